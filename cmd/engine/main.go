@@ -16,8 +16,14 @@ import (
 	"gorm.io/gorm/schema"
 
 	"github.com/zhongshangwu/avatarai-social/pkg/api"
+	"github.com/zhongshangwu/avatarai-social/pkg/atproto"
 	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/labeler"
+	"github.com/zhongshangwu/avatarai-social/pkg/pds/firehose"
+	"github.com/zhongshangwu/avatarai-social/pkg/pds/syncers"
 	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/search"
+	"github.com/zhongshangwu/avatarai-social/pkg/services"
 )
 
 var log = slog.Default().With("system", "avatarai-engine")
@@ -80,24 +86,72 @@ func runAvatarEngine(cctx *cli.Context) error {
 		return fmt.Errorf("初始化元数据存储失败: %w", err)
 	}
 
-	// // 创建同步器管理器
-	// syncerConfig := syncers.DefaultSyncerConfig()
-	// syncerManager := syncers.NewSyncerManager(metaStore, syncerConfig)
+	// 检索索引为空时在后台回填已有数据, 首次启用检索或更换数据库后不需要手动重建
+	if _, disabled := metaStore.Search.(search.DisabledIndex); !disabled {
+		count, err := metaStore.Search.Count(context.Background())
+		if err == nil && count == 0 {
+			go func() {
+				if _, err := services.NewSearchService(cfg, metaStore).Reindex(context.Background()); err != nil {
+					log.Error("回填检索索引失败", "err", err)
+				}
+			}()
+		}
+	}
 
 	// 创建 API 服务器
 	apiServer := api.NewAvatarAIAPI(cfg, metaStore)
 
+	// 启动 PDS 同步器管理器
+	var syncerManager *syncers.SyncerManager
+	if cfg.Syncer.Enabled {
+		syncerManager = syncers.NewSyncerManager(metaStore, cfg.Syncer)
+		if err := syncerManager.Start(); err != nil {
+			return fmt.Errorf("启动同步器管理器失败: %w", err)
+		}
+		apiServer.SetSyncerManager(syncerManager)
+	}
+
+	// 订阅其他 PDS 的 app.vtri.* 记录
+	var consumer *firehose.Consumer
+	if cfg.Firehose.Enabled {
+		consumer, err = firehose.NewConsumer(metaStore, cfg.Firehose)
+		if err != nil {
+			return fmt.Errorf("创建订阅失败: %w", err)
+		}
+		if err := consumer.Start(); err != nil {
+			return fmt.Errorf("启动订阅失败: %w", err)
+		}
+	}
+
+	// labeler 模式: 签发本服务的审核标签, 对应的公钥需要写入 labeler 的 DID 文档
+	if cfg.Labeler.Enabled {
+		l := labeler.Shared(cfg, metaStore)
+		if l == nil {
+			return fmt.Errorf("启动 labeler 失败, 请检查 labeler.did 和 labeler.signing_key")
+		}
+		publicKey, err := l.PublicKeyMultibase()
+		if err != nil {
+			return fmt.Errorf("读取 labeler 公钥失败: %w", err)
+		}
+		log.Info("labeler 已启用", "did", l.DID(), "atproto_label", publicKey)
+	}
+
+	// 订阅第三方 labeler 的标签
+	var labelSubscribers []*labeler.Subscriber
+	directory := atproto.DefaultDirectory()
+	for _, subscription := range cfg.Labeler.Subscriptions {
+		subscriber, err := labeler.NewSubscriber(metaStore, subscription, cfg.Labeler.ReconnectDelay, directory)
+		if err != nil {
+			return fmt.Errorf("创建 labeler 订阅失败: %w", err)
+		}
+		if err := subscriber.Start(); err != nil {
+			return fmt.Errorf("启动 labeler 订阅失败: %w", err)
+		}
+		labelSubscribers = append(labelSubscribers, subscriber)
+	}
+
 	// 启动服务
 	apiErr := make(chan error, 1)
-	// syncerErr := make(chan error, 1)
-
-	// 启动同步器管理器
-	// go func() {
-	// 	log.Info("启动同步器管理器")
-	// 	if err := syncerManager.Start(); err != nil {
-	// 		syncerErr <- fmt.Errorf("同步器管理器启动失败: %w", err)
-	// 	}
-	// }()
 
 	// 启动 API 服务器
 	go func() {
@@ -112,23 +166,16 @@ func runAvatarEngine(cctx *cli.Context) error {
 
 	log.Info("服务启动完成")
 
-	// // 等待信号或错误
+	// 等待信号或错误
 	select {
 	case <-signals:
 		log.Info("收到关闭信号")
-		// 	log.Info("收到关闭信号")
-		// 	shutdownServices(syncerManager)
-		// case err := <-syncerErr:
-		// 	if err != nil {
-		// 		log.Error("同步器管理器错误", "err", err)
-		// 	}
-		// 	shutdownServices(syncerManager)
-		// case err := <-apiErr:
-		// 	if err != nil {
-		// 		log.Error("API 服务器错误", "err", err)
-		// 	}
-		// 	shutdownServices(syncerManager)
+	case err := <-apiErr:
+		if err != nil {
+			log.Error("API 服务器错误", "err", err)
+		}
 	}
+	shutdownServices(syncerManager, consumer, labelSubscribers)
 
 	log.Info("关闭完成")
 	return nil
@@ -171,15 +218,23 @@ func setupDatabase(cfg config.DatabaseConfig) (*gorm.DB, error) {
 	return db, nil
 }
 
-// // 关闭服务
-// func shutdownServices(syncerManager *syncers.SyncerManager) {
-// 	log.Info("正在关闭服务...")
+// 关闭服务
+func shutdownServices(syncerManager *syncers.SyncerManager, consumer *firehose.Consumer, labelSubscribers []*labeler.Subscriber) {
+	log.Info("正在关闭服务...")
+
+	// 停止订阅并保存游标
+	if consumer != nil {
+		consumer.Stop()
+	}
+	for _, subscriber := range labelSubscribers {
+		subscriber.Stop()
+	}
 
-// 	// 停止同步器管理器
-// 	if err := syncerManager.Stop(); err != nil {
-// 		log.Error("关闭同步器管理器时出错", "err", err)
-// 	}
-// }
+	// 停止同步器管理器, 等待正在提交的批次结束
+	if syncerManager != nil {
+		syncerManager.Stop()
+	}
+}
 
 // mock 实现模型客户端接口
 type mockModelClient struct{}
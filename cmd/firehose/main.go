@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/urfave/cli/v2"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/pds/firehose"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+)
+
+var log = slog.Default().With("system", "avatarai-firehose")
+
+func main() {
+	if err := run(os.Args); err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	app := cli.App{
+		Name:  "avatarai-firehose",
+		Usage: "订阅 relay / Jetstream 并索引 app.vtri.* 记录",
+	}
+
+	app.Flags = []cli.Flag{
+		&cli.StringFlag{
+			Name:    "conf",
+			Usage:   "配置文件路径",
+			EnvVars: []string{"AVATARAI_CONFIG"},
+		},
+	}
+
+	app.Commands = []*cli.Command{
+		{
+			Name:  "run",
+			Usage: "持续订阅并索引",
+			Flags: []cli.Flag{
+				&cli.Int64Flag{
+					Name:  "cursor",
+					Usage: "从指定游标开始, 默认使用保存的游标",
+				},
+				&cli.StringFlag{
+					Name:  "record",
+					Usage: "将收到的帧录制到文件, 用于回放",
+				},
+			},
+			Action: runConsumer,
+		},
+		{
+			Name:  "replay",
+			Usage: "回放录制的帧文件",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "file",
+					Usage:    "录制文件路径",
+					Required: true,
+				},
+			},
+			Action: runReplay,
+		},
+	}
+	return app.Run(args)
+}
+
+func runConsumer(cctx *cli.Context) error {
+	cfg, metaStore, err := setup(cctx)
+	if err != nil {
+		return err
+	}
+
+	consumer, err := firehose.NewConsumer(metaStore, cfg.Firehose)
+	if err != nil {
+		return err
+	}
+	if cursor := cctx.Int64("cursor"); cursor > 0 {
+		consumer.SetCursor(cursor)
+	}
+	if path := cctx.String("record"); path != "" {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("打开录制文件失败: %w", err)
+		}
+		defer file.Close()
+		consumer.SetRecorder(file)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	if err := consumer.Start(); err != nil {
+		return err
+	}
+	<-signals
+	log.Info("收到关闭信号")
+	consumer.Stop()
+	return nil
+}
+
+func runReplay(cctx *cli.Context) error {
+	cfg, metaStore, err := setup(cctx)
+	if err != nil {
+		return err
+	}
+
+	consumer, err := firehose.NewConsumer(metaStore, cfg.Firehose)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(cctx.String("file"))
+	if err != nil {
+		return fmt.Errorf("打开录制文件失败: %w", err)
+	}
+	defer file.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	count, err := consumer.Replay(ctx, file)
+	if err != nil {
+		return fmt.Errorf("回放失败: %w", err)
+	}
+	log.Info("回放完成", "帧数", count)
+	return nil
+}
+
+func setup(cctx *cli.Context) (*config.SocialConfig, *repositories.MetaStore, error) {
+	cfg, err := config.LoadConfig(cctx.String("conf"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("加载配置失败: %w", err)
+	}
+
+	db, err := setupDatabase(cfg.Database)
+	if err != nil {
+		return nil, nil, fmt.Errorf("设置数据库失败: %w", err)
+	}
+
+	metaStore := repositories.NewMetaStore(db)
+	if err := metaStore.Init(); err != nil {
+		return nil, nil, fmt.Errorf("初始化元数据存储失败: %w", err)
+	}
+	return cfg, metaStore, nil
+}
+
+func setupDatabase(cfg config.DatabaseConfig) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+
+	switch cfg.Driver {
+	case "sqlite":
+		dialector = sqlite.Open(cfg.DSN)
+	case "mysql":
+		dialector = mysql.Open(cfg.DSN)
+	case "postgres":
+		dialector = postgres.Open(cfg.DSN)
+	default:
+		return nil, fmt.Errorf("不支持的数据库类型: %s", cfg.Driver)
+	}
+
+	return gorm.Open(dialector, &gorm.Config{
+		DisableForeignKeyConstraintWhenMigrating: true,
+		SkipDefaultTransaction:                   true,
+		NamingStrategy: schema.NamingStrategy{
+			SingularTable: true,
+		},
+	})
+}
@@ -7,8 +7,10 @@ import (
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/zhongshangwu/avatarai-social/pkg/api/handlers"
 	mw "github.com/zhongshangwu/avatarai-social/pkg/api/middleware"
+	"github.com/zhongshangwu/avatarai-social/pkg/atproto"
 	"github.com/zhongshangwu/avatarai-social/pkg/atproto/blobs"
 	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/pds/syncers"
 	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
 )
 
@@ -28,10 +30,17 @@ type AvatarAIAPI struct {
 	BlobsHandler          *handlers.BlobHandler
 	MessagesHandler       *handlers.MessageHandler
 	ChatHandler           *handlers.ChatHandler
+	RoomHandler           *handlers.RoomHandler
 	ActivityHandler       *handlers.ActivityHandler
+	GraphHandler          *handlers.GraphHandler
+	FeedGenHandler        *handlers.FeedGenHandler
+	LabelerHandler        *handlers.LabelerHandler
+	NotificationHandler   *handlers.NotificationHandler
+	SearchHandler         *handlers.SearchHandler
 	ImageViewer           *blobs.ImageViewer
 	MCPMarketplaceHandler *handlers.MCPMarketplaceHandler
 	MCPOAuthHandler       *handlers.MCPOAuthHandler
+	AdminHandler          *handlers.AdminHandler
 }
 
 func NewAvatarAIAPI(config *config.SocialConfig, metaStore *repositories.MetaStore) *AvatarAIAPI {
@@ -48,10 +57,17 @@ func NewAvatarAIAPI(config *config.SocialConfig, metaStore *repositories.MetaSto
 	blobHandler := handlers.NewBlobHandler(config, metaStore)
 	messageHandler := handlers.NewMessageHandler(config, metaStore)
 	chatHandler := handlers.NewChatHandler(config, metaStore)
+	roomHandler := handlers.NewRoomHandler(config, metaStore)
 	feedHandler := handlers.NewFeedHandler(config, metaStore)
 	activityHandler := handlers.NewActivityHandler(config, metaStore)
+	graphHandler := handlers.NewGraphHandler(config, metaStore)
+	feedGenHandler := handlers.NewFeedGenHandler(config, metaStore)
+	labelerHandler := handlers.NewLabelerHandler(config, metaStore)
+	notificationHandler := handlers.NewNotificationHandler(config, metaStore)
+	searchHandler := handlers.NewSearchHandler(config, metaStore)
 	mcpMarketplaceHandler := handlers.NewMCPMarketplaceHandler(config, metaStore)
 	mcpOAuthHandler := handlers.NewMCPOAuthHandler(config, metaStore)
+	adminHandler := handlers.NewAdminHandler(config, metaStore)
 
 	viewer, err := blobs.NewImageViewer(blobs.DefaultImageViewerConfig())
 	if err != nil {
@@ -71,14 +87,26 @@ func NewAvatarAIAPI(config *config.SocialConfig, metaStore *repositories.MetaSto
 		BlobsHandler:          blobHandler,
 		MessagesHandler:       messageHandler,
 		ChatHandler:           chatHandler,
+		RoomHandler:           roomHandler,
 		FeedHandler:           feedHandler,
 		ActivityHandler:       activityHandler,
+		GraphHandler:          graphHandler,
+		FeedGenHandler:        feedGenHandler,
+		LabelerHandler:        labelerHandler,
+		NotificationHandler:   notificationHandler,
+		SearchHandler:         searchHandler,
 		ImageViewer:           viewer,
 		MCPMarketplaceHandler: mcpMarketplaceHandler,
 		MCPOAuthHandler:       mcpOAuthHandler,
+		AdminHandler:          adminHandler,
 	}
 }
 
+// SetSyncerManager 管理接口通过它获取 PDS 同步器的运行状态
+func (a *AvatarAIAPI) SetSyncerManager(syncerManager *syncers.SyncerManager) {
+	a.AdminHandler.SetSyncerManager(syncerManager)
+}
+
 func (a *AvatarAIAPI) InstallRoutes() {
 	a.echo.GET("/healthz", a.HealthHandler.Healthz)
 
@@ -110,6 +138,8 @@ func (a *AvatarAIAPI) InstallRoutes() {
 
 	chat := api.Group("/chat")
 	chat.GET("/stream", withAuth(a.ChatHandler.ChatStream, true))
+	chat.POST("/messages", withAuth(a.ChatHandler.SendMessage, true))
+	chat.GET("/events", withAuth(a.ChatHandler.Events, true))
 
 	moment := api.Group("/moments")
 	moment.POST("", withAuth(a.MomentsHandler.CreateMoment, true))
@@ -125,8 +155,25 @@ func (a *AvatarAIAPI) InstallRoutes() {
 	blob.POST("", withAuth(a.BlobsHandler.UploadFile, true))
 	blob.GET("", withAuth(a.BlobsHandler.GetFile, true))
 
+	rooms := api.Group("/rooms")
+	rooms.GET("", withAuth(a.RoomHandler.ListRooms, true))
+	rooms.POST("", withAuth(a.RoomHandler.CreateRoom, true))
+	rooms.GET("/detail", withAuth(a.RoomHandler.GetRoom, true))
+	rooms.POST("/rename", withAuth(a.RoomHandler.RenameRoom, true))
+	rooms.POST("/archive", withAuth(a.RoomHandler.ArchiveRoom, true))
+	rooms.POST("/invite", withAuth(a.RoomHandler.Invite, true))
+	rooms.POST("/accept", withAuth(a.RoomHandler.Accept, true))
+	rooms.POST("/leave", withAuth(a.RoomHandler.Leave, true))
+	rooms.POST("/read", withAuth(a.RoomHandler.MarkRead, true))
+	rooms.POST("/mirror", withAuth(a.RoomHandler.SetMirror, true))
+	rooms.POST("/import", withAuth(a.RoomHandler.ImportFromPDS, true))
+	rooms.GET("/threads", withAuth(a.RoomHandler.ListThreads, true))
+	rooms.POST("/threads", withAuth(a.RoomHandler.CreateThread, true))
+	rooms.POST("/threads/fork", withAuth(a.RoomHandler.ForkThread, true))
+
 	messages := api.Group("/messages")
 	messages.GET("/history", withAuth(a.MessagesHandler.HistoryMessages, true))
+	messages.GET("/search", withAuth(a.MessagesHandler.SearchHistory, true))
 
 	activity := api.Group("/activity")
 	activity.GET("/tags", withAuth(a.ActivityHandler.ListTags, false))
@@ -135,6 +182,41 @@ func (a *AvatarAIAPI) InstallRoutes() {
 	activity.GET("/topics", withAuth(a.ActivityHandler.ListTopics, false))
 	activity.POST("/topics", withAuth(a.ActivityHandler.CreateTopic, true))
 
+	graph := api.Group("/graph")
+	graph.POST("/follow", withAuth(a.GraphHandler.Follow, true))
+	graph.DELETE("/follow", withAuth(a.GraphHandler.Unfollow, true))
+	graph.POST("/block", withAuth(a.GraphHandler.Block, true))
+	graph.DELETE("/block", withAuth(a.GraphHandler.Unblock, true))
+	graph.GET("/blocks", withAuth(a.GraphHandler.Blocks, true))
+	graph.POST("/mute", withAuth(a.GraphHandler.Mute, true))
+	graph.DELETE("/mute", withAuth(a.GraphHandler.Unmute, true))
+	graph.GET("/mutes", withAuth(a.GraphHandler.Mutes, true))
+	graph.GET("/followers", withAuth(a.GraphHandler.Followers, false))
+	graph.GET("/following", withAuth(a.GraphHandler.Following, false))
+
+	notifications := api.Group("/notifications")
+	notifications.GET("", withAuth(a.NotificationHandler.ListNotifications, true))
+	notifications.POST("/read", withAuth(a.NotificationHandler.MarkRead, true))
+	notifications.GET("/unread-count", withAuth(a.NotificationHandler.UnreadCount, true))
+
+	api.GET("/search", withAuth(a.SearchHandler.Search, false))
+	api.GET("/search/:type", withAuth(a.SearchHandler.SearchType, false))
+
+	if a.FeedGenHandler.ServesDIDDocument() {
+		a.echo.GET("/.well-known/did.json", a.FeedGenHandler.DidDocument)
+	}
+	if a.Config.FeedGen.Enabled {
+		validator := atproto.NewServiceAuthValidator(a.Config.FeedGen.ServiceDID(), atproto.DefaultDirectory())
+		xrpc := a.echo.Group("/xrpc", mw.NewServiceAuthMiddleware(validator))
+		xrpc.GET("/app.bsky.feed.describeFeedGenerator", a.FeedGenHandler.DescribeFeedGenerator)
+		xrpc.GET("/app.bsky.feed.getFeedSkeleton", a.FeedGenHandler.GetFeedSkeleton)
+	}
+	// labeler 接口公开访问, 不经过服务间认证
+	if a.LabelerHandler.Enabled() {
+		a.echo.GET("/xrpc/com.atproto.label.queryLabels", a.LabelerHandler.QueryLabels)
+		a.echo.GET("/xrpc/com.atproto.label.subscribeLabels", a.LabelerHandler.SubscribeLabels)
+	}
+
 	img := a.echo.Group("/img")
 	img.Use(echo.WrapMiddleware(a.ImageViewer.CreateMiddleware("/img/")))
 
@@ -148,6 +230,13 @@ func (a *AvatarAIAPI) InstallRoutes() {
 	mcpOAuth := mcp.Group("/oauth")
 	mcpOAuth.GET("/authorize", withAuth(a.MCPOAuthHandler.Authorize, true))
 	mcpOAuth.GET("/callback", withAuth(a.MCPOAuthHandler.OAuthCallback, false))
+
+	admin := api.Group("/admin", mw.NewAdminKeyMiddleware(a.Config))
+	admin.GET("/syncers", a.AdminHandler.SyncerStats)
+	admin.POST("/syncers/trigger", a.AdminHandler.TriggerSyncer)
+	admin.POST("/search/reindex", a.AdminHandler.ReindexSearch)
+	admin.GET("/moderation/queue", a.AdminHandler.ModerationQueue)
+	admin.POST("/moderation/:id/review", a.AdminHandler.ReviewModeration)
 }
 
 func (a *AvatarAIAPI) InstallMiddleware() {
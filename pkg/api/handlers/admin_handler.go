@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/pds/syncers"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/search"
+	"github.com/zhongshangwu/avatarai-social/pkg/services"
+)
+
+type AdminHandler struct {
+	config            *config.SocialConfig
+	metaStore         *repositories.MetaStore
+	syncerManager     *syncers.SyncerManager
+	searchService     *services.SearchService
+	moderationService *services.ModerationService
+}
+
+func NewAdminHandler(config *config.SocialConfig, metaStore *repositories.MetaStore) *AdminHandler {
+	return &AdminHandler{
+		config:            config,
+		metaStore:         metaStore,
+		searchService:     services.NewSearchService(config, metaStore),
+		moderationService: services.NewModerationService(config, metaStore),
+	}
+}
+
+func (h *AdminHandler) SetSyncerManager(syncerManager *syncers.SyncerManager) {
+	h.syncerManager = syncerManager
+}
+
+// SyncerStats PDS 同步队列按集合的统计, 同步器未启动时只返回队列情况
+func (h *AdminHandler) SyncerStats(c echo.Context) error {
+	if h.syncerManager != nil {
+		status, err := h.syncerManager.GetStatus()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		return c.JSON(http.StatusOK, status)
+	}
+
+	queue, err := h.metaStore.OutboxRepo.CountOutboxOpsByCollection()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "统计同步队列失败: " + err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"running": false,
+		"queue":   queue,
+	})
+}
+
+// TriggerSyncer 立即处理一次同步队列
+func (h *AdminHandler) TriggerSyncer(c echo.Context) error {
+	if h.syncerManager == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "同步器未启用"})
+	}
+	h.syncerManager.Trigger()
+	return c.JSON(http.StatusAccepted, map[string]string{"status": "triggered"})
+}
+
+// ReindexSearch 在后台从数据库重建全文检索索引
+func (h *AdminHandler) ReindexSearch(c echo.Context) error {
+	if _, ok := h.metaStore.Search.(search.DisabledIndex); ok {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": search.ErrUnavailable.Error()})
+	}
+	logger := c.Logger()
+	go func() {
+		if _, err := h.searchService.Reindex(context.Background()); err != nil {
+			logger.Errorf("重建检索索引失败: %v", err)
+		}
+	}()
+	return c.JSON(http.StatusAccepted, map[string]string{"status": "reindexing", "index": h.metaStore.Search.Name()})
+}
+
+// ModerationQueue 审核队列, 默认返回等待人工审核的记录, 可通过 status 查看已处理的记录
+func (h *AdminHandler) ModerationQueue(c echo.Context) error {
+	limit := 50
+	if l, err := strconv.Atoi(c.QueryParam("limit")); err == nil && l > 0 && l <= 100 {
+		limit = l
+	}
+	queue, err := h.moderationService.Queue(c.Request().Context(), c.QueryParam("status"), limit, c.QueryParam("cursor"))
+	if errors.Is(err, services.ErrUnknownReviewState) || errors.Is(err, services.ErrInvalidCursor) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "获取审核队列失败: " + err.Error()})
+	}
+	return c.JSON(http.StatusOK, queue)
+}
+
+type ReviewModerationRequest struct {
+	Action   string `json:"action"` // approve, reject
+	Reviewer string `json:"reviewer"`
+	Note     string `json:"note,omitempty"`
+}
+
+// ReviewModeration 处理一条等待审核的记录
+func (h *AdminHandler) ReviewModeration(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "无效的审核记录 ID"})
+	}
+	var req ReviewModerationRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "请求格式错误: " + err.Error()})
+	}
+	if req.Action != "approve" && req.Action != "reject" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "action 只能是 approve 或 reject"})
+	}
+
+	item, err := h.moderationService.Review(c.Request().Context(), uint(id), req.Action == "approve", req.Reviewer, req.Note)
+	if errors.Is(err, services.ErrReviewNotPending) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "处理审核记录失败: " + err.Error()})
+	}
+	return c.JSON(http.StatusOK, item)
+}
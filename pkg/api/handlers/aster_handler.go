@@ -16,6 +16,7 @@ import (
 	"github.com/zhongshangwu/avatarai-social/pkg/config"
 	"github.com/zhongshangwu/avatarai-social/pkg/mint"
 	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/services"
 	"github.com/zhongshangwu/avatarai-social/pkg/utils"
 	"github.com/zhongshangwu/avatarai-social/types"
 )
@@ -212,6 +213,7 @@ func (h *AsterHandler) HandleAsterUpdateProfile(c *types.APIContext) error {
 				Message: "PDS 个人资料已更新，但本地数据库更新失败: " + err.Error(),
 			})
 		}
+		services.IndexUser(h.metaStore, aster.Did)
 	}
 
 	return c.JSON(http.StatusOK, UpdateAsterProfileResponse{
@@ -308,6 +310,7 @@ func (h *AsterHandler) HandleAsterMint(c *types.APIContext) error {
 	if err := h.metaStore.UserRepo.CreateAster(aster); err != nil {
 		return c.InternalServerError("保存Aster失败: " + err.Error())
 	}
+	services.IndexUser(h.metaStore, aster.Did)
 
 	avatarURL := ""
 	if aster.AvatarCID != "" {
@@ -4,17 +4,20 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
 	"github.com/sirupsen/logrus"
 	"github.com/zhongshangwu/avatarai-social/pkg/communication/chat"
-	"github.com/zhongshangwu/avatarai-social/pkg/communication/events"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/memory"
 	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
 	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/providers/llm"
 	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
-	"github.com/zhongshangwu/avatarai-social/pkg/streams"
 	"github.com/zhongshangwu/avatarai-social/types"
 )
 
@@ -28,15 +31,26 @@ var (
 )
 
 type ChatHandler struct {
-	config    *config.SocialConfig
-	metaStore *repositories.MetaStore
+	config          *config.SocialConfig
+	metaStore       *repositories.MetaStore
+	semanticIndexes *memory.SemanticIndexRegistry
+	summarizer      *memory.ThreadSummarizer
+	historySearcher *memory.HistorySearcher
+	sessions        *chatSessionRegistry
 }
 
 func NewChatHandler(config *config.SocialConfig, metaStore *repositories.MetaStore) *ChatHandler {
-	return &ChatHandler{
+	handler := &ChatHandler{
 		config:    config,
 		metaStore: metaStore,
+		sessions:  newChatSessionRegistry(),
 	}
+
+	handler.semanticIndexes = memory.SharedSemanticIndexRegistry(config, metaStore)
+	handler.historySearcher = memory.NewHistorySearcher(metaStore, handler.semanticIndexes)
+	// 上下文超出模型窗口时总是需要话题摘要, summary.enabled 只控制每次回复后的滚动摘要
+	handler.summarizer = memory.NewThreadSummarizer(metaStore, llm.NewModelManager(config), config.Avatar.Memory.Summary)
+	return handler
 }
 
 func (h *ChatHandler) ChatStream(c *types.APIContext) error {
@@ -51,57 +65,20 @@ func (h *ChatHandler) ChatStream(c *types.APIContext) error {
 	connCtx, connCancel := context.WithCancel(c.Request().Context())
 	defer connCancel()
 
-	tracer := events.NewLoggingTracer[*messages.ChatEvent](func(format string, args ...interface{}) {
-		logrus.Infof("[ChatEventTracer] "+format, args...)
-	})
-
-	eventBus := events.NewEventBus[*messages.ChatEvent](
-		events.BusWithBufferSize[*messages.ChatEvent](100),
-		events.BusWithWorkerCount[*messages.ChatEvent](1),
-		events.BusWithErrorHandler[*messages.ChatEvent](func(err error) {
-			logrus.Errorf("ChatStream event bus error: %v", err)
-		}),
-		events.BusWithTracer[*messages.ChatEvent](tracer),
-	)
-
-	if err := eventBus.Start(connCtx); err != nil {
-		logrus.Errorf("Failed to start event bus: %v", err)
-		return err
-	}
-	defer func() {
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		eventBus.Stop(shutdownCtx)
-	}()
-
-	outbox := streams.NewStream[*messages.ChatEvent](connCtx, 100)
-	defer outbox.CloseSend()
-
-	chatActor := chat.NewChatActor("chat",
-		h.metaStore,
-		h.config,
-		events.ActorWithCustomOutbox[*messages.ChatEvent](outbox),
-	)
-
-	if err := chatActor.Start(connCtx); err != nil {
-		logrus.Errorf("Failed to start chat actor: %v", err)
-		return err
-	}
-	defer chatActor.Stop()
-
-	if _, err := eventBus.Subscribe(string(messages.EventTypeMessageSend), chatActor.Send); err != nil {
-		logrus.Errorf("ChatStream subscribe event error: %v", err)
-		h.sendErrorEvent(conn, "subscribe_event_error", "订阅事件失败")
-		return err
-	}
-	if _, err := eventBus.Subscribe(string(messages.EventTypeAgentMessageInterrupt), chatActor.Send); err != nil {
-		logrus.Errorf("ChatStream subscribe event error: %v", err)
-		h.sendErrorEvent(conn, "subscribe_event_error", "订阅事件失败")
+	transport := newWebsocketTransport(conn)
+	session, err := h.newChatSession(connCtx, c.User.Did)
+	if err != nil {
+		logrus.Errorf("创建聊天会话失败: %v", err)
+		transport.SendError("session_error", "创建会话失败")
 		return err
 	}
+	defer session.Close()
 
 	go func() {
-		h.handleChatStreamResponse(connCtx, outbox, conn)
+		defer connCancel()
+		if err := session.Serve(connCtx, transport, 0); err != nil {
+			logrus.Errorf("发送响应到客户端失败: %v", err)
+		}
 	}()
 
 	for {
@@ -123,11 +100,17 @@ func (h *ChatHandler) ChatStream(c *types.APIContext) error {
 				return err
 			}
 
-			if msgType == websocket.TextMessage {
-				if err := h.handleWebSocketMessage(connCtx, eventBus, conn, msg); err != nil {
+			switch msgType {
+			case websocket.TextMessage:
+				if err := h.handleWebSocketMessage(connCtx, session, transport, msg); err != nil {
 					logrus.Errorf("处理 WebSocket 消息失败: %v", err)
 					continue
 				}
+			case websocket.BinaryMessage:
+				if err := h.handleAudioFrame(connCtx, session, msg); err != nil {
+					logrus.Errorf("处理音频帧失败: %v", err)
+					continue
+				}
 			}
 		}
 	}
@@ -135,8 +118,8 @@ func (h *ChatHandler) ChatStream(c *types.APIContext) error {
 
 func (h *ChatHandler) handleWebSocketMessage(
 	ctx context.Context,
-	eventBus events.EventBus[*messages.ChatEvent],
-	conn *websocket.Conn,
+	session *chatSession,
+	transport *websocketTransport,
 	msg []byte,
 ) error {
 	logrus.Infof("ChatStream msgType: TextMessage, msg: %s", string(msg))
@@ -144,19 +127,14 @@ func (h *ChatHandler) handleWebSocketMessage(
 	var event messages.ChatEvent
 	if err := json.Unmarshal(msg, &event); err != nil {
 		logrus.Errorf("ChatStream event unmarshal error: %v", err)
-		h.sendErrorEvent(conn, "invalid_event_format", "无效的事件格式")
+		transport.SendError("invalid_event_format", "无效的事件格式")
 		return err
 	}
 
-	eventCtx := context.WithValue(ctx, "eventID", event.EventID)
-	eventCtx = context.WithValue(eventCtx, "eventType", event.EventType)
-	eventCtx, cancel := context.WithTimeout(eventCtx, 30*time.Second)
-	defer cancel()
-
 	logrus.Infof("ChatStream event: %+v", event)
 	logrus.Infof("开始发布事件到 EventBus: %s", event.EventID)
 
-	if err := eventBus.Publish(eventCtx, &event); err != nil {
+	if err := session.Dispatch(ctx, &event); err != nil {
 		logrus.Errorf("ChatStream publish event error: %v", err)
 		return err
 	}
@@ -165,81 +143,157 @@ func (h *ChatHandler) handleWebSocketMessage(
 	return nil
 }
 
-func (h *ChatHandler) handleChatStreamResponse(ctx context.Context, outbox *streams.Stream[*messages.ChatEvent], conn *websocket.Conn) {
-	logrus.Info("启动 handleChatStreamResponse 处理器")
-	defer logrus.Info("handleChatStreamResponse 处理器退出")
-
-	for {
-		result := outbox.Recv()
-
-		if result.HasData {
-			serverEvent := result.Data
+// handleAudioFrame 二进制帧作为实时语音会话的输入音频, 等同于 realtime.input_audio.append 事件
+func (h *ChatHandler) handleAudioFrame(ctx context.Context, session *chatSession, frame []byte) error {
+	event := &messages.ChatEvent{
+		EventID:   uuid.New().String(),
+		EventType: messages.EventTypeRealtimeInputAudioAppend,
+		Event:     &messages.InputAudioAppendEvent{Audio: frame},
+	}
+	return session.Dispatch(ctx, event)
+}
 
-			if serverEvent == nil {
-				logrus.Info("ChatStream recv response finished - serverEvent is nil")
-				continue
-			}
+// SendMessage 通过 HTTP 发送消息, 与 WebSocket 的 message.send 事件走同一个 ChatActor.
+// 未指定会话时创建新会话, 之后通过 GET /api/chat/events 接收回复事件
+func (h *ChatHandler) SendMessage(c *types.APIContext) error {
+	var sendMsgEvent messages.SendMsgEvent
+	if err := json.NewDecoder(c.Request().Body).Decode(&sendMsgEvent); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "无效的消息格式")
+	}
+	if sendMsgEvent.SenderID == "" {
+		sendMsgEvent.SenderID = c.User.Did
+	}
 
-			logrus.Infof("收到响应事件: ID=%s, 类型=%s", serverEvent.EventID, serverEvent.EventType)
+	session, err := h.httpSession(c.QueryParam("sessionId"), c.User.Did, false)
+	if err != nil {
+		return err
+	}
 
-			if err := h.sendResponseToClient(ctx, conn, serverEvent); err != nil {
-				logrus.Errorf("发送响应到客户端失败: %v", err)
-				return
-			}
+	event := &messages.ChatEvent{
+		EventID:   uuid.New().String(),
+		EventType: messages.EventTypeMessageSend,
+		Event:     &sendMsgEvent,
+	}
+	results, cancel := session.actor.AwaitSendResult(event.EventID)
+	defer cancel()
+	if err := session.Dispatch(c.Request().Context(), event); err != nil {
+		logrus.Errorf("发布消息事件失败: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "发送消息失败")
+	}
 
-			continue
-		}
+	var result *chat.SendResult
+	select {
+	case result = <-results:
+	case <-time.After(chatDispatchTimeout):
+		return echo.NewHTTPError(http.StatusGatewayTimeout, "发送消息超时")
+	case <-c.Request().Context().Done():
+		return c.Request().Context().Err()
+	}
+	if result.ErrorCode != "" {
+		return echo.NewHTTPError(sendErrorStatus(result.ErrorCode), result.Error)
+	}
 
-		if result.Completed {
-			if result.Error != nil {
-				logrus.Errorf("ChatStream recv response error: %v", result.Error)
-				return
-			}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"sessionId":  session.id,
+		"eventId":    event.EventID,
+		"messageId":  result.MessageID,
+		"responseId": result.ResponseID,
+		"held":       result.Held,
+	})
+}
 
-			logrus.Info("ChatStream recv response finished - stream closed")
-			return
-		}
+// Events 接收会话的输出事件. Accept 为 text/event-stream 时使用 SSE, 否则为长轮询.
+// 通过 Last-Event-ID 请求头或 lastEventId 参数从断开的位置继续, 会话已过期时创建新会话
+func (h *ChatHandler) Events(c *types.APIContext) error {
+	sessionID := c.QueryParam("sessionId")
+	lastEventID := c.Request().Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.QueryParam("lastEventId")
+	}
+	var after uint64
+	if lastSessionID, seq, ok := parseEventID(lastEventID); ok && (sessionID == "" || sessionID == lastSessionID) {
+		sessionID, after = lastSessionID, seq
 	}
-}
 
-func (h *ChatHandler) sendResponseToClient(ctx context.Context, conn *websocket.Conn, event *messages.ChatEvent) error {
-	data, err := json.Marshal(event)
+	session, err := h.httpSession(sessionID, c.User.Did, true)
 	if err != nil {
-		logrus.Errorf("ChatStream response marshal error: %v", err)
 		return err
 	}
+	if session.id != sessionID {
+		after = 0
+	}
 
-	logrus.Infof("发送响应到客户端: %s", string(data))
+	if strings.Contains(c.Request().Header.Get(echo.HeaderAccept), "text/event-stream") {
+		transport := newSSETransport(c.Response())
+		if err := session.Serve(c.Request().Context(), transport, after); err != nil {
+			logrus.Infof("SSE 连接断开: %v", err)
+		}
+		return nil
+	}
 
-	// conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-	err = conn.WriteMessage(websocket.TextMessage, data)
-	if err != nil {
-		if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-			logrus.Info("客户端连接已关闭，停止发送响应")
-		} else {
-			logrus.Errorf("ChatStream write response error: %v", err)
+	wait := chatLongPollWait
+	if waitParam := c.QueryParam("wait"); waitParam != "" {
+		seconds, err := strconv.Atoi(waitParam)
+		if err != nil || seconds < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "无效的 wait 参数")
 		}
-		return err
+		wait = min(time.Duration(seconds)*time.Second, chatLongPollWait)
 	}
 
-	logrus.Info("响应已发送到客户端")
-	return nil
+	pending, lost := session.Poll(c.Request().Context(), after, wait)
+	data := make([]map[string]interface{}, 0, len(pending)+1)
+	lastID := session.eventID(after)
+	if lost {
+		data = append(data, map[string]interface{}{"id": lastID, "event": eventsLostEvent()})
+	}
+	for _, event := range pending {
+		lastID = session.eventID(event.Seq)
+		data = append(data, map[string]interface{}{"id": lastID, "event": event.Event})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"sessionId":   session.id,
+		"events":      data,
+		"lastEventId": lastID,
+	})
 }
 
-func (h *ChatHandler) sendErrorEvent(conn *websocket.Conn, errorCode string, errorMsg string) error {
-	errorEvent := messages.ChatEvent{
-		EventID:   uuid.New().String(),
-		EventType: messages.EventTypeError,
-		Event: &messages.ErrorEvent{
-			Code:    &errorCode,
-			Message: errorMsg,
-		},
+// httpSession 查找 SSE 和长轮询会话. 会话不存在时, create 为 true 则创建新会话, 否则返回 404
+func (h *ChatHandler) httpSession(sessionID string, did string, create bool) (*chatSession, error) {
+	if sessionID != "" {
+		if session := h.sessions.Get(sessionID, did); session != nil {
+			return session, nil
+		}
+		if !create {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "会话不存在或已过期")
+		}
 	}
 
-	data, err := json.Marshal(errorEvent)
+	session, err := h.newChatSession(context.Background(), did)
 	if err != nil {
-		return err
+		logrus.Errorf("创建聊天会话失败: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "创建会话失败")
 	}
+	session.append(&messages.ChatEvent{
+		EventID:   uuid.New().String(),
+		EventType: messages.EventTypeSessionCreated,
+		Event:     &messages.SessionCreatedEvent{SessionID: session.id},
+	})
+	h.sessions.Add(session)
+	return session, nil
+}
 
-	return conn.WriteMessage(websocket.TextMessage, data)
+// sendErrorStatus 消息发送失败时的 HTTP 状态码
+func sendErrorStatus(code string) int {
+	switch code {
+	case "room_not_found", "thread_not_found":
+		return http.StatusNotFound
+	case "sender_mismatch", "not_room_member":
+		return http.StatusForbidden
+	case "room_archived":
+		return http.StatusConflict
+	case "content_rejected":
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
 }
@@ -0,0 +1,425 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/chat"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/events"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/fanout"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/services"
+	"github.com/zhongshangwu/avatarai-social/pkg/streams"
+)
+
+const (
+	chatSessionBacklog     = 512             // 每个会话保留的最近事件数, 断线重连时从中补发
+	chatSessionIdleTimeout = 2 * time.Minute // SSE 和长轮询会话没有连接后保留的时间
+	chatDispatchTimeout    = 30 * time.Second
+	chatLongPollWait       = 25 * time.Second // 长轮询最长等待时间, 低于常见代理的空闲超时
+)
+
+// chatSessionEvent 会话内按顺序编号的输出事件
+type chatSessionEvent struct {
+	Seq   uint64
+	Event *messages.ChatEvent
+}
+
+// chatSession 一个聊天会话: 独立的 EventBus 和 ChatActor, 以及同一用户其他设备、房间成员和通知的事件.
+// 输出事件按顺序编号并保留最近一段, 传输层断开后可以从指定编号之后继续读取
+type chatSession struct {
+	id     string
+	did    string
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	eventBus     events.EventBus[*messages.ChatEvent]
+	outbox       *streams.Stream[*messages.ChatEvent]
+	actor        *chat.ChatActor
+	fanoutSub    *fanout.Subscription
+	subscription *services.NotificationSubscription
+
+	mu        sync.Mutex
+	seq       uint64
+	backlog   []chatSessionEvent
+	wake      chan struct{} // 有新事件时关闭并替换
+	attached  int
+	idleTimer *time.Timer
+	onClose   func(*chatSession)
+	closeOnce sync.Once
+}
+
+// newChatSession 创建会话并启动 ChatActor, ctx 结束时会话随之关闭
+func (h *ChatHandler) newChatSession(ctx context.Context, did string) (*chatSession, error) {
+	sessionCtx, cancel := context.WithCancel(ctx)
+	session := &chatSession{
+		id:     uuid.New().String(),
+		did:    did,
+		ctx:    sessionCtx,
+		cancel: cancel,
+		wake:   make(chan struct{}),
+	}
+
+	tracer := events.NewLoggingTracer[*messages.ChatEvent](func(format string, args ...interface{}) {
+		logrus.Infof("[ChatEventTracer] "+format, args...)
+	})
+	session.eventBus = events.NewEventBus[*messages.ChatEvent](
+		events.BusWithBufferSize[*messages.ChatEvent](100),
+		events.BusWithWorkerCount[*messages.ChatEvent](1),
+		events.BusWithErrorHandler[*messages.ChatEvent](func(err error) {
+			logrus.Errorf("ChatStream event bus error: %v", err)
+		}),
+		events.BusWithTracer[*messages.ChatEvent](tracer),
+	)
+	if err := session.eventBus.Start(sessionCtx); err != nil {
+		cancel()
+		return nil, fmt.Errorf("启动 EventBus 失败: %w", err)
+	}
+
+	session.outbox = streams.NewStream[*messages.ChatEvent](sessionCtx, 100)
+	session.actor = chat.NewChatActor("chat",
+		h.metaStore,
+		h.config,
+		events.ActorWithCustomOutbox[*messages.ChatEvent](session.outbox),
+	)
+	if err := session.actor.Start(sessionCtx); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("启动 ChatActor 失败: %w", err)
+	}
+
+	session.actor.RestrictSender(did)
+	if err := session.actor.LoadMCPTools(sessionCtx, did); err != nil {
+		logrus.Errorf("Failed to load mcp tools: %v", err)
+	}
+	if h.semanticIndexes != nil {
+		session.actor.EnableSemanticMemory(h.semanticIndexes, did)
+	}
+	session.actor.EnableHistorySearch(h.historySearcher, did)
+	session.actor.EnableThreadSummary(h.summarizer)
+
+	// 同一用户的其他设备和房间内其他成员发送的消息、AI 回复经由 fanout hub 写入输出流
+	session.fanoutSub = fanout.Default().Subscribe(did)
+	if roomIDs, err := h.metaStore.MessageRepo.ListRoomIDsByMember(did); err != nil {
+		logrus.Errorf("获取用户房间失败, 只接收之后新加入房间的事件: %v", err)
+	} else {
+		for _, roomID := range roomIDs {
+			session.fanoutSub.JoinRoom(roomID)
+		}
+	}
+	session.actor.EnableFanout(session.fanoutSub)
+	go session.forwardFanout()
+
+	for _, eventType := range []messages.ChatEventType{
+		messages.EventTypeMessageSend,
+		messages.EventTypeAgentMessageInterrupt,
+		messages.EventTypeAgentMessageResume,
+		messages.EventTypeRealtimeSessionStart,
+		messages.EventTypeRealtimeSessionStop,
+		messages.EventTypeRealtimeInputAudioAppend,
+		messages.EventTypeRealtimeInputAudioCommit,
+	} {
+		if _, err := session.eventBus.Subscribe(string(eventType), session.actor.Send); err != nil {
+			session.Close()
+			return nil, fmt.Errorf("订阅事件失败: %w", err)
+		}
+	}
+
+	go session.collect()
+
+	// 新通知作为 notification.created 事件写入同一个输出流
+	session.subscription = services.DefaultNotificationHub().Subscribe(did)
+	go session.forwardNotifications()
+
+	go func() {
+		<-sessionCtx.Done()
+		session.Close()
+	}()
+	return session, nil
+}
+
+// Dispatch 将客户端事件发布到会话的 EventBus
+func (s *chatSession) Dispatch(ctx context.Context, event *messages.ChatEvent) error {
+	eventCtx := context.WithValue(ctx, "eventID", event.EventID)
+	eventCtx = context.WithValue(eventCtx, "eventType", event.EventType)
+	eventCtx, cancel := context.WithTimeout(eventCtx, chatDispatchTimeout)
+	defer cancel()
+
+	if err := s.eventBus.Publish(eventCtx, event); err != nil {
+		return fmt.Errorf("发布事件失败: %w", err)
+	}
+	return nil
+}
+
+// Serve 将编号大于 after 的事件依次交给传输层, 直到 ctx 结束、会话关闭或写出失败
+func (s *chatSession) Serve(ctx context.Context, transport ChatTransport, after uint64) error {
+	s.attach()
+	defer s.detach()
+
+	heartbeat := time.NewTicker(chatHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		pending, wake, lost := s.eventsAfter(after)
+		if lost {
+			if err := transport.Send(s.eventID(after), eventsLostEvent()); err != nil {
+				return err
+			}
+		}
+		for _, event := range pending {
+			if err := transport.Send(s.eventID(event.Seq), event.Event); err != nil {
+				return err
+			}
+			after = event.Seq
+		}
+
+		select {
+		case <-wake:
+		case <-heartbeat.C:
+			if err := transport.Ping(); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		case <-s.ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Poll 长轮询: 有新事件时立即返回, 否则最多等待 wait
+func (s *chatSession) Poll(ctx context.Context, after uint64, wait time.Duration) ([]chatSessionEvent, bool) {
+	s.attach()
+	defer s.detach()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		pending, wake, lost := s.eventsAfter(after)
+		if len(pending) > 0 || lost {
+			return pending, lost
+		}
+		select {
+		case <-wake:
+		case <-timer.C:
+			return nil, false
+		case <-ctx.Done():
+			return nil, false
+		case <-s.ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// Close 停止 ChatActor 并释放订阅, 可重复调用
+func (s *chatSession) Close() {
+	s.closeOnce.Do(func() {
+		if s.subscription != nil {
+			s.subscription.Close()
+		}
+		if s.fanoutSub != nil {
+			s.fanoutSub.Close()
+		}
+		if s.actor != nil {
+			s.actor.Stop()
+		}
+		if s.outbox != nil {
+			s.outbox.CloseSend()
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.eventBus.Stop(shutdownCtx)
+		s.cancel()
+
+		s.mu.Lock()
+		if s.idleTimer != nil {
+			s.idleTimer.Stop()
+		}
+		onClose := s.onClose
+		s.mu.Unlock()
+		if onClose != nil {
+			onClose(s)
+		}
+		logrus.Infof("聊天会话 %s 已关闭", s.id)
+	})
+}
+
+// expireWhenIdle 没有传输层连接超过 chatSessionIdleTimeout 后关闭会话, 用于 SSE 和长轮询
+func (s *chatSession) expireWhenIdle(onClose func(*chatSession)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onClose = onClose
+	if s.attached == 0 {
+		s.idleTimer = time.AfterFunc(chatSessionIdleTimeout, s.closeIfIdle)
+	}
+}
+
+func (s *chatSession) attach() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attached++
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+		s.idleTimer = nil
+	}
+}
+
+func (s *chatSession) detach() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attached--
+	if s.attached == 0 && s.onClose != nil {
+		s.idleTimer = time.AfterFunc(chatSessionIdleTimeout, s.closeIfIdle)
+	}
+}
+
+func (s *chatSession) closeIfIdle() {
+	s.mu.Lock()
+	idle := s.attached == 0
+	s.mu.Unlock()
+	if idle {
+		logrus.Infof("聊天会话 %s 空闲超时", s.id)
+		s.Close()
+	}
+}
+
+// append 为事件编号并写入 backlog, 唤醒等待中的传输层
+func (s *chatSession) append(event *messages.ChatEvent) {
+	s.mu.Lock()
+	s.seq++
+	s.backlog = append(s.backlog, chatSessionEvent{Seq: s.seq, Event: event})
+	if len(s.backlog) > chatSessionBacklog {
+		s.backlog = append(s.backlog[:0], s.backlog[len(s.backlog)-chatSessionBacklog:]...)
+	}
+	close(s.wake)
+	s.wake = make(chan struct{})
+	s.mu.Unlock()
+}
+
+// eventsAfter 返回编号大于 after 的事件和新事件到达时关闭的通道. 要求的事件已经移出 backlog 时 lost 为 true
+func (s *chatSession) eventsAfter(after uint64) (pending []chatSessionEvent, wake <-chan struct{}, lost bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.backlog) > 0 && s.backlog[0].Seq > after+1 {
+		lost = true
+	}
+	for i, event := range s.backlog {
+		if event.Seq > after {
+			pending = append(pending, s.backlog[i:]...)
+			break
+		}
+	}
+	return pending, s.wake, lost
+}
+
+// collect 从 ChatActor 的输出流读取事件写入 backlog
+func (s *chatSession) collect() {
+	for {
+		result := s.outbox.Recv()
+		if result.HasData {
+			if result.Data != nil {
+				s.append(result.Data)
+			}
+			continue
+		}
+		if result.Completed {
+			if result.Error != nil {
+				logrus.Errorf("ChatStream recv response error: %v", result.Error)
+			}
+			return
+		}
+	}
+}
+
+func (s *chatSession) forwardNotifications() {
+	for event := range s.subscription.Events() {
+		err := s.outbox.Send(&messages.ChatEvent{
+			EventID:   uuid.New().String(),
+			EventType: messages.EventTypeNotificationCreated,
+			Event:     event,
+		})
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *chatSession) forwardFanout() {
+	for event := range s.fanoutSub.Events() {
+		if err := s.outbox.Send(event); err != nil {
+			return
+		}
+	}
+}
+
+// eventID 传输层使用的事件ID, 由会话ID和编号组成, 重连时据此找回会话
+func (s *chatSession) eventID(seq uint64) string {
+	return s.id + ":" + strconv.FormatUint(seq, 10)
+}
+
+// parseEventID 解析 Last-Event-ID, 格式不正确时返回 false
+func parseEventID(eventID string) (string, uint64, bool) {
+	sessionID, seqStr, ok := strings.Cut(eventID, ":")
+	if !ok || sessionID == "" {
+		return "", 0, false
+	}
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return sessionID, seq, true
+}
+
+func eventsLostEvent() *messages.ChatEvent {
+	code := "events_lost"
+	return &messages.ChatEvent{
+		EventID:   uuid.New().String(),
+		EventType: messages.EventTypeError,
+		Event: &messages.ErrorEvent{
+			Code:    &code,
+			Message: "部分事件已过期, 请重新拉取历史消息",
+		},
+	}
+}
+
+// chatSessionRegistry SSE 和长轮询会话跨越多个 HTTP 请求, 按会话ID索引
+type chatSessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]*chatSession
+}
+
+func newChatSessionRegistry() *chatSessionRegistry {
+	return &chatSessionRegistry{sessions: make(map[string]*chatSession)}
+}
+
+// Get 只返回属于 did 的会话
+func (r *chatSessionRegistry) Get(sessionID string, did string) *chatSession {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session, ok := r.sessions[sessionID]
+	if !ok || session.did != did {
+		return nil
+	}
+	return session
+}
+
+// Add 登记会话, 会话空闲超时或关闭后自动移除
+func (r *chatSessionRegistry) Add(session *chatSession) {
+	r.mu.Lock()
+	r.sessions[session.id] = session
+	r.mu.Unlock()
+	session.expireWhenIdle(r.remove)
+}
+
+func (r *chatSessionRegistry) remove(session *chatSession) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sessions[session.id] == session {
+		delete(r.sessions, session.id)
+	}
+}
@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/types"
+)
+
+// newTestChatServer 启动只挂载 GET /events 的服务, 请求方身份由 X-Test-Did 请求头指定
+func newTestChatServer(t *testing.T) (*ChatHandler, *httptest.Server) {
+	t.Helper()
+	handler := NewChatHandler(&config.SocialConfig{}, newTestMetaStore(t))
+	e := echo.New()
+	e.GET("/events", func(c echo.Context) error {
+		return handler.Events(&types.APIContext{Context: c, User: &types.User{Did: c.Request().Header.Get("X-Test-Did")}})
+	})
+	server := httptest.NewServer(e)
+	t.Cleanup(func() {
+		server.Close()
+		handler.sessions.mu.Lock()
+		sessions := make([]*chatSession, 0, len(handler.sessions.sessions))
+		for _, session := range handler.sessions.sessions {
+			sessions = append(sessions, session)
+		}
+		handler.sessions.mu.Unlock()
+		for _, session := range sessions {
+			session.Close()
+		}
+	})
+	return handler, server
+}
+
+func testEvent(n int) *messages.ChatEvent {
+	id := fmt.Sprintf("msg%d", n)
+	return &messages.ChatEvent{
+		EventID:   id,
+		EventType: messages.EventTypeMessageSent,
+		Event:     &messages.MessageSentEvent{MessageID: id},
+	}
+}
+
+// wireEvent 客户端看到的事件, 只解析测试关心的字段
+type wireEvent struct {
+	ID    string
+	Event struct {
+		EventID   string                 `json:"eventId"`
+		EventType messages.ChatEventType `json:"eventType"`
+		Event     struct {
+			Code string `json:"code"`
+		} `json:"event"`
+	}
+}
+
+type pollResponse struct {
+	SessionID   string `json:"sessionId"`
+	LastEventID string `json:"lastEventId"`
+	Events      []struct {
+		ID    string          `json:"id"`
+		Event json.RawMessage `json:"event"`
+	} `json:"events"`
+}
+
+func poll(t *testing.T, server *httptest.Server, did string, query url.Values) (*pollResponse, []wireEvent) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/events?"+query.Encode(), nil)
+	if err != nil {
+		t.Errorf("new request: %v", err)
+		return nil, nil
+	}
+	req.Header.Set("X-Test-Did", did)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("poll: %v", err)
+		return nil, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("poll status = %d", resp.StatusCode)
+		return nil, nil
+	}
+	var body pollResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Errorf("decode poll response: %v", err)
+		return nil, nil
+	}
+	events := make([]wireEvent, 0, len(body.Events))
+	for _, raw := range body.Events {
+		event := wireEvent{ID: raw.ID}
+		if err := json.Unmarshal(raw.Event, &event.Event); err != nil {
+			t.Errorf("decode event: %v", err)
+		}
+		events = append(events, event)
+	}
+	return &body, events
+}
+
+// sseStream 读取 SSE 响应中的事件, 忽略注释行
+type sseStream struct {
+	resp   *http.Response
+	cancel context.CancelFunc
+	events chan wireEvent
+}
+
+func openSSE(t *testing.T, server *httptest.Server, did string, lastEventID string) *sseStream {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("X-Test-Did", did)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		cancel()
+		t.Fatalf("open sse: %v", err)
+	}
+	if contentType := resp.Header.Get(echo.HeaderContentType); contentType != "text/event-stream" {
+		t.Fatalf("content type = %q", contentType)
+	}
+
+	stream := &sseStream{resp: resp, cancel: cancel, events: make(chan wireEvent, 1024)}
+	go func() {
+		defer close(stream.events)
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+		var event wireEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				event.ID = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event.Event); err != nil {
+					t.Errorf("decode sse data: %v", err)
+				}
+			case line == "" && event.ID != "":
+				stream.events <- event
+				event = wireEvent{}
+			}
+		}
+	}()
+	t.Cleanup(stream.Close)
+	return stream
+}
+
+func (s *sseStream) next(t *testing.T) wireEvent {
+	t.Helper()
+	select {
+	case event, ok := <-s.events:
+		if !ok {
+			t.Fatal("sse stream closed")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for sse event")
+		return wireEvent{}
+	}
+}
+
+func (s *sseStream) Close() {
+	s.cancel()
+	s.resp.Body.Close()
+}
+
+func waitSession(t *testing.T, handler *ChatHandler, sessionID string) *chatSession {
+	t.Helper()
+	session := handler.sessions.Get(sessionID, testDID)
+	if session == nil {
+		t.Fatalf("session %s not registered", sessionID)
+	}
+	return session
+}
+
+func TestChatEventsSSEResumesFromLastEventID(t *testing.T) {
+	handler, server := newTestChatServer(t)
+
+	stream := openSSE(t, server, testDID, "")
+	created := stream.next(t)
+	if created.Event.EventType != messages.EventTypeSessionCreated {
+		t.Fatalf("first event = %s, want session.created", created.Event.EventType)
+	}
+	sessionID, _, ok := parseEventID(created.ID)
+	if !ok {
+		t.Fatalf("invalid event id %q", created.ID)
+	}
+	session := waitSession(t, handler, sessionID)
+
+	// ChatActor 的输出流和 Serve 并发读写 backlog
+	const total = 200
+	go func() {
+		for i := 1; i <= total; i++ {
+			if err := session.outbox.Send(testEvent(i)); err != nil {
+				t.Errorf("send: %v", err)
+				return
+			}
+		}
+	}()
+
+	var lastID string
+	for i := 1; i <= total/2; i++ {
+		event := stream.next(t)
+		if want := fmt.Sprintf("msg%d", i); event.Event.EventID != want {
+			t.Fatalf("event %d = %s, want %s", i, event.Event.EventID, want)
+		}
+		lastID = event.ID
+	}
+	stream.Close()
+
+	// 断线期间的事件保留在会话中, 用 Last-Event-ID 重连后从断开处继续, 不重复也不遗漏
+	resumed := openSSE(t, server, testDID, lastID)
+	for i := total/2 + 1; i <= total; i++ {
+		event := resumed.next(t)
+		if want := fmt.Sprintf("msg%d", i); event.Event.EventID != want {
+			t.Fatalf("resumed event %d = %s, want %s", i, event.Event.EventID, want)
+		}
+	}
+	if got := waitSession(t, handler, sessionID); got != session {
+		t.Error("reconnect created a new session")
+	}
+
+	// 会话关闭后结束 SSE 响应并从注册表移除
+	session.Close()
+	select {
+	case _, ok := <-resumed.events:
+		if ok {
+			t.Error("unexpected event after session close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("sse response still open after session close")
+	}
+	if handler.sessions.Get(sessionID, testDID) != nil {
+		t.Error("closed session still registered")
+	}
+}
+
+func TestChatEventsLongPollConcurrentClients(t *testing.T) {
+	handler, server := newTestChatServer(t)
+
+	first, events := poll(t, server, testDID, url.Values{"wait": {"0"}})
+	if first == nil {
+		t.FailNow()
+	}
+	if len(events) != 1 || events[0].Event.EventType != messages.EventTypeSessionCreated {
+		t.Fatalf("first poll = %+v, want session.created", events)
+	}
+	session := waitSession(t, handler, first.SessionID)
+
+	const total = 100
+	const clients = 4
+	var wg sync.WaitGroup
+	received := make([][]string, clients)
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			lastEventID := first.LastEventID
+			for len(received[c]) < total {
+				body, events := poll(t, server, testDID, url.Values{"lastEventId": {lastEventID}, "wait": {"5"}})
+				if body == nil {
+					return
+				}
+				if body.SessionID != first.SessionID {
+					t.Errorf("client %d moved to session %s", c, body.SessionID)
+					return
+				}
+				for _, event := range events {
+					received[c] = append(received[c], event.Event.EventID)
+				}
+				lastEventID = body.LastEventID
+			}
+		}(c)
+	}
+	for i := 1; i <= total; i++ {
+		if err := session.outbox.Send(testEvent(i)); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+		if i%10 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	wg.Wait()
+
+	for c, ids := range received {
+		if len(ids) != total {
+			t.Errorf("client %d received %d events, want %d", c, len(ids), total)
+			continue
+		}
+		for i, id := range ids {
+			if want := fmt.Sprintf("msg%d", i+1); id != want {
+				t.Errorf("client %d event %d = %s, want %s", c, i, id, want)
+				break
+			}
+		}
+	}
+
+	// 其他用户拿到会话ID也不能读取, 只会得到自己的新会话
+	other, _ := poll(t, server, "did:plc:mallory", url.Values{"sessionId": {first.SessionID}, "wait": {"0"}})
+	if other != nil && other.SessionID == first.SessionID {
+		t.Error("another user attached to alice's session")
+	}
+}
+
+func TestChatEventsReportsLostEvents(t *testing.T) {
+	handler, server := newTestChatServer(t)
+
+	first, _ := poll(t, server, testDID, url.Values{"wait": {"0"}})
+	if first == nil {
+		t.FailNow()
+	}
+	session := waitSession(t, handler, first.SessionID)
+	for i := 1; i <= chatSessionBacklog+10; i++ {
+		session.append(testEvent(i))
+	}
+
+	_, events := poll(t, server, testDID, url.Values{"lastEventId": {first.LastEventID}, "wait": {"0"}})
+	if len(events) != chatSessionBacklog+1 {
+		t.Fatalf("got %d events, want the lost marker and the backlog", len(events))
+	}
+	if events[0].Event.EventType != messages.EventTypeError || events[0].Event.Event.Code != "events_lost" {
+		t.Errorf("first event = %+v, want events_lost", events[0].Event)
+	}
+	if want := fmt.Sprintf("msg%d", 11); events[1].Event.EventID != want {
+		t.Errorf("oldest kept event = %s, want %s", events[1].Event.EventID, want)
+	}
+}
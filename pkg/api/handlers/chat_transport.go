@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+)
+
+// chatHeartbeatInterval 空闲时的保活间隔, 避免代理断开长时间没有数据的连接
+const chatHeartbeatInterval = 15 * time.Second
+
+// ChatTransport 聊天协议的传输层. 会话按编号顺序将事件交给传输层写出,
+// WebSocket 和 SSE 共用同一套会话、ChatActor 和 ChatEvent JSON
+type ChatTransport interface {
+	// Send 写出一个服务端事件, id 为会话内的事件ID, 可用于断线续传
+	Send(id string, event *messages.ChatEvent) error
+	// Ping 空闲时保活
+	Ping() error
+}
+
+// websocketTransport WebSocket 连接同时有读写两个方向, 写操作需要串行
+type websocketTransport struct {
+	conn *websocket.Conn
+	mu   sync.Mutex
+}
+
+func newWebsocketTransport(conn *websocket.Conn) *websocketTransport {
+	return &websocketTransport{conn: conn}
+}
+
+func (t *websocketTransport) Send(id string, event *messages.ChatEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		logrus.Errorf("ChatStream response marshal error: %v", err)
+		return err
+	}
+
+	logrus.Infof("发送响应到客户端: %s", string(data))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	err = t.conn.WriteMessage(websocket.TextMessage, data)
+	if err != nil {
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			logrus.Info("客户端连接已关闭，停止发送响应")
+		} else {
+			logrus.Errorf("ChatStream write response error: %v", err)
+		}
+		return err
+	}
+	return nil
+}
+
+func (t *websocketTransport) Ping() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+}
+
+func (t *websocketTransport) SendError(errorCode string, errorMsg string) error {
+	return t.Send("", &messages.ChatEvent{
+		EventID:   uuid.New().String(),
+		EventType: messages.EventTypeError,
+		Event: &messages.ErrorEvent{
+			Code:    &errorCode,
+			Message: errorMsg,
+		},
+	})
+}
+
+// sseTransport Server-Sent Events, 每个事件的 data 为 ChatEvent JSON, id 供浏览器重连时作为 Last-Event-ID 带回
+type sseTransport struct {
+	response *echo.Response
+}
+
+// newSSETransport 写出 SSE 响应头. 服务端配置的 WriteTimeout 会切断长连接, 这里对当前请求取消写超时
+func newSSETransport(response *echo.Response) *sseTransport {
+	if err := http.NewResponseController(response).SetWriteDeadline(time.Time{}); err != nil {
+		logrus.Warnf("取消 SSE 写超时失败: %v", err)
+	}
+	header := response.Header()
+	header.Set(echo.HeaderContentType, "text/event-stream")
+	header.Set(echo.HeaderCacheControl, "no-cache")
+	header.Set(echo.HeaderConnection, "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	response.WriteHeader(http.StatusOK)
+	response.Flush()
+	return &sseTransport{response: response}
+}
+
+func (t *sseTransport) Send(id string, event *messages.ChatEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		logrus.Errorf("ChatStream response marshal error: %v", err)
+		return err
+	}
+	if _, err := fmt.Fprintf(t.response, "id: %s\ndata: %s\n\n", id, data); err != nil {
+		return err
+	}
+	t.response.Flush()
+	return nil
+}
+
+func (t *sseTransport) Ping() error {
+	if _, err := fmt.Fprint(t.response, ": ping\n\n"); err != nil {
+		return err
+	}
+	t.response.Flush()
+	return nil
+}
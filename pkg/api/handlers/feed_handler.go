@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -33,16 +34,28 @@ func (h *FeedHandler) Feeds(c *types.APIContext) error {
 			limit = l
 		}
 	}
-	_ = c.Request().Context()
 
-	cursor := c.QueryParam("cursor")
 	feedName := c.QueryParam("feed")
 	if feedName == "" {
 		feedName = "default"
 	}
 
-	feeds, err := h.feedService.Feeds(c.Request().Context(), feedName, limit, cursor)
+	query := &services.FeedQuery{
+		Viewer:      c.ViewerDid(),
+		Limit:       limit,
+		Cursor:      c.QueryParam("cursor"),
+		WithReplies: c.QueryParam("replies") != "false",
+		Debug:       c.QueryParam("debug") == "true",
+	}
+
+	feeds, err := h.feedService.Feeds(c.Request().Context(), feedName, query)
 	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUnknownFeed), errors.Is(err, services.ErrInvalidCursor):
+			return echo.NewHTTPError(http.StatusBadRequest, "获取feed失败: "+err.Error())
+		case errors.Is(err, services.ErrViewerNeeded):
+			return echo.NewHTTPError(http.StatusUnauthorized, "获取feed失败: "+err.Error())
+		}
 		return echo.NewHTTPError(http.StatusInternalServerError, "获取feed失败: "+err.Error())
 	}
 
@@ -62,7 +75,7 @@ func (h *FeedHandler) MomentThread(c *types.APIContext) error {
 		}
 	}
 
-	thread, err := h.feedService.MomentThread(c.Request().Context(), uri, depth)
+	thread, err := h.feedService.MomentThread(c.Request().Context(), c.ViewerDid(), uri, depth)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "获取帖子失败: "+err.Error())
 	}
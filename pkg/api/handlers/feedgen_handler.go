@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/labstack/echo/v4"
+
+	mw "github.com/zhongshangwu/avatarai-social/pkg/api/middleware"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/labeler"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/services"
+)
+
+const feedGeneratorCollection = "app.bsky.feed.generator"
+
+// FeedGenHandler 以 ATProto feed generator 的身份提供 feed, 与 /api/feeds 共用 FeedGenerator 实现
+type FeedGenHandler struct {
+	config      *config.SocialConfig
+	metaStore   *repositories.MetaStore
+	feedService *services.FeedService
+	feeds       map[string]string // feed 记录 URI -> 内部 feed 名称
+	labeler     *labeler.Labeler  // 与 feed generator 共用 did:web 时写入 DID 文档
+}
+
+func NewFeedGenHandler(config *config.SocialConfig, metaStore *repositories.MetaStore) *FeedGenHandler {
+	feeds := make(map[string]string, len(config.FeedGen.Feeds))
+	for _, feed := range config.FeedGen.Feeds {
+		feeds[feedGeneratorURI(&config.FeedGen, feed.RKey)] = feed.Feed
+	}
+
+	return &FeedGenHandler{
+		config:      config,
+		metaStore:   metaStore,
+		feedService: services.NewFeedService(config, metaStore),
+		feeds:       feeds,
+		labeler:     labeler.Shared(config, metaStore),
+	}
+}
+
+type didDocument struct {
+	Context            []string                `json:"@context"`
+	ID                 string                  `json:"id"`
+	VerificationMethod []didVerificationMethod `json:"verificationMethod,omitempty"`
+	Service            []didService            `json:"service"`
+}
+
+type didService struct {
+	ID              string `json:"id"`
+	Type            string `json:"type"`
+	ServiceEndpoint string `json:"serviceEndpoint"`
+}
+
+// DidDocument /.well-known/did.json, 声明 did:web 的 feed generator 服务地址,
+// labeler 使用同一个 did:web 时同时声明 #atproto_label 公钥和 labeler 服务地址
+func (h *FeedGenHandler) DidDocument(c echo.Context) error {
+	did := h.config.FeedGen.ServiceDID()
+	doc := &didDocument{
+		Context: []string{"https://www.w3.org/ns/did/v1"},
+		ID:      did,
+		Service: []didService{},
+	}
+	if h.config.FeedGen.Enabled {
+		doc.Service = append(doc.Service, didService{
+			ID:              "#bsky_fg",
+			Type:            "BskyFeedGenerator",
+			ServiceEndpoint: "https://" + h.config.FeedGen.Hostname,
+		})
+	}
+	methods, services := labelerDIDEntries(h.labeler, did, h.config.FeedGen.Hostname)
+	if len(methods) > 0 {
+		doc.Context = append(doc.Context, "https://w3id.org/security/multikey/v1")
+		doc.VerificationMethod = methods
+		doc.Service = append(doc.Service, services...)
+	}
+	return c.JSON(http.StatusOK, doc)
+}
+
+// ServesDIDDocument 启用 feed generator, 或 labeler 使用服务自身的 did:web 时提供 DID 文档
+func (h *FeedGenHandler) ServesDIDDocument() bool {
+	return h.config.FeedGen.Enabled || (h.labeler != nil && h.labeler.DID() == h.config.FeedGen.ServiceDID())
+}
+
+func (h *FeedGenHandler) DescribeFeedGenerator(c echo.Context) error {
+	out := &bsky.FeedDescribeFeedGenerator_Output{
+		Did:   h.config.FeedGen.ServiceDID(),
+		Feeds: make([]*bsky.FeedDescribeFeedGenerator_Feed, 0, len(h.config.FeedGen.Feeds)),
+	}
+	for _, feed := range h.config.FeedGen.Feeds {
+		out.Feeds = append(out.Feeds, &bsky.FeedDescribeFeedGenerator_Feed{
+			Uri: feedGeneratorURI(&h.config.FeedGen, feed.RKey),
+		})
+	}
+	return c.JSON(http.StatusOK, out)
+}
+
+// GetFeedSkeleton app.bsky.feed.getFeedSkeleton, 请求方 DID 由服务间认证中间件写入
+func (h *FeedGenHandler) GetFeedSkeleton(c echo.Context) error {
+	feedURI := c.QueryParam("feed")
+	if _, err := syntax.ParseATURI(feedURI); err != nil {
+		return xrpcError(c, http.StatusBadRequest, "InvalidRequest", "无效的 feed 参数")
+	}
+	feedName, ok := h.feeds[feedURI]
+	if !ok {
+		return xrpcError(c, http.StatusBadRequest, "UnknownFeed", "未知的 feed: "+feedURI)
+	}
+
+	limit := 50
+	if limitStr := c.QueryParam("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l < 1 || l > 100 {
+			return xrpcError(c, http.StatusBadRequest, "InvalidRequest", "limit 应在 1 到 100 之间")
+		}
+		limit = l
+	}
+
+	viewer, _ := c.Get(mw.RequesterDIDKey).(string)
+	query := &services.FeedQuery{
+		Viewer:      viewer,
+		Limit:       limit,
+		Cursor:      c.QueryParam("cursor"),
+		WithReplies: true,
+	}
+
+	uris, nextCursor, err := h.feedService.FeedSkeleton(c.Request().Context(), feedName, query)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUnknownFeed):
+			return xrpcError(c, http.StatusBadRequest, "UnknownFeed", err.Error())
+		case errors.Is(err, services.ErrInvalidCursor):
+			return xrpcError(c, http.StatusBadRequest, "InvalidRequest", err.Error())
+		case errors.Is(err, services.ErrViewerNeeded):
+			return xrpcError(c, http.StatusUnauthorized, "AuthenticationRequired", err.Error())
+		}
+		c.Logger().Errorf("获取 feed skeleton 失败: %v", err)
+		return xrpcError(c, http.StatusInternalServerError, "InternalServerError", "获取 feed 失败")
+	}
+
+	out := &bsky.FeedGetFeedSkeleton_Output{
+		Feed: make([]*bsky.FeedDefs_SkeletonFeedPost, 0, len(uris)),
+	}
+	for _, uri := range uris {
+		out.Feed = append(out.Feed, &bsky.FeedDefs_SkeletonFeedPost{Post: uri})
+	}
+	if nextCursor != "" {
+		out.Cursor = &nextCursor
+	}
+	return c.JSON(http.StatusOK, out)
+}
+
+func feedGeneratorURI(feedGen *config.FeedGenConfig, rkey string) string {
+	return "at://" + feedGen.Publisher() + "/" + feedGeneratorCollection + "/" + rkey
+}
+
+// xrpcError 按 XRPC 约定返回 {"error", "message"}
+func xrpcError(c echo.Context, status int, name string, message string) error {
+	return c.JSON(status, map[string]string{
+		"error":   name,
+		"message": message,
+	})
+}
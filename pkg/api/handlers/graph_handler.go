@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/labstack/echo/v4"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/services"
+	"github.com/zhongshangwu/avatarai-social/types"
+)
+
+type GraphHandler struct {
+	config              *config.SocialConfig
+	metaStore           *repositories.MetaStore
+	relationshipService *services.RelationshipService
+}
+
+func NewGraphHandler(config *config.SocialConfig, metaStore *repositories.MetaStore) *GraphHandler {
+	return &GraphHandler{
+		config:              config,
+		metaStore:           metaStore,
+		relationshipService: services.NewRelationshipService(config, metaStore),
+	}
+}
+
+type relationshipRequest struct {
+	Did string `json:"did"`
+}
+
+func (h *GraphHandler) Follow(c *types.APIContext) error {
+	var req relationshipRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "请求格式错误: "+err.Error())
+	}
+
+	relationship, err := h.relationshipService.Follow(c.Request().Context(), c.User.Did, req.Did)
+	if err != nil {
+		return relationshipError("关注失败", err)
+	}
+	return c.JSON(http.StatusOK, relationship)
+}
+
+func (h *GraphHandler) Unfollow(c *types.APIContext) error {
+	did := c.QueryParam("did")
+	if did == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "did参数不能为空")
+	}
+
+	if err := h.relationshipService.Unfollow(c.Request().Context(), c.User.Did, did); err != nil {
+		return relationshipError("取消关注失败", err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func (h *GraphHandler) Block(c *types.APIContext) error {
+	var req relationshipRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "请求格式错误: "+err.Error())
+	}
+
+	relationship, err := h.relationshipService.Block(c.Request().Context(), c.User.Did, req.Did)
+	if err != nil {
+		return relationshipError("屏蔽失败", err)
+	}
+	return c.JSON(http.StatusOK, relationship)
+}
+
+func (h *GraphHandler) Unblock(c *types.APIContext) error {
+	did := c.QueryParam("did")
+	if did == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "did参数不能为空")
+	}
+
+	if err := h.relationshipService.Unblock(c.Request().Context(), c.User.Did, did); err != nil {
+		return relationshipError("取消屏蔽失败", err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func (h *GraphHandler) Mute(c *types.APIContext) error {
+	var req relationshipRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "请求格式错误: "+err.Error())
+	}
+
+	relationship, err := h.relationshipService.Mute(c.Request().Context(), c.User.Did, req.Did)
+	if err != nil {
+		return relationshipError("静音失败", err)
+	}
+	return c.JSON(http.StatusOK, relationship)
+}
+
+func (h *GraphHandler) Unmute(c *types.APIContext) error {
+	did := c.QueryParam("did")
+	if did == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "did参数不能为空")
+	}
+
+	if err := h.relationshipService.Unmute(c.Request().Context(), c.User.Did, did); err != nil {
+		return relationshipError("取消静音失败", err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// Followers 查询用户的粉丝, 未指定 did 时查询当前用户
+func (h *GraphHandler) Followers(c *types.APIContext) error {
+	did, err := targetDid(c)
+	if err != nil {
+		return err
+	}
+	limit, cursor := listParams(c)
+
+	list, err := h.relationshipService.Followers(c.Request().Context(), did, c.ViewerDid(), limit, cursor)
+	if err != nil {
+		return relationshipError("获取粉丝列表失败", err)
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
+// Following 查询用户关注的人, 未指定 did 时查询当前用户
+func (h *GraphHandler) Following(c *types.APIContext) error {
+	did, err := targetDid(c)
+	if err != nil {
+		return err
+	}
+	limit, cursor := listParams(c)
+
+	list, err := h.relationshipService.Following(c.Request().Context(), did, c.ViewerDid(), limit, cursor)
+	if err != nil {
+		return relationshipError("获取关注列表失败", err)
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
+func (h *GraphHandler) Blocks(c *types.APIContext) error {
+	limit, cursor := listParams(c)
+
+	list, err := h.relationshipService.Blocks(c.Request().Context(), c.User.Did, limit, cursor)
+	if err != nil {
+		return relationshipError("获取屏蔽列表失败", err)
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
+func (h *GraphHandler) Mutes(c *types.APIContext) error {
+	limit, cursor := listParams(c)
+
+	list, err := h.relationshipService.Mutes(c.Request().Context(), c.User.Did, limit, cursor)
+	if err != nil {
+		return relationshipError("获取静音列表失败", err)
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
+func targetDid(c *types.APIContext) (string, error) {
+	did := c.QueryParam("did")
+	if did == "" {
+		did = c.ViewerDid()
+	}
+	if did == "" {
+		return "", echo.NewHTTPError(http.StatusBadRequest, "did参数不能为空")
+	}
+	if _, err := syntax.ParseDID(did); err != nil {
+		return "", echo.NewHTTPError(http.StatusBadRequest, "无效的did参数")
+	}
+	return did, nil
+}
+
+func listParams(c *types.APIContext) (int, string) {
+	limit := 50
+	if limitStr := c.QueryParam("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+	}
+	return limit, c.QueryParam("cursor")
+}
+
+func relationshipError(message string, err error) error {
+	switch {
+	case errors.Is(err, services.ErrInvalidDID), errors.Is(err, services.ErrSelfRelationship), errors.Is(err, services.ErrInvalidCursor):
+		return echo.NewHTTPError(http.StatusBadRequest, message+": "+err.Error())
+	case errors.Is(err, services.ErrBlocked):
+		return echo.NewHTTPError(http.StatusForbidden, message+": "+err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, message+": "+err.Error())
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/labeler"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+)
+
+// labelWriteTimeout 单帧的写入超时, 订阅方不再读取时断开连接, 推送协程不会一直阻塞
+const labelWriteTimeout = 10 * time.Second
+
+// LabelerHandler 以 ATProto labeler 的身份提供 com.atproto.label.* 接口, 未启用 labeler 时不注册路由
+type LabelerHandler struct {
+	config    *config.SocialConfig
+	metaStore *repositories.MetaStore
+	labeler   *labeler.Labeler
+}
+
+func NewLabelerHandler(config *config.SocialConfig, metaStore *repositories.MetaStore) *LabelerHandler {
+	return &LabelerHandler{
+		config:    config,
+		metaStore: metaStore,
+		labeler:   labeler.Shared(config, metaStore),
+	}
+}
+
+func (h *LabelerHandler) Enabled() bool {
+	return h.labeler != nil
+}
+
+// QueryLabels com.atproto.label.queryLabels, uriPatterns 必填, 以 * 结尾时按前缀匹配
+func (h *LabelerHandler) QueryLabels(c echo.Context) error {
+	params := c.QueryParams()
+	uriPatterns := params["uriPatterns"]
+	if len(uriPatterns) == 0 {
+		return xrpcError(c, http.StatusBadRequest, "InvalidRequest", "缺少 uriPatterns 参数")
+	}
+	for _, pattern := range uriPatterns {
+		if pattern == "" || pattern == "*" {
+			return xrpcError(c, http.StatusBadRequest, "InvalidRequest", "uriPatterns 不能为空或只包含 *")
+		}
+	}
+
+	limit := 50
+	if limitStr := c.QueryParam("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l < 1 || l > 250 {
+			return xrpcError(c, http.StatusBadRequest, "InvalidRequest", "limit 应在 1 到 250 之间")
+		}
+		limit = l
+	}
+	var cursor int64
+	if cursorStr := c.QueryParam("cursor"); cursorStr != "" {
+		value, err := strconv.ParseInt(cursorStr, 10, 64)
+		if err != nil || value < 0 {
+			return xrpcError(c, http.StatusBadRequest, "InvalidRequest", "无效的 cursor 参数")
+		}
+		cursor = value
+	}
+
+	out, err := h.labeler.Query(uriPatterns, params["sources"], cursor, limit)
+	if err != nil {
+		c.Logger().Errorf("查询标签失败: %v", err)
+		return xrpcError(c, http.StatusInternalServerError, "InternalServerError", "查询标签失败")
+	}
+	return c.JSON(http.StatusOK, out)
+}
+
+// SubscribeLabels com.atproto.label.subscribeLabels, 带 cursor 时先回放之后的全部标签
+func (h *LabelerHandler) SubscribeLabels(c echo.Context) error {
+	var cursor *int64
+	if cursorStr := c.QueryParam("cursor"); cursorStr != "" {
+		value, err := strconv.ParseInt(cursorStr, 10, 64)
+		if err != nil || value < 0 {
+			return xrpcError(c, http.StatusBadRequest, "InvalidRequest", "无效的 cursor 参数")
+		}
+		cursor = &value
+	}
+
+	conn, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+
+	// 订阅方不会发送消息, 读取只用于发现连接断开
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	err = h.labeler.Stream(ctx, cursor, func(frame []byte) error {
+		if err := conn.SetWriteDeadline(time.Now().Add(labelWriteTimeout)); err != nil {
+			return err
+		}
+		return conn.WriteMessage(websocket.BinaryMessage, frame)
+	})
+	if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, labeler.ErrFutureCursor) {
+		c.Logger().Warnf("标签订阅中断: %v", err)
+	}
+	return nil
+}
+
+// didVerificationMethod DID 文档中的 Multikey 公钥
+type didVerificationMethod struct {
+	ID                 string `json:"id"`
+	Type               string `json:"type"`
+	Controller         string `json:"controller"`
+	PublicKeyMultibase string `json:"publicKeyMultibase"`
+}
+
+// labelerDIDEntries labeler 的 DID 为服务自身的 did:web 时, 返回需要写入 DID 文档的公钥和服务地址
+func labelerDIDEntries(l *labeler.Labeler, did string, hostname string) ([]didVerificationMethod, []didService) {
+	if l == nil || l.DID() != did {
+		return nil, nil
+	}
+	publicKey, err := l.PublicKeyMultibase()
+	if err != nil {
+		return nil, nil
+	}
+	methods := []didVerificationMethod{{
+		ID:                 did + "#atproto_label",
+		Type:               "Multikey",
+		Controller:         did,
+		PublicKeyMultibase: publicKey,
+	}}
+	services := []didService{{
+		ID:              "#atproto_labeler",
+		Type:            "AtprotoLabeler",
+		ServiceEndpoint: "https://" + hostname,
+	}}
+	return methods, services
+}
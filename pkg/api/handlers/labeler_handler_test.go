@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"context"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/labeler"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+)
+
+const (
+	testLabelerDID = "did:web:labeler.test"
+	labeledMoment1 = "at://did:plc:alice/app.vtri.activity.moment/m1"
+	labeledMoment2 = "at://did:plc:alice/app.vtri.activity.moment/m2"
+)
+
+// newTestLabeler 使用随机生成的密钥创建 labeler, 返回 labeler 和对应公钥的 multibase
+func newTestLabeler(t *testing.T, store *repositories.MetaStore) (*labeler.Labeler, string) {
+	t.Helper()
+	key, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	l, err := labeler.NewLabeler(config.LabelerConfig{DID: testLabelerDID, SigningKey: key.Multibase()}, store)
+	if err != nil {
+		t.Fatalf("new labeler: %v", err)
+	}
+	publicKey, err := l.PublicKeyMultibase()
+	if err != nil {
+		t.Fatalf("public key: %v", err)
+	}
+	return l, publicKey
+}
+
+func emitLabels(t *testing.T, l *labeler.Labeler, uri string, neg bool, vals ...string) {
+	t.Helper()
+	if _, err := l.Emit(context.Background(), uri, "", vals, neg); err != nil {
+		t.Fatalf("emit %v on %s: %v", vals, uri, err)
+	}
+}
+
+func storedLabels(t *testing.T, store *repositories.MetaStore, uri string) string {
+	t.Helper()
+	labels, err := store.ModRepo.GetLabelsByURIs([]string{uri})
+	if err != nil {
+		t.Fatalf("labels of %s: %v", uri, err)
+	}
+	vals := make([]string, 0, len(labels[uri]))
+	for _, label := range labels[uri] {
+		vals = append(vals, label.Src+":"+label.Val)
+	}
+	sort.Strings(vals)
+	return strings.Join(vals, ",")
+}
+
+func waitForLabels(t *testing.T, store *repositories.MetaStore, uri string, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := storedLabels(t, store, uri)
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("labels of %s = %q, want %q", uri, got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLabelerSubscribeRoundTrip(t *testing.T) {
+	labelerStore := newTestMetaStore(t)
+	l, publicKey := newTestLabeler(t, labelerStore)
+	emitLabels(t, l, labeledMoment1, false, "spoiler") // seq 1, 在订阅游标之前
+	emitLabels(t, l, labeledMoment1, false, "nudity")  // seq 2
+	emitLabels(t, l, labeledMoment1, true, "nudity")   // seq 3, 撤销
+	emitLabels(t, l, "at://did:plc:alice/app.bsky.feed.post/p1", false, "gore")
+	emitLabels(t, l, labeledMoment2, false, "gore") // seq 5
+
+	handler := &LabelerHandler{labeler: l}
+	e := echo.New()
+	e.GET("/xrpc/com.atproto.label.subscribeLabels", handler.SubscribeLabels)
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+
+	// 订阅方从保存的游标之后开始回放
+	store := newTestMetaStore(t)
+	if err := store.AtpRepo.SaveIngestCursor("labels:"+testLabelerDID, 1); err != nil {
+		t.Fatalf("save cursor: %v", err)
+	}
+	directory := identity.NewMockDirectory()
+	directory.Insert(identity.Identity{
+		DID:  syntax.DID(testLabelerDID),
+		Keys: map[string]identity.Key{"atproto_label": {Type: "Multikey", PublicKeyMultibase: publicKey}},
+	})
+	subscriber, err := labeler.NewSubscriber(store, config.LabelerSubscriptionConfig{DID: testLabelerDID, URL: server.URL}, 10*time.Millisecond, &directory)
+	if err != nil {
+		t.Fatalf("new subscriber: %v", err)
+	}
+	if err := subscriber.Start(); err != nil {
+		t.Fatalf("start subscriber: %v", err)
+	}
+
+	waitForLabels(t, store, labeledMoment2, testLabelerDID+":gore")
+	// 游标之前的 spoiler 不回放, nudity 写入后被撤销, 非 app.vtri 记录的标签不保存
+	if got := storedLabels(t, store, labeledMoment1); got != "" {
+		t.Fatalf("m1 labels after replay = %q, want none", got)
+	}
+	if got := storedLabels(t, store, "at://did:plc:alice/app.bsky.feed.post/p1"); got != "" {
+		t.Fatalf("bsky post labels = %q, want none", got)
+	}
+
+	// 回放之后继续接收新签发的标签
+	emitLabels(t, l, labeledMoment1, false, "violence") // seq 6
+	waitForLabels(t, store, labeledMoment1, testLabelerDID+":violence")
+
+	subscriber.Stop()
+	if cursor, err := store.AtpRepo.GetIngestCursor("labels:" + testLabelerDID); err != nil || cursor != 6 {
+		t.Fatalf("saved cursor = %d, %v, want 6", cursor, err)
+	}
+
+	// 同一 DID 但其他密钥签名的标签校验失败, 不保存
+	forger, _ := newTestLabeler(t, newTestMetaStore(t))
+	events, err := forger.Emit(context.Background(), labeledMoment2, "", []string{"spam"}, false)
+	if err != nil {
+		t.Fatalf("forge: %v", err)
+	}
+	frame, err := labeler.LabelsFrame(7, []*comatproto.LabelDefs_Label{labeler.ToLexicon(events[0])})
+	if err != nil {
+		t.Fatalf("encode frame: %v", err)
+	}
+	if err := subscriber.HandleFrame(context.Background(), frame); err != nil {
+		t.Fatalf("handle forged frame: %v", err)
+	}
+	if got := storedLabels(t, store, labeledMoment2); got != testLabelerDID+":gore" {
+		t.Fatalf("m2 labels after forged frame = %q, want only gore", got)
+	}
+}
+
+func TestLabelerSubscribeFutureCursor(t *testing.T) {
+	store := newTestMetaStore(t)
+	l, _ := newTestLabeler(t, store)
+	emitLabels(t, l, labeledMoment1, false, "spoiler")
+
+	handler := &LabelerHandler{labeler: l}
+	e := echo.New()
+	e.GET("/xrpc/com.atproto.label.subscribeLabels", handler.SubscribeLabels)
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/xrpc/com.atproto.label.subscribeLabels?cursor=100", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, frame, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	subscriber, err := labeler.NewSubscriber(newTestMetaStore(t), config.LabelerSubscriptionConfig{DID: testLabelerDID}, 0, nil)
+	if err != nil {
+		t.Fatalf("new subscriber: %v", err)
+	}
+	if err := subscriber.HandleFrame(context.Background(), frame); err == nil || !strings.Contains(err.Error(), "FutureCursor") {
+		t.Fatalf("future cursor frame = %v, want FutureCursor error", err)
+	}
+}
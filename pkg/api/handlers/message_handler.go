@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/labstack/echo/v4"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/memory"
 	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
 	"github.com/zhongshangwu/avatarai-social/pkg/config"
 	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
@@ -28,16 +30,20 @@ type PaginationInfo struct {
 }
 
 type MessageHandler struct {
-	config         *config.SocialConfig
-	metaStore      *repositories.MetaStore
-	messageService *services.MessageService
+	config          *config.SocialConfig
+	metaStore       *repositories.MetaStore
+	messageService  *services.MessageService
+	roomService     *services.RoomService
+	historySearcher *memory.HistorySearcher
 }
 
 func NewMessageHandler(config *config.SocialConfig, metaStore *repositories.MetaStore) *MessageHandler {
 	return &MessageHandler{
-		config:         config,
-		metaStore:      metaStore,
-		messageService: services.NewMessageService(metaStore),
+		config:          config,
+		metaStore:       metaStore,
+		messageService:  services.NewMessageService(metaStore),
+		roomService:     services.NewRoomService(metaStore),
+		historySearcher: memory.NewHistorySearcher(metaStore, memory.SharedSemanticIndexRegistry(config, metaStore)),
 	}
 }
 
@@ -57,6 +63,11 @@ func (h *MessageHandler) HistoryMessages(c *types.APIContext) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "threadId 参数是必需的")
 	}
 
+	// 只有房间的正式成员可以查看历史消息
+	if _, err := h.roomService.CheckThread(c.User.Did, roomID, threadID); err != nil {
+		return roomError("获取历史消息失败", err)
+	}
+
 	// 解析计数参数
 	limit := 0
 
@@ -84,9 +95,15 @@ func (h *MessageHandler) HistoryMessages(c *types.APIContext) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "获取历史消息失败: "+err.Error())
 	}
 
+	// 其他成员等待审核的消息不返回
+	visible, err := services.VisibleMessages(h.metaStore, result.Messages, c.User.Did)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "获取历史消息失败: "+err.Error())
+	}
+
 	// 转换数据库消息为API消息格式
-	apiMessages := make([]*messages.Message, 0, len(result.Messages))
-	for _, dbMsg := range result.Messages {
+	apiMessages := make([]*messages.Message, 0, len(visible))
+	for _, dbMsg := range visible {
 		apiMsg := h.messageService.Converter.DBToMessage(dbMsg)
 		if apiMsg != nil {
 			apiMessages = append(apiMessages, apiMsg)
@@ -110,6 +127,40 @@ func (h *MessageHandler) HistoryMessages(c *types.APIContext) error {
 	return c.JSON(http.StatusOK, response)
 }
 
+// SearchHistory 混合检索当前用户的聊天记录, 关键词和语义两路结果按倒数排名融合.
+// 可按 roomId、threadId 和 since/until (毫秒时间戳) 过滤
+func (h *MessageHandler) SearchHistory(c *types.APIContext) error {
+	query := &memory.HistoryQuery{
+		UserDid:  c.User.Did,
+		Text:     c.QueryParam("q"),
+		RoomID:   c.QueryParam("roomId"),
+		ThreadID: c.QueryParam("threadId"),
+	}
+	if l, err := strconv.Atoi(c.QueryParam("limit")); err == nil && l > 0 && l <= 50 {
+		query.Limit = l
+	}
+	for param, target := range map[string]*int64{"since": &query.Since, "until": &query.Until} {
+		value := c.QueryParam(param)
+		if value == "" {
+			continue
+		}
+		ts, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || ts < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, param+" 参数必须是毫秒时间戳")
+		}
+		*target = ts
+	}
+
+	hits, err := h.historySearcher.Search(c.Request().Context(), query)
+	if err != nil {
+		if errors.Is(err, memory.ErrEmptyHistoryQuery) {
+			return echo.NewHTTPError(http.StatusBadRequest, "检索聊天记录失败: "+err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "检索聊天记录失败: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, &types.HistorySearchResults{Hits: hits})
+}
+
 func (h *MessageHandler) GetMessageStatsHandler(c echo.Context) error {
 	roomID := c.QueryParam("roomId")
 	threadID := c.QueryParam("threadId")
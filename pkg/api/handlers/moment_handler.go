@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
@@ -24,7 +25,7 @@ func NewMomentHandler(config *config.SocialConfig, metaStore *repositories.MetaS
 	return &MomentHandler{
 		config:        config,
 		metaStore:     metaStore,
-		momentService: services.NewMomentService(metaStore),
+		momentService: services.NewMomentService(config, metaStore),
 		feedService:   services.NewFeedService(config, metaStore),
 		fileService:   services.NewFileService(config, metaStore),
 	}
@@ -45,6 +46,9 @@ func (h *MomentHandler) CreateMoment(c *types.APIContext) error {
 	}
 
 	response, err := h.momentService.CreateMoment(c.Request().Context(), c.User.Did, &req)
+	if errors.Is(err, services.ErrContentRejected) {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "创建moment失败: "+err.Error())
+	}
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "创建moment失败: "+err.Error())
 	}
@@ -62,6 +66,9 @@ func (h *MomentHandler) GetMoment(c *types.APIContext) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, "moment不存在: "+err.Error())
 	}
+	if !h.momentService.VisibleTo(moment, c.ViewerDid()) {
+		return echo.NewHTTPError(http.StatusNotFound, "moment不存在")
+	}
 
 	return c.JSON(http.StatusOK, moment)
 }
@@ -80,7 +87,7 @@ func (h *MomentHandler) GetMomentThread(c *types.APIContext) error {
 		}
 	}
 
-	thread, err := h.feedService.MomentThread(c.Request().Context(), uri, depth)
+	thread, err := h.feedService.MomentThread(c.Request().Context(), c.ViewerDid(), uri, depth)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, "获取 moment thread 失败: "+err.Error())
 	}
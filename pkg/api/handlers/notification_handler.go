@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/services"
+	"github.com/zhongshangwu/avatarai-social/types"
+)
+
+type NotificationHandler struct {
+	config              *config.SocialConfig
+	metaStore           *repositories.MetaStore
+	notificationService *services.NotificationService
+}
+
+func NewNotificationHandler(config *config.SocialConfig, metaStore *repositories.MetaStore) *NotificationHandler {
+	return &NotificationHandler{
+		config:              config,
+		metaStore:           metaStore,
+		notificationService: services.NewNotificationService(config, metaStore),
+	}
+}
+
+// ListNotifications 查询当前用户的通知, unread=true 时只返回未读通知
+func (h *NotificationHandler) ListNotifications(c *types.APIContext) error {
+	limit, cursor := listParams(c)
+	unreadOnly := c.QueryParam("unread") == "true"
+
+	list, err := h.notificationService.List(c.Request().Context(), c.User.Did, unreadOnly, limit, cursor)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidCursor) {
+			return echo.NewHTTPError(http.StatusBadRequest, "获取通知失败: "+err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "获取通知失败: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
+type markReadRequest struct {
+	IDs []uint `json:"ids"` // 为空时标记全部通知
+}
+
+func (h *NotificationHandler) MarkRead(c *types.APIContext) error {
+	var req markReadRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "请求格式错误: "+err.Error())
+	}
+
+	if err := h.notificationService.MarkRead(c.Request().Context(), c.User.Did, req.IDs); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return h.UnreadCount(c)
+}
+
+func (h *NotificationHandler) UnreadCount(c *types.APIContext) error {
+	count, err := h.notificationService.UnreadCount(c.Request().Context(), c.User.Did)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]int64{"count": count})
+}
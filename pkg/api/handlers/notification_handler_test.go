@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/types"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const testDID = "did:plc:alice"
+
+func newTestMetaStore(t *testing.T) *repositories.MetaStore {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.sqlite")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	store := repositories.NewMetaStore(db)
+	if err := store.Init(); err != nil {
+		t.Fatalf("init metastore: %v", err)
+	}
+	return store
+}
+
+// newTestNotificationServer 挂载通知接口, 请求方身份由 X-Test-Did 请求头指定
+func newTestNotificationServer(t *testing.T, store *repositories.MetaStore) *httptest.Server {
+	t.Helper()
+	handler := NewNotificationHandler(&config.SocialConfig{}, store)
+	wrap := func(fn func(*types.APIContext) error) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			return fn(&types.APIContext{Context: c, User: &types.User{Did: c.Request().Header.Get("X-Test-Did")}})
+		}
+	}
+	e := echo.New()
+	e.GET("/notifications", wrap(handler.ListNotifications))
+	e.POST("/notifications/read", wrap(handler.MarkRead))
+	e.GET("/notifications/unread", wrap(handler.UnreadCount))
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func doJSON(t *testing.T, method string, url string, did string, body string, out interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Header.Set("X-Test-Did", did)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("decode %s response: %v", url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestNotificationEndpoints(t *testing.T) {
+	store := newTestMetaStore(t)
+	for i, recipient := range []string{testDID, testDID, testDID, "did:plc:bob"} {
+		_, err := store.NotifyRepo.CreateNotification(&repositories.Notification{
+			Recipient: recipient,
+			Author:    fmt.Sprintf("did:plc:fan%d", i),
+			Reason:    repositories.NotificationReasonFollow,
+			RecordURI: fmt.Sprintf("at://did:plc:fan%d/app.vtri.activity.relationship/f%d", i, i),
+			CreatedAt: int64(1000 + i),
+		})
+		if err != nil {
+			t.Fatalf("create notification: %v", err)
+		}
+	}
+	server := newTestNotificationServer(t, store)
+
+	unread := func(did string) int64 {
+		var body map[string]int64
+		if status := doJSON(t, http.MethodGet, server.URL+"/notifications/unread", did, "", &body); status != http.StatusOK {
+			t.Fatalf("unread status = %d", status)
+		}
+		return body["count"]
+	}
+	if got := unread(testDID); got != 3 {
+		t.Fatalf("unread = %d, want 3", got)
+	}
+
+	var page types.NotificationList
+	if status := doJSON(t, http.MethodGet, server.URL+"/notifications?limit=2", testDID, "", &page); status != http.StatusOK {
+		t.Fatalf("list status = %d", status)
+	}
+	if len(page.Notifications) != 2 || page.Cursor == "" || page.Notifications[0].Author.Did != "did:plc:fan2" {
+		t.Fatalf("first page = %+v", page)
+	}
+
+	// 标记第一页已读, 返回新的未读数; 其他用户的通知 ID 不受影响
+	ids := append(page.Notifications[0].IDs, page.Notifications[1].IDs...)
+	bob, err := store.NotifyRepo.ListNotifications("did:plc:bob", false, 1, 0)
+	if err != nil || len(bob) != 1 {
+		t.Fatalf("bob notifications = %v, %v", bob, err)
+	}
+	request, _ := json.Marshal(map[string][]uint{"ids": append(ids, bob[0].ID)})
+	var marked map[string]int64
+	if status := doJSON(t, http.MethodPost, server.URL+"/notifications/read", testDID, string(request), &marked); status != http.StatusOK {
+		t.Fatalf("mark read status = %d", status)
+	}
+	if marked["count"] != 1 || unread("did:plc:bob") != 1 {
+		t.Fatalf("after mark read: alice unread %d, bob unread %d, want 1 and 1", marked["count"], unread("did:plc:bob"))
+	}
+
+	var unreadPage types.NotificationList
+	doJSON(t, http.MethodGet, server.URL+"/notifications?unread=true", testDID, "", &unreadPage)
+	if len(unreadPage.Notifications) != 1 || unreadPage.Notifications[0].Author.Did != "did:plc:fan0" || unreadPage.Notifications[0].IsRead {
+		t.Fatalf("unread page = %+v, want only fan0's follow", unreadPage.Notifications)
+	}
+
+	// ids 为空时全部标记已读
+	if status := doJSON(t, http.MethodPost, server.URL+"/notifications/read", testDID, `{}`, &marked); status != http.StatusOK || marked["count"] != 0 {
+		t.Fatalf("mark all read = %d, %v", status, marked)
+	}
+	if status := doJSON(t, http.MethodGet, server.URL+"/notifications?cursor=abc", testDID, "", nil); status != http.StatusBadRequest {
+		t.Fatalf("invalid cursor status = %d, want 400", status)
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"github.com/zhongshangwu/avatarai-social/pkg/atproto"
 	"github.com/zhongshangwu/avatarai-social/pkg/config"
 	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/services"
 	"github.com/zhongshangwu/avatarai-social/pkg/utils"
 	"github.com/zhongshangwu/avatarai-social/types"
 )
@@ -466,6 +467,8 @@ func (h *OAuthHandler) HandleOAuthCallback(c echo.Context) error {
 			"error": "创建或获取 Avatar 失败",
 		})
 	}
+	// 登录时 handle 可能已经变化
+	services.IndexUser(h.metaStore, avatar.Did)
 
 	code, err := utils.GenerateCode()
 	if err != nil {
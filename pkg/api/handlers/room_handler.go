@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/services"
+	"github.com/zhongshangwu/avatarai-social/types"
+)
+
+type RoomHandler struct {
+	config        *config.SocialConfig
+	metaStore     *repositories.MetaStore
+	roomService   *services.RoomService
+	mirrorService *services.ChatMirrorService
+}
+
+func NewRoomHandler(config *config.SocialConfig, metaStore *repositories.MetaStore) *RoomHandler {
+	return &RoomHandler{
+		config:        config,
+		metaStore:     metaStore,
+		roomService:   services.NewRoomService(metaStore),
+		mirrorService: services.NewChatMirrorService(config, metaStore),
+	}
+}
+
+type createRoomRequest struct {
+	Type    string   `json:"type"` // direct, group, ai_chat
+	Title   string   `json:"title"`
+	Members []string `json:"members"` // 除自己以外的成员 DID
+}
+
+type roomRequest struct {
+	RoomID   string `json:"roomId"`
+	Title    string `json:"title"`
+	Archived bool   `json:"archived"`
+	Did      string `json:"did"`
+	Enabled  bool   `json:"enabled"`
+}
+
+type createThreadRequest struct {
+	RoomID         string `json:"roomId"`
+	Title          string `json:"title"`
+	ContextMode    string `json:"contextMode"` // continuous, isolated
+	ParentThreadID string `json:"parentThreadId"`
+	RootMID        string `json:"rootMid"`
+}
+
+type forkThreadRequest struct {
+	ThreadID    string `json:"threadId"`
+	MessageID   string `json:"messageId"` // 分叉点的消息
+	Title       string `json:"title"`
+	ContextMode string `json:"contextMode"`
+}
+
+func (h *RoomHandler) CreateRoom(c *types.APIContext) error {
+	var req createRoomRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "请求格式错误: "+err.Error())
+	}
+
+	detail, err := h.roomService.CreateRoom(c.Request().Context(), c.User.Did, req.Type, req.Title, req.Members)
+	if err != nil {
+		return roomError("创建房间失败", err)
+	}
+	return c.JSON(http.StatusOK, detail)
+}
+
+// ListRooms 当前用户的房间, pending=true 时只返回待接受的邀请, archived=true 时只返回已归档的房间
+func (h *RoomHandler) ListRooms(c *types.APIContext) error {
+	limit, cursor := listParams(c)
+	pending := c.QueryParam("pending") == "true"
+	archived := c.QueryParam("archived") == "true"
+
+	list, err := h.roomService.ListRooms(c.Request().Context(), c.User.Did, pending, archived, limit, cursor)
+	if err != nil {
+		return roomError("获取房间列表失败", err)
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
+func (h *RoomHandler) GetRoom(c *types.APIContext) error {
+	roomID := c.QueryParam("roomId")
+	if roomID == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "roomId 参数是必需的")
+	}
+
+	detail, err := h.roomService.GetRoom(c.Request().Context(), c.User.Did, roomID)
+	if err != nil {
+		return roomError("获取房间失败", err)
+	}
+	return c.JSON(http.StatusOK, detail)
+}
+
+func (h *RoomHandler) RenameRoom(c *types.APIContext) error {
+	var req roomRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "请求格式错误: "+err.Error())
+	}
+
+	if err := h.roomService.RenameRoom(c.Request().Context(), c.User.Did, req.RoomID, req.Title); err != nil {
+		return roomError("重命名房间失败", err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func (h *RoomHandler) ArchiveRoom(c *types.APIContext) error {
+	var req roomRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "请求格式错误: "+err.Error())
+	}
+
+	if err := h.roomService.ArchiveRoom(c.Request().Context(), c.User.Did, req.RoomID, req.Archived); err != nil {
+		return roomError("归档房间失败", err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func (h *RoomHandler) Invite(c *types.APIContext) error {
+	var req roomRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "请求格式错误: "+err.Error())
+	}
+
+	if err := h.roomService.Invite(c.Request().Context(), c.User.Did, req.RoomID, req.Did); err != nil {
+		return roomError("邀请成员失败", err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func (h *RoomHandler) Accept(c *types.APIContext) error {
+	var req roomRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "请求格式错误: "+err.Error())
+	}
+
+	if err := h.roomService.Accept(c.Request().Context(), c.User.Did, req.RoomID); err != nil {
+		return roomError("接受邀请失败", err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func (h *RoomHandler) Leave(c *types.APIContext) error {
+	var req roomRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "请求格式错误: "+err.Error())
+	}
+
+	if err := h.roomService.Leave(c.Request().Context(), c.User.Did, req.RoomID); err != nil {
+		return roomError("退出房间失败", err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func (h *RoomHandler) MarkRead(c *types.APIContext) error {
+	var req roomRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "请求格式错误: "+err.Error())
+	}
+
+	if err := h.roomService.MarkRead(c.Request().Context(), c.User.Did, req.RoomID); err != nil {
+		return roomError("标记已读失败", err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func (h *RoomHandler) ListThreads(c *types.APIContext) error {
+	roomID := c.QueryParam("roomId")
+	if roomID == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "roomId 参数是必需的")
+	}
+
+	threads, err := h.roomService.ListThreads(c.Request().Context(), c.User.Did, roomID)
+	if err != nil {
+		return roomError("获取话题列表失败", err)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"threads": threads})
+}
+
+func (h *RoomHandler) CreateThread(c *types.APIContext) error {
+	var req createThreadRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "请求格式错误: "+err.Error())
+	}
+
+	thread, err := h.roomService.CreateThread(c.Request().Context(), c.User.Did, req.RoomID, req.Title, req.ContextMode, req.ParentThreadID, req.RootMID)
+	if err != nil {
+		return roomError("创建话题失败", err)
+	}
+	return c.JSON(http.StatusOK, thread)
+}
+
+// ForkThread 从已有话题的某条消息处分叉出新话题
+func (h *RoomHandler) ForkThread(c *types.APIContext) error {
+	var req forkThreadRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "请求格式错误: "+err.Error())
+	}
+	if req.ThreadID == "" || req.MessageID == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "threadId 和 messageId 参数是必需的")
+	}
+
+	thread, err := h.roomService.ForkThread(c.Request().Context(), c.User.Did, req.ThreadID, req.MessageID, req.Title, req.ContextMode)
+	if err != nil {
+		return roomError("分叉话题失败", err)
+	}
+	return c.JSON(http.StatusOK, thread)
+}
+
+// SetMirror 开启或关闭房间的 PDS 同步, 开启后房间、话题和自己的消息写入自己的仓库
+func (h *RoomHandler) SetMirror(c *types.APIContext) error {
+	var req roomRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "请求格式错误: "+err.Error())
+	}
+
+	if err := h.mirrorService.SetMirror(c.Request().Context(), c.User.Did, req.RoomID, req.Enabled); err != nil {
+		return roomError("更新同步设置失败", err)
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// ImportFromPDS 从自己仓库中的 app.vtri.chat.* 记录重建本地聊天历史
+func (h *RoomHandler) ImportFromPDS(c *types.APIContext) error {
+	result, err := h.mirrorService.ImportFromPDS(c.Request().Context(), c.User.Did, c.OauthSession)
+	if err != nil {
+		return roomError("导入聊天记录失败", err)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+func roomError(message string, err error) error {
+	switch {
+	case errors.Is(err, services.ErrInvalidRoom), errors.Is(err, services.ErrInvalidCursor):
+		return echo.NewHTTPError(http.StatusBadRequest, message+": "+err.Error())
+	case errors.Is(err, services.ErrNoPDSSession):
+		return echo.NewHTTPError(http.StatusUnauthorized, message+": "+err.Error())
+	case errors.Is(err, services.ErrRoomNotFound), errors.Is(err, services.ErrThreadNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, message+": "+err.Error())
+	case errors.Is(err, services.ErrNotRoomMember), errors.Is(err, services.ErrRoomPermission),
+		errors.Is(err, services.ErrBlocked):
+		return echo.NewHTTPError(http.StatusForbidden, message+": "+err.Error())
+	case errors.Is(err, services.ErrRoomArchived), errors.Is(err, services.ErrAlreadyMember),
+		errors.Is(err, services.ErrNoPendingInvite):
+		return echo.NewHTTPError(http.StatusConflict, message+": "+err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, message+": "+err.Error())
+}
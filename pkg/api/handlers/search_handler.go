@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/search"
+	"github.com/zhongshangwu/avatarai-social/pkg/services"
+	"github.com/zhongshangwu/avatarai-social/types"
+)
+
+// 综合搜索每种类型默认返回的条数
+const defaultSectionLimit = 10
+
+// 路径中的类型名与索引类型的对应关系
+var searchTypes = map[string]string{
+	"moments":  search.KindMoment,
+	"users":    search.KindUser,
+	"tags":     search.KindTag,
+	"topics":   search.KindTopic,
+	"messages": search.KindMessage,
+}
+
+type SearchHandler struct {
+	config        *config.SocialConfig
+	metaStore     *repositories.MetaStore
+	searchService *services.SearchService
+}
+
+func NewSearchHandler(config *config.SocialConfig, metaStore *repositories.MetaStore) *SearchHandler {
+	return &SearchHandler{
+		config:        config,
+		metaStore:     metaStore,
+		searchService: services.NewSearchService(config, metaStore),
+	}
+}
+
+// Search 综合搜索, 每种类型返回第一页, 翻页使用 SearchType
+func (h *SearchHandler) Search(c *types.APIContext) error {
+	limit := defaultSectionLimit
+	if l, err := strconv.Atoi(c.QueryParam("limit")); err == nil && l > 0 && l <= 50 {
+		limit = l
+	}
+
+	results, err := h.searchService.Search(c.Request().Context(), &services.SearchQuery{
+		Viewer: c.ViewerDid(),
+		Text:   c.QueryParam("q"),
+		Limit:  limit,
+	})
+	if err != nil {
+		return searchError(err)
+	}
+	return c.JSON(http.StatusOK, results)
+}
+
+// SearchType 按类型搜索, 支持游标翻页. 聊天消息只在当前用户参与的房间内检索
+func (h *SearchHandler) SearchType(c *types.APIContext) error {
+	kind, ok := searchTypes[c.Param("type")]
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "搜索失败: "+services.ErrUnknownSearchType.Error())
+	}
+	limit, cursor := listParams(c)
+
+	results, err := h.searchService.Search(c.Request().Context(), &services.SearchQuery{
+		Viewer: c.ViewerDid(),
+		Text:   c.QueryParam("q"),
+		Kind:   kind,
+		Limit:  limit,
+		Cursor: cursor,
+	})
+	if err != nil {
+		return searchError(err)
+	}
+	return c.JSON(http.StatusOK, results)
+}
+
+func searchError(err error) error {
+	switch {
+	case errors.Is(err, services.ErrEmptySearchQuery), errors.Is(err, services.ErrUnknownSearchType), errors.Is(err, services.ErrInvalidCursor):
+		return echo.NewHTTPError(http.StatusBadRequest, "搜索失败: "+err.Error())
+	case errors.Is(err, search.ErrUnavailable):
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "搜索失败: "+err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, "搜索失败: "+err.Error())
+}
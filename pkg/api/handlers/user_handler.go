@@ -13,6 +13,7 @@ import (
 	"github.com/zhongshangwu/avatarai-social/pkg/atproto/vtri"
 	"github.com/zhongshangwu/avatarai-social/pkg/config"
 	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/services"
 	"github.com/zhongshangwu/avatarai-social/types"
 )
 
@@ -209,6 +210,7 @@ func (h *UserHandler) UpdateUserProfile(c *types.APIContext) error {
 				Message: "PDS 个人资料已更新，但本地数据库更新失败: " + err.Error(),
 			})
 		}
+		services.IndexUser(h.metaStore, user.Did)
 	}
 
 	return c.JSON(http.StatusOK, UpdateProfileResponse{
@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+)
+
+const AdminKeyHeader = "X-Admin-Key"
+
+// NewAdminKeyMiddleware 管理接口校验请求头中的 admin_key, 未配置 admin_key 时拒绝所有请求
+func NewAdminKeyMiddleware(config *config.SocialConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			adminKey := config.Server.AdminKey
+			if adminKey == "" {
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "管理接口未启用"})
+			}
+			key := c.Request().Header.Get(AdminKeyHeader)
+			if subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) != 1 {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "无效的管理密钥"})
+			}
+			return next(c)
+		}
+	}
+}
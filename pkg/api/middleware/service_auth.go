@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/zhongshangwu/avatarai-social/pkg/atproto"
+)
+
+// RequesterDIDKey 服务间认证通过后, 请求方 DID 保存在 echo.Context 中的键
+const RequesterDIDKey = "requesterDid"
+
+// NewServiceAuthMiddleware XRPC 接口的服务间认证. 未携带令牌的请求以匿名身份继续处理,
+// 携带令牌时必须由请求方 DID 的签名密钥签发, lxm 取自路由 /xrpc/<nsid>
+func NewServiceAuthMiddleware(validator *atproto.ServiceAuthValidator) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			header := c.Request().Header.Get("Authorization")
+			if header == "" {
+				return next(c)
+			}
+
+			token, ok := strings.CutPrefix(header, "Bearer ")
+			if !ok {
+				return c.JSON(http.StatusUnauthorized, map[string]string{
+					"error":   "AuthenticationRequired",
+					"message": "Authorization 头格式错误",
+				})
+			}
+
+			lxm := strings.TrimPrefix(c.Path(), "/xrpc/")
+			did, err := validator.Validate(c.Request().Context(), strings.TrimSpace(token), lxm)
+			if err != nil {
+				c.Logger().Warnf("服务间认证失败: %v", err)
+				return c.JSON(http.StatusUnauthorized, map[string]string{
+					"error":   "AuthenticationRequired",
+					"message": err.Error(),
+				})
+			}
+			c.Set(RequesterDIDKey, did.String())
+			return next(c)
+		}
+	}
+}
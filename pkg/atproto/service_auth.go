@@ -0,0 +1,195 @@
+package atproto
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	ErrServiceAuthMissing = errors.New("缺少服务间认证令牌")
+	ErrServiceAuthInvalid = errors.New("无效的服务间认证令牌")
+)
+
+// ServiceAuthClaims 服务间 JWT 的声明, iss 为请求方 DID, lxm 为授权调用的 XRPC 方法
+type ServiceAuthClaims struct {
+	jwt.RegisteredClaims
+	LexiconMethod string `json:"lxm,omitempty"`
+}
+
+// maxTrackedIssuers 记录解析时间的 DID 数量上限, 超过后清理已过刷新间隔的记录
+const maxTrackedIssuers = 10000
+
+// ServiceAuthValidator 校验其他服务 (如 AppView) 代用户签发的 JWT.
+// 签名使用请求方 DID 文档中的 atproto 签名密钥, 支持 ES256K 和 ES256
+type ServiceAuthValidator struct {
+	Audience  string // 本服务的 DID
+	Directory identity.Directory
+	Leeway    time.Duration
+	// KeyRefreshInterval 签名校验失败时, 只有距上次解析该 DID 超过此间隔才清除缓存重新解析,
+	// 避免伪造令牌让每个请求都去请求 PLC 或 did:web
+	KeyRefreshInterval time.Duration
+
+	mu         sync.Mutex
+	resolvedAt map[syntax.DID]time.Time
+	now        func() time.Time
+}
+
+func NewServiceAuthValidator(audience string, dir identity.Directory) *ServiceAuthValidator {
+	return &ServiceAuthValidator{
+		Audience:           audience,
+		Directory:          dir,
+		Leeway:             30 * time.Second,
+		KeyRefreshInterval: time.Minute,
+		resolvedAt:         make(map[syntax.DID]time.Time),
+	}
+}
+
+// Validate 校验令牌并返回请求方 DID. 令牌必须声明 lxm, 且与被调用的方法 lxm 一致
+func (v *ServiceAuthValidator) Validate(ctx context.Context, token string, lxm string) (syntax.DID, error) {
+	if token == "" {
+		return "", ErrServiceAuthMissing
+	}
+
+	// jwt 库没有注册 ES256K, 头部和声明在这里解码, 签名交给 atproto 密钥校验
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("%w: 格式错误", ErrServiceAuthInvalid)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", err
+	}
+	if header.Alg != "ES256K" && header.Alg != "ES256" {
+		return "", fmt.Errorf("%w: 不支持的签名算法 %s", ErrServiceAuthInvalid, header.Alg)
+	}
+	var claims ServiceAuthClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", err
+	}
+
+	now := v.currentTime()
+	if !claims.VerifyAudience(v.Audience, true) {
+		return "", fmt.Errorf("%w: aud 不匹配", ErrServiceAuthInvalid)
+	}
+	if !claims.VerifyExpiresAt(now.Add(-v.Leeway), true) {
+		return "", fmt.Errorf("%w: 令牌已过期", ErrServiceAuthInvalid)
+	}
+	if !claims.VerifyIssuedAt(now.Add(v.Leeway), false) {
+		return "", fmt.Errorf("%w: iat 晚于当前时间", ErrServiceAuthInvalid)
+	}
+	if claims.LexiconMethod == "" {
+		return "", fmt.Errorf("%w: 缺少 lxm", ErrServiceAuthInvalid)
+	}
+	if claims.LexiconMethod != lxm {
+		return "", fmt.Errorf("%w: lxm 不匹配", ErrServiceAuthInvalid)
+	}
+
+	// iss 可能带有服务标识, 例如 did:plc:xxx#atproto_labeler, 签名密钥仍取 DID 文档中的 atproto 密钥
+	issuer, _, _ := strings.Cut(claims.Issuer, "#")
+	did, err := syntax.ParseDID(issuer)
+	if err != nil {
+		return "", fmt.Errorf("%w: 无效的 iss", ErrServiceAuthInvalid)
+	}
+
+	signature, err := jwt.DecodeSegment(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrServiceAuthInvalid, err)
+	}
+	signingInput := []byte(parts[0] + "." + parts[1])
+
+	v.observe(did)
+	if err := v.verify(ctx, did, signingInput, signature); err != nil {
+		// 签名密钥可能已轮换, 缓存的密钥足够旧时才清除缓存重新解析一次
+		if !v.allowRefresh(did) {
+			return "", err
+		}
+		if purgeErr := v.Directory.Purge(ctx, did.AtIdentifier()); purgeErr != nil {
+			return "", err
+		}
+		if err := v.verify(ctx, did, signingInput, signature); err != nil {
+			return "", err
+		}
+	}
+	return did, nil
+}
+
+// observe 记录首次见到 did 的时间, 此时目录缓存中的密钥由本次校验解析得到
+func (v *ServiceAuthValidator) observe(did syntax.DID) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if _, ok := v.resolvedAt[did]; !ok {
+		v.trackLocked(did, v.currentTime())
+	}
+}
+
+// allowRefresh 判断 did 的缓存密钥是否已超过刷新间隔, 允许时记录本次重新解析的时间
+func (v *ServiceAuthValidator) allowRefresh(did syntax.DID) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := v.currentTime()
+	if last, ok := v.resolvedAt[did]; ok && now.Sub(last) < v.KeyRefreshInterval {
+		return false
+	}
+	v.trackLocked(did, now)
+	return true
+}
+
+func (v *ServiceAuthValidator) trackLocked(did syntax.DID, now time.Time) {
+	if v.resolvedAt == nil {
+		v.resolvedAt = make(map[syntax.DID]time.Time)
+	}
+	if len(v.resolvedAt) >= maxTrackedIssuers {
+		for tracked, at := range v.resolvedAt {
+			if now.Sub(at) >= v.KeyRefreshInterval {
+				delete(v.resolvedAt, tracked)
+			}
+		}
+	}
+	v.resolvedAt[did] = now
+}
+
+func (v *ServiceAuthValidator) currentTime() time.Time {
+	if v.now != nil {
+		return v.now()
+	}
+	return time.Now()
+}
+
+func decodeSegment(segment string, out interface{}) error {
+	raw, err := jwt.DecodeSegment(segment)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrServiceAuthInvalid, err)
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return fmt.Errorf("%w: %v", ErrServiceAuthInvalid, err)
+	}
+	return nil
+}
+
+func (v *ServiceAuthValidator) verify(ctx context.Context, did syntax.DID, signingInput []byte, signature []byte) error {
+	ident, err := v.Directory.LookupDID(ctx, did)
+	if err != nil {
+		return fmt.Errorf("解析请求方 DID 失败: %w", err)
+	}
+	pubKey, err := ident.PublicKey()
+	if err != nil {
+		return fmt.Errorf("%w: 请求方没有签名密钥: %v", ErrServiceAuthInvalid, err)
+	}
+	if err := pubKey.HashAndVerifyLenient(signingInput, signature); err != nil {
+		return fmt.Errorf("%w: 签名校验失败", ErrServiceAuthInvalid)
+	}
+	return nil
+}
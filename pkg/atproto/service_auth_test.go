@@ -0,0 +1,286 @@
+package atproto
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	atcrypto "github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+const (
+	testAudience = "did:web:feed.example.com"
+	testIssuer   = "did:plc:appview"
+	skeletonLxm  = "app.bsky.feed.getFeedSkeleton"
+)
+
+// fakeDirectory 模拟带缓存的 identity.Directory: cached 为缓存中的身份, published 为 DID 文档当前发布的身份
+type fakeDirectory struct {
+	mu        sync.Mutex
+	cached    map[syntax.DID]*identity.Identity
+	published map[syntax.DID]*identity.Identity
+	resolves  int
+	purges    int
+}
+
+func newFakeDirectory() *fakeDirectory {
+	return &fakeDirectory{
+		cached:    make(map[syntax.DID]*identity.Identity),
+		published: make(map[syntax.DID]*identity.Identity),
+	}
+}
+
+func (d *fakeDirectory) publish(t *testing.T, did string, key atcrypto.PrivateKey) {
+	t.Helper()
+	pub, err := key.PublicKey()
+	if err != nil {
+		t.Fatalf("public key: %v", err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.published[syntax.DID(did)] = &identity.Identity{
+		DID:  syntax.DID(did),
+		Keys: map[string]identity.Key{"atproto": {Type: "Multikey", PublicKeyMultibase: pub.Multibase()}},
+	}
+}
+
+func (d *fakeDirectory) LookupDID(ctx context.Context, did syntax.DID) (*identity.Identity, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if ident, ok := d.cached[did]; ok {
+		return ident, nil
+	}
+	d.resolves++
+	ident, ok := d.published[did]
+	if !ok {
+		return nil, identity.ErrDIDNotFound
+	}
+	d.cached[did] = ident
+	return ident, nil
+}
+
+func (d *fakeDirectory) LookupHandle(ctx context.Context, handle syntax.Handle) (*identity.Identity, error) {
+	return nil, identity.ErrHandleNotFound
+}
+
+func (d *fakeDirectory) Lookup(ctx context.Context, atid syntax.AtIdentifier) (*identity.Identity, error) {
+	did, err := atid.AsDID()
+	if err != nil {
+		return nil, err
+	}
+	return d.LookupDID(ctx, did)
+}
+
+func (d *fakeDirectory) Purge(ctx context.Context, atid syntax.AtIdentifier) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.purges++
+	if did, err := atid.AsDID(); err == nil {
+		delete(d.cached, did)
+	}
+	return nil
+}
+
+func (d *fakeDirectory) counts() (int, int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.resolves, d.purges
+}
+
+func signToken(t *testing.T, key atcrypto.PrivateKey, alg string, claims map[string]interface{}) string {
+	t.Helper()
+	encode := func(v interface{}) string {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(raw)
+	}
+	signingInput := encode(map[string]string{"alg": alg, "typ": "JWT"}) + "." + encode(claims)
+	signature, err := key.HashAndSign([]byte(signingInput))
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func testClaims(now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"iss": testIssuer,
+		"aud": testAudience,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Minute).Unix(),
+		"lxm": skeletonLxm,
+	}
+}
+
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestValidator(dir identity.Directory) (*ServiceAuthValidator, *testClock) {
+	clock := &testClock{now: time.Now()}
+	validator := NewServiceAuthValidator(testAudience, dir)
+	validator.now = clock.Now
+	return validator, clock
+}
+
+func TestServiceAuthValidatesClaims(t *testing.T) {
+	k256, err := atcrypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatalf("generate k256: %v", err)
+	}
+	p256, err := atcrypto.GeneratePrivateKeyP256()
+	if err != nil {
+		t.Fatalf("generate p256: %v", err)
+	}
+	dir := newFakeDirectory()
+	dir.publish(t, testIssuer, k256)
+	dir.publish(t, "did:plc:labeler", p256)
+	validator, clock := newTestValidator(dir)
+	now := clock.Now()
+
+	with := func(key string, value interface{}) map[string]interface{} {
+		claims := testClaims(now)
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+	tests := []struct {
+		name    string
+		key     atcrypto.PrivateKey
+		alg     string
+		claims  map[string]interface{}
+		wantDID string
+	}{
+		{name: "es256k", key: k256, alg: "ES256K", claims: testClaims(now), wantDID: testIssuer},
+		{name: "es256 with service fragment", key: p256, alg: "ES256", claims: with("iss", "did:plc:labeler#atproto_labeler"), wantDID: "did:plc:labeler"},
+		{name: "missing lxm", key: k256, alg: "ES256K", claims: with("lxm", nil)},
+		{name: "other lxm", key: k256, alg: "ES256K", claims: with("lxm", "app.bsky.feed.getTimeline")},
+		{name: "wrong audience", key: k256, alg: "ES256K", claims: with("aud", "did:web:other.example.com")},
+		{name: "expired", key: k256, alg: "ES256K", claims: with("exp", now.Add(-time.Hour).Unix())},
+		{name: "unsupported alg", key: k256, alg: "HS256", claims: testClaims(now)},
+		{name: "signed by another key", key: p256, alg: "ES256", claims: testClaims(now)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			did, err := validator.Validate(context.Background(), signToken(t, tt.key, tt.alg, tt.claims), skeletonLxm)
+			if tt.wantDID == "" {
+				if !errors.Is(err, ErrServiceAuthInvalid) {
+					t.Fatalf("err = %v, want ErrServiceAuthInvalid", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validate: %v", err)
+			}
+			if did.String() != tt.wantDID {
+				t.Errorf("did = %s, want %s", did, tt.wantDID)
+			}
+		})
+	}
+}
+
+func TestServiceAuthRateLimitsKeyRefresh(t *testing.T) {
+	oldKey, err := atcrypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	forged, err := atcrypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	dir := newFakeDirectory()
+	dir.publish(t, testIssuer, oldKey)
+	validator, clock := newTestValidator(dir)
+	ctx := context.Background()
+
+	if _, err := validator.Validate(ctx, signToken(t, oldKey, "ES256K", testClaims(clock.Now())), skeletonLxm); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+
+	// 刚解析过的密钥, 伪造令牌不会触发清除缓存和重新解析
+	for i := 0; i < 20; i++ {
+		if _, err := validator.Validate(ctx, signToken(t, forged, "ES256K", testClaims(clock.Now())), skeletonLxm); !errors.Is(err, ErrServiceAuthInvalid) {
+			t.Fatalf("forged token: err = %v, want ErrServiceAuthInvalid", err)
+		}
+	}
+	if resolves, purges := dir.counts(); resolves != 1 || purges != 0 {
+		t.Fatalf("resolves = %d, purges = %d, want the cached key reused", resolves, purges)
+	}
+
+	// 密钥轮换后, 缓存的旧密钥超过刷新间隔才重新解析, 且每个间隔至多一次
+	newKey, err := atcrypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	dir.publish(t, testIssuer, newKey)
+	if _, err := validator.Validate(ctx, signToken(t, newKey, "ES256K", testClaims(clock.Now())), skeletonLxm); err == nil {
+		t.Fatal("rotated key accepted before the refresh interval")
+	}
+	clock.Advance(validator.KeyRefreshInterval)
+	if _, err := validator.Validate(ctx, signToken(t, newKey, "ES256K", testClaims(clock.Now())), skeletonLxm); err != nil {
+		t.Fatalf("rotated key: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		validator.Validate(ctx, signToken(t, forged, "ES256K", testClaims(clock.Now())), skeletonLxm)
+	}
+	if resolves, purges := dir.counts(); resolves != 2 || purges != 1 {
+		t.Errorf("resolves = %d, purges = %d, want one refresh per interval", resolves, purges)
+	}
+}
+
+func TestServiceAuthResolvesUnknownIssuersOnce(t *testing.T) {
+	forged, err := atcrypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	dir := newFakeDirectory()
+	for _, did := range []string{"did:plc:victim1", "did:plc:victim2"} {
+		other, err := atcrypto.GeneratePrivateKeyK256()
+		if err != nil {
+			t.Fatalf("generate key: %v", err)
+		}
+		dir.publish(t, did, other)
+	}
+	validator, clock := newTestValidator(dir)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			claims := testClaims(clock.Now())
+			claims["iss"] = []string{"did:plc:victim1", "did:plc:victim2"}[i%2]
+			if _, err := validator.Validate(context.Background(), signToken(t, forged, "ES256K", claims), skeletonLxm); err == nil {
+				t.Error("forged token accepted")
+			}
+		}(i)
+	}
+	wg.Wait()
+	if resolves, purges := dir.counts(); resolves != 2 || purges != 0 {
+		t.Errorf("resolves = %d, purges = %d, want each issuer resolved once", resolves, purges)
+	}
+}
@@ -6568,7 +6568,7 @@ func (t *ChatMessage) MarshalCBOR(w io.Writer) error {
 	}
 
 	cw := cbg.NewCborWriter(w)
-	fieldCount := 14
+	fieldCount := 15
 
 	if t.Deleted == nil {
 		fieldCount--
@@ -6582,6 +6582,10 @@ func (t *ChatMessage) MarshalCBOR(w io.Writer) error {
 		fieldCount--
 	}
 
+	if t.ReceiverId == nil {
+		fieldCount--
+	}
+
 	if t.RootId == nil {
 		fieldCount--
 	}
@@ -6946,6 +6950,37 @@ func (t *ChatMessage) MarshalCBOR(w io.Writer) error {
 		}
 	}
 
+	// t.ReceiverId (string) (string)
+	if t.ReceiverId != nil {
+
+		if len("receiverId") > 1000000 {
+			return xerrors.Errorf("Value in field \"receiverId\" was too long")
+		}
+
+		if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("receiverId"))); err != nil {
+			return err
+		}
+		if _, err := cw.WriteString(string("receiverId")); err != nil {
+			return err
+		}
+
+		if t.ReceiverId == nil {
+			if _, err := cw.Write(cbg.CborNull); err != nil {
+				return err
+			}
+		} else {
+			if len(*t.ReceiverId) > 1000000 {
+				return xerrors.Errorf("Value in field t.ReceiverId was too long")
+			}
+
+			if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(*t.ReceiverId))); err != nil {
+				return err
+			}
+			if _, err := cw.WriteString(string(*t.ReceiverId)); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
@@ -6974,7 +7009,7 @@ func (t *ChatMessage) UnmarshalCBOR(r io.Reader) (err error) {
 
 	n := extra
 
-	nameBuf := make([]byte, 9)
+	nameBuf := make([]byte, 10)
 	for i := uint64(0); i < n; i++ {
 		nameLen, ok, err := cbg.ReadFullStringIntoBuf(cr, nameBuf, 1000000)
 		if err != nil {
@@ -7266,6 +7301,27 @@ func (t *ChatMessage) UnmarshalCBOR(r io.Reader) (err error) {
 
 				t.UpdatedAt = int64(extraI)
 			}
+			// t.ReceiverId (string) (string)
+		case "receiverId":
+
+			{
+				b, err := cr.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := cr.UnreadByte(); err != nil {
+						return err
+					}
+
+					sval, err := cbg.ReadStringWithMax(cr, 1000000)
+					if err != nil {
+						return err
+					}
+
+					t.ReceiverId = (*string)(&sval)
+				}
+			}
 
 		default:
 			// Field doesn't exist on this type, so ignore it
@@ -16676,12 +16732,24 @@ func (t *ChatThread) MarshalCBOR(w io.Writer) error {
 	}
 
 	cw := cbg.NewCborWriter(w)
-	fieldCount := 7
+	fieldCount := 10
 
 	if t.Deleted == nil {
 		fieldCount--
 	}
 
+	if t.ParentThreadId == nil {
+		fieldCount--
+	}
+
+	if t.RoomId == nil {
+		fieldCount--
+	}
+
+	if t.RootMid == nil {
+		fieldCount--
+	}
+
 	if _, err := cw.Write(cbg.CborEncodeMajorType(cbg.MajMap, uint64(fieldCount))); err != nil {
 		return err
 	}
@@ -16774,6 +16842,38 @@ func (t *ChatThread) MarshalCBOR(w io.Writer) error {
 		return err
 	}
 
+	// t.RoomId (string) (string)
+	if t.RoomId != nil {
+
+		if len("roomId") > 1000000 {
+			return xerrors.Errorf("Value in field \"roomId\" was too long")
+		}
+
+		if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("roomId"))); err != nil {
+			return err
+		}
+		if _, err := cw.WriteString(string("roomId")); err != nil {
+			return err
+		}
+
+		if t.RoomId == nil {
+			if _, err := cw.Write(cbg.CborNull); err != nil {
+				return err
+			}
+		} else {
+			if len(*t.RoomId) > 1000000 {
+				return xerrors.Errorf("Value in field t.RoomId was too long")
+			}
+
+			if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(*t.RoomId))); err != nil {
+				return err
+			}
+			if _, err := cw.WriteString(string(*t.RoomId)); err != nil {
+				return err
+			}
+		}
+	}
+
 	// t.Deleted (bool) (bool)
 	if t.Deleted != nil {
 
@@ -16799,6 +16899,38 @@ func (t *ChatThread) MarshalCBOR(w io.Writer) error {
 		}
 	}
 
+	// t.RootMid (string) (string)
+	if t.RootMid != nil {
+
+		if len("rootMid") > 1000000 {
+			return xerrors.Errorf("Value in field \"rootMid\" was too long")
+		}
+
+		if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("rootMid"))); err != nil {
+			return err
+		}
+		if _, err := cw.WriteString(string("rootMid")); err != nil {
+			return err
+		}
+
+		if t.RootMid == nil {
+			if _, err := cw.Write(cbg.CborNull); err != nil {
+				return err
+			}
+		} else {
+			if len(*t.RootMid) > 1000000 {
+				return xerrors.Errorf("Value in field t.RootMid was too long")
+			}
+
+			if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(*t.RootMid))); err != nil {
+				return err
+			}
+			if _, err := cw.WriteString(string(*t.RootMid)); err != nil {
+				return err
+			}
+		}
+	}
+
 	// t.CreatedAt (string) (string)
 	if len("createdAt") > 1000000 {
 		return xerrors.Errorf("Value in field \"createdAt\" was too long")
@@ -16844,6 +16976,38 @@ func (t *ChatThread) MarshalCBOR(w io.Writer) error {
 	if _, err := cw.WriteString(string(t.UpdatedAt)); err != nil {
 		return err
 	}
+
+	// t.ParentThreadId (string) (string)
+	if t.ParentThreadId != nil {
+
+		if len("parentThreadId") > 1000000 {
+			return xerrors.Errorf("Value in field \"parentThreadId\" was too long")
+		}
+
+		if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("parentThreadId"))); err != nil {
+			return err
+		}
+		if _, err := cw.WriteString(string("parentThreadId")); err != nil {
+			return err
+		}
+
+		if t.ParentThreadId == nil {
+			if _, err := cw.Write(cbg.CborNull); err != nil {
+				return err
+			}
+		} else {
+			if len(*t.ParentThreadId) > 1000000 {
+				return xerrors.Errorf("Value in field t.ParentThreadId was too long")
+			}
+
+			if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(*t.ParentThreadId))); err != nil {
+				return err
+			}
+			if _, err := cw.WriteString(string(*t.ParentThreadId)); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
@@ -16872,7 +17036,7 @@ func (t *ChatThread) UnmarshalCBOR(r io.Reader) (err error) {
 
 	n := extra
 
-	nameBuf := make([]byte, 9)
+	nameBuf := make([]byte, 14)
 	for i := uint64(0); i < n; i++ {
 		nameLen, ok, err := cbg.ReadFullStringIntoBuf(cr, nameBuf, 1000000)
 		if err != nil {
@@ -16932,6 +17096,27 @@ func (t *ChatThread) UnmarshalCBOR(r io.Reader) (err error) {
 
 				t.Title = string(sval)
 			}
+			// t.RoomId (string) (string)
+		case "roomId":
+
+			{
+				b, err := cr.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := cr.UnreadByte(); err != nil {
+						return err
+					}
+
+					sval, err := cbg.ReadStringWithMax(cr, 1000000)
+					if err != nil {
+						return err
+					}
+
+					t.RoomId = (*string)(&sval)
+				}
+			}
 			// t.Deleted (bool) (bool)
 		case "deleted":
 
@@ -16965,6 +17150,27 @@ func (t *ChatThread) UnmarshalCBOR(r io.Reader) (err error) {
 					t.Deleted = &val
 				}
 			}
+			// t.RootMid (string) (string)
+		case "rootMid":
+
+			{
+				b, err := cr.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := cr.UnreadByte(); err != nil {
+						return err
+					}
+
+					sval, err := cbg.ReadStringWithMax(cr, 1000000)
+					if err != nil {
+						return err
+					}
+
+					t.RootMid = (*string)(&sval)
+				}
+			}
 			// t.CreatedAt (string) (string)
 		case "createdAt":
 
@@ -16987,6 +17193,27 @@ func (t *ChatThread) UnmarshalCBOR(r io.Reader) (err error) {
 
 				t.UpdatedAt = string(sval)
 			}
+			// t.ParentThreadId (string) (string)
+		case "parentThreadId":
+
+			{
+				b, err := cr.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := cr.UnreadByte(); err != nil {
+						return err
+					}
+
+					sval, err := cbg.ReadStringWithMax(cr, 1000000)
+					if err != nil {
+						return err
+					}
+
+					t.ParentThreadId = (*string)(&sval)
+				}
+			}
 
 		default:
 			// Field doesn't exist on this type, so ignore it
@@ -17005,12 +17232,16 @@ func (t *ChatRoom) MarshalCBOR(w io.Writer) error {
 	}
 
 	cw := cbg.NewCborWriter(w)
-	fieldCount := 6
+	fieldCount := 7
 
 	if t.Deleted == nil {
 		fieldCount--
 	}
 
+	if t.Type == nil {
+		fieldCount--
+	}
+
 	if _, err := cw.Write(cbg.CborEncodeMajorType(cbg.MajMap, uint64(fieldCount))); err != nil {
 		return err
 	}
@@ -17038,6 +17269,38 @@ func (t *ChatRoom) MarshalCBOR(w io.Writer) error {
 		return err
 	}
 
+	// t.Type (string) (string)
+	if t.Type != nil {
+
+		if len("type") > 1000000 {
+			return xerrors.Errorf("Value in field \"type\" was too long")
+		}
+
+		if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len("type"))); err != nil {
+			return err
+		}
+		if _, err := cw.WriteString(string("type")); err != nil {
+			return err
+		}
+
+		if t.Type == nil {
+			if _, err := cw.Write(cbg.CborNull); err != nil {
+				return err
+			}
+		} else {
+			if len(*t.Type) > 1000000 {
+				return xerrors.Errorf("Value in field t.Type was too long")
+			}
+
+			if err := cw.WriteMajorTypeHeader(cbg.MajTextString, uint64(len(*t.Type))); err != nil {
+				return err
+			}
+			if _, err := cw.WriteString(string(*t.Type)); err != nil {
+				return err
+			}
+		}
+	}
+
 	// t.LexiconTypeID (string) (string)
 	if len("$type") > 1000000 {
 		return xerrors.Errorf("Value in field \"$type\" was too long")
@@ -17205,6 +17468,27 @@ func (t *ChatRoom) UnmarshalCBOR(r io.Reader) (err error) {
 
 				t.Id = string(sval)
 			}
+			// t.Type (string) (string)
+		case "type":
+
+			{
+				b, err := cr.ReadByte()
+				if err != nil {
+					return err
+				}
+				if b != cbg.CborNull[0] {
+					if err := cr.UnreadByte(); err != nil {
+						return err
+					}
+
+					sval, err := cbg.ReadStringWithMax(cr, 1000000)
+					if err != nil {
+						return err
+					}
+
+					t.Type = (*string)(&sval)
+				}
+			}
 			// t.LexiconTypeID (string) (string)
 		case "$type":
 
@@ -28,6 +28,8 @@ type ChatMessage struct {
 	ParentId *string `json:"parentId,omitempty" cborgen:"parentId,omitempty"`
 	// quoteId: 被引用消息的ID，用于实现消息引用功能
 	QuoteId *string `json:"quoteId,omitempty" cborgen:"quoteId,omitempty"`
+	// receiverId: 消息接收者的唯一标识符，AI 对话中为回复消息的 Aster
+	ReceiverId *string `json:"receiverId,omitempty" cborgen:"receiverId,omitempty"`
 	// roomId: 消息所属聊天室的唯一标识符，表示消息的发送位置
 	RoomId string `json:"roomId" cborgen:"roomId"`
 	// rootId: 对于嵌套回复，指向最顶层消息的ID，用于构建完整的消息树结构
@@ -22,6 +22,8 @@ type ChatRoom struct {
 	Id string `json:"id" cborgen:"id"`
 	// title: 话题标题
 	Title string `json:"title" cborgen:"title"`
+	// type: 房间类型: 单聊/群聊/AI 对话
+	Type *string `json:"type,omitempty" cborgen:"type,omitempty"`
 	// updatedAt: 更新时间
 	UpdatedAt string `json:"updatedAt" cborgen:"updatedAt"`
 }
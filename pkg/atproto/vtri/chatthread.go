@@ -20,6 +20,12 @@ type ChatThread struct {
 	Deleted *bool `json:"deleted,omitempty" cborgen:"deleted,omitempty"`
 	// id: 话题ID
 	Id string `json:"id" cborgen:"id"`
+	// parentThreadId: 分叉来源的父话题ID
+	ParentThreadId *string `json:"parentThreadId,omitempty" cborgen:"parentThreadId,omitempty"`
+	// roomId: 话题所属的房间ID
+	RoomId *string `json:"roomId,omitempty" cborgen:"roomId,omitempty"`
+	// rootMid: 父话题中分叉点的消息ID
+	RootMid *string `json:"rootMid,omitempty" cborgen:"rootMid,omitempty"`
 	// title: 话题标题
 	Title string `json:"title" cborgen:"title"`
 	// type: 话题类型: 连续上下文/独立上下文
@@ -9,6 +9,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/zhongshangwu/avatarai-social/pkg/communication/memory"
 	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/providers/llm"
 	"github.com/zhongshangwu/avatarai-social/pkg/streams"
 )
 
@@ -16,13 +17,19 @@ type ChatInvokeContext struct {
 	Context     context.Context
 	ControlChan chan CtrlType
 
-	Memory     memory.Memory
-	InputItems []messages.InputItem
+	Memory         memory.Memory
+	LongTermMemory memory.Memory // 跨会话的语义记忆, 可为空
+	InputItems     []messages.InputItem
+
+	RouteRequest *llm.RouteRequest // 模型路由条件
+	Route        *llm.ModelRoute   // 本次回复使用的模型路由, 降级后会去掉失败的模型
 
 	Response             *messages.AgentMessage
 	CurrentOutputItemIdx int
 	CurrentOutputMessage *messages.OutputMessage
 	CurrentTextContent   *messages.OutputTextContent
+	CurrentToolCalls     map[int]*ToolCallState // 当前轮次的工具调用, 按 LLM 输出的 Index 索引
+	turnUsage            *llm.Usage             // 当前轮次最近一次上报的用量
 
 	Stream *streams.Stream[*messages.ChatEvent]
 	mu     sync.RWMutex
@@ -32,10 +39,11 @@ func NewChatInvokeContext(
 	ctx context.Context,
 ) *ChatInvokeContext {
 	return &ChatInvokeContext{
-		Context:     ctx,
-		ControlChan: make(chan CtrlType, 10), // 增加缓冲区大小
-		Stream:      streams.NewStream[*messages.ChatEvent](ctx, 100),
-		mu:          sync.RWMutex{},
+		Context:          ctx,
+		ControlChan:      make(chan CtrlType, 10), // 增加缓冲区大小
+		CurrentToolCalls: make(map[int]*ToolCallState),
+		Stream:           streams.NewStream[*messages.ChatEvent](ctx, 100),
+		mu:               sync.RWMutex{},
 	}
 }
 
@@ -51,11 +59,41 @@ func (c *ChatInvokeContext) WithMemory(memory memory.Memory) *ChatInvokeContext
 	return c
 }
 
+func (c *ChatInvokeContext) WithLongTermMemory(memory memory.Memory) *ChatInvokeContext {
+	c.LongTermMemory = memory
+	return c
+}
+
 func (c *ChatInvokeContext) WithAgentMessage(message *messages.AgentMessage) *ChatInvokeContext {
 	c.Response = message
 	return c
 }
 
+func (c *ChatInvokeContext) WithRouteRequest(req *llm.RouteRequest) *ChatInvokeContext {
+	c.RouteRequest = req
+	return c
+}
+
+// setMetadata 以写时复制的方式更新 Response.Metadata, 已发出的事件仍引用旧的 map
+func (c *ChatInvokeContext) setMetadata(values map[string]interface{}) {
+	metadata := make(map[string]interface{}, len(c.Response.Metadata)+len(values))
+	for key, value := range c.Response.Metadata {
+		metadata[key] = value
+	}
+	for key, value := range values {
+		metadata[key] = value
+	}
+	c.Response.Metadata = metadata
+}
+
+// resetTurn 清理当前轮次的输出状态, 下一轮 LLM 输出将创建新的输出项
+func (c *ChatInvokeContext) resetTurn() {
+	c.CurrentOutputMessage = nil
+	c.CurrentTextContent = nil
+	c.CurrentToolCalls = make(map[int]*ToolCallState)
+	c.turnUsage = nil
+}
+
 func (c *ChatInvokeContext) send(event *messages.ChatEvent) error {
 	logrus.Infof("ChatInvokeContext 尝试发送事件 [%s] 类型: %s", event.EventID, event.EventType)
 	err := c.Stream.Send(event)
@@ -3,32 +3,47 @@ package agents
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"github.com/zhongshangwu/avatarai-social/pkg/communication/memory"
-	"github.com/zhongshangwu/avatarai-social/pkg/communication/memory/converters"
 	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
 	"github.com/zhongshangwu/avatarai-social/pkg/communication/prompt"
 	"github.com/zhongshangwu/avatarai-social/pkg/providers/llm"
 	"github.com/zhongshangwu/avatarai-social/pkg/streams"
 )
 
+// DefaultMaxSteps 单次回复中 LLM 调用工具的默认最大轮数
+const DefaultMaxSteps = 10
+
 type ChatRunner struct {
 	*BaseRunner
-	LLMManager *llm.ModelManager
+	LLMManager     *llm.ModelManager
+	ToolEngine     *ToolEngine
+	ContextBuilder *ContextBuilder
+	MaxSteps       int
 
 	runnings sync.Map
 }
 
 func NewChatRunner(
 	llmManager *llm.ModelManager,
+	maxSteps int,
 ) *ChatRunner {
+	if maxSteps <= 0 {
+		maxSteps = DefaultMaxSteps
+	}
 	return &ChatRunner{
-		BaseRunner: NewBaseRunner("ChatRunner", "处理 AI 聊天消息的智能体"),
-		LLMManager: llmManager,
+		BaseRunner:     NewBaseRunner("ChatRunner", "处理 AI 聊天消息的智能体"),
+		LLMManager:     llmManager,
+		ToolEngine:     NewToolEngine(llmManager),
+		ContextBuilder: NewContextBuilder(),
+		MaxSteps:       maxSteps,
 	}
 }
 
@@ -76,6 +91,12 @@ func (a *ChatRunner) _invoke(ctx *ChatInvokeContext) error {
 	a.runnings.Store(responseID, ctx)
 	defer a.runnings.Delete(responseID)
 
+	ctx.Route = a.LLMManager.ResolveRoute(ctx.RouteRequest)
+	ctx.setMetadata(map[string]interface{}{
+		"route": ctx.Route.Name,
+		"model": ctx.Route.Primary(),
+	})
+
 	if err := ctx.sendAIChatCreated(ctx.Response); err != nil {
 		logrus.Errorf("发送创建事件失败: %v", err)
 		return err
@@ -100,16 +121,16 @@ func (a *ChatRunner) _invoke(ctx *ChatInvokeContext) error {
 		return ctx.sendAIChatFailed(ctx.Response, "memory_error", "获取记忆失败: "+err.Error())
 	}
 
-	var promptMessages []*llm.PromptMessage
-
-	for _, chunk := range chunks {
-		p := converters.ChunkToLLM(chunk)
-		logrus.Infof("promptMessages: %+v", p)
-		promptMessages = append(promptMessages, p)
-	}
 	transformer := &prompt.LLMEntitiesTransform{}
 	tools := transformer.TransformTools(ctx.Response.Tools)
 
+	var prefix []*llm.PromptMessage
+	if recalled := a.recallMemories(ctx, chunks); recalled != nil {
+		prefix = append(prefix, recalled)
+	}
+	promptMessages, stats := a.ContextBuilder.Build(ctx.Context, ctx.Route, prefix, chunks, tools)
+	ctx.setMetadata(map[string]interface{}{"context": stats})
+
 	if err := a.processLLMInteraction(ctx, promptMessages, tools); err != nil {
 		logrus.Errorf("处理 LLM 交互失败: %v", err)
 		return ctx.sendAIChatFailed(ctx.Response, "llm_error", "LLM 交互失败: "+err.Error())
@@ -118,7 +139,129 @@ func (a *ChatRunner) _invoke(ctx *ChatInvokeContext) error {
 	return nil
 }
 
+// recallMemories 从长期记忆中召回与当前消息相关的历史片段, 组装为一条 system 消息
+// 已经在当前会话上下文中的消息不会重复召回
+func (a *ChatRunner) recallMemories(ctx *ChatInvokeContext, contextChunks []memory.Chunk) *llm.PromptMessage {
+	if ctx.LongTermMemory == nil {
+		return nil
+	}
+
+	recalled, err := ctx.LongTermMemory.Retrieve(&memory.MessageChunk{
+		ID:       ctx.Response.MessageID,
+		Metadata: map[string]interface{}{"text": latestUserText(contextChunks)},
+	})
+	if err != nil {
+		logrus.Errorf("召回长期记忆失败: %v", err)
+		return nil
+	}
+
+	inContext := make(map[string]bool, len(contextChunks))
+	for _, chunk := range contextChunks {
+		inContext[chunk.GetID()] = true
+	}
+
+	var lines []string
+	for _, chunk := range recalled {
+		messageChunk, ok := chunk.(*memory.MessageChunk)
+		if !ok || inContext[messageChunk.ID] {
+			continue
+		}
+		text := memory.ChunkText(messageChunk)
+		if text == "" {
+			continue
+		}
+		role := "用户"
+		if messageChunk.Content != nil && messageChunk.Content.MsgType == messages.MessageTypeAgent {
+			role = "你"
+		}
+		lines = append(lines, fmt.Sprintf("- %s: %s", role, text))
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	logrus.Infof("召回 %d 条长期记忆", len(lines))
+	return llm.NewSystemPromptMessage("以下是与当前对话相关的历史记忆, 仅在相关时参考:\n"+strings.Join(lines, "\n"), "").PromptMessage
+}
+
+// latestUserText 当前会话中最后一条非 AI 消息的文本, 作为召回查询
+func latestUserText(chunks []memory.Chunk) string {
+	for i := len(chunks) - 1; i >= 0; i-- {
+		messageChunk, ok := chunks[i].(*memory.MessageChunk)
+		if !ok || messageChunk.Content == nil || messageChunk.Content.MsgType == messages.MessageTypeAgent {
+			continue
+		}
+		if text := memory.ChunkText(messageChunk); text != "" {
+			return text
+		}
+	}
+	return ""
+}
+
+// processLLMInteraction 执行 agent 循环: 调用 LLM, 执行其请求的工具并将结果回填, 直到 LLM 不再调用工具或达到最大轮数
 func (a *ChatRunner) processLLMInteraction(ctx *ChatInvokeContext, promptMessages []*llm.PromptMessage, tools []llm.PromptMessageTool) error {
+	for step := 1; step <= a.MaxSteps; step++ {
+		logrus.Infof("开始第 %d 轮 LLM 调用", step)
+
+		finished, err := a.processLLMTurn(ctx, promptMessages, tools)
+		if finished || err != nil {
+			return err
+		}
+
+		toolCallStates, err := a.ToolEngine.FinalizeToolCalls(ctx)
+		if err != nil {
+			return err
+		}
+		if err := a.finalizeAllOutputItems(ctx); err != nil {
+			return err
+		}
+
+		if len(toolCallStates) == 0 {
+			return ctx.sendAIChatCompleted(ctx.Response)
+		}
+
+		// 将本轮的 assistant 消息 (文本 + 工具调用) 和工具结果追加到上下文中
+		assistantText := ""
+		if ctx.CurrentTextContent != nil {
+			assistantText = ctx.CurrentTextContent.Text
+		}
+		toolCalls := make([]llm.ToolCall, 0, len(toolCallStates))
+		for i, state := range toolCallStates {
+			toolCalls = append(toolCalls, llm.ToolCall{
+				Index: i,
+				ID:    state.Item.ID,
+				Type:  "function",
+				Function: llm.ToolCallFunction{
+					Name:      state.Item.Name,
+					Arguments: state.Item.Arguments,
+				},
+			})
+		}
+		promptMessages = append(promptMessages, llm.NewAssistantPromptMessage(assistantText, "", toolCalls).PromptMessage)
+
+		toolMessages, interrupted, err := a.ToolEngine.ExecuteToolCalls(ctx, toolCallStates)
+		if err != nil {
+			return err
+		}
+		if interrupted {
+			return a.handleManuallyInterrupt(ctx)
+		}
+		promptMessages = append(promptMessages, toolMessages...)
+
+		ctx.resetTurn()
+	}
+
+	logrus.Warnf("达到最大工具调用轮数 %d，停止处理", a.MaxSteps)
+	ctx.Response.Status = messages.AgentMessageStatusIncomplete
+	ctx.Response.InterruptType = int32(messages.InterruptTypeSystem)
+	ctx.Response.IncompleteDetails = &messages.IncompleteDetails{
+		Reason: messages.IncompleteReasonMaxSteps,
+	}
+	return ctx.sendAIChatIncomplete(ctx.Response)
+}
+
+// processLLMTurn 流式处理一轮 LLM 输出, finished 为 true 表示响应已经以中断或失败结束
+func (a *ChatRunner) processLLMTurn(ctx *ChatInvokeContext, promptMessages []*llm.PromptMessage, tools []llm.PromptMessageTool) (bool, error) {
 	modelParameters := map[string]interface{}{
 		"temperature": 0.7,
 	}
@@ -129,9 +272,9 @@ func (a *ChatRunner) processLLMInteraction(ctx *ChatInvokeContext, promptMessage
 	llmCtx, cancel := context.WithTimeout(ctx.Context, 5*time.Minute)
 	defer cancel()
 
-	chatStream, err := a.LLMManager.ChatStream(llmCtx, promptMessages, modelParameters, tools, nil)
+	chatStream, err := a.LLMManager.ChatStreamWithRoute(llmCtx, ctx.Route, promptMessages, modelParameters, tools, nil)
 	if err != nil {
-		return ctx.sendAIChatFailed(ctx.Response, messages.ResponseErrorCodeServerError, "LLM 请求失败: "+err.Error())
+		return true, ctx.sendAIChatFailed(ctx.Response, messages.ResponseErrorCodeServerError, "LLM 请求失败: "+err.Error())
 	}
 
 	// FIXME: 将 Recv 改成 non-blocking 模式
@@ -142,7 +285,7 @@ func (a *ChatRunner) processLLMInteraction(ctx *ChatInvokeContext, promptMessage
 			switch ctrlType {
 			case CtrlTypeInterrupt:
 				logrus.Info("收到中断信号，停止流处理")
-				return a.handleManuallyInterrupt(ctx)
+				return true, a.handleManuallyInterrupt(ctx)
 			default:
 				logrus.Warnf("未知的控制事件类型: %s", ctrlType)
 			}
@@ -151,17 +294,25 @@ func (a *ChatRunner) processLLMInteraction(ctx *ChatInvokeContext, promptMessage
 
 			if result.HasData {
 				chunk := result.Data
+				if chunk.Fallback != nil {
+					if err := a.handleFallback(ctx, chunk.Fallback); err != nil {
+						return true, a.handleServerInterrupt(ctx, messages.ResponseErrorCodeServerError, "处理模型降级失败: "+err.Error())
+					}
+					continue
+				}
 				if err := a.processChunk(ctx, chunk); err != nil {
 					logrus.Errorf("处理块失败: %v", err)
-					return a.handleServerInterrupt(ctx, messages.ResponseErrorCodeServerError, "处理块失败: "+err.Error())
+					return true, a.handleServerInterrupt(ctx, messages.ResponseErrorCodeServerError, "处理块失败: "+err.Error())
 				}
 
-				if chunk.Delta.FinishReason != "" && chunk.Delta.FinishReason != "stop" {
-					logrus.Infof("收到完成原因: %s", chunk.Delta.FinishReason)
+				finishReason := chunk.Delta.FinishReason
+				if finishReason != "" && finishReason != "stop" && finishReason != "tool_calls" {
+					logrus.Infof("收到完成原因: %s", finishReason)
 
-					if err := a.handleFinishReason(ctx, chunk.Delta.FinishReason); err != nil {
-						return err
+					if err := a.handleFinishReason(ctx, finishReason); err != nil {
+						return true, err
 					}
+					return true, nil
 				}
 				continue
 			}
@@ -172,46 +323,97 @@ func (a *ChatRunner) processLLMInteraction(ctx *ChatInvokeContext, promptMessage
 				if result.Error != nil {
 					if errors.Is(result.Error, streams.ErrContextAlreadyDone) || errors.Is(result.Error, streams.ErrChannelClosed) {
 						logrus.Infof("流已关闭或上下文已取消: %v", result.Error)
-						return a.handleServerInterrupt(ctx, messages.ResponseErrorCodeServerError, "流已关闭或上下文已取消")
+						return true, a.handleServerInterrupt(ctx, messages.ResponseErrorCodeServerError, "流已关闭或上下文已取消")
 					}
 					logrus.Errorf("接收流数据错误: %v", result.Error)
-					return a.handleServerInterrupt(ctx, messages.ResponseErrorCodeServerError, "接收流数据错误: "+result.Error.Error())
-				}
-
-				logrus.Info("流已完成，开始处理完成事件")
-				if err := a.finalizeAllOutputItems(ctx); err != nil {
-					return err
+					return true, a.handleServerInterrupt(ctx, messages.ResponseErrorCodeServerError, "接收流数据错误: "+result.Error.Error())
 				}
-				return ctx.sendAIChatCompleted(ctx.Response)
+				return false, nil
 			}
 		}
 	}
 }
 
+// handleFallback 记录模型降级, 并丢弃失败模型在本轮已输出的部分内容
+func (a *ChatRunner) handleFallback(ctx *ChatInvokeContext, hop *llm.FallbackHop) error {
+	logrus.Warnf("模型降级: %s -> %s, 原因: %s", hop.From.Name, hop.To.Name, hop.Reason)
+
+	var fallbacks []llm.FallbackHop
+	if previous, ok := ctx.Response.Metadata["fallbacks"].([]llm.FallbackHop); ok {
+		fallbacks = append(fallbacks, previous...)
+	}
+	fallbacks = append(fallbacks, *hop)
+	ctx.setMetadata(map[string]interface{}{
+		"model":     hop.To,
+		"fallbacks": fallbacks,
+	})
+	ctx.Route = ctx.Route.Without(hop.From)
+
+	if ctx.CurrentOutputMessage != nil {
+		ctx.CurrentOutputMessage.Status = "incomplete"
+		if err := ctx.sendOutputItemDone(ctx.CurrentOutputItemIdx, ctx.CurrentOutputMessage); err != nil {
+			return err
+		}
+	}
+
+	indexes := make([]int, 0, len(ctx.CurrentToolCalls))
+	for index := range ctx.CurrentToolCalls {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		state := ctx.CurrentToolCalls[index]
+		state.Item.Status = string(messages.ToolCallStatusIncomplete)
+		if err := ctx.sendOutputItemDone(state.OutputIndex, state.Item); err != nil {
+			return err
+		}
+	}
+
+	ctx.resetTurn()
+	return nil
+}
+
 func (a *ChatRunner) processChunk(ctx *ChatInvokeContext, chunk *llm.LLMResultChunk) error {
 	delta := chunk.Delta
 	message := delta.Message
 
-	if content, ok := message.Content.(string); ok && content != "" {
-		return a.handleTextContent(ctx, content)
+	if chunk.Delta.Usage != nil {
+		a.accumulateUsage(ctx, chunk.Delta.Usage)
 	}
 
-	if len(message.ToolCalls) > 0 {
-		// toolAgent := NewToolEngine(ctx.Sender, a.LLMManager)
-		// return toolAgent.HandleToolCalls(ctx, message.ToolCalls)
+	if message == nil || message.PromptMessage == nil {
+		return nil
 	}
 
-	if chunk.Delta.Usage != nil {
-		ctx.Response.Usage = &messages.ResponseUsage{
-			InputTokens:  chunk.Delta.Usage.PromptTokens,
-			OutputTokens: chunk.Delta.Usage.CompletionTokens,
-			TotalTokens:  chunk.Delta.Usage.TotalTokens,
+	if content, ok := message.Content.(string); ok && content != "" {
+		if err := a.handleTextContent(ctx, content); err != nil {
+			return err
 		}
 	}
 
+	if len(message.ToolCalls) > 0 {
+		return a.ToolEngine.HandleToolCallDeltas(ctx, message.ToolCalls)
+	}
+
 	return nil
 }
 
+// accumulateUsage 累加多轮 LLM 调用的用量, 同一轮中的用量以最后一次为准
+func (a *ChatRunner) accumulateUsage(ctx *ChatInvokeContext, usage *llm.Usage) {
+	if ctx.Response.Usage == nil {
+		ctx.Response.Usage = &messages.ResponseUsage{}
+	}
+	if ctx.turnUsage != nil {
+		ctx.Response.Usage.InputTokens -= ctx.turnUsage.PromptTokens
+		ctx.Response.Usage.OutputTokens -= ctx.turnUsage.CompletionTokens
+		ctx.Response.Usage.TotalTokens -= ctx.turnUsage.TotalTokens
+	}
+	ctx.Response.Usage.InputTokens += usage.PromptTokens
+	ctx.Response.Usage.OutputTokens += usage.CompletionTokens
+	ctx.Response.Usage.TotalTokens += usage.TotalTokens
+	ctx.turnUsage = usage
+}
+
 func (a *ChatRunner) handleTextContent(ctx *ChatInvokeContext, content string) error {
 	if err := a.ensureOutputMessage(ctx); err != nil {
 		return err
@@ -264,7 +466,7 @@ func (a *ChatRunner) ensureTextContent(ctx *ChatInvokeContext) error {
 
 func (a *ChatRunner) finalizeAllOutputItems(ctx *ChatInvokeContext) error {
 	for i, item := range ctx.Response.MessageItems {
-		if outputMsg, ok := item.(*messages.OutputMessage); ok {
+		if outputMsg, ok := item.(*messages.OutputMessage); ok && outputMsg.Status == "in_progress" {
 			if err := a.finalizeOutputMessage(ctx, outputMsg, i); err != nil {
 				return err
 			}
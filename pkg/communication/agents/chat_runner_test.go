@@ -0,0 +1,240 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/memory"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/providers/llm"
+)
+
+// staticMemory 固定返回一段对话历史
+type staticMemory struct {
+	chunks []memory.Chunk
+}
+
+func (m *staticMemory) Write(chunk memory.Chunk) error { return nil }
+func (m *staticMemory) Retrieve(query memory.Chunk) ([]memory.Chunk, error) {
+	return m.chunks, nil
+}
+func (m *staticMemory) Close() error { return nil }
+
+func textChunk(id string, text string) *memory.MessageChunk {
+	return &memory.MessageChunk{
+		ID: id,
+		Content: &messages.Message{
+			ID:      id,
+			MsgType: messages.MessageTypeText,
+			Content: &messages.TextMessageContent{Text: text},
+		},
+	}
+}
+
+func fakeChunk(content string, toolCalls []llm.ToolCall, finishReason string) *llm.LLMResultChunk {
+	chunk := &llm.LLMResultChunk{
+		Delta: llm.LLMResultChunkDelta{
+			Message:      llm.NewAssistantPromptMessage(content, "", toolCalls),
+			FinishReason: finishReason,
+		},
+	}
+	if finishReason != "" {
+		chunk.Delta.Usage = &llm.Usage{}
+	}
+	return chunk
+}
+
+// fakeOpenAIServer 以 OpenAI 流式接口返回预先准备的响应, 每次请求消费一轮, 超出后重复最后一轮
+func fakeOpenAIServer(t *testing.T, turns [][]*llm.LLMResultChunk) *httptest.Server {
+	t.Helper()
+	var (
+		mu   sync.Mutex
+		next int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		turn := turns[len(turns)-1]
+		if next < len(turns) {
+			turn = turns[next]
+		}
+		next++
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range turn {
+			delta := map[string]interface{}{"content": chunk.Delta.Message.Content}
+			var toolCalls []map[string]interface{}
+			for _, toolCall := range chunk.Delta.Message.ToolCalls {
+				toolCalls = append(toolCalls, map[string]interface{}{
+					"index":    toolCall.Index,
+					"id":       toolCall.ID,
+					"type":     "function",
+					"function": map[string]string{"name": toolCall.Function.Name, "arguments": toolCall.Function.Arguments},
+				})
+			}
+			if len(toolCalls) > 0 {
+				delta["tool_calls"] = toolCalls
+			}
+			choice := map[string]interface{}{"index": 0, "delta": delta, "finish_reason": nil}
+			if chunk.Delta.FinishReason != "" {
+				choice["finish_reason"] = chunk.Delta.FinishReason
+			}
+			data, err := json.Marshal(map[string]interface{}{
+				"id":      "chatcmpl-test",
+				"object":  "chat.completion.chunk",
+				"model":   "fake",
+				"choices": []interface{}{choice},
+			})
+			if err != nil {
+				t.Errorf("marshal chunk: %v", err)
+				return
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newFakeRunner(t *testing.T, maxSteps int, turns ...[]*llm.LLMResultChunk) (*ChatRunner, *llm.ModelManager) {
+	t.Helper()
+	server := fakeOpenAIServer(t, turns)
+
+	socialConfig := &config.SocialConfig{}
+	socialConfig.Avatar.LLM = config.LLMConfig{Provider: "openai", Model: "fake", APIURL: server.URL}
+	manager := llm.NewModelManager(socialConfig)
+	return NewChatRunner(manager, maxSteps), manager
+}
+
+func runToCompletion(t *testing.T, runner *ChatRunner, invokeCtx *ChatInvokeContext) []*messages.ChatEvent {
+	t.Helper()
+	events := collectEvents(invokeCtx)
+	if err := runner.Invoke(invokeCtx); err != nil {
+		t.Fatalf("Invoke: %v", err)
+	}
+	select {
+	case result := <-events:
+		return result
+	case <-time.After(10 * time.Second):
+		t.Fatal("runner did not finish")
+		return nil
+	}
+}
+
+func TestChatRunnerMultiStepToolLoop(t *testing.T) {
+	// 第一轮并行调用两个工具, 参数分两段流式输出; 第二轮根据工具结果输出文本
+	runner, manager := newFakeRunner(t, 0,
+		[]*llm.LLMResultChunk{
+			fakeChunk("", []llm.ToolCall{
+				{Index: 0, ID: "call_add", Type: "function", Function: llm.ToolCallFunction{Name: "add", Arguments: `{"a":1,`}},
+				{Index: 1, ID: "call_echo", Type: "function", Function: llm.ToolCallFunction{Name: "echo", Arguments: `{"text":"hi"}`}},
+			}, ""),
+			fakeChunk("", []llm.ToolCall{{Index: 0, Function: llm.ToolCallFunction{Arguments: `"b":2}`}}}, ""),
+			fakeChunk("", nil, "tool_calls"),
+		},
+		[]*llm.LLMResultChunk{
+			fakeChunk("1+2=3", nil, ""),
+			fakeChunk("", nil, "stop"),
+		},
+	)
+
+	var addArguments string
+	manager.RegisterTool(&funcTool{name: "add", fn: func(ctx context.Context, arguments string) (string, error) {
+		addArguments = arguments
+		return "3", nil
+	}})
+	manager.RegisterTool(&funcTool{name: "echo", fn: func(ctx context.Context, arguments string) (string, error) {
+		return arguments, nil
+	}})
+
+	response := &messages.AgentMessage{ID: "resp-1", MessageID: "msg-1"}
+	invokeCtx := NewChatInvokeContext(context.Background()).
+		WithAgentMessage(response).
+		WithMemory(&staticMemory{chunks: []memory.Chunk{textChunk("msg-1", "1+2 等于多少")}})
+
+	events := runToCompletion(t, runner, invokeCtx)
+
+	if addArguments != `{"a":1,"b":2}` {
+		t.Errorf("add arguments = %q, want streamed arguments joined", addArguments)
+	}
+	last := events[len(events)-1]
+	if last.EventType != messages.EventTypeAgentMessageCompleted {
+		t.Fatalf("last event = %s, want completed", last.EventType)
+	}
+
+	// 输出项: 两个工具调用, 两个工具结果 (按调用顺序), 最后一条文本消息
+	var types []string
+	for _, item := range response.MessageItems {
+		types = append(types, item.GetType())
+	}
+	wantTypes := []string{"tool_call", "tool_call", "function_call_output", "function_call_output", "message"}
+	if len(types) != len(wantTypes) {
+		t.Fatalf("output item types = %v, want %v", types, wantTypes)
+	}
+	for i := range wantTypes {
+		if types[i] != wantTypes[i] {
+			t.Fatalf("output item types = %v, want %v", types, wantTypes)
+		}
+	}
+	addOutput := response.MessageItems[2].(*messages.FunctionToolCallOutput)
+	echoOutput := response.MessageItems[3].(*messages.FunctionToolCallOutput)
+	if addOutput.CallID != "call_add" || addOutput.Output != "3" {
+		t.Errorf("add output = %+v", addOutput)
+	}
+	if echoOutput.CallID != "call_echo" || echoOutput.Output != `{"text":"hi"}` {
+		t.Errorf("echo output = %+v", echoOutput)
+	}
+
+	var text string
+	for _, event := range events {
+		if delta, ok := event.Event.(*messages.TextDeltaEvent); ok {
+			text += delta.Delta
+		}
+	}
+	if text != "1+2=3" {
+		t.Errorf("streamed text = %q, want 1+2=3", text)
+	}
+}
+
+func TestChatRunnerStopsAtMaxSteps(t *testing.T) {
+	// 模型始终请求调用工具, 达到最大轮数后以 incomplete 结束
+	runner, manager := newFakeRunner(t, 2,
+		[]*llm.LLMResultChunk{
+			fakeChunk("", []llm.ToolCall{{Index: 0, ID: "call_loop", Type: "function", Function: llm.ToolCallFunction{Name: "loop", Arguments: "{}"}}}, ""),
+			fakeChunk("", nil, "tool_calls"),
+		},
+	)
+	calls := 0
+	manager.RegisterTool(&funcTool{name: "loop", fn: func(ctx context.Context, arguments string) (string, error) {
+		calls++
+		return "again", nil
+	}})
+
+	response := &messages.AgentMessage{ID: "resp-2", MessageID: "msg-2"}
+	invokeCtx := NewChatInvokeContext(context.Background()).
+		WithAgentMessage(response).
+		WithMemory(&staticMemory{chunks: []memory.Chunk{textChunk("msg-2", "loop")}})
+
+	events := runToCompletion(t, runner, invokeCtx)
+
+	if calls != 2 {
+		t.Errorf("tool called %d times, want 2", calls)
+	}
+	last := events[len(events)-1]
+	incomplete, ok := last.Event.(*messages.IncompleteEvent)
+	if !ok {
+		t.Fatalf("last event = %s, want incomplete", last.EventType)
+	}
+	details := incomplete.AgentMessage.IncompleteDetails
+	if details == nil || details.Reason != messages.IncompleteReasonMaxSteps {
+		t.Errorf("incomplete details = %+v, want max steps", details)
+	}
+}
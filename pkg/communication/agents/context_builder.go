@@ -0,0 +1,163 @@
+package agents
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/memory"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/memory/converters"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/providers/llm"
+)
+
+const (
+	// DefaultCompletionReserve 为模型输出预留的 token 数
+	DefaultCompletionReserve = 1024
+	// summaryBudgetRatio 需要摘要时, 预算中留给摘要的比例
+	summaryBudgetRatio = 4
+)
+
+// ContextStats 上下文构建结果, 记录到 AgentMessage.Metadata["context"]
+type ContextStats struct {
+	Window     int64 `json:"window"`     // 模型上下文窗口
+	Budget     int64 `json:"budget"`     // 可用于历史消息的 token 数
+	Tokens     int64 `json:"tokens"`     // 最终 prompt 的 token 数 (不含工具)
+	Messages   int   `json:"messages"`   // 保留的历史消息数
+	Inherited  int   `json:"inherited"`  // 其中继承自父话题的消息数
+	Dropped    int   `json:"dropped"`    // 超出预算被裁掉的消息数
+	Summarized bool  `json:"summarized"` // 较早的消息是否由话题摘要替代
+}
+
+// HistorySummarizer 将话题中直到 through 的消息合并进持久化的话题摘要, 由 memory.ThreadSummarizer 实现
+type HistorySummarizer interface {
+	SummarizeThrough(ctx context.Context, roomID string, threadID string, through *messages.Message) (*memory.SummaryChunk, error)
+}
+
+// ContextBuilder 将记忆中的历史消息组装为 prompt, 按模型上下文窗口裁剪.
+// 较早的消息由话题摘要替代: 记忆中已有的摘要总是保留, 超出预算的话题消息合并进同一份持久化的摘要
+type ContextBuilder struct {
+	CompletionReserve int64
+	Summarizer        HistorySummarizer // 为空时超出预算的消息直接丢弃
+}
+
+func NewContextBuilder() *ContextBuilder {
+	return &ContextBuilder{
+		CompletionReserve: DefaultCompletionReserve,
+	}
+}
+
+// Build 组装 prompt: prefix + 预算内最近的历史消息, 话题摘要放在话题自身的消息之前
+// 最后一条消息 (当前的用户消息) 总是保留
+func (b *ContextBuilder) Build(
+	ctx context.Context,
+	route *llm.ModelRoute,
+	prefix []*llm.PromptMessage,
+	chunks []memory.Chunk,
+	tools []llm.PromptMessageTool,
+) ([]*llm.PromptMessage, *ContextStats) {
+	model := route.Primary().Model
+	stats := &ContextStats{Window: route.ContextWindow()}
+	stats.Budget = stats.Window - b.CompletionReserve - llm.NumTokensFromMessages(model, prefix, tools)
+
+	var summary *memory.SummaryChunk
+	history := make([]*llm.PromptMessage, 0, len(chunks))
+	sources := make([]memory.Chunk, 0, len(chunks))
+	costs := make([]int64, 0, len(chunks))
+	for _, chunk := range chunks {
+		if summaryChunk, ok := chunk.(*memory.SummaryChunk); ok {
+			summary = summaryChunk
+			continue
+		}
+		message := converters.ChunkToLLM(chunk)
+		if message == nil {
+			continue
+		}
+		history = append(history, message)
+		sources = append(sources, chunk)
+		costs = append(costs, llm.NumTokensFromMessages(model, []*llm.PromptMessage{message}, nil))
+	}
+
+	cut := fitHistory(costs, stats.Budget-summaryCost(model, summary))
+	if cut > 0 && b.Summarizer != nil {
+		// 为摘要预留空间后重新裁剪, 被裁掉的话题消息合并进摘要, 之后的轮次直接复用
+		cut = fitHistory(costs, stats.Budget-stats.Budget/summaryBudgetRatio)
+		if through := lastOwnMessage(sources[:cut]); through != nil {
+			updated, err := b.Summarizer.SummarizeThrough(ctx, through.RoomID, through.ThreadID, through)
+			if err != nil {
+				logrus.Warnf("更新话题摘要失败, 直接丢弃较早的消息: %v", err)
+			} else if updated != nil {
+				summary = updated
+			}
+		}
+	}
+	// 摘要没有更新时它可能超出预留的空间, 按实际大小再裁剪一次
+	cut = max(cut, fitHistory(costs, stats.Budget-summaryCost(model, summary)))
+
+	// 继承的消息早于话题自身的消息, 摘要只覆盖话题自身的消息
+	own := cut
+	for own < len(sources) && isInherited(sources[own]) {
+		own++
+	}
+	promptMessages := make([]*llm.PromptMessage, 0, len(prefix)+len(history)-cut+1)
+	promptMessages = append(promptMessages, prefix...)
+	promptMessages = append(promptMessages, history[cut:own]...)
+	if summary != nil {
+		if message := converters.ChunkToLLM(summary); message != nil {
+			promptMessages = append(promptMessages, message)
+			stats.Summarized = true
+		}
+	}
+	promptMessages = append(promptMessages, history[own:]...)
+
+	stats.Dropped = cut
+	stats.Messages = len(history) - cut
+	stats.Inherited = own - cut
+	stats.Tokens = llm.NumTokensFromMessages(model, promptMessages, nil)
+
+	logrus.Infof("构建上下文: 窗口 %d, 预算 %d, 保留 %d 条 (继承 %d 条), 裁剪 %d 条, 摘要 %v",
+		stats.Window, stats.Budget, stats.Messages, stats.Inherited, stats.Dropped, stats.Summarized)
+	return promptMessages, stats
+}
+
+// fitHistory 从最新的消息向前累加, 返回预算内能保留的第一条消息的下标
+func fitHistory(costs []int64, budget int64) int {
+	var used int64
+	for i := len(costs) - 1; i >= 0; i-- {
+		used += costs[i]
+		if used > budget && i < len(costs)-1 {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func summaryCost(model string, summary *memory.SummaryChunk) int64 {
+	if summary == nil {
+		return 0
+	}
+	message := converters.ChunkToLLM(summary)
+	if message == nil {
+		return 0
+	}
+	return llm.NumTokensFromMessages(model, []*llm.PromptMessage{message}, nil)
+}
+
+// lastOwnMessage 被裁掉的消息中最后一条属于当前话题的消息, 继承自父话题的消息不进入本话题的摘要
+func lastOwnMessage(dropped []memory.Chunk) *messages.Message {
+	for i := len(dropped) - 1; i >= 0; i-- {
+		messageChunk, ok := dropped[i].(*memory.MessageChunk)
+		if ok && messageChunk.Content != nil && !isInherited(messageChunk) {
+			return messageChunk.Content
+		}
+	}
+	return nil
+}
+
+func isInherited(chunk memory.Chunk) bool {
+	messageChunk, ok := chunk.(*memory.MessageChunk)
+	if !ok || messageChunk.Metadata == nil {
+		return false
+	}
+	inherited, _ := messageChunk.Metadata["inherited"].(bool)
+	return inherited
+}
@@ -0,0 +1,285 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/memory"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/providers/llm"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/services"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// countingLLM 记录摘要调用次数
+type countingLLM struct {
+	*llm.FakeLLM
+	calls atomic.Int32
+}
+
+func (c *countingLLM) Chat(
+	ctx context.Context,
+	model string,
+	credentials map[string]interface{},
+	promptMessages []*llm.PromptMessage,
+	modelParameters map[string]interface{},
+	tools []llm.PromptMessageTool,
+	stop []string,
+) (*llm.LLMResult, error) {
+	c.calls.Add(1)
+	return c.FakeLLM.Chat(ctx, model, credentials, promptMessages, modelParameters, tools, stop)
+}
+
+// recordingSummarizer 记录每次摘要到的消息, 返回一份固定内容的摘要
+type recordingSummarizer struct {
+	through []*messages.Message
+	err     error
+}
+
+func (s *recordingSummarizer) SummarizeThrough(ctx context.Context, roomID string, threadID string, through *messages.Message) (*memory.SummaryChunk, error) {
+	s.through = append(s.through, through)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &memory.SummaryChunk{
+		ID:            "summary-" + through.ID,
+		ThreadID:      threadID,
+		LastMessageID: through.ID,
+		CoveredUntil:  through.CreatedAt,
+		Content:       &memory.ConversationSummary{Summary: "用户在讨论旅行计划"},
+	}, nil
+}
+
+func newTestBuilder(t *testing.T, window int) (*ContextBuilder, *llm.ModelRoute) {
+	t.Helper()
+	socialConfig := &config.SocialConfig{}
+	socialConfig.Avatar.LLM = config.LLMConfig{Provider: llm.ProviderFake, Model: "fake", ContextWindow: window}
+	builder := NewContextBuilder()
+	builder.CompletionReserve = 0
+	return builder, llm.NewModelManager(socialConfig).ResolveRoute(nil)
+}
+
+func messageText(i int) string {
+	return fmt.Sprintf("第 %d 条消息: %s", i, strings.Repeat("旅行 ", 10))
+}
+
+// perMessageTokens 单条测试消息的 token 数, 用于按条数设置上下文窗口
+func perMessageTokens() int64 {
+	return llm.NumTokensFromMessages("fake", []*llm.PromptMessage{{Role: llm.PromptMessageRoleUser, Content: messageText(10)}}, nil)
+}
+
+func conversation(t *testing.T, count int) []memory.Chunk {
+	chunks := make([]memory.Chunk, 0, count)
+	for i := 0; i < count; i++ {
+		chunk := textChunk(fmt.Sprintf("%s-%d", t.Name(), i), messageText(i))
+		chunk.Content.RoomID = "room-1"
+		chunk.Content.ThreadID = "thread-1"
+		chunk.Content.CreatedAt = int64(1000 + i)
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+func inherit(chunk *memory.MessageChunk) *memory.MessageChunk {
+	chunk.Metadata = map[string]interface{}{"inherited": true}
+	return chunk
+}
+
+func promptContents(promptMessages []*llm.PromptMessage) []string {
+	contents := make([]string, 0, len(promptMessages))
+	for _, message := range promptMessages {
+		content, _ := message.Content.(string)
+		contents = append(contents, content)
+	}
+	return contents
+}
+
+func TestContextBuilderKeepsHistoryWithinBudget(t *testing.T) {
+	builder, route := newTestBuilder(t, 100000)
+	summarizer := &recordingSummarizer{}
+	builder.Summarizer = summarizer
+	chunks := conversation(t, 10)
+
+	promptMessages, stats := builder.Build(context.Background(), route, nil, chunks, nil)
+	if stats.Dropped != 0 || stats.Summarized || len(promptMessages) != len(chunks) {
+		t.Fatalf("stats = %+v, want all %d messages kept", stats, len(chunks))
+	}
+	if len(summarizer.through) != 0 {
+		t.Fatalf("summarizer called %d times, want 0", len(summarizer.through))
+	}
+}
+
+func TestContextBuilderKeepsPersistedSummary(t *testing.T) {
+	builder, route := newTestBuilder(t, int(perMessageTokens()*8))
+	summarizer := &recordingSummarizer{}
+	builder.Summarizer = summarizer
+
+	// 记忆中的顺序: 继承的消息, 话题摘要, 话题自身的消息
+	chunks := []memory.Chunk{
+		inherit(textChunk("parent-1", "父话题的消息")),
+		&memory.SummaryChunk{ID: "persisted", MessageCount: 30, Content: &memory.ConversationSummary{Summary: "已有的话题摘要"}},
+	}
+	chunks = append(chunks, conversation(t, 4)...)
+
+	promptMessages, stats := builder.Build(context.Background(), route, nil, chunks, nil)
+	contents := promptContents(promptMessages)
+	if len(contents) != 6 || contents[0] != "父话题的消息" || !strings.Contains(contents[1], "已有的话题摘要") {
+		t.Fatalf("prompt = %q, want inherited message, summary, then thread messages", contents)
+	}
+	if !stats.Summarized || stats.Dropped != 0 || stats.Inherited != 1 || len(summarizer.through) != 0 {
+		t.Fatalf("stats = %+v, summarizer calls %d", stats, len(summarizer.through))
+	}
+}
+
+func TestContextBuilderSummarizesDroppedThreadMessages(t *testing.T) {
+	builder, route := newTestBuilder(t, int(perMessageTokens()*20))
+	summarizer := &recordingSummarizer{}
+	builder.Summarizer = summarizer
+
+	chunks := []memory.Chunk{inherit(textChunk("parent-1", messageText(0)))}
+	chunks = append(chunks, conversation(t, 40)...)
+
+	promptMessages, stats := builder.Build(context.Background(), route, nil, chunks, nil)
+	if len(summarizer.through) != 1 {
+		t.Fatalf("summarizer called %d times, want 1", len(summarizer.through))
+	}
+	// 摘要覆盖到被裁掉的最后一条话题消息, 继承的消息不进入摘要
+	lastDropped := chunks[stats.Dropped-1].(*memory.MessageChunk)
+	if through := summarizer.through[0]; through.ID != lastDropped.ID || through.ThreadID != "thread-1" {
+		t.Fatalf("summarized through %s, want %s", through.ID, lastDropped.ID)
+	}
+	contents := promptContents(promptMessages)
+	if !stats.Summarized || !strings.Contains(contents[0], "用户在讨论旅行计划") {
+		t.Fatalf("prompt starts with %q, want the summary", contents[0])
+	}
+	firstKept := chunks[stats.Dropped].(*memory.MessageChunk).Content.Content.(*messages.TextMessageContent).Text
+	if contents[1] != firstKept {
+		t.Fatalf("message after summary = %q, want %q", contents[1], firstKept)
+	}
+	if stats.Tokens > stats.Budget || stats.Inherited != 0 {
+		t.Fatalf("stats = %+v, want prompt within budget without inherited messages", stats)
+	}
+}
+
+func TestContextBuilderDropsWithoutSummarizer(t *testing.T) {
+	builder, route := newTestBuilder(t, int(perMessageTokens()*20))
+	chunks := conversation(t, 40)
+
+	promptMessages, stats := builder.Build(context.Background(), route, nil, chunks, nil)
+	if stats.Summarized || stats.Dropped == 0 || len(promptMessages) != stats.Messages {
+		t.Fatalf("stats = %+v, want older messages dropped", stats)
+	}
+	if stats.Tokens > stats.Budget {
+		t.Fatalf("prompt uses %d tokens, budget %d", stats.Tokens, stats.Budget)
+	}
+
+	// 摘要失败时同样直接丢弃
+	builder.Summarizer = &recordingSummarizer{err: errors.New("llm down")}
+	if _, stats := builder.Build(context.Background(), route, nil, chunks, nil); stats.Summarized || stats.Tokens > stats.Budget {
+		t.Fatalf("stats after failed summary = %+v", stats)
+	}
+}
+
+func newTestMetaStore(t *testing.T) *repositories.MetaStore {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.sqlite")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	store := repositories.NewMetaStore(db)
+	if err := store.Init(); err != nil {
+		t.Fatalf("init metastore: %v", err)
+	}
+	return store
+}
+
+func TestContextBuilderReusesPersistedSummaryAcrossTurns(t *testing.T) {
+	ctx := context.Background()
+	store := newTestMetaStore(t)
+	converter := services.NewMessageConverter(store.MessageRepo)
+	addMessage := func(i int) {
+		message := &messages.Message{
+			ID:        fmt.Sprintf("m%03d", i),
+			RoomID:    "room-1",
+			ThreadID:  "thread-1",
+			MsgType:   messages.MessageTypeText,
+			SenderID:  "did:plc:alice",
+			Content:   &messages.TextMessageContent{Text: messageText(i)},
+			CreatedAt: int64(1000 + i),
+		}
+		if err := store.MessageRepo.InsertMessage(converter.MessageToDB(message)); err != nil {
+			t.Fatalf("insert message %d: %v", i, err)
+		}
+	}
+	for i := 0; i < 40; i++ {
+		addMessage(i)
+	}
+
+	provider := "context-test-" + t.Name()
+	counting := &countingLLM{FakeLLM: llm.NewFakeLLM([]*llm.LLMResultChunk{
+		fakeChunk("用户在讨论旅行计划", nil, "stop"),
+	})}
+	llm.RegisterProvider(provider, counting)
+	socialConfig := &config.SocialConfig{}
+	socialConfig.Avatar.LLM = config.LLMConfig{Provider: provider, Model: "fake", ContextWindow: int(perMessageTokens() * 20)}
+	manager := llm.NewModelManager(socialConfig)
+	route := manager.ResolveRoute(nil)
+
+	builder := NewContextBuilder()
+	builder.CompletionReserve = 0
+	builder.Summarizer = memory.NewThreadSummarizer(store, manager, config.SummaryConfig{})
+	threadMemory := memory.NewSimpleThreadMemory(store.DB, "room-1", "thread-1")
+	build := func() *ContextStats {
+		chunks, err := threadMemory.Retrieve(nil)
+		if err != nil {
+			t.Fatalf("retrieve: %v", err)
+		}
+		_, stats := builder.Build(ctx, route, nil, chunks, nil)
+		if stats.Tokens > stats.Budget {
+			t.Fatalf("prompt uses %d tokens, budget %d", stats.Tokens, stats.Budget)
+		}
+		return stats
+	}
+
+	if stats := build(); !stats.Summarized || stats.Dropped == 0 || counting.calls.Load() != 1 {
+		t.Fatalf("first turn stats = %+v after %d calls, want a new summary", stats, counting.calls.Load())
+	}
+	summary, err := store.MemoryRepo.GetLatestThreadSummary("room-1", "thread-1")
+	if err != nil || summary == nil {
+		t.Fatalf("persisted summary = %v, %v", summary, err)
+	}
+
+	// 之后的轮次从记忆中读到同一份摘要, 被覆盖的消息不再出现, 也不再调用模型
+	turns := 0
+	for i := 40; ; i++ {
+		addMessage(i)
+		stats := build()
+		if counting.calls.Load() > 1 {
+			break
+		}
+		if !stats.Summarized || stats.Dropped != 0 {
+			t.Fatalf("turn %d stats = %+v, want the persisted summary reused", i, stats)
+		}
+		turns++
+	}
+	if turns == 0 {
+		t.Fatal("persisted summary was not reused on the next turn")
+	}
+
+	// 剩余消息再次放不下时合并进新的摘要
+	latest, _ := store.MemoryRepo.GetLatestThreadSummary("room-1", "thread-1")
+	if latest.ID == summary.ID || latest.MessageCount <= summary.MessageCount {
+		t.Fatalf("latest summary covers %d messages, want more than %d", latest.MessageCount, summary.MessageCount)
+	}
+}
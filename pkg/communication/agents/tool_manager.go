@@ -1,107 +1,182 @@
 package agents
 
-// type ToolEngine struct {
-// 	Sender     *ChatEventSender
-// 	LLMManager *llm.ModelManager
-// }
-
-// func NewToolEngine(sender *ChatEventSender, llmManager *llm.ModelManager) *ToolEngine {
-// 	return &ToolEngine{
-// 		Sender:     sender,
-// 		LLMManager: llmManager,
-// 	}
-// }
-
-// func (a *ToolEngine) HandleToolCalls(ctx context.Context, toolCalls []llm.ToolCall) error {
-// 	for _, toolCall := range toolCalls {
-// 		if toolCall.Type == "function" {
-// 			if err := a.handleFunctionCall(ctx, toolCall); err != nil {
-// 				return err
-// 			}
-// 		}
-// 	}
-// 	return nil
-// }
-
-// func (a *ToolEngine) handleFunctionCall(ctx context.Context, toolCall llm.ToolCall) error {
-// 	// 创建函数工具调用
-// 	functionCall := &messages.FunctionToolCall{
-// 		ID:        toolCall.ID,
-// 		Type:      "tool_call",
-// 		Name:      toolCall.Function.Name,
-// 		Status:    "in_progress",
-// 		Arguments: toolCall.Function.Arguments,
-// 	}
-
-// 	// 添加到消息项
-// 	ctx.AIChatMessage.MessageItems = append(ctx.AIChatMessage.MessageItems, functionCall)
-// 	currentIndex := len(ctx.AIChatMessage.MessageItems) - 1
-
-// 	// 发送输出项添加事件
-// 	if err := a.Sender.sendOutputItemAdded(ctx, currentIndex, functionCall); err != nil {
-// 		return err
-// 	}
-
-// 	// 发送函数调用参数增量事件（如果有参数）
-// 	if functionCall.Arguments != "" {
-// 		if err := a.Sender.sendFunctionCallArgumentsDelta(ctx, functionCall.ID, currentIndex, functionCall.Arguments); err != nil {
-// 			return err
-// 		}
-
-// 		// 发送函数调用参数完成事件
-// 		if err := a.Sender.sendFunctionCallArgumentsDone(ctx, functionCall.ID, currentIndex, functionCall.Arguments); err != nil {
-// 			return err
-// 		}
-// 	}
-
-// 	// 异步执行工具调用
-// 	go a.executeFunctionCall(ctx, functionCall, currentIndex)
-
-// 	return nil
-// }
-
-// func (a *ToolEngine) executeFunctionCall(ctx context.Context, functionCall *messages.FunctionToolCall, outputIndex int) {
-// 	logrus.Infof("开始执行工具: %s", functionCall.Name)
-
-// 	// 执行工具
-// 	result, err := a.LLMManager.ExecuteTool(ctx.Context, functionCall.Name, functionCall.Arguments)
-
-// 	if err != nil {
-// 		logrus.Errorf("执行工具 %s 失败: %v", functionCall.Name, err)
-// 		functionCall.Status = "failed"
-
-// 		// 可以在这里发送工具调用失败事件
-// 		// 目前先记录错误
-// 	} else {
-// 		logrus.Infof("工具 %s 执行成功，结果: %s", functionCall.Name, result)
-// 		functionCall.Status = "completed"
-
-// 		// 创建函数调用输出
-// 		functionOutput := &messages.FunctionToolCallOutput{
-// 			ID:     uuid.New().String(),
-// 			Type:   "function_call_output",
-// 			CallID: functionCall.ID,
-// 			Output: result,
-// 			Status: "completed",
-// 		}
-
-// 		// 添加到消息项
-// 		ctx.AIChatMessage.MessageItems = append(ctx.AIChatMessage.MessageItems, functionOutput)
-// 		outputOutputIndex := len(ctx.AIChatMessage.MessageItems) - 1
-
-// 		// 发送函数调用输出添加事件
-// 		if err := a.Sender.sendOutputItemAdded(ctx, outputOutputIndex, functionOutput); err != nil {
-// 			logrus.Errorf("发送函数调用输出添加事件失败: %v", err)
-// 		}
-
-// 		// 发送函数调用输出完成事件
-// 		if err := a.Sender.sendOutputItemDone(ctx, outputOutputIndex, functionOutput); err != nil {
-// 			logrus.Errorf("发送函数调用输出完成事件失败: %v", err)
-// 		}
-// 	}
-
-// 	// 发送函数调用完成事件
-// 	if err := a.Sender.sendOutputItemDone(ctx, outputIndex, functionCall); err != nil {
-// 		logrus.Errorf("发送函数调用完成事件失败: %v", err)
-// 	}
-// }
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/providers/llm"
+)
+
+// ToolCallState 一轮 LLM 输出中正在构建的工具调用
+type ToolCallState struct {
+	OutputIndex int
+	Item        *messages.FunctionToolCall
+}
+
+type ToolEngine struct {
+	LLMManager *llm.ModelManager
+}
+
+func NewToolEngine(llmManager *llm.ModelManager) *ToolEngine {
+	return &ToolEngine{
+		LLMManager: llmManager,
+	}
+}
+
+// HandleToolCallDeltas 处理流式的工具调用增量
+// 首个增量创建 FunctionToolCall 输出项, 后续增量按 Index 拼接参数并发送 arguments.delta 事件
+func (e *ToolEngine) HandleToolCallDeltas(ctx *ChatInvokeContext, toolCalls []llm.ToolCall) error {
+	for _, toolCall := range toolCalls {
+		state, ok := ctx.CurrentToolCalls[toolCall.Index]
+		if !ok {
+			callID := toolCall.ID
+			if callID == "" {
+				callID = uuid.New().String()
+			}
+			functionCall := &messages.FunctionToolCall{
+				ID:     callID,
+				Type:   string(messages.ToolTypeFunctionCall),
+				Name:   toolCall.Function.Name,
+				Status: string(messages.ToolCallStatusInProgress),
+			}
+
+			ctx.Response.MessageItems = append(ctx.Response.MessageItems, functionCall)
+			state = &ToolCallState{
+				OutputIndex: len(ctx.Response.MessageItems) - 1,
+				Item:        functionCall,
+			}
+			ctx.CurrentToolCalls[toolCall.Index] = state
+
+			if err := ctx.sendOutputItemAdded(state.OutputIndex, functionCall); err != nil {
+				return err
+			}
+		} else if state.Item.Name == "" && toolCall.Function.Name != "" {
+			state.Item.Name = toolCall.Function.Name
+		}
+
+		if toolCall.Function.Arguments == "" {
+			continue
+		}
+		state.Item.Arguments += toolCall.Function.Arguments
+		if err := ctx.sendFunctionCallArgumentsDelta(state.Item.ID, state.OutputIndex, toolCall.Function.Arguments); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FinalizeToolCalls 结束本轮的工具调用输出项, 按 LLM 输出顺序返回
+func (e *ToolEngine) FinalizeToolCalls(ctx *ChatInvokeContext) ([]*ToolCallState, error) {
+	indexes := make([]int, 0, len(ctx.CurrentToolCalls))
+	for index := range ctx.CurrentToolCalls {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	states := make([]*ToolCallState, 0, len(indexes))
+	for _, index := range indexes {
+		state := ctx.CurrentToolCalls[index]
+		if state.Item.Arguments == "" {
+			state.Item.Arguments = "{}"
+		}
+		if err := ctx.sendFunctionCallArgumentsDone(state.Item.ID, state.OutputIndex, state.Item.Arguments); err != nil {
+			return nil, err
+		}
+		state.Item.Status = string(messages.ToolCallStatusCompleted)
+		if err := ctx.sendOutputItemDone(state.OutputIndex, state.Item); err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// ExecuteToolCalls 并发执行本轮的全部工具调用, 返回按调用顺序排列的 tool 消息
+// 执行过程中收到中断信号会取消尚未完成的工具, 并返回 interrupted = true
+func (e *ToolEngine) ExecuteToolCalls(ctx *ChatInvokeContext, states []*ToolCallState) ([]*llm.PromptMessage, bool, error) {
+	// 先按顺序占好输出位置, 保证持久化的 position 与调用顺序一致
+	outputs := make([]*messages.FunctionToolCallOutput, len(states))
+	outputIndexes := make([]int, len(states))
+	for i, state := range states {
+		output := &messages.FunctionToolCallOutput{
+			ID:     uuid.New().String(),
+			Type:   "function_call_output",
+			CallID: state.Item.ID,
+			Status: string(messages.ToolCallStatusInProgress),
+		}
+		ctx.Response.MessageItems = append(ctx.Response.MessageItems, output)
+		outputs[i] = output
+		outputIndexes[i] = len(ctx.Response.MessageItems) - 1
+		if err := ctx.sendOutputItemAdded(outputIndexes[i], output); err != nil {
+			return nil, false, err
+		}
+	}
+
+	toolCtx, cancel := context.WithCancel(ctx.Context)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i, state := range states {
+		wg.Add(1)
+		go func(state *ToolCallState, output *messages.FunctionToolCallOutput, outputIndex int) {
+			defer wg.Done()
+			e.executeFunctionCall(toolCtx, ctx, state.Item, output, outputIndex)
+		}(state, outputs[i], outputIndexes[i])
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	interrupted := false
+	for waiting := true; waiting; {
+		select {
+		case <-done:
+			waiting = false
+		case ctrlType := <-ctx.ControlChan:
+			if ctrlType == CtrlTypeInterrupt {
+				logrus.Info("工具执行中收到中断信号，取消未完成的工具")
+				interrupted = true
+				cancel()
+			}
+		}
+	}
+
+	promptMessages := make([]*llm.PromptMessage, 0, len(states))
+	for i, state := range states {
+		promptMessages = append(promptMessages, llm.NewToolPromptMessage(outputs[i].Output, state.Item.Name, state.Item.ID).PromptMessage)
+	}
+	return promptMessages, interrupted, nil
+}
+
+func (e *ToolEngine) executeFunctionCall(
+	toolCtx context.Context,
+	ctx *ChatInvokeContext,
+	functionCall *messages.FunctionToolCall,
+	output *messages.FunctionToolCallOutput,
+	outputIndex int,
+) {
+	logrus.Infof("开始执行工具: %s", functionCall.Name)
+
+	result, err := e.LLMManager.ExecuteTool(toolCtx, functionCall.Name, functionCall.Arguments)
+	if err != nil {
+		logrus.Errorf("执行工具 %s 失败: %v", functionCall.Name, err)
+		// 错误信息同样作为工具输出交给模型, 由模型决定如何继续
+		output.Output = "工具执行失败: " + err.Error()
+		output.Status = string(messages.ToolCallStatusFailed)
+	} else {
+		logrus.Infof("工具 %s 执行成功", functionCall.Name)
+		output.Output = result
+		output.Status = string(messages.ToolCallStatusCompleted)
+	}
+
+	if err := ctx.sendOutputItemDone(outputIndex, output); err != nil {
+		logrus.Errorf("发送函数调用输出完成事件失败: %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/providers/llm"
+)
+
+// funcTool 测试用的工具执行器
+type funcTool struct {
+	name string
+	fn   func(ctx context.Context, arguments string) (string, error)
+}
+
+func (t *funcTool) Execute(ctx context.Context, arguments string) (string, error) {
+	return t.fn(ctx, arguments)
+}
+
+func (t *funcTool) GetName() string        { return t.name }
+func (t *funcTool) GetDescription() string { return t.name }
+func (t *funcTool) GetParameters() map[string]interface{} {
+	return map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+}
+
+// collectEvents 在后台读取响应流, 流关闭后返回全部事件
+func collectEvents(invokeCtx *ChatInvokeContext) <-chan []*messages.ChatEvent {
+	result := make(chan []*messages.ChatEvent, 1)
+	go func() {
+		var events []*messages.ChatEvent
+		for {
+			recv := invokeCtx.Stream.Recv()
+			if recv.HasData {
+				events = append(events, recv.Data)
+				continue
+			}
+			if recv.Completed {
+				result <- events
+				return
+			}
+		}
+	}()
+	return result
+}
+
+func newToolCallStates(names ...string) []*ToolCallState {
+	states := make([]*ToolCallState, 0, len(names))
+	for i, name := range names {
+		states = append(states, &ToolCallState{
+			OutputIndex: i,
+			Item: &messages.FunctionToolCall{
+				ID:        fmt.Sprintf("call_%d", i),
+				Type:      string(messages.ToolTypeFunctionCall),
+				Name:      name,
+				Arguments: "{}",
+			},
+		})
+	}
+	return states
+}
+
+func TestExecuteToolCallsRunsConcurrently(t *testing.T) {
+	manager := llm.NewModelManager(&config.SocialConfig{})
+
+	// 三个工具都开始执行之后才能返回, 串行执行会超时
+	const parallel = 3
+	var started sync.WaitGroup
+	started.Add(parallel)
+	allStarted := make(chan struct{})
+	go func() {
+		started.Wait()
+		close(allStarted)
+	}()
+	for i := 0; i < parallel; i++ {
+		name := fmt.Sprintf("tool_%d", i)
+		manager.RegisterTool(&funcTool{name: name, fn: func(ctx context.Context, arguments string) (string, error) {
+			started.Done()
+			select {
+			case <-allStarted:
+				return "result of " + name, nil
+			case <-time.After(5 * time.Second):
+				return "", errors.New("tools did not run concurrently")
+			}
+		}})
+	}
+
+	invokeCtx := NewChatInvokeContext(context.Background()).WithAgentMessage(&messages.AgentMessage{ID: "resp"})
+	events := collectEvents(invokeCtx)
+
+	states := newToolCallStates("tool_0", "tool_1", "tool_2", "missing")
+	promptMessages, interrupted, err := NewToolEngine(manager).ExecuteToolCalls(invokeCtx, states)
+	if err != nil {
+		t.Fatalf("ExecuteToolCalls: %v", err)
+	}
+	if interrupted {
+		t.Fatal("ExecuteToolCalls reported an interrupt")
+	}
+	invokeCtx.Stream.CloseSend()
+
+	if len(promptMessages) != len(states) {
+		t.Fatalf("got %d tool messages, want %d", len(promptMessages), len(states))
+	}
+	for i := 0; i < parallel; i++ {
+		want := fmt.Sprintf("result of tool_%d", i)
+		if promptMessages[i].Content != want {
+			t.Errorf("tool message %d = %v, want %q", i, promptMessages[i].Content, want)
+		}
+		if promptMessages[i].ToolCallID != states[i].Item.ID {
+			t.Errorf("tool message %d call id = %q, want %q", i, promptMessages[i].ToolCallID, states[i].Item.ID)
+		}
+	}
+
+	// 输出项按调用顺序占位, 未知工具的错误作为输出交给模型
+	outputs := invokeCtx.Response.MessageItems
+	if len(outputs) != len(states) {
+		t.Fatalf("got %d output items, want %d", len(outputs), len(states))
+	}
+	for i, item := range outputs {
+		output, ok := item.(*messages.FunctionToolCallOutput)
+		if !ok {
+			t.Fatalf("output item %d is %T", i, item)
+		}
+		if output.CallID != states[i].Item.ID {
+			t.Errorf("output %d call id = %q, want %q", i, output.CallID, states[i].Item.ID)
+		}
+		wantStatus := string(messages.ToolCallStatusCompleted)
+		if i == parallel {
+			wantStatus = string(messages.ToolCallStatusFailed)
+		}
+		if output.Status != wantStatus {
+			t.Errorf("output %d status = %q, want %q", i, output.Status, wantStatus)
+		}
+	}
+
+	added, done := 0, 0
+	for _, event := range <-events {
+		switch event.EventType {
+		case messages.EventTypeAgentMessageOutputItemAdded:
+			added++
+		case messages.EventTypeAgentMessageOutputItemDone:
+			done++
+		}
+	}
+	if added != len(states) || done != len(states) {
+		t.Errorf("got %d added / %d done events, want %d each", added, done, len(states))
+	}
+}
+
+func TestExecuteToolCallsInterrupt(t *testing.T) {
+	manager := llm.NewModelManager(&config.SocialConfig{})
+	slowStarted := make(chan struct{})
+	manager.RegisterTool(&funcTool{name: "slow", fn: func(ctx context.Context, arguments string) (string, error) {
+		close(slowStarted)
+		<-ctx.Done()
+		return "", ctx.Err()
+	}})
+	manager.RegisterTool(&funcTool{name: "fast", fn: func(ctx context.Context, arguments string) (string, error) {
+		return "ok", nil
+	}})
+
+	invokeCtx := NewChatInvokeContext(context.Background()).WithAgentMessage(&messages.AgentMessage{ID: "resp"})
+	events := collectEvents(invokeCtx)
+	go func() {
+		<-slowStarted
+		invokeCtx.ControlChan <- CtrlTypeInterrupt
+	}()
+
+	done := make(chan struct{})
+	var interrupted bool
+	go func() {
+		defer close(done)
+		_, interrupted, _ = NewToolEngine(manager).ExecuteToolCalls(invokeCtx, newToolCallStates("slow", "fast"))
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ExecuteToolCalls did not return after interrupt")
+	}
+	invokeCtx.Stream.CloseSend()
+	<-events
+
+	if !interrupted {
+		t.Fatal("interrupted = false, want true")
+	}
+	slow := invokeCtx.Response.MessageItems[0].(*messages.FunctionToolCallOutput)
+	fast := invokeCtx.Response.MessageItems[1].(*messages.FunctionToolCallOutput)
+	if slow.Status != string(messages.ToolCallStatusFailed) {
+		t.Errorf("slow status = %q, want failed", slow.Status)
+	}
+	if fast.Status != string(messages.ToolCallStatusCompleted) || fast.Output != "ok" {
+		t.Errorf("fast output = %q (%s), want ok", fast.Output, fast.Status)
+	}
+}
@@ -1,15 +1,20 @@
 package chat
 
 import (
+	"context"
+	"errors"
 	"sync"
 
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"github.com/zhongshangwu/avatarai-social/pkg/communication/agents"
 	"github.com/zhongshangwu/avatarai-social/pkg/communication/events"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/fanout"
 	"github.com/zhongshangwu/avatarai-social/pkg/communication/memory"
 	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/realtime"
 	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/mcp"
 	"github.com/zhongshangwu/avatarai-social/pkg/providers/llm"
 	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
 	"github.com/zhongshangwu/avatarai-social/pkg/services"
@@ -21,14 +26,26 @@ type ChatActor struct {
 	User         *repositories.Avatar       // 发送者用户
 	OAuthSession *repositories.OAuthSession // 发送者 OAuth 会话
 
-	MetaStore      *repositories.MetaStore
-	MessageService *services.MessageService
-	llmManager     *llm.ModelManager
-	config         *config.SocialConfig
+	MetaStore         *repositories.MetaStore
+	MessageService    *services.MessageService
+	MCPService        *services.MCPService
+	ModerationService *services.ModerationService
+	RoomService       *services.RoomService
+	llmManager        *llm.ModelManager
+	mcpSessions       *mcp.MCPSessionManager
+	config            *config.SocialConfig
 
 	runner *agents.ChatRunner
 	memory memory.Memory
 	mu     sync.RWMutex
+
+	semanticIndexes *memory.SemanticIndexRegistry // 为空时不启用语义记忆
+	userDid         string
+	summarizer      *memory.ThreadSummarizer // 为空时不生成话题摘要
+	fanout          *fanout.Subscription     // 为空时事件只写入当前连接的输出流
+	senderDid       string                   // 为空时不校验消息的发送者
+	voice           *realtime.Session        // 当前连接上的实时语音会话, 未开启时为空
+	sendResults     sync.Map                 // eventID -> chan *SendResult, 等待发送结果的 HTTP 请求
 }
 
 func NewChatActor(
@@ -43,21 +60,78 @@ func NewChatActor(
 	llm.RegisterDefaultTools(llmManager)
 
 	actor := &ChatActor{
-		BaseActor:      baseActor,
-		MetaStore:      metaStore,
-		MessageService: services.NewMessageService(metaStore),
-		llmManager:     llmManager,
-		config:         config,
+		BaseActor:         baseActor,
+		MetaStore:         metaStore,
+		MessageService:    services.NewMessageService(metaStore),
+		MCPService:        services.NewMCPService(metaStore, config),
+		ModerationService: services.NewModerationService(config, metaStore),
+		RoomService:       services.NewRoomService(metaStore),
+		llmManager:        llmManager,
+		mcpSessions:       mcp.NewMCPSessionManager(metaStore),
+		config:            config,
 	}
 	runner := agents.NewChatRunner(
 		actor.llmManager,
+		config.Avatar.MaxSteps,
 	)
 	actor.runner = runner
 	actor.RegisterHandler(string(messages.EventTypeMessageSend), actor.SendMsgHandler)
 	actor.RegisterHandler(string(messages.EventTypeAgentMessageInterrupt), actor.InterruptHandler)
+	actor.RegisterHandler(string(messages.EventTypeAgentMessageResume), actor.ResumeHandler)
+	actor.RegisterHandler(string(messages.EventTypeRealtimeSessionStart), actor.RealtimeStartHandler)
+	actor.RegisterHandler(string(messages.EventTypeRealtimeSessionStop), actor.RealtimeStopHandler)
+	actor.RegisterHandler(string(messages.EventTypeRealtimeInputAudioAppend), actor.RealtimeAppendHandler)
+	actor.RegisterHandler(string(messages.EventTypeRealtimeInputAudioCommit), actor.RealtimeCommitHandler)
 	return actor
 }
 
+// LoadMCPTools 连接用户已启用的 MCP Server, 将其工具注册为 LLM 可调用的工具
+func (actor *ChatActor) LoadMCPTools(ctx context.Context, userDid string) error {
+	servers, err := actor.MCPService.ListEnabledMCPServers(userDid)
+	if err != nil {
+		logrus.Errorf("获取用户 MCP Server 失败: %v", err)
+		return err
+	}
+	count := actor.mcpSessions.RegisterTools(ctx, actor.llmManager, servers)
+	logrus.Infof("已为用户 %s 注册 %d 个 MCP 工具", userDid, count)
+	return nil
+}
+
+// EnableSemanticMemory 启用跨房间的语义记忆, 用户消息和 AI 回复会写入该用户的向量索引
+func (actor *ChatActor) EnableSemanticMemory(indexes *memory.SemanticIndexRegistry, userDid string) {
+	actor.semanticIndexes = indexes
+	actor.userDid = userDid
+}
+
+// EnableHistorySearch 注册聊天记录检索工具, 模型可以按需检索该用户的历史对话
+func (actor *ChatActor) EnableHistorySearch(searcher *memory.HistorySearcher, userDid string) {
+	actor.llmManager.RegisterTool(memory.NewHistorySearchTool(searcher, userDid))
+}
+
+// EnableThreadSummary 启用话题滚动摘要, 每次 AI 回复完成后检查是否需要压缩较早的消息,
+// 构建上下文时超出模型窗口的消息也合并进同一份摘要
+func (actor *ChatActor) EnableThreadSummary(summarizer *memory.ThreadSummarizer) {
+	actor.summarizer = summarizer
+	actor.runner.ContextBuilder.Summarizer = summarizer
+}
+
+// EnableFanout 将消息和 AI 回复事件分发给同一用户的其他设备以及房间内的其他成员
+func (actor *ChatActor) EnableFanout(sub *fanout.Subscription) {
+	actor.fanout = sub
+}
+
+// RestrictSender 只允许以当前连接的用户身份发送消息
+func (actor *ChatActor) RestrictSender(did string) {
+	actor.senderDid = did
+}
+
+func (actor *ChatActor) Stop() error {
+	actor.mcpSessions.Close()
+	err := actor.BaseActor.Stop()
+	actor.closeVoice()
+	return err
+}
+
 func (actor *ChatActor) SendMsgHandler(actorCtx events.ActorContext[*messages.ChatEvent], event *messages.ChatEvent) error {
 	logrus.Infof("开始处理 SendMessage 事件: %s", event.EventID)
 
@@ -67,18 +141,73 @@ func (actor *ChatActor) SendMsgHandler(actorCtx events.ActorContext[*messages.Ch
 		return actor.sendError(actorCtx, "invalid_event", "无效的事件类型")
 	}
 
-	logrus.Infof("消息类型: %s", sendMsgEvent.MsgType)
+	logrus.Infof("消息类型: %d", sendMsgEvent.MsgType)
+
+	result := actor.sendMsgAndRespond(actorCtx, event, sendMsgEvent, nil)
+	if waiter, ok := actor.sendResults.LoadAndDelete(event.EventID); ok {
+		waiter.(chan *SendResult) <- result
+	}
+	return nil
+}
+
+// SendResult 消息的发送结果, HTTP 接口据此同步返回消息 ID 和回复 ID
+type SendResult struct {
+	MessageID  string // 发送失败时为空
+	ResponseID string // 触发的 AI 回复 ID, 没有触发时为空
+	Held       bool   // 消息等待人工审核
+	ErrorCode  string
+	Error      string
+}
 
-	message, err := actor.SendMsg(sendMsgEvent)
+// AwaitSendResult 在发布 message.send 事件之前注册, 事件处理完成后从返回的通道取得结果.
+// 不再等待时调用返回的 cancel
+func (actor *ChatActor) AwaitSendResult(eventID string) (<-chan *SendResult, func()) {
+	waiter := make(chan *SendResult, 1)
+	actor.sendResults.Store(eventID, waiter)
+	return waiter, func() { actor.sendResults.Delete(eventID) }
+}
+
+// sendMsgAndRespond 发送消息并触发 AI 回复, voice 不为空时回复事件同时交给实时语音会话播报
+func (actor *ChatActor) sendMsgAndRespond(
+	actorCtx events.ActorContext[*messages.ChatEvent],
+	event *messages.ChatEvent,
+	sendMsgEvent *messages.SendMsgEvent,
+	voice *realtime.Turn,
+) *SendResult {
+	message, held, err := actor.SendMsg(actorCtx.Context, sendMsgEvent)
+	if errors.Is(err, services.ErrContentRejected) {
+		logrus.Infof("消息未通过内容审核, 发送者: %s", sendMsgEvent.SenderID)
+		return actor.sendFailed(actorCtx, "content_rejected", "消息未通过内容审核")
+	}
+	if code, ok := roomErrorCode(err); ok {
+		logrus.Infof("消息未通过房间检查, 发送者: %s, 房间: %s: %v", sendMsgEvent.SenderID, sendMsgEvent.RoomID, err)
+		return actor.sendFailed(actorCtx, code, err.Error())
+	}
 	if err != nil {
 		logrus.Errorf("消息发送失败: %v", err)
-		return actor.sendError(actorCtx, "send_failed", "消息发送失败")
+		return actor.sendFailed(actorCtx, "send_failed", "消息发送失败")
+	}
+	actor.sendMsgSent(actorCtx, message, event, held)
+	result := &SendResult{MessageID: message.ID, Held: held}
+	if held {
+		logrus.Infof("消息 %s 等待人工审核, 不触发 AI 回复", message.ID)
+		actor.sendError(actorCtx, "content_held", "消息正在审核中")
+		return result
 	}
-	actor.sendMsgSent(actorCtx, message, event)
 
-	actor.AIRespond(actorCtx, message)
+	agentMessage, err := actor.AIRespond(actorCtx, message, voice)
+	if err != nil {
+		logrus.Errorf("触发 AI 回复失败: %v", err)
+		return result
+	}
+	result.ResponseID = agentMessage.ID
 	logrus.Info("已启动异步处理 AI 聊天消息")
-	return nil
+	return result
+}
+
+func (actor *ChatActor) sendFailed(actorCtx events.ActorContext[*messages.ChatEvent], errorCode string, errorMsg string) *SendResult {
+	actor.sendError(actorCtx, errorCode, errorMsg)
+	return &SendResult{ErrorCode: errorCode, Error: errorMsg}
 }
 
 func (actor *ChatActor) InterruptHandler(actorCtx events.ActorContext[*messages.ChatEvent], event *messages.ChatEvent) error {
@@ -89,11 +218,32 @@ func (actor *ChatActor) InterruptHandler(actorCtx events.ActorContext[*messages.
 		return actor.sendError(actorCtx, "invalid_event", "无效的事件类型")
 	}
 
-	ctrlCtx := agents.NewChatControlContext(actorCtx.Context, agents.CtrlTypeInterrupt, interruptEvent.AgentMessageID)
-	actor.runner.Ctrl(ctrlCtx)
+	// 客户端主动打断时语音播报也一并停止
+	if voice := actor.currentVoice(); voice != nil {
+		voice.StopSpeaking()
+	}
+	actor.interrupt(actorCtx.Context, interruptEvent.AgentMessageID)
 	return nil
 }
 
+// interrupt 打断回复. 续传的回复由断开的连接生成, 不在当前连接的 runner 中, 通过事件日志打断
+func (actor *ChatActor) interrupt(ctx context.Context, agentMessageID string) error {
+	err := actor.ctrlInterrupt(ctx, agentMessageID)
+	if err == nil {
+		return nil
+	}
+	if responseLog := responseLogs.Get(agentMessageID, actor.senderDid); responseLog != nil {
+		responseLog.Interrupt()
+		return nil
+	}
+	return err
+}
+
+func (actor *ChatActor) ctrlInterrupt(ctx context.Context, agentMessageID string) error {
+	ctrlCtx := agents.NewChatControlContext(ctx, agents.CtrlTypeInterrupt, agentMessageID)
+	return actor.runner.Ctrl(ctrlCtx)
+}
+
 func (actor *ChatActor) sendError(actorCtx events.ActorContext[*messages.ChatEvent], errorCode string, errorMsg string) error {
 	errorEvent := &messages.ChatEvent{
 		EventID:   uuid.New().String(),
@@ -107,16 +257,24 @@ func (actor *ChatActor) sendError(actorCtx events.ActorContext[*messages.ChatEve
 	return actor.PublishToOutbox(actorCtx.Context, errorEvent)
 }
 
-func (actor *ChatActor) sendMsgSent(actorCtx events.ActorContext[*messages.ChatEvent], message *messages.Message, event *messages.ChatEvent) error {
+// sendMsgSent 等待审核的消息只分发给发送者自己的其他设备
+func (actor *ChatActor) sendMsgSent(actorCtx events.ActorContext[*messages.ChatEvent], message *messages.Message, event *messages.ChatEvent, held bool) error {
 	sentEvent := &messages.ChatEvent{
 		EventID:   uuid.New().String(),
 		EventType: messages.EventTypeMessageSent,
 		Event: &messages.MessageSentEvent{
 			MessageID: message.ID,
 			EventID:   event.EventID,
+			Message:   message,
 		},
 	}
 	logrus.Infof("发送消息已发送事件: %s", sentEvent.EventID)
+	if held {
+		actor.fanoutToUser(actorCtx.Context, message.SenderID, sentEvent)
+	} else {
+		actor.joinRoom(actorCtx.Context, message)
+		actor.fanoutToRoom(actorCtx.Context, message.RoomID, sentEvent)
+	}
 	return actor.PublishToOutbox(actorCtx.Context, sentEvent)
 }
 
@@ -129,5 +287,48 @@ func (actor *ChatActor) sendMsgReceived(actorCtx events.ActorContext[*messages.C
 		},
 	}
 	logrus.Infof("发送消息已接收事件: %s", receivedEvent.EventID)
+	actor.fanoutToRoom(actorCtx.Context, message.RoomID, receivedEvent)
 	return actor.PublishToOutbox(actorCtx.Context, receivedEvent)
 }
+
+// joinRoom 当前连接和接收者的所有连接加入消息所在的房间, 新房间的第一条消息也能分发到
+func (actor *ChatActor) joinRoom(ctx context.Context, message *messages.Message) {
+	if actor.fanout == nil {
+		return
+	}
+	actor.fanout.JoinRoom(message.RoomID)
+	if message.ReceiverID != "" && message.ReceiverID != message.SenderID {
+		actor.fanout.Hub().JoinRoom(ctx, message.ReceiverID, message.RoomID)
+	}
+}
+
+func (actor *ChatActor) fanoutToRoom(ctx context.Context, roomID string, event *messages.ChatEvent) {
+	if actor.fanout == nil || roomID == "" {
+		return
+	}
+	actor.fanout.Hub().PublishToRoom(ctx, roomID, event, actor.fanout)
+}
+
+func (actor *ChatActor) fanoutToUser(ctx context.Context, did string, event *messages.ChatEvent) {
+	if actor.fanout == nil {
+		return
+	}
+	actor.fanout.Hub().PublishToUser(ctx, did, event, actor.fanout)
+}
+
+// roomErrorCode 房间和成员检查失败时返回给客户端的错误码
+func roomErrorCode(err error) (string, bool) {
+	switch {
+	case errors.Is(err, services.ErrSenderMismatch):
+		return "sender_mismatch", true
+	case errors.Is(err, services.ErrRoomNotFound), errors.Is(err, services.ErrInvalidRoom):
+		return "room_not_found", true
+	case errors.Is(err, services.ErrThreadNotFound):
+		return "thread_not_found", true
+	case errors.Is(err, services.ErrNotRoomMember):
+		return "not_room_member", true
+	case errors.Is(err, services.ErrRoomArchived):
+		return "room_archived", true
+	}
+	return "", false
+}
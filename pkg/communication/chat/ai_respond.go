@@ -11,61 +11,142 @@ import (
 	"github.com/zhongshangwu/avatarai-social/pkg/communication/events"
 	"github.com/zhongshangwu/avatarai-social/pkg/communication/memory"
 	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/realtime"
+	"github.com/zhongshangwu/avatarai-social/pkg/providers/llm"
 	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/services"
 )
 
-func (actor *ChatActor) AIRespond(actorCtx events.ActorContext[*messages.ChatEvent], message *messages.Message) error {
+// AIRespond 异步生成 AI 回复, 返回创建的回复. voice 不为空时回复事件同时交给实时语音会话播报
+func (actor *ChatActor) AIRespond(actorCtx events.ActorContext[*messages.ChatEvent], message *messages.Message, voice *realtime.Turn) (*messages.AgentMessage, error) {
 	logrus.Info("开始处理 AI 聊天消息")
 
 	inputItems, err := actor.convertMsgToInputItems(message)
 	if err != nil {
 		logrus.Errorf("消息转换失败: %v", err)
-		return actor.sendError(actorCtx, "conversion_failed", "消息转换失败")
+		finishVoice(voice)
+		actor.sendError(actorCtx, "conversion_failed", "消息转换失败")
+		return nil, err
 	}
 
 	respondMessage, err := actor.InitRespondMessage(message)
 	if err != nil {
 		logrus.Errorf("初始化响应消息失败: %v", err)
-		return actor.sendError(actorCtx, "init_respond_message_failed", "初始化响应消息失败")
+		finishVoice(voice)
+		actor.sendError(actorCtx, "init_respond_message_failed", "初始化响应消息失败")
+		return nil, err
 	}
 	actor.sendMsgReceived(actorCtx, respondMessage)
 
-	ctx, cancel := context.WithTimeout(actorCtx.Context, 5*time.Minute)
+	// 回复不随连接结束, 没有连接接收时由事件日志在宽限期之后打断
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(actorCtx.Context), 5*time.Minute)
 
 	mem := memory.NewSimpleThreadMemory(actor.MetaStore.DB, message.RoomID, message.ThreadID)
 
+	agentMessage := &respondMessage.Content.(*messages.AgentMessageContent).AgentMessage
 	invokeCtx := agents.NewChatInvokeContext(ctx).
 		WithInputItems(inputItems).
-		WithAgentMessage(&respondMessage.Content.(*messages.AgentMessageContent).AgentMessage).
-		WithMemory(mem)
+		WithAgentMessage(agentMessage).
+		WithMemory(mem).
+		WithRouteRequest(actor.buildRouteRequest(message, len(agentMessage.Tools) > 0))
+
+	if actor.semanticIndexes != nil {
+		semanticMemory := actor.semanticIndexes.Memory(actor.userDid, message.RoomID, message.ThreadID, actor.config.Avatar.Memory.TopK)
+		invokeCtx.WithLongTermMemory(semanticMemory)
+		go actor.remember(semanticMemory, &memory.MessageChunk{ID: message.ID, Content: message})
+	}
+
+	responseLog := responseLogs.Start(agentMessage.ID, message.SenderID, actor.config.Avatar.Resume, func() {
+		if err := actor.ctrlInterrupt(context.Background(), agentMessage.ID); err != nil {
+			logrus.Warnf("打断回复 %s 失败: %v", agentMessage.ID, err)
+		}
+	})
+	// 当前连接断开后不再计入接收者
+	responseLog.Attach()
+	stopWatch := context.AfterFunc(actorCtx.Context, responseLog.Detach)
 
 	go func() {
 		defer cancel()
-		actor.HandleAIResponseStream(invokeCtx)
+		defer func() {
+			responseLogs.Finish(responseLog)
+			if stopWatch() {
+				responseLog.Detach()
+			}
+		}()
+		actor.HandleAIResponseStream(invokeCtx, message.RoomID, voice, responseLog)
 		logrus.Info("所有响应处理完成")
+		// AI 回复已落库, 检查话题是否需要压缩较早的消息
+		if actor.summarizer != nil {
+			actor.summarizer.Trigger(message.RoomID, message.ThreadID)
+		}
 	}()
 
 	if err := actor.runner.Invoke(invokeCtx); err != nil {
 		logrus.Errorf("AI 聊天智能体执行失败: %v", err)
-		return actor.sendError(actorCtx, "ai_respond_failed", "AI 聊天智能体执行失败")
+		actor.sendError(actorCtx, "ai_respond_failed", "AI 聊天智能体执行失败")
+		return nil, err
 	} else {
 		logrus.Info("AI 处理成功完成")
 	}
 
 	logrus.Info("AI 处理完成，已关闭响应流，等待响应处理完成...")
-	return nil
+	return agentMessage, nil
+}
+
+// remember 写入长期记忆, 向量化较慢, 在后台执行
+func (actor *ChatActor) remember(mem memory.Memory, chunk *memory.MessageChunk) {
+	if err := mem.Write(chunk); err != nil {
+		logrus.Errorf("写入语义记忆失败: %v", err)
+	}
+}
+
+// buildRouteRequest 根据用户消息构造模型路由条件, message.ReceiverID 即回复的 Aster DID
+func (actor *ChatActor) buildRouteRequest(message *messages.Message, hasTools bool) *llm.RouteRequest {
+	req := &llm.RouteRequest{
+		AsterDID: message.ReceiverID,
+		ThreadID: message.ThreadID,
+		HasTools: hasTools,
+	}
+
+	if room, err := actor.MetaStore.MessageRepo.GetRoomByID(message.RoomID); err != nil {
+		logrus.Warnf("获取房间 %s 失败, 路由时忽略房间类型: %v", message.RoomID, err)
+	} else {
+		req.RoomType = room.Type
+	}
+
+	switch message.MsgType {
+	case messages.MessageTypeImage, messages.MessageTypeSticker:
+		req.Modalities = []string{"image"}
+	case messages.MessageTypeAudio:
+		req.Modalities = []string{"audio"}
+	case messages.MessageTypeVideo:
+		req.Modalities = []string{"video"}
+	case messages.MessageTypeFile:
+		req.Modalities = []string{"file"}
+	default:
+		req.Modalities = []string{"text"}
+	}
+	return req
 }
 
+// HandleAIResponseStream 持久化 AI 回复事件并写入事件日志和输出流, 同时分发给房间内的其他连接.
+// 连接断开后继续消费响应流, 事件只写入日志, 等待重连后续传
 func (actor *ChatActor) HandleAIResponseStream(
 	invokeCtx *agents.ChatInvokeContext,
+	roomID string,
+	voice *realtime.Turn,
+	responseLog *ResponseLog,
 ) {
 	logrus.Info("开始处理响应流...")
 	defer logrus.Info("响应流处理器退出")
+	// 响应流异常关闭时没有结束事件, 语音播报在这里结束
+	defer finishVoice(voice)
 
 	var currentAgentMessageID string
 	if invokeCtx.Response != nil {
 		currentAgentMessageID = invokeCtx.Response.ID
 	}
+	connected := true
 
 	for {
 		result := invokeCtx.Stream.Recv()
@@ -73,14 +154,29 @@ func (actor *ChatActor) HandleAIResponseStream(
 		if result.HasData {
 			serverEvent := result.Data
 			logrus.Infof("收到事件响应: %v", serverEvent)
+			responseLog.Append(serverEvent)
 
 			if err := actor.handleEventPersistence(serverEvent, currentAgentMessageID); err != nil {
 				logrus.Errorf("持久化事件失败: %v", err)
 			}
+			if completedEvent, ok := serverEvent.Event.(*messages.CompletedEvent); ok && invokeCtx.LongTermMemory != nil {
+				agentMessage := completedEvent.AgentMessage
+				go actor.remember(invokeCtx.LongTermMemory, &memory.MessageChunk{
+					ID:       agentMessage.MessageID,
+					Metadata: map[string]interface{}{"text": agentMessage.AltText},
+				})
+			}
 
+			if voice != nil {
+				voice.HandleEvent(serverEvent)
+			}
+			actor.fanoutToRoom(invokeCtx.Context, roomID, serverEvent)
+			if !connected {
+				continue
+			}
 			if err := actor.PublishToOutbox(invokeCtx.Context, serverEvent); err != nil {
-				logrus.Errorf("发布响应到 outbox 失败: %v", err)
-				return
+				logrus.Infof("发布响应到 outbox 失败, 回复 %s 继续生成: %v", currentAgentMessageID, err)
+				connected = false
 			}
 			continue
 		}
@@ -127,7 +223,10 @@ func (actor *ChatActor) handleAgentMessageCreated(event *messages.ChatEvent) err
 	agentMessage := createdEvent.AgentMessage
 	logrus.Infof("持久化AI消息创建事件: %s", agentMessage.ID)
 
-	return actor.MetaStore.MessageRepo.UpdateAgentMessageStatus(agentMessage.ID, string(agentMessage.Status))
+	if err := actor.MetaStore.MessageRepo.UpdateAgentMessageStatus(agentMessage.ID, string(agentMessage.Status)); err != nil {
+		return err
+	}
+	return actor.MetaStore.MessageRepo.UpdateAgentMessageMetadata(agentMessage.ID, agentMessage.Metadata)
 }
 
 func (actor *ChatActor) handleAgentMessageInProgress(event *messages.ChatEvent) error {
@@ -151,12 +250,20 @@ func (actor *ChatActor) handleAgentMessageCompleted(event *messages.ChatEvent) e
 	agentMessage := completedEvent.AgentMessage
 	logrus.Infof("持久化AI消息完成事件: %s", agentMessage.ID)
 
-	return actor.MetaStore.MessageRepo.UpdateAgentMessageWithUsage(
+	if err := actor.persistAgentMessageMetadata(agentMessage); err != nil {
+		return err
+	}
+	if err := actor.MetaStore.MessageRepo.UpdateAgentMessageWithUsage(
 		agentMessage.ID,
 		string(agentMessage.Status),
 		agentMessage.Usage,
 		agentMessage.AltText,
-	)
+	); err != nil {
+		return err
+	}
+	services.IndexAgentReply(actor.MetaStore, agentMessage)
+	services.MirrorAgentReply(actor.MetaStore, agentMessage)
+	return nil
 }
 
 func (actor *ChatActor) handleAgentMessageFailed(event *messages.ChatEvent) error {
@@ -168,6 +275,9 @@ func (actor *ChatActor) handleAgentMessageFailed(event *messages.ChatEvent) erro
 	agentMessage := failedEvent.AgentMessage
 	logrus.Infof("持久化AI消息失败事件: %s", agentMessage.ID)
 
+	if err := actor.persistAgentMessageMetadata(agentMessage); err != nil {
+		return err
+	}
 	return actor.MetaStore.MessageRepo.UpdateAgentMessageWithError(
 		agentMessage.ID,
 		string(agentMessage.Status),
@@ -184,6 +294,9 @@ func (actor *ChatActor) handleAgentMessageIncomplete(event *messages.ChatEvent)
 	agentMessage := incompleteEvent.AgentMessage
 	logrus.Infof("持久化AI消息不完整事件: %s", agentMessage.ID)
 
+	if err := actor.persistAgentMessageMetadata(agentMessage); err != nil {
+		return err
+	}
 	return actor.MetaStore.MessageRepo.UpdateAgentMessageIncomplete(
 		agentMessage.ID,
 		agentMessage.InterruptType,
@@ -192,6 +305,14 @@ func (actor *ChatActor) handleAgentMessageIncomplete(event *messages.ChatEvent)
 	)
 }
 
+// persistAgentMessageMetadata 回复过程中可能发生模型降级, 结束时再写一次 metadata
+func (actor *ChatActor) persistAgentMessageMetadata(agentMessage *messages.AgentMessage) error {
+	if len(agentMessage.Metadata) == 0 {
+		return nil
+	}
+	return actor.MetaStore.MessageRepo.UpdateAgentMessageMetadata(agentMessage.ID, agentMessage.Metadata)
+}
+
 func (actor *ChatActor) handleOutputItemAdded(event *messages.ChatEvent, agentMessageID string) error {
 	outputItemEvent, ok := event.Event.(*messages.OutputItemAddedEvent)
 	if !ok {
@@ -89,7 +89,7 @@ func BuildMessageFromSendMsgEvent(sendMsgEvent *messages.SendMsgEvent) (*message
 			MimeType: body.MimeType,
 		}
 	default:
-		return nil, fmt.Errorf("unsupported message type: %s", sendMsgEvent.MsgType)
+		return nil, fmt.Errorf("unsupported message type: %d", sendMsgEvent.MsgType)
 	}
 	return message, nil
 }
@@ -120,6 +120,7 @@ func (actor *ChatActor) InitRespondMessage(input *messages.Message) (*messages.M
 		Creator:       input.ReceiverID,
 		CreatedAt:     time.Now().UnixMilli(),
 		UpdatedAt:     time.Now().UnixMilli(),
+		Tools:         actor.extractTools(),
 		Metadata:      make(map[string]interface{}),
 	}
 	dbAgentMessage := actor.MessageService.Converter.AgentMessageToDB(agentMessage)
@@ -136,6 +137,7 @@ func (actor *ChatActor) InitRespondMessage(input *messages.Message) (*messages.M
 		logrus.Errorf("insert message failed: %v", err)
 		return nil, err
 	}
+	actor.RoomService.RecordMessage(message)
 	return message, nil
 }
 
@@ -25,7 +25,7 @@ func (actor *ChatActor) convertMsgToInputItems(message *messages.Message) ([]mes
 	case messages.MessageTypeSticker:
 		return actor.convertStickerMsg(message)
 	default:
-		return nil, fmt.Errorf("不支持的消息类型: %s", message.MsgType)
+		return nil, fmt.Errorf("不支持的消息类型: %d", message.MsgType)
 	}
 }
 
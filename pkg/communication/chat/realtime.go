@@ -0,0 +1,218 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/events"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/realtime"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/providers/speech"
+)
+
+// RealtimeStartHandler 在当前连接上开启实时语音会话, 已有会话时先关闭旧会话
+func (actor *ChatActor) RealtimeStartHandler(actorCtx events.ActorContext[*messages.ChatEvent], event *messages.ChatEvent) error {
+	startEvent, ok := event.Event.(*messages.RealtimeSessionStartEvent)
+	if !ok {
+		logrus.Error("事件类型转换失败，非 RealtimeSessionStartEvent 类型")
+		return actor.sendError(actorCtx, "invalid_event", "无效的事件类型")
+	}
+	// 识别出的语音以当前连接的用户身份发送
+	if actor.senderDid == "" {
+		return actor.sendError(actorCtx, "realtime_unavailable", "当前连接不支持实时语音")
+	}
+	if err := actor.RoomService.CheckSend(actor.senderDid, startEvent.RoomID, startEvent.ThreadID); err != nil {
+		if code, ok := roomErrorCode(err); ok {
+			return actor.sendError(actorCtx, code, err.Error())
+		}
+		logrus.Errorf("检查房间失败: %v", err)
+		return actor.sendError(actorCtx, "realtime_start_failed", "开启实时语音失败")
+	}
+
+	inputFormat, err := audioFormat(startEvent.InputAudioFormat, startEvent.InputSampleRate, speech.DefaultInputFormat)
+	if err != nil {
+		return actor.sendError(actorCtx, "invalid_audio_format", err.Error())
+	}
+	outputFormat, err := audioFormat(startEvent.OutputAudioFormat, startEvent.OutputSampleRate, speech.DefaultOutputFormat)
+	if err != nil {
+		return actor.sendError(actorCtx, "invalid_audio_format", err.Error())
+	}
+
+	realtimeConfig := actor.config.Avatar.Realtime
+	stt, err := speech.NewSpeechToText(realtimeConfig.STT)
+	if err != nil {
+		logrus.Errorf("创建语音识别失败: %v", err)
+		return actor.sendError(actorCtx, "realtime_start_failed", "开启实时语音失败")
+	}
+	tts, err := speech.NewTextToSpeech(realtimeConfig.TTS)
+	if err != nil {
+		logrus.Errorf("创建语音合成失败: %v", err)
+		return actor.sendError(actorCtx, "realtime_start_failed", "开启实时语音失败")
+	}
+
+	// 能量检测需要解码后的采样, opus 输入只能由客户端提交每段语音
+	var vadConfig *config.VADConfig
+	if startEvent.TurnDetection != realtime.TurnDetectionNone && inputFormat.Encoding == speech.EncodingPCM16 {
+		vadConfig = &realtimeConfig.VAD
+	}
+
+	responder := &voiceResponder{
+		actor:      actor,
+		ctx:        actorCtx.Context,
+		roomID:     startEvent.RoomID,
+		threadID:   startEvent.ThreadID,
+		receiverID: startEvent.ReceiverID,
+	}
+	session := realtime.NewSession(realtime.SessionConfig{
+		InputFormat:  inputFormat,
+		OutputFormat: outputFormat,
+		VAD:          vadConfig,
+	}, stt, tts, responder, actor.PublishToOutbox)
+
+	actor.closeVoice()
+	session.Start(actorCtx.Context)
+	actor.mu.Lock()
+	actor.voice = session
+	actor.mu.Unlock()
+	logrus.Infof("用户 %s 开启实时语音会话 %s, 房间: %s", actor.senderDid, session.ID, startEvent.RoomID)
+
+	return actor.PublishToOutbox(actorCtx.Context, &messages.ChatEvent{
+		EventID:   uuid.New().String(),
+		EventType: messages.EventTypeRealtimeSessionStarted,
+		Event: &messages.RealtimeSessionStartedEvent{
+			SessionID:         session.ID,
+			InputAudioFormat:  string(inputFormat.Encoding),
+			InputSampleRate:   inputFormat.SampleRate,
+			OutputAudioFormat: string(outputFormat.Encoding),
+			OutputSampleRate:  outputFormat.SampleRate,
+			TurnDetection:     session.TurnDetection(),
+		},
+	})
+}
+
+func (actor *ChatActor) RealtimeStopHandler(actorCtx events.ActorContext[*messages.ChatEvent], event *messages.ChatEvent) error {
+	session := actor.closeVoice()
+	if session == nil {
+		return nil
+	}
+	return actor.PublishToOutbox(actorCtx.Context, &messages.ChatEvent{
+		EventID:   uuid.New().String(),
+		EventType: messages.EventTypeRealtimeSessionStopped,
+		Event:     &messages.RealtimeSessionStoppedEvent{SessionID: session.ID},
+	})
+}
+
+func (actor *ChatActor) RealtimeAppendHandler(actorCtx events.ActorContext[*messages.ChatEvent], event *messages.ChatEvent) error {
+	appendEvent, ok := event.Event.(*messages.InputAudioAppendEvent)
+	if !ok {
+		logrus.Error("事件类型转换失败，非 InputAudioAppendEvent 类型")
+		return actor.sendError(actorCtx, "invalid_event", "无效的事件类型")
+	}
+	session := actor.currentVoice()
+	if session == nil {
+		return actor.sendError(actorCtx, "realtime_not_started", "实时语音会话未开启")
+	}
+	if err := session.AppendAudio(appendEvent.Audio); err != nil {
+		if errors.Is(err, realtime.ErrAudioBufferFull) {
+			return actor.sendError(actorCtx, "audio_buffer_full", "音频发送过快, 部分音频已丢弃")
+		}
+		return actor.sendError(actorCtx, "realtime_not_started", "实时语音会话未开启")
+	}
+	return nil
+}
+
+func (actor *ChatActor) RealtimeCommitHandler(actorCtx events.ActorContext[*messages.ChatEvent], event *messages.ChatEvent) error {
+	session := actor.currentVoice()
+	if session == nil {
+		return actor.sendError(actorCtx, "realtime_not_started", "实时语音会话未开启")
+	}
+	if err := session.Commit(); err != nil {
+		return actor.sendError(actorCtx, "realtime_not_started", "实时语音会话未开启")
+	}
+	return nil
+}
+
+func (actor *ChatActor) currentVoice() *realtime.Session {
+	actor.mu.RLock()
+	defer actor.mu.RUnlock()
+	return actor.voice
+}
+
+// closeVoice 关闭并返回当前的实时语音会话, 没有会话时返回 nil
+func (actor *ChatActor) closeVoice() *realtime.Session {
+	actor.mu.Lock()
+	session := actor.voice
+	actor.voice = nil
+	actor.mu.Unlock()
+	if session != nil {
+		session.Close()
+		logrus.Infof("实时语音会话 %s 已关闭", session.ID)
+	}
+	return session
+}
+
+// voiceResponder 将识别出的文本作为当前用户的文本消息发送, 回复走与普通消息相同的流程
+type voiceResponder struct {
+	actor      *ChatActor
+	ctx        context.Context // 回复的生命周期跟随连接, 关闭语音会话不影响已经开始的回复
+	roomID     string
+	threadID   string
+	receiverID string
+}
+
+func (r *voiceResponder) Respond(ctx context.Context, transcript string, turn *realtime.Turn) error {
+	sendMsgEvent := &messages.SendMsgEvent{
+		RoomID:     r.roomID,
+		ThreadID:   r.threadID,
+		MsgType:    messages.MessageTypeText,
+		Body:       &messages.TextMsgBody{Text: transcript},
+		ReceiverID: r.receiverID,
+		SenderID:   r.actor.senderDid,
+		SenderAt:   time.Now().UnixMilli(),
+	}
+	event := &messages.ChatEvent{
+		EventID:   uuid.New().String(),
+		EventType: messages.EventTypeMessageSend,
+		Event:     sendMsgEvent,
+	}
+
+	actorCtx := events.ActorContext[*messages.ChatEvent]{Context: r.ctx}
+	result := r.actor.sendMsgAndRespond(actorCtx, event, sendMsgEvent, turn)
+	if result.ResponseID == "" {
+		return realtime.ErrNoReply
+	}
+	return nil
+}
+
+func (r *voiceResponder) Interrupt(ctx context.Context, agentMessageID string) error {
+	return r.actor.interrupt(ctx, agentMessageID)
+}
+
+// audioFormat 解析客户端指定的音频格式, 未指定的字段使用默认值
+func audioFormat(encoding string, sampleRate int, defaults speech.AudioFormat) (speech.AudioFormat, error) {
+	format := defaults
+	switch speech.AudioEncoding(encoding) {
+	case "":
+	case speech.EncodingPCM16, speech.EncodingOpus:
+		format.Encoding = speech.AudioEncoding(encoding)
+	default:
+		return format, errors.New("不支持的音频编码: " + encoding)
+	}
+	if sampleRate < 0 || sampleRate > 48000 {
+		return format, errors.New("不支持的采样率")
+	}
+	if sampleRate > 0 {
+		format.SampleRate = sampleRate
+	}
+	return format, nil
+}
+
+func finishVoice(voice *realtime.Turn) {
+	if voice != nil {
+		voice.Finish()
+	}
+}
@@ -0,0 +1,171 @@
+package chat
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+)
+
+// responseLogs 进程内所有回复的事件日志, 连接断开重连后由新的 ChatActor 按回复 ID 找回
+var responseLogs = newResponseLogRegistry()
+
+// ResponseLog 一次 AI 回复的事件日志. 事件按顺序编号并保留最近一段, 重连的客户端从指定编号之后补发并继续接收.
+// 没有连接接收时回复继续生成, 超过宽限期后打断
+type ResponseLog struct {
+	ID  string
+	did string // 触发回复的用户, 只有该用户可以续传
+
+	config    config.ResumeConfig
+	interrupt func()
+
+	mu        sync.Mutex
+	seq       uint64
+	events    []*messages.ChatEvent
+	wake      chan struct{} // 有新事件或回复结束时关闭并替换
+	done      bool
+	attached  int
+	idleTimer *time.Timer
+}
+
+// Append 为事件编号并写入日志, 唤醒等待中的连接
+func (l *ResponseLog) Append(event *messages.ChatEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.done {
+		return
+	}
+	l.seq++
+	event.Seq = l.seq
+	l.events = append(l.events, event)
+	if l.config.MaxEvents > 0 && len(l.events) > l.config.MaxEvents {
+		l.events = append(l.events[:0], l.events[len(l.events)-l.config.MaxEvents:]...)
+	}
+	l.notify()
+}
+
+// Finish 回复结束, 不会再有新的事件
+func (l *ResponseLog) Finish() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.done {
+		return
+	}
+	l.done = true
+	if l.idleTimer != nil {
+		l.idleTimer.Stop()
+		l.idleTimer = nil
+	}
+	l.notify()
+}
+
+// EventsAfter 返回编号大于 after 的事件和新事件到达时关闭的通道. 要求的事件已经移出日志时 lost 为 true
+func (l *ResponseLog) EventsAfter(after uint64) (pending []*messages.ChatEvent, wake <-chan struct{}, done bool, lost bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.events) > 0 && l.events[0].Seq > after+1 {
+		lost = true
+	}
+	for i, event := range l.events {
+		if event.Seq > after {
+			pending = append(pending, l.events[i:]...)
+			break
+		}
+	}
+	return pending, l.wake, l.done, lost
+}
+
+// Attach 有连接开始接收回复事件
+func (l *ResponseLog) Attach() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.attached++
+	if l.idleTimer != nil {
+		l.idleTimer.Stop()
+		l.idleTimer = nil
+	}
+}
+
+// Detach 连接不再接收, 最后一个连接离开后开始计算宽限期
+func (l *ResponseLog) Detach() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.attached--
+	if l.attached == 0 && !l.done {
+		l.idleTimer = time.AfterFunc(l.config.GracePeriod, l.interruptIfIdle)
+	}
+}
+
+// Interrupt 打断正在生成的回复, 用于其他连接上的 agent_message.interrupt
+func (l *ResponseLog) Interrupt() {
+	l.mu.Lock()
+	done := l.done
+	l.mu.Unlock()
+	if !done {
+		l.interrupt()
+	}
+}
+
+func (l *ResponseLog) interruptIfIdle() {
+	l.mu.Lock()
+	idle := l.attached == 0 && !l.done
+	l.mu.Unlock()
+	if idle {
+		logrus.Infof("回复 %s 超过 %s 没有连接接收, 打断生成", l.ID, l.config.GracePeriod)
+		l.interrupt()
+	}
+}
+
+func (l *ResponseLog) notify() {
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
+
+type responseLogRegistry struct {
+	mu   sync.Mutex
+	logs map[string]*ResponseLog
+}
+
+func newResponseLogRegistry() *responseLogRegistry {
+	return &responseLogRegistry{logs: make(map[string]*ResponseLog)}
+}
+
+// Start 为新回复创建事件日志, interrupt 在宽限期结束或其他连接请求打断时调用
+func (r *responseLogRegistry) Start(responseID string, did string, resumeConfig config.ResumeConfig, interrupt func()) *ResponseLog {
+	log := &ResponseLog{
+		ID:        responseID,
+		did:       did,
+		config:    resumeConfig,
+		interrupt: interrupt,
+		wake:      make(chan struct{}),
+	}
+	r.mu.Lock()
+	r.logs[responseID] = log
+	r.mu.Unlock()
+	return log
+}
+
+// Get 只返回 did 触发的回复, did 为空时不校验
+func (r *responseLogRegistry) Get(responseID string, did string) *ResponseLog {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	log, ok := r.logs[responseID]
+	if !ok || (did != "" && log.did != did) {
+		return nil
+	}
+	return log
+}
+
+// Finish 结束回复, 事件在 Retention 内仍可补发, 之后从注册表移除
+func (r *responseLogRegistry) Finish(log *ResponseLog) {
+	log.Finish()
+	time.AfterFunc(log.config.Retention, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.logs[log.ID] == log {
+			delete(r.logs, log.ID)
+		}
+	})
+}
@@ -0,0 +1,246 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/events"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const testDID = "did:plc:alice"
+
+func newTestMetaStore(t *testing.T) *repositories.MetaStore {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.sqlite")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	store := repositories.NewMetaStore(db)
+	if err := store.Init(); err != nil {
+		t.Fatalf("init metastore: %v", err)
+	}
+	return store
+}
+
+// testConnection 一个重连后的连接: 独立的 ChatActor 和持续读取其输出流的协程
+type testConnection struct {
+	actor  *ChatActor
+	events chan *messages.ChatEvent
+}
+
+func newTestConnection(t *testing.T, store *repositories.MetaStore) *testConnection {
+	t.Helper()
+	actor := NewChatActor("chat", store, &config.SocialConfig{}, events.ActorWithOutboxCapacity[*messages.ChatEvent](1024))
+	if err := actor.Start(context.Background()); err != nil {
+		t.Fatalf("start actor: %v", err)
+	}
+	actor.RestrictSender(testDID)
+	conn := &testConnection{actor: actor, events: make(chan *messages.ChatEvent, 4096)}
+	go func() {
+		defer close(conn.events)
+		for {
+			event, err := actor.ReceiveFromOutbox(context.Background())
+			if err != nil {
+				return
+			}
+			conn.events <- event
+		}
+	}()
+	t.Cleanup(func() { actor.Stop() })
+	return conn
+}
+
+func (c *testConnection) resume(t *testing.T, responseID string, afterSeq uint64) {
+	t.Helper()
+	err := c.actor.ResumeHandler(events.ActorContext[*messages.ChatEvent]{Context: context.Background()}, &messages.ChatEvent{
+		EventID:   uuid.New().String(),
+		EventType: messages.EventTypeAgentMessageResume,
+		Event:     &messages.ResumeEvent{ResponseID: responseID, AfterSeq: afterSeq},
+	})
+	if err != nil {
+		t.Errorf("resume: %v", err)
+	}
+}
+
+// next 可能在测试以外的协程中调用, 失败时记录错误并返回 nil
+func (c *testConnection) next(t *testing.T) *messages.ChatEvent {
+	t.Helper()
+	select {
+	case event, ok := <-c.events:
+		if !ok {
+			t.Error("connection closed")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Error("timed out waiting for a resumed event")
+		return nil
+	}
+}
+
+func deltaEvent(n int) *messages.ChatEvent {
+	return &messages.ChatEvent{
+		EventID:   fmt.Sprintf("delta%d", n),
+		EventType: messages.EventTypeAgentMessageOutputTextDelta,
+		Event:     &messages.TextDeltaEvent{Delta: fmt.Sprintf("%d ", n)},
+	}
+}
+
+func errorCode(event *messages.ChatEvent) string {
+	if body, ok := event.Event.(*messages.ErrorEvent); ok && body.Code != nil {
+		return *body.Code
+	}
+	return ""
+}
+
+func testResumeConfig() config.ResumeConfig {
+	return config.ResumeConfig{GracePeriod: time.Minute, MaxEvents: 4096, Retention: time.Minute}
+}
+
+// TestResumeReplaysWhileGenerating 多个连接在回复生成过程中从不同位置续传, 在 -race 下检查事件日志的并发读写,
+// 每个连接都应收到连续且不重复的事件直到回复结束
+func TestResumeReplaysWhileGenerating(t *testing.T) {
+	store := newTestMetaStore(t)
+	responseID := uuid.New().String()
+	responseLog := responseLogs.Start(responseID, testDID, testResumeConfig(), func() {})
+
+	const total = 300
+	var generated sync.WaitGroup
+	generated.Add(1)
+	go func() {
+		defer generated.Done()
+		for i := 1; i <= total; i++ {
+			responseLog.Append(deltaEvent(i))
+			if i%25 == 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+		responseLogs.Finish(responseLog)
+	}()
+
+	var followers sync.WaitGroup
+	for _, after := range []uint64{0, 0, 10, 50, 120} {
+		conn := newTestConnection(t, store)
+		followers.Add(1)
+		go func(after uint64) {
+			defer followers.Done()
+			// 等待日志中至少有 after 个事件, 模拟客户端断线前已收到的部分
+			for {
+				pending, wake, _, _ := responseLog.EventsAfter(0)
+				if uint64(len(pending)) >= after {
+					break
+				}
+				<-wake
+			}
+			conn.resume(t, responseID, after)
+			for seq := after + 1; seq <= total; seq++ {
+				event := conn.next(t)
+				if event == nil {
+					return
+				}
+				if event.Seq != seq || event.EventID != fmt.Sprintf("delta%d", seq) {
+					t.Errorf("resume after %d: got seq %d (%s), want %d", after, event.Seq, event.EventID, seq)
+					return
+				}
+			}
+		}(after)
+	}
+	generated.Wait()
+	followers.Wait()
+
+	// 回复结束后续传只补发剩余事件
+	conn := newTestConnection(t, store)
+	conn.resume(t, responseID, total-1)
+	if event := conn.next(t); event == nil || event.Seq != total {
+		t.Errorf("resume after finish: got %+v, want seq %d", event, total)
+	}
+}
+
+func TestResumeReportsLostEventsAndUnknownResponses(t *testing.T) {
+	store := newTestMetaStore(t)
+	resumeConfig := testResumeConfig()
+	resumeConfig.MaxEvents = 5
+	responseID := uuid.New().String()
+	responseLog := responseLogs.Start(responseID, testDID, resumeConfig, func() {})
+	for i := 1; i <= 8; i++ {
+		responseLog.Append(deltaEvent(i))
+	}
+	responseLogs.Finish(responseLog)
+
+	conn := newTestConnection(t, store)
+	conn.resume(t, responseID, 1)
+	if event := conn.next(t); event == nil || errorCode(event) != "events_lost" {
+		t.Fatalf("first event = %+v, want events_lost", event)
+	}
+	for seq := uint64(4); seq <= 8; seq++ {
+		if event := conn.next(t); event == nil || event.Seq != seq {
+			t.Fatalf("got %+v, want seq %d", event, seq)
+		}
+	}
+
+	// 其他用户不能续传, 不存在的回复返回错误
+	if responseLogs.Get(responseID, "did:plc:mallory") != nil {
+		t.Error("another user can resume alice's response")
+	}
+	conn.resume(t, uuid.New().String(), 0)
+	if event := conn.next(t); event == nil || errorCode(event) != "response_not_found" {
+		t.Errorf("unknown response event = %+v, want response_not_found", event)
+	}
+}
+
+func TestResponseLogInterruptsAfterGracePeriod(t *testing.T) {
+	resumeConfig := testResumeConfig()
+	resumeConfig.GracePeriod = 20 * time.Millisecond
+	var interrupts atomic.Int32
+	responseLog := responseLogs.Start(uuid.New().String(), testDID, resumeConfig, func() { interrupts.Add(1) })
+
+	// 宽限期内重连不打断
+	responseLog.Attach()
+	responseLog.Detach()
+	responseLog.Attach()
+	time.Sleep(3 * resumeConfig.GracePeriod)
+	if n := interrupts.Load(); n != 0 {
+		t.Fatalf("interrupted %d times while a connection was attached", n)
+	}
+
+	// 最后一个连接离开后超过宽限期打断生成
+	responseLog.Detach()
+	deadline := time.Now().Add(2 * time.Second)
+	for interrupts.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("response was not interrupted after the grace period")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// 回复结束后不再打断, 事件在保留期后移出注册表
+	resumeConfig.Retention = 20 * time.Millisecond
+	finished := responseLogs.Start(uuid.New().String(), testDID, resumeConfig, func() { interrupts.Add(1) })
+	finished.Attach()
+	finished.Detach()
+	responseLogs.Finish(finished)
+	finished.Interrupt()
+	if responseLogs.Get(finished.ID, testDID) == nil {
+		t.Error("finished response dropped before its retention")
+	}
+	time.Sleep(3 * resumeConfig.GracePeriod)
+	if n := interrupts.Load(); n != 1 {
+		t.Errorf("interrupted %d times, want only the idle response", n)
+	}
+	if responseLogs.Get(finished.ID, testDID) != nil {
+		t.Error("finished response still registered after its retention")
+	}
+}
@@ -0,0 +1,58 @@
+package chat
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/events"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+)
+
+// ResumeHandler 重连后续传回复: 补发序号大于 afterSeq 的事件, 回复仍在生成时继续接收直到结束
+func (actor *ChatActor) ResumeHandler(actorCtx events.ActorContext[*messages.ChatEvent], event *messages.ChatEvent) error {
+	resumeEvent, ok := event.Event.(*messages.ResumeEvent)
+	if !ok {
+		logrus.Error("事件类型转换失败，非 ResumeEvent 类型")
+		return actor.sendError(actorCtx, "invalid_event", "无效的事件类型")
+	}
+
+	responseLog := responseLogs.Get(resumeEvent.ResponseID, actor.senderDid)
+	if responseLog == nil {
+		return actor.sendError(actorCtx, "response_not_found", "回复不存在或已过期")
+	}
+	logrus.Infof("续传回复 %s, 从序号 %d 之后开始", resumeEvent.ResponseID, resumeEvent.AfterSeq)
+
+	// 回复可能还要生成很久, 不能阻塞 actor 处理后续事件
+	responseLog.Attach()
+	go actor.followResponse(actorCtx.Context, responseLog, resumeEvent.AfterSeq)
+	return nil
+}
+
+// followResponse 将事件日志中的事件写入当前连接的输出流, 直到回复结束或连接断开
+func (actor *ChatActor) followResponse(ctx context.Context, responseLog *ResponseLog, after uint64) {
+	defer responseLog.Detach()
+
+	actorCtx := events.ActorContext[*messages.ChatEvent]{Context: ctx}
+	for {
+		pending, wake, done, lost := responseLog.EventsAfter(after)
+		if lost {
+			actor.sendError(actorCtx, "events_lost", "部分回复事件已过期, 请在回复结束后重新拉取该消息")
+		}
+		for _, event := range pending {
+			if err := actor.PublishToOutbox(ctx, event); err != nil {
+				logrus.Infof("续传回复 %s 中断: %v", responseLog.ID, err)
+				return
+			}
+			after = event.Seq
+		}
+		if done {
+			return
+		}
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -1,30 +1,52 @@
 package chat
 
 import (
+	"context"
+
 	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/moderation"
+	"github.com/zhongshangwu/avatarai-social/pkg/services"
 )
 
-func (actor *ChatActor) SendMsg(sendMsgEvent *messages.SendMsgEvent) (*messages.Message, error) {
+// SendMsg 返回的 held 表示消息等待人工审核, 此时不触发 AI 回复
+func (actor *ChatActor) SendMsg(ctx context.Context, sendMsgEvent *messages.SendMsgEvent) (message *messages.Message, held bool, err error) {
 	// 一个通用的 IM 消息发送流程：
 	// 1. 构建消息, 格式化消息格式
-	// 2. 用户状态、权限和好友关系检查 (暂时忽略)
-	// 3. 内容审核 (暂时忽略)
+	// 2. 发送者、房间成员和话题检查
+	// 3. 内容审核: 拒绝的消息不落库, 等待审核的消息正常保存
 	// 4. 存储消息
 	// 5. 消息分发, Websocket 或者 IM Push 通知 (暂时忽略)
-	// 6. 后处理: 更新最后会话时间、最后活跃等等 (暂时忽略)
-	message, err := BuildMessageFromSendMsgEvent(sendMsgEvent)
+	// 6. 后处理: 更新房间最后消息和成员未读数, 同步到 PDS, 等待审核的消息不计入
+	message, err = BuildMessageFromSendMsgEvent(sendMsgEvent)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+
+	if actor.senderDid != "" && message.SenderID != actor.senderDid {
+		return nil, false, services.ErrSenderMismatch
+	}
+	if err := actor.RoomService.CheckSend(message.SenderID, message.RoomID, message.ThreadID); err != nil {
+		return nil, false, err
 	}
 
-	// if message.SenderID != actor.User.Did {
-	// 	return nil, errors.New("invalid sender id")
-	// }
+	decision, err := actor.ModerationService.Moderate(ctx, services.MessageSubject(message))
+	if err != nil {
+		return nil, false, err
+	}
+	if decision.Verdict == moderation.VerdictReject {
+		return nil, false, services.ErrContentRejected
+	}
 
 	dbMessage := actor.MessageService.Converter.MessageToDB(message)
 	if err := actor.MetaStore.MessageRepo.InsertMessage(dbMessage); err != nil {
-		return nil, err
+		return nil, false, err
 	}
+	services.IndexMessage(actor.MetaStore, message)
 
-	return message, nil
+	held = decision.Verdict == moderation.VerdictHold
+	if !held {
+		actor.RoomService.RecordMessage(message)
+		services.MirrorMessage(actor.MetaStore, message)
+	}
+	return message, held, nil
 }
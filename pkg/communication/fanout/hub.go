@@ -0,0 +1,311 @@
+package fanout
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+)
+
+const subscriptionBufferSize = 256
+
+const (
+	envelopeEvent = "event"
+	envelopeJoin  = "join"  // 让用户的所有连接加入房间
+	envelopeLeave = "leave" // 让用户的所有连接退出房间
+)
+
+// envelope 在 Transport 上传输的消息
+type envelope struct {
+	Origin  string              `json:"origin"`            // 发布方 Hub 的 ID, 本地已经投递过的不再重复投递
+	Kind    string              `json:"kind"`              // event, join, leave
+	RoomID  string              `json:"roomId,omitempty"`  // join, leave 时的房间
+	Exclude string              `json:"exclude,omitempty"` // 已经通过自身输出流收到事件的连接
+	Event   *messages.ChatEvent `json:"event,omitempty"`
+}
+
+func userTopic(did string) string {
+	return "user:" + did
+}
+
+func roomTopic(roomID string) string {
+	return "room:" + roomID
+}
+
+// Hub 按用户 DID 和房间 ID 将聊天事件分发给所有在线连接. 每个 WebSocket 连接持有一个订阅,
+// 订阅默认只接收自己用户的事件, 加入房间后接收房间内的事件. 事件先投递给本地订阅,
+// 再通过 Transport 转发给其他引擎实例
+type Hub struct {
+	id        string
+	transport Transport
+
+	mu     sync.RWMutex
+	topics map[string]map[*Subscription]struct{}
+	cancel map[string]func() // 主题 -> 取消 Transport 订阅
+}
+
+type Subscription struct {
+	hub    *Hub
+	id     string
+	did    string
+	events chan *messages.ChatEvent
+
+	mu     sync.Mutex
+	rooms  map[string]struct{}
+	closed bool
+
+	topicMu sync.Mutex // 串行化房间主题的加入和退出
+}
+
+var (
+	defaultHubMu sync.RWMutex
+	defaultHub   = NewHub(NewMemoryTransport())
+)
+
+// Default 聊天连接使用的全局 Hub, 默认只在进程内分发
+func Default() *Hub {
+	defaultHubMu.RLock()
+	defer defaultHubMu.RUnlock()
+	return defaultHub
+}
+
+// SetDefault 替换全局 Hub, 多实例部署时在启动阶段接入 Redis 或 NATS 的 Transport
+func SetDefault(hub *Hub) {
+	defaultHubMu.Lock()
+	defer defaultHubMu.Unlock()
+	defaultHub = hub
+}
+
+func NewHub(transport Transport) *Hub {
+	return &Hub{
+		id:        uuid.New().String(),
+		transport: transport,
+		topics:    make(map[string]map[*Subscription]struct{}),
+		cancel:    make(map[string]func()),
+	}
+}
+
+// Subscribe 为一个连接创建订阅, 连接断开时需要调用 Close
+func (h *Hub) Subscribe(did string) *Subscription {
+	sub := &Subscription{
+		hub:    h,
+		id:     uuid.New().String(),
+		did:    did,
+		events: make(chan *messages.ChatEvent, subscriptionBufferSize),
+		rooms:  make(map[string]struct{}),
+	}
+	h.addToTopic(userTopic(did), sub)
+	return sub
+}
+
+// PublishToRoom 将事件分发给房间内的所有连接, from 为事件的来源连接, 它已经从自身的输出流收到事件
+func (h *Hub) PublishToRoom(ctx context.Context, roomID string, event *messages.ChatEvent, from *Subscription) {
+	h.publish(ctx, roomTopic(roomID), &envelope{Kind: envelopeEvent, Event: event}, from)
+}
+
+// PublishToUser 将事件分发给用户的所有连接
+func (h *Hub) PublishToUser(ctx context.Context, did string, event *messages.ChatEvent, from *Subscription) {
+	h.publish(ctx, userTopic(did), &envelope{Kind: envelopeEvent, Event: event}, from)
+}
+
+// JoinRoom 让用户在所有实例上的连接加入房间, 用于新建房间或用户第一次收到房间消息之前
+func (h *Hub) JoinRoom(ctx context.Context, did string, roomID string) {
+	h.publish(ctx, userTopic(did), &envelope{Kind: envelopeJoin, RoomID: roomID}, nil)
+}
+
+// LeaveRoom 让用户在所有实例上的连接退出房间, 用于退出房间之后
+func (h *Hub) LeaveRoom(ctx context.Context, did string, roomID string) {
+	h.publish(ctx, userTopic(did), &envelope{Kind: envelopeLeave, RoomID: roomID}, nil)
+}
+
+func (h *Hub) publish(ctx context.Context, topic string, env *envelope, from *Subscription) {
+	env.Origin = h.id
+	if from != nil {
+		env.Exclude = from.id
+	}
+	h.deliver(topic, env)
+
+	payload, err := json.Marshal(env)
+	if err != nil {
+		logrus.Errorf("序列化分发事件失败: %v", err)
+		return
+	}
+	if err := h.transport.Publish(ctx, topic, payload); err != nil {
+		logrus.Warnf("转发主题 %s 的事件失败: %v", topic, err)
+	}
+}
+
+// receive 处理 Transport 转发来的消息, 自己发布的消息已经在本地投递过
+func (h *Hub) receive(topic string, payload []byte) {
+	// 先解析信封, 确认不是自己发布的消息后再解析事件
+	var wire struct {
+		envelope
+		Event json.RawMessage `json:"event,omitempty"`
+	}
+	if err := json.Unmarshal(payload, &wire); err != nil {
+		logrus.Warnf("解析主题 %s 的分发消息失败: %v", topic, err)
+		return
+	}
+	if wire.Origin == h.id {
+		return
+	}
+	env := wire.envelope
+	if len(wire.Event) > 0 {
+		env.Event = &messages.ChatEvent{}
+		if err := json.Unmarshal(wire.Event, env.Event); err != nil {
+			logrus.Warnf("解析主题 %s 的分发事件失败: %v", topic, err)
+			return
+		}
+	}
+	h.deliver(topic, &env)
+}
+
+func (h *Hub) deliver(topic string, env *envelope) {
+	h.mu.RLock()
+	subs := make([]*Subscription, 0, len(h.topics[topic]))
+	for sub := range h.topics[topic] {
+		if sub.id != env.Exclude {
+			subs = append(subs, sub)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, sub := range subs {
+		switch env.Kind {
+		case envelopeJoin:
+			sub.JoinRoom(env.RoomID)
+		case envelopeLeave:
+			sub.LeaveRoom(env.RoomID)
+		case envelopeEvent:
+			sub.send(env.Event)
+		}
+	}
+}
+
+func (h *Hub) addToTopic(topic string, sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.topics[topic] == nil {
+		h.topics[topic] = make(map[*Subscription]struct{})
+		cancel, err := h.transport.Subscribe(topic, func(payload []byte) {
+			h.receive(topic, payload)
+		})
+		if err != nil {
+			logrus.Warnf("订阅主题 %s 失败, 只接收本实例的事件: %v", topic, err)
+		} else {
+			h.cancel[topic] = cancel
+		}
+	}
+	h.topics[topic][sub] = struct{}{}
+}
+
+func (h *Hub) removeFromTopic(topic string, sub *Subscription) {
+	h.mu.Lock()
+	delete(h.topics[topic], sub)
+	var cancel func()
+	if len(h.topics[topic]) == 0 {
+		delete(h.topics, topic)
+		cancel = h.cancel[topic]
+		delete(h.cancel, topic)
+	}
+	h.mu.Unlock()
+
+	// 取消订阅会等待 Transport 的投递协程退出, 不能持有锁
+	if cancel != nil {
+		cancel()
+	}
+}
+
+func (s *Subscription) ID() string {
+	return s.id
+}
+
+func (s *Subscription) Hub() *Hub {
+	return s.hub
+}
+
+func (s *Subscription) Events() <-chan *messages.ChatEvent {
+	return s.events
+}
+
+// JoinRoom 接收房间内的事件, 重复加入时忽略
+func (s *Subscription) JoinRoom(roomID string) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	if _, ok := s.rooms[roomID]; ok {
+		s.mu.Unlock()
+		return
+	}
+	s.rooms[roomID] = struct{}{}
+	s.mu.Unlock()
+
+	s.syncRoom(roomID)
+}
+
+// LeaveRoom 不再接收房间内的事件
+func (s *Subscription) LeaveRoom(roomID string) {
+	s.mu.Lock()
+	if _, ok := s.rooms[roomID]; !ok {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.rooms, roomID)
+	s.mu.Unlock()
+
+	s.syncRoom(roomID)
+}
+
+// syncRoom 按订阅当前的房间集合加入或退出房间主题. 加入, 退出和关闭可能在不同协程中交错,
+// 以持有 topicMu 时读到的状态为准, 避免已退出或已关闭的订阅残留在主题中
+func (s *Subscription) syncRoom(roomID string) {
+	s.topicMu.Lock()
+	defer s.topicMu.Unlock()
+
+	s.mu.Lock()
+	_, joined := s.rooms[roomID]
+	s.mu.Unlock()
+
+	if joined {
+		s.hub.addToTopic(roomTopic(roomID), s)
+	} else {
+		s.hub.removeFromTopic(roomTopic(roomID), s)
+	}
+}
+
+// send 缓冲区满时丢弃事件, 客户端可以通过历史消息接口补齐
+func (s *Subscription) send(event *messages.ChatEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.events <- event:
+	default:
+		logrus.Warnf("用户 %s 的连接 %s 分发缓冲区已满, 丢弃事件 %s", s.did, s.id, event.EventType)
+	}
+}
+
+func (s *Subscription) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	rooms := s.rooms
+	s.rooms = nil
+	close(s.events)
+	s.mu.Unlock()
+
+	s.hub.removeFromTopic(userTopic(s.did), s)
+	for roomID := range rooms {
+		s.syncRoom(roomID)
+	}
+}
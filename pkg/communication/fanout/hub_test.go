@@ -0,0 +1,230 @@
+package fanout
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+)
+
+func sentEvent(id string) *messages.ChatEvent {
+	return &messages.ChatEvent{
+		EventID:   id,
+		EventType: messages.EventTypeMessageSent,
+		Event:     &messages.MessageSentEvent{MessageID: id},
+	}
+}
+
+func receive(t *testing.T, sub *Subscription) *messages.ChatEvent {
+	t.Helper()
+	select {
+	case event := <-sub.Events():
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatalf("subscription %s received nothing", sub.ID())
+		return nil
+	}
+}
+
+func expectNothing(t *testing.T, sub *Subscription) {
+	t.Helper()
+	select {
+	case event := <-sub.Events():
+		t.Fatalf("subscription %s received unexpected event %s", sub.ID(), event.EventID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// topicCount 返回 Hub 上仍有订阅的主题数和 Transport 订阅数
+func topicCount(h *Hub) (int, int) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.topics), len(h.cancel)
+}
+
+func TestHubDeliversRoomAndUserEvents(t *testing.T) {
+	hub := NewHub(NewMemoryTransport())
+	ctx := context.Background()
+	alicePhone := hub.Subscribe("did:plc:alice")
+	aliceLaptop := hub.Subscribe("did:plc:alice")
+	bob := hub.Subscribe("did:plc:bob")
+	carol := hub.Subscribe("did:plc:carol")
+
+	hub.JoinRoom(ctx, "did:plc:alice", "room1")
+	bob.JoinRoom("room1")
+
+	// 发送方连接已经从自身的输出流收到事件, 不再重复投递
+	hub.PublishToRoom(ctx, "room1", sentEvent("e1"), alicePhone)
+	for _, sub := range []*Subscription{aliceLaptop, bob} {
+		if event := receive(t, sub); event.EventID != "e1" {
+			t.Errorf("event = %s, want e1", event.EventID)
+		}
+	}
+	expectNothing(t, alicePhone)
+	expectNothing(t, carol)
+
+	hub.PublishToUser(ctx, "did:plc:bob", sentEvent("e2"), nil)
+	if event := receive(t, bob); event.EventID != "e2" {
+		t.Errorf("event = %s, want e2", event.EventID)
+	}
+	expectNothing(t, aliceLaptop)
+
+	hub.LeaveRoom(ctx, "did:plc:alice", "room1")
+	hub.PublishToRoom(ctx, "room1", sentEvent("e3"), nil)
+	if event := receive(t, bob); event.EventID != "e3" {
+		t.Errorf("event = %s, want e3", event.EventID)
+	}
+	expectNothing(t, alicePhone)
+	expectNothing(t, aliceLaptop)
+
+	for _, sub := range []*Subscription{alicePhone, aliceLaptop, bob, carol} {
+		sub.Close()
+	}
+	if topics, cancels := topicCount(hub); topics != 0 || cancels != 0 {
+		t.Errorf("%d topics and %d transport subscriptions left after close", topics, cancels)
+	}
+	// 关闭后的订阅不再接收事件, 也不会因为向已关闭的通道发送而 panic
+	hub.PublishToRoom(ctx, "room1", sentEvent("e4"), nil)
+	bob.JoinRoom("room2")
+}
+
+func TestHubForwardsAcrossInstances(t *testing.T) {
+	pubsub := NewFakePubSub()
+	hubA := NewHub(NewPubSubTransport(pubsub, "chat:"))
+	hubB := NewHub(NewPubSubTransport(pubsub, "chat:"))
+	ctx := context.Background()
+
+	alice := hubA.Subscribe("did:plc:alice")
+	bob := hubB.Subscribe("did:plc:bob")
+	defer alice.Close()
+	defer bob.Close()
+
+	// 房间成员关系通过用户主题转发到 bob 所在的实例
+	alice.JoinRoom("room1")
+	hubA.JoinRoom(ctx, "did:plc:bob", "room1")
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		bob.mu.Lock()
+		_, joined := bob.rooms["room1"]
+		bob.mu.Unlock()
+		if joined {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("bob did not join room1 on the other instance")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	hubA.PublishToRoom(ctx, "room1", sentEvent("e1"), alice)
+	event := receive(t, bob)
+	if event.EventID != "e1" {
+		t.Fatalf("event = %s, want e1", event.EventID)
+	}
+	if sent, ok := event.Event.(*messages.MessageSentEvent); !ok || sent.MessageID != "e1" {
+		t.Errorf("event body = %#v, want the decoded MessageSentEvent", event.Event)
+	}
+	// 发布方实例收到 Transport 回传的消息时不重复投递
+	expectNothing(t, alice)
+
+	hubB.PublishToRoom(ctx, "room1", sentEvent("e2"), bob)
+	if event := receive(t, alice); event.EventID != "e2" {
+		t.Errorf("event = %s, want e2", event.EventID)
+	}
+	expectNothing(t, bob)
+}
+
+// TestHubConcurrentSubscribers 在 -race 下检查订阅, 加入/退出房间, 发布和关闭并发进行时的数据竞争,
+// 并确认所有连接关闭后主题和 Transport 订阅都被清理
+func TestHubConcurrentSubscribers(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		transport func() Transport
+	}{
+		{name: "memory", transport: func() Transport { return NewMemoryTransport() }},
+		{name: "pubsub", transport: func() Transport { return NewPubSubTransport(NewFakePubSub(), "chat:") }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hub := NewHub(tc.transport())
+			ctx := context.Background()
+			const users = 8
+			const rounds = 50
+
+			var wg sync.WaitGroup
+			for i := 0; i < users; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					did := fmt.Sprintf("did:plc:user%d", i)
+					for round := 0; round < rounds; round++ {
+						sub := hub.Subscribe(did)
+						room := fmt.Sprintf("room%d", round%3)
+
+						var inner sync.WaitGroup
+						inner.Add(3)
+						go func() {
+							defer inner.Done()
+							sub.JoinRoom(room)
+							hub.JoinRoom(ctx, did, "lobby")
+							sub.LeaveRoom(room)
+						}()
+						go func() {
+							defer inner.Done()
+							hub.PublishToRoom(ctx, "lobby", sentEvent(fmt.Sprintf("%s-%d", did, round)), sub)
+							hub.PublishToUser(ctx, did, sentEvent("direct"), nil)
+						}()
+						go func() {
+							defer inner.Done()
+							for {
+								select {
+								case _, ok := <-sub.Events():
+									if !ok {
+										return
+									}
+								case <-time.After(time.Millisecond):
+									sub.Close()
+								}
+							}
+						}()
+						inner.Wait()
+						sub.Close()
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			if topics, cancels := topicCount(hub); topics != 0 || cancels != 0 {
+				t.Errorf("%d topics and %d transport subscriptions left after all subscriptions closed", topics, cancels)
+			}
+		})
+	}
+}
+
+func TestSubscriptionCloseWhileJoining(t *testing.T) {
+	hub := NewHub(NewMemoryTransport())
+	for i := 0; i < 2000; i++ {
+		sub := hub.Subscribe("did:plc:alice")
+		var wg sync.WaitGroup
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			sub.JoinRoom("room1")
+		}()
+		go func() {
+			defer wg.Done()
+			sub.LeaveRoom("room1")
+		}()
+		go func() {
+			defer wg.Done()
+			sub.Close()
+		}()
+		wg.Wait()
+
+		if topics, cancels := topicCount(hub); topics != 0 || cancels != 0 {
+			t.Fatalf("iteration %d: %d topics and %d transport subscriptions left after close", i, topics, cancels)
+		}
+	}
+}
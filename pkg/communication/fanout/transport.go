@@ -0,0 +1,211 @@
+package fanout
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+var ErrTransportClosed = errors.New("传输已关闭")
+
+// Transport 在多个 Hub 之间转发事件. 同一主题的消息按发布顺序投递, 发布方自己也会收到
+type Transport interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+	// Subscribe 订阅主题, 返回取消订阅的函数. handler 可能在传输的内部协程中调用, 不应阻塞
+	Subscribe(topic string, handler func(payload []byte)) (func(), error)
+	Close() error
+}
+
+// MemoryTransport 进程内的主题总线, 只有一个引擎实例时使用. 多个 Hub 共用同一个 MemoryTransport 时可以互相转发
+type MemoryTransport struct {
+	mu       sync.RWMutex
+	handlers map[string]map[*memoryHandler]struct{}
+	closed   bool
+}
+
+type memoryHandler struct {
+	fn func(payload []byte)
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{
+		handlers: make(map[string]map[*memoryHandler]struct{}),
+	}
+}
+
+func (t *MemoryTransport) Publish(ctx context.Context, topic string, payload []byte) error {
+	// 在锁外调用 handler, handler 中可以再订阅其他主题
+	t.mu.RLock()
+	if t.closed {
+		t.mu.RUnlock()
+		return ErrTransportClosed
+	}
+	handlers := make([]*memoryHandler, 0, len(t.handlers[topic]))
+	for handler := range t.handlers[topic] {
+		handlers = append(handlers, handler)
+	}
+	t.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler.fn(payload)
+	}
+	return nil
+}
+
+func (t *MemoryTransport) Subscribe(topic string, fn func(payload []byte)) (func(), error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, ErrTransportClosed
+	}
+	handler := &memoryHandler{fn: fn}
+	if t.handlers[topic] == nil {
+		t.handlers[topic] = make(map[*memoryHandler]struct{})
+	}
+	t.handlers[topic][handler] = struct{}{}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			delete(t.handlers[topic], handler)
+			if len(t.handlers[topic]) == 0 {
+				delete(t.handlers, topic)
+			}
+		})
+	}, nil
+}
+
+func (t *MemoryTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	t.handlers = make(map[string]map[*memoryHandler]struct{})
+	return nil
+}
+
+// PubSub 外部消息中间件的发布订阅接口. Redis 的 PUBLISH/SUBSCRIBE 和 NATS 的 core pub/sub
+// 都可以直接适配, 适配器放在引入对应客户端的部署代码中, 通过 NewPubSubTransport 接入
+type PubSub interface {
+	Publish(ctx context.Context, channel string, data []byte) error
+	Subscribe(ctx context.Context, channel string) (PubSubSubscription, error)
+}
+
+// PubSubSubscription 一个频道的订阅, Close 之后 Messages 需要被关闭
+type PubSubSubscription interface {
+	Messages() <-chan []byte
+	Close() error
+}
+
+// PubSubTransport 通过 PubSub 在多个引擎实例之间转发事件, 频道名为 prefix + 主题
+type PubSubTransport struct {
+	pubsub PubSub
+	prefix string
+}
+
+func NewPubSubTransport(pubsub PubSub, prefix string) *PubSubTransport {
+	return &PubSubTransport{pubsub: pubsub, prefix: prefix}
+}
+
+func (t *PubSubTransport) Publish(ctx context.Context, topic string, payload []byte) error {
+	return t.pubsub.Publish(ctx, t.prefix+topic, payload)
+}
+
+func (t *PubSubTransport) Subscribe(topic string, handler func(payload []byte)) (func(), error) {
+	sub, err := t.pubsub.Subscribe(context.Background(), t.prefix+topic)
+	if err != nil {
+		return nil, err
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for payload := range sub.Messages() {
+			handler(payload)
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if err := sub.Close(); err != nil {
+				logrus.Warnf("取消订阅频道 %s 失败: %v", t.prefix+topic, err)
+			}
+			<-done
+		})
+	}, nil
+}
+
+// Close 连接由 PubSub 的创建方管理, 这里不关闭
+func (t *PubSubTransport) Close() error {
+	return nil
+}
+
+const fakePubSubBufferSize = 256
+
+// FakePubSub 进程内的 PubSub 实现, 行为与 Redis 的发布订阅一致: 消息只投递给当前在线的订阅,
+// 订阅的缓冲区满时丢弃. 用于本地开发和在单进程内模拟多个引擎实例
+type FakePubSub struct {
+	mu   sync.RWMutex
+	subs map[string]map[*fakeSubscription]struct{}
+}
+
+type fakeSubscription struct {
+	pubsub   *FakePubSub
+	channel  string
+	messages chan []byte
+	once     sync.Once
+}
+
+func NewFakePubSub() *FakePubSub {
+	return &FakePubSub{
+		subs: make(map[string]map[*fakeSubscription]struct{}),
+	}
+}
+
+func (p *FakePubSub) Publish(ctx context.Context, channel string, data []byte) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for sub := range p.subs[channel] {
+		select {
+		case sub.messages <- data:
+		default:
+			logrus.Warnf("频道 %s 的订阅缓冲区已满, 丢弃一条消息", channel)
+		}
+	}
+	return nil
+}
+
+func (p *FakePubSub) Subscribe(ctx context.Context, channel string) (PubSubSubscription, error) {
+	sub := &fakeSubscription{
+		pubsub:   p,
+		channel:  channel,
+		messages: make(chan []byte, fakePubSubBufferSize),
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.subs[channel] == nil {
+		p.subs[channel] = make(map[*fakeSubscription]struct{})
+	}
+	p.subs[channel][sub] = struct{}{}
+	return sub, nil
+}
+
+func (s *fakeSubscription) Messages() <-chan []byte {
+	return s.messages
+}
+
+func (s *fakeSubscription) Close() error {
+	s.once.Do(func() {
+		s.pubsub.mu.Lock()
+		defer s.pubsub.mu.Unlock()
+		delete(s.pubsub.subs[s.channel], s)
+		if len(s.pubsub.subs[s.channel]) == 0 {
+			delete(s.pubsub.subs, s.channel)
+		}
+		close(s.messages)
+	})
+	return nil
+}
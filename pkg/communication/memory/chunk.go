@@ -1,6 +1,9 @@
 package memory
 
-import "github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+import (
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+)
 
 type Chunk interface {
 	GetID() string
@@ -11,6 +14,7 @@ type ChunkType string
 
 const (
 	ChunkTypeMessage ChunkType = "message" // 聊天消息
+	ChunkTypeSummary ChunkType = "summary" // 话题的滚动摘要
 )
 
 type MessageChunk struct {
@@ -26,3 +30,35 @@ func (c *MessageChunk) GetID() string {
 func (c *MessageChunk) GetType() ChunkType {
 	return ChunkTypeMessage
 }
+
+// ConversationSummary 结构化的对话摘要
+type ConversationSummary struct {
+	Summary       string   `json:"summary"`
+	KeyPoints     []string `json:"key_points,omitempty"`
+	Decisions     []string `json:"decisions,omitempty"`
+	OpenQuestions []string `json:"open_questions,omitempty"`
+}
+
+// SummaryChunk 替代被摘要的历史消息, 在构建 prompt 时作为 system 消息注入
+type SummaryChunk struct {
+	ID            string
+	ThreadID      string
+	LastMessageID string // 最后一条被摘要的消息
+	CoveredUntil  int64  // 摘要覆盖到的消息时间
+	MessageCount  int    // 被摘要的消息数
+	Content       *ConversationSummary
+}
+
+// Covers 判断消息是否已被摘要覆盖, 与摘要最后一条消息同一毫秒的消息按 id 区分
+func (c *SummaryChunk) Covers(createdAt int64, messageID string) bool {
+	return repositories.MessageKey{CreatedAt: createdAt, ID: messageID}.
+		Before(repositories.MessageKey{CreatedAt: c.CoveredUntil, ID: c.LastMessageID})
+}
+
+func (c *SummaryChunk) GetID() string {
+	return c.ID
+}
+
+func (c *SummaryChunk) GetType() ChunkType {
+	return ChunkTypeSummary
+}
@@ -22,6 +22,7 @@ func NewChunkToLLMConverter() *ChunkToLLMConverter {
 	}
 
 	converter.RegisterConverter(&MessageChunkConverter{})
+	converter.RegisterConverter(&SummaryChunkConverter{})
 
 	return converter
 }
@@ -77,7 +77,7 @@ func (m *MessageChunkConverter) convertContent(message *messages.Message) (inter
 	case messages.MessageTypeSticker:
 		return m.convertStickerContent(message)
 	default:
-		return fmt.Sprintf("[不支持的消息类型: %s]", message.MsgType), nil
+		return fmt.Sprintf("[不支持的消息类型: %d]", message.MsgType), nil
 	}
 }
 
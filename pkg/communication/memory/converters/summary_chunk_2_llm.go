@@ -0,0 +1,43 @@
+package converters
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/memory"
+	"github.com/zhongshangwu/avatarai-social/pkg/providers/llm"
+)
+
+type SummaryChunkConverter struct{}
+
+func (s *SummaryChunkConverter) SupportedType() memory.ChunkType {
+	return memory.ChunkTypeSummary
+}
+
+func (s *SummaryChunkConverter) Convert(chunk memory.Chunk) (*llm.PromptMessage, error) {
+	summaryChunk, ok := chunk.(*memory.SummaryChunk)
+	if !ok {
+		return nil, fmt.Errorf("chunk 类型断言失败，期望 *memory.SummaryChunk")
+	}
+	if summaryChunk.Content == nil {
+		return nil, fmt.Errorf("摘要内容为空")
+	}
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "以下是本话题更早的 %d 条消息的摘要:\n%s", summaryChunk.MessageCount, summaryChunk.Content.Summary)
+	writeSummarySection(&builder, "要点", summaryChunk.Content.KeyPoints)
+	writeSummarySection(&builder, "已确定的事项", summaryChunk.Content.Decisions)
+	writeSummarySection(&builder, "待解决的问题", summaryChunk.Content.OpenQuestions)
+
+	return llm.NewSystemPromptMessage(builder.String(), "").PromptMessage, nil
+}
+
+func writeSummarySection(builder *strings.Builder, title string, items []string) {
+	if len(items) == 0 {
+		return
+	}
+	fmt.Fprintf(builder, "\n%s:", title)
+	for _, item := range items {
+		fmt.Fprintf(builder, "\n- %s", item)
+	}
+}
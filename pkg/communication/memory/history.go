@@ -0,0 +1,266 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/search"
+	"github.com/zhongshangwu/avatarai-social/pkg/services"
+	"github.com/zhongshangwu/avatarai-social/types"
+)
+
+const (
+	DefaultHistorySearchLimit = 10
+
+	// 倒数排名融合的平滑常数, 取常用的 60, 使头部名次的差异不至于压过另一路的结果
+	rrfK = 60
+	// 每路检索的候选数为 limit 的倍数, 按话题和时间过滤后仍能保留足够的结果
+	historyCandidateFactor = 5
+	historyMinCandidates   = 50
+	historySnippetLength   = 160
+)
+
+var ErrEmptyHistoryQuery = errors.New("检索内容不能为空")
+
+// HistoryQuery 时间范围为消息的 created_at (毫秒), 为零表示不限
+type HistoryQuery struct {
+	UserDid  string
+	Text     string
+	RoomID   string
+	ThreadID string
+	Since    int64
+	Until    int64
+	Limit    int
+}
+
+// HistorySearcher 在用户自己的聊天记录中混合检索, 覆盖用户发送的消息和 AI 回复 (输出项拼接的 AltText).
+// 关键词检索使用全文索引, 只在用户参与的房间内进行; 语义检索使用用户的 HNSW 向量索引.
+// 两路结果按倒数排名融合 (RRF): score = Σ 1 / (rrfK + rank)
+type HistorySearcher struct {
+	metaStore *repositories.MetaStore
+	indexes   *SemanticIndexRegistry // 为空时只使用关键词检索
+}
+
+func NewHistorySearcher(metaStore *repositories.MetaStore, indexes *SemanticIndexRegistry) *HistorySearcher {
+	return &HistorySearcher{
+		metaStore: metaStore,
+		indexes:   indexes,
+	}
+}
+
+type historyCandidate struct {
+	messageID   string
+	text        string
+	keywordRank int
+	vectorRank  int
+}
+
+func (s *HistorySearcher) Search(ctx context.Context, query *HistoryQuery) ([]*types.HistorySearchHit, error) {
+	if strings.TrimSpace(query.Text) == "" {
+		return nil, ErrEmptyHistoryQuery
+	}
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultHistorySearchLimit
+	}
+	candidates := max(limit*historyCandidateFactor, historyMinCandidates)
+
+	roomIDs, err := s.metaStore.MessageRepo.ListRoomIDsByMember(query.UserDid)
+	if err != nil {
+		return nil, fmt.Errorf("获取用户的房间失败: %w", err)
+	}
+	if query.RoomID != "" {
+		if !containsString(roomIDs, query.RoomID) {
+			return []*types.HistorySearchHit{}, nil
+		}
+		roomIDs = []string{query.RoomID}
+	}
+	if len(roomIDs) == 0 {
+		return []*types.HistorySearchHit{}, nil
+	}
+
+	keywordHits, err := s.keywordSearch(ctx, query, roomIDs, candidates)
+	if err != nil {
+		return nil, err
+	}
+	vectorHits := s.vectorSearch(query, candidates)
+
+	// 合并两路候选, 名次在按话题和时间过滤后重新计算
+	byID := make(map[string]*historyCandidate)
+	var order []*historyCandidate
+	candidate := func(messageID string, text string) *historyCandidate {
+		c, ok := byID[messageID]
+		if !ok {
+			c = &historyCandidate{messageID: messageID, text: text}
+			byID[messageID] = c
+			order = append(order, c)
+		}
+		return c
+	}
+	for _, hit := range keywordHits {
+		candidate(hit.Key, hit.Text)
+	}
+	for _, hit := range vectorHits {
+		candidate(hit.record.MessageID, hit.record.Text)
+	}
+	if len(order) == 0 {
+		return []*types.HistorySearchHit{}, nil
+	}
+
+	ids := make([]string, len(order))
+	for i, c := range order {
+		ids[i] = c.messageID
+	}
+	dbMessages, err := s.metaStore.MessageRepo.GetMessagesByIDs(ids)
+	if err != nil {
+		return nil, fmt.Errorf("查询消息失败: %w", err)
+	}
+	// 其他成员等待审核的消息不返回
+	dbMessages, err = services.VisibleMessages(s.metaStore, dbMessages, query.UserDid)
+	if err != nil {
+		return nil, err
+	}
+	visible := make(map[string]*repositories.Message, len(dbMessages))
+	for _, message := range dbMessages {
+		if query.RoomID != "" && message.RoomID != query.RoomID {
+			continue
+		}
+		if query.ThreadID != "" && message.ThreadID != query.ThreadID {
+			continue
+		}
+		if query.Since > 0 && message.CreatedAt < query.Since {
+			continue
+		}
+		if query.Until > 0 && message.CreatedAt >= query.Until {
+			continue
+		}
+		visible[message.ID] = message
+	}
+
+	rank := 0
+	for _, hit := range keywordHits {
+		if c := byID[hit.Key]; visible[hit.Key] != nil && c.keywordRank == 0 {
+			rank++
+			c.keywordRank = rank
+		}
+	}
+	rank = 0
+	for _, hit := range vectorHits {
+		if c := byID[hit.record.MessageID]; visible[hit.record.MessageID] != nil && c.vectorRank == 0 {
+			rank++
+			c.vectorRank = rank
+		}
+	}
+
+	hits := make([]*types.HistorySearchHit, 0, len(visible))
+	for _, c := range order {
+		message, ok := visible[c.messageID]
+		if !ok {
+			continue
+		}
+		itemType := types.HistoryItemUserMessage
+		if message.MsgType == int(messages.MessageTypeAgent) {
+			itemType = types.HistoryItemAgentReply
+		}
+		hits = append(hits, &types.HistorySearchHit{
+			Message: &types.MessageRef{
+				ID:        message.ID,
+				RoomID:    message.RoomID,
+				ThreadID:  message.ThreadID,
+				SenderID:  message.SenderID,
+				CreatedAt: message.CreatedAt,
+			},
+			ItemType:    itemType,
+			Text:        c.text,
+			Highlight:   search.Highlight(c.text, query.Text, historySnippetLength),
+			Score:       reciprocalRank(c.keywordRank) + reciprocalRank(c.vectorRank),
+			KeywordRank: c.keywordRank,
+			VectorRank:  c.vectorRank,
+		})
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Message.CreatedAt > hits[j].Message.CreatedAt
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+// keywordSearch 全文索引不可用时跳过关键词检索
+func (s *HistorySearcher) keywordSearch(ctx context.Context, query *HistoryQuery, roomIDs []string, candidates int) ([]*search.Hit, error) {
+	hits, err := s.metaStore.Search.Search(ctx, &search.Query{
+		Kind:   search.KindMessage,
+		Text:   query.Text,
+		Scopes: roomIDs,
+		Since:  query.Since,
+		Until:  query.Until,
+		Limit:  candidates,
+	})
+	if errors.Is(err, search.ErrUnavailable) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("关键词检索失败: %w", err)
+	}
+	return hits, nil
+}
+
+// vectorSearch 语义记忆未启用或向量化失败时只使用关键词检索的结果
+func (s *HistorySearcher) vectorSearch(query *HistoryQuery, candidates int) []*semanticHit {
+	if s.indexes == nil {
+		return nil
+	}
+	idx, err := s.indexes.index(query.UserDid)
+	if err != nil {
+		logrus.Warnf("加载用户 %s 的向量索引失败, 只使用关键词检索: %v", query.UserDid, err)
+		return nil
+	}
+	vector, err := s.indexes.embed(query.Text)
+	if err != nil {
+		logrus.Warnf("查询向量化失败, 只使用关键词检索: %v", err)
+		return nil
+	}
+	hits, err := idx.search(vector, candidates)
+	if err != nil {
+		logrus.Warnf("向量检索失败, 只使用关键词检索: %v", err)
+		return nil
+	}
+
+	filtered := make([]*semanticHit, 0, len(hits))
+	for _, hit := range hits {
+		if query.RoomID != "" && hit.record.RoomID != query.RoomID {
+			continue
+		}
+		if query.ThreadID != "" && hit.record.ThreadID != query.ThreadID {
+			continue
+		}
+		filtered = append(filtered, hit)
+	}
+	return filtered
+}
+
+func reciprocalRank(rank int) float64 {
+	if rank <= 0 {
+		return 0
+	}
+	return 1.0 / float64(rrfK+rank)
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/providers/embedding"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/services"
+	"github.com/zhongshangwu/avatarai-social/types"
+)
+
+const (
+	historyUser = "did:plc:alice"
+	historyAI   = "did:plc:assistant"
+)
+
+// historyMessage 一条待写入的消息, keyword、vector 控制是否写入全文索引和语义记忆
+type historyMessage struct {
+	id        string
+	roomID    string
+	threadID  string
+	senderID  string
+	text      string
+	createdAt int64
+	keyword   bool
+	vector    bool
+}
+
+func seedHistory(t *testing.T, store *repositories.MetaStore, registry *SemanticIndexRegistry, items ...historyMessage) {
+	t.Helper()
+	converter := services.NewMessageConverter(store.MessageRepo)
+	for _, item := range items {
+		message := &messages.Message{
+			ID:        item.id,
+			RoomID:    item.roomID,
+			ThreadID:  item.threadID,
+			MsgType:   messages.MessageTypeText,
+			SenderID:  item.senderID,
+			Content:   &messages.TextMessageContent{Text: item.text},
+			CreatedAt: item.createdAt,
+		}
+		dbMessage := converter.MessageToDB(message)
+		dbMessage.CreatedAt = item.createdAt
+		if err := store.MessageRepo.InsertMessage(dbMessage); err != nil {
+			t.Fatalf("insert message %s: %v", item.id, err)
+		}
+		if item.keyword {
+			services.IndexMessage(store, message)
+		}
+		if item.vector {
+			memory := registry.Memory(historyUser, item.roomID, item.threadID, 5)
+			if err := memory.Write(&MessageChunk{ID: item.id, Content: message}); err != nil {
+				t.Fatalf("write %s: %v", item.id, err)
+			}
+		}
+	}
+}
+
+// seedAgentReply 写入 AI 对 historyUser 的回复并写入全文索引
+func seedAgentReply(t *testing.T, store *repositories.MetaStore, id string, roomID string, text string, createdAt int64) {
+	t.Helper()
+	if err := store.MessageRepo.InsertMessage(&repositories.Message{
+		ID:         id,
+		RoomID:     roomID,
+		MsgType:    int(messages.MessageTypeAgent),
+		SenderID:   historyAI,
+		ReceiverID: historyUser,
+		CreatedAt:  createdAt,
+	}); err != nil {
+		t.Fatalf("insert reply %s: %v", id, err)
+	}
+	agentMessage := &repositories.AgentMessage{ID: "am-" + id, MessageID: id, AltText: text, Creator: historyAI}
+	if err := store.MessageRepo.InsertAgentMessage(agentMessage); err != nil {
+		t.Fatalf("insert agent message %s: %v", id, err)
+	}
+	services.IndexAgentReply(store, &messages.AgentMessage{ID: agentMessage.ID, MessageID: id, AltText: text, Creator: historyAI})
+}
+
+func searchHistory(t *testing.T, searcher *HistorySearcher, query *HistoryQuery) []*types.HistorySearchHit {
+	t.Helper()
+	query.UserDid = historyUser
+	hits, err := searcher.Search(context.Background(), query)
+	if err != nil {
+		t.Fatalf("search %+v: %v", query, err)
+	}
+	return hits
+}
+
+func historyIDs(hits []*types.HistorySearchHit) string {
+	ids := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		ids = append(ids, hit.Message.ID)
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+func TestHistorySearchFusesKeywordAndVectorRanks(t *testing.T) {
+	store := newTestMetaStore(t)
+	registry := NewSemanticIndexRegistry(store, embedding.NewHashingEmbedding(256), "hashing:test:256", "")
+	seedHistory(t, store, registry,
+		historyMessage{id: "both", roomID: "room-a", senderID: historyUser, text: "my cat mochi", createdAt: 1000, keyword: true, vector: true},
+		historyMessage{id: "keyword", roomID: "room-a", senderID: historyUser, text: "the cat called mochi sleeps", createdAt: 3000, keyword: true},
+		historyMessage{id: "vector", roomID: "room-a", senderID: historyUser, text: "mochi likes fish", createdAt: 4000, vector: true},
+	)
+	seedAgentReply(t, store, "reply", "room-a", "mochi is a lovely cat", 2000)
+
+	hits := searchHistory(t, NewHistorySearcher(store, registry), &HistoryQuery{Text: "mochi cat"})
+	if historyIDs(hits) != "both,keyword,reply,vector" {
+		t.Fatalf("hits = %s, want all four messages", historyIDs(hits))
+	}
+	// 两路都命中的消息排在最前
+	if hits[0].Message.ID != "both" || hits[0].KeywordRank == 0 || hits[0].VectorRank == 0 {
+		t.Fatalf("first hit = %s (keyword %d, vector %d), want both ranked in both lists", hits[0].Message.ID, hits[0].KeywordRank, hits[0].VectorRank)
+	}
+	for i, hit := range hits {
+		if want := reciprocalRank(hit.KeywordRank) + reciprocalRank(hit.VectorRank); hit.Score != want {
+			t.Errorf("%s score = %v, want %v", hit.Message.ID, hit.Score, want)
+		}
+		if i > 0 && hit.Score > hits[i-1].Score {
+			t.Errorf("hits are not sorted by score: %s %v after %v", hit.Message.ID, hit.Score, hits[i-1].Score)
+		}
+		switch hit.Message.ID {
+		case "keyword", "reply":
+			if hit.VectorRank != 0 {
+				t.Errorf("%s vector rank = %d, want 0", hit.Message.ID, hit.VectorRank)
+			}
+		case "vector":
+			if hit.KeywordRank != 0 {
+				t.Errorf("vector keyword rank = %d, want 0", hit.KeywordRank)
+			}
+		}
+		wantType := types.HistoryItemUserMessage
+		if hit.Message.ID == "reply" {
+			wantType = types.HistoryItemAgentReply
+		}
+		if hit.ItemType != wantType {
+			t.Errorf("%s item type = %s, want %s", hit.Message.ID, hit.ItemType, wantType)
+		}
+	}
+
+	// 只用关键词检索时名次按关键词结果重新计算
+	keywordOnly := searchHistory(t, NewHistorySearcher(store, nil), &HistoryQuery{Text: "mochi cat", Limit: 2})
+	if len(keywordOnly) != 2 || keywordOnly[0].KeywordRank != 1 || keywordOnly[0].Score != reciprocalRank(1) {
+		t.Fatalf("keyword only hits = %+v, want two hits ranked from 1", keywordOnly)
+	}
+}
+
+func TestHistorySearchScopes(t *testing.T) {
+	store := newTestMetaStore(t)
+	registry := NewSemanticIndexRegistry(store, embedding.NewHashingEmbedding(256), "hashing:test:256", "")
+	seedHistory(t, store, registry,
+		historyMessage{id: "a1-early", roomID: "room-a", threadID: "t1", senderID: historyUser, text: "budget for the trip", createdAt: 1000, keyword: true, vector: true},
+		historyMessage{id: "a1-late", roomID: "room-a", threadID: "t1", senderID: historyUser, text: "trip budget again", createdAt: 5000, keyword: true, vector: true},
+		historyMessage{id: "a2", roomID: "room-a", threadID: "t2", senderID: historyUser, text: "trip budget in another thread", createdAt: 3000, keyword: true, vector: true},
+		historyMessage{id: "b", roomID: "room-b", senderID: historyUser, text: "budget of the trip to rome", createdAt: 2000, keyword: true, vector: true},
+		// 用户没有参与的房间
+		historyMessage{id: "other", roomID: "room-c", senderID: "did:plc:bob", text: "trip budget secret", createdAt: 2000, keyword: true},
+	)
+	searcher := NewHistorySearcher(store, registry)
+
+	tests := []struct {
+		name  string
+		query HistoryQuery
+		want  string
+	}{
+		{"all rooms", HistoryQuery{Text: "trip budget"}, "a1-early,a1-late,a2,b"},
+		{"room", HistoryQuery{Text: "trip budget", RoomID: "room-a"}, "a1-early,a1-late,a2"},
+		{"thread", HistoryQuery{Text: "trip budget", RoomID: "room-a", ThreadID: "t1"}, "a1-early,a1-late"},
+		{"since is inclusive", HistoryQuery{Text: "trip budget", Since: 3000}, "a1-late,a2"},
+		{"until is exclusive", HistoryQuery{Text: "trip budget", Until: 3000}, "a1-early,b"},
+		{"thread and time", HistoryQuery{Text: "trip budget", ThreadID: "t1", Since: 2000}, "a1-late"},
+		{"room the user is not in", HistoryQuery{Text: "trip budget", RoomID: "room-c"}, ""},
+	}
+	for _, tt := range tests {
+		query := tt.query
+		if got := historyIDs(searchHistory(t, searcher, &query)); got != tt.want {
+			t.Errorf("%s: hits = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := searcher.Search(context.Background(), &HistoryQuery{UserDid: historyUser, Text: "  "}); err != ErrEmptyHistoryQuery {
+		t.Errorf("empty query = %v, want ErrEmptyHistoryQuery", err)
+	}
+}
+
+func TestHistorySearchToolRoles(t *testing.T) {
+	store := newTestMetaStore(t)
+	seedHistory(t, store, nil,
+		historyMessage{id: "mine", roomID: "room-a", senderID: historyUser, text: "plan the picnic", createdAt: 1000, keyword: true},
+		historyMessage{id: "friend", roomID: "room-a", senderID: "did:plc:bob", text: "picnic at noon", createdAt: 2000, keyword: true},
+	)
+	seedAgentReply(t, store, "reply", "room-a", "picnic checklist", 3000)
+
+	tool := NewHistorySearchTool(NewHistorySearcher(store, nil), historyUser)
+	output, err := tool.Execute(context.Background(), `{"query":"picnic"}`)
+	if err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	var result struct {
+		Results []map[string]string `json:"results"`
+	}
+	if err := json.Unmarshal([]byte(output), &result); err != nil {
+		t.Fatalf("decode %s: %v", output, err)
+	}
+	roles := make(map[string]string)
+	for _, item := range result.Results {
+		roles[item["text"]] = item["role"]
+	}
+	// 群聊中其他成员的消息不是 AI 的回复
+	want := map[string]string{"plan the picnic": "user", "picnic at noon": "user", "picnic checklist": "assistant"}
+	for text, role := range want {
+		if roles[text] != role {
+			t.Errorf("role of %q = %q, want %q (results %v)", text, roles[text], role, result.Results)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/zhongshangwu/avatarai-social/types"
+)
+
+const (
+	historyToolMaxLimit   = 20
+	historyToolTextLength = 500
+)
+
+// HistorySearchTool 让模型主动检索当前用户的聊天记录, 绑定到一个用户, 只能看到该用户参与的房间
+type HistorySearchTool struct {
+	searcher *HistorySearcher
+	userDid  string
+}
+
+func NewHistorySearchTool(searcher *HistorySearcher, userDid string) *HistorySearchTool {
+	return &HistorySearchTool{
+		searcher: searcher,
+		userDid:  userDid,
+	}
+}
+
+func (t *HistorySearchTool) Execute(ctx context.Context, arguments string) (string, error) {
+	var args struct {
+		Query    string `json:"query"`
+		RoomID   string `json:"room_id"`
+		ThreadID string `json:"thread_id"`
+		Since    string `json:"since"`
+		Until    string `json:"until"`
+		Limit    int    `json:"limit"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", fmt.Errorf("解析参数失败: %v", err)
+	}
+
+	since, err := parseToolTime(args.Since)
+	if err != nil {
+		return "", err
+	}
+	until, err := parseToolTime(args.Until)
+	if err != nil {
+		return "", err
+	}
+	limit := args.Limit
+	if limit <= 0 {
+		limit = DefaultSemanticTopK
+	}
+	limit = min(limit, historyToolMaxLimit)
+
+	hits, err := t.searcher.Search(ctx, &HistoryQuery{
+		UserDid:  t.userDid,
+		Text:     args.Query,
+		RoomID:   args.RoomID,
+		ThreadID: args.ThreadID,
+		Since:    since,
+		Until:    until,
+		Limit:    limit,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	results := make([]map[string]interface{}, 0, len(hits))
+	for _, hit := range hits {
+		// 角色取决于命中的是用户消息还是 AI 回复, 群聊中其他成员的消息也是 user
+		role := "user"
+		if hit.ItemType == types.HistoryItemAgentReply {
+			role = "assistant"
+		}
+		results = append(results, map[string]interface{}{
+			"time":      time.UnixMilli(hit.Message.CreatedAt).Format("2006-01-02 15:04"),
+			"room_id":   hit.Message.RoomID,
+			"thread_id": hit.Message.ThreadID,
+			"role":      role,
+			"text":      truncateRunes(hit.Text, historyToolTextLength),
+		})
+	}
+	resultBytes, _ := json.Marshal(map[string]interface{}{
+		"query":   args.Query,
+		"results": results,
+	})
+	return string(resultBytes), nil
+}
+
+func (t *HistorySearchTool) GetName() string {
+	return "search_chat_history"
+}
+
+func (t *HistorySearchTool) GetDescription() string {
+	return "检索用户过去的聊天记录（包括用户的消息和你的回复），同时按关键词和语义匹配。当用户提到之前聊过的内容、或回答需要参考历史对话时使用"
+}
+
+func (t *HistorySearchTool) GetParameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"query": map[string]interface{}{
+				"type":        "string",
+				"description": "检索内容，可以是关键词或一句话",
+			},
+			"room_id": map[string]interface{}{
+				"type":        "string",
+				"description": "只检索指定房间，默认检索全部房间",
+			},
+			"thread_id": map[string]interface{}{
+				"type":        "string",
+				"description": "只检索指定话题",
+			},
+			"since": map[string]interface{}{
+				"type":        "string",
+				"description": "起始时间（含），格式 '2006-01-02' 或 RFC3339",
+			},
+			"until": map[string]interface{}{
+				"type":        "string",
+				"description": "截止时间（不含），格式 '2006-01-02' 或 RFC3339",
+			},
+			"limit": map[string]interface{}{
+				"type":        "integer",
+				"description": fmt.Sprintf("返回条数，最多 %d 条", historyToolMaxLimit),
+				"default":     DefaultSemanticTopK,
+			},
+		},
+		"required": []string{"query"},
+	}
+}
+
+// parseToolTime 将模型给出的日期转换为毫秒时间戳, 只有日期时按服务器本地时区解析
+func parseToolTime(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UnixMilli(), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t.UnixMilli(), nil
+	}
+	return 0, fmt.Errorf("无法解析时间: %s", value)
+}
+
+func truncateRunes(text string, maxRunes int) string {
+	if utf8.RuneCountInString(text) <= maxRunes {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:maxRunes]) + "…"
+}
@@ -1,65 +1,137 @@
 package memory
 
 import (
+	"container/heap"
 	"fmt"
 	"math"
 	"math/rand"
 	"sort"
+	"sync"
 	"time"
 )
 
-// Vector 表示一个向量
-type Vector []float64
+// Vector 表示一个向量, 与 embedding.Embedding 的输出保持一致
+type Vector []float32
 
-// Node 表示图中的一个节点
+// Metric 距离度量方式
+type Metric uint8
+
+const (
+	MetricEuclidean Metric = iota // 欧几里得距离
+	MetricCosine                  // 余弦距离: 1 - cos
+	MetricDot                     // 内积距离: 1 - dot, 向量需预先归一化
+)
+
+func (m Metric) String() string {
+	switch m {
+	case MetricEuclidean:
+		return "euclidean"
+	case MetricCosine:
+		return "cosine"
+	case MetricDot:
+		return "dot"
+	default:
+		return fmt.Sprintf("metric(%d)", uint8(m))
+	}
+}
+
+// DefaultRebuildRatio 墓碑节点占比超过该值时建议重建索引
+const DefaultRebuildRatio = 0.25
+
+// Node 表示图中的一个节点, ID 由调用方指定
 type Node struct {
 	ID        int
 	Vector    Vector
 	Level     int
+	Deleted   bool            // 墓碑标记, 删除的节点仍参与图遍历, 但不会出现在结果中
 	Neighbors []map[int]*Node // 每层的邻居节点
 }
 
-// HNSW 主结构
+// HNSW 主结构, 所有公开方法都是并发安全的
 type HNSW struct {
-	Nodes          []*Node
+	Nodes          map[int]*Node
 	EntryPoint     *Node
 	MaxLevel       int
 	M              int     // 每层最大连接数
 	Ml             float64 // level 生成参数
 	Ef             int     // 搜索时的候选集大小
 	EfConstruction int     // 构建时的候选集大小
+	Metric         Metric
+	Dimensions     int // 首次插入时确定
+
+	distance func(a, b Vector) float32
+	deleted  int
+	rng      *rand.Rand
+	mu       sync.RWMutex
 }
 
-// NewHNSW 创建新的 HNSW 索引
+// NewHNSW 创建新的 HNSW 索引, 默认使用欧几里得距离
 func NewHNSW(m int, efConstruction int, ml float64) *HNSW {
+	return NewHNSWWithMetric(m, efConstruction, ml, MetricEuclidean)
+}
+
+func NewHNSWWithMetric(m int, efConstruction int, ml float64, metric Metric) *HNSW {
 	return &HNSW{
-		Nodes:          make([]*Node, 0),
+		Nodes:          make(map[int]*Node),
 		M:              m,
 		Ml:             ml,
 		Ef:             efConstruction,
 		EfConstruction: efConstruction,
 		MaxLevel:       0,
+		Metric:         metric,
+		distance:       distanceFunc(metric),
+		rng:            rand.New(rand.NewSource(time.Now().UnixNano())),
 	}
 }
 
-// 计算两个向量的欧几里得距离
-func EuclideanDistance(a, b Vector) float64 {
-	if len(a) != len(b) {
-		panic("向量维度不匹配")
+func distanceFunc(metric Metric) func(a, b Vector) float32 {
+	switch metric {
+	case MetricCosine:
+		return CosineDistance
+	case MetricDot:
+		return DotDistance
+	default:
+		return EuclideanDistance
 	}
+}
 
-	sum := 0.0
+// 计算两个向量的欧几里得距离, 调用方需保证维度一致
+func EuclideanDistance(a, b Vector) float32 {
+	var sum float32
 	for i := range a {
 		diff := a[i] - b[i]
 		sum += diff * diff
 	}
-	return math.Sqrt(sum)
+	return float32(math.Sqrt(float64(sum)))
+}
+
+// CosineDistance 余弦距离, 零向量与任何向量的距离为 1
+func CosineDistance(a, b Vector) float32 {
+	var dot, normA, normB float32
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 1
+	}
+	return 1 - dot/float32(math.Sqrt(float64(normA))*math.Sqrt(float64(normB)))
+}
+
+// DotDistance 内积距离
+func DotDistance(a, b Vector) float32 {
+	var dot float32
+	for i := range a {
+		dot += a[i] * b[i]
+	}
+	return 1 - dot
 }
 
 // 候选节点结构，用于搜索过程
 type Candidate struct {
 	Node     *Node
-	Distance float64
+	Distance float32
 }
 
 // 候选节点优先队列（最小堆）
@@ -70,86 +142,105 @@ func (pq CandidateQueue) Less(i, j int) bool {
 	return pq[i].Distance < pq[j].Distance
 }
 func (pq CandidateQueue) Swap(i, j int) { pq[i], pq[j] = pq[j], pq[i] }
+func (pq *CandidateQueue) Push(x any)   { *pq = append(*pq, x.(*Candidate)) }
+func (pq *CandidateQueue) Pop() any {
+	old := *pq
+	n := len(old)
+	item := old[n-1]
+	*pq = old[:n-1]
+	return item
+}
+
+// 候选节点优先队列（最大堆）, 堆顶是距离最远的点
+type maxCandidateQueue struct {
+	CandidateQueue
+}
+
+func (pq maxCandidateQueue) Less(i, j int) bool {
+	return pq.CandidateQueue[i].Distance > pq.CandidateQueue[j].Distance
+}
+
+// Len 返回未删除的节点数
+func (h *HNSW) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.Nodes) - h.deleted
+}
+
+// DeletedRatio 墓碑节点占比
+func (h *HNSW) DeletedRatio() float64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.Nodes) == 0 {
+		return 0
+	}
+	return float64(h.deleted) / float64(len(h.Nodes))
+}
+
+// NeedsRebuild 墓碑节点过多会降低搜索效率和召回率, 需要重建
+func (h *HNSW) NeedsRebuild() bool {
+	return h.DeletedRatio() > DefaultRebuildRatio
+}
 
 // 随机选择层级
 func (h *HNSW) selectLevel() int {
-	level := int(math.Floor(-math.Log(rand.Float64()) * h.Ml))
+	level := int(math.Floor(-math.Log(h.rng.Float64()) * h.Ml))
 	return level
 }
 
-// 搜索最近邻居
+// 搜索最近邻居, dynamic 为待扩展的最小堆, results 为当前最近 numClosest 个点的最大堆
 func (h *HNSW) searchLayer(query Vector, entryPoints []*Node, numClosest int, level int) []*Candidate {
 	visited := make(map[int]bool)
-	candidates := make([]*Candidate, 0)
-	dynamic := make([]*Candidate, 0)
+	dynamic := &CandidateQueue{}
+	results := &maxCandidateQueue{}
 
 	// 初始化候选集
 	for _, ep := range entryPoints {
 		if !visited[ep.ID] {
-			dist := EuclideanDistance(query, ep.Vector)
-			candidate := &Candidate{Node: ep, Distance: dist}
-			candidates = append(candidates, candidate)
-			dynamic = append(dynamic, candidate)
+			candidate := &Candidate{Node: ep, Distance: h.distance(query, ep.Vector)}
+			heap.Push(dynamic, candidate)
+			heap.Push(results, candidate)
 			visited[ep.ID] = true
 		}
 	}
+	for results.Len() > numClosest {
+		heap.Pop(results)
+	}
 
-	for len(dynamic) > 0 {
-		// 获取距离最近的候选点
-		sort.Slice(dynamic, func(i, j int) bool {
-			return dynamic[i].Distance < dynamic[j].Distance
-		})
-
-		current := dynamic[0]
-		dynamic = dynamic[1:]
-
-		// 检查是否需要继续搜索
-		sort.Slice(candidates, func(i, j int) bool {
-			return candidates[i].Distance < candidates[j].Distance
-		})
-
-		if len(candidates) >= numClosest && current.Distance > candidates[numClosest-1].Distance {
+	for dynamic.Len() > 0 {
+		// 最近的待扩展点比结果中最远的点还远时停止
+		current := heap.Pop(dynamic).(*Candidate)
+		if results.Len() >= numClosest && current.Distance > results.CandidateQueue[0].Distance {
 			break
 		}
 
 		// 检查当前节点的邻居
-		if level < len(current.Node.Neighbors) {
-			for _, neighbor := range current.Node.Neighbors[level] {
-				if !visited[neighbor.ID] {
-					visited[neighbor.ID] = true
-					dist := EuclideanDistance(query, neighbor.Vector)
-					candidate := &Candidate{Node: neighbor, Distance: dist}
-
-					sort.Slice(candidates, func(i, j int) bool {
-						return candidates[i].Distance < candidates[j].Distance
-					})
-
-					if len(candidates) < numClosest || dist < candidates[len(candidates)-1].Distance {
-						candidates = append(candidates, candidate)
-						dynamic = append(dynamic, candidate)
-
-						// 保持候选集大小
-						sort.Slice(candidates, func(i, j int) bool {
-							return candidates[i].Distance < candidates[j].Distance
-						})
-						if len(candidates) > numClosest {
-							candidates = candidates[:numClosest]
-						}
-					}
+		if level >= len(current.Node.Neighbors) {
+			continue
+		}
+		for _, neighbor := range current.Node.Neighbors[level] {
+			if visited[neighbor.ID] {
+				continue
+			}
+			visited[neighbor.ID] = true
+			dist := h.distance(query, neighbor.Vector)
+			if results.Len() < numClosest || dist < results.CandidateQueue[0].Distance {
+				candidate := &Candidate{Node: neighbor, Distance: dist}
+				heap.Push(dynamic, candidate)
+				heap.Push(results, candidate)
+				// 保持候选集大小
+				if results.Len() > numClosest {
+					heap.Pop(results)
 				}
 			}
 		}
 	}
 
-	// 返回最近的 numClosest 个候选点
-	sort.Slice(candidates, func(i, j int) bool {
-		return candidates[i].Distance < candidates[j].Distance
-	})
-
-	if len(candidates) > numClosest {
-		candidates = candidates[:numClosest]
+	// 按距离升序返回
+	candidates := make([]*Candidate, results.Len())
+	for i := len(candidates) - 1; i >= 0; i-- {
+		candidates[i] = heap.Pop(results).(*Candidate)
 	}
-
 	return candidates
 }
 
@@ -176,11 +267,28 @@ func (h *HNSW) selectNeighbors(candidates []*Candidate, m int) []*Node {
 	return result
 }
 
-// 插入新节点
-func (h *HNSW) Insert(vector Vector) {
-	level := h.selectLevel()
+// Insert 插入新节点, ID 已存在时返回错误
+func (h *HNSW) Insert(id int, vector Vector) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, exists := h.Nodes[id]; exists {
+		return fmt.Errorf("节点 %d 已存在", id)
+	}
+	if h.Dimensions == 0 {
+		h.Dimensions = len(vector)
+	}
+	if len(vector) != h.Dimensions {
+		return fmt.Errorf("向量维度不匹配: 期望 %d, 实际 %d", h.Dimensions, len(vector))
+	}
+
+	h.insert(id, vector, h.selectLevel())
+	return nil
+}
+
+func (h *HNSW) insert(id int, vector Vector, level int) *Node {
 	node := &Node{
-		ID:        len(h.Nodes),
+		ID:        id,
 		Vector:    vector,
 		Level:     level,
 		Neighbors: make([]map[int]*Node, level+1),
@@ -191,12 +299,12 @@ func (h *HNSW) Insert(vector Vector) {
 		node.Neighbors[i] = make(map[int]*Node)
 	}
 
-	h.Nodes = append(h.Nodes, node)
+	h.Nodes[id] = node
 
 	if h.EntryPoint == nil {
 		h.EntryPoint = node
 		h.MaxLevel = level
-		return
+		return node
 	}
 
 	currentMaxLevel := h.MaxLevel
@@ -231,6 +339,44 @@ func (h *HNSW) Insert(vector Vector) {
 		h.MaxLevel = level
 		h.EntryPoint = node
 	}
+	return node
+}
+
+// Delete 以墓碑方式删除节点, 节点保留在图中维持连通性, 重建时才真正移除
+func (h *HNSW) Delete(id int) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	node, exists := h.Nodes[id]
+	if !exists || node.Deleted {
+		return false
+	}
+	node.Deleted = true
+	h.deleted++
+	return true
+}
+
+// Rebuild 丢弃墓碑节点, 用剩余节点重新构建图, 节点 ID 和层级保持不变
+func (h *HNSW) Rebuild() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	live := make([]*Node, 0, len(h.Nodes)-h.deleted)
+	for _, node := range h.Nodes {
+		if !node.Deleted {
+			live = append(live, node)
+		}
+	}
+	// 按 ID 顺序插入, 保证相同数据重建出的图一致
+	sort.Slice(live, func(i, j int) bool { return live[i].ID < live[j].ID })
+
+	h.Nodes = make(map[int]*Node, len(live))
+	h.EntryPoint = nil
+	h.MaxLevel = 0
+	h.deleted = 0
+	for _, node := range live {
+		h.insert(node.ID, node.Vector, node.Level)
+	}
 }
 
 // 修剪连接（保持每个节点的连接数不超过 M）
@@ -242,7 +388,7 @@ func (h *HNSW) pruneConnections(node *Node, level int) {
 	// 计算所有邻居的距离
 	candidates := make([]*Candidate, 0, len(node.Neighbors[level]))
 	for _, neighbor := range node.Neighbors[level] {
-		dist := EuclideanDistance(node.Vector, neighbor.Vector)
+		dist := h.distance(node.Vector, neighbor.Vector)
 		candidates = append(candidates, &Candidate{Node: neighbor, Distance: dist})
 	}
 
@@ -274,10 +420,16 @@ func (h *HNSW) getNodesFromCandidates(candidates []*Candidate) []*Node {
 	return nodes
 }
 
-// 搜索 K 个最近邻
-func (h *HNSW) Search(query Vector, k int) []*Candidate {
-	if h.EntryPoint == nil {
-		return []*Candidate{}
+// Search 搜索 K 个最近邻, 结果不包含已删除的节点
+func (h *HNSW) Search(query Vector, k int) ([]*Candidate, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.EntryPoint == nil || k <= 0 {
+		return []*Candidate{}, nil
+	}
+	if len(query) != h.Dimensions {
+		return nil, fmt.Errorf("向量维度不匹配: 期望 %d, 实际 %d", h.Dimensions, len(query))
 	}
 
 	entryPoints := []*Node{h.EntryPoint}
@@ -287,17 +439,80 @@ func (h *HNSW) Search(query Vector, k int) []*Candidate {
 		entryPoints = h.getNodesFromCandidates(h.searchLayer(query, entryPoints, 1, level))
 	}
 
-	// 在第0层进行详细搜索
-	candidates := h.searchLayer(query, entryPoints, max(h.Ef, k), 0)
+	// 在第0层进行详细搜索, 墓碑节点会占用候选位置, 按比例放大候选集
+	ef := max(h.Ef, k)
+	if h.deleted > 0 {
+		ef += ef * h.deleted / max(len(h.Nodes)-h.deleted, 1)
+	}
+	candidates := h.searchLayer(query, entryPoints, ef, 0)
+
+	results := make([]*Candidate, 0, k)
+	for _, candidate := range candidates {
+		if candidate.Node.Deleted {
+			continue
+		}
+		results = append(results, candidate)
+		if len(results) == k {
+			break
+		}
+	}
+	return results, nil
+}
+
+// BruteForceSearch 暴力搜索精确的 K 个最近邻, 用于评估召回率
+func (h *HNSW) BruteForceSearch(query Vector, k int) []*Candidate {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
 
-	// 返回前 k 个结果
+	candidates := make([]*Candidate, 0, len(h.Nodes))
+	for _, node := range h.Nodes {
+		if node.Deleted || len(node.Vector) != len(query) {
+			continue
+		}
+		candidates = append(candidates, &Candidate{Node: node, Distance: h.distance(query, node.Vector)})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Distance < candidates[j].Distance
+	})
 	if len(candidates) > k {
 		candidates = candidates[:k]
 	}
-
 	return candidates
 }
 
+// Recall 以暴力搜索为基准计算 Search 的平均召回率 (recall@k)
+func (h *HNSW) Recall(queries []Vector, k int) (float64, error) {
+	if len(queries) == 0 {
+		return 0, nil
+	}
+
+	var total float64
+	for _, query := range queries {
+		exact := h.BruteForceSearch(query, k)
+		if len(exact) == 0 {
+			total += 1
+			continue
+		}
+		approx, err := h.Search(query, k)
+		if err != nil {
+			return 0, err
+		}
+
+		expected := make(map[int]bool, len(exact))
+		for _, candidate := range exact {
+			expected[candidate.Node.ID] = true
+		}
+		hit := 0
+		for _, candidate := range approx {
+			if expected[candidate.Node.ID] {
+				hit++
+			}
+		}
+		total += float64(hit) / float64(len(exact))
+	}
+	return total / float64(len(queries)), nil
+}
+
 // 辅助函数
 func min(a, b int) int {
 	if a < b {
@@ -315,8 +530,6 @@ func max(a, b int) int {
 
 // 示例使用
 func HNSWExample() {
-	rand.Seed(time.Now().UnixNano())
-
 	// 创建 HNSW 索引
 	hnsw := NewHNSW(16, 200, 1.0/math.Log(2.0))
 
@@ -336,7 +549,10 @@ func HNSWExample() {
 
 	fmt.Printf("插入 %d 个向量到索引中...\n", len(vectors))
 	for i, vec := range vectors {
-		hnsw.Insert(vec)
+		if err := hnsw.Insert(i, vec); err != nil {
+			fmt.Printf("插入向量 %d 失败: %v\n", i, err)
+			continue
+		}
 		fmt.Printf("插入向量 %d: %v (层级: %d)\n", i, vec, hnsw.Nodes[i].Level)
 	}
 
@@ -353,7 +569,7 @@ func HNSWExample() {
 	fmt.Printf("查询向量: %v\n", query)
 	fmt.Printf("搜索最近的 %d 个邻居...\n", k)
 
-	results := hnsw.Search(query, k)
+	results, _ := hnsw.Search(query, k)
 
 	fmt.Printf("\n搜索结果:\n")
 	for i, result := range results {
@@ -361,9 +577,13 @@ func HNSWExample() {
 			i+1, result.Node.ID, result.Node.Vector, result.Distance)
 	}
 
+	recall, _ := hnsw.Recall([]Vector{query, {10.0, 11.0, 12.0}}, k)
+	fmt.Printf("\n召回率 (recall@%d): %.2f\n", k, recall)
+
 	// 显示图的连接信息
 	fmt.Printf("\n=== 图连接信息 ===\n")
-	for _, node := range hnsw.Nodes {
+	for id := 0; id < len(vectors); id++ {
+		node := hnsw.Nodes[id]
 		fmt.Printf("节点 %d (层级 %d):\n", node.ID, node.Level)
 		for level := 0; level <= node.Level; level++ {
 			neighbors := make([]int, 0, len(node.Neighbors[level]))
@@ -0,0 +1,298 @@
+package memory
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// HNSW 二进制文件格式 (小端序):
+//
+//	header: magic "HNSW" | version u16 | metric u8 | M u32 | Ef u32 | EfConstruction u32 | Ml f64 |
+//	        dimensions u32 | maxLevel u32 | entryPoint i64 (-1 表示空) | nodeCount u32
+//	node:   id i64 | level u32 | deleted u8 | vector [dimensions]f32 |
+//	        每层: neighborCount u32 | neighborIDs [neighborCount]i64
+const (
+	hnswMagic   = "HNSW"
+	hnswVersion = 1
+)
+
+var ErrInvalidHNSWFile = errors.New("无效的 HNSW 索引文件")
+
+// WriteTo 将索引序列化到 w
+func (h *HNSW) WriteTo(w io.Writer) (int64, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	cw := &countingWriter{w: bw}
+
+	entryPoint := int64(-1)
+	if h.EntryPoint != nil {
+		entryPoint = int64(h.EntryPoint.ID)
+	}
+	header := []interface{}{
+		[]byte(hnswMagic),
+		uint16(hnswVersion),
+		uint8(h.Metric),
+		uint32(h.M),
+		uint32(h.Ef),
+		uint32(h.EfConstruction),
+		h.Ml,
+		uint32(h.Dimensions),
+		uint32(h.MaxLevel),
+		entryPoint,
+		uint32(len(h.Nodes)),
+	}
+	for _, field := range header {
+		if err := binary.Write(cw, binary.LittleEndian, field); err != nil {
+			return cw.n, err
+		}
+	}
+
+	ids := make([]int, 0, len(h.Nodes))
+	for id := range h.Nodes {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	buf := make([]byte, 8)
+	for _, id := range ids {
+		node := h.Nodes[id]
+		deleted := uint8(0)
+		if node.Deleted {
+			deleted = 1
+		}
+		if err := binary.Write(cw, binary.LittleEndian, int64(node.ID)); err != nil {
+			return cw.n, err
+		}
+		if err := binary.Write(cw, binary.LittleEndian, uint32(node.Level)); err != nil {
+			return cw.n, err
+		}
+		if err := binary.Write(cw, binary.LittleEndian, deleted); err != nil {
+			return cw.n, err
+		}
+		for _, value := range node.Vector {
+			binary.LittleEndian.PutUint32(buf, math.Float32bits(value))
+			if _, err := cw.Write(buf[:4]); err != nil {
+				return cw.n, err
+			}
+		}
+		for level := 0; level <= node.Level; level++ {
+			neighbors := make([]int, 0, len(node.Neighbors[level]))
+			for neighborID := range node.Neighbors[level] {
+				neighbors = append(neighbors, neighborID)
+			}
+			sort.Ints(neighbors)
+			if err := binary.Write(cw, binary.LittleEndian, uint32(len(neighbors))); err != nil {
+				return cw.n, err
+			}
+			for _, neighborID := range neighbors {
+				binary.LittleEndian.PutUint64(buf, uint64(int64(neighborID)))
+				if _, err := cw.Write(buf); err != nil {
+					return cw.n, err
+				}
+			}
+		}
+	}
+
+	return cw.n, bw.Flush()
+}
+
+// ReadHNSW 从 r 反序列化索引. 长度字段在分配内存前先与剩余输入比较, 损坏或恶意构造的文件不会导致超大分配
+func ReadHNSW(r io.Reader) (*HNSW, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	br := bytes.NewReader(data)
+
+	magic := make([]byte, len(hnswMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != hnswMagic {
+		return nil, ErrInvalidHNSWFile
+	}
+
+	var (
+		version    uint16
+		metric     uint8
+		m, ef, efc uint32
+		ml         float64
+		dimensions uint32
+		maxLevel   uint32
+		entryPoint int64
+		nodeCount  uint32
+		headerErr  error
+	)
+	for _, field := range []interface{}{&version, &metric, &m, &ef, &efc, &ml, &dimensions, &maxLevel, &entryPoint, &nodeCount} {
+		if err := binary.Read(br, binary.LittleEndian, field); err != nil {
+			headerErr = err
+			break
+		}
+	}
+	if headerErr != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidHNSWFile, headerErr)
+	}
+	if version != hnswVersion {
+		return nil, fmt.Errorf("%w: 不支持的版本 %d", ErrInvalidHNSWFile, version)
+	}
+	// 每个节点至少包含 id、level、deleted、向量和第 0 层的邻居数
+	minNodeSize := uint64(8+4+1+4) + 4*uint64(dimensions)
+	if uint64(nodeCount)*minNodeSize > uint64(br.Len()) {
+		return nil, fmt.Errorf("%w: 节点数 %d 超出文件长度", ErrInvalidHNSWFile, nodeCount)
+	}
+
+	h := NewHNSWWithMetric(int(m), int(efc), ml, Metric(metric))
+	h.Ef = int(ef)
+	h.Dimensions = int(dimensions)
+	h.MaxLevel = int(maxLevel)
+	h.Nodes = make(map[int]*Node, nodeCount)
+
+	// 邻居可能引用尚未读取的节点, 先记录 ID, 全部读完后再连接
+	neighborIDs := make(map[int][][]int, nodeCount)
+	buf := make([]byte, 8)
+	for i := uint32(0); i < nodeCount; i++ {
+		var (
+			id      int64
+			level   uint32
+			deleted uint8
+		)
+		if err := binary.Read(br, binary.LittleEndian, &id); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidHNSWFile, err)
+		}
+		if err := binary.Read(br, binary.LittleEndian, &level); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidHNSWFile, err)
+		}
+		if err := binary.Read(br, binary.LittleEndian, &deleted); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidHNSWFile, err)
+		}
+		if level > maxLevel || 4*uint64(dimensions)+4*(uint64(level)+1) > uint64(br.Len()) {
+			return nil, fmt.Errorf("%w: 节点 %d 的层级 %d 无效", ErrInvalidHNSWFile, id, level)
+		}
+
+		node := &Node{
+			ID:        int(id),
+			Vector:    make(Vector, dimensions),
+			Level:     int(level),
+			Deleted:   deleted == 1,
+			Neighbors: make([]map[int]*Node, level+1),
+		}
+		for j := range node.Vector {
+			if _, err := io.ReadFull(br, buf[:4]); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidHNSWFile, err)
+			}
+			node.Vector[j] = math.Float32frombits(binary.LittleEndian.Uint32(buf))
+		}
+
+		levels := make([][]int, level+1)
+		for lev := range levels {
+			var count uint32
+			if err := binary.Read(br, binary.LittleEndian, &count); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidHNSWFile, err)
+			}
+			if 8*uint64(count) > uint64(br.Len()) {
+				return nil, fmt.Errorf("%w: 节点 %d 的邻居数 %d 超出文件长度", ErrInvalidHNSWFile, id, count)
+			}
+			levels[lev] = make([]int, count)
+			for k := range levels[lev] {
+				if _, err := io.ReadFull(br, buf); err != nil {
+					return nil, fmt.Errorf("%w: %v", ErrInvalidHNSWFile, err)
+				}
+				levels[lev][k] = int(int64(binary.LittleEndian.Uint64(buf)))
+			}
+		}
+
+		h.Nodes[node.ID] = node
+		neighborIDs[node.ID] = levels
+		if node.Deleted {
+			h.deleted++
+		}
+	}
+
+	for id, levels := range neighborIDs {
+		node := h.Nodes[id]
+		for lev, ids := range levels {
+			node.Neighbors[lev] = make(map[int]*Node, len(ids))
+			for _, neighborID := range ids {
+				neighbor, ok := h.Nodes[neighborID]
+				if !ok {
+					return nil, fmt.Errorf("%w: 节点 %d 引用了不存在的邻居 %d", ErrInvalidHNSWFile, id, neighborID)
+				}
+				// 搜索在第 lev 层会访问邻居的 Neighbors[lev], 层级不足的邻居会导致越界
+				if neighbor.Level < lev {
+					return nil, fmt.Errorf("%w: 节点 %d 在第 %d 层的邻居 %d 只有 %d 层", ErrInvalidHNSWFile, id, lev, neighborID, neighbor.Level)
+				}
+				node.Neighbors[lev][neighborID] = neighbor
+			}
+		}
+	}
+
+	if entryPoint >= 0 {
+		node, ok := h.Nodes[int(entryPoint)]
+		if !ok {
+			return nil, fmt.Errorf("%w: 入口点 %d 不存在", ErrInvalidHNSWFile, entryPoint)
+		}
+		// 搜索从 MaxLevel 开始访问入口点的邻居, 两者必须一致
+		if node.Level != h.MaxLevel {
+			return nil, fmt.Errorf("%w: 入口点 %d 的层级 %d 与最大层级 %d 不一致", ErrInvalidHNSWFile, entryPoint, node.Level, h.MaxLevel)
+		}
+		h.EntryPoint = node
+	}
+	h.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	return h, nil
+}
+
+// SaveFile 将索引写入文件, 先写临时文件再重命名, 避免写入中断留下损坏的文件
+func (h *HNSW) SaveFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := h.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("写入 HNSW 索引失败: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadHNSWFile 从文件加载索引
+func LoadHNSWFile(path string) (*HNSW, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadHNSW(file)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,450 @@
+package memory
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func randomVectors(rng *rand.Rand, count int, dimensions int, normalize bool) []Vector {
+	vectors := make([]Vector, count)
+	for i := range vectors {
+		vector := make(Vector, dimensions)
+		var norm float64
+		for j := range vector {
+			vector[j] = float32(rng.NormFloat64())
+			norm += float64(vector[j]) * float64(vector[j])
+		}
+		if normalize {
+			norm = math.Sqrt(norm)
+			for j := range vector {
+				vector[j] = float32(float64(vector[j]) / norm)
+			}
+		}
+		vectors[i] = vector
+	}
+	return vectors
+}
+
+// newTestHNSW 使用固定随机种子构建索引, 保证测试结果可复现
+func newTestHNSW(t testing.TB, metric Metric, vectors []Vector) *HNSW {
+	t.Helper()
+	h := NewHNSWWithMetric(16, 200, 1.0/math.Log(16), metric)
+	h.rng = rand.New(rand.NewSource(1))
+	for i, vector := range vectors {
+		if err := h.Insert(i, vector); err != nil {
+			t.Fatalf("insert %d: %v", i, err)
+		}
+	}
+	return h
+}
+
+var testMetrics = []struct {
+	metric    Metric
+	normalize bool
+}{
+	{MetricEuclidean, false},
+	{MetricCosine, false},
+	{MetricDot, true}, // 内积距离要求向量预先归一化
+}
+
+func TestHNSWRecallAgainstBruteForce(t *testing.T) {
+	for _, tt := range testMetrics {
+		t.Run(tt.metric.String(), func(t *testing.T) {
+			rng := rand.New(rand.NewSource(42))
+			h := newTestHNSW(t, tt.metric, randomVectors(rng, 500, 16, tt.normalize))
+			queries := randomVectors(rng, 50, 16, tt.normalize)
+
+			recall, err := h.Recall(queries, 10)
+			if err != nil {
+				t.Fatalf("recall: %v", err)
+			}
+			if recall < 0.9 {
+				t.Fatalf("recall@10 = %.3f, want >= 0.9", recall)
+			}
+
+			// 结果按距离升序, 与暴力搜索使用同一度量
+			results, err := h.Search(queries[0], 10)
+			if err != nil {
+				t.Fatalf("search: %v", err)
+			}
+			for i := 1; i < len(results); i++ {
+				if results[i].Distance < results[i-1].Distance {
+					t.Fatalf("results not sorted by distance: %v > %v", results[i-1].Distance, results[i].Distance)
+				}
+			}
+			exact := h.BruteForceSearch(queries[0], 1)
+			if results[0].Node.ID != exact[0].Node.ID {
+				t.Errorf("nearest = %d, brute force nearest = %d", results[0].Node.ID, exact[0].Node.ID)
+			}
+		})
+	}
+}
+
+func TestHNSWInsertRejectsDuplicateAndDimensionMismatch(t *testing.T) {
+	h := newTestHNSW(t, MetricEuclidean, []Vector{{1, 2, 3}})
+	if err := h.Insert(0, Vector{4, 5, 6}); err == nil {
+		t.Error("duplicate id should be rejected")
+	}
+	if err := h.Insert(1, Vector{1, 2}); err == nil {
+		t.Error("dimension mismatch should be rejected")
+	}
+	if _, err := h.Search(Vector{1, 2}, 1); err == nil {
+		t.Error("search with mismatched dimensions should fail")
+	}
+}
+
+func TestHNSWDeleteExcludesTombstones(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	vectors := randomVectors(rng, 200, 16, false)
+	h := newTestHNSW(t, MetricEuclidean, vectors)
+
+	// 删除查询向量本身, 它不应再出现在结果中, 但图仍然连通
+	if !h.Delete(0) {
+		t.Fatal("delete 0 = false")
+	}
+	if h.Delete(0) {
+		t.Error("deleting twice should return false")
+	}
+	if h.Delete(9999) {
+		t.Error("deleting unknown id should return false")
+	}
+	if h.Len() != len(vectors)-1 {
+		t.Errorf("Len = %d, want %d", h.Len(), len(vectors)-1)
+	}
+
+	results, err := h.Search(vectors[0], 10)
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if len(results) != 10 {
+		t.Fatalf("got %d results, want 10", len(results))
+	}
+	for _, result := range results {
+		if result.Node.ID == 0 || result.Node.Deleted {
+			t.Fatalf("deleted node %d returned", result.Node.ID)
+		}
+	}
+}
+
+func TestHNSWRebuildCompactsTombstones(t *testing.T) {
+	rng := rand.New(rand.NewSource(11))
+	vectors := randomVectors(rng, 300, 16, false)
+	h := newTestHNSW(t, MetricEuclidean, vectors)
+
+	for id := 0; id < 100; id++ {
+		h.Delete(id)
+	}
+	if !h.NeedsRebuild() {
+		t.Fatalf("deleted ratio %.2f should require rebuild", h.DeletedRatio())
+	}
+
+	levels := make(map[int]int)
+	for id, node := range h.Nodes {
+		levels[id] = node.Level
+	}
+	h.Rebuild()
+
+	if len(h.Nodes) != 200 || h.Len() != 200 || h.DeletedRatio() != 0 {
+		t.Fatalf("after rebuild nodes=%d len=%d ratio=%.2f, want 200 live nodes", len(h.Nodes), h.Len(), h.DeletedRatio())
+	}
+	for id, node := range h.Nodes {
+		if id < 100 {
+			t.Fatalf("deleted node %d survived rebuild", id)
+		}
+		if node.Level != levels[id] {
+			t.Errorf("node %d level changed from %d to %d", id, levels[id], node.Level)
+		}
+		for _, neighbors := range node.Neighbors {
+			for neighborID := range neighbors {
+				if _, ok := h.Nodes[neighborID]; !ok {
+					t.Fatalf("node %d still links to removed node %d", id, neighborID)
+				}
+			}
+		}
+	}
+
+	recall, err := h.Recall(randomVectors(rng, 20, 16, false), 5)
+	if err != nil {
+		t.Fatalf("recall: %v", err)
+	}
+	if recall < 0.9 {
+		t.Errorf("recall@5 after rebuild = %.3f, want >= 0.9", recall)
+	}
+}
+
+func TestHNSWSaveLoadRoundTrip(t *testing.T) {
+	for _, tt := range testMetrics {
+		t.Run(tt.metric.String(), func(t *testing.T) {
+			rng := rand.New(rand.NewSource(3))
+			h := newTestHNSW(t, tt.metric, randomVectors(rng, 150, 8, tt.normalize))
+			h.Delete(5)
+			h.Ef = 64
+
+			path := filepath.Join(t.TempDir(), "nested", "index.hnsw")
+			if err := h.SaveFile(path); err != nil {
+				t.Fatalf("save: %v", err)
+			}
+			loaded, err := LoadHNSWFile(path)
+			if err != nil {
+				t.Fatalf("load: %v", err)
+			}
+
+			if loaded.Metric != h.Metric || loaded.M != h.M || loaded.Ef != h.Ef || loaded.EfConstruction != h.EfConstruction ||
+				loaded.Ml != h.Ml || loaded.Dimensions != h.Dimensions || loaded.MaxLevel != h.MaxLevel {
+				t.Fatalf("loaded parameters differ: %+v", loaded)
+			}
+			if loaded.EntryPoint.ID != h.EntryPoint.ID || loaded.Len() != h.Len() || len(loaded.Nodes) != len(h.Nodes) {
+				t.Fatalf("loaded graph differs: entry %d len %d nodes %d", loaded.EntryPoint.ID, loaded.Len(), len(loaded.Nodes))
+			}
+			for id, node := range h.Nodes {
+				other := loaded.Nodes[id]
+				if other.Level != node.Level || other.Deleted != node.Deleted {
+					t.Fatalf("node %d differs after load", id)
+				}
+				for lev := range node.Neighbors {
+					if len(other.Neighbors[lev]) != len(node.Neighbors[lev]) {
+						t.Fatalf("node %d level %d neighbors differ", id, lev)
+					}
+				}
+			}
+
+			// 相同的图得到相同的搜索结果
+			for _, query := range randomVectors(rng, 10, 8, tt.normalize) {
+				want, _ := h.Search(query, 5)
+				got, err := loaded.Search(query, 5)
+				if err != nil {
+					t.Fatalf("search loaded: %v", err)
+				}
+				if fmt.Sprint(candidateIDs(got)) != fmt.Sprint(candidateIDs(want)) {
+					t.Fatalf("loaded search = %v, want %v", candidateIDs(got), candidateIDs(want))
+				}
+			}
+
+			// 加载后的索引可以继续插入
+			if err := loaded.Insert(1000, randomVectors(rng, 1, 8, tt.normalize)[0]); err != nil {
+				t.Fatalf("insert after load: %v", err)
+			}
+		})
+	}
+}
+
+func TestHNSWSaveLoadEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := NewHNSW(16, 200, 1).WriteTo(&buf); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	loaded, err := ReadHNSW(&buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if loaded.EntryPoint != nil || loaded.Len() != 0 {
+		t.Fatalf("loaded empty index has %d nodes", loaded.Len())
+	}
+}
+
+func TestReadHNSWRejectsCorruptInput(t *testing.T) {
+	h := newTestHNSW(t, MetricCosine, randomVectors(rand.New(rand.NewSource(5)), 20, 4, false))
+	var buf bytes.Buffer
+	if _, err := h.WriteTo(&buf); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	valid := buf.Bytes()
+
+	// 头部字段偏移: magic 4 | version 2 | metric 1 | M 4 | Ef 4 | EfConstruction 4 | Ml 8 | dimensions 4 | maxLevel 4 | entryPoint 8 | nodeCount 4
+	const (
+		dimensionsOffset = 4 + 2 + 1 + 4 + 4 + 4 + 8
+		maxLevelOffset   = dimensionsOffset + 4
+		nodeCountOffset  = maxLevelOffset + 4 + 8
+		firstNodeOffset  = nodeCountOffset + 4
+		firstLevelOffset = firstNodeOffset + 8
+	)
+	patch := func(offset int, value uint32) []byte {
+		data := bytes.Clone(valid)
+		binary.LittleEndian.PutUint32(data[offset:], value)
+		return data
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"bad magic", append([]byte("HNSX"), valid[4:]...)},
+		{"bad version", func() []byte { d := bytes.Clone(valid); d[4] = 9; return d }()},
+		{"truncated header", valid[:20]},
+		{"truncated node", valid[:len(valid)-3]},
+		{"huge node count", patch(nodeCountOffset, math.MaxUint32)},
+		{"huge dimensions", patch(dimensionsOffset, math.MaxUint32)},
+		{"level above max level", patch(firstLevelOffset, uint32(h.MaxLevel)+1)},
+		{"huge level", func() []byte {
+			d := patch(maxLevelOffset, math.MaxUint32)
+			binary.LittleEndian.PutUint32(d[firstLevelOffset:], math.MaxUint32-1)
+			return d
+		}()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadHNSW(bytes.NewReader(tt.data)); !errors.Is(err, ErrInvalidHNSWFile) {
+				t.Fatalf("err = %v, want ErrInvalidHNSWFile", err)
+			}
+		})
+	}
+}
+
+// craftedNode 手工构造的索引节点, neighbors[lev] 为第 lev 层的邻居 ID
+type craftedNode struct {
+	id        int64
+	level     uint32
+	neighbors [][]int64
+}
+
+// craftHNSW 按文件格式手工写出一个二维的索引, 用于构造结构上不一致的图
+func craftHNSW(maxLevel uint32, entryPoint int64, nodes []craftedNode) []byte {
+	var buf bytes.Buffer
+	write := func(value interface{}) { _ = binary.Write(&buf, binary.LittleEndian, value) }
+	buf.WriteString(hnswMagic)
+	for _, field := range []interface{}{
+		uint16(hnswVersion), uint8(MetricEuclidean), uint32(16), uint32(200), uint32(200), 1.0 / math.Log(16),
+		uint32(2), maxLevel, entryPoint, uint32(len(nodes)),
+	} {
+		write(field)
+	}
+	for _, node := range nodes {
+		write(node.id)
+		write(node.level)
+		write(uint8(0))
+		write([]float32{float32(node.id), 0})
+		for _, ids := range node.neighbors {
+			write(uint32(len(ids)))
+			write(ids)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestReadHNSWRejectsInconsistentGraph(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxLevel uint32
+		entry    int64
+		nodes    []craftedNode
+		wantErr  bool
+	}{
+		{
+			name:     "valid",
+			maxLevel: 1,
+			entry:    0,
+			nodes: []craftedNode{
+				{id: 0, level: 1, neighbors: [][]int64{{1, 2}, {2}}},
+				{id: 1, level: 0, neighbors: [][]int64{{0}}},
+				{id: 2, level: 1, neighbors: [][]int64{{0}, {0}}},
+			},
+		},
+		{
+			name:     "neighbor below layer",
+			maxLevel: 1,
+			entry:    0,
+			nodes: []craftedNode{
+				{id: 0, level: 1, neighbors: [][]int64{{1}, {1}}},
+				{id: 1, level: 0, neighbors: [][]int64{{0}}},
+			},
+			wantErr: true,
+		},
+		{
+			name:     "entry point below max level",
+			maxLevel: 1,
+			entry:    1,
+			nodes: []craftedNode{
+				{id: 0, level: 1, neighbors: [][]int64{{1}, {}}},
+				{id: 1, level: 0, neighbors: [][]int64{{0}}},
+			},
+			wantErr: true,
+		},
+		{
+			name:     "max level above every node",
+			maxLevel: 2,
+			entry:    0,
+			nodes: []craftedNode{
+				{id: 0, level: 1, neighbors: [][]int64{{1}, {}}},
+				{id: 1, level: 0, neighbors: [][]int64{{0}}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := ReadHNSW(bytes.NewReader(craftHNSW(tt.maxLevel, tt.entry, tt.nodes)))
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidHNSWFile) {
+					t.Fatalf("err = %v, want ErrInvalidHNSWFile", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			// 合法的图可以正常搜索
+			results, err := h.Search(Vector{1, 0}, 2)
+			if err != nil || len(results) != 2 || results[0].Node.ID != 1 {
+				t.Fatalf("search = %v, %v", candidateIDs(results), err)
+			}
+		})
+	}
+}
+
+func candidateIDs(candidates []*Candidate) []int {
+	ids := make([]int, len(candidates))
+	for i, candidate := range candidates {
+		ids[i] = candidate.Node.ID
+	}
+	return ids
+}
+
+func BenchmarkHNSWSearch(b *testing.B) {
+	for _, tt := range testMetrics {
+		rng := rand.New(rand.NewSource(42))
+		h := newTestHNSW(b, tt.metric, randomVectors(rng, 10000, 32, tt.normalize))
+		h.Ef = 64
+		queries := randomVectors(rng, 100, 32, tt.normalize)
+
+		b.Run(tt.metric.String()+"/hnsw", func(b *testing.B) {
+			recall, err := h.Recall(queries, 10)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := h.Search(queries[i%len(queries)], 10); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(recall, "recall@10")
+		})
+		b.Run(tt.metric.String()+"/bruteforce", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				h.BruteForceSearch(queries[i%len(queries)], 10)
+			}
+		})
+	}
+}
+
+func BenchmarkHNSWInsert(b *testing.B) {
+	for _, tt := range testMetrics {
+		b.Run(tt.metric.String(), func(b *testing.B) {
+			vectors := randomVectors(rand.New(rand.NewSource(42)), b.N, 32, tt.normalize)
+			h := newTestHNSW(b, tt.metric, nil)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := h.Insert(i, vectors[i]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
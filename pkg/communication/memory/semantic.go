@@ -0,0 +1,515 @@
+package memory
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/providers/embedding"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/services"
+)
+
+const (
+	DefaultSemanticTopK = 5
+
+	semanticEmbedTimeout = 30 * time.Second
+	semanticSaveInterval = 32 // 每新增多少条向量落盘一次图结构
+)
+
+// semanticIndex 单个用户的向量索引, HNSW 节点 ID 对应 MemoryVector.NodeID
+type semanticIndex struct {
+	hnsw *HNSW
+	path string // 图结构的持久化文件, 为空时不落盘
+
+	mu         sync.RWMutex
+	records    map[int]*repositories.MemoryVector
+	messageIDs map[string]int
+	nextNodeID int
+	unsaved    int
+}
+
+func newSemanticIndex(path string) *semanticIndex {
+	return &semanticIndex{
+		hnsw:       NewHNSWWithMetric(16, 200, 1.0/math.Log(16), MetricCosine),
+		path:       path,
+		records:    make(map[int]*repositories.MemoryVector),
+		messageIDs: make(map[string]int),
+	}
+}
+
+// load 优先使用落盘的图结构, 与数据库记录不一致时用数据库中的向量重建
+func (idx *semanticIndex) load(records []*repositories.MemoryVector) {
+	for _, record := range records {
+		idx.records[record.NodeID] = record
+		idx.messageIDs[record.MessageID] = record.NodeID
+		if record.NodeID >= idx.nextNodeID {
+			idx.nextNodeID = record.NodeID + 1
+		}
+	}
+
+	if idx.path != "" {
+		graph, err := LoadHNSWFile(idx.path)
+		switch {
+		case err == nil && idx.matches(graph):
+			idx.hnsw = graph
+			return
+		case err == nil:
+			logrus.Warnf("HNSW 索引文件 %s 与数据库不一致, 重建索引", idx.path)
+		case !os.IsNotExist(err):
+			logrus.Warnf("加载 HNSW 索引文件 %s 失败, 重建索引: %v", idx.path, err)
+		}
+	}
+
+	for _, record := range records {
+		if err := idx.hnsw.Insert(record.NodeID, decodeVector(record.Vector)); err != nil {
+			logrus.Warnf("跳过向量 %s: %v", record.ID, err)
+		}
+	}
+	idx.unsaved = len(records)
+	idx.save()
+}
+
+func (idx *semanticIndex) matches(graph *HNSW) bool {
+	if graph.Metric != MetricCosine || graph.Len() != len(idx.records) {
+		return false
+	}
+	for nodeID := range idx.records {
+		node, ok := graph.Nodes[nodeID]
+		if !ok || node.Deleted {
+			return false
+		}
+	}
+	return true
+}
+
+// allocate 分配节点 ID, 同一用户的写入需要串行, 由调用方在插入数据库前获取
+func (idx *semanticIndex) allocate(messageID string) (int, bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if _, exists := idx.messageIDs[messageID]; exists {
+		return 0, false
+	}
+	nodeID := idx.nextNodeID
+	idx.nextNodeID++
+	idx.messageIDs[messageID] = nodeID
+	return nodeID, true
+}
+
+func (idx *semanticIndex) release(messageID string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	delete(idx.messageIDs, messageID)
+}
+
+func (idx *semanticIndex) add(record *repositories.MemoryVector, vector []float32) error {
+	if err := idx.hnsw.Insert(record.NodeID, vector); err != nil {
+		return err
+	}
+
+	idx.mu.Lock()
+	idx.records[record.NodeID] = record
+	idx.unsaved++
+	shouldSave := idx.unsaved >= semanticSaveInterval
+	idx.mu.Unlock()
+
+	if shouldSave {
+		idx.save()
+	}
+	return nil
+}
+
+// remove 删除消息对应的向量, 墓碑过多时重建图
+func (idx *semanticIndex) remove(messageID string) (*repositories.MemoryVector, bool) {
+	idx.mu.Lock()
+	nodeID, exists := idx.messageIDs[messageID]
+	record := idx.records[nodeID]
+	if exists {
+		delete(idx.messageIDs, messageID)
+		delete(idx.records, nodeID)
+		idx.unsaved++
+	}
+	idx.mu.Unlock()
+
+	if !exists || record == nil {
+		return nil, false
+	}
+
+	idx.hnsw.Delete(nodeID)
+	if idx.hnsw.NeedsRebuild() {
+		logrus.Infof("HNSW 墓碑占比 %.2f, 重建索引", idx.hnsw.DeletedRatio())
+		idx.hnsw.Rebuild()
+	}
+	idx.save()
+	return record, true
+}
+
+func (idx *semanticIndex) contains(messageID string) bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	_, exists := idx.messageIDs[messageID]
+	return exists
+}
+
+type semanticHit struct {
+	record *repositories.MemoryVector
+	score  float64
+}
+
+func (idx *semanticIndex) search(vector []float32, k int) ([]*semanticHit, error) {
+	candidates, err := idx.hnsw.Search(vector, k)
+	if err != nil {
+		return nil, err
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	hits := make([]*semanticHit, 0, len(candidates))
+	for _, candidate := range candidates {
+		record, ok := idx.records[candidate.Node.ID]
+		if !ok {
+			continue
+		}
+		hits = append(hits, &semanticHit{
+			record: record,
+			score:  float64(1 - candidate.Distance),
+		})
+	}
+	return hits, nil
+}
+
+// save 将图结构写入文件, 失败只记录日志, 下次加载时会从数据库重建
+func (idx *semanticIndex) save() {
+	if idx.path == "" {
+		return
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.unsaved == 0 {
+		return
+	}
+	if err := idx.hnsw.SaveFile(idx.path); err != nil {
+		logrus.Errorf("保存 HNSW 索引 %s 失败: %v", idx.path, err)
+		return
+	}
+	idx.unsaved = 0
+}
+
+// SemanticIndexRegistry 进程内共享的用户向量索引, 首次访问时从数据库和数据目录加载
+type SemanticIndexRegistry struct {
+	metaStore *repositories.MetaStore
+	embedder  embedding.Embedding
+	model     string
+	dataDir   string
+
+	mu      sync.Mutex
+	indexes map[string]*semanticIndex
+}
+
+var (
+	sharedRegistryOnce sync.Once
+	sharedRegistry     *SemanticIndexRegistry
+)
+
+// SharedSemanticIndexRegistry 按配置创建进程内唯一的向量索引注册表, 聊天和历史检索共用, 避免同一用户的索引加载多份.
+// 未启用语义记忆或 embedding 初始化失败时返回 nil
+func SharedSemanticIndexRegistry(config *config.SocialConfig, metaStore *repositories.MetaStore) *SemanticIndexRegistry {
+	sharedRegistryOnce.Do(func() {
+		memoryConfig := config.Avatar.Memory
+		if !memoryConfig.Semantic {
+			return
+		}
+		embedder, err := embedding.NewEmbedding(memoryConfig.Embedding)
+		if err != nil {
+			logrus.Errorf("初始化 embedding 失败, 语义记忆不可用: %v", err)
+			return
+		}
+		model := fmt.Sprintf("%s:%s:%d", memoryConfig.Embedding.Provider, memoryConfig.Embedding.Model, memoryConfig.Embedding.Dimensions)
+		sharedRegistry = NewSemanticIndexRegistry(metaStore, embedder, model, config.Storage.DataDir)
+	})
+	return sharedRegistry
+}
+
+// NewSemanticIndexRegistry dataDir 为空时只持久化向量, 图结构每次启动时重建
+func NewSemanticIndexRegistry(metaStore *repositories.MetaStore, embedder embedding.Embedding, model string, dataDir string) *SemanticIndexRegistry {
+	return &SemanticIndexRegistry{
+		metaStore: metaStore,
+		embedder:  embedder,
+		model:     model,
+		dataDir:   dataDir,
+		indexes:   make(map[string]*semanticIndex),
+	}
+}
+
+func (r *SemanticIndexRegistry) index(userDid string) (*semanticIndex, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if idx, ok := r.indexes[userDid]; ok {
+		return idx, nil
+	}
+
+	records, err := r.metaStore.MemoryRepo.ListMemoryVectorsByUser(userDid)
+	if err != nil {
+		return nil, fmt.Errorf("加载用户向量失败: %w", err)
+	}
+
+	// 向量化模型变更后旧向量不可比较, 跳过
+	usable := make([]*repositories.MemoryVector, 0, len(records))
+	for _, record := range records {
+		if record.Model == r.model {
+			usable = append(usable, record)
+		}
+	}
+
+	idx := newSemanticIndex(r.indexPath(userDid))
+	idx.load(usable)
+	logrus.Infof("已加载用户 %s 的语义记忆, 共 %d 条", userDid, len(usable))
+
+	r.indexes[userDid] = idx
+	return idx, nil
+}
+
+func (r *SemanticIndexRegistry) indexPath(userDid string) string {
+	if r.dataDir == "" {
+		return ""
+	}
+	sum := sha1.Sum([]byte(userDid + "|" + r.model))
+	return filepath.Join(r.dataDir, "memory", hex.EncodeToString(sum[:])+".hnsw")
+}
+
+// Flush 将所有索引的图结构写入数据目录
+func (r *SemanticIndexRegistry) Flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, idx := range r.indexes {
+		idx.save()
+	}
+}
+
+// Memory 创建绑定到某个用户和会话的语义记忆
+func (r *SemanticIndexRegistry) Memory(userDid string, roomID string, threadID string, topK int) *SemanticMemory {
+	if topK <= 0 {
+		topK = DefaultSemanticTopK
+	}
+	return &SemanticMemory{
+		registry:       r,
+		userDid:        userDid,
+		roomID:         roomID,
+		threadID:       threadID,
+		topK:           topK,
+		messageService: services.NewMessageService(r.metaStore),
+	}
+}
+
+// SemanticMemory 跨房间和话题的长期记忆
+// Write 时将消息文本向量化并持久化, Retrieve 时返回该用户所有会话中与查询最相关的 topK 条消息
+type SemanticMemory struct {
+	registry *SemanticIndexRegistry
+	userDid  string
+	roomID   string
+	threadID string
+	topK     int
+
+	messageService *services.MessageService
+}
+
+func (m *SemanticMemory) Write(chunk Chunk) error {
+	messageChunk, ok := chunk.(*MessageChunk)
+	if !ok {
+		return nil
+	}
+
+	text := ChunkText(messageChunk)
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+
+	idx, err := m.registry.index(m.userDid)
+	if err != nil {
+		return err
+	}
+	nodeID, ok := idx.allocate(messageChunk.ID)
+	if !ok {
+		return nil
+	}
+
+	vector, err := m.embed(text)
+	if err != nil {
+		idx.release(messageChunk.ID)
+		return err
+	}
+
+	roomID, threadID := m.roomID, m.threadID
+	if messageChunk.Content != nil {
+		roomID, threadID = messageChunk.Content.RoomID, messageChunk.Content.ThreadID
+	}
+	record := &repositories.MemoryVector{
+		ID:         uuid.New().String(),
+		UserDid:    m.userDid,
+		NodeID:     nodeID,
+		RoomID:     roomID,
+		ThreadID:   threadID,
+		MessageID:  messageChunk.ID,
+		ChunkType:  string(messageChunk.GetType()),
+		Text:       text,
+		Model:      m.registry.model,
+		Dimensions: len(vector),
+		Vector:     encodeVector(vector),
+	}
+	if err := m.registry.metaStore.MemoryRepo.InsertMemoryVector(record); err != nil {
+		idx.release(messageChunk.ID)
+		return fmt.Errorf("保存向量失败: %w", err)
+	}
+	return idx.add(record, vector)
+}
+
+// Forget 从长期记忆中删除一条消息
+func (m *SemanticMemory) Forget(messageID string) error {
+	idx, err := m.registry.index(m.userDid)
+	if err != nil {
+		return err
+	}
+	if _, ok := idx.remove(messageID); !ok {
+		return nil
+	}
+	return m.registry.metaStore.MemoryRepo.DeleteMemoryVectorsByMessageID(m.userDid, messageID)
+}
+
+func (m *SemanticMemory) Retrieve(query Chunk) ([]Chunk, error) {
+	messageChunk, ok := query.(*MessageChunk)
+	if !ok {
+		return nil, fmt.Errorf("不支持的查询类型: %s", query.GetType())
+	}
+
+	text := ChunkText(messageChunk)
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+
+	idx, err := m.registry.index(m.userDid)
+	if err != nil {
+		return nil, err
+	}
+
+	vector, err := m.embed(text)
+	if err != nil {
+		return nil, err
+	}
+
+	// 多取一条, 查询消息本身可能已经写入索引
+	hits, err := idx.search(vector, m.topK+1)
+	if err != nil {
+		return nil, err
+	}
+
+	hitsByMessageID := make(map[string]*semanticHit, len(hits))
+	messageIDs := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		if hit.record.MessageID == messageChunk.ID {
+			continue
+		}
+		if _, ok := hitsByMessageID[hit.record.MessageID]; ok {
+			continue
+		}
+		hitsByMessageID[hit.record.MessageID] = hit
+		messageIDs = append(messageIDs, hit.record.MessageID)
+	}
+	if len(messageIDs) > m.topK {
+		messageIDs = messageIDs[:m.topK]
+	}
+	if len(messageIDs) == 0 {
+		return nil, nil
+	}
+
+	dbMessages, err := m.registry.metaStore.MessageRepo.GetMessagesByIDs(messageIDs)
+	if err != nil {
+		return nil, fmt.Errorf("查询消息失败: %w", err)
+	}
+
+	chunks := make([]Chunk, 0, len(dbMessages))
+	for _, dbMsg := range dbMessages {
+		hit := hitsByMessageID[dbMsg.ID]
+		message := m.messageService.Converter.DBToMessage(dbMsg)
+		chunks = append(chunks, &MessageChunk{
+			ID: dbMsg.ID,
+			Metadata: map[string]interface{}{
+				"sender_id":   message.SenderID,
+				"receiver_id": message.ReceiverID,
+				"room_id":     message.RoomID,
+				"thread_id":   message.ThreadID,
+				"created_at":  message.CreatedAt,
+				"msg_type":    message.MsgType,
+				"text":        hit.record.Text,
+				"score":       hit.score,
+			},
+			Content: message,
+		})
+	}
+
+	sort.SliceStable(chunks, func(i, j int) bool {
+		return chunks[i].(*MessageChunk).Metadata["score"].(float64) > chunks[j].(*MessageChunk).Metadata["score"].(float64)
+	})
+	return chunks, nil
+}
+
+func (m *SemanticMemory) Close() error {
+	return nil
+}
+
+func (m *SemanticMemory) embed(text string) ([]float32, error) {
+	return m.registry.embed(text)
+}
+
+func (r *SemanticIndexRegistry) embed(text string) ([]float32, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), semanticEmbedTimeout)
+	defer cancel()
+
+	vector, err := r.embedder.Embed(ctx, text)
+	if err != nil {
+		return nil, fmt.Errorf("向量化失败: %w", err)
+	}
+	if len(vector) == 0 {
+		return nil, fmt.Errorf("向量化结果为空")
+	}
+	return vector, nil
+}
+
+// ChunkText 提取消息块中可用于检索的文本, Metadata 中的 text 优先
+func ChunkText(chunk *MessageChunk) string {
+	if text, ok := chunk.Metadata["text"].(string); ok && text != "" {
+		return text
+	}
+	return services.MessageText(chunk.Content)
+}
+
+func encodeVector(vector []float32) []byte {
+	data := make([]byte, 4*len(vector))
+	for i, value := range vector {
+		binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(value))
+	}
+	return data
+}
+
+func decodeVector(data []byte) []float32 {
+	vector := make([]float32, len(data)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:]))
+	}
+	return vector
+}
@@ -0,0 +1,163 @@
+package memory
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/providers/embedding"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/services"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestMetaStore(t *testing.T) *repositories.MetaStore {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.sqlite")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	store := repositories.NewMetaStore(db)
+	if err := store.Init(); err != nil {
+		t.Fatalf("init metastore: %v", err)
+	}
+	return store
+}
+
+// writeMessage 保存一条文本消息并写入语义记忆
+func writeMessage(t *testing.T, store *repositories.MetaStore, memory *SemanticMemory, id string, senderID string, text string) {
+	t.Helper()
+	message := &messages.Message{
+		ID:       id,
+		RoomID:   "room-" + senderID,
+		ThreadID: "thread-" + senderID,
+		MsgType:  messages.MessageTypeText,
+		SenderID: senderID,
+		Content:  &messages.TextMessageContent{Text: text},
+	}
+	converter := services.NewMessageConverter(store.MessageRepo)
+	if err := store.MessageRepo.InsertMessage(converter.MessageToDB(message)); err != nil {
+		t.Fatalf("insert message %s: %v", id, err)
+	}
+	if err := memory.Write(&MessageChunk{ID: id, Content: message}); err != nil {
+		t.Fatalf("write %s: %v", id, err)
+	}
+}
+
+func retrieveIDs(t *testing.T, memory *SemanticMemory, text string) ([]string, []float64) {
+	t.Helper()
+	chunks, err := memory.Retrieve(&MessageChunk{ID: "query", Metadata: map[string]interface{}{"text": text}})
+	if err != nil {
+		t.Fatalf("retrieve %q: %v", text, err)
+	}
+	ids := make([]string, 0, len(chunks))
+	scores := make([]float64, 0, len(chunks))
+	for _, chunk := range chunks {
+		messageChunk := chunk.(*MessageChunk)
+		ids = append(ids, messageChunk.ID)
+		scores = append(scores, messageChunk.Metadata["score"].(float64))
+	}
+	return ids, scores
+}
+
+func TestSemanticMemoryPerUserIsolation(t *testing.T) {
+	store := newTestMetaStore(t)
+	registry := NewSemanticIndexRegistry(store, embedding.NewHashingEmbedding(128), "hashing:test:128", "")
+
+	alice := registry.Memory("did:plc:alice", "room-alice", "thread-alice", 5)
+	bob := registry.Memory("did:plc:bob", "room-bob", "thread-bob", 5)
+	writeMessage(t, store, alice, "alice-1", "did:plc:alice", "my cat is called mochi")
+	writeMessage(t, store, bob, "bob-1", "did:plc:bob", "my cat is called mochi too")
+
+	ids, _ := retrieveIDs(t, alice, "what is my cat called")
+	if len(ids) != 1 || ids[0] != "alice-1" {
+		t.Fatalf("alice retrieved %v, want only alice-1", ids)
+	}
+	ids, _ = retrieveIDs(t, bob, "what is my cat called")
+	if len(ids) != 1 || ids[0] != "bob-1" {
+		t.Fatalf("bob retrieved %v, want only bob-1", ids)
+	}
+
+	// 同一用户的其他会话共享长期记忆
+	other := registry.Memory("did:plc:alice", "room-other", "thread-other", 5)
+	ids, _ = retrieveIDs(t, other, "cat mochi")
+	if len(ids) != 1 || ids[0] != "alice-1" {
+		t.Fatalf("alice in another room retrieved %v, want alice-1", ids)
+	}
+}
+
+func TestSemanticMemoryTopKOrdering(t *testing.T) {
+	store := newTestMetaStore(t)
+	registry := NewSemanticIndexRegistry(store, embedding.NewHashingEmbedding(256), "hashing:test:256", "")
+	memory := registry.Memory("did:plc:alice", "room", "thread", 2)
+
+	writeMessage(t, store, memory, "exact", "did:plc:alice", "weekend hiking trip to the mountains")
+	writeMessage(t, store, memory, "partial", "did:plc:alice", "hiking boots shopping list")
+	writeMessage(t, store, memory, "unrelated", "did:plc:alice", "quarterly tax report deadline")
+	writeMessage(t, store, memory, "empty", "did:plc:alice", "   ")
+
+	ids, scores := retrieveIDs(t, memory, "weekend hiking trip to the mountains")
+	if len(ids) != 2 {
+		t.Fatalf("retrieved %v, want top 2", ids)
+	}
+	if ids[0] != "exact" || ids[1] != "partial" {
+		t.Fatalf("retrieved %v, want [exact partial]", ids)
+	}
+	if scores[0] < scores[1] {
+		t.Fatalf("scores %v are not in descending order", scores)
+	}
+
+	// 查询消息本身已写入时不返回自身
+	chunks, err := memory.Retrieve(&MessageChunk{ID: "exact", Metadata: map[string]interface{}{"text": "weekend hiking trip to the mountains"}})
+	if err != nil {
+		t.Fatalf("retrieve: %v", err)
+	}
+	for _, chunk := range chunks {
+		if chunk.(*MessageChunk).ID == "exact" {
+			t.Fatal("query message should not be returned")
+		}
+	}
+}
+
+func TestSemanticMemoryForget(t *testing.T) {
+	store := newTestMetaStore(t)
+	dataDir := t.TempDir()
+	registry := NewSemanticIndexRegistry(store, embedding.NewHashingEmbedding(128), "hashing:test:128", dataDir)
+	memory := registry.Memory("did:plc:alice", "room", "thread", 5)
+
+	writeMessage(t, store, memory, "keep", "did:plc:alice", "favourite colour is green")
+	writeMessage(t, store, memory, "forget", "did:plc:alice", "favourite colour is blue")
+
+	if err := memory.Forget("forget"); err != nil {
+		t.Fatalf("forget: %v", err)
+	}
+	ids, _ := retrieveIDs(t, memory, "favourite colour")
+	if len(ids) != 1 || ids[0] != "keep" {
+		t.Fatalf("retrieved %v after forget, want only keep", ids)
+	}
+
+	records, err := store.MemoryRepo.ListMemoryVectorsByUser("did:plc:alice")
+	if err != nil {
+		t.Fatalf("list vectors: %v", err)
+	}
+	if len(records) != 1 || records[0].MessageID != "keep" {
+		t.Fatalf("persisted vectors = %d, want only keep", len(records))
+	}
+
+	// 重新加载索引后删除仍然生效
+	registry.Flush()
+	reloaded := NewSemanticIndexRegistry(store, embedding.NewHashingEmbedding(128), "hashing:test:128", dataDir)
+	ids, _ = retrieveIDs(t, reloaded.Memory("did:plc:alice", "room", "thread", 5), "favourite colour")
+	if len(ids) != 1 || ids[0] != "keep" {
+		t.Fatalf("retrieved %v after reload, want only keep", ids)
+	}
+
+	// 重复删除和删除不存在的消息都不报错
+	if err := memory.Forget("forget"); err != nil {
+		t.Fatalf("forget twice: %v", err)
+	}
+}
@@ -3,11 +3,20 @@ package memory
 import (
 	"fmt"
 
+	"github.com/sirupsen/logrus"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
 	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
 	"github.com/zhongshangwu/avatarai-social/pkg/services"
 	"gorm.io/gorm"
 )
 
+const (
+	// DefaultHistoryLimit 单次检索的历史消息条数上限
+	DefaultHistoryLimit = 100
+	// maxInheritDepth 连续话题向上追溯父话题的最大层数
+	maxInheritDepth = 5
+)
+
 type SimpleThreadMemory struct {
 	db       *gorm.DB
 	roomID   string
@@ -28,6 +37,9 @@ func (m *SimpleThreadMemory) Write(chunk Chunk) error {
 	return nil
 }
 
+// Retrieve 按话题的上下文模式检索历史消息, 按时间正序返回
+// 隔离话题只包含自身的消息; 连续话题会继承父话题 (或房间主线) 在分叉点之前的消息.
+// 这里只按条数截断, 按 token 预算的裁剪由上下文构建阶段完成
 func (m *SimpleThreadMemory) Retrieve(query Chunk) ([]Chunk, error) {
 	if m.db == nil {
 		return nil, fmt.Errorf("数据库连接为空")
@@ -37,13 +49,26 @@ func (m *SimpleThreadMemory) Retrieve(query Chunk) ([]Chunk, error) {
 	metaStore := &repositories.MetaStore{DB: m.db}
 	messageRepo := repositories.NewMessageRepository(metaStore)
 
-	dbMessages, err := messageRepo.ListMessagesHistory(m.roomID, m.threadID)
+	dbMessages, err := m.history(messageRepo, m.threadID, 0, DefaultHistoryLimit, 0)
 	if err != nil {
 		return nil, fmt.Errorf("查询消息失败: %w", err)
 	}
 
-	chunks := make([]Chunk, 0, len(dbMessages))
+	// 已被话题摘要覆盖的消息用摘要替代, 摘要放在话题自身消息之前
+	summary, err := m.threadSummary(metaStore)
+	if err != nil {
+		logrus.Warnf("读取话题摘要失败, 使用原始消息: %v", err)
+	}
+
+	chunks := make([]Chunk, 0, len(dbMessages)+1)
 	for _, dbMsg := range dbMessages {
+		if summary != nil && dbMsg.ThreadID == m.threadID {
+			if summary.Covers(dbMsg.CreatedAt, dbMsg.ID) {
+				continue
+			}
+			chunks = append(chunks, summary)
+			summary = nil
+		}
 		message := m.messageService.Converter.DBToMessage(dbMsg)
 		chunk := &MessageChunk{
 			ID: dbMsg.ID,
@@ -53,15 +78,63 @@ func (m *SimpleThreadMemory) Retrieve(query Chunk) ([]Chunk, error) {
 				"sender_at":   message.SenderAt,
 				"created_at":  message.CreatedAt,
 				"msg_type":    message.MsgType,
+				"thread_id":   dbMsg.ThreadID,
+				"inherited":   dbMsg.ThreadID != m.threadID,
 			},
 			Content: message,
 		}
 		chunks = append(chunks, chunk)
 	}
+	if summary != nil {
+		chunks = append(chunks, summary)
+	}
 
 	return chunks, nil
 }
 
+func (m *SimpleThreadMemory) threadSummary(metaStore *repositories.MetaStore) (*SummaryChunk, error) {
+	summary, err := repositories.NewMemoryRepository(metaStore).GetLatestThreadSummary(m.roomID, m.threadID)
+	if err != nil || summary == nil {
+		return nil, err
+	}
+	return ThreadSummaryChunk(summary)
+}
+
+// history 查询话题中不晚于 before 的最近 limit 条消息, 连续话题消息不足时向父话题追溯
+func (m *SimpleThreadMemory) history(messageRepo *repositories.MessageRepository, threadID string, before int64, limit int, depth int) ([]*repositories.Message, error) {
+	own, err := messageRepo.ListRecentMessages(m.roomID, threadID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	// 房间主线没有父话题
+	if threadID == "" || len(own) >= limit || depth >= maxInheritDepth {
+		return own, nil
+	}
+
+	thread, err := messageRepo.GetThreadByID(threadID)
+	if err != nil {
+		logrus.Warnf("查询话题 %s 失败, 仅使用话题内消息: %v", threadID, err)
+		return own, nil
+	}
+	if messages.ThreadContextMode(thread.ContextMode) == messages.ThreadContextModeIsolated {
+		return own, nil
+	}
+
+	// 分叉点: 话题根消息的时间, 根消息缺失时使用话题创建时间
+	forkAt := thread.CreatedAt
+	if thread.RootMID != "" {
+		if root, err := messageRepo.GetMessageByID(thread.RootMID); err == nil {
+			forkAt = root.CreatedAt
+		}
+	}
+
+	inherited, err := m.history(messageRepo, thread.ParentThreadID, forkAt, limit-len(own), depth+1)
+	if err != nil {
+		return nil, err
+	}
+	return append(inherited, own...), nil
+}
+
 func (m *SimpleThreadMemory) Close() error {
 	// 对于简单实现，不需要特殊的关闭操作
 	return nil
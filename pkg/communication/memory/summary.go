@@ -0,0 +1,273 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/providers/llm"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/services"
+)
+
+const (
+	DefaultSummaryEvery      = 20
+	DefaultSummaryKeepRecent = 10
+
+	// maxSummaryBatch 单次摘要最多压缩的消息数, 积压较多时分多次追上
+	maxSummaryBatch = 100
+	summaryTimeout  = 2 * time.Minute
+)
+
+const summaryPrompt = `你负责维护一段对话的滚动摘要. 根据已有摘要和新的对话记录, 输出合并后的完整摘要.
+只输出 JSON, 不要输出其他内容, 格式如下:
+{"summary": "对话的整体概述", "key_points": ["人物、事实、偏好等要点"], "decisions": ["已确定的事项"], "open_questions": ["尚未解决的问题"]}
+使用对话所用的语言, 不要编造内容.`
+
+// ThreadSummarizer 在话题积累足够多的新消息后, 调用 LLM 将较早的消息压缩为结构化摘要
+// 摘要按话题存储, SimpleThreadMemory 检索时用它替代被摘要的消息
+type ThreadSummarizer struct {
+	metaStore      *repositories.MetaStore
+	messageService *services.MessageService
+	llmManager     *llm.ModelManager
+	every          int
+	keepRecent     int
+	rolling        bool // 为 false 时不在后台滚动摘要, 只在上下文超出模型窗口时按需摘要
+
+	locks sync.Map // room_id/thread_id -> *sync.Mutex, 同一话题同时只有一个摘要任务
+}
+
+func NewThreadSummarizer(metaStore *repositories.MetaStore, llmManager *llm.ModelManager, cfg config.SummaryConfig) *ThreadSummarizer {
+	every := cfg.Every
+	if every <= 0 {
+		every = DefaultSummaryEvery
+	}
+	keepRecent := cfg.KeepRecent
+	if keepRecent < 0 {
+		keepRecent = 0
+	} else if keepRecent == 0 {
+		keepRecent = DefaultSummaryKeepRecent
+	}
+	return &ThreadSummarizer{
+		metaStore:      metaStore,
+		messageService: services.NewMessageService(metaStore),
+		llmManager:     llmManager,
+		every:          every,
+		keepRecent:     keepRecent,
+		rolling:        cfg.Enabled,
+	}
+}
+
+func (s *ThreadSummarizer) lock(roomID string, threadID string) *sync.Mutex {
+	lock, _ := s.locks.LoadOrStore(roomID+"/"+threadID, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+// Trigger 在后台检查并更新话题摘要, 未启用滚动摘要或同一话题已有任务在执行时直接返回
+func (s *ThreadSummarizer) Trigger(roomID string, threadID string) {
+	if !s.rolling {
+		return
+	}
+	lock := s.lock(roomID, threadID)
+	if !lock.TryLock() {
+		return
+	}
+	go func() {
+		defer lock.Unlock()
+		ctx, cancel := context.WithTimeout(context.Background(), summaryTimeout)
+		defer cancel()
+		if err := s.summarize(ctx, roomID, threadID); err != nil {
+			logrus.Errorf("更新话题 %s/%s 的摘要失败: %v", roomID, threadID, err)
+		}
+	}()
+}
+
+// Summarize 上次摘要之后的新消息超过 every + keepRecent 条时, 将除最近 keepRecent 条以外的消息合并进摘要
+func (s *ThreadSummarizer) Summarize(ctx context.Context, roomID string, threadID string) error {
+	lock := s.lock(roomID, threadID)
+	lock.Lock()
+	defer lock.Unlock()
+	return s.summarize(ctx, roomID, threadID)
+}
+
+func (s *ThreadSummarizer) summarize(ctx context.Context, roomID string, threadID string) error {
+	previous, err := s.latest(roomID, threadID)
+	if err != nil {
+		return err
+	}
+	pending, err := s.metaStore.MessageRepo.ListMessagesAfter(roomID, threadID, coveredKey(previous), maxSummaryBatch+s.keepRecent)
+	if err != nil {
+		return fmt.Errorf("查询待摘要消息失败: %w", err)
+	}
+	if len(pending) < s.every+s.keepRecent {
+		return nil
+	}
+	_, err = s.merge(ctx, roomID, threadID, previous, pending[:len(pending)-s.keepRecent])
+	return err
+}
+
+// SummarizeThrough 将话题中直到 through (含) 的消息合并进摘要, 不受 every 和 keepRecent 的限制.
+// 构建上下文时超出模型窗口的消息由此压缩, 之后的轮次和后台任务都复用同一份摘要
+func (s *ThreadSummarizer) SummarizeThrough(ctx context.Context, roomID string, threadID string, through *messages.Message) (*SummaryChunk, error) {
+	lock := s.lock(roomID, threadID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, summaryTimeout)
+	defer cancel()
+
+	target := repositories.MessageKey{CreatedAt: through.CreatedAt, ID: through.ID}
+	summary, err := s.latest(roomID, threadID)
+	if err != nil {
+		return nil, err
+	}
+	// 积压较多时分批合并, 直到覆盖 through
+	for summary == nil || !summary.Covers(target.CreatedAt, target.ID) {
+		pending, err := s.metaStore.MessageRepo.ListMessagesAfter(roomID, threadID, coveredKey(summary), maxSummaryBatch)
+		if err != nil {
+			return nil, fmt.Errorf("查询待摘要消息失败: %w", err)
+		}
+		var batch []*repositories.Message
+		for _, message := range pending {
+			if !(repositories.MessageKey{CreatedAt: message.CreatedAt, ID: message.ID}).Before(target) {
+				break
+			}
+			batch = append(batch, message)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		if summary, err = s.merge(ctx, roomID, threadID, summary, batch); err != nil {
+			return nil, err
+		}
+	}
+	return summary, nil
+}
+
+// latest 话题最新的摘要, 不存在时返回 nil
+func (s *ThreadSummarizer) latest(roomID string, threadID string) (*SummaryChunk, error) {
+	previous, err := s.metaStore.MemoryRepo.GetLatestThreadSummary(roomID, threadID)
+	if err != nil {
+		return nil, fmt.Errorf("查询话题摘要失败: %w", err)
+	}
+	if previous == nil {
+		return nil, nil
+	}
+	return ThreadSummaryChunk(previous)
+}
+
+// merge 将 batch 合并进 previous 并保存为新的摘要
+func (s *ThreadSummarizer) merge(ctx context.Context, roomID string, threadID string, previous *SummaryChunk, batch []*repositories.Message) (*SummaryChunk, error) {
+	var (
+		messageCount    int
+		previousContent *ConversationSummary
+	)
+	if previous != nil {
+		messageCount = previous.MessageCount
+		previousContent = previous.Content
+	}
+
+	content, model, err := s.generate(ctx, previousContent, batch)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(content)
+	if err != nil {
+		return nil, fmt.Errorf("序列化摘要失败: %w", err)
+	}
+
+	last := batch[len(batch)-1]
+	summary := &repositories.ThreadSummary{
+		ID:            uuid.New().String(),
+		RoomID:        roomID,
+		ThreadID:      threadID,
+		Content:       string(data),
+		LastMessageID: last.ID,
+		CoveredUntil:  last.CreatedAt,
+		MessageCount:  messageCount + len(batch),
+		Model:         model,
+	}
+	if err := s.metaStore.MemoryRepo.InsertThreadSummary(summary); err != nil {
+		return nil, fmt.Errorf("保存话题摘要失败: %w", err)
+	}
+	logrus.Infof("话题 %s/%s 摘要已更新, 累计覆盖 %d 条消息", roomID, threadID, summary.MessageCount)
+	return ThreadSummaryChunk(summary)
+}
+
+// coveredKey 摘要覆盖到的最后一条消息的位置, 没有摘要时返回 nil
+func coveredKey(summary *SummaryChunk) *repositories.MessageKey {
+	if summary == nil {
+		return nil
+	}
+	return &repositories.MessageKey{CreatedAt: summary.CoveredUntil, ID: summary.LastMessageID}
+}
+
+func (s *ThreadSummarizer) generate(ctx context.Context, previous *ConversationSummary, batch []*repositories.Message) (*ConversationSummary, string, error) {
+	var input strings.Builder
+	if previous != nil {
+		data, _ := json.Marshal(previous)
+		fmt.Fprintf(&input, "已有摘要:\n%s\n\n", data)
+	}
+	input.WriteString("新的对话记录:\n")
+	for _, dbMsg := range batch {
+		message := s.messageService.Converter.DBToMessage(dbMsg)
+		text := ChunkText(&MessageChunk{ID: dbMsg.ID, Content: message})
+		if text == "" {
+			continue
+		}
+		role := "用户"
+		if message.MsgType == messages.MessageTypeAgent {
+			role = "助手"
+		}
+		fmt.Fprintf(&input, "%s: %s\n", role, text)
+	}
+
+	result, err := s.llmManager.Chat(ctx, []*llm.PromptMessage{
+		llm.NewSystemPromptMessage(summaryPrompt, "").PromptMessage,
+		{Role: llm.PromptMessageRoleUser, Content: input.String()},
+	}, map[string]interface{}{"temperature": 0.2}, nil, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("生成摘要失败: %w", err)
+	}
+
+	text := ""
+	if result.Message != nil && result.Message.PromptMessage != nil {
+		text, _ = result.Message.Content.(string)
+	}
+	return parseSummary(text), result.Model, nil
+}
+
+// parseSummary 解析模型输出的 JSON 摘要, 模型未按格式输出时整段文本作为概述
+func parseSummary(text string) *ConversationSummary {
+	text = strings.TrimSpace(text)
+	raw := strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(text, "```json"), "```"), "```")
+
+	var summary ConversationSummary
+	if err := json.Unmarshal([]byte(strings.TrimSpace(raw)), &summary); err != nil || summary.Summary == "" {
+		return &ConversationSummary{Summary: text}
+	}
+	return &summary
+}
+
+// ThreadSummaryChunk 将存储的摘要转换为 SummaryChunk
+func ThreadSummaryChunk(summary *repositories.ThreadSummary) (*SummaryChunk, error) {
+	var content ConversationSummary
+	if err := json.Unmarshal([]byte(summary.Content), &content); err != nil {
+		return nil, fmt.Errorf("解析话题摘要 %s 失败: %w", summary.ID, err)
+	}
+	return &SummaryChunk{
+		ID:            summary.ID,
+		ThreadID:      summary.ThreadID,
+		LastMessageID: summary.LastMessageID,
+		CoveredUntil:  summary.CoveredUntil,
+		MessageCount:  summary.MessageCount,
+		Content:       &content,
+	}, nil
+}
@@ -0,0 +1,142 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/providers/llm"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/services"
+)
+
+// insertThreadMessage 在 room-1/thread-1 中保存一条指定时间的文本消息
+func insertThreadMessage(t *testing.T, store *repositories.MetaStore, id string, createdAt int64) {
+	t.Helper()
+	message := &messages.Message{
+		ID:        id,
+		RoomID:    "room-1",
+		ThreadID:  "thread-1",
+		MsgType:   messages.MessageTypeText,
+		SenderID:  "did:plc:alice",
+		Content:   &messages.TextMessageContent{Text: "text of " + id},
+		CreatedAt: createdAt,
+	}
+	converter := services.NewMessageConverter(store.MessageRepo)
+	if err := store.MessageRepo.InsertMessage(converter.MessageToDB(message)); err != nil {
+		t.Fatalf("insert message %s: %v", id, err)
+	}
+}
+
+// newEchoSummarizer 使用回显输入的 fake 模型, 摘要内容即为被摘要的对话记录
+func newEchoSummarizer(t *testing.T, store *repositories.MetaStore, every int, keepRecent int) *ThreadSummarizer {
+	t.Helper()
+	provider := "memory-test-" + t.Name()
+	llm.RegisterProvider(provider, llm.NewFakeLLM())
+	socialConfig := &config.SocialConfig{}
+	socialConfig.Avatar.LLM = config.LLMConfig{Provider: provider, Model: "fake"}
+	return NewThreadSummarizer(store, llm.NewModelManager(socialConfig), config.SummaryConfig{Every: every, KeepRecent: keepRecent})
+}
+
+func TestListMessagesAfterBreaksTiesByID(t *testing.T) {
+	store := newTestMetaStore(t)
+	for i, id := range []string{"m3", "m1", "m2"} {
+		insertThreadMessage(t, store, id, 1000)
+		insertThreadMessage(t, store, fmt.Sprintf("n%d", i), 2000)
+	}
+	insertThreadMessage(t, store, "m0", 500)
+
+	ids := func(after *repositories.MessageKey) string {
+		list, err := store.MessageRepo.ListMessagesAfter("room-1", "thread-1", after, 10)
+		if err != nil {
+			t.Fatalf("list after %+v: %v", after, err)
+		}
+		var out []string
+		for _, message := range list {
+			out = append(out, message.ID)
+		}
+		return strings.Join(out, ",")
+	}
+
+	tests := []struct {
+		after *repositories.MessageKey
+		want  string
+	}{
+		{nil, "m0,m1,m2,m3,n0,n1,n2"},
+		{&repositories.MessageKey{CreatedAt: 500, ID: "m0"}, "m1,m2,m3,n0,n1,n2"},
+		// 同一毫秒内只跳过 id 不大于游标的消息
+		{&repositories.MessageKey{CreatedAt: 1000, ID: "m1"}, "m2,m3,n0,n1,n2"},
+		{&repositories.MessageKey{CreatedAt: 1000, ID: "m3"}, "n0,n1,n2"},
+		{&repositories.MessageKey{CreatedAt: 2000, ID: "n2"}, ""},
+	}
+	for _, tt := range tests {
+		if got := ids(tt.after); got != tt.want {
+			t.Errorf("after %+v = %q, want %q", tt.after, got, tt.want)
+		}
+	}
+}
+
+func TestThreadSummaryCoversMessagesInTheSameMillisecond(t *testing.T) {
+	store := newTestMetaStore(t)
+	summarizer := newEchoSummarizer(t, store, 2, 1)
+	ctx := context.Background()
+
+	// 同一毫秒内写入的消息, 旧的 created_at 游标会在第一次摘要后跳过剩余的消息
+	for _, id := range []string{"m1", "m2", "m3", "m4", "m5"} {
+		insertThreadMessage(t, store, id, 1000)
+	}
+	if err := summarizer.Summarize(ctx, "room-1", "thread-1"); err != nil {
+		t.Fatalf("summarize: %v", err)
+	}
+	first, err := store.MemoryRepo.GetLatestThreadSummary("room-1", "thread-1")
+	if err != nil || first == nil {
+		t.Fatalf("first summary = %v, %v", first, err)
+	}
+	if first.LastMessageID != "m4" || first.CoveredUntil != 1000 || first.MessageCount != 4 {
+		t.Fatalf("first summary covers %s@%d (%d messages), want m4@1000 (4)", first.LastMessageID, first.CoveredUntil, first.MessageCount)
+	}
+
+	// 待摘要的消息不足 every + keepRecent 条时不生成新摘要
+	insertThreadMessage(t, store, "m6", 1000)
+	if err := summarizer.Summarize(ctx, "room-1", "thread-1"); err != nil {
+		t.Fatalf("summarize: %v", err)
+	}
+	if latest, _ := store.MemoryRepo.GetLatestThreadSummary("room-1", "thread-1"); latest.ID != first.ID {
+		t.Fatalf("summary regenerated with only m5, m6 pending")
+	}
+
+	insertThreadMessage(t, store, "m7", 1000)
+	if err := summarizer.Summarize(ctx, "room-1", "thread-1"); err != nil {
+		t.Fatalf("summarize: %v", err)
+	}
+	second, _ := store.MemoryRepo.GetLatestThreadSummary("room-1", "thread-1")
+	if second.LastMessageID != "m6" || second.MessageCount != 6 {
+		t.Fatalf("second summary covers %s (%d messages), want m6 (6)", second.LastMessageID, second.MessageCount)
+	}
+	chunk, err := ThreadSummaryChunk(second)
+	if err != nil {
+		t.Fatalf("summary chunk: %v", err)
+	}
+	// 回显的输入包含已有摘要, 只检查新的对话记录部分
+	text := chunk.Content.Summary
+	if pending := text[strings.LastIndex(text, "新的对话记录:"):]; !strings.Contains(pending, "text of m5") || !strings.Contains(pending, "text of m6") || strings.Contains(pending, "text of m4") {
+		t.Errorf("second summary input = %q, want only m5 and m6 as new messages", text)
+	}
+
+	// 检索时摘要替代被覆盖的消息, 同一毫秒内未被覆盖的消息保留原文
+	chunks, err := NewSimpleThreadMemory(store.DB, "room-1", "thread-1").Retrieve(nil)
+	if err != nil {
+		t.Fatalf("retrieve: %v", err)
+	}
+	var got []string
+	for _, chunk := range chunks {
+		got = append(got, string(chunk.GetType())+":"+chunk.GetID())
+	}
+	want := "summary:" + second.ID + ",message:m7"
+	if strings.Join(got, ",") != want {
+		t.Errorf("retrieved %v, want %s", got, want)
+	}
+}
@@ -6,6 +6,14 @@ type InterruptEvent struct {
 
 func (i *InterruptEvent) isChatEventBody() {}
 
+// ResumeEvent 重连后续传回复, 补发序号大于 AfterSeq 的事件后继续接收
+type ResumeEvent struct {
+	ResponseID string `json:"responseId"` // 响应ID, 即 agentMessageId
+	AfterSeq   uint64 `json:"afterSeq"`   // 已收到的最后一个事件序号, 为 0 时从头补发
+}
+
+func (r *ResumeEvent) isChatEventBody() {}
+
 type CompletedEvent struct {
 	AgentMessage *AgentMessage `json:"agentMessage"`
 }
@@ -268,7 +276,8 @@ type ComputerCallCompletedEvent struct {
 func (e *ComputerCallCompletedEvent) isChatEventBody() {}
 
 type AudioDeltaEvent struct {
-	Delta string `json:"delta"` // 音频增量数据
+	AgentMessageID string `json:"agentMessageId"` // 响应ID
+	Delta          string `json:"delta"`          // 音频增量数据, base64 编码
 }
 
 func (e *AudioDeltaEvent) isChatEventBody() {}
@@ -280,13 +289,15 @@ type AudioDoneEvent struct {
 func (e *AudioDoneEvent) isChatEventBody() {}
 
 type AudioTranscriptDeltaEvent struct {
-	Delta string `json:"delta"` // 音频转录增量
+	AgentMessageID string `json:"agentMessageId"` // 响应ID
+	Delta          string `json:"delta"`          // 音频转录增量
 }
 
 func (e *AudioTranscriptDeltaEvent) isChatEventBody() {}
 
 type AudioTranscriptDoneEvent struct {
-	Transcript string `json:"transcript"` // 完整的音频转录
+	AgentMessageID string `json:"agentMessageId"` // 响应ID
+	Transcript     string `json:"transcript"`     // 完整的音频转录
 }
 
 func (e *AudioTranscriptDoneEvent) isChatEventBody() {}
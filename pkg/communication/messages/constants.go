@@ -18,6 +18,29 @@ const (
 	MessageTypeRTC         MessageType = 12 // 暂时不实现
 )
 
+type RoomType string
+
+const (
+	RoomTypeDirect RoomType = "direct"  // 单聊
+	RoomTypeGroup  RoomType = "group"   // 群聊
+	RoomTypeAIChat RoomType = "ai_chat" // 与 AI 的对话, 只有创建者一个成员
+)
+
+type RoomMemberStatus string
+
+const (
+	RoomMemberStatusRequest  RoomMemberStatus = "request"  // 已被邀请, 等待接受
+	RoomMemberStatusAccepted RoomMemberStatus = "accepted" // 正式成员
+	RoomMemberStatusLeft     RoomMemberStatus = "left"     // 已退出
+)
+
+type RoomMemberRole string
+
+const (
+	RoomMemberRoleOwner  RoomMemberRole = "owner"
+	RoomMemberRoleMember RoomMemberRole = "member"
+)
+
 type ChatEventType string
 
 const (
@@ -28,6 +51,7 @@ const (
 	EventTypeAgentMessageCreated                   ChatEventType = "agent_message.created"
 	EventTypeAgentMessageInProgress                ChatEventType = "agent_message.in_progress"
 	EventTypeAgentMessageInterrupt                 ChatEventType = "agent_message.interrupt"
+	EventTypeAgentMessageResume                    ChatEventType = "agent_message.resume"
 	EventTypeAgentMessageCompleted                 ChatEventType = "agent_message.completed"
 	EventTypeAgentMessageContentPartAdded          ChatEventType = "agent_message.content_part.added"
 	EventTypeAgentMessageContentPartDone           ChatEventType = "agent_message.content_part.done"
@@ -75,6 +99,23 @@ const (
 	EventTypeAgentMessageAudioDone            ChatEventType = "agent_message.audio.done"
 	EventTypeAgentMessageAudioTranscriptDelta ChatEventType = "agent_message.audio_transcript.delta"
 	EventTypeAgentMessageAudioTranscriptDone  ChatEventType = "agent_message.audio_transcript.done"
+
+	// 实时语音会话相关事件
+	EventTypeRealtimeSessionStart           ChatEventType = "realtime.session.start"
+	EventTypeRealtimeSessionStarted         ChatEventType = "realtime.session.started"
+	EventTypeRealtimeSessionStop            ChatEventType = "realtime.session.stop"
+	EventTypeRealtimeSessionStopped         ChatEventType = "realtime.session.stopped"
+	EventTypeRealtimeInputAudioAppend       ChatEventType = "realtime.input_audio.append"
+	EventTypeRealtimeInputAudioCommit       ChatEventType = "realtime.input_audio.commit"
+	EventTypeRealtimeSpeechStarted          ChatEventType = "realtime.input_audio.speech_started"
+	EventTypeRealtimeSpeechStopped          ChatEventType = "realtime.input_audio.speech_stopped"
+	EventTypeRealtimeTranscriptionCompleted ChatEventType = "realtime.input_audio.transcription.completed"
+
+	// 通知相关事件
+	EventTypeNotificationCreated ChatEventType = "notification.created"
+
+	// SSE 和长轮询会话相关事件
+	EventTypeSessionCreated ChatEventType = "session.created"
 )
 
 type RoleType string
@@ -107,6 +148,7 @@ type IncompleteReason string
 const (
 	IncompleteReasonMaxOutputTokens IncompleteReason = "max_output_tokens"
 	IncompleteReasonContentFilter   IncompleteReason = "content_filter"
+	IncompleteReasonMaxSteps        IncompleteReason = "max_steps"
 )
 
 type ToolCallStatus string
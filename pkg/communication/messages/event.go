@@ -6,9 +6,10 @@ import (
 )
 
 type ChatEvent struct {
-	EventID   string        `json:"eventId"`   // 事件ID
-	EventType ChatEventType `json:"eventType"` // 事件类型
-	Event     ChatEventBody `json:"event"`     // 事件内容
+	EventID   string        `json:"eventId"`       // 事件ID
+	EventType ChatEventType `json:"eventType"`     // 事件类型
+	Event     ChatEventBody `json:"event"`         // 事件内容
+	Seq       uint64        `json:"seq,omitempty"` // AI 回复事件在该回复内的序号, 从 1 开始递增, 用于断线续传
 }
 
 func (c *ChatEvent) ID() string {
@@ -47,6 +48,8 @@ func (e *ChatEvent) UnmarshalJSON(data []byte) error {
 		eventBody = &MessageReceivedEvent{}
 	case EventTypeAgentMessageInterrupt:
 		eventBody = &InterruptEvent{}
+	case EventTypeAgentMessageResume:
+		eventBody = &ResumeEvent{}
 	case EventTypeAgentMessageCompleted:
 		eventBody = &CompletedEvent{}
 	case EventTypeAgentMessageContentPartAdded:
@@ -121,6 +124,28 @@ func (e *ChatEvent) UnmarshalJSON(data []byte) error {
 		eventBody = &AudioTranscriptDeltaEvent{}
 	case EventTypeAgentMessageAudioTranscriptDone:
 		eventBody = &AudioTranscriptDoneEvent{}
+	case EventTypeRealtimeSessionStart:
+		eventBody = &RealtimeSessionStartEvent{}
+	case EventTypeRealtimeSessionStarted:
+		eventBody = &RealtimeSessionStartedEvent{}
+	case EventTypeRealtimeSessionStop:
+		eventBody = &RealtimeSessionStopEvent{}
+	case EventTypeRealtimeSessionStopped:
+		eventBody = &RealtimeSessionStoppedEvent{}
+	case EventTypeRealtimeInputAudioAppend:
+		eventBody = &InputAudioAppendEvent{}
+	case EventTypeRealtimeInputAudioCommit:
+		eventBody = &InputAudioCommitEvent{}
+	case EventTypeRealtimeSpeechStarted:
+		eventBody = &SpeechStartedEvent{}
+	case EventTypeRealtimeSpeechStopped:
+		eventBody = &SpeechStoppedEvent{}
+	case EventTypeRealtimeTranscriptionCompleted:
+		eventBody = &TranscriptionCompletedEvent{}
+	case EventTypeNotificationCreated:
+		eventBody = &NotificationCreatedEvent{}
+	case EventTypeSessionCreated:
+		eventBody = &SessionCreatedEvent{}
 	default:
 		return fmt.Errorf("未知的事件类型: %s", e.EventType)
 	}
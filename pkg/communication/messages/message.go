@@ -11,6 +11,8 @@ type Room struct {
 	Type         string   `json:"type"`         // 房间类型 // 单聊、群聊、ai 对话...
 	LastMID      string   `json:"lastMid"`      // 最后一条消息ID
 	Participants []string `json:"participants"` // 参与者
+	CreatorID    string   `json:"creatorId"`    // 创建者
+	Archived     bool     `json:"archived"`     // 是否已归档, 归档后不能发送消息
 	CreatedAt    int64    `json:"createdAt"`    // 创建时间
 	UpdatedAt    int64    `json:"updatedAt"`    // 更新时间
 	Deleted      bool     `json:"deleted"`      // 是否被删除
@@ -26,7 +28,10 @@ type UserRoomStatus struct { // 归属某个具体的用户, 基本是一个 roo
 	UnreadCount  int32    `json:"unreadCount"`  // 未读消息数
 	Muted        bool     `json:"muted"`        // 是否被静音
 	UserID       string   `json:"userId"`       // 用户ID
-	Status       string   `json:"status"`       // 状态 // request, accepted
+	Status       string   `json:"status"`       // 状态 // request, accepted, left
+	Role         string   `json:"role"`         // 角色 // owner, member
+	Archived     bool     `json:"archived"`     // 房间是否已归档
+	PDSMirror    bool     `json:"pdsMirror"`    // 是否将房间内自己的消息同步到 PDS
 	CreatedAt    int64    `json:"createdAt"`    // 创建时间
 	UpdatedAt    int64    `json:"updatedAt"`    // 更新时间
 	Deleted      bool     `json:"deleted"`      // 是否被删除
@@ -0,0 +1,11 @@
+package messages
+
+import "github.com/zhongshangwu/avatarai-social/types"
+
+// NotificationCreatedEvent 服务端推送的新通知, 附带推送时的未读数
+type NotificationCreatedEvent struct {
+	Notification *types.Notification `json:"notification"`
+	UnreadCount  int64               `json:"unreadCount"`
+}
+
+func (n *NotificationCreatedEvent) isChatEventBody() {}
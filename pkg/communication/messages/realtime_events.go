@@ -0,0 +1,66 @@
+package messages
+
+// RealtimeSessionStartEvent 在当前连接上开启实时语音会话, 识别出的语音作为文本消息发送到房间
+type RealtimeSessionStartEvent struct {
+	RoomID            string `json:"roomId"`                      // 房间ID
+	ThreadID          string `json:"threadId,omitempty"`          // 话题ID
+	ReceiverID        string `json:"receiverId"`                  // 接收者ID, 即回复的 Aster DID
+	InputAudioFormat  string `json:"inputAudioFormat,omitempty"`  // 输入音频编码: pcm16, opus, 默认 pcm16
+	InputSampleRate   int    `json:"inputSampleRate,omitempty"`   // 输入采样率, 默认 16000
+	OutputAudioFormat string `json:"outputAudioFormat,omitempty"` // 输出音频编码: pcm16, opus, 默认 pcm16
+	OutputSampleRate  int    `json:"outputSampleRate,omitempty"`  // 输出采样率, 默认 24000
+	TurnDetection     string `json:"turnDetection,omitempty"`     // 说话结束的判断方式: server_vad, none, 默认 server_vad
+}
+
+func (e *RealtimeSessionStartEvent) isChatEventBody() {}
+
+type RealtimeSessionStartedEvent struct {
+	SessionID         string `json:"sessionId"`         // 会话ID
+	InputAudioFormat  string `json:"inputAudioFormat"`  // 输入音频编码
+	InputSampleRate   int    `json:"inputSampleRate"`   // 输入采样率
+	OutputAudioFormat string `json:"outputAudioFormat"` // 输出音频编码
+	OutputSampleRate  int    `json:"outputSampleRate"`  // 输出采样率
+	TurnDetection     string `json:"turnDetection"`     // 实际生效的判断方式, opus 输入不支持 server_vad
+}
+
+func (e *RealtimeSessionStartedEvent) isChatEventBody() {}
+
+type RealtimeSessionStopEvent struct{}
+
+func (e *RealtimeSessionStopEvent) isChatEventBody() {}
+
+type RealtimeSessionStoppedEvent struct {
+	SessionID string `json:"sessionId"` // 会话ID
+}
+
+func (e *RealtimeSessionStoppedEvent) isChatEventBody() {}
+
+// InputAudioAppendEvent 追加一段输入音频. 也可以直接发送 WebSocket 二进制帧, 效果相同
+type InputAudioAppendEvent struct {
+	Audio []byte `json:"audio"` // 音频数据, JSON 中为 base64 编码
+}
+
+func (e *InputAudioAppendEvent) isChatEventBody() {}
+
+// InputAudioCommitEvent 结束当前这段语音, 关闭服务端语音活动检测时由客户端发送
+type InputAudioCommitEvent struct{}
+
+func (e *InputAudioCommitEvent) isChatEventBody() {}
+
+type SpeechStartedEvent struct {
+	AudioStartMs int64 `json:"audioStartMs"` // 从会话开始计算的音频时间
+}
+
+func (e *SpeechStartedEvent) isChatEventBody() {}
+
+type SpeechStoppedEvent struct {
+	AudioEndMs int64 `json:"audioEndMs"` // 从会话开始计算的音频时间
+}
+
+func (e *SpeechStoppedEvent) isChatEventBody() {}
+
+type TranscriptionCompletedEvent struct {
+	Transcript string `json:"transcript"` // 识别出的文本
+}
+
+func (e *TranscriptionCompletedEvent) isChatEventBody() {}
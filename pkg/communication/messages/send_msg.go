@@ -243,8 +243,9 @@ func (p *PostMsgBody) UnmarshalJSON(data []byte) error {
 }
 
 type MessageSentEvent struct {
-	MessageID string `json:"messageId"` // 消息ID
-	EventID   string `json:"eventId"`   // 原始发送消息的事件ID
+	MessageID string   `json:"messageId"`         // 消息ID
+	EventID   string   `json:"eventId"`           // 原始发送消息的事件ID
+	Message   *Message `json:"message,omitempty"` // 完整消息, 用于同一用户的其他设备和房间内其他成员展示
 }
 
 func (s *MessageSentEvent) isChatEventBody() {}
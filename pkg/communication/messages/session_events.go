@@ -0,0 +1,8 @@
+package messages
+
+// SessionCreatedEvent SSE 和长轮询会话的第一个事件, 客户端发送消息时需要带上会话ID
+type SessionCreatedEvent struct {
+	SessionID string `json:"sessionId"` // 会话ID
+}
+
+func (e *SessionCreatedEvent) isChatEventBody() {}
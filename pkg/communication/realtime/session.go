@@ -1 +1,336 @@
 package realtime
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/providers/speech"
+)
+
+const (
+	TurnDetectionServerVAD = "server_vad"
+	TurnDetectionNone      = "none"
+)
+
+const (
+	frameBufferSize     = 256
+	utteranceBufferSize = 4
+	maxUtteranceBytes   = 4 << 20 // 单段语音的上限, 超过后直接提交识别
+)
+
+var (
+	ErrSessionClosed   = errors.New("实时语音会话已关闭")
+	ErrAudioBufferFull = errors.New("音频缓冲区已满")
+	// ErrNoReply 消息已发送但不会触发 AI 回复, 例如等待人工审核
+	ErrNoReply = errors.New("消息不会触发 AI 回复")
+)
+
+// Responder 语音会话的对话阶段: 将识别出的文本作为用户消息发送, 交给 ChatRunner 生成回复.
+// 回复事件需要交给 turn.HandleEvent, 由会话按句合成语音
+type Responder interface {
+	Respond(ctx context.Context, transcript string, turn *Turn) error
+	// Interrupt 打断正在生成的回复, 与客户端发送 agent_message.interrupt 走同一条路径
+	Interrupt(ctx context.Context, agentMessageID string) error
+}
+
+// Emitter 将会话产生的事件写入连接的输出流
+type Emitter func(ctx context.Context, event *messages.ChatEvent) error
+
+type SessionConfig struct {
+	InputFormat  speech.AudioFormat
+	OutputFormat speech.AudioFormat
+	VAD          *config.VADConfig // 为空时不做服务端语音活动检测, 由客户端提交每段语音
+}
+
+// Session 实时语音会话: 输入音频 → 语音活动检测 → STT → Responder → 按句 TTS → 输出音频.
+// 用户在 AI 播报过程中开始说话时停止播报, 并打断仍在生成的回复
+type Session struct {
+	ID string
+
+	config    SessionConfig
+	stt       speech.SpeechToText
+	tts       speech.TextToSpeech
+	vad       VoiceActivityDetector
+	responder Responder
+	emit      Emitter
+
+	ctx        context.Context
+	cancel     context.CancelFunc
+	frames     chan []byte
+	commits    chan struct{}
+	utterances chan []byte
+	wg         sync.WaitGroup
+
+	mu   sync.Mutex
+	turn *Turn // 正在播报的回复
+}
+
+func NewSession(
+	config SessionConfig,
+	stt speech.SpeechToText,
+	tts speech.TextToSpeech,
+	responder Responder,
+	emit Emitter,
+) *Session {
+	session := &Session{
+		ID:         uuid.New().String(),
+		config:     config,
+		stt:        stt,
+		tts:        tts,
+		responder:  responder,
+		emit:       emit,
+		frames:     make(chan []byte, frameBufferSize),
+		commits:    make(chan struct{}, 1),
+		utterances: make(chan []byte, utteranceBufferSize),
+	}
+	if config.VAD != nil {
+		session.vad = NewEnergyVAD(*config.VAD, config.InputFormat)
+	}
+	return session
+}
+
+// TurnDetection 实际生效的说话结束判断方式
+func (s *Session) TurnDetection() string {
+	if s.vad != nil {
+		return TurnDetectionServerVAD
+	}
+	return TurnDetectionNone
+}
+
+func (s *Session) Start(ctx context.Context) {
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.wg.Add(2)
+	go s.listen()
+	go s.respond()
+}
+
+// Close 停止播报并结束会话, 已经在生成的文本回复不受影响
+func (s *Session) Close() {
+	s.cancel()
+	s.wg.Wait()
+	s.stopTurn(false)
+}
+
+// AppendAudio 追加一段输入音频, 不阻塞调用方
+func (s *Session) AppendAudio(frame []byte) error {
+	if s.ctx.Err() != nil {
+		return ErrSessionClosed
+	}
+	select {
+	case s.frames <- frame:
+		return nil
+	default:
+		return ErrAudioBufferFull
+	}
+}
+
+// Commit 结束当前这段语音并提交识别
+func (s *Session) Commit() error {
+	if s.ctx.Err() != nil {
+		return ErrSessionClosed
+	}
+	select {
+	case s.commits <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// StopSpeaking 停止播报当前回复, 客户端已经主动打断时使用
+func (s *Session) StopSpeaking() {
+	s.stopTurn(false)
+}
+
+func (s *Session) listen() {
+	defer s.wg.Done()
+	defer close(s.utterances)
+
+	var buffer []byte
+	var received int
+	speaking := false
+	prefixBytes := 0
+	if s.config.VAD != nil {
+		prefixBytes = int(s.config.VAD.PrefixPadding.Milliseconds()) * s.config.InputFormat.BytesPerSecond() / 1000 &^ 1
+	}
+
+	handleFrame := func(frame []byte) {
+		received += len(frame)
+		buffer = append(buffer, frame...)
+		if s.vad == nil {
+			if len(buffer) >= maxUtteranceBytes {
+				s.submit(buffer)
+				buffer = nil
+			}
+			return
+		}
+
+		switch s.vad.Detect(frame) {
+		case VADSpeechStarted:
+			speaking = true
+			s.sendEvent(messages.EventTypeRealtimeSpeechStarted, &messages.SpeechStartedEvent{
+				AudioStartMs: s.audioMs(received - len(buffer)),
+			})
+			s.stopTurn(true)
+		case VADSpeechStopped:
+			speaking = false
+			s.sendEvent(messages.EventTypeRealtimeSpeechStopped, &messages.SpeechStoppedEvent{
+				AudioEndMs: s.audioMs(received),
+			})
+			s.submit(buffer)
+			buffer = nil
+		}
+
+		// 未在说话时只保留尚未确认的语音和之前的一小段音频, 作为下一段语音的开头
+		if keep := prefixBytes + s.vad.Pending(); !speaking && len(buffer) > keep {
+			buffer = append(buffer[:0], buffer[len(buffer)-keep:]...)
+		}
+		if speaking && len(buffer) >= maxUtteranceBytes {
+			s.submit(buffer)
+			buffer = nil
+		}
+	}
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case frame := <-s.frames:
+			handleFrame(frame)
+		case <-s.commits:
+			// 提交之前追加的音频可能还在队列中, 先处理完再提交
+			for drained := false; !drained; {
+				select {
+				case frame := <-s.frames:
+					handleFrame(frame)
+				default:
+					drained = true
+				}
+			}
+			if s.vad != nil {
+				if !speaking {
+					continue
+				}
+				speaking = false
+				s.vad.Reset()
+			}
+			if len(buffer) == 0 {
+				continue
+			}
+			s.stopTurn(true)
+			s.submit(buffer)
+			buffer = nil
+		}
+	}
+}
+
+func (s *Session) submit(audio []byte) {
+	select {
+	case s.utterances <- audio:
+	case <-s.ctx.Done():
+	}
+}
+
+func (s *Session) respond() {
+	defer s.wg.Done()
+
+	for audio := range s.utterances {
+		transcript, err := s.stt.Transcribe(s.ctx, audio, s.config.InputFormat)
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return
+			}
+			logrus.Errorf("实时语音会话 %s 语音识别失败: %v", s.ID, err)
+			s.sendError("transcription_failed", "语音识别失败")
+			continue
+		}
+		transcript = strings.TrimSpace(transcript)
+		if transcript == "" {
+			continue
+		}
+		s.sendEvent(messages.EventTypeRealtimeTranscriptionCompleted, &messages.TranscriptionCompletedEvent{
+			Transcript: transcript,
+		})
+
+		turn := s.startTurn()
+		if err := s.responder.Respond(s.ctx, transcript, turn); err != nil {
+			turn.Finish()
+			if !errors.Is(err, ErrNoReply) {
+				logrus.Errorf("实时语音会话 %s 发送消息失败: %v", s.ID, err)
+			}
+		}
+	}
+}
+
+func (s *Session) startTurn() *Turn {
+	s.stopTurn(true)
+
+	turn := newTurn(s)
+	s.mu.Lock()
+	s.turn = turn
+	s.mu.Unlock()
+	go turn.speak()
+	return turn
+}
+
+// stopTurn 停止播报当前回复, interrupt 为 true 时同时打断仍在生成的回复 (barge-in)
+func (s *Session) stopTurn(interrupt bool) {
+	s.mu.Lock()
+	turn := s.turn
+	s.turn = nil
+	s.mu.Unlock()
+	if turn == nil {
+		return
+	}
+
+	agentMessageID, generating := turn.stop(interrupt)
+	if interrupt && generating && agentMessageID != "" {
+		s.interrupt(agentMessageID)
+	}
+}
+
+func (s *Session) clearTurn(turn *Turn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.turn == turn {
+		s.turn = nil
+	}
+}
+
+func (s *Session) interrupt(agentMessageID string) {
+	logrus.Infof("实时语音会话 %s 用户开始说话, 打断回复 %s", s.ID, agentMessageID)
+	if err := s.responder.Interrupt(context.WithoutCancel(s.ctx), agentMessageID); err != nil {
+		logrus.Warnf("打断回复 %s 失败: %v", agentMessageID, err)
+	}
+}
+
+func (s *Session) audioMs(bytes int) int64 {
+	bytesPerSecond := s.config.InputFormat.BytesPerSecond()
+	if bytesPerSecond == 0 || bytes < 0 {
+		return 0
+	}
+	return int64(bytes) * 1000 / int64(bytesPerSecond)
+}
+
+func (s *Session) sendEvent(eventType messages.ChatEventType, body messages.ChatEventBody) {
+	event := &messages.ChatEvent{
+		EventID:   uuid.New().String(),
+		EventType: eventType,
+		Event:     body,
+	}
+	if err := s.emit(context.WithoutCancel(s.ctx), event); err != nil {
+		logrus.Errorf("实时语音会话 %s 发送事件 %s 失败: %v", s.ID, eventType, err)
+	}
+}
+
+func (s *Session) sendError(code string, message string) {
+	s.sendEvent(messages.EventTypeError, &messages.ErrorEvent{
+		Code:    &code,
+		Message: message,
+	})
+}
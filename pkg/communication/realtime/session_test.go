@@ -0,0 +1,252 @@
+package realtime
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/providers/speech"
+)
+
+const frameMs = 20
+
+// pcmFrame 生成 20 毫秒的 PCM16 帧, amplitude 为 0 时是静音
+func pcmFrame(amplitude int16) []byte {
+	samples := speech.DefaultInputFormat.SampleRate * frameMs / 1000
+	frame := make([]byte, samples*2)
+	for i := 0; i < samples; i++ {
+		binary.LittleEndian.PutUint16(frame[i*2:], uint16(amplitude))
+	}
+	return frame
+}
+
+// fakeResponder 每轮回复分配一个 ID, 推送 Created 和文本增量后不结束回复, 模拟仍在生成中的 AI 回复
+type fakeResponder struct {
+	mu          sync.Mutex
+	replies     map[string]string // 识别文本 -> 回复文本
+	transcripts []string
+	interrupted []string
+}
+
+func (r *fakeResponder) Respond(ctx context.Context, transcript string, turn *Turn) error {
+	r.mu.Lock()
+	r.transcripts = append(r.transcripts, transcript)
+	agentMessageID := fmt.Sprintf("am%d", len(r.transcripts))
+	reply := r.replies[transcript]
+	r.mu.Unlock()
+
+	turn.HandleEvent(&messages.ChatEvent{
+		EventType: messages.EventTypeAgentMessageCreated,
+		Event:     &messages.CreatedEvent{AgentMessage: &messages.AgentMessage{ID: agentMessageID}},
+	})
+	turn.HandleEvent(&messages.ChatEvent{
+		EventType: messages.EventTypeAgentMessageOutputTextDelta,
+		Event:     &messages.TextDeltaEvent{Delta: reply},
+	})
+	return nil
+}
+
+func (r *fakeResponder) Interrupt(ctx context.Context, agentMessageID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interrupted = append(r.interrupted, agentMessageID)
+	return nil
+}
+
+func (r *fakeResponder) interrupts() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.interrupted...)
+}
+
+// eventRecorder 记录会话输出的事件. 第一个 audio.delta 会阻塞到 release 关闭, 让测试在播报过程中插话
+type eventRecorder struct {
+	events     chan *messages.ChatEvent
+	firstAudio chan struct{}
+	release    chan struct{}
+	once       sync.Once
+}
+
+func newEventRecorder() *eventRecorder {
+	return &eventRecorder{
+		events:     make(chan *messages.ChatEvent, 1024),
+		firstAudio: make(chan struct{}),
+		release:    make(chan struct{}),
+	}
+}
+
+func (r *eventRecorder) emit(ctx context.Context, event *messages.ChatEvent) error {
+	r.events <- event
+	if event.EventType == messages.EventTypeAgentMessageAudioDelta {
+		r.once.Do(func() {
+			close(r.firstAudio)
+			<-r.release
+		})
+	}
+	return nil
+}
+
+// waitFor 读取事件直到出现指定类型, 返回期间收到的所有事件
+func (r *eventRecorder) waitFor(t *testing.T, eventType messages.ChatEventType) []*messages.ChatEvent {
+	t.Helper()
+	var seen []*messages.ChatEvent
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case event := <-r.events:
+			seen = append(seen, event)
+			if event.EventType == eventType {
+				return seen
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s, got %d events", eventType, len(seen))
+			return nil
+		}
+	}
+}
+
+func countEvents(events []*messages.ChatEvent, eventType messages.ChatEventType) int {
+	count := 0
+	for _, event := range events {
+		if event.EventType == eventType {
+			count++
+		}
+	}
+	return count
+}
+
+func appendFrames(t *testing.T, session *Session, frame []byte, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := session.AppendAudio(frame); err != nil {
+			t.Fatalf("append audio: %v", err)
+		}
+	}
+}
+
+func TestSessionBargeIn(t *testing.T) {
+	responder := &fakeResponder{replies: map[string]string{"讲个故事": "从前有座山。山里有座庙。"}}
+	recorder := newEventRecorder()
+	session := NewSession(SessionConfig{
+		InputFormat:  speech.DefaultInputFormat,
+		OutputFormat: speech.DefaultOutputFormat,
+		VAD: &config.VADConfig{
+			Threshold:         0.1,
+			SilenceDuration:   100 * time.Millisecond,
+			MinSpeechDuration: 40 * time.Millisecond,
+			PrefixPadding:     20 * time.Millisecond,
+		},
+	}, speech.NewFakeSpeechToText("讲个故事"), speech.NewFakeTextToSpeech(), responder, recorder.emit)
+	if session.TurnDetection() != TurnDetectionServerVAD {
+		t.Fatalf("turn detection = %s, want server_vad", session.TurnDetection())
+	}
+	session.Start(context.Background())
+
+	loud, silence := pcmFrame(16000), pcmFrame(0)
+
+	// 第一段语音: 开始说话, 静音后说完, 识别结果交给 Responder
+	appendFrames(t, session, silence, 3)
+	appendFrames(t, session, loud, 5)
+	events := recorder.waitFor(t, messages.EventTypeRealtimeSpeechStarted)
+	started := events[len(events)-1].Event.(*messages.SpeechStartedEvent)
+	if started.AudioStartMs != 2*frameMs {
+		t.Errorf("speech started at %dms, want the prefix padding before the first loud frame", started.AudioStartMs)
+	}
+	appendFrames(t, session, silence, 6)
+	recorder.waitFor(t, messages.EventTypeRealtimeSpeechStopped)
+	events = recorder.waitFor(t, messages.EventTypeRealtimeTranscriptionCompleted)
+	if transcript := events[len(events)-1].Event.(*messages.TranscriptionCompletedEvent).Transcript; transcript != "讲个故事" {
+		t.Errorf("transcript = %q", transcript)
+	}
+
+	// 第一句开始播报后用户插话: 停止播报并打断仍在生成的回复
+	select {
+	case <-recorder.firstAudio:
+	case <-time.After(2 * time.Second):
+		t.Fatal("TTS did not start speaking")
+	}
+	appendFrames(t, session, loud, 3)
+	recorder.waitFor(t, messages.EventTypeRealtimeSpeechStarted)
+	deadline := time.Now().Add(2 * time.Second)
+	for len(responder.interrupts()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("reply was not interrupted on barge-in")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(recorder.release)
+
+	events = recorder.waitFor(t, messages.EventTypeAgentMessageAudioTranscriptDone)
+	if n := countEvents(events, messages.EventTypeAgentMessageAudioDelta); n != 0 {
+		t.Errorf("%d more audio chunks after barge-in, want synthesis stopped", n)
+	}
+	done := events[len(events)-1].Event.(*messages.AudioTranscriptDoneEvent)
+	if done.AgentMessageID != "am1" || done.Transcript != "从前有座山。" {
+		t.Errorf("transcript done = %+v, want only the first sentence of am1", done)
+	}
+	recorder.waitFor(t, messages.EventTypeAgentMessageAudioDone)
+
+	// 第二段语音得到新的回复, 播报过程中关闭会话: 停止播报但不打断回复
+	appendFrames(t, session, silence, 6)
+	recorder.waitFor(t, messages.EventTypeRealtimeSpeechStopped)
+	recorder.waitFor(t, messages.EventTypeRealtimeTranscriptionCompleted)
+	events = recorder.waitFor(t, messages.EventTypeAgentMessageAudioTranscriptDelta)
+	if delta := events[len(events)-1].Event.(*messages.AudioTranscriptDeltaEvent); delta.AgentMessageID != "am2" {
+		t.Errorf("speaking %s, want am2", delta.AgentMessageID)
+	}
+
+	session.Close()
+	events = recorder.waitFor(t, messages.EventTypeAgentMessageAudioDone)
+	if id := events[len(events)-1].Event.(*messages.AudioDoneEvent).AgentMessageID; id != "am2" {
+		t.Errorf("audio done for %s, want am2", id)
+	}
+	if got := responder.interrupts(); len(got) != 1 || got[0] != "am1" {
+		t.Errorf("interrupted %v, want only am1", got)
+	}
+	if err := session.AppendAudio(loud); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("append after close: err = %v, want ErrSessionClosed", err)
+	}
+	if err := session.Commit(); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("commit after close: err = %v, want ErrSessionClosed", err)
+	}
+	session.mu.Lock()
+	turn := session.turn
+	session.mu.Unlock()
+	if turn != nil {
+		t.Error("session still holds a turn after close")
+	}
+}
+
+func TestSessionManualCommit(t *testing.T) {
+	responder := &fakeResponder{}
+	recorder := newEventRecorder()
+	close(recorder.release)
+	session := NewSession(SessionConfig{
+		InputFormat:  speech.DefaultInputFormat,
+		OutputFormat: speech.DefaultOutputFormat,
+	}, speech.NewFakeSpeechToText(""), speech.NewFakeTextToSpeech(), responder, recorder.emit)
+	if session.TurnDetection() != TurnDetectionNone {
+		t.Fatalf("turn detection = %s, want none", session.TurnDetection())
+	}
+	session.Start(context.Background())
+	defer session.Close()
+
+	// 关闭语音活动检测时, 整段音频由客户端提交
+	appendFrames(t, session, pcmFrame(0), 25)
+	if err := session.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	events := recorder.waitFor(t, messages.EventTypeRealtimeTranscriptionCompleted)
+	if n := countEvents(events, messages.EventTypeRealtimeSpeechStarted); n != 0 {
+		t.Errorf("%d speech_started events without server VAD", n)
+	}
+	if transcript := events[len(events)-1].Event.(*messages.TranscriptionCompletedEvent).Transcript; transcript != "(500 毫秒语音)" {
+		t.Errorf("transcript = %q, want the whole committed audio", transcript)
+	}
+}
@@ -0,0 +1,207 @@
+package realtime
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+)
+
+// Turn 一轮语音回复: 收集 AI 回复的文本增量, 按句交给 TTS, 输出 audio.delta 和 audio_transcript.delta
+type Turn struct {
+	session *Session
+	ctx     context.Context
+	cancel  context.CancelFunc
+	notify  chan struct{}
+
+	mu             sync.Mutex
+	agentMessageID string
+	pending        string   // 尚未断句的文本
+	segments       []string // 等待合成的句子
+	finished       bool     // 回复已结束, 不会再有新的文本
+	stopped        bool     // 播报被打断
+}
+
+func newTurn(session *Session) *Turn {
+	ctx, cancel := context.WithCancel(session.ctx)
+	return &Turn{
+		session: session,
+		ctx:     ctx,
+		cancel:  cancel,
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// HandleEvent 接收 AI 回复的事件, 由 ChatActor 在处理回复流时调用, 不阻塞
+func (t *Turn) HandleEvent(event *messages.ChatEvent) {
+	switch body := event.Event.(type) {
+	case *messages.CreatedEvent:
+		t.mu.Lock()
+		t.agentMessageID = body.AgentMessage.ID
+		stopped := t.stopped
+		t.mu.Unlock()
+		// 回复创建之前用户已经开始说话, 此时才能打断
+		if stopped {
+			t.session.interrupt(body.AgentMessage.ID)
+		}
+	case *messages.TextDeltaEvent:
+		t.appendText(body.Delta)
+	case *messages.CompletedEvent, *messages.FailedEvent, *messages.IncompleteEvent:
+		t.Finish()
+	}
+}
+
+// Finish 回复结束, 剩余的文本作为最后一句合成
+func (t *Turn) Finish() {
+	t.mu.Lock()
+	if t.finished {
+		t.mu.Unlock()
+		return
+	}
+	t.finished = true
+	if segment := strings.TrimSpace(t.pending); segment != "" {
+		t.segments = append(t.segments, segment)
+	}
+	t.pending = ""
+	t.mu.Unlock()
+	t.signal()
+}
+
+func (t *Turn) appendText(delta string) {
+	t.mu.Lock()
+	if t.finished || t.stopped {
+		t.mu.Unlock()
+		return
+	}
+	t.pending += delta
+	var segments []string
+	segments, t.pending = splitSentences(t.pending)
+	t.segments = append(t.segments, segments...)
+	t.mu.Unlock()
+	if len(segments) > 0 {
+		t.signal()
+	}
+}
+
+// stop 停止播报, 返回回复 ID 以及回复是否仍在生成
+func (t *Turn) stop(interrupted bool) (string, bool) {
+	t.mu.Lock()
+	t.stopped = t.stopped || interrupted
+	agentMessageID := t.agentMessageID
+	generating := !t.finished
+	t.finished = true
+	t.segments = nil
+	t.mu.Unlock()
+	t.cancel()
+	return agentMessageID, generating
+}
+
+func (t *Turn) signal() {
+	select {
+	case t.notify <- struct{}{}:
+	default:
+	}
+}
+
+// next 取出下一句, 回复结束且没有剩余句子或者被打断时返回 false
+func (t *Turn) next() (string, bool) {
+	for {
+		t.mu.Lock()
+		if len(t.segments) > 0 {
+			segment := t.segments[0]
+			t.segments = t.segments[1:]
+			t.mu.Unlock()
+			return segment, true
+		}
+		finished := t.finished
+		t.mu.Unlock()
+		if finished || t.ctx.Err() != nil {
+			return "", false
+		}
+
+		select {
+		case <-t.notify:
+		case <-t.ctx.Done():
+		}
+	}
+}
+
+func (t *Turn) speak() {
+	var transcript strings.Builder
+	for {
+		segment, ok := t.next()
+		if !ok || t.ctx.Err() != nil {
+			break
+		}
+
+		agentMessageID := t.messageID()
+		t.session.sendEvent(messages.EventTypeAgentMessageAudioTranscriptDelta, &messages.AudioTranscriptDeltaEvent{
+			AgentMessageID: agentMessageID,
+			Delta:          segment,
+		})
+		err := t.session.tts.Synthesize(t.ctx, segment, t.session.config.OutputFormat, func(chunk []byte) error {
+			t.session.sendEvent(messages.EventTypeAgentMessageAudioDelta, &messages.AudioDeltaEvent{
+				AgentMessageID: agentMessageID,
+				Delta:          base64.StdEncoding.EncodeToString(chunk),
+			})
+			return nil
+		})
+		transcript.WriteString(segment)
+		if err != nil {
+			if t.ctx.Err() == nil {
+				logrus.Errorf("实时语音会话 %s 语音合成失败: %v", t.session.ID, err)
+				t.session.sendError("speech_synthesis_failed", "语音合成失败")
+			}
+			break
+		}
+	}
+
+	// 被打断时输出的转录只包含已经开始播报的部分
+	agentMessageID := t.messageID()
+	t.session.sendEvent(messages.EventTypeAgentMessageAudioTranscriptDone, &messages.AudioTranscriptDoneEvent{
+		AgentMessageID: agentMessageID,
+		Transcript:     transcript.String(),
+	})
+	t.session.sendEvent(messages.EventTypeAgentMessageAudioDone, &messages.AudioDoneEvent{
+		AgentMessageID: agentMessageID,
+	})
+	t.session.clearTurn(t)
+	t.cancel()
+}
+
+func (t *Turn) messageID() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.agentMessageID
+}
+
+// splitSentences 按句末标点切出完整的句子, 返回句子和剩余的文本
+func splitSentences(text string) ([]string, string) {
+	var sentences []string
+	start := 0
+	runes := []rune(text)
+	for i, r := range runes {
+		if !isSentenceEnd(runes, i, r) {
+			continue
+		}
+		if sentence := strings.TrimSpace(string(runes[start : i+1])); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+		start = i + 1
+	}
+	return sentences, string(runes[start:])
+}
+
+// isSentenceEnd 英文句点后面需要跟空白, 避免切开小数和缩写
+func isSentenceEnd(runes []rune, i int, r rune) bool {
+	switch r {
+	case '。', '！', '？', '；', '!', '?', ';', '\n':
+		return true
+	case '.':
+		return i+1 < len(runes) && (runes[i+1] == ' ' || runes[i+1] == '\n')
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package realtime
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/providers/speech"
+)
+
+type VADEvent int
+
+const (
+	VADNone VADEvent = iota
+	VADSpeechStarted
+	VADSpeechStopped
+)
+
+// VoiceActivityDetector 逐帧判断用户是否开始或结束说话
+type VoiceActivityDetector interface {
+	Detect(frame []byte) VADEvent
+	// Pending 未开始说话时, 已经检测到但还不足以确认开始说话的语音字节数
+	Pending() int
+	Reset()
+}
+
+// EnergyVAD 按 RMS 能量判断是否在说话, 只支持 PCM16.
+// 连续超过阈值 MinSpeechDuration 视为开始说话, 之后连续低于阈值 SilenceDuration 视为说完
+type EnergyVAD struct {
+	threshold      float64
+	minSpeechBytes int
+	silenceBytes   int
+
+	speaking bool
+	voiced   int    // 未开始说话时连续语音的字节数
+	silent   int    // 说话过程中连续静音的字节数
+	carry    []byte // 上一帧末尾不足一个采样的字节
+}
+
+func NewEnergyVAD(cfg config.VADConfig, format speech.AudioFormat) *EnergyVAD {
+	bytesPerMs := format.BytesPerSecond() / 1000
+	return &EnergyVAD{
+		threshold:      cfg.Threshold,
+		minSpeechBytes: int(cfg.MinSpeechDuration.Milliseconds()) * bytesPerMs,
+		silenceBytes:   int(cfg.SilenceDuration.Milliseconds()) * bytesPerMs,
+	}
+}
+
+func (v *EnergyVAD) Detect(frame []byte) VADEvent {
+	if len(v.carry) > 0 {
+		frame = append(v.carry, frame...)
+		v.carry = nil
+	}
+	if len(frame)%2 == 1 {
+		v.carry = []byte{frame[len(frame)-1]}
+		frame = frame[:len(frame)-1]
+	}
+	if len(frame) == 0 {
+		return VADNone
+	}
+
+	loud := rms(frame) >= v.threshold
+	if !v.speaking {
+		if !loud {
+			v.voiced = 0
+			return VADNone
+		}
+		v.voiced += len(frame)
+		if v.voiced < v.minSpeechBytes {
+			return VADNone
+		}
+		v.speaking = true
+		v.silent = 0
+		return VADSpeechStarted
+	}
+
+	if loud {
+		v.silent = 0
+		return VADNone
+	}
+	v.silent += len(frame)
+	if v.silent < v.silenceBytes {
+		return VADNone
+	}
+	v.speaking = false
+	v.voiced = 0
+	return VADSpeechStopped
+}
+
+func (v *EnergyVAD) Pending() int {
+	if v.speaking {
+		return 0
+	}
+	return v.voiced
+}
+
+func (v *EnergyVAD) Reset() {
+	v.speaking = false
+	v.voiced = 0
+	v.silent = 0
+	v.carry = nil
+}
+
+// rms 计算 PCM16 采样的均方根, 归一化到 0~1
+func rms(pcm []byte) float64 {
+	var sum float64
+	samples := len(pcm) / 2
+	for i := 0; i < samples; i++ {
+		sample := float64(int16(binary.LittleEndian.Uint16(pcm[i*2:]))) / math.MaxInt16
+		sum += sample * sample
+	}
+	return math.Sqrt(sum / float64(samples))
+}
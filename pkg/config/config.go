@@ -12,14 +12,104 @@ import (
 )
 
 type SocialConfig struct {
-	Server   ServerConfig   `mapstructure:"server"`
-	Database DatabaseConfig `mapstructure:"database"`
-	Storage  StorageConfig  `mapstructure:"storage"`
-	APP      APPConfig      `mapstructure:"app"`
-	ATP      ATPConfig      `mapstructure:"atp"`
-	Avatar   AvatarConfig   `mapstructure:"avatar"`
-	Security SecurityConfig `mapstructure:"security"` // 新增 security
-	MCP      MCPConfig      `mapstructure:"mcp"`      // 新增 mcp
+	Server     ServerConfig     `mapstructure:"server"`
+	Database   DatabaseConfig   `mapstructure:"database"`
+	Storage    StorageConfig    `mapstructure:"storage"`
+	APP        APPConfig        `mapstructure:"app"`
+	ATP        ATPConfig        `mapstructure:"atp"`
+	Avatar     AvatarConfig     `mapstructure:"avatar"`
+	Security   SecurityConfig   `mapstructure:"security"` // 新增 security
+	MCP        MCPConfig        `mapstructure:"mcp"`      // 新增 mcp
+	Syncer     SyncerConfig     `mapstructure:"syncer"`
+	Firehose   FirehoseConfig   `mapstructure:"firehose"`
+	FeedGen    FeedGenConfig    `mapstructure:"feedgen"`
+	Moderation ModerationConfig `mapstructure:"moderation"`
+	Labeler    LabelerConfig    `mapstructure:"labeler"`
+}
+
+// SyncerConfig 本地记录同步到用户 PDS 的队列配置
+type SyncerConfig struct {
+	Enabled       bool          `mapstructure:"enabled"`
+	Interval      time.Duration `mapstructure:"interval"`        // 轮询队列的间隔
+	BatchSize     int           `mapstructure:"batch_size"`      // 每次从队列取出的操作数, 同一用户的操作合并为一次 applyWrites
+	MaxRetries    int           `mapstructure:"max_retries"`     // 超过后标记为失败
+	RetryDelay    time.Duration `mapstructure:"retry_delay"`     // 首次重试的延迟, 之后指数增长
+	MaxRetryDelay time.Duration `mapstructure:"max_retry_delay"` // 重试延迟上限
+	Retention     time.Duration `mapstructure:"retention"`       // 已完成操作的保留时间
+}
+
+// FirehoseConfig 订阅 relay 或 Jetstream, 索引其他 PDS 上写入的 app.vtri.* 记录
+type FirehoseConfig struct {
+	Enabled        bool          `mapstructure:"enabled"`
+	Mode           string        `mapstructure:"mode"`            // repos: com.atproto.sync.subscribeRepos (CBOR), jetstream: Jetstream (JSON)
+	URL            string        `mapstructure:"url"`             // 订阅地址, 如 wss://bsky.network/xrpc/com.atproto.sync.subscribeRepos
+	ReconnectDelay time.Duration `mapstructure:"reconnect_delay"` // 断线重连的初始延迟, 之后指数增长
+	CursorInterval time.Duration `mapstructure:"cursor_interval"` // 游标落盘的间隔
+}
+
+// FeedGenConfig 以 ATProto feed generator 的身份对外提供 feed
+type FeedGenConfig struct {
+	Enabled      bool                `mapstructure:"enabled"`
+	Hostname     string              `mapstructure:"hostname"`      // 服务 DID 为 did:web:<hostname>, 服务地址为 https://<hostname>
+	PublisherDID string              `mapstructure:"publisher_did"` // 发布 app.bsky.feed.generator 记录的账号, 默认为服务 DID
+	Feeds        []FeedGenFeedConfig `mapstructure:"feeds"`
+}
+
+// FeedGenFeedConfig feed 记录的 rkey 与内部 feed 名称的对应关系, 名称同 /api/feeds 的 feed 参数
+type FeedGenFeedConfig struct {
+	RKey string `mapstructure:"rkey"`
+	Feed string `mapstructure:"feed"`
+}
+
+// ServiceDID 返回 feed generator 的 did:web
+func (f *FeedGenConfig) ServiceDID() string {
+	return "did:web:" + f.Hostname
+}
+
+// Publisher 返回 feed 记录所在仓库的 DID
+func (f *FeedGenConfig) Publisher() string {
+	if f.PublisherDID != "" {
+		return f.PublisherDID
+	}
+	return f.ServiceDID()
+}
+
+// ModerationConfig 发布 moment 和发送聊天消息前的内容审核.
+// 检查器按 keywords、domains、image_hashes、classifier 的顺序执行, 取最严格的结论
+type ModerationConfig struct {
+	Enabled     bool                       `mapstructure:"enabled"`
+	Keywords    []ModerationRuleConfig     `mapstructure:"keywords"`     // 关键词, 以 / 包围时按正则匹配
+	Domains     []ModerationRuleConfig     `mapstructure:"domains"`      // 链接域名, 同时匹配子域名
+	ImageHashes []ModerationRuleConfig     `mapstructure:"image_hashes"` // 图片 blob 的 CID 或 sha256 (hex)
+	Classifier  ModerationClassifierConfig `mapstructure:"classifier"`
+}
+
+// ModerationRuleConfig 命中规则时的结论, verdict 可选 label, hold, reject, 默认 reject
+type ModerationRuleConfig struct {
+	Pattern string `mapstructure:"pattern"`
+	Verdict string `mapstructure:"verdict"`
+	Label   string `mapstructure:"label"`
+}
+
+// ModerationClassifierConfig 使用默认模型对文本分类, 调用失败时不影响发布
+type ModerationClassifierConfig struct {
+	Enabled bool          `mapstructure:"enabled"`
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// LabelerConfig 以 ATProto labeler 的身份签发审核标签, 并订阅第三方 labeler 的标签
+type LabelerConfig struct {
+	Enabled        bool                        `mapstructure:"enabled"`
+	DID            string                      `mapstructure:"did"`             // labeler 账号的 DID, 为 did:web:<feedgen.hostname> 时由本服务提供 DID 文档
+	SigningKey     string                      `mapstructure:"signing_key"`     // multibase 编码的私钥 (secp256k1 或 P-256), 与 DID 文档中的 #atproto_label 对应
+	Subscriptions  []LabelerSubscriptionConfig `mapstructure:"subscriptions"`   // 订阅的第三方 labeler, 不需要启用 enabled
+	ReconnectDelay time.Duration               `mapstructure:"reconnect_delay"` // 断线重连的初始延迟, 之后指数增长
+}
+
+// LabelerSubscriptionConfig 第三方 labeler 的 DID 和 subscribeLabels 地址, 地址为空时从 DID 文档解析
+type LabelerSubscriptionConfig struct {
+	DID string `mapstructure:"did"`
+	URL string `mapstructure:"url"`
 }
 
 type SecurityConfig struct {
@@ -78,15 +168,93 @@ type ATPConfig struct {
 }
 
 type AvatarConfig struct {
-	LLM   LLMConfig    `mapstructure:"llm"`
-	Tools []ToolConfig `mapstructure:"tools"`
+	LLM               LLMConfig          `mapstructure:"llm"`
+	Models            []LLMConfig        `mapstructure:"models"`              // 可供路由选择的其它模型, 通过 name 引用
+	Routes            []ModelRouteConfig `mapstructure:"routes"`              // 模型路由表, 按顺序匹配第一条
+	Fallbacks         []string           `mapstructure:"fallbacks"`           // 未命中路由时默认模型的降级链
+	StreamIdleTimeout time.Duration      `mapstructure:"stream_idle_timeout"` // 流式输出空闲超时, 超时后切换到降级模型
+	Tools             []ToolConfig       `mapstructure:"tools"`
+	MaxSteps          int                `mapstructure:"max_steps"` // 单次回复中 LLM 调用工具的最大轮数
+	Memory            MemoryConfig       `mapstructure:"memory"`
+	Realtime          RealtimeConfig     `mapstructure:"realtime"`
+	Resume            ResumeConfig       `mapstructure:"resume"`
 }
 
-type LLMConfig struct {
+// ResumeConfig 回复断线续传: 连接断开后回复继续生成, 重连后通过 agent_message.resume 补发错过的事件
+type ResumeConfig struct {
+	GracePeriod time.Duration `mapstructure:"grace_period"` // 没有连接接收时回复继续生成的时间, 超时后打断
+	MaxEvents   int           `mapstructure:"max_events"`   // 每个回复保留的最近事件数
+	Retention   time.Duration `mapstructure:"retention"`    // 回复结束后事件保留的时间
+}
+
+type MemoryConfig struct {
+	Semantic  bool            `mapstructure:"semantic"` // 是否启用跨房间的语义记忆
+	TopK      int             `mapstructure:"top_k"`    // 每次召回的记忆条数
+	Embedding EmbeddingConfig `mapstructure:"embedding"`
+	Summary   SummaryConfig   `mapstructure:"summary"`
+}
+
+// SummaryConfig 话题滚动摘要, 每新增 Every 条消息将较早的消息压缩为一条摘要
+type SummaryConfig struct {
+	Enabled    bool `mapstructure:"enabled"`     // 关闭时只在上下文超出模型窗口时按需摘要
+	Every      int  `mapstructure:"every"`       // 触发摘要所需的新消息数
+	KeepRecent int  `mapstructure:"keep_recent"` // 最近多少条消息保留原文, 不参与摘要
+}
+
+// RealtimeConfig 实时语音会话: 语音识别、语音合成和服务端语音活动检测
+type RealtimeConfig struct {
+	STT SpeechConfig `mapstructure:"stt"`
+	TTS SpeechConfig `mapstructure:"tts"`
+	VAD VADConfig    `mapstructure:"vad"`
+}
+
+type SpeechConfig struct {
+	Provider string `mapstructure:"provider"` // openai, fake
 	APIURL   string `mapstructure:"api_url"`
-	Model    string `mapstructure:"model"`
-	Provider string `mapstructure:"provider"`
 	APIKey   string `mapstructure:"api_key"`
+	Model    string `mapstructure:"model"`
+	Voice    string `mapstructure:"voice"`    // 仅 TTS 使用
+	Language string `mapstructure:"language"` // 仅 STT 使用, 为空时自动识别
+	Text     string `mapstructure:"text"`     // 仅 fake STT 使用, 固定返回的转写文本
+}
+
+// VADConfig 基于能量的语音活动检测, 只对 PCM16 输入生效
+type VADConfig struct {
+	Threshold         float64       `mapstructure:"threshold"`           // 归一化 RMS 阈值, 0~1
+	SilenceDuration   time.Duration `mapstructure:"silence_duration"`    // 静音持续多久视为说完
+	MinSpeechDuration time.Duration `mapstructure:"min_speech_duration"` // 语音持续多久才视为开始说话, 过滤短促噪声
+	PrefixPadding     time.Duration `mapstructure:"prefix_padding"`      // 开始说话前保留的音频, 避免截掉第一个字
+}
+
+type EmbeddingConfig struct {
+	Provider   string `mapstructure:"provider"` // openai, hashing
+	APIURL     string `mapstructure:"api_url"`
+	APIKey     string `mapstructure:"api_key"`
+	Model      string `mapstructure:"model"`
+	Dimensions int    `mapstructure:"dimensions"`
+}
+
+// ModelRouteConfig 模型路由规则, 未配置的条件不参与匹配
+type ModelRouteConfig struct {
+	Name       string   `mapstructure:"name"`
+	AsterDID   string   `mapstructure:"aster_did"`
+	RoomType   string   `mapstructure:"room_type"`
+	ThreadID   string   `mapstructure:"thread_id"`
+	Tools      *bool    `mapstructure:"tools"`      // 请求是否携带工具
+	Modalities []string `mapstructure:"modalities"` // 请求包含全部所列模态时命中, 例如 image, audio
+	Model      string   `mapstructure:"model"`
+	Fallbacks  []string `mapstructure:"fallbacks"`
+}
+
+type LLMConfig struct {
+	Name     string                 `mapstructure:"name"` // 模型名称, avatar.llm 固定为 default
+	APIURL   string                 `mapstructure:"api_url"`
+	Model    string                 `mapstructure:"model"`
+	Provider string                 `mapstructure:"provider"` // openai, anthropic, ollama, llamacpp, fake
+	APIKey   string                 `mapstructure:"api_key"`
+	Options  map[string]interface{} `mapstructure:"options"` // 提供方专属配置, 例如 fake 的 script_file
+
+	ContextWindow int `mapstructure:"context_window"` // 模型上下文窗口 token 数
 }
 
 type ToolConfig struct {
@@ -66,4 +66,15 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("avatar.llm.api_url", "https://api.openai.com/v1")
 	v.SetDefault("avatar.llm.model", "gpt-4")
 	v.SetDefault("avatar.llm.provider", "openai")
+
+	v.SetDefault("avatar.realtime.stt.provider", "fake")
+	v.SetDefault("avatar.realtime.tts.provider", "fake")
+	v.SetDefault("avatar.realtime.vad.threshold", 0.02)
+	v.SetDefault("avatar.realtime.vad.silence_duration", "600ms")
+	v.SetDefault("avatar.realtime.vad.min_speech_duration", "200ms")
+	v.SetDefault("avatar.realtime.vad.prefix_padding", "300ms")
+
+	v.SetDefault("avatar.resume.grace_period", "60s")
+	v.SetDefault("avatar.resume.max_events", 4096)
+	v.SetDefault("avatar.resume.retention", "5m")
 }
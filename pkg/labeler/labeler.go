@@ -0,0 +1,172 @@
+package labeler
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/label"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/sirupsen/logrus"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+)
+
+var (
+	sharedOnce    sync.Once
+	sharedLabeler *Labeler
+)
+
+// Shared 进程内共享的 labeler, 签发标签和 subscribeLabels 推送需要使用同一个实例.
+// 未启用或配置有误时返回空
+func Shared(config *config.SocialConfig, metaStore *repositories.MetaStore) *Labeler {
+	sharedOnce.Do(func() {
+		if !config.Labeler.Enabled {
+			return
+		}
+		l, err := NewLabeler(config.Labeler, metaStore)
+		if err != nil {
+			logrus.Errorf("labeler 配置有误, 不签发标签: %v", err)
+			return
+		}
+		sharedLabeler = l
+	})
+	return sharedLabeler
+}
+
+// Labeler 以配置的 DID 和密钥签发标签, 标签按序号持久化在 label_events 中
+type Labeler struct {
+	did       string
+	key       crypto.PrivateKeyExportable
+	metaStore *repositories.MetaStore
+
+	mu      sync.Mutex
+	changed chan struct{}
+}
+
+func NewLabeler(cfg config.LabelerConfig, metaStore *repositories.MetaStore) (*Labeler, error) {
+	if _, err := syntax.ParseDID(cfg.DID); err != nil {
+		return nil, fmt.Errorf("无效的 labeler DID: %w", err)
+	}
+	key, err := crypto.ParsePrivateMultibase(cfg.SigningKey)
+	if err != nil {
+		return nil, fmt.Errorf("解析签名密钥失败: %w", err)
+	}
+	return &Labeler{
+		did:       cfg.DID,
+		key:       key,
+		metaStore: metaStore,
+		changed:   make(chan struct{}),
+	}, nil
+}
+
+func (l *Labeler) DID() string {
+	return l.did
+}
+
+// PublicKeyMultibase 写入 DID 文档 #atproto_label 的公钥
+func (l *Labeler) PublicKeyMultibase() (string, error) {
+	pub, err := l.key.PublicKey()
+	if err != nil {
+		return "", err
+	}
+	return pub.Multibase(), nil
+}
+
+// Emit 为 uri 签发一组标签, neg 为 true 时签发撤销标签. cid 为空时标签作用于记录的所有版本
+func (l *Labeler) Emit(ctx context.Context, uri string, cid string, vals []string, neg bool) ([]*repositories.LabelEvent, error) {
+	if len(vals) == 0 {
+		return nil, nil
+	}
+	now := time.Now()
+	cts := now.UTC().Format(syntax.AtprotoDatetimeLayout)
+	events := make([]*repositories.LabelEvent, 0, len(vals))
+	for _, val := range vals {
+		event := &repositories.LabelEvent{
+			Src:       l.did,
+			URI:       uri,
+			Val:       val,
+			CID:       cid,
+			Neg:       neg,
+			Cts:       cts,
+			CreatedAt: now.UnixMilli(),
+		}
+		signed := signedLabel(event)
+		if err := signed.Sign(l.key); err != nil {
+			return nil, fmt.Errorf("签名标签 %s 失败: %w", val, err)
+		}
+		event.Sig = signed.Sig
+		events = append(events, event)
+	}
+	if err := l.metaStore.ModRepo.AppendLabelEvents(events); err != nil {
+		return nil, fmt.Errorf("保存标签失败: %w", err)
+	}
+	l.notify()
+	return events, nil
+}
+
+// Query com.atproto.label.queryLabels, 只返回当前生效的标签, 游标为最后一条的序号
+func (l *Labeler) Query(uriPatterns []string, sources []string, cursor int64, limit int) (*comatproto.LabelQueryLabels_Output, error) {
+	events, err := l.metaStore.ModRepo.QueryLabelEvents(uriPatterns, sources, cursor, limit)
+	if err != nil {
+		return nil, fmt.Errorf("查询标签失败: %w", err)
+	}
+	out := &comatproto.LabelQueryLabels_Output{
+		Labels: make([]*comatproto.LabelDefs_Label, 0, len(events)),
+	}
+	for _, event := range events {
+		out.Labels = append(out.Labels, ToLexicon(event))
+	}
+	if len(events) >= limit && len(events) > 0 {
+		next := strconv.FormatInt(events[len(events)-1].Seq, 10)
+		out.Cursor = &next
+	}
+	return out, nil
+}
+
+// Changed 返回在下一次签发标签时关闭的通道
+func (l *Labeler) Changed() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.changed
+}
+
+func (l *Labeler) notify() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// signedLabel 签名内容不包含 sig, 版本固定为 1
+func signedLabel(event *repositories.LabelEvent) *label.Label {
+	signed := &label.Label{
+		CreatedAt: event.Cts,
+		SourceDID: event.Src,
+		URI:       event.URI,
+		Val:       event.Val,
+		Version:   label.ATPROTO_LABEL_VERSION,
+		Sig:       event.Sig,
+	}
+	if event.CID != "" {
+		cid := event.CID
+		signed.CID = &cid
+	}
+	if event.Neg {
+		neg := true
+		signed.Negated = &neg
+	}
+	return signed
+}
+
+// ToLexicon 转换为 com.atproto.label.defs#label. label.Label.ToLexicon 不带 neg, 这里补上
+func ToLexicon(event *repositories.LabelEvent) *comatproto.LabelDefs_Label {
+	signed := signedLabel(event)
+	lex := signed.ToLexicon()
+	lex.Neg = signed.Negated
+	return &lex
+}
@@ -0,0 +1,103 @@
+package labeler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/data"
+)
+
+const (
+	streamPageSize = 500
+	// 其他进程签发的标签不会触发 Changed, 按这个间隔兜底查询
+	streamPollInterval = 10 * time.Second
+)
+
+var ErrFutureCursor = errors.New("游标超过了最新的序号")
+
+// Stream 推送 subscribeLabels 的帧. cursor 为空时只推送之后签发的标签, 否则先回放 cursor 之后的全部事件.
+// 持续运行直到 ctx 结束或 send 返回错误
+func (l *Labeler) Stream(ctx context.Context, cursor *int64, send func(frame []byte) error) error {
+	latest, err := l.metaStore.ModRepo.LatestLabelSeq()
+	if err != nil {
+		return fmt.Errorf("读取最新序号失败: %w", err)
+	}
+	last := latest
+	if cursor != nil {
+		if *cursor > latest {
+			frame, err := ErrorFrame("FutureCursor", ErrFutureCursor.Error())
+			if err != nil {
+				return err
+			}
+			if err := send(frame); err != nil {
+				return err
+			}
+			return ErrFutureCursor
+		}
+		last = *cursor
+	}
+
+	ticker := time.NewTicker(streamPollInterval)
+	defer ticker.Stop()
+	for {
+		// 先取通知通道再查询, 查询之后签发的标签一定会唤醒下一轮
+		changed := l.Changed()
+		for {
+			events, err := l.metaStore.ModRepo.ListLabelEvents(last, streamPageSize)
+			if err != nil {
+				return fmt.Errorf("读取标签事件失败: %w", err)
+			}
+			for _, event := range events {
+				frame, err := LabelsFrame(event.Seq, []*comatproto.LabelDefs_Label{ToLexicon(event)})
+				if err != nil {
+					return err
+				}
+				if err := send(frame); err != nil {
+					return err
+				}
+				last = event.Seq
+			}
+			if len(events) < streamPageSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		case <-ticker.C:
+		}
+	}
+}
+
+// LabelsFrame 编码 #labels 消息: CBOR 头 {op: 1, t: "#labels"} + 消息体
+func LabelsFrame(seq int64, labels []*comatproto.LabelDefs_Label) ([]byte, error) {
+	header, err := data.MarshalCBOR(map[string]any{"op": int64(1), "t": "#labels"})
+	if err != nil {
+		return nil, fmt.Errorf("编码帧头失败: %w", err)
+	}
+	buf := bytes.NewBuffer(header)
+	body := &comatproto.LabelSubscribeLabels_Labels{Seq: seq, Labels: labels}
+	if err := body.MarshalCBOR(buf); err != nil {
+		return nil, fmt.Errorf("编码标签失败: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// ErrorFrame 编码错误帧: CBOR 头 {op: -1} + {error, message}
+func ErrorFrame(name string, message string) ([]byte, error) {
+	header, err := data.MarshalCBOR(map[string]any{"op": int64(-1)})
+	if err != nil {
+		return nil, fmt.Errorf("编码帧头失败: %w", err)
+	}
+	body, err := data.MarshalCBOR(map[string]any{"error": name, "message": message})
+	if err != nil {
+		return nil, fmt.Errorf("编码错误帧失败: %w", err)
+	}
+	return append(header, body...), nil
+}
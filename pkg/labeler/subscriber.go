@@ -0,0 +1,340 @@
+package labeler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/label"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+)
+
+const (
+	DefaultReconnectDelay = time.Second
+
+	maxReconnectDelay = time.Minute
+	readTimeout       = 5 * time.Minute
+	cursorInterval    = 5 * time.Second
+)
+
+// Subscriber 订阅第三方 labeler 的 subscribeLabels, 校验签名后写入 content_labels (src 为 labeler 的 DID).
+// 只保存作用在 app.vtri.* 记录上的标签, 撤销标签删除对应的记录, 已过期的标签忽略
+type Subscriber struct {
+	metaStore      *repositories.MetaStore
+	did            string
+	url            string
+	reconnectDelay time.Duration
+	directory      identity.Directory
+
+	keyMu sync.Mutex
+	key   crypto.PublicKey
+
+	cursor atomic.Int64
+
+	mu      sync.Mutex
+	running bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func NewSubscriber(metaStore *repositories.MetaStore, cfg config.LabelerSubscriptionConfig, reconnectDelay time.Duration, directory identity.Directory) (*Subscriber, error) {
+	if _, err := syntax.ParseDID(cfg.DID); err != nil {
+		return nil, fmt.Errorf("无效的 labeler DID %s: %w", cfg.DID, err)
+	}
+	if reconnectDelay <= 0 {
+		reconnectDelay = DefaultReconnectDelay
+	}
+	return &Subscriber{
+		metaStore:      metaStore,
+		did:            cfg.DID,
+		url:            cfg.URL,
+		reconnectDelay: reconnectDelay,
+		directory:      directory,
+	}, nil
+}
+
+func (s *Subscriber) source() string {
+	return "labels:" + s.did
+}
+
+// Start 启动订阅, 从保存的游标继续
+func (s *Subscriber) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running {
+		return fmt.Errorf("订阅已经在运行")
+	}
+	cursor, err := s.metaStore.AtpRepo.GetIngestCursor(s.source())
+	if err != nil {
+		return fmt.Errorf("读取订阅游标失败: %w", err)
+	}
+	s.cursor.Store(cursor)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	s.running = true
+
+	go s.run(ctx, s.done)
+	logrus.Infof("labeler %s 的订阅已启动, 游标 %d", s.did, cursor)
+	return nil
+}
+
+// Stop 停止订阅并保存游标
+func (s *Subscriber) Stop() {
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return
+	}
+	s.running = false
+	s.cancel()
+	done := s.done
+	s.mu.Unlock()
+
+	<-done
+	logrus.Infof("labeler %s 的订阅已停止", s.did)
+}
+
+func (s *Subscriber) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	go s.flushCursorLoop(ctx)
+	defer s.flushCursor()
+
+	delay := s.reconnectDelay
+	for ctx.Err() == nil {
+		connected, err := s.subscribe(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			delay = s.reconnectDelay
+		}
+		logrus.Warnf("labeler %s 的订阅连接断开: %v, %s 后重连", s.did, err, delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+func (s *Subscriber) subscribe(ctx context.Context) (bool, error) {
+	subscribeURL, err := s.subscribeURL(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, subscribeURL, nil)
+	if err != nil {
+		return false, fmt.Errorf("连接 %s 失败: %w", subscribeURL, err)
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+			return true, err
+		}
+		_, frame, err := conn.ReadMessage()
+		if err != nil {
+			return true, err
+		}
+		if err := s.HandleFrame(ctx, frame); err != nil {
+			return true, err
+		}
+	}
+}
+
+// subscribeURL 未配置地址时使用 DID 文档中 #atproto_labeler 的服务地址
+func (s *Subscriber) subscribeURL(ctx context.Context) (string, error) {
+	base := s.url
+	if base == "" {
+		ident, err := s.directory.LookupDID(ctx, syntax.DID(s.did))
+		if err != nil {
+			return "", fmt.Errorf("解析 labeler %s 失败: %w", s.did, err)
+		}
+		base = ident.GetServiceEndpoint("atproto_labeler")
+		if base == "" {
+			return "", fmt.Errorf("labeler %s 的 DID 文档中没有 #atproto_labeler 服务", s.did)
+		}
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("无效的 labeler 地址 %s: %w", base, err)
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+	if !strings.HasSuffix(u.Path, "/xrpc/com.atproto.label.subscribeLabels") {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/xrpc/com.atproto.label.subscribeLabels"
+	}
+	query := u.Query()
+	query.Set("cursor", strconv.FormatInt(s.cursor.Load(), 10))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// HandleFrame 解码一帧并保存其中的标签. 签名校验失败的标签只记录日志; 订阅源返回的错误帧会中断当前连接
+func (s *Subscriber) HandleFrame(ctx context.Context, frame []byte) error {
+	reader := bytes.NewReader(frame)
+
+	var rawHeader cbg.Deferred
+	if err := rawHeader.UnmarshalCBOR(reader); err != nil {
+		return fmt.Errorf("读取帧头失败: %w", err)
+	}
+	header, err := data.UnmarshalCBOR(rawHeader.Raw)
+	if err != nil {
+		return fmt.Errorf("解析帧头失败: %w", err)
+	}
+	op, _ := header["op"].(int64)
+	msgType, _ := header["t"].(string)
+
+	if op == -1 {
+		body, _ := data.UnmarshalCBOR(frame[len(rawHeader.Raw):])
+		return fmt.Errorf("订阅源返回错误: %v %v", body["error"], body["message"])
+	}
+
+	switch msgType {
+	case "#labels":
+		var evt comatproto.LabelSubscribeLabels_Labels
+		if err := evt.UnmarshalCBOR(reader); err != nil {
+			return fmt.Errorf("解析 #labels 失败: %w", err)
+		}
+		for _, lex := range evt.Labels {
+			if err := s.apply(ctx, lex); err != nil {
+				logrus.Warnf("处理 labeler %s 的标签 %s %s 失败: %v", s.did, lex.Uri, lex.Val, err)
+			}
+		}
+		if evt.Seq > 0 {
+			s.cursor.Store(evt.Seq)
+		}
+	case "#info":
+		var evt comatproto.LabelSubscribeLabels_Info
+		if err := evt.UnmarshalCBOR(reader); err == nil {
+			logrus.Infof("labeler %s 消息: %s", s.did, evt.Name)
+		}
+	}
+	return nil
+}
+
+func (s *Subscriber) apply(ctx context.Context, lex *comatproto.LabelDefs_Label) error {
+	if !strings.Contains(lex.Uri, "/app.vtri.") {
+		return nil
+	}
+	if lex.Src != s.did {
+		return fmt.Errorf("标签来源 %s 与订阅的 labeler 不一致", lex.Src)
+	}
+	signed := label.FromLexicon(lex)
+	signed.Negated = lex.Neg
+	if err := signed.VerifySyntax(); err != nil {
+		return err
+	}
+	if err := s.verify(ctx, &signed); err != nil {
+		return err
+	}
+
+	negated := lex.Neg != nil && *lex.Neg
+	if lex.Exp != nil {
+		if exp, err := syntax.ParseDatetime(*lex.Exp); err == nil && exp.Time().Before(time.Now()) {
+			negated = true
+		}
+	}
+	if negated {
+		return s.metaStore.ModRepo.RemoveLabels(s.did, lex.Uri, []string{lex.Val})
+	}
+	return s.metaStore.ModRepo.AddLabels(s.did, lex.Uri, []string{lex.Val})
+}
+
+// verify 使用 DID 文档中的 #atproto_label 公钥校验签名, 失败时刷新一次 DID 文档, 应对密钥轮换
+func (s *Subscriber) verify(ctx context.Context, signed *label.Label) error {
+	key, err := s.labelKey(ctx, false)
+	if err != nil {
+		return err
+	}
+	if err := signed.VerifySignature(key); err == nil {
+		return nil
+	}
+	if key, err = s.labelKey(ctx, true); err != nil {
+		return err
+	}
+	if err := signed.VerifySignature(key); err != nil {
+		return fmt.Errorf("签名校验失败: %w", err)
+	}
+	return nil
+}
+
+func (s *Subscriber) labelKey(ctx context.Context, refresh bool) (crypto.PublicKey, error) {
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+
+	if s.key != nil && !refresh {
+		return s.key, nil
+	}
+	did := syntax.DID(s.did)
+	if refresh {
+		if err := s.directory.Purge(ctx, did.AtIdentifier()); err != nil {
+			logrus.Warnf("刷新 labeler %s 的 DID 文档失败: %v", s.did, err)
+		}
+	}
+	ident, err := s.directory.LookupDID(ctx, did)
+	if err != nil {
+		return nil, fmt.Errorf("解析 labeler %s 失败: %w", s.did, err)
+	}
+	key, err := ident.GetPublicKey("atproto_label")
+	if err != nil {
+		return nil, fmt.Errorf("labeler %s 没有 #atproto_label 公钥: %w", s.did, err)
+	}
+	s.key = key
+	return key, nil
+}
+
+func (s *Subscriber) flushCursorLoop(ctx context.Context) {
+	ticker := time.NewTicker(cursorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.flushCursor()
+		}
+	}
+}
+
+func (s *Subscriber) flushCursor() {
+	cursor := s.cursor.Load()
+	if cursor == 0 {
+		return
+	}
+	if err := s.metaStore.AtpRepo.SaveIngestCursor(s.source(), cursor); err != nil {
+		logrus.Errorf("保存 labeler %s 的订阅游标失败: %v", s.did, err)
+	}
+}
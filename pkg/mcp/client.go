@@ -12,6 +12,7 @@ import (
 
 	mcpclient "github.com/mark3labs/mcp-go/client"
 	mcpclienttransport "github.com/mark3labs/mcp-go/client/transport"
+	mcptypes "github.com/mark3labs/mcp-go/mcp"
 	"github.com/sirupsen/logrus"
 	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
 )
@@ -99,9 +100,21 @@ func NewMCPClient(metaStore *repositories.MetaStore, serverInfo *MCPServerInfo)
 	var oauthHandler *mcpclienttransport.OAuthHandler
 	var err error
 
-	if serverInfo.Authorization.Method != MCPServerAuthorizationMethodOAuth2 {
+	switch serverInfo.Authorization.Method {
+	default:
 		return nil, fmt.Errorf("invalid authorization method: %s", serverInfo.Authorization.Method)
-	} else {
+	case MCPServerAuthorizationMethodNone, "":
+		switch serverInfo.Endpoint.Type {
+		default:
+			return nil, fmt.Errorf("invalid endpoint type: %s", serverInfo.Endpoint.Type)
+		case MCPServerEndpointTypeStdio:
+			client = nil
+		case MCPServerEndpointTypeSSE:
+			client, err = mcpclient.NewSSEMCPClient(serverInfo.Endpoint.Url, mcpclienttransport.WithHeaders(serverInfo.Endpoint.Headers))
+		case MCPServerEndpointTypeStreamableHttp:
+			client, err = mcpclient.NewStreamableHttpClient(serverInfo.Endpoint.Url, mcpclienttransport.WithHTTPHeaders(serverInfo.Endpoint.Headers))
+		}
+	case MCPServerAuthorizationMethodOAuth2:
 		tokenStore := NewDBTokenStore(metaStore, serverInfo)
 		oAuthConfig := mcpclient.OAuthConfig{
 			ClientID:     GetString(serverInfo.Authorization.Config, "client_id"),
@@ -112,7 +125,8 @@ func NewMCPClient(metaStore *repositories.MetaStore, serverInfo *MCPServerInfo)
 			TokenStore:   tokenStore,
 		}
 		oauthHandler = mcpclienttransport.NewOAuthHandler(oAuthConfig)
-		baseURL, err := extractBaseURL(serverInfo.Endpoint.Url)
+		var baseURL string
+		baseURL, err = extractBaseURL(serverInfo.Endpoint.Url)
 		if err != nil {
 			logrus.WithError(err).Errorf("Failed to extract base URL: %v", err)
 			return nil, err
@@ -178,6 +192,71 @@ func (c *MCPClient) ExchangeCode(ctx context.Context, expectedState string, code
 	return nil
 }
 
+// Connect 建立与 MCP Server 的会话并完成 initialize 握手
+func (c *MCPClient) Connect(ctx context.Context) error {
+	if c.client == nil {
+		return fmt.Errorf("mcp server %s 不支持的连接方式: %s", c.ServerInfo.McpId, c.ServerInfo.Endpoint.Type)
+	}
+	if err := c.client.Start(ctx); err != nil {
+		return fmt.Errorf("启动 mcp 客户端失败: %w", err)
+	}
+
+	initRequest := mcptypes.InitializeRequest{}
+	initRequest.Params.ProtocolVersion = mcptypes.LATEST_PROTOCOL_VERSION
+	initRequest.Params.ClientInfo = mcptypes.Implementation{
+		Name:    "avatarai-social",
+		Version: "1.0.0",
+	}
+	result, err := c.client.Initialize(ctx, initRequest)
+	if err != nil {
+		return fmt.Errorf("mcp initialize 失败: %w", err)
+	}
+	logrus.Infof("MCP Server %s 已连接: %s %s", c.ServerInfo.McpId, result.ServerInfo.Name, result.ServerInfo.Version)
+	return nil
+}
+
+// ListTools 获取 MCP Server 提供的全部工具
+func (c *MCPClient) ListTools(ctx context.Context) ([]mcptypes.Tool, error) {
+	if c.client == nil {
+		return nil, fmt.Errorf("mcp server %s 未连接", c.ServerInfo.McpId)
+	}
+	result, err := c.client.ListTools(ctx, mcptypes.ListToolsRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("获取 mcp 工具列表失败: %w", err)
+	}
+	return result.Tools, nil
+}
+
+// CallTool 调用 MCP Server 上的工具, arguments 为 JSON 对象字符串
+func (c *MCPClient) CallTool(ctx context.Context, name string, arguments string) (*mcptypes.CallToolResult, error) {
+	if c.client == nil {
+		return nil, fmt.Errorf("mcp server %s 未连接", c.ServerInfo.McpId)
+	}
+
+	var args map[string]any
+	if arguments != "" {
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return nil, fmt.Errorf("解析工具参数失败: %w", err)
+		}
+	}
+
+	request := mcptypes.CallToolRequest{}
+	request.Params.Name = name
+	request.Params.Arguments = args
+	result, err := c.client.CallTool(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("调用 mcp 工具 %s 失败: %w", name, err)
+	}
+	return result, nil
+}
+
+func (c *MCPClient) Close() error {
+	if c.client == nil {
+		return nil
+	}
+	return c.client.Close()
+}
+
 func extractBaseURL(mcpServerURL string) (string, error) {
 	parsedURL, err := url.Parse(mcpServerURL)
 	if err != nil {
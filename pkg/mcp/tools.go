@@ -0,0 +1,185 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	mcptypes "github.com/mark3labs/mcp-go/mcp"
+	"github.com/sirupsen/logrus"
+	"github.com/zhongshangwu/avatarai-social/pkg/providers/llm"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+)
+
+// ToolNameSeparator MCP 工具注册到 LLM 时的命名空间分隔符: {mcpId}__{toolName}
+const ToolNameSeparator = "__"
+
+// NamespacedToolName 生成带 mcpId 命名空间的工具名, 避免不同 server 的同名工具冲突
+func NamespacedToolName(mcpId string, toolName string) string {
+	return mcpId + ToolNameSeparator + toolName
+}
+
+// ParseNamespacedToolName 解析带命名空间的工具名, 返回 mcpId 和原始工具名
+func ParseNamespacedToolName(name string) (string, string, bool) {
+	parts := strings.SplitN(name, ToolNameSeparator, 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// MCPToolExecutor 将 MCP Server 上的工具适配为 llm.ToolExecutor
+type MCPToolExecutor struct {
+	client *MCPClient
+	tool   mcptypes.Tool
+}
+
+func NewMCPToolExecutor(client *MCPClient, tool mcptypes.Tool) *MCPToolExecutor {
+	return &MCPToolExecutor{client: client, tool: tool}
+}
+
+func (e *MCPToolExecutor) Execute(ctx context.Context, arguments string) (string, error) {
+	result, err := e.client.CallTool(ctx, e.tool.Name, arguments)
+	if err != nil {
+		return "", err
+	}
+
+	output := formatCallToolResult(result)
+	if result.IsError {
+		return "", fmt.Errorf("mcp 工具 %s 执行出错: %s", e.tool.Name, output)
+	}
+	return output, nil
+}
+
+func (e *MCPToolExecutor) GetName() string {
+	return NamespacedToolName(e.client.ServerInfo.McpId, e.tool.Name)
+}
+
+func (e *MCPToolExecutor) GetDescription() string {
+	if e.client.ServerInfo.Name == "" {
+		return e.tool.Description
+	}
+	return fmt.Sprintf("[%s] %s", e.client.ServerInfo.Name, e.tool.Description)
+}
+
+func (e *MCPToolExecutor) GetParameters() map[string]interface{} {
+	var schemaBytes []byte
+	if len(e.tool.RawInputSchema) > 0 {
+		schemaBytes = e.tool.RawInputSchema
+	} else {
+		schemaBytes, _ = json.Marshal(e.tool.InputSchema)
+	}
+
+	parameters := map[string]interface{}{}
+	if err := json.Unmarshal(schemaBytes, &parameters); err != nil {
+		logrus.Errorf("解析 mcp 工具 %s 参数定义失败: %v", e.tool.Name, err)
+	}
+	if _, ok := parameters["type"]; !ok {
+		parameters["type"] = "object"
+	}
+	if _, ok := parameters["properties"]; !ok {
+		parameters["properties"] = map[string]interface{}{}
+	}
+	return parameters
+}
+
+// formatCallToolResult 将工具结果转换为 LLM 可读的文本, 文本内容直接拼接, 其它内容序列化为 JSON
+func formatCallToolResult(result *mcptypes.CallToolResult) string {
+	parts := make([]string, 0, len(result.Content))
+	for _, content := range result.Content {
+		if text, ok := mcptypes.AsTextContent(content); ok {
+			parts = append(parts, text.Text)
+			continue
+		}
+		contentBytes, err := json.Marshal(content)
+		if err != nil {
+			continue
+		}
+		parts = append(parts, string(contentBytes))
+	}
+	return strings.Join(parts, "\n")
+}
+
+// MCPSessionManager 管理一个用户已启用的 MCP Server 会话, 并将其工具注册到 ModelManager
+type MCPSessionManager struct {
+	metaStore *repositories.MetaStore
+	clients   map[string]*MCPClient
+	mu        sync.Mutex
+}
+
+func NewMCPSessionManager(metaStore *repositories.MetaStore) *MCPSessionManager {
+	return &MCPSessionManager{
+		metaStore: metaStore,
+		clients:   make(map[string]*MCPClient),
+	}
+}
+
+// RegisterTools 连接所有已启用的 MCP Server, 并将工具注册到 llmManager
+// 单个 server 连接失败不影响其它 server
+func (m *MCPSessionManager) RegisterTools(ctx context.Context, llmManager *llm.ModelManager, servers []*MCPServerInfo) int {
+	count := 0
+	for _, server := range servers {
+		if !server.Enabled || server.Endpoint == nil {
+			continue
+		}
+
+		client, err := m.connect(ctx, server)
+		if err != nil {
+			logrus.Errorf("连接 MCP Server %s 失败: %v", server.McpId, err)
+			continue
+		}
+
+		tools, err := client.ListTools(ctx)
+		if err != nil {
+			logrus.Errorf("获取 MCP Server %s 工具失败: %v", server.McpId, err)
+			continue
+		}
+
+		for _, tool := range tools {
+			llmManager.RegisterTool(NewMCPToolExecutor(client, tool))
+			count++
+		}
+		logrus.Infof("MCP Server %s 注册了 %d 个工具", server.McpId, len(tools))
+	}
+	return count
+}
+
+func (m *MCPSessionManager) connect(ctx context.Context, server *MCPServerInfo) (*MCPClient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if client, ok := m.clients[server.McpId]; ok {
+		return client, nil
+	}
+
+	if server.Authorization.Method == MCPServerAuthorizationMethodOAuth2 &&
+		server.Authorization.Status != MCPServerAuthorizationStatusActive {
+		return nil, fmt.Errorf("mcp server 未授权")
+	}
+
+	client, err := NewMCPClient(m.metaStore, server)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Connect(ctx); err != nil {
+		client.Close()
+		return nil, err
+	}
+	m.clients[server.McpId] = client
+	return client, nil
+}
+
+// Close 关闭所有 MCP 会话
+func (m *MCPSessionManager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for mcpId, client := range m.clients {
+		if err := client.Close(); err != nil {
+			logrus.Errorf("关闭 MCP Server %s 会话失败: %v", mcpId, err)
+		}
+	}
+	m.clients = make(map[string]*MCPClient)
+}
@@ -0,0 +1,167 @@
+package mcp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	mcptypes "github.com/mark3labs/mcp-go/mcp"
+	mcpserver "github.com/mark3labs/mcp-go/server"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/providers/llm"
+)
+
+// newStubServer 启动一个本地 streamable-HTTP MCP Server, 提供 lookup、fail 和 broken 三个工具
+func newStubServer(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	server := mcpserver.NewMCPServer(name, "1.0.0", mcpserver.WithToolCapabilities(true))
+	server.AddTool(mcptypes.NewTool("lookup",
+		mcptypes.WithDescription("lookup a key"),
+		mcptypes.WithString("key", mcptypes.Required()),
+	), func(ctx context.Context, request mcptypes.CallToolRequest) (*mcptypes.CallToolResult, error) {
+		key, err := request.RequireString("key")
+		if err != nil {
+			return mcptypes.NewToolResultError(err.Error()), nil
+		}
+		return mcptypes.NewToolResultText(name + ":" + key), nil
+	})
+	server.AddTool(mcptypes.NewTool("fail", mcptypes.WithDescription("always reports a tool error")),
+		func(ctx context.Context, request mcptypes.CallToolRequest) (*mcptypes.CallToolResult, error) {
+			return mcptypes.NewToolResultError("boom"), nil
+		})
+	server.AddTool(mcptypes.NewTool("broken", mcptypes.WithDescription("handler returns an error")),
+		func(ctx context.Context, request mcptypes.CallToolRequest) (*mcptypes.CallToolResult, error) {
+			return nil, errors.New("handler failed")
+		})
+
+	httpServer := httptest.NewServer(mcpserver.NewStreamableHTTPServer(server))
+	t.Cleanup(httpServer.Close)
+	return httpServer
+}
+
+func streamableServer(mcpId string, url string, enabled bool) *MCPServerInfo {
+	return &MCPServerInfo{
+		McpId:   mcpId,
+		Name:    mcpId,
+		Enabled: enabled,
+		Endpoint: &MCPServerEndpoint{
+			Type: MCPServerEndpointTypeStreamableHttp,
+			Url:  url,
+		},
+		Authorization: MCPServerAuthorization{Method: MCPServerAuthorizationMethodNone},
+	}
+}
+
+func TestRegisterToolsWithStubServers(t *testing.T) {
+	ctx := context.Background()
+	alpha := newStubServer(t, "alpha")
+	beta := newStubServer(t, "beta")
+
+	var disabledHits atomic.Int32
+	disabled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		disabledHits.Add(1)
+		http.Error(w, "should not be contacted", http.StatusInternalServerError)
+	}))
+	defer disabled.Close()
+
+	manager := NewMCPSessionManager(nil)
+	defer manager.Close()
+	llmManager := llm.NewModelManager(&config.SocialConfig{})
+
+	count := manager.RegisterTools(ctx, llmManager, []*MCPServerInfo{
+		streamableServer("alpha", alpha.URL, true),
+		streamableServer("beta", beta.URL, true),
+		streamableServer("off", disabled.URL, false),
+		{McpId: "noendpoint", Enabled: true},
+	})
+	if count != 6 {
+		t.Fatalf("registered %d tools, want 6", count)
+	}
+	if disabledHits.Load() != 0 {
+		t.Fatalf("disabled server was contacted %d times", disabledHits.Load())
+	}
+
+	var names []string
+	for _, tool := range llmManager.GetAvailableTools() {
+		names = append(names, tool.Name)
+		if tool.Parameters["type"] != "object" {
+			t.Errorf("tool %s parameters missing type: %v", tool.Name, tool.Parameters)
+		}
+	}
+	sort.Strings(names)
+	want := []string{
+		"alpha__broken", "alpha__fail", "alpha__lookup",
+		"beta__broken", "beta__fail", "beta__lookup",
+	}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("tool names = %v, want %v", names, want)
+	}
+
+	// 同名工具按命名空间路由到各自的 server
+	for _, mcpId := range []string{"alpha", "beta"} {
+		output, err := llmManager.ExecuteTool(ctx, NamespacedToolName(mcpId, "lookup"), `{"key":"k1"}`)
+		if err != nil {
+			t.Fatalf("%s lookup: %v", mcpId, err)
+		}
+		if output != mcpId+":k1" {
+			t.Errorf("%s lookup = %q, want %q", mcpId, output, mcpId+":k1")
+		}
+	}
+
+	if _, err := llmManager.ExecuteTool(ctx, "alpha__fail", ""); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("alpha__fail error = %v, want tool error containing boom", err)
+	}
+	if _, err := llmManager.ExecuteTool(ctx, "beta__broken", ""); err == nil {
+		t.Error("beta__broken should return an error")
+	}
+	if _, err := llmManager.ExecuteTool(ctx, "alpha__lookup", `not json`); err == nil {
+		t.Error("invalid arguments should return an error")
+	}
+	if _, err := llmManager.ExecuteTool(ctx, "off__lookup", `{"key":"k1"}`); err == nil {
+		t.Error("tools of disabled servers should not be registered")
+	}
+}
+
+func TestRegisterToolsSkipsUnreachableServer(t *testing.T) {
+	alpha := newStubServer(t, "alpha")
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	manager := NewMCPSessionManager(nil)
+	defer manager.Close()
+	llmManager := llm.NewModelManager(&config.SocialConfig{})
+
+	count := manager.RegisterTools(context.Background(), llmManager, []*MCPServerInfo{
+		streamableServer("down", unreachable.URL, true),
+		streamableServer("alpha", alpha.URL, true),
+	})
+	if count != 3 {
+		t.Fatalf("registered %d tools, want 3", count)
+	}
+}
+
+func TestParseNamespacedToolName(t *testing.T) {
+	tests := []struct {
+		name   string
+		mcpId  string
+		tool   string
+		wantOK bool
+	}{
+		{name: "alpha__lookup", mcpId: "alpha", tool: "lookup", wantOK: true},
+		{name: "alpha__ns__tool", mcpId: "alpha", tool: "ns__tool", wantOK: true},
+		{name: "lookup"},
+		{name: "__lookup"},
+		{name: "alpha__"},
+	}
+	for _, tt := range tests {
+		mcpId, tool, ok := ParseNamespacedToolName(tt.name)
+		if ok != tt.wantOK || mcpId != tt.mcpId || tool != tt.tool {
+			t.Errorf("ParseNamespacedToolName(%q) = %q, %q, %v", tt.name, mcpId, tool, ok)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/providers/llm"
+)
+
+const defaultClassifierTimeout = 10 * time.Second
+
+// classifierLabels 模型只能给出这些标签, 其它标签 (包括 ! 开头的系统标签) 一律丢弃
+var classifierLabels = []string{"spam", "sexual", "nudity", "violence", "gore", "hate", "harassment", "self-harm", "illegal", "spoiler"}
+
+var classifierPrompt = `你是社交平台的内容审核员。判断用户发布的内容是否违反社区规范, 只输出 JSON, 不要输出其它内容:
+{"verdict": "allow|label|hold|reject", "labels": ["..."], "reason": "一句话说明"}
+
+verdict 的含义:
+- allow: 正常内容
+- label: 可以发布, 但需要提示观看者, 例如轻度的成人内容、暴力画面描述、剧透
+- hold: 无法确定, 需要人工审核
+- reject: 明显违规, 例如垃圾广告、色情、仇恨言论、骚扰、鼓励自残、违法信息
+
+labels 从以下标签中选择: ` + strings.Join(classifierLabels, ", ")
+
+// Classifier 使用 LLM 对文本分类, 模型输出无法解析时按 hold 处理.
+// 标签只保留 classifierLabels 中的值, 模型的输出不能写入 !hide、!takedown 这类系统标签
+type Classifier struct {
+	llmManager *llm.ModelManager
+	timeout    time.Duration
+}
+
+func NewClassifier(llmManager *llm.ModelManager, timeout time.Duration) *Classifier {
+	if timeout <= 0 {
+		timeout = defaultClassifierTimeout
+	}
+	return &Classifier{
+		llmManager: llmManager,
+		timeout:    timeout,
+	}
+}
+
+func (c *Classifier) Name() string {
+	return "classifier"
+}
+
+type classification struct {
+	Verdict Verdict  `json:"verdict"`
+	Labels  []string `json:"labels"`
+	Reason  string   `json:"reason"`
+}
+
+func (c *Classifier) Check(ctx context.Context, subject *Subject) ([]*Finding, error) {
+	if strings.TrimSpace(subject.Text) == "" {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	result, err := c.llmManager.Chat(ctx, []*llm.PromptMessage{
+		llm.NewSystemPromptMessage(classifierPrompt, "").PromptMessage,
+		{Role: llm.PromptMessageRoleUser, Content: subject.Text},
+	}, map[string]interface{}{"temperature": 0}, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("调用审核模型失败: %w", err)
+	}
+	text := ""
+	if result.Message != nil && result.Message.PromptMessage != nil {
+		text, _ = result.Message.Content.(string)
+	}
+
+	parsed := parseClassification(text)
+	if parsed.Verdict == VerdictAllow {
+		return nil, nil
+	}
+	if len(parsed.Labels) == 0 {
+		return []*Finding{{Checker: c.Name(), Verdict: parsed.Verdict, Reason: parsed.Reason}}, nil
+	}
+	findings := make([]*Finding, 0, len(parsed.Labels))
+	for _, label := range parsed.Labels {
+		findings = append(findings, &Finding{Checker: c.Name(), Verdict: parsed.Verdict, Label: label, Reason: parsed.Reason})
+	}
+	return findings, nil
+}
+
+// parseClassification 解析模型输出, 去掉可能的代码块标记
+func parseClassification(text string) *classification {
+	text = strings.TrimSpace(text)
+	raw := strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(text, "```json"), "```"), "```")
+
+	var parsed classification
+	if err := json.Unmarshal([]byte(strings.TrimSpace(raw)), &parsed); err != nil {
+		return &classification{Verdict: VerdictHold, Reason: "无法解析审核模型的输出"}
+	}
+	if _, err := ParseVerdict(string(parsed.Verdict)); err != nil || parsed.Verdict == "" {
+		return &classification{Verdict: VerdictHold, Reason: "审核模型返回了未知的结论: " + string(parsed.Verdict)}
+	}
+
+	labels := make([]string, 0, len(parsed.Labels))
+	for _, label := range parsed.Labels {
+		label = strings.ToLower(strings.TrimSpace(label))
+		if contains(classifierLabels, label) && !contains(labels, label) {
+			labels = append(labels, label)
+		}
+	}
+	// label 结论必须带有合法的标签, 否则交给人工审核
+	if parsed.Verdict == VerdictLabel && len(labels) == 0 {
+		return &classification{Verdict: VerdictHold, Reason: "审核模型没有返回可用的标签: " + strings.Join(parsed.Labels, ", ")}
+	}
+	parsed.Labels = labels
+	return &parsed
+}
@@ -0,0 +1,175 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/providers/llm"
+)
+
+const (
+	SubjectMoment  = "moment"
+	SubjectMessage = "message"
+)
+
+// 系统标签, 以 ! 开头, 与 ATProto 的约定一致
+const (
+	LabelHide     = "!hide"     // 等待审核, 只有作者可见
+	LabelTakedown = "!takedown" // 审核拒绝, 所有人不可见
+)
+
+var ErrUnknownVerdict = errors.New("未知的审核结论")
+
+// Verdict 审核结论, 按严格程度从低到高排列
+type Verdict string
+
+const (
+	VerdictAllow  Verdict = "allow"
+	VerdictLabel  Verdict = "label"  // 发布并打上标签
+	VerdictHold   Verdict = "hold"   // 保存但不发布, 等待人工审核
+	VerdictReject Verdict = "reject" // 拒绝发布
+)
+
+func (v Verdict) severity() int {
+	switch v {
+	case VerdictLabel:
+		return 1
+	case VerdictHold:
+		return 2
+	case VerdictReject:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// ParseVerdict 配置中未填写时为 reject
+func ParseVerdict(value string) (Verdict, error) {
+	switch Verdict(value) {
+	case "":
+		return VerdictReject, nil
+	case VerdictAllow, VerdictLabel, VerdictHold, VerdictReject:
+		return Verdict(value), nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnknownVerdict, value)
+}
+
+// Subject 待审核的内容
+type Subject struct {
+	Type   string
+	URI    string // moment 的 at-uri 或消息 ID
+	Author string
+	Text   string
+	Links  []string // 富文本注解和外链卡片中的链接, 正文中的链接由检查器自行提取
+	Blobs  []string // 图片 blob 的 CID
+}
+
+// Finding 检查器的一条命中
+type Finding struct {
+	Checker string  `json:"checker"`
+	Verdict Verdict `json:"verdict"`
+	Label   string  `json:"label,omitempty"`
+	Reason  string  `json:"reason"`
+}
+
+// Decision 审核链的结论, Verdict 为所有命中中最严格的一条
+type Decision struct {
+	Verdict  Verdict
+	Labels   []string
+	Findings []*Finding
+}
+
+func (d *Decision) add(findings []*Finding) {
+	for _, finding := range findings {
+		if finding.Verdict.severity() > d.Verdict.severity() {
+			d.Verdict = finding.Verdict
+		}
+		if finding.Label != "" && finding.Verdict != VerdictAllow && !contains(d.Labels, finding.Label) {
+			d.Labels = append(d.Labels, finding.Label)
+		}
+		d.Findings = append(d.Findings, finding)
+	}
+}
+
+// Checker 一个审核检查器, 没有命中时返回空
+type Checker interface {
+	Name() string
+	Check(ctx context.Context, subject *Subject) ([]*Finding, error)
+}
+
+// Chain 按顺序执行检查器, 出现 reject 后不再执行后续检查器.
+// 单个检查器出错时记录日志并跳过, 审核不可用不应阻止发布
+type Chain struct {
+	checkers []Checker
+}
+
+func NewChain(checkers ...Checker) *Chain {
+	return &Chain{checkers: checkers}
+}
+
+// NewChainFromConfig 按配置创建审核链, 未启用时返回不包含检查器的链
+func NewChainFromConfig(cfg *config.SocialConfig) (*Chain, error) {
+	moderationConfig := cfg.Moderation
+	if !moderationConfig.Enabled {
+		return NewChain(), nil
+	}
+
+	var checkers []Checker
+	if len(moderationConfig.Keywords) > 0 {
+		checker, err := NewKeywordChecker(moderationConfig.Keywords)
+		if err != nil {
+			return nil, err
+		}
+		checkers = append(checkers, checker)
+	}
+	if len(moderationConfig.Domains) > 0 {
+		checker, err := NewDomainChecker(moderationConfig.Domains)
+		if err != nil {
+			return nil, err
+		}
+		checkers = append(checkers, checker)
+	}
+	if len(moderationConfig.ImageHashes) > 0 {
+		checker, err := NewImageHashChecker(moderationConfig.ImageHashes)
+		if err != nil {
+			return nil, err
+		}
+		checkers = append(checkers, checker)
+	}
+	if moderationConfig.Classifier.Enabled {
+		checkers = append(checkers, NewClassifier(llm.NewModelManager(cfg), moderationConfig.Classifier.Timeout))
+	}
+	return NewChain(checkers...), nil
+}
+
+func (c *Chain) Enabled() bool {
+	return len(c.checkers) > 0
+}
+
+func (c *Chain) Moderate(ctx context.Context, subject *Subject) *Decision {
+	decision := &Decision{Verdict: VerdictAllow}
+	for _, checker := range c.checkers {
+		findings, err := checker.Check(ctx, subject)
+		if err != nil {
+			logrus.Warnf("审核检查器 %s 执行失败, 跳过: %v", checker.Name(), err)
+			continue
+		}
+		decision.add(findings)
+		if decision.Verdict == VerdictReject {
+			break
+		}
+	}
+	return decision
+}
+
+func contains(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package moderation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+)
+
+type stubChecker struct {
+	name     string
+	findings []*Finding
+	err      error
+	calls    int
+}
+
+func (c *stubChecker) Name() string {
+	return c.name
+}
+
+func (c *stubChecker) Check(ctx context.Context, subject *Subject) ([]*Finding, error) {
+	c.calls++
+	return c.findings, c.err
+}
+
+func finding(checker string, verdict Verdict, label string) *Finding {
+	return &Finding{Checker: checker, Verdict: verdict, Label: label}
+}
+
+func TestChainKeepsStrictestVerdict(t *testing.T) {
+	ctx := context.Background()
+	subject := &Subject{Type: SubjectMoment, Text: "hello"}
+
+	if decision := NewChain().Moderate(ctx, subject); decision.Verdict != VerdictAllow || len(decision.Findings) != 0 {
+		t.Fatalf("empty chain = %+v, want allow", decision)
+	}
+
+	broken := &stubChecker{name: "broken", err: errors.New("unavailable")}
+	labels := &stubChecker{name: "labels", findings: []*Finding{finding("labels", VerdictLabel, "spoiler"), finding("labels", VerdictLabel, "violence")}}
+	hold := &stubChecker{name: "hold", findings: []*Finding{finding("hold", VerdictHold, "spoiler")}}
+	decision := NewChain(broken, labels, hold).Moderate(ctx, subject)
+	// 出错的检查器被跳过, 结论取最严格的一条, 标签去重
+	if decision.Verdict != VerdictHold || strings.Join(decision.Labels, ",") != "spoiler,violence" || len(decision.Findings) != 3 {
+		t.Fatalf("decision = %s %v with %d findings, want hold spoiler,violence with 3", decision.Verdict, decision.Labels, len(decision.Findings))
+	}
+
+	// reject 之后不再执行后续检查器
+	reject := &stubChecker{name: "reject", findings: []*Finding{finding("reject", VerdictReject, "spam")}}
+	after := &stubChecker{name: "after", findings: []*Finding{finding("after", VerdictLabel, "gore")}}
+	decision = NewChain(labels, reject, after).Moderate(ctx, subject)
+	if decision.Verdict != VerdictReject || after.calls != 0 || contains(decision.Labels, "gore") {
+		t.Fatalf("decision = %s %v, after called %d times, want reject without later checkers", decision.Verdict, decision.Labels, after.calls)
+	}
+
+	// allow 命中不带标签
+	allow := &stubChecker{name: "allow", findings: []*Finding{finding("allow", VerdictAllow, "fine")}}
+	if decision := NewChain(allow).Moderate(ctx, subject); decision.Verdict != VerdictAllow || len(decision.Labels) != 0 {
+		t.Fatalf("allow decision = %s %v", decision.Verdict, decision.Labels)
+	}
+}
+
+func TestRuleCheckers(t *testing.T) {
+	ctx := context.Background()
+	image := sha256.Sum256([]byte("image"))
+	chain, err := NewChainFromConfig(&config.SocialConfig{Moderation: config.ModerationConfig{
+		Enabled: true,
+		Keywords: []config.ModerationRuleConfig{
+			{Pattern: "Buy Now", Verdict: "hold"},
+			{Pattern: `/\d{11}/`, Verdict: "label", Label: "phone"},
+		},
+		Domains:     []config.ModerationRuleConfig{{Pattern: "*.spam.example"}},
+		ImageHashes: []config.ModerationRuleConfig{{Pattern: hex.EncodeToString(image[:]), Verdict: "hold"}},
+	}})
+	if err != nil {
+		t.Fatalf("new chain: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		subject *Subject
+		verdict Verdict
+		labels  string
+	}{
+		{"clean", &Subject{Text: "see you tomorrow", Links: []string{"https://example.com/a"}}, VerdictAllow, ""},
+		{"keyword ignores case", &Subject{Text: "BUY NOW please"}, VerdictHold, "sensitive"},
+		{"regexp", &Subject{Text: "call 13800000000"}, VerdictLabel, "phone"},
+		{"domain in text matches subdomains", &Subject{Text: "go to https://www.spam.example/x"}, VerdictReject, "blocked-link"},
+		{"domain in links", &Subject{Links: []string{"spam.example"}}, VerdictReject, "blocked-link"},
+		{"similar domain", &Subject{Text: "https://notspam.example"}, VerdictAllow, ""},
+		{"image hash", &Subject{Blobs: []string{hex.EncodeToString(image[:])}}, VerdictHold, "blocked-image"},
+	}
+	for _, tt := range tests {
+		decision := chain.Moderate(ctx, tt.subject)
+		if decision.Verdict != tt.verdict || strings.Join(decision.Labels, ",") != tt.labels {
+			t.Errorf("%s: decision = %s %v, want %s %q", tt.name, decision.Verdict, decision.Labels, tt.verdict, tt.labels)
+		}
+	}
+
+	if _, err := NewChainFromConfig(&config.SocialConfig{Moderation: config.ModerationConfig{
+		Enabled:  true,
+		Keywords: []config.ModerationRuleConfig{{Pattern: "x", Verdict: "ban"}},
+	}}); !errors.Is(err, ErrUnknownVerdict) {
+		t.Errorf("unknown verdict = %v, want ErrUnknownVerdict", err)
+	}
+}
+
+func TestParseClassificationWhitelistsLabels(t *testing.T) {
+	tests := []struct {
+		output  string
+		verdict Verdict
+		labels  string
+	}{
+		{`{"verdict":"allow"}`, VerdictAllow, ""},
+		{"```json\n{\"verdict\":\"label\",\"labels\":[\"Spoiler\",\"spoiler\"]}\n```", VerdictLabel, "spoiler"},
+		// 系统标签和未知标签被丢弃
+		{`{"verdict":"reject","labels":["spam","!takedown","!hide","custom"]}`, VerdictReject, "spam"},
+		{`{"verdict":"label","labels":["!hide"]}`, VerdictHold, ""},
+		{`{"verdict":"label"}`, VerdictHold, ""},
+		{`{"verdict":"hold","labels":["!takedown"]}`, VerdictHold, ""},
+		{`{"verdict":"ban"}`, VerdictHold, ""},
+		{`not json`, VerdictHold, ""},
+	}
+	for _, tt := range tests {
+		parsed := parseClassification(tt.output)
+		if parsed.Verdict != tt.verdict || strings.Join(parsed.Labels, ",") != tt.labels {
+			t.Errorf("parse %q = %s %v, want %s %q", tt.output, parsed.Verdict, parsed.Labels, tt.verdict, tt.labels)
+		}
+	}
+}
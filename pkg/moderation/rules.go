@@ -0,0 +1,205 @@
+package moderation
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+)
+
+// 未配置标签时各检查器使用的默认标签
+const (
+	defaultKeywordLabel = "sensitive"
+	defaultDomainLabel  = "blocked-link"
+	defaultImageLabel   = "blocked-image"
+)
+
+var linkPattern = regexp.MustCompile(`(?i)https?://[^\s<>"'）)]+`)
+
+type rule struct {
+	pattern string
+	regexp  *regexp.Regexp // 只用于关键词规则
+	verdict Verdict
+	label   string
+}
+
+func newRule(cfg config.ModerationRuleConfig, defaultLabel string) (*rule, error) {
+	verdict, err := ParseVerdict(cfg.Verdict)
+	if err != nil {
+		return nil, fmt.Errorf("审核规则 %s: %w", cfg.Pattern, err)
+	}
+	label := cfg.Label
+	if label == "" {
+		label = defaultLabel
+	}
+	return &rule{pattern: cfg.Pattern, verdict: verdict, label: label}, nil
+}
+
+func (r *rule) finding(checker string, reason string) *Finding {
+	return &Finding{Checker: checker, Verdict: r.verdict, Label: r.label, Reason: reason}
+}
+
+// KeywordChecker 按关键词或正则匹配正文, 关键词不区分大小写
+type KeywordChecker struct {
+	rules []*rule
+}
+
+func NewKeywordChecker(configs []config.ModerationRuleConfig) (*KeywordChecker, error) {
+	checker := &KeywordChecker{}
+	for _, cfg := range configs {
+		r, err := newRule(cfg, defaultKeywordLabel)
+		if err != nil {
+			return nil, err
+		}
+		if len(cfg.Pattern) > 2 && strings.HasPrefix(cfg.Pattern, "/") && strings.HasSuffix(cfg.Pattern, "/") {
+			if r.regexp, err = regexp.Compile("(?i)" + cfg.Pattern[1:len(cfg.Pattern)-1]); err != nil {
+				return nil, fmt.Errorf("编译审核正则 %s 失败: %w", cfg.Pattern, err)
+			}
+		} else if r.pattern = strings.ToLower(cfg.Pattern); r.pattern == "" {
+			continue
+		}
+		checker.rules = append(checker.rules, r)
+	}
+	return checker, nil
+}
+
+func (c *KeywordChecker) Name() string {
+	return "keyword"
+}
+
+func (c *KeywordChecker) Check(ctx context.Context, subject *Subject) ([]*Finding, error) {
+	if subject.Text == "" {
+		return nil, nil
+	}
+	var findings []*Finding
+	text := strings.ToLower(subject.Text)
+	for _, r := range c.rules {
+		if r.regexp != nil {
+			if match := r.regexp.FindString(subject.Text); match != "" {
+				findings = append(findings, r.finding(c.Name(), "命中规则 "+r.pattern+": "+match))
+			}
+		} else if strings.Contains(text, r.pattern) {
+			findings = append(findings, r.finding(c.Name(), "命中关键词 "+r.pattern))
+		}
+	}
+	return findings, nil
+}
+
+// DomainChecker 检查正文和附带链接的域名, 规则同时匹配子域名
+type DomainChecker struct {
+	domains []*rule
+}
+
+func NewDomainChecker(configs []config.ModerationRuleConfig) (*DomainChecker, error) {
+	checker := &DomainChecker{}
+	for _, cfg := range configs {
+		r, err := newRule(cfg, defaultDomainLabel)
+		if err != nil {
+			return nil, err
+		}
+		r.pattern = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(cfg.Pattern)), "*"), ".")
+		if r.pattern == "" {
+			continue
+		}
+		checker.domains = append(checker.domains, r)
+	}
+	return checker, nil
+}
+
+func (c *DomainChecker) Name() string {
+	return "domain"
+}
+
+func (c *DomainChecker) Check(ctx context.Context, subject *Subject) ([]*Finding, error) {
+	links := append(linkPattern.FindAllString(subject.Text, -1), subject.Links...)
+	seen := make(map[string]bool)
+	var findings []*Finding
+	for _, link := range links {
+		host := linkHost(link)
+		if host == "" || seen[host] {
+			continue
+		}
+		seen[host] = true
+		for _, r := range c.domains {
+			if host == r.pattern || strings.HasSuffix(host, "."+r.pattern) {
+				findings = append(findings, r.finding(c.Name(), "链接域名 "+host+" 在屏蔽列表中"))
+				break
+			}
+		}
+	}
+	return findings, nil
+}
+
+func linkHost(link string) string {
+	if !strings.Contains(link, "://") {
+		link = "https://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+}
+
+// ImageHashChecker 按内容哈希匹配上传的图片. blob 的 CID 本身包含 sha256 摘要,
+// 因此既可以配置 CID, 也可以配置文件的 sha256, 无需下载图片
+type ImageHashChecker struct {
+	hashes map[string]*rule
+}
+
+func NewImageHashChecker(configs []config.ModerationRuleConfig) (*ImageHashChecker, error) {
+	checker := &ImageHashChecker{hashes: make(map[string]*rule)}
+	for _, cfg := range configs {
+		r, err := newRule(cfg, defaultImageLabel)
+		if err != nil {
+			return nil, err
+		}
+		key := blobDigest(strings.TrimSpace(cfg.Pattern))
+		if key == "" {
+			continue
+		}
+		checker.hashes[key] = r
+	}
+	return checker, nil
+}
+
+func (c *ImageHashChecker) Name() string {
+	return "image_hash"
+}
+
+func (c *ImageHashChecker) Check(ctx context.Context, subject *Subject) ([]*Finding, error) {
+	var findings []*Finding
+	for _, blob := range subject.Blobs {
+		if r, ok := c.hashes[blobDigest(blob)]; ok {
+			findings = append(findings, r.finding(c.Name(), "图片 "+blob+" 在屏蔽列表中"))
+		}
+	}
+	return findings, nil
+}
+
+// blobDigest 将 CID 转换为其中的 sha256 摘要, 其他值按小写的 hex 处理.
+// 64 位的 hex 优先按 sha256 处理, 避免以 f 开头时被当作 base16 的 CID
+func blobDigest(value string) string {
+	if value == "" {
+		return ""
+	}
+	if len(value) == 64 {
+		if _, err := hex.DecodeString(value); err == nil {
+			return strings.ToLower(value)
+		}
+	}
+	if c, err := cid.Decode(value); err == nil {
+		decoded, err := multihash.Decode(c.Hash())
+		if err == nil && decoded.Code == multihash.SHA2_256 {
+			return hex.EncodeToString(decoded.Digest)
+		}
+		return c.String()
+	}
+	return strings.ToLower(value)
+}
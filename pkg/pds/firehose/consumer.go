@@ -0,0 +1,263 @@
+package firehose
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+)
+
+const (
+	ModeRepos     = "repos"
+	ModeJetstream = "jetstream"
+)
+
+const (
+	DefaultReconnectDelay = time.Second
+	DefaultCursorInterval = 5 * time.Second
+
+	maxReconnectDelay = time.Minute
+	readTimeout       = time.Minute
+)
+
+// Consumer 订阅 relay 的 subscribeRepos 或 Jetstream, 将 app.vtri.* 记录交给 Indexer 写入本地.
+// 消费进度按订阅源保存在 ingest_cursors 中, 重启或断线后从保存的游标继续
+type Consumer struct {
+	metaStore *repositories.MetaStore
+	config    config.FirehoseConfig
+	decoder   FrameDecoder
+	indexer   *Indexer
+	source    string
+
+	cursor   atomic.Int64
+	recorder *FrameWriter
+
+	mu      sync.Mutex
+	running bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func NewConsumer(metaStore *repositories.MetaStore, cfg config.FirehoseConfig) (*Consumer, error) {
+	if cfg.ReconnectDelay <= 0 {
+		cfg.ReconnectDelay = DefaultReconnectDelay
+	}
+	if cfg.CursorInterval <= 0 {
+		cfg.CursorInterval = DefaultCursorInterval
+	}
+
+	var decoder FrameDecoder
+	switch cfg.Mode {
+	case ModeRepos:
+		decoder = &ReposDecoder{}
+	case ModeJetstream, "":
+		decoder = &JetstreamDecoder{}
+	default:
+		return nil, fmt.Errorf("不支持的订阅模式: %s", cfg.Mode)
+	}
+
+	return &Consumer{
+		metaStore: metaStore,
+		config:    cfg,
+		decoder:   decoder,
+		indexer:   NewIndexer(metaStore),
+		source:    decoder.Mode() + ":" + cfg.URL,
+	}, nil
+}
+
+// SetCursor 覆盖保存的游标, 需要在 Start 之前调用
+func (c *Consumer) SetCursor(cursor int64) {
+	c.cursor.Store(cursor)
+}
+
+// SetRecorder 将收到的原始帧写入 w, 用于录制回放文件
+func (c *Consumer) SetRecorder(w io.Writer) {
+	c.recorder = NewFrameWriter(w)
+}
+
+// Start 启动订阅
+func (c *Consumer) Start() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.running {
+		return fmt.Errorf("订阅已经在运行")
+	}
+	if c.config.URL == "" {
+		return fmt.Errorf("未配置订阅地址")
+	}
+
+	if c.cursor.Load() == 0 {
+		cursor, err := c.metaStore.AtpRepo.GetIngestCursor(c.source)
+		if err != nil {
+			return fmt.Errorf("读取订阅游标失败: %w", err)
+		}
+		c.cursor.Store(cursor)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.done = make(chan struct{})
+	c.running = true
+
+	go c.run(ctx, c.done)
+	logrus.Infof("订阅 %s 已启动, 模式 %s, 游标 %d", c.config.URL, c.decoder.Mode(), c.cursor.Load())
+	return nil
+}
+
+// Stop 停止订阅并保存游标
+func (c *Consumer) Stop() {
+	c.mu.Lock()
+	if !c.running {
+		c.mu.Unlock()
+		return
+	}
+	c.running = false
+	c.cancel()
+	done := c.done
+	c.mu.Unlock()
+
+	<-done
+	logrus.Info("订阅已停止")
+}
+
+func (c *Consumer) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	go c.flushCursorLoop(ctx)
+	defer c.flushCursor()
+
+	delay := c.config.ReconnectDelay
+	for ctx.Err() == nil {
+		connected, err := c.subscribe(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			delay = c.config.ReconnectDelay
+		}
+		logrus.Warnf("订阅连接断开: %v, %s 后重连", err, delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// subscribe 建立一次连接并持续读取帧, 返回是否连接成功过
+func (c *Consumer) subscribe(ctx context.Context) (bool, error) {
+	subscribeURL, err := c.decoder.SubscribeURL(c.config.URL, c.cursor.Load())
+	if err != nil {
+		return false, fmt.Errorf("构造订阅地址失败: %w", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, subscribeURL, nil)
+	if err != nil {
+		return false, fmt.Errorf("连接 %s 失败: %w", subscribeURL, err)
+	}
+	defer conn.Close()
+
+	// 退出时关闭连接, 让阻塞中的 ReadMessage 返回
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+			return true, err
+		}
+		_, frame, err := conn.ReadMessage()
+		if err != nil {
+			return true, err
+		}
+		if c.recorder != nil {
+			if err := c.recorder.WriteFrame(frame); err != nil {
+				logrus.Errorf("录制帧失败: %v", err)
+			}
+		}
+		if err := c.HandleFrame(ctx, frame); err != nil {
+			return true, err
+		}
+	}
+}
+
+// HandleFrame 解码一帧并索引其中的记录. 无法解码的帧和单条记录索引失败只记录日志并跳过,
+// 不阻塞后续消费; 只有订阅源返回的错误帧会中断当前连接
+func (c *Consumer) HandleFrame(ctx context.Context, frame []byte) error {
+	events, cursor, err := c.decoder.Decode(ctx, frame)
+	if errors.Is(err, ErrStreamError) {
+		return err
+	}
+	if err != nil {
+		logrus.Warnf("跳过无法解码的帧 (游标 %d): %v", cursor, err)
+		if cursor > 0 {
+			c.cursor.Store(cursor)
+		}
+		return nil
+	}
+	for _, event := range events {
+		if err := c.indexer.Index(event); err != nil {
+			logrus.Errorf("%v", err)
+		}
+	}
+	if cursor > 0 {
+		c.cursor.Store(cursor)
+	}
+	return nil
+}
+
+// Replay 依次处理录制文件中的帧, 不更新保存的游标
+func (c *Consumer) Replay(ctx context.Context, r io.Reader) (int, error) {
+	reader := NewFrameReader(r)
+	count := 0
+	for ctx.Err() == nil {
+		frame, err := reader.ReadFrame()
+		if errors.Is(err, io.EOF) {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		if err := c.HandleFrame(ctx, frame); err != nil {
+			logrus.Warnf("回放第 %d 帧失败: %v", count+1, err)
+		}
+		count++
+	}
+	return count, ctx.Err()
+}
+
+func (c *Consumer) flushCursorLoop(ctx context.Context) {
+	ticker := time.NewTicker(c.config.CursorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.flushCursor()
+		}
+	}
+}
+
+func (c *Consumer) flushCursor() {
+	cursor := c.cursor.Load()
+	if cursor == 0 {
+		return
+	}
+	if err := c.metaStore.AtpRepo.SaveIngestCursor(c.source, cursor); err != nil {
+		logrus.Errorf("保存订阅游标失败: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package firehose
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/data"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/ipfs/go-cid"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+)
+
+// reposFrame 按 subscribeRepos 的格式拼接 CBOR 帧头和消息体
+func reposFrame(t *testing.T, op int64, msgType string, body []byte) []byte {
+	t.Helper()
+	header := map[string]any{"op": op}
+	if msgType != "" {
+		header["t"] = msgType
+	}
+	raw, err := data.MarshalCBOR(header)
+	if err != nil {
+		t.Fatalf("marshal header: %v", err)
+	}
+	return append(raw, body...)
+}
+
+func TestHandleFrameSkipsUndecodableFrames(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("jetstream", func(t *testing.T) {
+		consumer, err := NewConsumer(newTestMetaStore(t), config.FirehoseConfig{Mode: ModeJetstream, URL: "wss://jetstream.test/subscribe"})
+		if err != nil {
+			t.Fatalf("new consumer: %v", err)
+		}
+		consumer.SetCursor(5)
+
+		if err := consumer.HandleFrame(ctx, []byte(`{"did":`)); err != nil {
+			t.Fatalf("undecodable frame returned %v, want it skipped", err)
+		}
+		if cursor := consumer.cursor.Load(); cursor != 5 {
+			t.Fatalf("cursor = %d after a frame without cursor, want 5", cursor)
+		}
+		if err := consumer.HandleFrame(ctx, []byte(`{"did":"did:plc:alice","time_us":10,"kind":"identity"}`)); err != nil {
+			t.Fatalf("identity frame: %v", err)
+		}
+		if cursor := consumer.cursor.Load(); cursor != 10 {
+			t.Fatalf("cursor = %d, want 10", cursor)
+		}
+	})
+
+	t.Run("repos", func(t *testing.T) {
+		consumer, err := NewConsumer(newTestMetaStore(t), config.FirehoseConfig{Mode: ModeRepos, URL: "wss://relay.test"})
+		if err != nil {
+			t.Fatalf("new consumer: %v", err)
+		}
+
+		// 提交的 CAR 无法读取时跳过该帧, 游标仍然前进到它的 seq
+		commitCID, err := cid.Decode("bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm")
+		if err != nil {
+			t.Fatalf("decode cid: %v", err)
+		}
+		var body bytes.Buffer
+		commit := &comatproto.SyncSubscribeRepos_Commit{
+			Commit: lexutil.LexLink(commitCID),
+			Repo:   "did:plc:alice",
+			Seq:    42,
+			Rev:    "3l",
+			Time:   "2024-10-27T00:00:00Z",
+			Ops:    []*comatproto.SyncSubscribeRepos_RepoOp{{Action: ActionDelete, Path: "app.vtri.activity.moment/3lalice1"}},
+			Blocks: []byte("not a car"),
+		}
+		if err := commit.MarshalCBOR(&body); err != nil {
+			t.Fatalf("marshal commit: %v", err)
+		}
+		if err := consumer.HandleFrame(ctx, reposFrame(t, 1, "#commit", body.Bytes())); err != nil {
+			t.Fatalf("commit with broken CAR returned %v, want it skipped", err)
+		}
+		if cursor := consumer.cursor.Load(); cursor != 42 {
+			t.Fatalf("cursor = %d, want 42", cursor)
+		}
+
+		if err := consumer.HandleFrame(ctx, reposFrame(t, 1, "#identity", []byte{0xff})); err != nil {
+			t.Fatalf("truncated identity returned %v, want it skipped", err)
+		}
+		if err := consumer.HandleFrame(ctx, []byte{0xff}); err != nil {
+			t.Fatalf("broken header returned %v, want it skipped", err)
+		}
+		if cursor := consumer.cursor.Load(); cursor != 42 {
+			t.Fatalf("cursor = %d after frames without seq, want 42", cursor)
+		}
+
+		// 只有错误帧断开连接
+		errorBody, _ := data.MarshalCBOR(map[string]any{"error": "FutureCursor", "message": "cursor in the future"})
+		err = consumer.HandleFrame(ctx, reposFrame(t, -1, "", errorBody))
+		if !errors.Is(err, ErrStreamError) {
+			t.Fatalf("error frame returned %v, want ErrStreamError", err)
+		}
+	})
+}
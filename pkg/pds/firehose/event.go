@@ -0,0 +1,76 @@
+package firehose
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/lex/util"
+)
+
+// CollectionPrefix 只索引 app.vtri.* 下的记录
+const CollectionPrefix = "app.vtri."
+
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// RecordEvent 订阅源中一条记录的变更, 与订阅模式无关
+type RecordEvent struct {
+	Did        string
+	Collection string
+	Rkey       string
+	Action     string
+	CID        string
+	Record     any    // 已注册 lexicon 的记录解码为 vtri 类型, delete 或未知类型时为 nil
+	JSON       []byte // 记录的 JSON 表示, delete 时为空
+	Time       time.Time
+}
+
+func (e *RecordEvent) URI() string {
+	return fmt.Sprintf("at://%s/%s/%s", e.Did, e.Collection, e.Rkey)
+}
+
+func wantCollection(collection string) bool {
+	return strings.HasPrefix(collection, CollectionPrefix)
+}
+
+// decodeRecordCBOR 用 cbor_gen 生成的解码器解析记录, 未注册的类型按通用数据模型转换为 JSON
+func decodeRecordCBOR(raw []byte) (any, []byte, error) {
+	if record, err := util.CborDecodeValue(raw); err == nil {
+		body, err := json.Marshal(record)
+		if err != nil {
+			return nil, nil, fmt.Errorf("序列化记录失败: %w", err)
+		}
+		return record, body, nil
+	}
+	generic, err := data.UnmarshalCBOR(raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("解析记录失败: %w", err)
+	}
+	body, err := json.Marshal(generic)
+	if err != nil {
+		return nil, nil, fmt.Errorf("序列化记录失败: %w", err)
+	}
+	return nil, body, nil
+}
+
+// decodeRecordJSON 按 $type 将 JSON 记录解码为 vtri 类型, 未注册的类型只保留 JSON
+func decodeRecordJSON(raw []byte) (any, []byte) {
+	record, err := util.JsonDecodeValue(raw)
+	if err != nil {
+		return nil, raw
+	}
+	return record, raw
+}
+
+func parseEventTime(value string) time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t
+	}
+	return time.Now()
+}
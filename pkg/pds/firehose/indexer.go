@@ -0,0 +1,604 @@
+package firehose
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/sirupsen/logrus"
+	"github.com/zhongshangwu/avatarai-social/pkg/atproto/helper"
+	"github.com/zhongshangwu/avatarai-social/pkg/atproto/vtri"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/search"
+	"github.com/zhongshangwu/avatarai-social/pkg/services"
+	"gorm.io/gorm"
+)
+
+const (
+	momentCollection = "app.vtri.activity.moment"
+	likeCollection   = "app.vtri.activity.like"
+	tagCollection    = "app.vtri.activity.tag"
+	topicCollection  = "app.vtri.activity.topic"
+
+	relationshipCollection = "app.vtri.activity.relationship"
+)
+
+// ErrNotRecordOwner 记录对应的本地行属于其他用户, 事件不能修改它
+var ErrNotRecordOwner = errors.New("记录不属于事件所在的仓库")
+
+// Indexer 将记录事件写入本地表: 所有 app.vtri.* 记录保存到 atp_records,
+// moment、点赞、标签、主题另外写入对应的业务表. 事件可以重复投递, 写入都是幂等的.
+// 业务表以 rkey 作为主键, 但不同仓库可以使用相同的 rkey, 因此事件始终按 URI 找到对应的行
+type Indexer struct {
+	metaStore *repositories.MetaStore
+}
+
+func NewIndexer(metaStore *repositories.MetaStore) *Indexer {
+	return &Indexer{metaStore: metaStore}
+}
+
+func (i *Indexer) Index(event *RecordEvent) error {
+	uri := event.URI()
+	atpRepo := i.metaStore.AtpRepo
+
+	// 删除前取出旧记录, 用来找到标签/主题关联的内容
+	var previous []byte
+	if event.Action == ActionDelete {
+		if record, err := atpRepo.GetAtpRecord(uri); err == nil {
+			previous = []byte(record.JSON)
+		}
+	}
+
+	var err error
+	switch event.Collection {
+	case momentCollection:
+		err = i.indexMoment(event)
+	case likeCollection:
+		err = i.indexLike(event)
+	case tagCollection:
+		err = i.indexTag(event, previous)
+	case topicCollection:
+		err = i.indexTopic(event, previous)
+	case relationshipCollection:
+		err = i.indexRelationship(event)
+	}
+	if err != nil {
+		return fmt.Errorf("索引记录 %s 失败: %w", uri, err)
+	}
+
+	if event.Action == ActionDelete {
+		return atpRepo.DeleteAtpRecord(uri)
+	}
+	return atpRepo.InsertOrUpdateAtpRecord(&repositories.AtpRecord{
+		URI:       uri,
+		CID:       event.CID,
+		Did:       event.Did,
+		JSON:      string(event.JSON),
+		IndexedAt: time.Now().Format(time.RFC3339),
+	})
+}
+
+func (i *Indexer) indexMoment(event *RecordEvent) error {
+	momentRepo := i.metaStore.MomentRepo
+	uri := event.URI()
+
+	existing, err := momentRepo.GetMomentByURI(uri)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if existing != nil && existing.Creator != event.Did {
+		return ErrNotRecordOwner
+	}
+
+	if event.Action == ActionDelete {
+		if existing == nil {
+			return nil
+		}
+		if err := momentRepo.DeleteMomentEmbeds(existing.ID); err != nil {
+			return err
+		}
+		if err := momentRepo.DeleteActivityTagsBySubjectURI(uri); err != nil {
+			return err
+		}
+		if err := momentRepo.DeleteMoment(uri); err != nil {
+			return err
+		}
+		i.refreshReplyParentAgg(existing.ReplyParentID)
+		services.RetractNotifications(i.metaStore, uri)
+		services.RemoveFromSearch(i.metaStore, search.KindMoment, uri)
+		return nil
+	}
+
+	record, ok := event.Record.(*vtri.ActivityMoment)
+	if !ok {
+		return fmt.Errorf("记录不是有效的 moment")
+	}
+
+	// 本地用户通过同步器写入的记录已经在本地, CID 一致时无需处理
+	if existing != nil && existing.CID == event.CID {
+		return nil
+	}
+
+	// indexed_at 是 feed 的排序键, 更新记录时保留首次索引的时间
+	now := time.Now().Unix()
+	indexedAt := now
+	var id string
+	if existing != nil {
+		indexedAt = existing.IndexedAt
+		id = existing.ID
+	} else if id, err = freeRowID(event.Rkey, func(id string) error {
+		_, err := momentRepo.GetMomentByID(id)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	facets, err := json.Marshal(record.Facets)
+	if err != nil {
+		return fmt.Errorf("序列化富文本注解失败: %w", err)
+	}
+	moment := &repositories.Moment{
+		ID:        id,
+		URI:       uri,
+		CID:       event.CID,
+		Text:      record.Text,
+		Facets:    string(facets),
+		Langs:     record.Langs,
+		Tags:      record.Tags,
+		CreatedAt: recordTime(record.CreatedAt, event.Time),
+		UpdatedAt: now,
+		IndexedAt: indexedAt,
+		Creator:   event.Did,
+	}
+	if record.Reply != nil {
+		moment.ReplyRootID = i.momentRefID(record.Reply.Root)
+		moment.ReplyParentID = i.momentRefID(record.Reply.Parent)
+	}
+	if err := momentRepo.SaveMoment(moment); err != nil {
+		return err
+	}
+	i.refreshReplyParentAgg(moment.ReplyParentID)
+	services.NotifyMoment(i.metaStore, moment)
+	services.IndexMoment(i.metaStore, moment)
+
+	if err := momentRepo.DeleteMomentEmbeds(moment.ID); err != nil {
+		return err
+	}
+	if err := i.indexMomentEmbed(moment.ID, record.Embed); err != nil {
+		return err
+	}
+
+	if err := momentRepo.DeleteActivityTagsBySubjectURI(uri); err != nil {
+		return err
+	}
+	for _, tag := range record.Tags {
+		if err := i.bindTag(uri, tag, event.Did, moment.CreatedAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *Indexer) indexMomentEmbed(momentID string, embed *vtri.ActivityMoment_Embed) error {
+	if embed == nil {
+		return nil
+	}
+	momentRepo := i.metaStore.MomentRepo
+
+	if embed.EntityImages != nil {
+		for position, image := range embed.EntityImages.Images {
+			if image == nil || image.Image == nil {
+				continue
+			}
+			if err := momentRepo.CreateMomentImage(&repositories.MomentImage{
+				MomentID: momentID,
+				Position: position,
+				ImageCID: image.Image.Ref.String(),
+				Alt:      image.Alt,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	if embed.EntityVideo != nil && embed.EntityVideo.Video != nil {
+		video := &repositories.MomentVideo{
+			MomentID: momentID,
+			VideoCID: embed.EntityVideo.Video.Ref.String(),
+		}
+		if embed.EntityVideo.Alt != nil {
+			video.Alt = *embed.EntityVideo.Alt
+		}
+		if err := momentRepo.CreateMomentVideo(video); err != nil {
+			return err
+		}
+	}
+	if embed.EntityExternal != nil && embed.EntityExternal.External != nil {
+		external := embed.EntityExternal.External
+		dbExternal := &repositories.MomentExternal{
+			MomentID:    momentID,
+			URI:         external.Uri,
+			Title:       external.Title,
+			Description: external.Description,
+		}
+		if external.Thumb != nil {
+			dbExternal.ThumbCID = external.Thumb.Ref.String()
+		}
+		if err := momentRepo.CreateMomentExternal(dbExternal); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *Indexer) indexLike(event *RecordEvent) error {
+	momentRepo := i.metaStore.MomentRepo
+	uri := event.URI()
+
+	existing, err := momentRepo.GetLikeByURI(uri)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if existing != nil && existing.Creator != event.Did {
+		return ErrNotRecordOwner
+	}
+
+	if event.Action == ActionDelete {
+		if existing == nil {
+			return nil
+		}
+		if err := momentRepo.DeleteLike(uri); err != nil {
+			return err
+		}
+		i.refreshMomentAgg(existing.SubjectURI)
+		services.RetractNotifications(i.metaStore, uri)
+		return nil
+	}
+
+	record, ok := event.Record.(*vtri.ActivityLike)
+	if !ok || record.Subject == nil {
+		return fmt.Errorf("记录不是有效的点赞")
+	}
+	var id string
+	if existing != nil {
+		id = existing.ID
+	} else if id, err = freeRowID(event.Rkey, func(id string) error {
+		_, err := momentRepo.GetLikeByID(id)
+		return err
+	}); err != nil {
+		return err
+	}
+	like := &repositories.Like{
+		ID:         id,
+		URI:        uri,
+		CID:        event.CID,
+		Creator:    event.Did,
+		SubjectURI: record.Subject.Uri,
+		SubjectCid: record.Subject.Cid,
+		CreatedAt:  recordTime(record.CreatedAt, event.Time),
+		IndexedAt:  time.Now().Unix(),
+	}
+	if err := momentRepo.SaveLike(like); err != nil {
+		return err
+	}
+	i.refreshMomentAgg(record.Subject.Uri)
+	services.NotifyLike(i.metaStore, like)
+	return nil
+}
+
+// indexTag 标签记录写入标签池; 记录的 creator 引用了同一仓库的 moment 时, 同时建立 moment 与标签的关联
+func (i *Indexer) indexTag(event *RecordEvent, previous []byte) error {
+	momentRepo := i.metaStore.MomentRepo
+	uri := event.URI()
+
+	if event.Action == ActionDelete {
+		var old vtri.ActivityTag
+		if len(previous) > 0 && json.Unmarshal(previous, &old) == nil && old.Tag != nil {
+			if subject := i.ownedMoment(old.Creator, event.Did); subject != "" {
+				if err := momentRepo.DeleteActivityTag(*old.Tag, subject); err != nil {
+					return err
+				}
+			}
+		}
+		if tag, err := momentRepo.GetTagByURI(uri); err == nil {
+			if err := momentRepo.DeleteTag(tag.ID); err != nil {
+				return err
+			}
+			services.RemoveFromSearch(i.metaStore, search.KindTag, tag.Tag)
+		}
+		return nil
+	}
+
+	record, ok := event.Record.(*vtri.ActivityTag)
+	if !ok || record.Tag == nil || *record.Tag == "" {
+		return fmt.Errorf("记录不是有效的标签")
+	}
+	createdAt := recordTime(record.CreatedAt, event.Time)
+
+	if tag, err := momentRepo.GetTagByURI(uri); err == nil {
+		if err := momentRepo.UpdateTag(tag.ID, map[string]interface{}{"cid": event.CID}); err != nil {
+			return err
+		}
+	} else if _, err := momentRepo.GetTagByTag(*record.Tag); errors.Is(err, gorm.ErrRecordNotFound) {
+		id, err := freeRowID(event.Rkey, func(id string) error {
+			_, err := momentRepo.GetTagByID(id)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		tag := &repositories.Tag{
+			ID:        id,
+			URI:       uri,
+			CID:       event.CID,
+			Tag:       *record.Tag,
+			CreatedAt: createdAt,
+			Creator:   event.Did,
+		}
+		if err := momentRepo.CreateTag(tag); err != nil {
+			return err
+		}
+		services.IndexTag(i.metaStore, tag)
+	} else if err != nil {
+		return err
+	}
+
+	if subject := i.ownedMoment(record.Creator, event.Did); subject != "" {
+		if err := momentRepo.DeleteActivityTag(*record.Tag, subject); err != nil {
+			return err
+		}
+		return momentRepo.CreateActivityTag(&repositories.ActivityTag{
+			ID:         helper.GenerateTID(),
+			SubjectURI: subject,
+			Tag:        *record.Tag,
+			CreatedAt:  createdAt,
+			Creator:    event.Did,
+		})
+	}
+	return nil
+}
+
+// indexTopic 主题记录写入主题池; 记录的 creator 引用了同一仓库的 moment 时, 同时建立 moment 与主题的关联
+func (i *Indexer) indexTopic(event *RecordEvent, previous []byte) error {
+	momentRepo := i.metaStore.MomentRepo
+	uri := event.URI()
+
+	if event.Action == ActionDelete {
+		var old vtri.ActivityTopic
+		if len(previous) > 0 && json.Unmarshal(previous, &old) == nil && old.Topic != "" {
+			if subject := i.ownedMoment(old.Creator, event.Did); subject != "" {
+				if err := momentRepo.DeleteActivityTopic(old.Topic, subject); err != nil {
+					return err
+				}
+			}
+		}
+		if topic, err := momentRepo.GetTopicByURI(uri); err == nil {
+			if err := momentRepo.DeleteTopic(topic.ID); err != nil {
+				return err
+			}
+			services.RemoveFromSearch(i.metaStore, search.KindTopic, topic.Topic)
+		}
+		return nil
+	}
+
+	record, ok := event.Record.(*vtri.ActivityTopic)
+	if !ok || record.Topic == "" {
+		return fmt.Errorf("记录不是有效的主题")
+	}
+	createdAt := recordTime(record.CreatedAt, event.Time)
+
+	if topic, err := momentRepo.GetTopicByURI(uri); err == nil {
+		if err := momentRepo.UpdateTopic(topic.ID, map[string]interface{}{"cid": event.CID}); err != nil {
+			return err
+		}
+	} else if _, err := momentRepo.GetTopicByTopic(record.Topic); errors.Is(err, gorm.ErrRecordNotFound) {
+		id, err := freeRowID(event.Rkey, func(id string) error {
+			_, err := momentRepo.GetTopicByID(id)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		topic := &repositories.Topic{
+			ID:        id,
+			URI:       uri,
+			CID:       event.CID,
+			Topic:     record.Topic,
+			CreatedAt: createdAt,
+			Creator:   event.Did,
+		}
+		if err := momentRepo.CreateTopic(topic); err != nil {
+			return err
+		}
+		services.IndexTopic(i.metaStore, topic)
+	} else if err != nil {
+		return err
+	}
+
+	if subject := i.ownedMoment(record.Creator, event.Did); subject != "" {
+		if err := momentRepo.DeleteActivityTopic(record.Topic, subject); err != nil {
+			return err
+		}
+		return momentRepo.CreateActivityTopic(&repositories.ActivityTopic{
+			ID:         helper.GenerateTID(),
+			SubjectURI: subject,
+			Topic:      record.Topic,
+			CreatedAt:  createdAt,
+			Creator:    event.Did,
+		})
+	}
+	return nil
+}
+
+// indexRelationship 关系的发起方固定为仓库所有者, 目标为 object 引用的用户
+func (i *Indexer) indexRelationship(event *RecordEvent) error {
+	graphRepo := i.metaStore.GraphRepo
+	uri := event.URI()
+
+	existing, err := graphRepo.GetRelationshipByURI(uri)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if existing != nil && existing.Creator != event.Did {
+		return ErrNotRecordOwner
+	}
+
+	if event.Action == ActionDelete {
+		if existing == nil {
+			return nil
+		}
+		if err := graphRepo.DeleteRelationship(uri); err != nil {
+			return err
+		}
+		services.RetractNotifications(i.metaStore, uri)
+		return nil
+	}
+
+	record, ok := event.Record.(*vtri.ActivityRelationship)
+	if !ok || record.Object == nil {
+		return fmt.Errorf("记录不是有效的关系")
+	}
+	if record.Predicate != repositories.RelationshipFollow && record.Predicate != repositories.RelationshipBlock {
+		return nil
+	}
+	object, err := helper.BuildAtURI(record.Object.Uri)
+	if err != nil {
+		return fmt.Errorf("无效的关系目标: %w", err)
+	}
+
+	var id string
+	if existing != nil {
+		id = existing.ID
+	} else if id, err = freeRowID(event.Rkey, func(id string) error {
+		_, err := graphRepo.GetRelationshipByID(id)
+		return err
+	}); err != nil {
+		return err
+	}
+	relationship := &repositories.Relationship{
+		ID:        id,
+		URI:       uri,
+		CID:       event.CID,
+		Creator:   event.Did,
+		Predicate: record.Predicate,
+		Object:    object.Authority().String(),
+		CreatedAt: recordTime(record.CreatedAt, event.Time),
+		IndexedAt: time.Now().Unix(),
+	}
+	if err := graphRepo.SaveRelationship(relationship); err != nil {
+		return err
+	}
+	services.NotifyFollow(i.metaStore, relationship)
+	return nil
+}
+
+// bindTag 建立 moment 与标签的关联, 标签池中没有该标签时补充一条 (不写入 PDS)
+func (i *Indexer) bindTag(subjectURI string, tag string, creator string, createdAt int64) error {
+	if tag == "" {
+		return nil
+	}
+	momentRepo := i.metaStore.MomentRepo
+	if _, err := momentRepo.GetTagByTag(tag); errors.Is(err, gorm.ErrRecordNotFound) {
+		newTag := &repositories.Tag{
+			ID:        helper.GenerateTID(),
+			Tag:       tag,
+			CreatedAt: createdAt,
+			Creator:   creator,
+		}
+		if err := momentRepo.CreateTag(newTag); err != nil {
+			return err
+		}
+		services.IndexTag(i.metaStore, newTag)
+	} else if err != nil {
+		return err
+	}
+	return momentRepo.CreateActivityTag(&repositories.ActivityTag{
+		ID:         helper.GenerateTID(),
+		SubjectURI: subjectURI,
+		Tag:        tag,
+		CreatedAt:  createdAt,
+		Creator:    creator,
+	})
+}
+
+// refreshMomentAgg 被点赞或回复的 moment 不在本地时跳过
+func (i *Indexer) refreshMomentAgg(uri string) {
+	err := i.metaStore.MomentRepo.RefreshMomentAgg(uri)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		logrus.Warnf("更新 moment %s 的互动计数失败: %v", uri, err)
+	}
+}
+
+func (i *Indexer) refreshReplyParentAgg(parentID string) {
+	if parentID == "" {
+		return
+	}
+	if parent, err := i.metaStore.MomentRepo.GetMomentByID(parentID); err == nil {
+		i.refreshMomentAgg(parent.URI)
+	}
+}
+
+// momentRefID 本地 moment 的回复关系按 moment ID 保存. 被回复的 moment 已在本地时使用其 ID,
+// 否则使用 rkey, 与之后索引到的同一 moment 对应
+func (i *Indexer) momentRefID(ref *comatproto.RepoStrongRef) string {
+	if ref == nil {
+		return ""
+	}
+	if moment, err := i.metaStore.MomentRepo.GetMomentByURI(ref.Uri); err == nil {
+		return moment.ID
+	}
+	aturi, err := helper.BuildAtURI(ref.Uri)
+	if err != nil {
+		return ""
+	}
+	return aturi.RecordKey().String()
+}
+
+// ownedMoment 引用指向本地已有且属于 did 的 moment 时返回其 URI, 其他用户的 moment 不能被绑定或解绑
+func (i *Indexer) ownedMoment(ref *comatproto.RepoStrongRef, did string) string {
+	subject := momentRef(ref)
+	if subject == "" {
+		return ""
+	}
+	moment, err := i.metaStore.MomentRepo.GetMomentByURI(subject)
+	if err != nil || moment.Creator != did {
+		return ""
+	}
+	return subject
+}
+
+// freeRowID 新记录优先使用 rkey 作为行 ID, rkey 已被其他记录占用时生成新的 ID.
+// lookup 按 ID 查找已有的行, 未找到时返回 gorm.ErrRecordNotFound
+func freeRowID(rkey string, lookup func(id string) error) (string, error) {
+	id := rkey
+	for {
+		err := lookup(id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return id, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		id = helper.GenerateTID()
+	}
+}
+
+// momentRef 引用指向 moment 时返回其 URI
+func momentRef(ref *comatproto.RepoStrongRef) string {
+	if ref == nil {
+		return ""
+	}
+	aturi, err := helper.BuildAtURI(ref.Uri)
+	if err != nil || aturi.Collection().String() != momentCollection {
+		return ""
+	}
+	return ref.Uri
+}
+
+func recordTime(value string, fallback time.Time) int64 {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t.Unix()
+	}
+	return fallback.Unix()
+}
@@ -0,0 +1,113 @@
+package firehose
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/zhongshangwu/avatarai-social/pkg/atproto/vtri"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+)
+
+func TestIndexerRefusesRowOwnedByAnotherUser(t *testing.T) {
+	store := newTestMetaStore(t)
+	uri := "at://did:plc:mallory/app.vtri.activity.moment/3kmoment1"
+	// 行的 URI 与作者不一致时, 事件既不能修改也不能删除它
+	if err := store.MomentRepo.CreateMoment(&repositories.Moment{ID: "3kmoment1", URI: uri, CID: "bafylocal", Text: "local", Creator: "did:plc:alice"}); err != nil {
+		t.Fatalf("create moment: %v", err)
+	}
+	indexer := NewIndexer(store)
+
+	for _, action := range []string{ActionUpdate, ActionDelete} {
+		event := &RecordEvent{
+			Did:        "did:plc:mallory",
+			Collection: momentCollection,
+			Rkey:       "3kmoment1",
+			Action:     action,
+			CID:        "bafymallory",
+			Record:     &vtri.ActivityMoment{Text: "hijack"},
+			Time:       time.Now(),
+		}
+		if err := indexer.Index(event); !errors.Is(err, ErrNotRecordOwner) {
+			t.Fatalf("%s: err = %v, want ErrNotRecordOwner", action, err)
+		}
+	}
+
+	moment, err := store.MomentRepo.GetMomentByURI(uri)
+	if err != nil {
+		t.Fatalf("get moment: %v", err)
+	}
+	if moment.Text != "local" || moment.Creator != "did:plc:alice" {
+		t.Errorf("moment = %+v, want it untouched", moment)
+	}
+	if _, err := store.AtpRepo.GetAtpRecord(uri); err == nil {
+		t.Error("refused event should not be stored as an atp record")
+	}
+}
+
+func TestIndexerKeysRelationshipsByURI(t *testing.T) {
+	store := newTestMetaStore(t)
+	local := &repositories.Relationship{
+		ID:        "3krel1",
+		URI:       "at://did:plc:alice/app.vtri.activity.relationship/3krel1",
+		Creator:   "did:plc:alice",
+		Predicate: repositories.RelationshipFollow,
+		Object:    "did:plc:bob",
+		CreatedAt: 1000,
+	}
+	if err := store.GraphRepo.CreateRelationship(local); err != nil {
+		t.Fatalf("create relationship: %v", err)
+	}
+	indexer := NewIndexer(store)
+
+	// mallory 使用与 alice 相同的 rkey 关注 bob, 然后删除自己的记录
+	follow := &RecordEvent{
+		Did:        "did:plc:mallory",
+		Collection: relationshipCollection,
+		Rkey:       "3krel1",
+		Action:     ActionCreate,
+		CID:        "bafymallory",
+		Record: &vtri.ActivityRelationship{
+			Predicate: repositories.RelationshipFollow,
+			Object:    &comatproto.RepoStrongRef{Uri: "at://did:plc:bob/app.vtri.avatar.profile/self"},
+			CreatedAt: "2024-10-27T03:33:20Z",
+		},
+		Time: time.Now(),
+	}
+	if err := indexer.Index(follow); err != nil {
+		t.Fatalf("index follow: %v", err)
+	}
+	mallory, err := store.GraphRepo.GetRelationshipByURI(follow.URI())
+	if err != nil {
+		t.Fatalf("get mallory relationship: %v", err)
+	}
+	if mallory.ID == local.ID || mallory.Creator != "did:plc:mallory" || mallory.Object != "did:plc:bob" {
+		t.Errorf("mallory relationship = %+v, want a separate row", mallory)
+	}
+	// 重复投递更新同一行
+	if err := indexer.Index(follow); err != nil {
+		t.Fatalf("index follow again: %v", err)
+	}
+	followers, err := store.GraphRepo.ListRelationshipsByObject("did:plc:bob", repositories.RelationshipFollow, 0, nil)
+	if err != nil {
+		t.Fatalf("list followers: %v", err)
+	}
+	if len(followers) != 2 {
+		t.Fatalf("bob has %d follow rows, want alice's and mallory's", len(followers))
+	}
+
+	unfollow := *follow
+	unfollow.Action = ActionDelete
+	unfollow.Record = nil
+	if err := indexer.Index(&unfollow); err != nil {
+		t.Fatalf("index unfollow: %v", err)
+	}
+	if _, err := store.GraphRepo.GetRelationshipByURI(follow.URI()); err == nil {
+		t.Error("mallory relationship was not deleted")
+	}
+	kept, err := store.GraphRepo.GetRelationshipByURI(local.URI)
+	if err != nil || kept.Creator != "did:plc:alice" || kept.Object != "did:plc:bob" {
+		t.Errorf("alice relationship = %+v (%v), want it untouched", kept, err)
+	}
+}
@@ -0,0 +1,71 @@
+package firehose
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// JetstreamDecoder 解析 Jetstream 的 JSON 消息, 由服务端按集合前缀过滤
+type JetstreamDecoder struct{}
+
+type jetstreamMessage struct {
+	Did    string           `json:"did"`
+	TimeUS int64            `json:"time_us"`
+	Kind   string           `json:"kind"`
+	Commit *jetstreamCommit `json:"commit,omitempty"`
+}
+
+type jetstreamCommit struct {
+	Rev        string          `json:"rev"`
+	Operation  string          `json:"operation"`
+	Collection string          `json:"collection"`
+	Rkey       string          `json:"rkey"`
+	Record     json.RawMessage `json:"record,omitempty"`
+	CID        string          `json:"cid"`
+}
+
+func (d *JetstreamDecoder) Mode() string {
+	return ModeJetstream
+}
+
+func (d *JetstreamDecoder) SubscribeURL(base string, cursor int64) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("wantedCollections", CollectionPrefix+"*")
+	if cursor > 0 {
+		query.Set("cursor", strconv.FormatInt(cursor, 10))
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+func (d *JetstreamDecoder) Decode(ctx context.Context, frame []byte) ([]*RecordEvent, int64, error) {
+	var msg jetstreamMessage
+	if err := json.Unmarshal(frame, &msg); err != nil {
+		return nil, 0, fmt.Errorf("解析 Jetstream 消息失败: %w", err)
+	}
+	if msg.Kind != "commit" || msg.Commit == nil || !wantCollection(msg.Commit.Collection) {
+		return nil, msg.TimeUS, nil
+	}
+
+	commit := msg.Commit
+	event := &RecordEvent{
+		Did:        msg.Did,
+		Collection: commit.Collection,
+		Rkey:       commit.Rkey,
+		Action:     commit.Operation,
+		CID:        commit.CID,
+		Time:       time.UnixMicro(msg.TimeUS),
+	}
+	if commit.Operation != ActionDelete && len(commit.Record) > 0 {
+		event.Record, event.JSON = decodeRecordJSON(commit.Record)
+	}
+	return []*RecordEvent{event}, msg.TimeUS, nil
+}
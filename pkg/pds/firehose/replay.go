@@ -0,0 +1,53 @@
+package firehose
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// 录制文件格式: 每帧为 uvarint 长度 + 原始帧字节, 与订阅模式无关
+const maxFrameSize = 16 << 20
+
+type FrameWriter struct {
+	w io.Writer
+}
+
+func NewFrameWriter(w io.Writer) *FrameWriter {
+	return &FrameWriter{w: w}
+}
+
+func (fw *FrameWriter) WriteFrame(frame []byte) error {
+	var header [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(header[:], uint64(len(frame)))
+	if _, err := fw.w.Write(header[:n]); err != nil {
+		return err
+	}
+	_, err := fw.w.Write(frame)
+	return err
+}
+
+type FrameReader struct {
+	r *bufio.Reader
+}
+
+func NewFrameReader(r io.Reader) *FrameReader {
+	return &FrameReader{r: bufio.NewReader(r)}
+}
+
+// ReadFrame 读取下一帧, 文件结束时返回 io.EOF
+func (fr *FrameReader) ReadFrame() ([]byte, error) {
+	size, err := binary.ReadUvarint(fr.r)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxFrameSize {
+		return nil, fmt.Errorf("帧长度 %d 超过上限", size)
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(fr.r, frame); err != nil {
+		return nil, fmt.Errorf("读取帧失败: %w", err)
+	}
+	return frame, nil
+}
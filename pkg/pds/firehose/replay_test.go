@@ -0,0 +1,228 @@
+package firehose
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testdata/jetstream.frames 录制的 Jetstream 消息, 共 13 帧:
+//   - alice 发布 moment 3lalice1 (标签 travel), bob 回复并点赞 (3llike1)
+//   - alice 用标签记录 (coffee) 和主题记录 (cities) 绑定自己的 moment, 中间有一条 identity 消息
+//   - mallory 复用 alice 本地 moment 的 rkey 3kmoment1 发布并修改 moment, 复用 bob 点赞的 rkey 3llike1,
+//     用标签 (spam) 和主题 (scam) 记录绑定 alice 的 moment, 并删除与 alice 记录 rkey 相同的 moment 3kmoment2 和标签 3ltag1
+const (
+	fixtureFrames = 13
+	fixtureCursor = 1730000000000013
+
+	aliceMomentURI = "at://did:plc:alice/app.vtri.activity.moment/3lalice1"
+)
+
+func newTestMetaStore(t *testing.T) *repositories.MetaStore {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.sqlite")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	store := repositories.NewMetaStore(db)
+	if err := store.Init(); err != nil {
+		t.Fatalf("init metastore: %v", err)
+	}
+	return store
+}
+
+// addLocalMoment 模拟 alice 通过本服务发布并已同步的 moment
+func addLocalMoment(t *testing.T, store *repositories.MetaStore, id string, text string) {
+	t.Helper()
+	moment := &repositories.Moment{
+		ID:      id,
+		URI:     "at://did:plc:alice/app.vtri.activity.moment/" + id,
+		CID:     "bafylocal" + id,
+		Text:    text,
+		Creator: "did:plc:alice",
+	}
+	if err := store.MomentRepo.CreateMoment(moment); err != nil {
+		t.Fatalf("create moment: %v", err)
+	}
+	if err := store.MomentRepo.CreateMomentImage(&repositories.MomentImage{MomentID: id, ImageCID: "bafyimage" + id}); err != nil {
+		t.Fatalf("create image: %v", err)
+	}
+}
+
+func replayFixture(t *testing.T, consumer *Consumer) {
+	t.Helper()
+	fixture, err := os.ReadFile("testdata/jetstream.frames")
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	count, err := consumer.Replay(context.Background(), bytes.NewReader(fixture))
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if count != fixtureFrames {
+		t.Fatalf("replayed %d frames, want %d", count, fixtureFrames)
+	}
+}
+
+type replaySnapshot struct {
+	moments   map[string]*repositories.Moment // uri -> moment
+	likes     map[string]*repositories.Like
+	tags      []string // alice moment 上未删除的标签
+	topics    []string
+	records   map[string]string // uri -> cid
+	imageRows int64
+}
+
+func snapshot(t *testing.T, store *repositories.MetaStore) *replaySnapshot {
+	t.Helper()
+	s := &replaySnapshot{
+		moments: make(map[string]*repositories.Moment),
+		likes:   make(map[string]*repositories.Like),
+		records: make(map[string]string),
+	}
+	var moments []*repositories.Moment
+	var likes []*repositories.Like
+	var tags []*repositories.ActivityTag
+	var topics []*repositories.ActivityTopic
+	var records []*repositories.AtpRecord
+	for _, query := range []*gorm.DB{
+		store.DB.Find(&moments),
+		store.DB.Find(&likes),
+		store.DB.Where("subject_uri = ? AND deleted = ?", aliceMomentURI, false).Find(&tags),
+		store.DB.Where("subject_uri = ? AND deleted = ?", aliceMomentURI, false).Find(&topics),
+		store.DB.Find(&records),
+		store.DB.Model(&repositories.MomentImage{}).Count(&s.imageRows),
+	} {
+		if query.Error != nil {
+			t.Fatalf("query: %v", query.Error)
+		}
+	}
+	for _, moment := range moments {
+		s.moments[moment.URI] = moment
+	}
+	for _, like := range likes {
+		s.likes[like.URI] = like
+	}
+	for _, tag := range tags {
+		s.tags = append(s.tags, tag.Tag)
+	}
+	for _, topic := range topics {
+		s.topics = append(s.topics, topic.Topic)
+	}
+	for _, record := range records {
+		s.records[record.URI] = record.CID
+	}
+	sort.Strings(s.tags)
+	sort.Strings(s.topics)
+	return s
+}
+
+func TestReplayFixture(t *testing.T) {
+	store := newTestMetaStore(t)
+	addLocalMoment(t, store, "3kmoment1", "local one")
+	addLocalMoment(t, store, "3kmoment2", "local two")
+
+	consumer, err := NewConsumer(store, config.FirehoseConfig{Mode: ModeJetstream, URL: "wss://jetstream.test/subscribe"})
+	if err != nil {
+		t.Fatalf("new consumer: %v", err)
+	}
+	replayFixture(t, consumer)
+	got := snapshot(t, store)
+
+	alice := got.moments[aliceMomentURI]
+	if alice == nil || alice.ID != "3lalice1" || alice.Text != "hello from alice" || alice.Creator != "did:plc:alice" {
+		t.Fatalf("alice moment = %+v", alice)
+	}
+	bob := got.moments["at://did:plc:bob/app.vtri.activity.moment/3lbob1"]
+	if bob == nil || bob.ReplyParentID != alice.ID || bob.ReplyRootID != alice.ID {
+		t.Errorf("bob reply = %+v, want a reply to %s", bob, alice.ID)
+	}
+
+	// 复用 rkey 的外部记录不能覆盖本地的行, 而是写入新的行
+	for _, id := range []string{"3kmoment1", "3kmoment2"} {
+		local := got.moments["at://did:plc:alice/app.vtri.activity.moment/"+id]
+		if local == nil || local.ID != id || local.Creator != "did:plc:alice" || !strings.HasPrefix(local.Text, "local") {
+			t.Errorf("local moment %s = %+v, want it untouched", id, local)
+		}
+	}
+	if got.imageRows != 2 {
+		t.Errorf("%d image rows, want the local embeds kept", got.imageRows)
+	}
+	mallory := got.moments["at://did:plc:mallory/app.vtri.activity.moment/3kmoment1"]
+	if mallory == nil || mallory.ID == "3kmoment1" || mallory.Creator != "did:plc:mallory" || mallory.Text != "hijack edited" || mallory.CID != "bafymallory2" {
+		t.Errorf("mallory moment = %+v, want its own row updated by uri", mallory)
+	}
+	if len(got.moments) != 5 {
+		t.Errorf("%d moments, want 5", len(got.moments))
+	}
+
+	bobLike := got.likes["at://did:plc:bob/app.vtri.activity.like/3llike1"]
+	malloryLike := got.likes["at://did:plc:mallory/app.vtri.activity.like/3llike1"]
+	if bobLike == nil || bobLike.ID != "3llike1" || bobLike.Creator != "did:plc:bob" || bobLike.SubjectURI != aliceMomentURI {
+		t.Errorf("bob like = %+v", bobLike)
+	}
+	if malloryLike == nil || malloryLike.ID == "3llike1" || malloryLike.Creator != "did:plc:mallory" {
+		t.Errorf("mallory like = %+v, want a separate row", malloryLike)
+	}
+
+	// 只有 moment 作者自己的标签/主题记录能绑定到 moment 上
+	if strings.Join(got.tags, ",") != "coffee,travel" {
+		t.Errorf("alice moment tags = %v, want [coffee travel]", got.tags)
+	}
+	if strings.Join(got.topics, ",") != "cities" {
+		t.Errorf("alice moment topics = %v, want [cities]", got.topics)
+	}
+
+	wantRecords := map[string]string{
+		aliceMomentURI: "bafyalice1",
+		"at://did:plc:bob/app.vtri.activity.moment/3lbob1":        "bafybob1",
+		"at://did:plc:bob/app.vtri.activity.like/3llike1":         "bafylike1",
+		"at://did:plc:alice/app.vtri.activity.tag/3ltag1":         "bafytag1",
+		"at://did:plc:alice/app.vtri.activity.topic/3ltopic1":     "bafytopic1",
+		"at://did:plc:mallory/app.vtri.activity.moment/3kmoment1": "bafymallory2",
+		"at://did:plc:mallory/app.vtri.activity.like/3llike1":     "bafymallorylike",
+		"at://did:plc:mallory/app.vtri.activity.tag/3ltag2":       "bafymallorytag",
+		"at://did:plc:mallory/app.vtri.activity.topic/3ltopic2":   "bafymallorytopic",
+	}
+	if len(got.records) != len(wantRecords) {
+		t.Errorf("atp records = %v, want %d", got.records, len(wantRecords))
+	}
+	for uri, cid := range wantRecords {
+		if got.records[uri] != cid {
+			t.Errorf("atp record %s cid = %q, want %q", uri, got.records[uri], cid)
+		}
+	}
+
+	// 游标前进到最后一帧, 包括不含记录的 identity 消息之后的帧
+	consumer.flushCursor()
+	cursor, err := store.AtpRepo.GetIngestCursor(consumer.source)
+	if err != nil {
+		t.Fatalf("get cursor: %v", err)
+	}
+	if cursor != fixtureCursor {
+		t.Errorf("persisted cursor = %d, want %d", cursor, fixtureCursor)
+	}
+
+	// 重复投递不产生新的行
+	replayFixture(t, consumer)
+	again := snapshot(t, store)
+	if len(again.moments) != len(got.moments) || len(again.likes) != len(got.likes) || len(again.records) != len(got.records) ||
+		strings.Join(again.tags, ",") != strings.Join(got.tags, ",") || strings.Join(again.topics, ",") != strings.Join(got.topics, ",") {
+		t.Errorf("second replay changed the rows: %+v -> %+v", got, again)
+	}
+	if again.moments["at://did:plc:mallory/app.vtri.activity.moment/3kmoment1"].ID != mallory.ID {
+		t.Error("second replay moved mallory's moment to another row")
+	}
+}
@@ -0,0 +1,165 @@
+package firehose
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/ipfs/go-cid"
+	"github.com/sirupsen/logrus"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// ErrStreamError 订阅源通过错误帧 (op=-1) 告知连接出错, 需要断开重连
+var ErrStreamError = errors.New("订阅源返回错误")
+
+// FrameDecoder 将订阅源的一帧消息解码为记录事件, 同时返回该帧的游标 (0 表示没有游标).
+// 解码失败时仍尽量返回已知的游标, 便于跳过该帧
+type FrameDecoder interface {
+	Mode() string
+	SubscribeURL(base string, cursor int64) (string, error)
+	Decode(ctx context.Context, frame []byte) ([]*RecordEvent, int64, error)
+}
+
+// ReposDecoder 解析 com.atproto.sync.subscribeRepos 的帧: CBOR 头 {op, t} + CBOR 消息体,
+// #commit 中的记录从附带的 CAR 块中读取
+type ReposDecoder struct{}
+
+func (d *ReposDecoder) Mode() string {
+	return ModeRepos
+}
+
+func (d *ReposDecoder) SubscribeURL(base string, cursor int64) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(u.Path, "/xrpc/com.atproto.sync.subscribeRepos") {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/xrpc/com.atproto.sync.subscribeRepos"
+	}
+	if cursor > 0 {
+		query := u.Query()
+		query.Set("cursor", strconv.FormatInt(cursor, 10))
+		u.RawQuery = query.Encode()
+	}
+	return u.String(), nil
+}
+
+func (d *ReposDecoder) Decode(ctx context.Context, frame []byte) ([]*RecordEvent, int64, error) {
+	reader := bytes.NewReader(frame)
+
+	var rawHeader cbg.Deferred
+	if err := rawHeader.UnmarshalCBOR(reader); err != nil {
+		return nil, 0, fmt.Errorf("读取帧头失败: %w", err)
+	}
+	header, err := data.UnmarshalCBOR(rawHeader.Raw)
+	if err != nil {
+		return nil, 0, fmt.Errorf("解析帧头失败: %w", err)
+	}
+	op, _ := header["op"].(int64)
+	msgType, _ := header["t"].(string)
+
+	if op == -1 {
+		body, _ := data.UnmarshalCBOR(frame[len(rawHeader.Raw):])
+		return nil, 0, fmt.Errorf("%w: %v %v", ErrStreamError, body["error"], body["message"])
+	}
+
+	switch msgType {
+	case "#commit":
+		var evt comatproto.SyncSubscribeRepos_Commit
+		if err := evt.UnmarshalCBOR(reader); err != nil {
+			return nil, 0, fmt.Errorf("解析 #commit 失败: %w", err)
+		}
+		events, err := d.commitEvents(ctx, &evt)
+		return events, evt.Seq, err
+	case "#identity":
+		var evt comatproto.SyncSubscribeRepos_Identity
+		if err := evt.UnmarshalCBOR(reader); err != nil {
+			return nil, 0, fmt.Errorf("解析 #identity 失败: %w", err)
+		}
+		return nil, evt.Seq, nil
+	case "#account":
+		var evt comatproto.SyncSubscribeRepos_Account
+		if err := evt.UnmarshalCBOR(reader); err != nil {
+			return nil, 0, fmt.Errorf("解析 #account 失败: %w", err)
+		}
+		return nil, evt.Seq, nil
+	case "#sync":
+		var evt comatproto.SyncSubscribeRepos_Sync
+		if err := evt.UnmarshalCBOR(reader); err != nil {
+			return nil, 0, fmt.Errorf("解析 #sync 失败: %w", err)
+		}
+		return nil, evt.Seq, nil
+	case "#info":
+		var evt comatproto.SyncSubscribeRepos_Info
+		if err := evt.UnmarshalCBOR(reader); err == nil {
+			logrus.Infof("订阅源消息: %s", evt.Name)
+		}
+		return nil, 0, nil
+	default:
+		return nil, 0, nil
+	}
+}
+
+func (d *ReposDecoder) commitEvents(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Commit) ([]*RecordEvent, error) {
+	var ops []*comatproto.SyncSubscribeRepos_RepoOp
+	for _, op := range evt.Ops {
+		collection, _, ok := strings.Cut(op.Path, "/")
+		if ok && wantCollection(collection) {
+			ops = append(ops, op)
+		}
+	}
+	if len(ops) == 0 {
+		return nil, nil
+	}
+	if evt.TooBig {
+		logrus.Warnf("仓库 %s 的提交 %d 过大, 未附带记录内容, 跳过", evt.Repo, evt.Seq)
+		return nil, nil
+	}
+
+	rr, err := repo.ReadRepoFromCar(ctx, bytes.NewReader(evt.Blocks))
+	if err != nil {
+		return nil, fmt.Errorf("读取仓库 %s 的 CAR 失败: %w", evt.Repo, err)
+	}
+
+	eventTime := parseEventTime(evt.Time)
+	events := make([]*RecordEvent, 0, len(ops))
+	for _, op := range ops {
+		collection, rkey, _ := strings.Cut(op.Path, "/")
+		event := &RecordEvent{
+			Did:        evt.Repo,
+			Collection: collection,
+			Rkey:       rkey,
+			Action:     op.Action,
+			Time:       eventTime,
+		}
+		if op.Action != ActionDelete {
+			if op.Cid == nil {
+				continue
+			}
+			recordCID := cid.Cid(*op.Cid)
+			block, err := rr.Blockstore().Get(ctx, recordCID)
+			if err != nil {
+				logrus.Warnf("提交 %d 中缺少记录 %s 的块: %v", evt.Seq, op.Path, err)
+				continue
+			}
+			record, body, err := decodeRecordCBOR(block.RawData())
+			if err != nil {
+				logrus.Warnf("解析记录 %s/%s 失败: %v", evt.Repo, op.Path, err)
+				continue
+			}
+			event.CID = recordCID.String()
+			event.Record = record
+			event.JSON = body
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
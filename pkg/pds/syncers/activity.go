@@ -0,0 +1,180 @@
+package syncers
+
+import (
+	"errors"
+	"fmt"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/lex/util"
+	"github.com/zhongshangwu/avatarai-social/pkg/atproto/vtri"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	LikeCollection         = "app.vtri.activity.like"
+	TagCollection          = "app.vtri.activity.tag"
+	TopicCollection        = "app.vtri.activity.topic"
+	RelationshipCollection = "app.vtri.activity.relationship"
+
+	profileCollection = "app.vtri.avatar.profile"
+)
+
+// LikeSyncer 同步点赞记录, 被点赞的 moment 需要先拿到 CID
+type LikeSyncer struct {
+	metaStore *repositories.MetaStore
+}
+
+func NewLikeSyncer(metaStore *repositories.MetaStore) *LikeSyncer {
+	return &LikeSyncer{metaStore: metaStore}
+}
+
+func (s *LikeSyncer) Collection() string {
+	return LikeCollection
+}
+
+func (s *LikeSyncer) BuildRecord(op *repositories.PDSOutboxOp) (util.CBOR, error) {
+	like, err := s.metaStore.MomentRepo.GetLikeByURI(recordURI(op))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRecordGone
+	}
+	if err != nil {
+		return nil, fmt.Errorf("获取点赞失败: %w", err)
+	}
+
+	subjectCID := like.SubjectCid
+	if subjectCID == "" {
+		moment, err := s.metaStore.MomentRepo.GetMomentByURI(like.SubjectURI)
+		if err != nil {
+			return nil, fmt.Errorf("获取被点赞的 moment 失败: %w", err)
+		}
+		if moment.CID == "" {
+			return nil, fmt.Errorf("%w: %s", ErrDependencyPending, moment.URI)
+		}
+		subjectCID = moment.CID
+	}
+
+	return &vtri.ActivityLike{
+		LexiconTypeID: LikeCollection,
+		CreatedAt:     utils.FormatTime(like.CreatedAt),
+		Subject:       &comatproto.RepoStrongRef{Uri: like.SubjectURI, Cid: subjectCID},
+	}, nil
+}
+
+func (s *LikeSyncer) Synced(op *repositories.PDSOutboxOp, uri string, cid string) error {
+	updates := map[string]interface{}{"cid": cid}
+	if like, err := s.metaStore.MomentRepo.GetLikeByURI(recordURI(op)); err == nil && like.SubjectCid == "" {
+		if moment, err := s.metaStore.MomentRepo.GetMomentByURI(like.SubjectURI); err == nil {
+			updates["subject_cid"] = moment.CID
+		}
+	}
+	return s.metaStore.MomentRepo.UpdateLike(recordURI(op), updates)
+}
+
+// TagSyncer 同步标签记录, 写入创建者的仓库, rkey 为标签 ID
+type TagSyncer struct {
+	metaStore *repositories.MetaStore
+}
+
+func NewTagSyncer(metaStore *repositories.MetaStore) *TagSyncer {
+	return &TagSyncer{metaStore: metaStore}
+}
+
+func (s *TagSyncer) Collection() string {
+	return TagCollection
+}
+
+func (s *TagSyncer) BuildRecord(op *repositories.PDSOutboxOp) (util.CBOR, error) {
+	tag, err := s.metaStore.MomentRepo.GetTagByID(op.Rkey)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && tag.Deleted) {
+		return nil, ErrRecordGone
+	}
+	if err != nil {
+		return nil, fmt.Errorf("获取标签失败: %w", err)
+	}
+	return &vtri.ActivityTag{
+		LexiconTypeID: TagCollection,
+		CreatedAt:     utils.FormatTime(tag.CreatedAt),
+		Tag:           &tag.Tag,
+	}, nil
+}
+
+func (s *TagSyncer) Synced(op *repositories.PDSOutboxOp, uri string, cid string) error {
+	return s.metaStore.MomentRepo.UpdateTag(op.Rkey, map[string]interface{}{"uri": uri, "cid": cid})
+}
+
+// TopicSyncer 同步主题记录, 写入创建者的仓库, rkey 为主题 ID
+type TopicSyncer struct {
+	metaStore *repositories.MetaStore
+}
+
+func NewTopicSyncer(metaStore *repositories.MetaStore) *TopicSyncer {
+	return &TopicSyncer{metaStore: metaStore}
+}
+
+func (s *TopicSyncer) Collection() string {
+	return TopicCollection
+}
+
+func (s *TopicSyncer) BuildRecord(op *repositories.PDSOutboxOp) (util.CBOR, error) {
+	topic, err := s.metaStore.MomentRepo.GetTopicByID(op.Rkey)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && topic.Deleted) {
+		return nil, ErrRecordGone
+	}
+	if err != nil {
+		return nil, fmt.Errorf("获取主题失败: %w", err)
+	}
+	return &vtri.ActivityTopic{
+		LexiconTypeID: TopicCollection,
+		CreatedAt:     utils.FormatTime(topic.CreatedAt),
+		Topic:         topic.Topic,
+	}, nil
+}
+
+func (s *TopicSyncer) Synced(op *repositories.PDSOutboxOp, uri string, cid string) error {
+	return s.metaStore.MomentRepo.UpdateTopic(op.Rkey, map[string]interface{}{"uri": uri, "cid": cid})
+}
+
+// RelationshipSyncer 同步关注/屏蔽记录, subject 和 object 引用双方的资料记录
+type RelationshipSyncer struct {
+	metaStore *repositories.MetaStore
+}
+
+func NewRelationshipSyncer(metaStore *repositories.MetaStore) *RelationshipSyncer {
+	return &RelationshipSyncer{metaStore: metaStore}
+}
+
+func (s *RelationshipSyncer) Collection() string {
+	return RelationshipCollection
+}
+
+func (s *RelationshipSyncer) BuildRecord(op *repositories.PDSOutboxOp) (util.CBOR, error) {
+	relationship, err := s.metaStore.GraphRepo.GetRelationshipByURI(recordURI(op))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRecordGone
+	}
+	if err != nil {
+		return nil, fmt.Errorf("获取关系失败: %w", err)
+	}
+	return &vtri.ActivityRelationship{
+		LexiconTypeID: RelationshipCollection,
+		CreatedAt:     utils.FormatTime(relationship.CreatedAt),
+		Subject:       s.profileRef(relationship.Creator),
+		Predicate:     relationship.Predicate,
+		Object:        s.profileRef(relationship.Object),
+	}, nil
+}
+
+func (s *RelationshipSyncer) Synced(op *repositories.PDSOutboxOp, uri string, cid string) error {
+	return s.metaStore.GraphRepo.UpdateRelationship(recordURI(op), map[string]interface{}{"cid": cid})
+}
+
+// profileRef 用户的资料记录引用, 本地没有索引到资料记录时 CID 为空
+func (s *RelationshipSyncer) profileRef(did string) *comatproto.RepoStrongRef {
+	ref := &comatproto.RepoStrongRef{Uri: fmt.Sprintf("at://%s/%s/self", did, profileCollection)}
+	if record, err := s.metaStore.AtpRepo.GetAtpRecord(ref.Uri); err == nil {
+		ref.Cid = record.CID
+	}
+	return ref
+}
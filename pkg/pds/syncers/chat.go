@@ -0,0 +1,185 @@
+package syncers
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bluesky-social/indigo/lex/util"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/services"
+	"gorm.io/gorm"
+)
+
+// ChatRoomSyncer 同步开启了 PDS 同步的房间, 写入成员自己的仓库, rkey 为房间 ID
+type ChatRoomSyncer struct {
+	metaStore *repositories.MetaStore
+	converter *services.MessageConverter
+}
+
+func NewChatRoomSyncer(metaStore *repositories.MetaStore) *ChatRoomSyncer {
+	return &ChatRoomSyncer{metaStore: metaStore, converter: services.NewMessageConverter(metaStore.MessageRepo)}
+}
+
+func (s *ChatRoomSyncer) Collection() string {
+	return services.ChatRoomCollection
+}
+
+func (s *ChatRoomSyncer) BuildRecord(op *repositories.PDSOutboxOp) (util.CBOR, error) {
+	room, err := s.metaStore.MessageRepo.GetRoomByID(op.Rkey)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && room.Deleted) {
+		return nil, ErrRecordGone
+	}
+	if err != nil {
+		return nil, fmt.Errorf("获取房间失败: %w", err)
+	}
+	if err := checkChatMirror(s.metaStore, op.Did, room.ID); err != nil {
+		return nil, err
+	}
+	return s.converter.RoomToRecord(room), nil
+}
+
+func (s *ChatRoomSyncer) Synced(op *repositories.PDSOutboxOp, uri string, cid string) error {
+	return nil
+}
+
+// ChatThreadSyncer 同步房间内的话题, rkey 为话题 ID
+type ChatThreadSyncer struct {
+	metaStore *repositories.MetaStore
+	converter *services.MessageConverter
+}
+
+func NewChatThreadSyncer(metaStore *repositories.MetaStore) *ChatThreadSyncer {
+	return &ChatThreadSyncer{metaStore: metaStore, converter: services.NewMessageConverter(metaStore.MessageRepo)}
+}
+
+func (s *ChatThreadSyncer) Collection() string {
+	return services.ChatThreadCollection
+}
+
+func (s *ChatThreadSyncer) BuildRecord(op *repositories.PDSOutboxOp) (util.CBOR, error) {
+	thread, err := s.metaStore.MessageRepo.GetThreadByID(op.Rkey)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && thread.Deleted) {
+		return nil, ErrRecordGone
+	}
+	if err != nil {
+		return nil, fmt.Errorf("获取话题失败: %w", err)
+	}
+	if err := checkChatMirror(s.metaStore, op.Did, thread.RoomID); err != nil {
+		return nil, err
+	}
+	return s.converter.ThreadToRecord(thread), nil
+}
+
+func (s *ChatThreadSyncer) Synced(op *repositories.PDSOutboxOp, uri string, cid string) error {
+	return nil
+}
+
+// ChatMessageSyncer 同步用户自己发送的消息和回复给用户的 AI 消息, rkey 为消息 ID
+type ChatMessageSyncer struct {
+	metaStore *repositories.MetaStore
+	converter *services.MessageConverter
+}
+
+func NewChatMessageSyncer(metaStore *repositories.MetaStore) *ChatMessageSyncer {
+	return &ChatMessageSyncer{metaStore: metaStore, converter: services.NewMessageConverter(metaStore.MessageRepo)}
+}
+
+func (s *ChatMessageSyncer) Collection() string {
+	return services.ChatMessageCollection
+}
+
+func (s *ChatMessageSyncer) BuildRecord(op *repositories.PDSOutboxOp) (util.CBOR, error) {
+	dbMessage, err := s.metaStore.MessageRepo.GetMessageByID(op.Rkey)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && dbMessage.Deleted) {
+		return nil, ErrRecordGone
+	}
+	if err != nil {
+		return nil, fmt.Errorf("获取消息失败: %w", err)
+	}
+	if !ownsChatMessage(op.Did, dbMessage) {
+		return nil, ErrRecordGone
+	}
+	if err := checkChatMirror(s.metaStore, op.Did, dbMessage.RoomID); err != nil {
+		return nil, err
+	}
+	return s.converter.MessageToRecord(s.converter.DBToMessage(dbMessage))
+}
+
+func (s *ChatMessageSyncer) Synced(op *repositories.PDSOutboxOp, uri string, cid string) error {
+	return nil
+}
+
+// ChatAiChatSyncer 同步已完成的 AI 回复内容, rkey 为 AgentMessage ID
+type ChatAiChatSyncer struct {
+	metaStore *repositories.MetaStore
+	converter *services.MessageConverter
+}
+
+func NewChatAiChatSyncer(metaStore *repositories.MetaStore) *ChatAiChatSyncer {
+	return &ChatAiChatSyncer{metaStore: metaStore, converter: services.NewMessageConverter(metaStore.MessageRepo)}
+}
+
+func (s *ChatAiChatSyncer) Collection() string {
+	return services.ChatAiChatCollection
+}
+
+func (s *ChatAiChatSyncer) BuildRecord(op *repositories.PDSOutboxOp) (util.CBOR, error) {
+	agentMessage, err := s.metaStore.MessageRepo.GetAgentMessageByID(op.Rkey)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && agentMessage.Deleted) {
+		return nil, ErrRecordGone
+	}
+	if err != nil {
+		return nil, fmt.Errorf("获取 AI 回复失败: %w", err)
+	}
+	if messages.AgentMessageStatus(agentMessage.Status) != messages.AgentMessageStatusCompleted {
+		return nil, ErrRecordGone
+	}
+	dbMessage, err := s.metaStore.MessageRepo.GetMessageByID(agentMessage.MessageID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && dbMessage.Deleted) {
+		return nil, ErrRecordGone
+	}
+	if err != nil {
+		return nil, fmt.Errorf("获取消息失败: %w", err)
+	}
+	if dbMessage.ReceiverID != op.Did {
+		return nil, ErrRecordGone
+	}
+	if err := checkChatMirror(s.metaStore, op.Did, dbMessage.RoomID); err != nil {
+		return nil, err
+	}
+
+	// AI 回复的输出项随消息内容一起加载
+	content, ok := s.converter.DBToMessage(dbMessage).Content.(*messages.AgentMessageContent)
+	if !ok {
+		return nil, fmt.Errorf("消息 %s 不是 AI 回复", dbMessage.ID)
+	}
+	return s.converter.AgentMessageToRecord(&content.AgentMessage, op.Did), nil
+}
+
+func (s *ChatAiChatSyncer) Synced(op *repositories.PDSOutboxOp, uri string, cid string) error {
+	return nil
+}
+
+// checkChatMirror 用户关闭同步或退出房间后, 队列中尚未投递的操作不再写入
+func checkChatMirror(metaStore *repositories.MetaStore, did string, roomID string) error {
+	status, err := metaStore.MessageRepo.GetUserRoomStatus(did, roomID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrRecordGone
+	}
+	if err != nil {
+		return fmt.Errorf("获取成员状态失败: %w", err)
+	}
+	if status.Deleted || !status.PDSMirror || status.Status != string(messages.RoomMemberStatusAccepted) {
+		return ErrRecordGone
+	}
+	return nil
+}
+
+// ownsChatMessage 只同步用户自己发送的消息和回复给用户的 AI 消息, 其他成员的消息不写入用户的仓库
+func ownsChatMessage(did string, message *repositories.Message) bool {
+	if message.SenderID == did {
+		return true
+	}
+	return messages.MessageType(message.MsgType) == messages.MessageTypeAgent && message.ReceiverID == did
+}
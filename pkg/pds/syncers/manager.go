@@ -1,264 +1,434 @@
 package syncers
 
-// import (
-// 	"context"
-// 	"fmt"
-// 	"log"
-// 	"sync"
-// 	"time"
-
-// 	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
-// )
-
-// // SyncerManager 同步器管理器
-// type SyncerManager struct {
-// 	metaStore    *repositories.MetaStore
-// 	momentSyncer *MomentSyncer
-// 	running      bool
-// 	ctx          context.Context
-// 	cancel       context.CancelFunc
-// 	mu           sync.RWMutex
-// 	startTime    time.Time
-// 	config       *SyncerConfig
-// }
-
-// // SyncerConfig 同步器配置
-// type SyncerConfig struct {
-// 	MomentSyncInterval time.Duration `json:"moment_sync_interval"`
-// 	BatchSize          int           `json:"batch_size"`
-// 	MaxRetries         int           `json:"max_retries"`
-// 	RetryDelay         time.Duration `json:"retry_delay"`
-// 	EnableMetrics      bool          `json:"enable_metrics"`
-// 	LogLevel           string        `json:"log_level"`
-// }
-
-// // DefaultSyncerConfig 默认同步器配置
-// func DefaultSyncerConfig() *SyncerConfig {
-// 	return &SyncerConfig{
-// 		MomentSyncInterval: 30 * time.Second,
-// 		BatchSize:          50,
-// 		MaxRetries:         3,
-// 		RetryDelay:         5 * time.Second,
-// 		EnableMetrics:      true,
-// 		LogLevel:           "info",
-// 	}
-// }
-
-// // NewSyncerManager 创建新的同步器管理器
-// func NewSyncerManager(metaStore *repositories.MetaStore, config *SyncerConfig) *SyncerManager {
-// 	if config == nil {
-// 		config = DefaultSyncerConfig()
-// 	}
-
-// 	return &SyncerManager{
-// 		metaStore: metaStore,
-// 		config:    config,
-// 	}
-// }
-
-// // Start 启动同步器管理器
-// func (sm *SyncerManager) Start() error {
-// 	sm.mu.Lock()
-// 	defer sm.mu.Unlock()
-
-// 	if sm.running {
-// 		return fmt.Errorf("同步器管理器已经在运行")
-// 	}
-
-// 	sm.ctx, sm.cancel = context.WithCancel(context.Background())
-// 	sm.startTime = time.Now()
-// 	sm.running = true
-
-// 	log.Println("启动同步器管理器")
-
-// 	// 初始化各个同步器
-// 	if err := sm.initializeSyncers(); err != nil {
-// 		sm.running = false
-// 		sm.cancel()
-// 		return fmt.Errorf("初始化同步器失败: %w", err)
-// 	}
-
-// 	// 启动各个同步器
-// 	if err := sm.startSyncers(); err != nil {
-// 		sm.running = false
-// 		sm.cancel()
-// 		return fmt.Errorf("启动同步器失败: %w", err)
-// 	}
-
-// 	log.Println("同步器管理器启动完成")
-// 	return nil
-// }
-
-// // Stop 停止同步器管理器
-// func (sm *SyncerManager) Stop() error {
-// 	sm.mu.Lock()
-// 	defer sm.mu.Unlock()
-
-// 	if !sm.running {
-// 		return nil
-// 	}
-
-// 	log.Println("停止同步器管理器")
-
-// 	// 停止各个同步器
-// 	sm.stopSyncers()
-
-// 	// 取消上下文
-// 	if sm.cancel != nil {
-// 		sm.cancel()
-// 	}
-
-// 	sm.running = false
-// 	log.Println("同步器管理器已停止")
-// 	return nil
-// }
-
-// // initializeSyncers 初始化同步器
-// func (sm *SyncerManager) initializeSyncers() error {
-// 	// 初始化Moment同步器
-// 	sm.momentSyncer = NewMomentSyncer(sm.metaStore)
-// 	sm.momentSyncer.SetSyncInterval(sm.config.MomentSyncInterval)
-// 	sm.momentSyncer.SetBatchSize(sm.config.BatchSize)
-
-// 	log.Println("同步器初始化完成")
-// 	return nil
-// }
-
-// // startSyncers 启动同步器
-// func (sm *SyncerManager) startSyncers() error {
-// 	// 启动Moment同步器
-// 	go func() {
-// 		if err := sm.momentSyncer.Start(sm.ctx); err != nil {
-// 			log.Printf("Moment同步器启动失败: %v", err)
-// 		}
-// 	}()
-
-// 	// 等待一小段时间确保同步器启动
-// 	time.Sleep(100 * time.Millisecond)
-
-// 	log.Println("所有同步器启动完成")
-// 	return nil
-// }
-
-// // stopSyncers 停止同步器
-// func (sm *SyncerManager) stopSyncers() {
-// 	if sm.momentSyncer != nil {
-// 		sm.momentSyncer.Stop()
-// 	}
-
-// 	log.Println("所有同步器已停止")
-// }
-
-// // IsRunning 检查管理器是否在运行
-// func (sm *SyncerManager) IsRunning() bool {
-// 	sm.mu.RLock()
-// 	defer sm.mu.RUnlock()
-// 	return sm.running
-// }
-
-// // GetStatus 获取同步器状态
-// func (sm *SyncerManager) GetStatus() *SyncerStatus {
-// 	sm.mu.RLock()
-// 	defer sm.mu.RUnlock()
-
-// 	status := &SyncerStatus{
-// 		Running:   sm.running,
-// 		StartTime: sm.startTime,
-// 		Uptime:    time.Since(sm.startTime),
-// 	}
-
-// 	if sm.momentSyncer != nil {
-// 		status.MomentSyncStats = sm.momentSyncer.GetSyncStats()
-// 	}
-
-// 	return status
-// }
-
-// // SyncerStatus 同步器状态
-// type SyncerStatus struct {
-// 	Running         bool          `json:"running"`
-// 	StartTime       time.Time     `json:"start_time"`
-// 	Uptime          time.Duration `json:"uptime"`
-// 	MomentSyncStats *SyncStats    `json:"moment_sync_stats,omitempty"`
-// }
-
-// // UpdateConfig 更新配置
-// func (sm *SyncerManager) UpdateConfig(config *SyncerConfig) error {
-// 	sm.mu.Lock()
-// 	defer sm.mu.Unlock()
-
-// 	if config == nil {
-// 		return fmt.Errorf("配置不能为空")
-// 	}
-
-// 	sm.config = config
-
-// 	// 如果同步器正在运行，更新其配置
-// 	if sm.running && sm.momentSyncer != nil {
-// 		sm.momentSyncer.SetSyncInterval(config.MomentSyncInterval)
-// 		sm.momentSyncer.SetBatchSize(config.BatchSize)
-// 	}
-
-// 	log.Println("同步器配置已更新")
-// 	return nil
-// }
-
-// // GetConfig 获取当前配置
-// func (sm *SyncerManager) GetConfig() *SyncerConfig {
-// 	sm.mu.RLock()
-// 	defer sm.mu.RUnlock()
-
-// 	// 返回配置副本
-// 	config := *sm.config
-// 	return &config
-// }
-
-// // TriggerSync 手动触发同步
-// func (sm *SyncerManager) TriggerSync(syncType string) error {
-// 	sm.mu.RLock()
-// 	defer sm.mu.RUnlock()
-
-// 	if !sm.running {
-// 		return fmt.Errorf("同步器管理器未运行")
-// 	}
-
-// 	switch syncType {
-// 	case "moment":
-// 		if sm.momentSyncer != nil {
-// 			go sm.momentSyncer.syncBatch(sm.ctx)
-// 			log.Println("手动触发Moment同步")
-// 			return nil
-// 		}
-// 		return fmt.Errorf("Moment同步器未初始化")
-// 	case "all":
-// 		if sm.momentSyncer != nil {
-// 			go sm.momentSyncer.syncBatch(sm.ctx)
-// 		}
-// 		log.Println("手动触发所有同步器")
-// 		return nil
-// 	default:
-// 		return fmt.Errorf("未知的同步类型: %s", syncType)
-// 	}
-// }
-
-// // GetMomentSyncer 获取Moment同步器实例
-// func (sm *SyncerManager) GetMomentSyncer() *MomentSyncer {
-// 	sm.mu.RLock()
-// 	defer sm.mu.RUnlock()
-// 	return sm.momentSyncer
-// }
-
-// // HealthCheck 健康检查
-// func (sm *SyncerManager) HealthCheck() error {
-// 	sm.mu.RLock()
-// 	defer sm.mu.RUnlock()
-
-// 	if !sm.running {
-// 		return fmt.Errorf("同步器管理器未运行")
-// 	}
-
-// 	// 检查各个同步器的健康状态
-// 	// 这里可以添加更详细的健康检查逻辑
-
-// 	return nil
-// }
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/lex/util"
+	"github.com/sirupsen/logrus"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+)
+
+const (
+	DefaultSyncInterval  = 2 * time.Second
+	DefaultBatchSize     = 50
+	DefaultMaxRetries    = 8
+	DefaultRetryDelay    = 5 * time.Second
+	DefaultMaxRetryDelay = 30 * time.Minute
+	DefaultRetention     = 72 * time.Hour
+
+	purgeInterval = time.Hour
+	syncTimeout   = time.Minute
+)
+
+var (
+	// ErrRecordGone 本地记录已被删除, 对应的 create/update 无需再同步
+	ErrRecordGone = errors.New("本地记录已不存在")
+	// ErrDependencyPending 记录引用的其他记录尚未同步 (还没有 CID), 稍后重试
+	ErrDependencyPending = errors.New("依赖的记录尚未同步")
+)
+
+// RecordSyncer 负责一个集合的记录同步
+// 队列中只保存操作本身, 记录内容在投递时根据本地数据构建, 保证提交的是最新状态
+type RecordSyncer interface {
+	Collection() string
+	// BuildRecord 构建 create/update 要写入的记录
+	BuildRecord(op *repositories.PDSOutboxOp) (util.CBOR, error)
+	// Synced 记录写入 PDS 后回写 uri/cid
+	Synced(op *repositories.PDSOutboxOp, uri string, cid string) error
+}
+
+// SyncerManager 从 pds_outbox 队列中取出待同步的操作, 按用户合并为 applyWrites 写入 PDS,
+// 失败时按指数退避重试. 同一用户的操作严格按入队顺序提交
+type SyncerManager struct {
+	metaStore *repositories.MetaStore
+	config    config.SyncerConfig
+	syncers   map[string]RecordSyncer
+
+	mu        sync.RWMutex
+	running   bool
+	startTime time.Time
+	cancel    context.CancelFunc
+	done      chan struct{}
+	trigger   chan struct{}
+	stats     map[string]*CollectionStats
+	lastPurge time.Time
+}
+
+// CollectionStats 单个集合的同步统计, Queue 为队列中各状态的操作数
+type CollectionStats struct {
+	Synced       int64            `json:"synced"`
+	Retried      int64            `json:"retried"`
+	Failed       int64            `json:"failed"`
+	LastSyncTime time.Time        `json:"last_sync_time,omitempty"`
+	LastError    string           `json:"last_error,omitempty"`
+	Queue        map[string]int64 `json:"queue,omitempty"`
+}
+
+// SyncerStatus 同步器状态
+type SyncerStatus struct {
+	Running     bool                        `json:"running"`
+	StartTime   time.Time                   `json:"start_time"`
+	Uptime      string                      `json:"uptime"`
+	Collections map[string]*CollectionStats `json:"collections"`
+}
+
+func NewSyncerManager(metaStore *repositories.MetaStore, cfg config.SyncerConfig) *SyncerManager {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultSyncInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = DefaultMaxRetries
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = DefaultRetryDelay
+	}
+	if cfg.MaxRetryDelay <= 0 {
+		cfg.MaxRetryDelay = DefaultMaxRetryDelay
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = DefaultRetention
+	}
+
+	sm := &SyncerManager{
+		metaStore: metaStore,
+		config:    cfg,
+		syncers:   make(map[string]RecordSyncer),
+		trigger:   make(chan struct{}, 1),
+		stats:     make(map[string]*CollectionStats),
+	}
+	sm.Register(NewMomentSyncer(metaStore))
+	sm.Register(NewLikeSyncer(metaStore))
+	sm.Register(NewTagSyncer(metaStore))
+	sm.Register(NewTopicSyncer(metaStore))
+	sm.Register(NewRelationshipSyncer(metaStore))
+	sm.Register(NewChatRoomSyncer(metaStore))
+	sm.Register(NewChatThreadSyncer(metaStore))
+	sm.Register(NewChatAiChatSyncer(metaStore))
+	sm.Register(NewChatMessageSyncer(metaStore))
+	return sm
+}
+
+// Register 注册集合同步器, 需要在 Start 之前调用
+func (sm *SyncerManager) Register(syncer RecordSyncer) {
+	sm.syncers[syncer.Collection()] = syncer
+	sm.stats[syncer.Collection()] = &CollectionStats{}
+}
+
+// Start 启动同步器管理器
+func (sm *SyncerManager) Start() error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.running {
+		return fmt.Errorf("同步器管理器已经在运行")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sm.cancel = cancel
+	sm.done = make(chan struct{})
+	sm.startTime = time.Now()
+	sm.running = true
+
+	go sm.run(ctx, sm.done)
+	logrus.Infof("PDS 同步器管理器已启动, 间隔 %s, 批大小 %d", sm.config.Interval, sm.config.BatchSize)
+	return nil
+}
+
+// Stop 停止同步器管理器, 等待正在进行的批次结束
+func (sm *SyncerManager) Stop() {
+	sm.mu.Lock()
+	if !sm.running {
+		sm.mu.Unlock()
+		return
+	}
+	sm.running = false
+	sm.cancel()
+	done := sm.done
+	sm.mu.Unlock()
+
+	<-done
+	logrus.Info("PDS 同步器管理器已停止")
+}
+
+// Trigger 立即处理一次队列, 用于新操作入队后减少同步延迟
+func (sm *SyncerManager) Trigger() {
+	select {
+	case sm.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (sm *SyncerManager) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(sm.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		// 队列积压时连续处理, 不等待下一个周期
+		for sm.drain(ctx) && ctx.Err() == nil {
+		}
+		sm.purge()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-sm.trigger:
+		}
+	}
+}
+
+// drain 处理一批到期的操作, 返回队列中是否可能还有待处理的操作
+func (sm *SyncerManager) drain(ctx context.Context) bool {
+	outboxRepo := sm.metaStore.OutboxRepo
+	now := time.Now().UnixMilli()
+
+	ops, err := outboxRepo.ListDueOutboxOps(now, sm.config.BatchSize)
+	if err != nil {
+		logrus.Errorf("查询同步队列失败: %v", err)
+		return false
+	}
+	if len(ops) == 0 {
+		return false
+	}
+	heads, err := outboxRepo.GetWaitingOutboxHeads(now)
+	if err != nil {
+		logrus.Errorf("查询等待重试的操作失败: %v", err)
+		return false
+	}
+
+	var dids []string
+	byDID := make(map[string][]*repositories.PDSOutboxOp)
+	for _, op := range ops {
+		// 同一用户更早的操作还在等待重试, 后面的操作不能越过它
+		if head, ok := heads[op.Did]; ok && op.ID > head {
+			continue
+		}
+		if _, ok := byDID[op.Did]; !ok {
+			dids = append(dids, op.Did)
+		}
+		byDID[op.Did] = append(byDID[op.Did], op)
+	}
+
+	progressed := 0
+	for _, did := range dids {
+		if ctx.Err() != nil {
+			return false
+		}
+		syncCtx, cancel := context.WithTimeout(ctx, syncTimeout)
+		progressed += sm.syncUser(syncCtx, did, byDID[did])
+		cancel()
+	}
+	return len(ops) == sm.config.BatchSize && progressed > 0
+}
+
+// syncUser 将一个用户的操作合并为一次 applyWrites 提交, 批量提交被拒绝时逐条提交以隔离出问题的记录
+// 返回已结束 (成功/跳过/最终失败) 的操作数
+func (sm *SyncerManager) syncUser(ctx context.Context, did string, ops []*repositories.PDSOutboxOp) int {
+	client, err := sm.newClient(did)
+	if err != nil {
+		sm.fail(ops[0], err)
+		return 0
+	}
+
+	finished := 0
+	writes := make([]*recordWrite, 0, len(ops))
+	for _, op := range ops {
+		syncer, ok := sm.syncers[op.Collection]
+		if !ok {
+			sm.fail(op, fmt.Errorf("不支持同步的集合: %s", op.Collection))
+			finished++
+			continue
+		}
+		write := &recordWrite{op: op}
+		if op.Action != repositories.OutboxActionDelete {
+			record, err := syncer.BuildRecord(op)
+			if errors.Is(err, ErrRecordGone) {
+				sm.skip(op)
+				finished++
+				continue
+			}
+			if errors.Is(err, ErrDependencyPending) && len(writes) > 0 {
+				// 依赖的记录可能就在本批次中, 先提交前面的写入, 本条留到下一轮
+				break
+			}
+			if err != nil {
+				if !sm.fail(op, err) {
+					break
+				}
+				finished++
+				continue
+			}
+			write.record = record
+		}
+		writes = append(writes, write)
+	}
+	if len(writes) == 0 {
+		return finished
+	}
+
+	results, err := applyWrites(ctx, client, did, writes)
+	if err == nil {
+		for i, write := range writes {
+			sm.complete(write, results[i].uri, results[i].cid)
+		}
+		return finished + len(writes)
+	}
+	if isRetryable(err) {
+		// 只为第一条安排重试, 其余操作会被它挡住, 保持顺序
+		sm.fail(writes[0].op, err)
+		return finished
+	}
+
+	logrus.Warnf("用户 %s 的批量同步被拒绝, 逐条提交: %v", did, err)
+	for _, write := range writes {
+		uri, cid, err := writeRecord(ctx, client, did, write)
+		if err != nil {
+			if !sm.fail(write.op, err) {
+				break
+			}
+			finished++
+			continue
+		}
+		sm.complete(write, uri, cid)
+		finished++
+	}
+	return finished
+}
+
+func (sm *SyncerManager) complete(write *recordWrite, uri string, cid string) {
+	op := write.op
+	if op.Action != repositories.OutboxActionDelete {
+		if err := sm.syncers[op.Collection].Synced(op, uri, cid); err != nil {
+			logrus.Errorf("回写记录 %s 的 CID 失败: %v", uri, err)
+		}
+	}
+	if err := sm.metaStore.OutboxRepo.MarkOutboxOpDone(op.ID, uri, cid); err != nil {
+		logrus.Errorf("更新同步操作 %d 状态失败: %v", op.ID, err)
+	}
+	sm.record(op.Collection, func(stats *CollectionStats) {
+		stats.Synced++
+		stats.LastSyncTime = time.Now()
+	})
+}
+
+// skip 本地记录已删除, 直接结束对应的操作
+func (sm *SyncerManager) skip(op *repositories.PDSOutboxOp) {
+	if err := sm.metaStore.OutboxRepo.MarkOutboxOpDone(op.ID, "", ""); err != nil {
+		logrus.Errorf("更新同步操作 %d 状态失败: %v", op.ID, err)
+	}
+}
+
+// fail 处理失败的操作: 可重试的按指数退避安排下一次投递, 否则或超过重试次数时标记为失败
+// 返回 true 表示该操作已最终失败, 同一用户后续的操作可以继续处理
+func (sm *SyncerManager) fail(op *repositories.PDSOutboxOp, cause error) bool {
+	outboxRepo := sm.metaStore.OutboxRepo
+	attempts := op.Attempts + 1
+	message := cause.Error()
+
+	if !isRetryable(cause) || attempts >= sm.config.MaxRetries {
+		logrus.Errorf("同步 %s/%s/%s (%s) 失败, 已尝试 %d 次: %v", op.Did, op.Collection, op.Rkey, op.Action, attempts, cause)
+		if err := outboxRepo.MarkOutboxOpFailed(op.ID, attempts, message); err != nil {
+			logrus.Errorf("更新同步操作 %d 状态失败: %v", op.ID, err)
+		}
+		sm.record(op.Collection, func(stats *CollectionStats) {
+			stats.Failed++
+			stats.LastError = message
+		})
+		return true
+	}
+
+	delay := sm.backoff(attempts)
+	logrus.Warnf("同步 %s/%s/%s (%s) 失败, %s 后重试: %v", op.Did, op.Collection, op.Rkey, op.Action, delay, cause)
+	if err := outboxRepo.MarkOutboxOpRetry(op.ID, attempts, time.Now().Add(delay).UnixMilli(), message); err != nil {
+		logrus.Errorf("更新同步操作 %d 状态失败: %v", op.ID, err)
+	}
+	sm.record(op.Collection, func(stats *CollectionStats) {
+		stats.Retried++
+		stats.LastError = message
+	})
+	return false
+}
+
+// backoff 第 n 次失败后的重试延迟: RetryDelay * 2^(n-1), 不超过 MaxRetryDelay
+func (sm *SyncerManager) backoff(attempts int) time.Duration {
+	delay := sm.config.RetryDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= sm.config.MaxRetryDelay {
+			return sm.config.MaxRetryDelay
+		}
+	}
+	return delay
+}
+
+func (sm *SyncerManager) purge() {
+	if time.Since(sm.lastPurge) < purgeInterval {
+		return
+	}
+	sm.lastPurge = time.Now()
+
+	before := time.Now().Add(-sm.config.Retention).UnixMilli()
+	count, err := sm.metaStore.OutboxRepo.PurgeDoneOutboxOps(before)
+	if err != nil {
+		logrus.Errorf("清理已完成的同步操作失败: %v", err)
+		return
+	}
+	if count > 0 {
+		logrus.Infof("清理了 %d 条已完成的同步操作", count)
+	}
+}
+
+func (sm *SyncerManager) record(collection string, update func(stats *CollectionStats)) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	stats, ok := sm.stats[collection]
+	if !ok {
+		stats = &CollectionStats{}
+		sm.stats[collection] = stats
+	}
+	update(stats)
+}
+
+// GetStatus 获取同步器状态, 包含进程内的统计和数据库中的队列情况
+func (sm *SyncerManager) GetStatus() (*SyncerStatus, error) {
+	queue, err := sm.metaStore.OutboxRepo.CountOutboxOpsByCollection()
+	if err != nil {
+		return nil, fmt.Errorf("统计同步队列失败: %w", err)
+	}
+
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	status := &SyncerStatus{
+		Running:     sm.running,
+		StartTime:   sm.startTime,
+		Collections: make(map[string]*CollectionStats, len(sm.stats)),
+	}
+	if sm.running {
+		status.Uptime = time.Since(sm.startTime).Round(time.Second).String()
+	}
+	for collection, stats := range sm.stats {
+		copied := *stats
+		copied.Queue = make(map[string]int64)
+		status.Collections[collection] = &copied
+	}
+	for _, stat := range queue {
+		stats, ok := status.Collections[stat.Collection]
+		if !ok {
+			stats = &CollectionStats{Queue: make(map[string]int64)}
+			status.Collections[stat.Collection] = stats
+		}
+		stats.Queue[stat.Status] = stat.Count
+	}
+	return status, nil
+}
@@ -0,0 +1,376 @@
+package syncers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakePDS 模拟 PDS 的 applyWrites 接口: 校验 DPoP nonce, 可以让指定用户的请求返回 5xx, 记录每次提交的写入
+type fakePDS struct {
+	mu       sync.Mutex
+	nonce    string
+	failures map[string]int // did -> 剩余的 502 次数
+	batches  []appliedBatch
+	rejected int // 因 nonce 过期被拒绝的请求数
+}
+
+type appliedBatch struct {
+	did    string
+	writes []string // action collection/rkey
+}
+
+func newFakePDS(t *testing.T, nonce string) (*fakePDS, *httptest.Server) {
+	t.Helper()
+	pds := &fakePDS{nonce: nonce, failures: make(map[string]int)}
+	server := httptest.NewServer(http.HandlerFunc(pds.handle))
+	t.Cleanup(server.Close)
+	return pds, server
+}
+
+func (p *fakePDS) handle(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if r.URL.Path != "/xrpc/com.atproto.repo.applyWrites" {
+		writeXRPCError(w, http.StatusNotImplemented, "MethodNotImplemented")
+		return
+	}
+	if dpopNonce(r.Header.Get("DPoP")) != p.nonce {
+		p.rejected++
+		w.Header().Set("DPoP-Nonce", p.nonce)
+		writeXRPCError(w, http.StatusUnauthorized, "use_dpop_nonce")
+		return
+	}
+
+	var input struct {
+		Repo   string `json:"repo"`
+		Writes []struct {
+			Type       string `json:"$type"`
+			Collection string `json:"collection"`
+			Rkey       string `json:"rkey"`
+		} `json:"writes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeXRPCError(w, http.StatusBadRequest, "InvalidRequest")
+		return
+	}
+	if p.failures[input.Repo] > 0 {
+		p.failures[input.Repo]--
+		writeXRPCError(w, http.StatusBadGateway, "UpstreamFailure")
+		return
+	}
+
+	batch := appliedBatch{did: input.Repo}
+	results := make([]map[string]string, 0, len(input.Writes))
+	for _, write := range input.Writes {
+		action := strings.TrimPrefix(write.Type, "com.atproto.repo.applyWrites#")
+		batch.writes = append(batch.writes, fmt.Sprintf("%s %s/%s", action, write.Collection, write.Rkey))
+		if action == "delete" {
+			results = append(results, map[string]string{"$type": "com.atproto.repo.applyWrites#deleteResult"})
+			continue
+		}
+		results = append(results, map[string]string{
+			"$type": "com.atproto.repo.applyWrites#" + action + "Result",
+			"uri":   fmt.Sprintf("at://%s/%s/%s", input.Repo, write.Collection, write.Rkey),
+			"cid":   "bafy" + write.Rkey,
+		})
+	}
+	p.batches = append(p.batches, batch)
+	json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+}
+
+func (p *fakePDS) applied() []appliedBatch {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]appliedBatch(nil), p.batches...)
+}
+
+func writeXRPCError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code, "message": code})
+}
+
+// dpopNonce 读取 DPoP 证明中的 nonce, 测试只关心 nonce 刷新, 不校验签名
+func dpopNonce(proof string) string {
+	parts := strings.Split(proof, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Nonce string `json:"nonce"`
+	}
+	json.Unmarshal(payload, &claims)
+	return claims.Nonce
+}
+
+func newTestMetaStore(t *testing.T) *repositories.MetaStore {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.sqlite")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	store := repositories.NewMetaStore(db)
+	if err := store.Init(); err != nil {
+		t.Fatalf("init metastore: %v", err)
+	}
+	return store
+}
+
+func addSession(t *testing.T, store *repositories.MetaStore, did string, pdsURL string, nonce string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	jwk, err := jose.JSONWebKey{Key: key, KeyID: did, Algorithm: string(jose.ES256), Use: "sig"}.MarshalJSON()
+	if err != nil {
+		t.Fatalf("marshal jwk: %v", err)
+	}
+	err = store.DB.Create(&repositories.OAuthSession{
+		Did:            did,
+		PdsUrl:         pdsURL,
+		AuthserverIss:  pdsURL,
+		AccessToken:    "token-" + did,
+		DpopPdsNonce:   nonce,
+		DpopPrivateJwk: string(jwk),
+	}).Error
+	if err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+}
+
+// addTag 写入一个标签并加入同步队列
+func addTag(t *testing.T, store *repositories.MetaStore, did string, id string) {
+	t.Helper()
+	tag := &repositories.Tag{ID: id, URI: fmt.Sprintf("at://%s/%s/%s", did, TagCollection, id), Tag: id, Creator: did, CreatedAt: time.Now().Unix()}
+	if err := store.MomentRepo.CreateTag(tag); err != nil {
+		t.Fatalf("create tag: %v", err)
+	}
+	if err := store.OutboxRepo.EnqueueRecordOp(did, TagCollection, id, repositories.OutboxActionCreate); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+}
+
+func outboxOps(t *testing.T, store *repositories.MetaStore) map[string]*repositories.PDSOutboxOp {
+	t.Helper()
+	var ops []*repositories.PDSOutboxOp
+	if err := store.DB.Order("id").Find(&ops).Error; err != nil {
+		t.Fatalf("list outbox: %v", err)
+	}
+	byRkey := make(map[string]*repositories.PDSOutboxOp, len(ops))
+	for _, op := range ops {
+		byRkey[op.Rkey] = op
+	}
+	return byRkey
+}
+
+func TestSyncBatchesWritesPerDID(t *testing.T) {
+	store := newTestMetaStore(t)
+	pds, server := newFakePDS(t, "n1")
+	addSession(t, store, "did:plc:alice", server.URL, "n1")
+	addSession(t, store, "did:plc:bob", server.URL, "n1")
+	addTag(t, store, "did:plc:alice", "a1")
+	addTag(t, store, "did:plc:bob", "b1")
+	addTag(t, store, "did:plc:alice", "a2")
+	addTag(t, store, "did:plc:alice", "a3")
+	if err := store.OutboxRepo.EnqueueRecordOp("did:plc:alice", TagCollection, "a0", repositories.OutboxActionDelete); err != nil {
+		t.Fatalf("enqueue delete: %v", err)
+	}
+
+	sm := NewSyncerManager(store, config.SyncerConfig{})
+	sm.drain(context.Background())
+
+	batches := pds.applied()
+	if len(batches) != 2 {
+		t.Fatalf("got %d applyWrites calls, want one per did: %+v", len(batches), batches)
+	}
+	want := map[string]string{
+		"did:plc:alice": "create app.vtri.activity.tag/a1,create app.vtri.activity.tag/a2,create app.vtri.activity.tag/a3,delete app.vtri.activity.tag/a0",
+		"did:plc:bob":   "create app.vtri.activity.tag/b1",
+	}
+	for _, batch := range batches {
+		if got := strings.Join(batch.writes, ","); got != want[batch.did] {
+			t.Errorf("%s writes = %s, want %s", batch.did, got, want[batch.did])
+		}
+	}
+
+	for rkey, op := range outboxOps(t, store) {
+		if op.Status != repositories.OutboxStatusDone {
+			t.Errorf("op %s status = %s, want done", rkey, op.Status)
+		}
+	}
+	tag, err := store.MomentRepo.GetTagByID("a2")
+	if err != nil {
+		t.Fatalf("get tag: %v", err)
+	}
+	if tag.CID != "bafya2" {
+		t.Errorf("tag cid = %q, want the cid returned by the PDS", tag.CID)
+	}
+}
+
+func TestSyncRetriesServerErrorsInOrder(t *testing.T) {
+	store := newTestMetaStore(t)
+	pds, server := newFakePDS(t, "n1")
+	pds.failures["did:plc:alice"] = 2
+	addSession(t, store, "did:plc:alice", server.URL, "n1")
+	addSession(t, store, "did:plc:bob", server.URL, "n1")
+	addTag(t, store, "did:plc:alice", "a1")
+	addTag(t, store, "did:plc:bob", "b1")
+
+	const retryDelay = 100 * time.Millisecond
+	sm := NewSyncerManager(store, config.SyncerConfig{RetryDelay: retryDelay, MaxRetryDelay: time.Second})
+	ctx := context.Background()
+
+	start := time.Now()
+	sm.drain(ctx)
+	// alice 失败后新入队的操作不能越过等待重试的第一条
+	addTag(t, store, "did:plc:alice", "a2")
+	sm.drain(ctx)
+
+	if batches := pds.applied(); len(batches) != 1 || batches[0].did != "did:plc:bob" {
+		t.Fatalf("applied batches = %+v, want only bob's", batches)
+	}
+	ops := outboxOps(t, store)
+	first := ops["a1"]
+	if first.Status != repositories.OutboxStatusPending || first.Attempts != 1 || first.LastError == "" {
+		t.Fatalf("a1 = %+v, want pending with one failed attempt", first)
+	}
+	if delay := time.Duration(first.NextAttemptAt-start.UnixMilli()) * time.Millisecond; delay < retryDelay {
+		t.Errorf("first retry scheduled after %s, want at least %s", delay, retryDelay)
+	}
+	if ops["a2"].Attempts != 0 || ops["a2"].Status != repositories.OutboxStatusPending {
+		t.Errorf("a2 = %+v, want untouched behind a1", ops["a2"])
+	}
+
+	// 第二次失败, 重试延迟翻倍
+	time.Sleep(time.Until(time.UnixMilli(first.NextAttemptAt)))
+	sm.drain(ctx)
+	second := outboxOps(t, store)["a1"]
+	if second.Attempts != 2 {
+		t.Fatalf("a1 attempts = %d, want 2", second.Attempts)
+	}
+	if gap := time.Duration(second.NextAttemptAt-second.UpdatedAt) * time.Millisecond; gap < 2*retryDelay-10*time.Millisecond {
+		t.Errorf("second retry delay = %s, want about %s", gap, 2*retryDelay)
+	}
+
+	time.Sleep(time.Until(time.UnixMilli(second.NextAttemptAt)))
+	sm.drain(ctx)
+
+	batches := pds.applied()
+	if len(batches) != 2 {
+		t.Fatalf("applied batches = %+v, want alice's retry", batches)
+	}
+	if got := strings.Join(batches[1].writes, ","); got != "create app.vtri.activity.tag/a1,create app.vtri.activity.tag/a2" {
+		t.Errorf("alice writes = %s, want a1 before a2 in one batch", got)
+	}
+	for rkey, op := range outboxOps(t, store) {
+		if op.Status != repositories.OutboxStatusDone {
+			t.Errorf("op %s status = %s, want done", rkey, op.Status)
+		}
+	}
+}
+
+func TestSyncGivesUpAfterMaxRetries(t *testing.T) {
+	store := newTestMetaStore(t)
+	pds, server := newFakePDS(t, "n1")
+	pds.failures["did:plc:alice"] = 100
+	addSession(t, store, "did:plc:alice", server.URL, "n1")
+	addTag(t, store, "did:plc:alice", "a1")
+
+	sm := NewSyncerManager(store, config.SyncerConfig{MaxRetries: 2, RetryDelay: time.Millisecond})
+	for i := 0; i < 2; i++ {
+		time.Sleep(5 * time.Millisecond)
+		sm.drain(context.Background())
+	}
+
+	op := outboxOps(t, store)["a1"]
+	if op.Status != repositories.OutboxStatusFailed || op.Attempts != 2 {
+		t.Fatalf("a1 = %+v, want failed after 2 attempts", op)
+	}
+	status, err := sm.GetStatus()
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if stats := status.Collections[TagCollection]; stats.Retried != 1 || stats.Failed != 1 || stats.Queue[repositories.OutboxStatusFailed] != 1 {
+		t.Errorf("tag stats = %+v", stats)
+	}
+}
+
+func TestSyncRefreshesDPoPNonce(t *testing.T) {
+	store := newTestMetaStore(t)
+	pds, server := newFakePDS(t, "fresh")
+	addSession(t, store, "did:plc:alice", server.URL, "stale")
+	addTag(t, store, "did:plc:alice", "a1")
+
+	sm := NewSyncerManager(store, config.SyncerConfig{})
+	sm.drain(context.Background())
+
+	if pds.rejected != 1 {
+		t.Errorf("PDS rejected %d requests, want exactly the stale one", pds.rejected)
+	}
+	if batches := pds.applied(); len(batches) != 1 {
+		t.Fatalf("applied batches = %+v, want the retried request", batches)
+	}
+	if op := outboxOps(t, store)["a1"]; op.Status != repositories.OutboxStatusDone || op.Attempts != 0 {
+		t.Errorf("a1 = %+v, want done without a scheduled retry", op)
+	}
+	session, err := store.OAuthRepo.GetOAuthSessionByDID("did:plc:alice")
+	if err != nil {
+		t.Fatalf("get session: %v", err)
+	}
+	if session.DpopPdsNonce != "fresh" {
+		t.Errorf("stored nonce = %q, want the nonce issued by the PDS", session.DpopPdsNonce)
+	}
+
+	// 下一次同步直接使用保存的 nonce
+	addTag(t, store, "did:plc:alice", "a2")
+	sm.drain(context.Background())
+	if pds.rejected != 1 || len(pds.applied()) != 2 {
+		t.Errorf("rejected %d, applied %d, want the stored nonce to be reused", pds.rejected, len(pds.applied()))
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	sm := &SyncerManager{config: config.SyncerConfig{RetryDelay: time.Second, MaxRetryDelay: 10 * time.Second}}
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{30, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := sm.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package syncers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/zhongshangwu/avatarai-social/pkg/atproto"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/types"
+)
+
+var errNoSession = errors.New("用户没有有效的 OAuth 会话")
+
+// recordWrite 一条待提交的记录写入, delete 时 record 为空
+type recordWrite struct {
+	op     *repositories.PDSOutboxOp
+	record util.CBOR
+}
+
+type writeResult struct {
+	uri string
+	cid string
+}
+
+// newClient 使用用户的 OAuth 会话创建 XRPC 客户端, PDS 下发的新 DPoP nonce 会写回会话
+func (sm *SyncerManager) newClient(did string) (*atproto.XrpcClient, error) {
+	session, err := sm.metaStore.OAuthRepo.GetOAuthSessionByDID(did)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNoSession, err)
+	}
+	client, err := atproto.NewXrpcClient(toOAuthSession(session), atproto.WithNonceUpdateCallback(func(did, newNonce string) error {
+		return sm.metaStore.OAuthRepo.UpdateOAuthSessionDpopPdsNonce(did, newNonce)
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("创建 XRPC 客户端失败: %w", err)
+	}
+	return client, nil
+}
+
+// applyWrites 将一个用户的多条写入作为一次提交, 结果与 writes 一一对应
+func applyWrites(ctx context.Context, client *atproto.XrpcClient, did string, writes []*recordWrite) ([]writeResult, error) {
+	input := comatproto.RepoApplyWrites_Input{
+		Repo:   did,
+		Writes: make([]*comatproto.RepoApplyWrites_Input_Writes_Elem, 0, len(writes)),
+	}
+	for _, write := range writes {
+		op := write.op
+		elem := &comatproto.RepoApplyWrites_Input_Writes_Elem{}
+		switch op.Action {
+		case repositories.OutboxActionCreate:
+			rkey := op.Rkey
+			elem.RepoApplyWrites_Create = &comatproto.RepoApplyWrites_Create{
+				LexiconTypeID: "com.atproto.repo.applyWrites#create",
+				Collection:    op.Collection,
+				Rkey:          &rkey,
+				Value:         &util.LexiconTypeDecoder{Val: write.record},
+			}
+		case repositories.OutboxActionUpdate:
+			elem.RepoApplyWrites_Update = &comatproto.RepoApplyWrites_Update{
+				LexiconTypeID: "com.atproto.repo.applyWrites#update",
+				Collection:    op.Collection,
+				Rkey:          op.Rkey,
+				Value:         &util.LexiconTypeDecoder{Val: write.record},
+			}
+		case repositories.OutboxActionDelete:
+			elem.RepoApplyWrites_Delete = &comatproto.RepoApplyWrites_Delete{
+				LexiconTypeID: "com.atproto.repo.applyWrites#delete",
+				Collection:    op.Collection,
+				Rkey:          op.Rkey,
+			}
+		default:
+			return nil, fmt.Errorf("未知的同步操作: %s", op.Action)
+		}
+		input.Writes = append(input.Writes, elem)
+	}
+
+	var output comatproto.RepoApplyWrites_Output
+	if err := client.Procedure(ctx, "com.atproto.repo.applyWrites", nil, input, &output); err != nil {
+		return nil, err
+	}
+
+	results := make([]writeResult, len(writes))
+	for i := range writes {
+		if i >= len(output.Results) || output.Results[i] == nil {
+			continue
+		}
+		switch result := output.Results[i]; {
+		case result.RepoApplyWrites_CreateResult != nil:
+			results[i] = writeResult{uri: result.RepoApplyWrites_CreateResult.Uri, cid: result.RepoApplyWrites_CreateResult.Cid}
+		case result.RepoApplyWrites_UpdateResult != nil:
+			results[i] = writeResult{uri: result.RepoApplyWrites_UpdateResult.Uri, cid: result.RepoApplyWrites_UpdateResult.Cid}
+		}
+	}
+	return results, nil
+}
+
+// writeRecord 单独提交一条写入, create/update 使用 putRecord, 重复提交也不会出错
+func writeRecord(ctx context.Context, client *atproto.XrpcClient, did string, write *recordWrite) (string, string, error) {
+	op := write.op
+	if op.Action == repositories.OutboxActionDelete {
+		input := comatproto.RepoDeleteRecord_Input{
+			Repo:       did,
+			Collection: op.Collection,
+			Rkey:       op.Rkey,
+		}
+		if err := client.Procedure(ctx, "com.atproto.repo.deleteRecord", nil, input, nil); err != nil {
+			return "", "", err
+		}
+		return "", "", nil
+	}
+
+	input := comatproto.RepoPutRecord_Input{
+		Repo:       did,
+		Collection: op.Collection,
+		Rkey:       op.Rkey,
+		Record:     &util.LexiconTypeDecoder{Val: write.record},
+	}
+	var output comatproto.RepoPutRecord_Output
+	if err := client.Procedure(ctx, "com.atproto.repo.putRecord", nil, input, &output); err != nil {
+		return "", "", err
+	}
+	return output.Uri, output.Cid, nil
+}
+
+// isRetryable 网络错误、服务端错误、限流、鉴权过期以及依赖未就绪时可以重试, 记录本身被拒绝时不重试
+func isRetryable(err error) bool {
+	if errors.Is(err, ErrDependencyPending) || errors.Is(err, errNoSession) {
+		return true
+	}
+	var xrpcErr *xrpc.Error
+	if !errors.As(err, &xrpcErr) {
+		return true
+	}
+	switch {
+	case xrpcErr.StatusCode >= http.StatusInternalServerError,
+		xrpcErr.StatusCode == http.StatusUnauthorized,
+		xrpcErr.StatusCode == http.StatusRequestTimeout,
+		xrpcErr.StatusCode == http.StatusTooManyRequests:
+		return true
+	}
+	var remoteErr *xrpc.XRPCError
+	if errors.As(err, &remoteErr) {
+		switch remoteErr.ErrStr {
+		case "use_dpop_nonce", "ExpiredToken", "InvalidToken":
+			return true
+		}
+	}
+	return false
+}
+
+func toOAuthSession(session *repositories.OAuthSession) *types.OAuthSession {
+	return &types.OAuthSession{
+		ID:                  fmt.Sprintf("%d", session.ID),
+		Did:                 session.Did,
+		Handle:              session.Handle,
+		PdsUrl:              session.PdsUrl,
+		AuthserverIss:       session.AuthserverIss,
+		AccessToken:         session.AccessToken,
+		RefreshToken:        session.RefreshToken,
+		DpopAuthserverNonce: session.DpopAuthserverNonce,
+		DpopPdsNonce:        session.DpopPdsNonce,
+		DpopPrivateJwk:      session.DpopPrivateJwk,
+		ExpiresIn:           session.ExpiresIn,
+		CreatedAt:           session.CreatedAt,
+		Provider:            types.OAuthProviderType(session.Platform),
+		ReturnURI:           session.ReturnURI,
+	}
+}
@@ -1,7 +1,29 @@
 package embedding
 
-import "context"
+import (
+	"context"
+	"fmt"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+)
+
+const (
+	ProviderOpenAI  = "openai"
+	ProviderHashing = "hashing"
+)
 
 type Embedding interface {
 	Embed(ctx context.Context, text string) ([]float32, error)
 }
+
+// NewEmbedding 根据配置创建向量化实现, 未配置 provider 时使用 hashing
+func NewEmbedding(cfg config.EmbeddingConfig) (Embedding, error) {
+	switch cfg.Provider {
+	case ProviderOpenAI:
+		return NewOpenAIEmbedding(cfg.APIURL, cfg.APIKey, cfg.Model, cfg.Dimensions), nil
+	case ProviderHashing, "":
+		return NewHashingEmbedding(cfg.Dimensions), nil
+	default:
+		return nil, fmt.Errorf("unsupported embedding provider: %s", cfg.Provider)
+	}
+}
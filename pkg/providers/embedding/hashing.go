@@ -0,0 +1,92 @@
+package embedding
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+const hashingDefaultDimensions = 256
+
+// HashingEmbedding 基于特征哈希的确定性向量化, 不依赖外部服务, 用于本地调试和测试
+//
+// 英文等按单词切分, 中日韩文字按单字和相邻双字切分, 每个特征哈希到一个维度并带符号累加,
+// 最后做 L2 归一化, 因此相同文本总是得到相同向量, 词汇重叠越多余弦相似度越高.
+type HashingEmbedding struct {
+	dimensions int
+}
+
+func NewHashingEmbedding(dimensions int) *HashingEmbedding {
+	if dimensions <= 0 {
+		dimensions = hashingDefaultDimensions
+	}
+	return &HashingEmbedding{dimensions: dimensions}
+}
+
+func (e *HashingEmbedding) Embed(ctx context.Context, text string) ([]float32, error) {
+	vector := make([]float32, e.dimensions)
+	for _, feature := range hashingFeatures(text) {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		index := int(sum % uint64(e.dimensions))
+		if sum>>63 == 1 {
+			vector[index] -= 1
+		} else {
+			vector[index] += 1
+		}
+	}
+
+	var norm float64
+	for _, value := range vector {
+		norm += float64(value) * float64(value)
+	}
+	if norm == 0 {
+		return vector, nil
+	}
+	norm = math.Sqrt(norm)
+	for i := range vector {
+		vector[i] = float32(float64(vector[i]) / norm)
+	}
+	return vector, nil
+}
+
+func hashingFeatures(text string) []string {
+	var features []string
+	var word []rune
+	var prevCJK rune
+
+	flushWord := func() {
+		if len(word) > 0 {
+			features = append(features, string(word))
+			word = word[:0]
+		}
+	}
+
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case isCJK(r):
+			flushWord()
+			features = append(features, string(r))
+			if prevCJK != 0 {
+				features = append(features, string([]rune{prevCJK, r}))
+			}
+			prevCJK = r
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			prevCJK = 0
+			word = append(word, r)
+		default:
+			prevCJK = 0
+			flushWord()
+		}
+	}
+	flushWord()
+	return features
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
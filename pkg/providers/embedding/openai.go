@@ -0,0 +1,60 @@
+package embedding
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+const openAIDefaultEmbeddingModel = "text-embedding-3-small"
+
+// OpenAIEmbedding 兼容 OpenAI /embeddings 接口的向量化实现
+type OpenAIEmbedding struct {
+	client     openai.Client
+	model      string
+	dimensions int
+}
+
+func NewOpenAIEmbedding(baseURL string, apiKey string, model string, dimensions int) *OpenAIEmbedding {
+	options := make([]option.RequestOption, 0)
+	if apiKey != "" {
+		options = append(options, option.WithAPIKey(apiKey))
+	}
+	if baseURL != "" {
+		options = append(options, option.WithBaseURL(baseURL))
+	}
+	if model == "" {
+		model = openAIDefaultEmbeddingModel
+	}
+	return &OpenAIEmbedding{
+		client:     openai.NewClient(options...),
+		model:      model,
+		dimensions: dimensions,
+	}
+}
+
+func (e *OpenAIEmbedding) Embed(ctx context.Context, text string) ([]float32, error) {
+	params := openai.EmbeddingNewParams{
+		Input: openai.EmbeddingNewParamsInputUnion{OfString: openai.String(text)},
+		Model: openai.EmbeddingModel(e.model),
+	}
+	if e.dimensions > 0 {
+		params.Dimensions = openai.Int(int64(e.dimensions))
+	}
+
+	resp, err := e.client.Embeddings.New(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("请求 embedding 失败: %w", err)
+	}
+	if len(resp.Data) == 0 {
+		return nil, fmt.Errorf("embedding 响应为空")
+	}
+
+	vector := make([]float32, len(resp.Data[0].Embedding))
+	for i, value := range resp.Data[0].Embedding {
+		vector[i] = float32(value)
+	}
+	return vector, nil
+}
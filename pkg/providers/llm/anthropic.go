@@ -0,0 +1,458 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zhongshangwu/avatarai-social/pkg/streams"
+)
+
+const (
+	anthropicDefaultBaseURL   = "https://api.anthropic.com"
+	anthropicDefaultVersion   = "2023-06-01"
+	anthropicDefaultMaxTokens = 4096
+)
+
+// AnthropicClient 基于 Anthropic Messages API 的原生实现
+type AnthropicClient struct{}
+
+type anthropicRequest struct {
+	Model         string             `json:"model"`
+	System        string             `json:"system,omitempty"`
+	Messages      []anthropicMessage `json:"messages"`
+	MaxTokens     int64              `json:"max_tokens"`
+	Temperature   *float64           `json:"temperature,omitempty"`
+	TopP          *float64           `json:"top_p,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Tools         []anthropicTool    `json:"tools,omitempty"`
+	Stream        bool               `json:"stream,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string                  `json:"role"`
+	Content []anthropicContentBlock `json:"content"`
+}
+
+type anthropicContentBlock struct {
+	Type      string                `json:"type"`
+	Text      string                `json:"text,omitempty"`
+	Source    *anthropicImageSource `json:"source,omitempty"`
+	ID        string                `json:"id,omitempty"`
+	Name      string                `json:"name,omitempty"`
+	Input     json.RawMessage       `json:"input,omitempty"`
+	ToolUseID string                `json:"tool_use_id,omitempty"`
+	Content   string                `json:"content,omitempty"`
+}
+
+type anthropicImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"input_schema"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int64 `json:"input_tokens"`
+	OutputTokens int64 `json:"output_tokens"`
+}
+
+type anthropicResponse struct {
+	ID         string                  `json:"id"`
+	Model      string                  `json:"model"`
+	Content    []anthropicContentBlock `json:"content"`
+	StopReason string                  `json:"stop_reason"`
+	Usage      anthropicUsage          `json:"usage"`
+}
+
+type anthropicStreamEvent struct {
+	Type         string                 `json:"type"`
+	Index        int                    `json:"index"`
+	Message      *anthropicResponse     `json:"message,omitempty"`
+	ContentBlock *anthropicContentBlock `json:"content_block,omitempty"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *anthropicUsage `json:"usage,omitempty"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+func (a *AnthropicClient) ChatStream(
+	ctx context.Context,
+	model string,
+	credentials map[string]interface{},
+	promptMessages []*PromptMessage,
+	modelParameters map[string]interface{},
+	tools []PromptMessageTool,
+	stop []string,
+) (*streams.Stream[*LLMResultChunk], error) {
+	body, err := a.doRequest(ctx, model, credentials, promptMessages, modelParameters, tools, stop, true)
+	if err != nil {
+		return nil, err
+	}
+
+	respStream := streams.NewStream[*LLMResultChunk](ctx, 5)
+	go func() {
+		defer respStream.CloseSend()
+		defer body.Close()
+
+		// Anthropic 按 content block 的序号输出, 需要映射为本轮工具调用的序号
+		toolIndexes := make(map[int]int)
+		usage := &Usage{}
+		stopped := false
+
+		send := func(content string, toolCalls []ToolCall, finishReason string, withUsage bool) {
+			chunk := &LLMResultChunk{
+				Model:          model,
+				PromptMessages: promptMessages,
+				Delta: LLMResultChunkDelta{
+					Message:      NewAssistantPromptMessage(content, "", toolCalls),
+					FinishReason: finishReason,
+				},
+			}
+			if withUsage {
+				chunk.Delta.Usage = usage
+			}
+			respStream.Send(chunk)
+		}
+
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data:") {
+				continue
+			}
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+
+			var event anthropicStreamEvent
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				logrus.Errorf("解析 anthropic 流事件失败: %v", err)
+				continue
+			}
+
+			switch event.Type {
+			case "message_start":
+				if event.Message != nil {
+					usage.PromptTokens = event.Message.Usage.InputTokens
+					if event.Message.Model != "" {
+						model = event.Message.Model
+					}
+				}
+			case "content_block_start":
+				if event.ContentBlock != nil && event.ContentBlock.Type == "tool_use" {
+					toolIndex := len(toolIndexes)
+					toolIndexes[event.Index] = toolIndex
+					send("", []ToolCall{{
+						Index:    toolIndex,
+						ID:       event.ContentBlock.ID,
+						Type:     "function",
+						Function: ToolCallFunction{Name: event.ContentBlock.Name},
+					}}, "", false)
+				}
+			case "content_block_delta":
+				switch event.Delta.Type {
+				case "text_delta":
+					send(event.Delta.Text, nil, "", false)
+				case "input_json_delta":
+					toolIndex, ok := toolIndexes[event.Index]
+					if !ok || event.Delta.PartialJSON == "" {
+						continue
+					}
+					send("", []ToolCall{{
+						Index:    toolIndex,
+						Type:     "function",
+						Function: ToolCallFunction{Arguments: event.Delta.PartialJSON},
+					}}, "", false)
+				}
+			case "message_delta":
+				if event.Usage != nil {
+					usage.CompletionTokens = event.Usage.OutputTokens
+				}
+				usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+				send("", nil, anthropicFinishReason(event.Delta.StopReason), true)
+			case "message_stop":
+				stopped = true
+			case "error":
+				if event.Error != nil {
+					respStream.SendError(fmt.Errorf("anthropic stream error: %s: %s", event.Error.Type, event.Error.Message))
+				}
+				return
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			respStream.SendError(err)
+			return
+		}
+		// 连接在 message_stop 之前断开时回复并不完整, 不能当作正常结束
+		if !stopped {
+			respStream.SendError(fmt.Errorf("anthropic 流在 message_stop 之前结束: %w", io.ErrUnexpectedEOF))
+		}
+	}()
+
+	return respStream, nil
+}
+
+func (a *AnthropicClient) Chat(
+	ctx context.Context,
+	model string,
+	credentials map[string]interface{},
+	promptMessages []*PromptMessage,
+	modelParameters map[string]interface{},
+	tools []PromptMessageTool,
+	stop []string,
+) (*LLMResult, error) {
+	body, err := a.doRequest(ctx, model, credentials, promptMessages, modelParameters, tools, stop, false)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var resp anthropicResponse
+	if err := json.NewDecoder(body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("解析 anthropic 响应失败: %w", err)
+	}
+
+	var text strings.Builder
+	var toolCalls []ToolCall
+	for _, block := range resp.Content {
+		switch block.Type {
+		case "text":
+			text.WriteString(block.Text)
+		case "tool_use":
+			arguments := string(block.Input)
+			if arguments == "" {
+				arguments = "{}"
+			}
+			toolCalls = append(toolCalls, ToolCall{
+				Index:    len(toolCalls),
+				ID:       block.ID,
+				Type:     "function",
+				Function: ToolCallFunction{Name: block.Name, Arguments: arguments},
+			})
+		}
+	}
+
+	return &LLMResult{
+		Model:          resp.Model,
+		PromptMessages: promptMessages,
+		Message:        NewAssistantPromptMessage(text.String(), "", toolCalls),
+		Usage: &Usage{
+			PromptTokens:     resp.Usage.InputTokens,
+			CompletionTokens: resp.Usage.OutputTokens,
+			TotalTokens:      resp.Usage.InputTokens + resp.Usage.OutputTokens,
+		},
+	}, nil
+}
+
+func (a *AnthropicClient) doRequest(
+	ctx context.Context,
+	model string,
+	credentials map[string]interface{},
+	promptMessages []*PromptMessage,
+	modelParameters map[string]interface{},
+	tools []PromptMessageTool,
+	stop []string,
+	stream bool,
+) (io.ReadCloser, error) {
+	system, messages := a.convertPromptMessages(promptMessages)
+	request := anthropicRequest{
+		Model:         model,
+		System:        system,
+		Messages:      messages,
+		MaxTokens:     anthropicDefaultMaxTokens,
+		StopSequences: stop,
+		Stream:        stream,
+	}
+	if maxTokens, ok := intParam(modelParameters, "max_tokens"); ok {
+		request.MaxTokens = maxTokens
+	}
+	if temperature, ok := floatParam(modelParameters, "temperature"); ok {
+		request.Temperature = &temperature
+	}
+	if topP, ok := floatParam(modelParameters, "top_p"); ok {
+		request.TopP = &topP
+	}
+	for _, tool := range tools {
+		request.Tools = append(request.Tools, anthropicTool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: tool.Parameters,
+		})
+	}
+
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := anthropicDefaultBaseURL
+	if url, ok := credentials["base_url"].(string); ok && url != "" {
+		baseURL = strings.TrimSuffix(url, "/")
+	}
+	version := anthropicDefaultVersion
+	if v, ok := credentials["anthropic_version"].(string); ok && v != "" {
+		version = v
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/v1/messages", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("anthropic-version", version)
+	if apiKey, ok := credentials["api_key"].(string); ok {
+		req.Header.Set("x-api-key", apiKey)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("anthropic 请求失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		errBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("anthropic 请求失败: status=%d body=%s", resp.StatusCode, string(errBody))
+	}
+	return resp.Body, nil
+}
+
+// convertPromptMessages 将 PromptMessage 转换为 Anthropic 格式
+// system 消息合并为顶层 system 字段, tool 消息转换为 user 角色的 tool_result 块
+func (a *AnthropicClient) convertPromptMessages(promptMessages []*PromptMessage) (string, []anthropicMessage) {
+	var systems []string
+	var messages []anthropicMessage
+
+	appendBlocks := func(role string, blocks []anthropicContentBlock) {
+		if len(blocks) == 0 {
+			return
+		}
+		// Anthropic 要求 user/assistant 交替出现, 相邻同角色消息合并
+		if n := len(messages); n > 0 && messages[n-1].Role == role {
+			messages[n-1].Content = append(messages[n-1].Content, blocks...)
+			return
+		}
+		messages = append(messages, anthropicMessage{Role: role, Content: blocks})
+	}
+
+	for _, message := range promptMessages {
+		switch message.Role {
+		case PromptMessageRoleSystem:
+			if content, ok := message.Content.(string); ok && content != "" {
+				systems = append(systems, content)
+			}
+		case PromptMessageRoleUser:
+			appendBlocks("user", a.convertContent(message.Content))
+		case PromptMessageRoleAssistant:
+			blocks := a.convertContent(message.Content)
+			for _, toolCall := range message.ToolCalls {
+				input := json.RawMessage(toolCall.Function.Arguments)
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, anthropicContentBlock{
+					Type:  "tool_use",
+					ID:    toolCall.ID,
+					Name:  toolCall.Function.Name,
+					Input: input,
+				})
+			}
+			appendBlocks("assistant", blocks)
+		case PromptMessageRoleTool:
+			content, _ := message.Content.(string)
+			appendBlocks("user", []anthropicContentBlock{{
+				Type:      "tool_result",
+				ToolUseID: message.ToolCallID,
+				Content:   content,
+			}})
+		}
+	}
+
+	return strings.Join(systems, "\n\n"), messages
+}
+
+func (a *AnthropicClient) convertContent(content interface{}) []anthropicContentBlock {
+	switch c := content.(type) {
+	case string:
+		if c == "" {
+			return nil
+		}
+		return []anthropicContentBlock{{Type: "text", Text: c}}
+	case []PromptMessageContent:
+		blocks := make([]anthropicContentBlock, 0, len(c))
+		for _, item := range c {
+			switch v := item.(type) {
+			case *TextPromptMessageContent:
+				blocks = append(blocks, anthropicContentBlock{Type: "text", Text: v.Data})
+			case *ImagePromptMessageContent:
+				source := &anthropicImageSource{Type: "url", URL: v.URL}
+				if v.URL == "" {
+					source = &anthropicImageSource{Type: "base64", MediaType: v.MimeType, Data: v.Base64Data}
+				}
+				blocks = append(blocks, anthropicContentBlock{Type: "image", Source: source})
+			}
+		}
+		return blocks
+	}
+	return nil
+}
+
+func anthropicFinishReason(stopReason string) string {
+	switch stopReason {
+	case "end_turn", "stop_sequence":
+		return "stop"
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	case "":
+		return "stop"
+	default:
+		return stopReason
+	}
+}
+
+func floatParam(params map[string]interface{}, key string) (float64, bool) {
+	switch v := params[key].(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+func intParam(params map[string]interface{}, key string) (int64, bool) {
+	switch v := params[key].(type) {
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), true
+	}
+	return 0, false
+}
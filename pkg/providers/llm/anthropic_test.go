@@ -0,0 +1,280 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// readChunks 读取流中的全部输出块, 返回流结束时的错误
+func readChunks(t *testing.T, provider LLM, credentials map[string]interface{}, promptMessages []*PromptMessage, tools []PromptMessageTool) ([]*LLMResultChunk, error) {
+	t.Helper()
+	stream, err := provider.ChatStream(context.Background(), "test-model", credentials, promptMessages, nil, tools, nil)
+	if err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+	var chunks []*LLMResultChunk
+	for {
+		result := stream.Recv()
+		if result.HasData {
+			chunks = append(chunks, result.Data)
+		}
+		if result.Completed {
+			return chunks, result.Error
+		}
+	}
+}
+
+// mergeChunks 按 OpenAI 的增量语义合并流式输出: 文本拼接, 工具调用按 Index 合并参数
+func mergeChunks(chunks []*LLMResultChunk) (string, []ToolCall, string, *Usage) {
+	var (
+		text         strings.Builder
+		toolCalls    []ToolCall
+		finishReason string
+		usage        *Usage
+	)
+	for _, chunk := range chunks {
+		if content, ok := chunk.Delta.Message.Content.(string); ok {
+			text.WriteString(content)
+		}
+		for _, call := range chunk.Delta.Message.ToolCalls {
+			for len(toolCalls) <= call.Index {
+				toolCalls = append(toolCalls, ToolCall{})
+			}
+			merged := &toolCalls[call.Index]
+			if call.ID != "" {
+				merged.ID = call.ID
+			}
+			if call.Function.Name != "" {
+				merged.Function.Name = call.Function.Name
+			}
+			merged.Function.Arguments += call.Function.Arguments
+		}
+		if chunk.Delta.FinishReason != "" {
+			finishReason = chunk.Delta.FinishReason
+		}
+		if chunk.Delta.Usage != nil {
+			usage = chunk.Delta.Usage
+		}
+	}
+	return text.String(), toolCalls, finishReason, usage
+}
+
+func sseEvents(events ...string) string {
+	var body strings.Builder
+	for _, event := range events {
+		var typed struct {
+			Type string `json:"type"`
+		}
+		_ = json.Unmarshal([]byte(event), &typed)
+		fmt.Fprintf(&body, "event: %s\ndata: %s\n\n", typed.Type, event)
+	}
+	return body.String()
+}
+
+func newAnthropicServer(t *testing.T, body string, requests chan<- anthropicRequest) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			t.Errorf("path = %s, want /v1/messages", r.URL.Path)
+		}
+		if r.Header.Get("x-api-key") != "test-key" || r.Header.Get("anthropic-version") == "" {
+			t.Errorf("headers = %v, want api key and version", r.Header)
+		}
+		var request anthropicRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		if requests != nil {
+			requests <- request
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestAnthropicStreamMapsToolCallsAndUsage(t *testing.T) {
+	body := sseEvents(
+		`{"type":"message_start","message":{"id":"msg_1","model":"claude-test","usage":{"input_tokens":12,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Let me "}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"check."}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{}}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"Paris\"}"}}`,
+		`{"type":"content_block_stop","index":1}`,
+		`{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_2","name":"get_time","input":{}}}`,
+		`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{}"}}`,
+		`{"type":"content_block_stop","index":2}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":7}}`,
+		`{"type":"message_stop"}`,
+	)
+	requests := make(chan anthropicRequest, 1)
+	server := newAnthropicServer(t, body, requests)
+
+	promptMessages := []*PromptMessage{
+		NewSystemPromptMessage("be brief", "").PromptMessage,
+		&NewUserPromptMessage("weather?", "").PromptMessage,
+		NewAssistantPromptMessage("", "", []ToolCall{{ID: "toolu_0", Type: "function", Function: ToolCallFunction{Name: "lookup", Arguments: `{"q":1}`}}}).PromptMessage,
+		{Role: PromptMessageRoleTool, Content: "ok", ToolCallID: "toolu_0"},
+	}
+	tools := []PromptMessageTool{{Name: "get_weather", Description: "weather", Parameters: map[string]interface{}{"type": "object"}}}
+	chunks, err := readChunks(t, &AnthropicClient{}, map[string]interface{}{"base_url": server.URL, "api_key": "test-key"}, promptMessages, tools)
+	if err != nil {
+		t.Fatalf("stream error: %v", err)
+	}
+
+	request := <-requests
+	if !request.Stream || request.System != "be brief" || len(request.Tools) != 1 || request.Tools[0].Name != "get_weather" {
+		t.Errorf("request = %+v", request)
+	}
+	// tool 消息转换为 user 角色的 tool_result, 与前后消息交替出现
+	if len(request.Messages) != 3 || request.Messages[2].Role != "user" || request.Messages[2].Content[0].Type != "tool_result" || request.Messages[2].Content[0].ToolUseID != "toolu_0" {
+		t.Errorf("messages = %+v", request.Messages)
+	}
+
+	text, toolCalls, finishReason, usage := mergeChunks(chunks)
+	if text != "Let me check." {
+		t.Errorf("text = %q", text)
+	}
+	if len(toolCalls) != 2 {
+		t.Fatalf("tool calls = %+v, want 2", toolCalls)
+	}
+	if toolCalls[0].ID != "toolu_1" || toolCalls[0].Function.Name != "get_weather" || toolCalls[0].Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("first tool call = %+v", toolCalls[0])
+	}
+	if toolCalls[1].ID != "toolu_2" || toolCalls[1].Function.Name != "get_time" || toolCalls[1].Function.Arguments != "{}" {
+		t.Errorf("second tool call = %+v", toolCalls[1])
+	}
+	if finishReason != "tool_calls" {
+		t.Errorf("finish reason = %q, want tool_calls", finishReason)
+	}
+	if usage == nil || usage.PromptTokens != 12 || usage.CompletionTokens != 7 || usage.TotalTokens != 19 {
+		t.Errorf("usage = %+v, want 12/7/19", usage)
+	}
+	if chunks[len(chunks)-1].Model != "claude-test" {
+		t.Errorf("model = %q, want the model reported in message_start", chunks[len(chunks)-1].Model)
+	}
+}
+
+func TestAnthropicStreamFinishReasons(t *testing.T) {
+	cases := map[string]string{
+		"end_turn":      "stop",
+		"stop_sequence": "stop",
+		"max_tokens":    "length",
+		"tool_use":      "tool_calls",
+		"refusal":       "refusal",
+	}
+	for stopReason, want := range cases {
+		t.Run(stopReason, func(t *testing.T) {
+			body := sseEvents(
+				`{"type":"message_start","message":{"id":"msg_1","usage":{"input_tokens":1}}}`,
+				`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"hi"}}`,
+				fmt.Sprintf(`{"type":"message_delta","delta":{"stop_reason":%q},"usage":{"output_tokens":1}}`, stopReason),
+				`{"type":"message_stop"}`,
+			)
+			server := newAnthropicServer(t, body, nil)
+			chunks, err := readChunks(t, &AnthropicClient{}, map[string]interface{}{"base_url": server.URL, "api_key": "test-key"},
+				[]*PromptMessage{&NewUserPromptMessage("hi", "").PromptMessage}, nil)
+			if err != nil {
+				t.Fatalf("stream error: %v", err)
+			}
+			if _, _, finishReason, _ := mergeChunks(chunks); finishReason != want {
+				t.Errorf("finish reason = %q, want %q", finishReason, want)
+			}
+		})
+	}
+}
+
+func TestAnthropicStreamEOFBeforeMessageStop(t *testing.T) {
+	cases := map[string]string{
+		"mid content": sseEvents(
+			`{"type":"message_start","message":{"id":"msg_1","usage":{"input_tokens":3}}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"partial"}}`,
+		),
+		"after message_delta": sseEvents(
+			`{"type":"message_start","message":{"id":"msg_1","usage":{"input_tokens":3}}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"partial"}}`,
+			`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":1}}`,
+		),
+	}
+	for name, body := range cases {
+		t.Run(name, func(t *testing.T) {
+			server := newAnthropicServer(t, body, nil)
+			_, err := readChunks(t, &AnthropicClient{}, map[string]interface{}{"base_url": server.URL, "api_key": "test-key"},
+				[]*PromptMessage{&NewUserPromptMessage("hi", "").PromptMessage}, nil)
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Fatalf("stream error = %v, want io.ErrUnexpectedEOF", err)
+			}
+		})
+	}
+}
+
+func TestAnthropicStreamErrorEvent(t *testing.T) {
+	body := sseEvents(
+		`{"type":"message_start","message":{"id":"msg_1","usage":{"input_tokens":3}}}`,
+		`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+	)
+	server := newAnthropicServer(t, body, nil)
+	_, err := readChunks(t, &AnthropicClient{}, map[string]interface{}{"base_url": server.URL, "api_key": "test-key"},
+		[]*PromptMessage{&NewUserPromptMessage("hi", "").PromptMessage}, nil)
+	if err == nil || !strings.Contains(err.Error(), "overloaded_error") {
+		t.Fatalf("stream error = %v, want overloaded_error", err)
+	}
+}
+
+func TestAnthropicChatMapsToolCallsAndUsage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{
+			"id": "msg_1",
+			"model": "claude-test",
+			"stop_reason": "tool_use",
+			"content": [
+				{"type": "text", "text": "Checking."},
+				{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city":"Paris"}},
+				{"type": "tool_use", "id": "toolu_2", "name": "get_time"}
+			],
+			"usage": {"input_tokens": 20, "output_tokens": 5}
+		}`)
+	}))
+	defer server.Close()
+
+	result, err := (&AnthropicClient{}).Chat(context.Background(), "test-model",
+		map[string]interface{}{"base_url": server.URL}, []*PromptMessage{&NewUserPromptMessage("hi", "").PromptMessage}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if result.Model != "claude-test" || result.Message.Content != "Checking." {
+		t.Errorf("result = %+v", result)
+	}
+	toolCalls := result.Message.ToolCalls
+	if len(toolCalls) != 2 || toolCalls[0].Function.Arguments != `{"city":"Paris"}` || toolCalls[1].Index != 1 || toolCalls[1].Function.Arguments != "{}" {
+		t.Errorf("tool calls = %+v", toolCalls)
+	}
+	if result.Usage.PromptTokens != 20 || result.Usage.CompletionTokens != 5 || result.Usage.TotalTokens != 25 {
+		t.Errorf("usage = %+v", result.Usage)
+	}
+}
+
+func TestAnthropicRequestFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"type":"error"}`, http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	_, err := (&AnthropicClient{}).ChatStream(context.Background(), "test-model",
+		map[string]interface{}{"base_url": server.URL}, []*PromptMessage{&NewUserPromptMessage("hi", "").PromptMessage}, nil, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "status=429") {
+		t.Fatalf("ChatStream error = %v, want status=429", err)
+	}
+}
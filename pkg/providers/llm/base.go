@@ -10,14 +10,14 @@ type LLM interface {
 	ChatStream(ctx context.Context,
 		model string,
 		credentials map[string]interface{},
-		promptMessages []PromptMessage,
+		promptMessages []*PromptMessage,
 		modelParameters map[string]interface{},
 		tools []PromptMessageTool,
-		stop []string) (streams.Stream[*LLMResultChunk], error)
+		stop []string) (*streams.Stream[*LLMResultChunk], error)
 	Chat(ctx context.Context,
 		model string,
 		credentials map[string]interface{},
-		promptMessages []PromptMessage,
+		promptMessages []*PromptMessage,
 		modelParameters map[string]interface{},
 		tools []PromptMessageTool,
 		stop []string) (*LLMResult, error)
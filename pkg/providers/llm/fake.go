@@ -0,0 +1,207 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/streams"
+)
+
+// FakeLLM 按脚本回放预置的 LLMResultChunk 序列, 用于离线调试 ChatRunner 和 WebSocket 流程
+//
+// 脚本由多轮组成, 每次调用根据最后一条 user 消息之后的 assistant 消息数量选择对应轮次,
+// 因此同一份脚本可以确定性地驱动多步工具调用. 超出脚本轮数时重复最后一轮.
+// 未提供脚本时回显最后一条 user 消息.
+type FakeLLM struct {
+	Turns [][]*LLMResultChunk
+}
+
+// FakeScriptChunk 脚本文件中的单个输出块
+type FakeScriptChunk struct {
+	Content      string     `json:"content,omitempty"`
+	ToolCalls    []ToolCall `json:"tool_calls,omitempty"`
+	FinishReason string     `json:"finish_reason,omitempty"`
+	Usage        *Usage     `json:"usage,omitempty"`
+}
+
+func NewFakeLLM(turns ...[]*LLMResultChunk) *FakeLLM {
+	return &FakeLLM{Turns: turns}
+}
+
+// LoadFakeScript 从 JSON 文件加载脚本, 文件内容为 [][]FakeScriptChunk
+func LoadFakeScript(path string) ([][]*LLMResultChunk, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取 fake 脚本失败: %w", err)
+	}
+
+	var script [][]FakeScriptChunk
+	if err := json.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("解析 fake 脚本失败: %w", err)
+	}
+
+	turns := make([][]*LLMResultChunk, 0, len(script))
+	for _, scriptTurn := range script {
+		turn := make([]*LLMResultChunk, 0, len(scriptTurn))
+		for _, scriptChunk := range scriptTurn {
+			turn = append(turn, &LLMResultChunk{
+				Model: ProviderFake,
+				Delta: LLMResultChunkDelta{
+					Message:      NewAssistantPromptMessage(scriptChunk.Content, "", scriptChunk.ToolCalls),
+					FinishReason: scriptChunk.FinishReason,
+					Usage:        scriptChunk.Usage,
+				},
+			})
+		}
+		turns = append(turns, turn)
+	}
+	return turns, nil
+}
+
+func (f *FakeLLM) ChatStream(
+	ctx context.Context,
+	model string,
+	credentials map[string]interface{},
+	promptMessages []*PromptMessage,
+	modelParameters map[string]interface{},
+	tools []PromptMessageTool,
+	stop []string,
+) (*streams.Stream[*LLMResultChunk], error) {
+	turn, err := f.selectTurn(credentials, promptMessages)
+	if err != nil {
+		return nil, err
+	}
+
+	respStream := streams.NewStream[*LLMResultChunk](ctx, 5)
+	go func() {
+		defer respStream.CloseSend()
+		for _, chunk := range turn {
+			replay := *chunk
+			replay.PromptMessages = promptMessages
+			if replay.Model == "" {
+				replay.Model = model
+			}
+			if err := respStream.Send(&replay); err != nil {
+				return
+			}
+		}
+	}()
+	return respStream, nil
+}
+
+func (f *FakeLLM) Chat(
+	ctx context.Context,
+	model string,
+	credentials map[string]interface{},
+	promptMessages []*PromptMessage,
+	modelParameters map[string]interface{},
+	tools []PromptMessageTool,
+	stop []string,
+) (*LLMResult, error) {
+	turn, err := f.selectTurn(credentials, promptMessages)
+	if err != nil {
+		return nil, err
+	}
+
+	var text strings.Builder
+	toolCalls := make(map[int]*ToolCall)
+	var order []int
+	var usage *Usage
+	for _, chunk := range turn {
+		if chunk.Delta.Usage != nil {
+			usage = chunk.Delta.Usage
+		}
+		message := chunk.Delta.Message
+		if message == nil || message.PromptMessage == nil {
+			continue
+		}
+		if content, ok := message.Content.(string); ok {
+			text.WriteString(content)
+		}
+		for _, toolCall := range message.ToolCalls {
+			existing, ok := toolCalls[toolCall.Index]
+			if !ok {
+				call := toolCall
+				toolCalls[toolCall.Index] = &call
+				order = append(order, toolCall.Index)
+				continue
+			}
+			existing.Function.Arguments += toolCall.Function.Arguments
+		}
+	}
+
+	merged := make([]ToolCall, 0, len(order))
+	for _, index := range order {
+		merged = append(merged, *toolCalls[index])
+	}
+
+	return &LLMResult{
+		Model:          model,
+		PromptMessages: promptMessages,
+		Message:        NewAssistantPromptMessage(text.String(), "", merged),
+		Usage:          usage,
+	}, nil
+}
+
+func (f *FakeLLM) selectTurn(credentials map[string]interface{}, promptMessages []*PromptMessage) ([]*LLMResultChunk, error) {
+	turns := f.Turns
+	if scriptFile, ok := credentials["script_file"].(string); ok && scriptFile != "" {
+		loaded, err := LoadFakeScript(scriptFile)
+		if err != nil {
+			return nil, err
+		}
+		turns = loaded
+	}
+
+	if len(turns) == 0 {
+		return f.echoTurn(promptMessages), nil
+	}
+
+	step := 0
+	for i := len(promptMessages) - 1; i >= 0; i-- {
+		if promptMessages[i].Role == PromptMessageRoleUser {
+			break
+		}
+		if promptMessages[i].Role == PromptMessageRoleAssistant {
+			step++
+		}
+	}
+	if step >= len(turns) {
+		step = len(turns) - 1
+	}
+	return turns[step], nil
+}
+
+func (f *FakeLLM) echoTurn(promptMessages []*PromptMessage) []*LLMResultChunk {
+	text := ""
+	for i := len(promptMessages) - 1; i >= 0; i-- {
+		if promptMessages[i].Role != PromptMessageRoleUser {
+			continue
+		}
+		if content, ok := promptMessages[i].Content.(string); ok {
+			text = content
+		}
+		break
+	}
+
+	words := strings.SplitAfter(text, " ")
+	turn := make([]*LLMResultChunk, 0, len(words)+1)
+	for _, word := range words {
+		turn = append(turn, &LLMResultChunk{
+			Model: ProviderFake,
+			Delta: LLMResultChunkDelta{Message: NewAssistantPromptMessage(word, "", nil)},
+		})
+	}
+	turn = append(turn, &LLMResultChunk{
+		Model: ProviderFake,
+		Delta: LLMResultChunkDelta{
+			Message:      NewAssistantPromptMessage("", "", nil),
+			FinishReason: "stop",
+			Usage:        &Usage{},
+		},
+	})
+	return turn
+}
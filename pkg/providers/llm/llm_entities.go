@@ -12,6 +12,7 @@ type LLMResultChunk struct {
 	PromptMessages    []*PromptMessage    `json:"prompt_messages"`
 	SystemFingerprint string              `json:"system_fingerprint,omitempty"`
 	Delta             LLMResultChunkDelta `json:"delta"`
+	Fallback          *FallbackHop        `json:"fallback,omitempty"` // 非空表示切换到了降级模型, 之前的部分输出应当丢弃
 }
 
 type LLMResult struct {
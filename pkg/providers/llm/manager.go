@@ -3,7 +3,9 @@ package llm
 import (
 	"context"
 	"fmt"
+	"sync"
 
+	"github.com/sirupsen/logrus"
 	"github.com/zhongshangwu/avatarai-social/pkg/config"
 	"github.com/zhongshangwu/avatarai-social/pkg/streams"
 )
@@ -11,6 +13,7 @@ import (
 type ModelManager struct {
 	config        *config.SocialConfig
 	toolExecutors map[string]ToolExecutor
+	toolsMu       sync.RWMutex
 }
 
 // ToolExecutor 定义工具执行器接口
@@ -30,11 +33,15 @@ func NewModelManager(config *config.SocialConfig) *ModelManager {
 
 // RegisterTool 注册工具执行器
 func (m *ModelManager) RegisterTool(executor ToolExecutor) {
+	m.toolsMu.Lock()
+	defer m.toolsMu.Unlock()
 	m.toolExecutors[executor.GetName()] = executor
 }
 
 // GetAvailableTools 获取可用的工具定义
 func (m *ModelManager) GetAvailableTools() []PromptMessageTool {
+	m.toolsMu.RLock()
+	defer m.toolsMu.RUnlock()
 	var tools []PromptMessageTool
 	for _, executor := range m.toolExecutors {
 		tools = append(tools, PromptMessageTool{
@@ -48,7 +55,9 @@ func (m *ModelManager) GetAvailableTools() []PromptMessageTool {
 
 // ExecuteTool 执行工具
 func (m *ModelManager) ExecuteTool(ctx context.Context, toolName, arguments string) (string, error) {
+	m.toolsMu.RLock()
 	executor, exists := m.toolExecutors[toolName]
+	m.toolsMu.RUnlock()
 	if !exists {
 		return "", fmt.Errorf("tool not found: %s", toolName)
 	}
@@ -56,6 +65,7 @@ func (m *ModelManager) ExecuteTool(ctx context.Context, toolName, arguments stri
 	return executor.Execute(ctx, arguments)
 }
 
+// ChatStream 使用默认模型及其降级链进行流式聊天
 func (m *ModelManager) ChatStream(
 	ctx context.Context,
 	promptMessages []*PromptMessage,
@@ -63,22 +73,10 @@ func (m *ModelManager) ChatStream(
 	tools []PromptMessageTool,
 	stop []string,
 ) (*streams.Stream[*LLMResultChunk], error) {
-	model := m.config.Avatar.LLM.Model
-	provider := m.config.Avatar.LLM.Provider
-
-	switch provider {
-	case "openai":
-		client := OpenAIClient{}
-		return client.ChatStream(ctx, model, map[string]interface{}{
-			"api_key":  m.config.Avatar.LLM.APIKey,
-			"base_url": m.config.Avatar.LLM.APIURL,
-		}, promptMessages, modelParameters, tools, stop)
-	default:
-		return nil, fmt.Errorf("unsupported provider: %s", provider)
-	}
+	return m.ChatStreamWithRoute(ctx, m.ResolveRoute(nil), promptMessages, modelParameters, tools, stop)
 }
 
-// Chat 非流式聊天
+// Chat 非流式聊天, 失败时依次尝试默认模型的降级链
 func (m *ModelManager) Chat(
 	ctx context.Context,
 	promptMessages []*PromptMessage,
@@ -86,17 +84,40 @@ func (m *ModelManager) Chat(
 	tools []PromptMessageTool,
 	stop []string,
 ) (*LLMResult, error) {
-	model := m.config.Avatar.LLM.Model
-	provider := m.config.Avatar.LLM.Provider
+	return m.ChatWithRoute(ctx, m.ResolveRoute(nil), promptMessages, modelParameters, tools, stop)
+}
 
-	switch provider {
-	case "openai":
-		client := OpenAIClient{}
-		return client.Chat(ctx, model, map[string]interface{}{
-			"api_key":  m.config.Avatar.LLM.APIKey,
-			"base_url": m.config.Avatar.LLM.APIURL,
-		}, promptMessages, modelParameters, tools, stop)
-	default:
-		return nil, fmt.Errorf("unsupported provider: %s", provider)
+// ChatWithRoute 非流式聊天, 失败时依次尝试路由中的候选模型
+func (m *ModelManager) ChatWithRoute(
+	ctx context.Context,
+	route *ModelRoute,
+	promptMessages []*PromptMessage,
+	modelParameters map[string]interface{},
+	tools []PromptMessageTool,
+	stop []string,
+) (*LLMResult, error) {
+	if route == nil {
+		route = m.ResolveRoute(nil)
+	}
+	var lastErr error
+	for _, candidate := range route.candidates {
+		provider, err := GetProvider(candidate.Provider)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		result, err := provider.Chat(ctx, candidate.Model, credentialsOf(candidate), promptMessages, modelParameters, tools, stop)
+		if err == nil {
+			return result, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		logrus.Warnf("模型 %s 调用失败: %v", candidate.Name, err)
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("模型路由 %s 没有可用的模型", route.Name)
 	}
+	return nil, lastErr
 }
@@ -165,9 +165,11 @@ func (d *DocumentPromptMessageContent) MarshalJSON() ([]byte, error) {
 }
 
 type PromptMessage struct {
-	Role    PromptMessageRole `json:"role"`
-	Content interface{}       `json:"content,omitempty"`
-	Name    string            `json:"name,omitempty"`
+	Role       PromptMessageRole `json:"role"`
+	Content    interface{}       `json:"content,omitempty"`
+	Name       string            `json:"name,omitempty"`
+	ToolCalls  []ToolCall        `json:"tool_calls,omitempty"`   // 仅 assistant 消息
+	ToolCallID string            `json:"tool_call_id,omitempty"` // 仅 tool 消息
 }
 
 func (p *PromptMessage) IsEmpty() bool {
@@ -262,6 +264,7 @@ func NewUserPromptMessage(content interface{}, name string) *UserPromptMessage {
 }
 
 type ToolCall struct {
+	Index    int              `json:"index"` // 流式输出时同一轮中工具调用的序号
 	ID       string           `json:"id"`
 	Type     string           `json:"type"`
 	Function ToolCallFunction `json:"function"`
@@ -280,9 +283,10 @@ type AssistantPromptMessage struct {
 func NewAssistantPromptMessage(content interface{}, name string, toolCalls []ToolCall) *AssistantPromptMessage {
 	return &AssistantPromptMessage{
 		PromptMessage: &PromptMessage{
-			Role:    PromptMessageRoleAssistant,
-			Content: content,
-			Name:    name,
+			Role:      PromptMessageRoleAssistant,
+			Content:   content,
+			Name:      name,
+			ToolCalls: toolCalls,
 		},
 		ToolCalls: toolCalls,
 	}
@@ -314,9 +318,10 @@ type ToolPromptMessage struct {
 func NewToolPromptMessage(content interface{}, name string, toolCallID string) *ToolPromptMessage {
 	return &ToolPromptMessage{
 		PromptMessage: &PromptMessage{
-			Role:    PromptMessageRoleTool,
-			Content: content,
-			Name:    name,
+			Role:       PromptMessageRoleTool,
+			Content:    content,
+			Name:       name,
+			ToolCallID: toolCallID,
 		},
 		ToolCallID: toolCallID,
 	}
@@ -0,0 +1,303 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/zhongshangwu/avatarai-social/pkg/streams"
+)
+
+const ollamaDefaultBaseURL = "http://127.0.0.1:11434"
+
+// OllamaClient 基于 Ollama /api/chat 接口的实现, 流式响应为 NDJSON
+type OllamaClient struct{}
+
+type ollamaRequest struct {
+	Model    string                 `json:"model"`
+	Messages []ollamaMessage        `json:"messages"`
+	Tools    []ollamaTool           `json:"tools,omitempty"`
+	Stream   bool                   `json:"stream"`
+	Options  map[string]interface{} `json:"options,omitempty"`
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaTool struct {
+	Type     string            `json:"type"`
+	Function PromptMessageTool `json:"function"`
+}
+
+type ollamaResponse struct {
+	Model           string        `json:"model"`
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int64         `json:"prompt_eval_count"`
+	EvalCount       int64         `json:"eval_count"`
+	Error           string        `json:"error,omitempty"`
+}
+
+func (o *OllamaClient) ChatStream(
+	ctx context.Context,
+	model string,
+	credentials map[string]interface{},
+	promptMessages []*PromptMessage,
+	modelParameters map[string]interface{},
+	tools []PromptMessageTool,
+	stop []string,
+) (*streams.Stream[*LLMResultChunk], error) {
+	body, err := o.doRequest(ctx, model, credentials, promptMessages, modelParameters, tools, stop, true)
+	if err != nil {
+		return nil, err
+	}
+
+	respStream := streams.NewStream[*LLMResultChunk](ctx, 5)
+	go func() {
+		defer respStream.CloseSend()
+		defer body.Close()
+
+		// Ollama 的工具调用不做增量输出, 每个工具调用都会完整地出现在某一行中
+		toolIndex := 0
+		done := false
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+
+			var resp ollamaResponse
+			if err := json.Unmarshal(line, &resp); err != nil {
+				logrus.Errorf("解析 ollama 流响应失败: %v", err)
+				continue
+			}
+			if resp.Error != "" {
+				respStream.SendError(fmt.Errorf("ollama stream error: %s", resp.Error))
+				return
+			}
+
+			toolCalls := o.convertToolCalls(resp.Message.ToolCalls, toolIndex)
+			toolIndex += len(toolCalls)
+
+			chunk := &LLMResultChunk{
+				Model:          resp.Model,
+				PromptMessages: promptMessages,
+				Delta: LLMResultChunkDelta{
+					Message: NewAssistantPromptMessage(resp.Message.Content, "", toolCalls),
+				},
+			}
+			if resp.Done {
+				done = true
+				chunk.Delta.FinishReason = ollamaFinishReason(resp.DoneReason, toolIndex > 0)
+				chunk.Delta.Usage = &Usage{
+					PromptTokens:     resp.PromptEvalCount,
+					CompletionTokens: resp.EvalCount,
+					TotalTokens:      resp.PromptEvalCount + resp.EvalCount,
+				}
+			}
+			respStream.Send(chunk)
+		}
+
+		if err := scanner.Err(); err != nil {
+			respStream.SendError(err)
+			return
+		}
+		if !done {
+			respStream.SendError(fmt.Errorf("ollama 流在 done 之前结束: %w", io.ErrUnexpectedEOF))
+		}
+	}()
+
+	return respStream, nil
+}
+
+func (o *OllamaClient) Chat(
+	ctx context.Context,
+	model string,
+	credentials map[string]interface{},
+	promptMessages []*PromptMessage,
+	modelParameters map[string]interface{},
+	tools []PromptMessageTool,
+	stop []string,
+) (*LLMResult, error) {
+	body, err := o.doRequest(ctx, model, credentials, promptMessages, modelParameters, tools, stop, false)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var resp ollamaResponse
+	if err := json.NewDecoder(body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("解析 ollama 响应失败: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("ollama error: %s", resp.Error)
+	}
+
+	return &LLMResult{
+		Model:          resp.Model,
+		PromptMessages: promptMessages,
+		Message:        NewAssistantPromptMessage(resp.Message.Content, "", o.convertToolCalls(resp.Message.ToolCalls, 0)),
+		Usage: &Usage{
+			PromptTokens:     resp.PromptEvalCount,
+			CompletionTokens: resp.EvalCount,
+			TotalTokens:      resp.PromptEvalCount + resp.EvalCount,
+		},
+	}, nil
+}
+
+func (o *OllamaClient) doRequest(
+	ctx context.Context,
+	model string,
+	credentials map[string]interface{},
+	promptMessages []*PromptMessage,
+	modelParameters map[string]interface{},
+	tools []PromptMessageTool,
+	stop []string,
+	stream bool,
+) (io.ReadCloser, error) {
+	request := ollamaRequest{
+		Model:    model,
+		Messages: o.convertPromptMessages(promptMessages),
+		Stream:   stream,
+		Options:  map[string]interface{}{},
+	}
+	if temperature, ok := floatParam(modelParameters, "temperature"); ok {
+		request.Options["temperature"] = temperature
+	}
+	if topP, ok := floatParam(modelParameters, "top_p"); ok {
+		request.Options["top_p"] = topP
+	}
+	if maxTokens, ok := intParam(modelParameters, "max_tokens"); ok {
+		request.Options["num_predict"] = maxTokens
+	}
+	if len(stop) > 0 {
+		request.Options["stop"] = stop
+	}
+	for _, tool := range tools {
+		request.Tools = append(request.Tools, ollamaTool{Type: "function", Function: tool})
+	}
+
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := ollamaDefaultBaseURL
+	if url, ok := credentials["base_url"].(string); ok && url != "" {
+		baseURL = strings.TrimSuffix(url, "/")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/api/chat", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey, ok := credentials["api_key"].(string); ok && apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ollama 请求失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		errBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("ollama 请求失败: status=%d body=%s", resp.StatusCode, string(errBody))
+	}
+	return resp.Body, nil
+}
+
+func (o *OllamaClient) convertPromptMessages(promptMessages []*PromptMessage) []ollamaMessage {
+	messages := make([]ollamaMessage, 0, len(promptMessages))
+	for _, message := range promptMessages {
+		msg := ollamaMessage{Role: string(message.Role)}
+
+		switch content := message.Content.(type) {
+		case string:
+			msg.Content = content
+		case []PromptMessageContent:
+			var texts []string
+			for _, item := range content {
+				switch v := item.(type) {
+				case *TextPromptMessageContent:
+					texts = append(texts, v.Data)
+				case *ImagePromptMessageContent:
+					// Ollama 只接受 base64 编码的图片
+					if v.Base64Data != "" {
+						msg.Images = append(msg.Images, v.Base64Data)
+					}
+				}
+			}
+			msg.Content = strings.Join(texts, "\n")
+		}
+
+		for _, toolCall := range message.ToolCalls {
+			var call ollamaToolCall
+			call.Function.Name = toolCall.Function.Name
+			call.Function.Arguments = json.RawMessage(toolCall.Function.Arguments)
+			if !json.Valid(call.Function.Arguments) {
+				call.Function.Arguments = json.RawMessage("{}")
+			}
+			msg.ToolCalls = append(msg.ToolCalls, call)
+		}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+// convertToolCalls Ollama 不返回工具调用 ID, 这里生成一个供后续 tool 消息关联
+func (o *OllamaClient) convertToolCalls(calls []ollamaToolCall, startIndex int) []ToolCall {
+	toolCalls := make([]ToolCall, 0, len(calls))
+	for i, call := range calls {
+		arguments := string(call.Function.Arguments)
+		if arguments == "" || arguments == "null" {
+			arguments = "{}"
+		}
+		toolCalls = append(toolCalls, ToolCall{
+			Index: startIndex + i,
+			ID:    "call_" + uuid.New().String(),
+			Type:  "function",
+			Function: ToolCallFunction{
+				Name:      call.Function.Name,
+				Arguments: arguments,
+			},
+		})
+	}
+	return toolCalls
+}
+
+func ollamaFinishReason(doneReason string, hasToolCalls bool) string {
+	if hasToolCalls {
+		return "tool_calls"
+	}
+	switch doneReason {
+	case "", "stop":
+		return "stop"
+	case "length":
+		return "length"
+	default:
+		return doneReason
+	}
+}
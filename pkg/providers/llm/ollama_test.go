@@ -0,0 +1,162 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newOllamaServer(t *testing.T, body string, requests chan<- ollamaRequest) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			t.Errorf("path = %s, want /api/chat", r.URL.Path)
+		}
+		var request ollamaRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		if requests != nil {
+			requests <- request
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestOllamaStreamMapsToolCallsAndUsage(t *testing.T) {
+	body := strings.Join([]string{
+		`{"model":"qwen-test","message":{"role":"assistant","content":"Let me "},"done":false}`,
+		`{"model":"qwen-test","message":{"role":"assistant","content":"check."},"done":false}`,
+		`{"model":"qwen-test","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_weather","arguments":{"city":"Paris"}}}]},"done":false}`,
+		`{"model":"qwen-test","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_time","arguments":null}}]},"done":false}`,
+		`{"model":"qwen-test","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":30,"eval_count":9}`,
+	}, "\n") + "\n"
+	requests := make(chan ollamaRequest, 1)
+	server := newOllamaServer(t, body, requests)
+
+	tools := []PromptMessageTool{{Name: "get_weather", Description: "weather", Parameters: map[string]interface{}{"type": "object"}}}
+	chunks, err := readChunks(t, &OllamaClient{}, map[string]interface{}{"base_url": server.URL},
+		[]*PromptMessage{&NewUserPromptMessage("weather?", "").PromptMessage}, tools)
+	if err != nil {
+		t.Fatalf("stream error: %v", err)
+	}
+
+	request := <-requests
+	if !request.Stream || len(request.Tools) != 1 || request.Tools[0].Type != "function" || request.Tools[0].Function.Name != "get_weather" {
+		t.Errorf("request = %+v", request)
+	}
+
+	text, toolCalls, finishReason, usage := mergeChunks(chunks)
+	if text != "Let me check." {
+		t.Errorf("text = %q", text)
+	}
+	if len(toolCalls) != 2 {
+		t.Fatalf("tool calls = %+v, want 2", toolCalls)
+	}
+	if toolCalls[0].Function.Name != "get_weather" || toolCalls[0].Function.Arguments != `{"city":"Paris"}` || !strings.HasPrefix(toolCalls[0].ID, "call_") {
+		t.Errorf("first tool call = %+v", toolCalls[0])
+	}
+	if toolCalls[1].Function.Name != "get_time" || toolCalls[1].Function.Arguments != "{}" || toolCalls[1].ID == toolCalls[0].ID {
+		t.Errorf("second tool call = %+v", toolCalls[1])
+	}
+	// 本轮有工具调用时, done_reason=stop 也映射为 tool_calls
+	if finishReason != "tool_calls" {
+		t.Errorf("finish reason = %q, want tool_calls", finishReason)
+	}
+	if usage == nil || usage.PromptTokens != 30 || usage.CompletionTokens != 9 || usage.TotalTokens != 39 {
+		t.Errorf("usage = %+v, want 30/9/39", usage)
+	}
+}
+
+func TestOllamaStreamFinishReasons(t *testing.T) {
+	cases := map[string]string{
+		"":       "stop",
+		"stop":   "stop",
+		"length": "length",
+		"load":   "load",
+	}
+	for doneReason, want := range cases {
+		t.Run("reason_"+doneReason, func(t *testing.T) {
+			body := `{"model":"m","message":{"role":"assistant","content":"hi"},"done":false}` + "\n" +
+				`{"model":"m","message":{"role":"assistant","content":""},"done":true,"done_reason":"` + doneReason + `"}` + "\n"
+			server := newOllamaServer(t, body, nil)
+			chunks, err := readChunks(t, &OllamaClient{}, map[string]interface{}{"base_url": server.URL},
+				[]*PromptMessage{&NewUserPromptMessage("hi", "").PromptMessage}, nil)
+			if err != nil {
+				t.Fatalf("stream error: %v", err)
+			}
+			if _, _, finishReason, _ := mergeChunks(chunks); finishReason != want {
+				t.Errorf("finish reason = %q, want %q", finishReason, want)
+			}
+		})
+	}
+}
+
+func TestOllamaStreamErrors(t *testing.T) {
+	t.Run("error line", func(t *testing.T) {
+		server := newOllamaServer(t, `{"error":"model not found"}`+"\n", nil)
+		_, err := readChunks(t, &OllamaClient{}, map[string]interface{}{"base_url": server.URL},
+			[]*PromptMessage{&NewUserPromptMessage("hi", "").PromptMessage}, nil)
+		if err == nil || !strings.Contains(err.Error(), "model not found") {
+			t.Fatalf("stream error = %v, want model not found", err)
+		}
+	})
+	t.Run("eof before done", func(t *testing.T) {
+		server := newOllamaServer(t, `{"model":"m","message":{"role":"assistant","content":"partial"},"done":false}`+"\n", nil)
+		_, err := readChunks(t, &OllamaClient{}, map[string]interface{}{"base_url": server.URL},
+			[]*PromptMessage{&NewUserPromptMessage("hi", "").PromptMessage}, nil)
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("stream error = %v, want io.ErrUnexpectedEOF", err)
+		}
+	})
+}
+
+func TestOllamaChatMapsToolCallsAndUsage(t *testing.T) {
+	requests := make(chan ollamaRequest, 1)
+	server := newOllamaServer(t, `{
+		"model": "qwen-test",
+		"message": {"role": "assistant", "content": "", "tool_calls": [
+			{"function": {"name": "get_weather", "arguments": {"city":"Paris"}}},
+			{"function": {"name": "get_time", "arguments": {}}}
+		]},
+		"done": true,
+		"done_reason": "stop",
+		"prompt_eval_count": 11,
+		"eval_count": 4
+	}`, requests)
+
+	promptMessages := []*PromptMessage{
+		&NewUserPromptMessage("weather?", "").PromptMessage,
+		NewAssistantPromptMessage("", "", []ToolCall{{ID: "call_0", Type: "function", Function: ToolCallFunction{Name: "lookup", Arguments: "not json"}}}).PromptMessage,
+	}
+	result, err := (&OllamaClient{}).Chat(context.Background(), "test-model", map[string]interface{}{"base_url": server.URL},
+		promptMessages, map[string]interface{}{"temperature": 0.5, "max_tokens": 64}, nil, []string{"END"})
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+
+	request := <-requests
+	if request.Stream || request.Options["temperature"] != 0.5 || request.Options["num_predict"] != float64(64) {
+		t.Errorf("request options = %+v", request.Options)
+	}
+	// 无效的历史工具调用参数替换为空对象, 避免 Ollama 拒绝请求
+	if args := string(request.Messages[1].ToolCalls[0].Function.Arguments); args != "{}" {
+		t.Errorf("history tool call arguments = %s, want {}", args)
+	}
+
+	toolCalls := result.Message.ToolCalls
+	if len(toolCalls) != 2 || toolCalls[0].Function.Arguments != `{"city":"Paris"}` || toolCalls[1].Index != 1 || toolCalls[1].Function.Arguments != "{}" {
+		t.Errorf("tool calls = %+v", toolCalls)
+	}
+	if result.Usage.PromptTokens != 11 || result.Usage.CompletionTokens != 4 || result.Usage.TotalTokens != 15 {
+		t.Errorf("usage = %+v", result.Usage)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"strings"
-	"time"
 
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
@@ -57,7 +56,7 @@ func (o *OpenAIClient) Chat(
 		modelParameters,
 		tools,
 		stop,
-		true,
+		false,
 	)
 	if err != nil {
 		return nil, err
@@ -191,8 +190,8 @@ func (o *OpenAIClient) handleChatGenerateResponse(
 		toolCalls,
 	)
 
-	promptTokens := o.numTokensFromMessages(model, promptMessages, tools)
-	completionTokens := o.numTokensFromMessages(model, []*PromptMessage{assistantPromptMessage.PromptMessage}, nil)
+	promptTokens := NumTokensFromMessages(model, promptMessages, tools)
+	completionTokens := NumTokensFromMessages(model, []*PromptMessage{assistantPromptMessage.PromptMessage}, nil)
 
 	usage := &Usage{
 		PromptTokens:     promptTokens,
@@ -221,8 +220,6 @@ func (o *OpenAIClient) handleChatGenerateStreamResponse(
 	go func() {
 		defer respStream.CloseSend()
 
-		acc := openai.ChatCompletionAccumulator{}
-		finalToolCalls := []ToolCall{}
 		var usage *Usage
 
 		for {
@@ -238,20 +235,6 @@ func (o *OpenAIClient) handleChatGenerateStreamResponse(
 			}
 
 			chunk := openaiStream.Current()
-			acc.AddChunk(chunk)
-
-			// 检查并累积工具调用
-			if toolCall, ok := acc.JustFinishedToolCall(); ok {
-				functionCall := ToolCall{
-					ID:   toolCall.Id,
-					Type: "function",
-					Function: ToolCallFunction{
-						Name:      toolCall.Name,
-						Arguments: toolCall.Arguments,
-					},
-				}
-				finalToolCalls = append(finalToolCalls, functionCall)
-			}
 
 			logrus.Infof("chunk: %v", chunk)
 
@@ -274,20 +257,18 @@ func (o *OpenAIClient) handleChatGenerateStreamResponse(
 			// 提取增量内容
 			content := delta.Delta.Content
 
-			// 提取工具调用
+			// 提取工具调用增量, 只有首个增量带有 ID 和函数名, 后续增量按 Index 拼接参数
 			var toolCalls []ToolCall
 			for _, toolCall := range delta.Delta.ToolCalls {
-				if toolCall.Type == "function" {
-					functionCall := ToolCall{
-						ID:   toolCall.ID,
-						Type: toolCall.Type,
-						Function: ToolCallFunction{
-							Name:      toolCall.Function.Name,
-							Arguments: toolCall.Function.Arguments,
-						},
-					}
-					toolCalls = append(toolCalls, functionCall)
-				}
+				toolCalls = append(toolCalls, ToolCall{
+					Index: int(toolCall.Index),
+					ID:    toolCall.ID,
+					Type:  "function",
+					Function: ToolCallFunction{
+						Name:      toolCall.Function.Name,
+						Arguments: toolCall.Function.Arguments,
+					},
+				})
 			}
 
 			// 创建助手消息
@@ -308,8 +289,6 @@ func (o *OpenAIClient) handleChatGenerateStreamResponse(
 			resultChunk.Delta.Usage = usage
 
 			respStream.Send(resultChunk)
-			logrus.Infof("send result chunk sleep 3 seconds....: %v", resultChunk.Delta.Message.Content)
-			time.Sleep(1 * time.Second)
 		}
 
 		// 处理流错误
@@ -397,20 +376,26 @@ func (o *OpenAIClient) convertPromptMessagesToOpenAIMessages(messages []*PromptM
 				result = append(result, msg)
 			}
 		case PromptMessageRoleAssistant:
-			if content, ok := message.Content.(string); ok {
-				msg := openai.AssistantMessage(content)
-
-				// Add tool calls if present
-				// if assistantMessage, ok := interface{}(&message).(*AssistantPromptMessage); ok && len(assistantMessage.ToolCalls) > 0 {
-				// 	toolCall := assistantMessage.ToolCalls[0]
-				// 	msg = openai.AssistantMessage(
-				// 		content,
-				// 		openai.WithFunctionCall(toolCall.Function.Name, toolCall.Function.Arguments),
-				// 	)
-				// }
+			content, _ := message.Content.(string)
+			if len(message.ToolCalls) == 0 {
+				result = append(result, openai.AssistantMessage(content))
+				continue
+			}
 
-				result = append(result, msg)
+			assistant := openai.ChatCompletionAssistantMessageParam{}
+			if content != "" {
+				assistant.Content.OfString = openai.String(content)
 			}
+			for _, toolCall := range message.ToolCalls {
+				assistant.ToolCalls = append(assistant.ToolCalls, openai.ChatCompletionMessageToolCallParam{
+					ID: toolCall.ID,
+					Function: openai.ChatCompletionMessageToolCallFunctionParam{
+						Name:      toolCall.Function.Name,
+						Arguments: toolCall.Function.Arguments,
+					},
+				})
+			}
+			result = append(result, openai.ChatCompletionMessageParamUnion{OfAssistant: &assistant})
 		case PromptMessageRoleSystem:
 			if content, ok := message.Content.(string); ok {
 				msg := openai.SystemMessage(content)
@@ -418,10 +403,8 @@ func (o *OpenAIClient) convertPromptMessagesToOpenAIMessages(messages []*PromptM
 			}
 		case PromptMessageRoleTool:
 			if content, ok := message.Content.(string); ok {
-				if toolMessage, ok := interface{}(&message).(*ToolPromptMessage); ok {
-					msg := openai.ToolMessage(content, toolMessage.ToolCallID)
-					result = append(result, msg)
-				}
+				msg := openai.ToolMessage(content, message.ToolCallID)
+				result = append(result, msg)
 			}
 		}
 	}
@@ -537,142 +520,6 @@ func (o *OpenAIClient) applyModelParameters(params *openai.ChatCompletionNewPara
 	return nil
 }
 
-// numTokensFromString calculates the number of tokens in a string
-func (o *OpenAIClient) numTokensFromString(model string, text string) int64 {
-	// This is a simplified implementation - in a real implementation,
-	// you'd use a proper tokenizer like tiktoken
-	// For now, we'll use a rough approximation
-	words := int64(len(strings.Fields(text)))
-	return words * 4 / 3 // Rough approximation: 4 tokens per 3 words
-}
-
-// numTokensFromMessages calculates the number of tokens in messages
-func (o *OpenAIClient) numTokensFromMessages(model string, messages []*PromptMessage, tools []PromptMessageTool) int64 {
-	// This is a simplified implementation
-	var (
-		tokensPerMessage int64 = 3
-		tokensPerName    int64 = 1
-		numTokens        int64 = 0
-	)
-
-	// Process messages
-	for _, message := range messages {
-		numTokens += tokensPerMessage
-
-		// Add tokens for content
-		if content, ok := message.Content.(string); ok {
-			numTokens += o.numTokensFromString(model, content)
-		} else if contentList, ok := message.Content.([]PromptMessageContent); ok {
-			for _, item := range contentList {
-				if textContent, ok := item.(*TextPromptMessageContent); ok {
-					numTokens += o.numTokensFromString(model, textContent.Data)
-				}
-				// Note: Image tokens would require more complex calculation
-			}
-		}
-
-		// Add tokens for name if present
-		if message.Name != "" {
-			numTokens += tokensPerName
-		}
-
-		// Add tokens for tool calls if present
-		if assistantMessage, ok := interface{}(&message).(*AssistantPromptMessage); ok {
-			for _, toolCall := range assistantMessage.ToolCalls {
-				numTokens += o.numTokensFromString(model, toolCall.ID)
-				numTokens += o.numTokensFromString(model, toolCall.Type)
-				numTokens += o.numTokensFromString(model, toolCall.Function.Name)
-				numTokens += o.numTokensFromString(model, toolCall.Function.Arguments)
-			}
-		}
-	}
-
-	// Add tokens for tools
-	if len(tools) > 0 {
-		numTokens += o.numTokensForTools(model, tools)
-	}
-
-	// Every reply is primed with <im_start>assistant
-	numTokens += 3
-
-	return numTokens
-}
-
-// numTokensForTools calculates the number of tokens used by tools
-func (o *OpenAIClient) numTokensForTools(model string, tools []PromptMessageTool) int64 {
-	var numTokens int64 = 0
-
-	for _, tool := range tools {
-		// Type and function tokens
-		numTokens += o.numTokensFromString(model, "type")
-		numTokens += o.numTokensFromString(model, "function")
-
-		// Function object tokens
-		numTokens += o.numTokensFromString(model, "name")
-		numTokens += o.numTokensFromString(model, tool.Name)
-		numTokens += o.numTokensFromString(model, "description")
-		numTokens += o.numTokensFromString(model, tool.Description)
-
-		// Parameters tokens
-		numTokens += o.numTokensFromString(model, "parameters")
-
-		if title, ok := tool.Parameters["title"].(string); ok {
-			numTokens += o.numTokensFromString(model, "title")
-			numTokens += o.numTokensFromString(model, title)
-		}
-
-		if typeStr, ok := tool.Parameters["type"].(string); ok {
-			numTokens += o.numTokensFromString(model, "type")
-			numTokens += o.numTokensFromString(model, typeStr)
-		}
-
-		if properties, ok := tool.Parameters["properties"].(map[string]interface{}); ok {
-			numTokens += o.numTokensFromString(model, "properties")
-
-			for key, value := range properties {
-				numTokens += o.numTokensFromString(model, key)
-
-				if propObj, ok := value.(map[string]interface{}); ok {
-					for fieldKey, fieldValue := range propObj {
-						numTokens += o.numTokensFromString(model, fieldKey)
-
-						if fieldKey == "enum" {
-							if enumValues, ok := fieldValue.([]interface{}); ok {
-								for _, enumValue := range enumValues {
-									numTokens += 3 // Approximate tokens for enum value
-									if enumStr, ok := enumValue.(string); ok {
-										numTokens += o.numTokensFromString(model, enumStr)
-									}
-								}
-							}
-						} else {
-							if fieldStr, ok := fieldValue.(string); ok {
-								numTokens += o.numTokensFromString(model, fieldStr)
-							} else {
-								// For non-string values, add approximate tokens
-								numTokens += 3
-							}
-						}
-					}
-				}
-			}
-		}
-
-		if required, ok := tool.Parameters["required"].([]interface{}); ok {
-			numTokens += o.numTokensFromString(model, "required")
-
-			for _, req := range required {
-				numTokens += 3 // Approximate tokens for required field
-				if reqStr, ok := req.(string); ok {
-					numTokens += o.numTokensFromString(model, reqStr)
-				}
-			}
-		}
-	}
-
-	return numTokens
-}
-
 // enforceStopTokens ensures that the text doesn't contain stop sequences
 func (o *OpenAIClient) enforceStopTokens(text string, stop []string) string {
 	result := text
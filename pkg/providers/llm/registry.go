@@ -0,0 +1,58 @@
+package llm
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	ProviderOpenAI    = "openai"
+	ProviderAnthropic = "anthropic"
+	ProviderOllama    = "ollama"
+	ProviderLlamaCpp  = "llamacpp" // llama.cpp server 提供 OpenAI 兼容接口
+	ProviderFake      = "fake"
+)
+
+var (
+	providers   = make(map[string]LLM)
+	providersMu sync.RWMutex
+)
+
+func init() {
+	RegisterProvider(ProviderOpenAI, &OpenAIClient{})
+	RegisterProvider(ProviderLlamaCpp, &OpenAIClient{})
+	RegisterProvider(ProviderAnthropic, &AnthropicClient{})
+	RegisterProvider(ProviderOllama, &OllamaClient{})
+	RegisterProvider(ProviderFake, &FakeLLM{})
+}
+
+// RegisterProvider 注册 LLM 提供方, 同名提供方会被覆盖
+func RegisterProvider(name string, provider LLM) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[name] = provider
+}
+
+// GetProvider 根据名称获取 LLM 提供方
+func GetProvider(name string) (LLM, error) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	provider, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported provider: %s", name)
+	}
+	return provider, nil
+}
+
+// ListProviders 返回已注册的提供方名称
+func ListProviders() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
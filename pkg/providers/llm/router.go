@@ -0,0 +1,300 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/streams"
+)
+
+// DefaultModelName avatar.llm 在路由表中的名称
+const DefaultModelName = "default"
+
+// DefaultContextWindow 模型未配置 context_window 时使用的上下文窗口
+const DefaultContextWindow = 8192
+
+const defaultStreamIdleTimeout = 60 * time.Second
+
+// RouteRequest 模型路由的匹配条件
+type RouteRequest struct {
+	AsterDID   string
+	RoomType   string
+	ThreadID   string
+	HasTools   bool
+	Modalities []string // 请求中包含的模态: text, image, audio, video, file
+}
+
+// ModelRef 路由选中的模型, 会记录到 AgentMessage.Metadata 中
+type ModelRef struct {
+	Name     string `json:"name"`
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+}
+
+// FallbackHop 一次模型降级
+type FallbackHop struct {
+	From   ModelRef `json:"from"`
+	To     ModelRef `json:"to"`
+	Reason string   `json:"reason"`
+}
+
+// ModelRoute 路由结果, candidates 按优先级排列, 第一个为首选模型
+type ModelRoute struct {
+	Name       string
+	candidates []config.LLMConfig
+}
+
+func (r *ModelRoute) Primary() ModelRef {
+	if len(r.candidates) == 0 {
+		return ModelRef{}
+	}
+	return modelRefOf(r.candidates[0])
+}
+
+func (r *ModelRoute) Candidates() []ModelRef {
+	refs := make([]ModelRef, 0, len(r.candidates))
+	for _, candidate := range r.candidates {
+		refs = append(refs, modelRefOf(candidate))
+	}
+	return refs
+}
+
+// ContextWindow 候选模型中最小的上下文窗口, 保证降级后上下文依然放得下
+func (r *ModelRoute) ContextWindow() int64 {
+	var window int64
+	for _, candidate := range r.candidates {
+		size := int64(candidate.ContextWindow)
+		if size <= 0 {
+			size = DefaultContextWindow
+		}
+		if window == 0 || size < window {
+			window = size
+		}
+	}
+	if window == 0 {
+		window = DefaultContextWindow
+	}
+	return window
+}
+
+// Without 去掉已失败的模型, 后续轮次直接从降级后的模型开始
+func (r *ModelRoute) Without(ref ModelRef) *ModelRoute {
+	candidates := make([]config.LLMConfig, 0, len(r.candidates))
+	for _, candidate := range r.candidates {
+		if candidate.Name != ref.Name {
+			candidates = append(candidates, candidate)
+		}
+	}
+	if len(candidates) == 0 {
+		return r
+	}
+	return &ModelRoute{Name: r.Name, candidates: candidates}
+}
+
+// ResolveRoute 按路由表顺序匹配第一条规则, 未命中时使用 avatar.llm 及其降级链
+func (m *ModelManager) ResolveRoute(req *RouteRequest) *ModelRoute {
+	avatar := m.config.Avatar
+	if req != nil {
+		for i, route := range avatar.Routes {
+			if !routeMatches(route, req) {
+				continue
+			}
+			candidates := m.lookupModels(append([]string{route.Model}, route.Fallbacks...))
+			if len(candidates) == 0 {
+				logrus.Warnf("模型路由 %d 没有可用的模型, 忽略", i)
+				continue
+			}
+			name := route.Name
+			if name == "" {
+				name = fmt.Sprintf("routes[%d]", i)
+			}
+			return &ModelRoute{Name: name, candidates: candidates}
+		}
+	}
+	return &ModelRoute{
+		Name:       DefaultModelName,
+		candidates: m.lookupModels(append([]string{DefaultModelName}, avatar.Fallbacks...)),
+	}
+}
+
+func routeMatches(route config.ModelRouteConfig, req *RouteRequest) bool {
+	if route.AsterDID != "" && route.AsterDID != req.AsterDID {
+		return false
+	}
+	if route.RoomType != "" && route.RoomType != req.RoomType {
+		return false
+	}
+	if route.ThreadID != "" && route.ThreadID != req.ThreadID {
+		return false
+	}
+	if route.Tools != nil && *route.Tools != req.HasTools {
+		return false
+	}
+	for _, modality := range route.Modalities {
+		if !slices.Contains(req.Modalities, modality) {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupModels 按名称查找模型配置, 未知名称和重复名称会被跳过
+func (m *ModelManager) lookupModels(names []string) []config.LLMConfig {
+	models := make([]config.LLMConfig, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if name == "" {
+			name = DefaultModelName
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		if name == DefaultModelName {
+			model := m.config.Avatar.LLM
+			model.Name = DefaultModelName
+			models = append(models, model)
+			continue
+		}
+		index := slices.IndexFunc(m.config.Avatar.Models, func(model config.LLMConfig) bool {
+			return model.Name == name
+		})
+		if index < 0 {
+			logrus.Warnf("未找到名为 %s 的模型配置", name)
+			continue
+		}
+		models = append(models, m.config.Avatar.Models[index])
+	}
+	return models
+}
+
+// ChatStreamWithRoute 按路由结果依次尝试候选模型
+// 请求失败、限流或流式输出中途出错/空闲超时时切换到下一个模型, 并先输出一个带 Fallback 的块,
+// 调用方据此丢弃上一个模型已经输出的部分内容
+func (m *ModelManager) ChatStreamWithRoute(
+	ctx context.Context,
+	route *ModelRoute,
+	promptMessages []*PromptMessage,
+	modelParameters map[string]interface{},
+	tools []PromptMessageTool,
+	stop []string,
+) (*streams.Stream[*LLMResultChunk], error) {
+	if route == nil {
+		route = m.ResolveRoute(nil)
+	}
+	if len(route.candidates) == 0 {
+		return nil, fmt.Errorf("模型路由 %s 没有可用的模型", route.Name)
+	}
+
+	respStream := streams.NewStream[*LLMResultChunk](ctx, 5)
+	go func() {
+		defer respStream.CloseSend()
+		for i, candidate := range route.candidates {
+			err := m.streamCandidate(ctx, candidate, respStream, promptMessages, modelParameters, tools, stop)
+			if err == nil {
+				return
+			}
+			if ctx.Err() != nil || i == len(route.candidates)-1 {
+				respStream.SendError(err)
+				return
+			}
+
+			hop := &FallbackHop{
+				From:   modelRefOf(candidate),
+				To:     modelRefOf(route.candidates[i+1]),
+				Reason: err.Error(),
+			}
+			logrus.Warnf("模型 %s 调用失败, 降级到 %s: %v", hop.From.Name, hop.To.Name, err)
+			if err := respStream.Send(&LLMResultChunk{
+				Model:          hop.To.Model,
+				PromptMessages: promptMessages,
+				Fallback:       hop,
+			}); err != nil {
+				return
+			}
+		}
+	}()
+	return respStream, nil
+}
+
+// streamCandidate 将单个模型的流式输出转发到 respStream, 返回 nil 表示该模型正常结束
+func (m *ModelManager) streamCandidate(
+	ctx context.Context,
+	candidate config.LLMConfig,
+	respStream *streams.Stream[*LLMResultChunk],
+	promptMessages []*PromptMessage,
+	modelParameters map[string]interface{},
+	tools []PromptMessageTool,
+	stop []string,
+) error {
+	provider, err := GetProvider(candidate.Provider)
+	if err != nil {
+		return err
+	}
+
+	attemptCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	idleTimeout := m.streamIdleTimeout()
+	var idleTimedOut atomic.Bool
+	timer := time.AfterFunc(idleTimeout, func() {
+		idleTimedOut.Store(true)
+		cancel()
+	})
+	defer timer.Stop()
+
+	chatStream, err := provider.ChatStream(attemptCtx, candidate.Model, credentialsOf(candidate), promptMessages, modelParameters, tools, stop)
+	if err != nil {
+		return err
+	}
+
+	for {
+		result := chatStream.Recv()
+		if result.HasData {
+			timer.Reset(idleTimeout)
+			if err := respStream.Send(result.Data); err != nil {
+				return err
+			}
+			continue
+		}
+		if result.Completed {
+			if idleTimedOut.Load() {
+				return fmt.Errorf("模型输出空闲超时 (%s)", idleTimeout)
+			}
+			return result.Error
+		}
+	}
+}
+
+func (m *ModelManager) streamIdleTimeout() time.Duration {
+	if m.config.Avatar.StreamIdleTimeout > 0 {
+		return m.config.Avatar.StreamIdleTimeout
+	}
+	return defaultStreamIdleTimeout
+}
+
+// credentialsOf 组装提供方凭证, options 中的提供方专属配置会一并传入
+func credentialsOf(model config.LLMConfig) map[string]interface{} {
+	credentials := map[string]interface{}{
+		"api_key":  model.APIKey,
+		"base_url": model.APIURL,
+	}
+	for key, value := range model.Options {
+		credentials[key] = value
+	}
+	return credentials
+}
+
+func modelRefOf(model config.LLMConfig) ModelRef {
+	return ModelRef{
+		Name:     model.Name,
+		Provider: model.Provider,
+		Model:    model.Model,
+	}
+}
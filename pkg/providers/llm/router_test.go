@@ -0,0 +1,311 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/streams"
+)
+
+// scriptedModel 单个模型的行为: 先输出 chunks, 再正常结束、返回错误或挂起
+type scriptedModel struct {
+	chunks     []string
+	requestErr error // ChatStream 直接返回的错误
+	streamErr  error // 输出 chunks 之后通过流返回的错误
+	hang       bool  // 输出 chunks 之后不再输出, 等待空闲超时
+}
+
+// scriptedProvider 按模型名回放预设行为, 记录每个模型被调用的次数
+type scriptedProvider struct {
+	mu     sync.Mutex
+	models map[string]*scriptedModel
+	calls  map[string]int
+}
+
+func newScriptedProvider(t *testing.T, models map[string]*scriptedModel) (string, *scriptedProvider) {
+	t.Helper()
+	name := "router-test-" + t.Name()
+	provider := &scriptedProvider{models: models, calls: make(map[string]int)}
+	RegisterProvider(name, provider)
+	return name, provider
+}
+
+func (p *scriptedProvider) callCount(model string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls[model]
+}
+
+func (p *scriptedProvider) ChatStream(ctx context.Context, model string, credentials map[string]interface{}, promptMessages []*PromptMessage, modelParameters map[string]interface{}, tools []PromptMessageTool, stop []string) (*streams.Stream[*LLMResultChunk], error) {
+	p.mu.Lock()
+	p.calls[model]++
+	script := p.models[model]
+	p.mu.Unlock()
+	if script == nil {
+		return nil, errors.New("unknown model " + model)
+	}
+	if script.requestErr != nil {
+		return nil, script.requestErr
+	}
+
+	stream := streams.NewStream[*LLMResultChunk](ctx, 5)
+	go func() {
+		defer stream.CloseSend()
+		for _, content := range script.chunks {
+			if err := stream.Send(&LLMResultChunk{Model: model, Delta: LLMResultChunkDelta{Message: NewAssistantPromptMessage(content, "", nil)}}); err != nil {
+				return
+			}
+		}
+		switch {
+		case script.streamErr != nil:
+			stream.SendError(script.streamErr)
+		case script.hang:
+			<-ctx.Done()
+		default:
+			stream.Send(&LLMResultChunk{Model: model, Delta: LLMResultChunkDelta{Message: NewAssistantPromptMessage("", "", nil), FinishReason: "stop", Usage: &Usage{}}})
+		}
+	}()
+	return stream, nil
+}
+
+func (p *scriptedProvider) Chat(ctx context.Context, model string, credentials map[string]interface{}, promptMessages []*PromptMessage, modelParameters map[string]interface{}, tools []PromptMessageTool, stop []string) (*LLMResult, error) {
+	p.mu.Lock()
+	p.calls[model]++
+	script := p.models[model]
+	p.mu.Unlock()
+	if script == nil {
+		return nil, errors.New("unknown model " + model)
+	}
+	if script.requestErr != nil {
+		return nil, script.requestErr
+	}
+	if script.streamErr != nil {
+		return nil, script.streamErr
+	}
+	return &LLMResult{Model: model, Message: NewAssistantPromptMessage(script.chunks[0], "", nil)}, nil
+}
+
+func routerConfig(provider string) *config.SocialConfig {
+	cfg := &config.SocialConfig{}
+	cfg.Avatar.LLM = config.LLMConfig{Provider: provider, Model: "default-model", ContextWindow: 32000}
+	cfg.Avatar.Models = []config.LLMConfig{
+		{Name: "vision", Provider: provider, Model: "vision-model", ContextWindow: 16000},
+		{Name: "tools", Provider: provider, Model: "tools-model"},
+		{Name: "backup", Provider: provider, Model: "backup-model", ContextWindow: 128000},
+		{Name: "last", Provider: provider, Model: "last-model", ContextWindow: 64000},
+	}
+	return cfg
+}
+
+func TestResolveRoute(t *testing.T) {
+	yes, no := true, false
+	cfg := routerConfig("router-test")
+	cfg.Avatar.Fallbacks = []string{"backup", "missing", "backup"}
+	cfg.Avatar.Routes = []config.ModelRouteConfig{
+		{Name: "broken", RoomType: "group", Model: "missing"},
+		{Name: "aster-images", AsterDID: "did:plc:aster", Modalities: []string{"image"}, Model: "vision", Fallbacks: []string{"backup"}},
+		{Name: "tool-calls", Tools: &yes, Model: "tools", Fallbacks: []string{"default"}},
+		{RoomType: "group", ThreadID: "thread-1", Tools: &no, Model: "last"},
+	}
+	manager := NewModelManager(cfg)
+
+	cases := []struct {
+		name       string
+		req        *RouteRequest
+		wantRoute  string
+		wantModels []string
+		wantWindow int64
+	}{
+		{"nil request uses default chain", nil, DefaultModelName, []string{"default", "backup"}, 32000},
+		{"no rule matches", &RouteRequest{RoomType: "direct"}, DefaultModelName, []string{"default", "backup"}, 32000},
+		{"route without available models is skipped", &RouteRequest{RoomType: "group", ThreadID: "thread-2"}, DefaultModelName, []string{"default", "backup"}, 32000},
+		{"all modalities and aster must match", &RouteRequest{AsterDID: "did:plc:aster", Modalities: []string{"text", "image"}}, "aster-images", []string{"vision", "backup"}, 16000},
+		{"missing modality falls through", &RouteRequest{AsterDID: "did:plc:aster", Modalities: []string{"text"}}, DefaultModelName, []string{"default", "backup"}, 32000},
+		{"other aster falls through to tools", &RouteRequest{AsterDID: "did:plc:other", Modalities: []string{"image"}, HasTools: true}, "tool-calls", []string{"tools", "default"}, DefaultContextWindow},
+		{"tools=false rule with unnamed index", &RouteRequest{RoomType: "group", ThreadID: "thread-1"}, "routes[3]", []string{"last"}, 64000},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			route := manager.ResolveRoute(tc.req)
+			if route.Name != tc.wantRoute {
+				t.Errorf("route = %s, want %s", route.Name, tc.wantRoute)
+			}
+			var names []string
+			for _, ref := range route.Candidates() {
+				names = append(names, ref.Name)
+			}
+			if len(names) != len(tc.wantModels) {
+				t.Fatalf("candidates = %v, want %v", names, tc.wantModels)
+			}
+			for i := range names {
+				if names[i] != tc.wantModels[i] {
+					t.Fatalf("candidates = %v, want %v", names, tc.wantModels)
+				}
+			}
+			if window := route.ContextWindow(); window != tc.wantWindow {
+				t.Errorf("context window = %d, want %d", window, tc.wantWindow)
+			}
+		})
+	}
+}
+
+func TestModelRouteWithout(t *testing.T) {
+	cfg := routerConfig("router-test")
+	cfg.Avatar.Fallbacks = []string{"backup", "last"}
+	route := NewModelManager(cfg).ResolveRoute(nil)
+
+	next := route.Without(route.Primary())
+	if next.Primary().Name != "backup" || len(next.Candidates()) != 2 {
+		t.Errorf("after dropping primary = %+v", next.Candidates())
+	}
+	// 最后一个候选失败后仍保留它, 而不是返回空路由
+	only := next.Without(ModelRef{Name: "backup"}).Without(ModelRef{Name: "last"})
+	if only.Primary().Name != "last" {
+		t.Errorf("route without every candidate = %+v, want last candidate kept", only.Candidates())
+	}
+}
+
+// streamAll 读取路由流, 返回普通输出块的文本、降级记录和结束时的错误
+func streamAll(t *testing.T, manager *ModelManager, route *ModelRoute) ([]string, []*FallbackHop, error) {
+	t.Helper()
+	stream, err := manager.ChatStreamWithRoute(context.Background(), route, []*PromptMessage{&NewUserPromptMessage("hi", "").PromptMessage}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("ChatStreamWithRoute: %v", err)
+	}
+	var (
+		texts []string
+		hops  []*FallbackHop
+	)
+	for {
+		result := stream.Recv()
+		if result.HasData {
+			if result.Data.Fallback != nil {
+				hops = append(hops, result.Data.Fallback)
+				texts = append(texts, "|")
+				continue
+			}
+			if content, _ := result.Data.Delta.Message.Content.(string); content != "" {
+				texts = append(texts, content)
+			}
+		}
+		if result.Completed {
+			return texts, hops, result.Error
+		}
+	}
+}
+
+func TestChatStreamFallbackChain(t *testing.T) {
+	provider, scripted := newScriptedProvider(t, map[string]*scriptedModel{
+		"default-model": {chunks: []string{"par", "tial"}, streamErr: errors.New("connection reset")},
+		"vision-model":  {requestErr: errors.New("429 rate limited")},
+		"backup-model":  {chunks: []string{"hello", " world"}},
+	})
+	cfg := routerConfig(provider)
+	cfg.Avatar.Fallbacks = []string{"vision", "backup", "last"}
+	manager := NewModelManager(cfg)
+
+	texts, hops, err := streamAll(t, manager, manager.ResolveRoute(nil))
+	if err != nil {
+		t.Fatalf("stream error: %v", err)
+	}
+	// 中途失败的模型已输出的部分内容在降级块之前, 调用方据此丢弃;
+	// 出错时流中尚未读取的部分可能被丢弃, 因此只要求是已输出内容的前缀
+	first := 0
+	for first < len(texts) && texts[first] != "|" {
+		first++
+	}
+	partial := []string{"par", "tial"}
+	if first > len(partial) {
+		t.Fatalf("stream = %q, want partial output before the fallback", texts)
+	}
+	for i := 0; i < first; i++ {
+		if texts[i] != partial[i] {
+			t.Fatalf("stream = %q, want partial output before the fallback", texts)
+		}
+	}
+	want := []string{"|", "|", "hello", " world"}
+	rest := texts[first:]
+	if len(rest) != len(want) {
+		t.Fatalf("stream = %q, want %q after the partial output", texts, want)
+	}
+	for i := range want {
+		if rest[i] != want[i] {
+			t.Fatalf("stream = %q, want %q after the partial output", texts, want)
+		}
+	}
+
+	if len(hops) != 2 {
+		t.Fatalf("fallback hops = %+v, want 2", hops)
+	}
+	if hops[0].From.Name != "default" || hops[0].To.Name != "vision" || hops[0].Reason != "connection reset" {
+		t.Errorf("first hop = %+v", hops[0])
+	}
+	if hops[1].From.Name != "vision" || hops[1].To.Model != "backup-model" || hops[1].Reason != "429 rate limited" {
+		t.Errorf("second hop = %+v", hops[1])
+	}
+	if calls := scripted.callCount("last-model"); calls != 0 {
+		t.Errorf("last model called %d times after backup succeeded", calls)
+	}
+}
+
+func TestChatStreamFallbackExhausted(t *testing.T) {
+	provider, _ := newScriptedProvider(t, map[string]*scriptedModel{
+		"default-model": {streamErr: errors.New("first failed")},
+		"backup-model":  {chunks: []string{"half"}, streamErr: errors.New("second failed")},
+	})
+	cfg := routerConfig(provider)
+	cfg.Avatar.Fallbacks = []string{"backup"}
+	manager := NewModelManager(cfg)
+
+	_, hops, err := streamAll(t, manager, manager.ResolveRoute(nil))
+	if err == nil || err.Error() != "second failed" {
+		t.Fatalf("stream error = %v, want the last candidate's error", err)
+	}
+	if len(hops) != 1 || hops[0].To.Name != "backup" {
+		t.Errorf("fallback hops = %+v", hops)
+	}
+}
+
+func TestChatStreamFallbackOnIdleTimeout(t *testing.T) {
+	provider, _ := newScriptedProvider(t, map[string]*scriptedModel{
+		"default-model": {chunks: []string{"stalled"}, hang: true},
+		"backup-model":  {chunks: []string{"ok"}},
+	})
+	cfg := routerConfig(provider)
+	cfg.Avatar.Fallbacks = []string{"backup"}
+	cfg.Avatar.StreamIdleTimeout = 50 * time.Millisecond
+	manager := NewModelManager(cfg)
+
+	texts, hops, err := streamAll(t, manager, manager.ResolveRoute(nil))
+	if err != nil {
+		t.Fatalf("stream error: %v", err)
+	}
+	if len(hops) != 1 || hops[0].From.Name != "default" || hops[0].To.Name != "backup" {
+		t.Fatalf("fallback hops = %+v", hops)
+	}
+	if texts[len(texts)-1] != "ok" {
+		t.Errorf("stream = %q, want backup output last", texts)
+	}
+}
+
+func TestChatWithRouteFallback(t *testing.T) {
+	provider, _ := newScriptedProvider(t, map[string]*scriptedModel{
+		"default-model": {requestErr: errors.New("down")},
+		"backup-model":  {chunks: []string{"from backup"}},
+	})
+	cfg := routerConfig(provider)
+	cfg.Avatar.Fallbacks = []string{"backup"}
+	manager := NewModelManager(cfg)
+
+	result, err := manager.Chat(context.Background(), []*PromptMessage{&NewUserPromptMessage("hi", "").PromptMessage}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if result.Model != "backup-model" || result.Message.Content != "from backup" {
+		t.Errorf("result = %+v", result)
+	}
+}
@@ -0,0 +1,152 @@
+package llm
+
+import (
+	"strings"
+	"unicode"
+)
+
+// numTokensFromString calculates the number of tokens in a string
+func numTokensFromString(model string, text string) int64 {
+	// This is a simplified implementation - in a real implementation,
+	// you'd use a proper tokenizer like tiktoken
+	// For now, we'll use a rough approximation: CJK characters count as one token each,
+	// other text is approximated by words (4 tokens per 3 words)
+	var cjk int64
+	var rest strings.Builder
+	for _, r := range text {
+		if unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+			unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r) {
+			cjk++
+			rest.WriteRune(' ')
+			continue
+		}
+		rest.WriteRune(r)
+	}
+	words := int64(len(strings.Fields(rest.String())))
+	return cjk + words*4/3
+}
+
+// NumTokensFromMessages calculates the number of tokens in messages
+func NumTokensFromMessages(model string, messages []*PromptMessage, tools []PromptMessageTool) int64 {
+	// This is a simplified implementation
+	var (
+		tokensPerMessage int64 = 3
+		tokensPerName    int64 = 1
+		numTokens        int64 = 0
+	)
+
+	// Process messages
+	for _, message := range messages {
+		numTokens += tokensPerMessage
+
+		// Add tokens for content
+		if content, ok := message.Content.(string); ok {
+			numTokens += numTokensFromString(model, content)
+		} else if contentList, ok := message.Content.([]PromptMessageContent); ok {
+			for _, item := range contentList {
+				if textContent, ok := item.(*TextPromptMessageContent); ok {
+					numTokens += numTokensFromString(model, textContent.Data)
+				}
+				// Note: Image tokens would require more complex calculation
+			}
+		}
+
+		// Add tokens for name if present
+		if message.Name != "" {
+			numTokens += tokensPerName
+		}
+
+		// Add tokens for tool calls if present
+		for _, toolCall := range message.ToolCalls {
+			numTokens += numTokensFromString(model, toolCall.ID)
+			numTokens += numTokensFromString(model, toolCall.Type)
+			numTokens += numTokensFromString(model, toolCall.Function.Name)
+			numTokens += numTokensFromString(model, toolCall.Function.Arguments)
+		}
+	}
+
+	// Add tokens for tools
+	if len(tools) > 0 {
+		numTokens += numTokensForTools(model, tools)
+	}
+
+	// Every reply is primed with <im_start>assistant
+	numTokens += 3
+
+	return numTokens
+}
+
+// numTokensForTools calculates the number of tokens used by tools
+func numTokensForTools(model string, tools []PromptMessageTool) int64 {
+	var numTokens int64 = 0
+
+	for _, tool := range tools {
+		// Type and function tokens
+		numTokens += numTokensFromString(model, "type")
+		numTokens += numTokensFromString(model, "function")
+
+		// Function object tokens
+		numTokens += numTokensFromString(model, "name")
+		numTokens += numTokensFromString(model, tool.Name)
+		numTokens += numTokensFromString(model, "description")
+		numTokens += numTokensFromString(model, tool.Description)
+
+		// Parameters tokens
+		numTokens += numTokensFromString(model, "parameters")
+
+		if title, ok := tool.Parameters["title"].(string); ok {
+			numTokens += numTokensFromString(model, "title")
+			numTokens += numTokensFromString(model, title)
+		}
+
+		if typeStr, ok := tool.Parameters["type"].(string); ok {
+			numTokens += numTokensFromString(model, "type")
+			numTokens += numTokensFromString(model, typeStr)
+		}
+
+		if properties, ok := tool.Parameters["properties"].(map[string]interface{}); ok {
+			numTokens += numTokensFromString(model, "properties")
+
+			for key, value := range properties {
+				numTokens += numTokensFromString(model, key)
+
+				if propObj, ok := value.(map[string]interface{}); ok {
+					for fieldKey, fieldValue := range propObj {
+						numTokens += numTokensFromString(model, fieldKey)
+
+						if fieldKey == "enum" {
+							if enumValues, ok := fieldValue.([]interface{}); ok {
+								for _, enumValue := range enumValues {
+									numTokens += 3 // Approximate tokens for enum value
+									if enumStr, ok := enumValue.(string); ok {
+										numTokens += numTokensFromString(model, enumStr)
+									}
+								}
+							}
+						} else {
+							if fieldStr, ok := fieldValue.(string); ok {
+								numTokens += numTokensFromString(model, fieldStr)
+							} else {
+								// For non-string values, add approximate tokens
+								numTokens += 3
+							}
+						}
+					}
+				}
+			}
+		}
+
+		if required, ok := tool.Parameters["required"].([]interface{}); ok {
+			numTokens += numTokensFromString(model, "required")
+
+			for _, req := range required {
+				numTokens += 3 // Approximate tokens for required field
+				if reqStr, ok := req.(string); ok {
+					numTokens += numTokensFromString(model, reqStr)
+				}
+			}
+		}
+	}
+
+	return numTokens
+}
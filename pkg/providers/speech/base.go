@@ -0,0 +1,73 @@
+package speech
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+)
+
+const (
+	ProviderOpenAI = "openai"
+	ProviderFake   = "fake"
+)
+
+type AudioEncoding string
+
+const (
+	EncodingPCM16 AudioEncoding = "pcm16" // 16 位小端 PCM
+	EncodingOpus  AudioEncoding = "opus"  // Ogg 封装的 Opus 流
+)
+
+type AudioFormat struct {
+	Encoding   AudioEncoding
+	SampleRate int
+	Channels   int
+}
+
+var (
+	DefaultInputFormat  = AudioFormat{Encoding: EncodingPCM16, SampleRate: 16000, Channels: 1}
+	DefaultOutputFormat = AudioFormat{Encoding: EncodingPCM16, SampleRate: 24000, Channels: 1}
+)
+
+// BytesPerSecond 每秒音频的字节数, 只对 PCM16 有意义, 其它编码返回 0
+func (f AudioFormat) BytesPerSecond() int {
+	if f.Encoding != EncodingPCM16 {
+		return 0
+	}
+	return f.SampleRate * f.Channels * 2
+}
+
+// SpeechToText 将一段完整的用户语音转写为文本
+type SpeechToText interface {
+	Transcribe(ctx context.Context, audio []byte, format AudioFormat) (string, error)
+}
+
+// TextToSpeech 将文本合成为语音, 合成出的音频分块交给 emit, emit 返回错误时停止合成
+type TextToSpeech interface {
+	Synthesize(ctx context.Context, text string, format AudioFormat, emit func(chunk []byte) error) error
+}
+
+// NewSpeechToText 根据配置创建语音识别实现, 未配置 provider 时使用 fake
+func NewSpeechToText(cfg config.SpeechConfig) (SpeechToText, error) {
+	switch cfg.Provider {
+	case ProviderOpenAI:
+		return NewOpenAISpeechToText(cfg.APIURL, cfg.APIKey, cfg.Model, cfg.Language), nil
+	case ProviderFake, "":
+		return NewFakeSpeechToText(cfg.Text), nil
+	default:
+		return nil, fmt.Errorf("unsupported stt provider: %s", cfg.Provider)
+	}
+}
+
+// NewTextToSpeech 根据配置创建语音合成实现, 未配置 provider 时使用 fake
+func NewTextToSpeech(cfg config.SpeechConfig) (TextToSpeech, error) {
+	switch cfg.Provider {
+	case ProviderOpenAI:
+		return NewOpenAITextToSpeech(cfg.APIURL, cfg.APIKey, cfg.Model, cfg.Voice), nil
+	case ProviderFake, "":
+		return NewFakeTextToSpeech(), nil
+	default:
+		return nil, fmt.Errorf("unsupported tts provider: %s", cfg.Provider)
+	}
+}
@@ -0,0 +1,54 @@
+package speech
+
+import (
+	"context"
+	"fmt"
+	"unicode/utf8"
+)
+
+// fakeRuneDuration fake TTS 每个字符对应的音频时长(毫秒)
+const fakeRuneDuration = 80
+
+// FakeSpeechToText 不做真正的识别, 固定返回配置的文本, 用于离线调试实时语音会话
+type FakeSpeechToText struct {
+	Text string
+}
+
+func NewFakeSpeechToText(text string) *FakeSpeechToText {
+	return &FakeSpeechToText{Text: text}
+}
+
+// Transcribe 未配置文本时返回音频时长的描述, 便于确认整段语音都已送达
+func (f *FakeSpeechToText) Transcribe(ctx context.Context, audio []byte, format AudioFormat) (string, error) {
+	if f.Text != "" {
+		return f.Text, nil
+	}
+	if bytesPerSecond := format.BytesPerSecond(); bytesPerSecond > 0 {
+		return fmt.Sprintf("(%d 毫秒语音)", len(audio)*1000/bytesPerSecond), nil
+	}
+	return fmt.Sprintf("(%d 字节语音)", len(audio)), nil
+}
+
+// FakeTextToSpeech 按文本长度生成静音 PCM16, 每 100 毫秒一块. 请求 opus 时同样输出 PCM16
+type FakeTextToSpeech struct{}
+
+func NewFakeTextToSpeech() *FakeTextToSpeech {
+	return &FakeTextToSpeech{}
+}
+
+func (f *FakeTextToSpeech) Synthesize(ctx context.Context, text string, format AudioFormat, emit func(chunk []byte) error) error {
+	if format.Encoding != EncodingPCM16 {
+		format = DefaultOutputFormat
+	}
+	total := utf8.RuneCountInString(text) * fakeRuneDuration * format.BytesPerSecond() / 1000
+	chunkSize := format.BytesPerSecond() / 10
+	for sent := 0; sent < total; sent += chunkSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := emit(make([]byte, min(chunkSize, total-sent))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
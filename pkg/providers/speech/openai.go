@@ -0,0 +1,159 @@
+package speech
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+const (
+	openAIDefaultTranscribeModel = "gpt-4o-mini-transcribe"
+	openAIDefaultSpeechModel     = "gpt-4o-mini-tts"
+	openAIDefaultVoice           = "alloy"
+	openAISpeechSampleRate       = 24000 // /audio/speech 输出的 pcm 固定为 24kHz 单声道
+	openAISpeechChunkSize        = 4800  // 100 毫秒
+)
+
+// OpenAISpeechToText 兼容 OpenAI /audio/transcriptions 接口的语音识别实现
+type OpenAISpeechToText struct {
+	client   openai.Client
+	model    string
+	language string
+}
+
+func NewOpenAISpeechToText(baseURL string, apiKey string, model string, language string) *OpenAISpeechToText {
+	if model == "" {
+		model = openAIDefaultTranscribeModel
+	}
+	return &OpenAISpeechToText{
+		client:   openai.NewClient(openAIOptions(baseURL, apiKey)...),
+		model:    model,
+		language: language,
+	}
+}
+
+// Transcribe PCM16 封装为 WAV 上传, Opus 按 Ogg 文件上传
+func (s *OpenAISpeechToText) Transcribe(ctx context.Context, audio []byte, format AudioFormat) (string, error) {
+	var file io.Reader
+	switch format.Encoding {
+	case EncodingPCM16:
+		file = openai.File(bytes.NewReader(wavFile(audio, format)), "audio.wav", "audio/wav")
+	case EncodingOpus:
+		file = openai.File(bytes.NewReader(audio), "audio.ogg", "audio/ogg")
+	default:
+		return "", fmt.Errorf("不支持的音频编码: %s", format.Encoding)
+	}
+
+	params := openai.AudioTranscriptionNewParams{
+		File:  file,
+		Model: openai.AudioModel(s.model),
+	}
+	if s.language != "" {
+		params.Language = openai.String(s.language)
+	}
+	transcription, err := s.client.Audio.Transcriptions.New(ctx, params)
+	if err != nil {
+		return "", fmt.Errorf("请求语音识别失败: %w", err)
+	}
+	return transcription.Text, nil
+}
+
+// OpenAITextToSpeech 兼容 OpenAI /audio/speech 接口的语音合成实现, 边下载边输出
+type OpenAITextToSpeech struct {
+	client openai.Client
+	model  string
+	voice  string
+}
+
+func NewOpenAITextToSpeech(baseURL string, apiKey string, model string, voice string) *OpenAITextToSpeech {
+	if model == "" {
+		model = openAIDefaultSpeechModel
+	}
+	if voice == "" {
+		voice = openAIDefaultVoice
+	}
+	return &OpenAITextToSpeech{
+		client: openai.NewClient(openAIOptions(baseURL, apiKey)...),
+		model:  model,
+		voice:  voice,
+	}
+}
+
+func (s *OpenAITextToSpeech) Synthesize(ctx context.Context, text string, format AudioFormat, emit func(chunk []byte) error) error {
+	var responseFormat openai.AudioSpeechNewParamsResponseFormat
+	switch format.Encoding {
+	case EncodingPCM16:
+		if format.SampleRate != openAISpeechSampleRate || format.Channels != 1 {
+			return fmt.Errorf("OpenAI 语音合成只支持 24kHz 单声道 PCM16")
+		}
+		responseFormat = openai.AudioSpeechNewParamsResponseFormatPCM
+	case EncodingOpus:
+		responseFormat = openai.AudioSpeechNewParamsResponseFormatOpus
+	default:
+		return fmt.Errorf("不支持的音频编码: %s", format.Encoding)
+	}
+
+	resp, err := s.client.Audio.Speech.New(ctx, openai.AudioSpeechNewParams{
+		Input:          text,
+		Model:          openai.SpeechModel(s.model),
+		Voice:          openai.AudioSpeechNewParamsVoice(s.voice),
+		ResponseFormat: responseFormat,
+	})
+	if err != nil {
+		return fmt.Errorf("请求语音合成失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	buf := make([]byte, openAISpeechChunkSize)
+	for {
+		n, err := io.ReadFull(resp.Body, buf)
+		if n > 0 {
+			if emitErr := emit(bytes.Clone(buf[:n])); emitErr != nil {
+				return emitErr
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("读取合成音频失败: %w", err)
+		}
+	}
+}
+
+func openAIOptions(baseURL string, apiKey string) []option.RequestOption {
+	options := make([]option.RequestOption, 0)
+	if apiKey != "" {
+		options = append(options, option.WithAPIKey(apiKey))
+	}
+	if baseURL != "" {
+		options = append(options, option.WithBaseURL(baseURL))
+	}
+	return options
+}
+
+// wavFile 为 PCM16 数据加上 44 字节的 WAV 文件头
+func wavFile(pcm []byte, format AudioFormat) []byte {
+	var buf bytes.Buffer
+	buf.Grow(44 + len(pcm))
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+len(pcm)))
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(16))
+	binary.Write(&buf, binary.LittleEndian, uint16(1))
+	binary.Write(&buf, binary.LittleEndian, uint16(format.Channels))
+	binary.Write(&buf, binary.LittleEndian, uint32(format.SampleRate))
+	binary.Write(&buf, binary.LittleEndian, uint32(format.BytesPerSecond()))
+	binary.Write(&buf, binary.LittleEndian, uint16(format.Channels*2))
+	binary.Write(&buf, binary.LittleEndian, uint16(16))
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(pcm)))
+	buf.Write(pcm)
+	return buf.Bytes()
+}
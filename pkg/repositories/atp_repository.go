@@ -2,6 +2,7 @@ package repositories
 
 import (
 	"errors"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -49,3 +50,27 @@ func (r *AtpRepository) InsertOrUpdateAtpRecord(record *AtpRecord) error {
 
 	return r.metaStore.DB.Model(&AtpRecord{}).Where("uri = ?", record.URI).Updates(record).Error
 }
+
+func (r *AtpRepository) DeleteAtpRecord(uri string) error {
+	return r.metaStore.DB.Where("uri = ?", uri).Delete(&AtpRecord{}).Error
+}
+
+// GetIngestCursor 返回订阅源保存的游标, 没有记录时返回 0
+func (r *AtpRepository) GetIngestCursor(source string) (int64, error) {
+	var cursor IngestCursor
+	if err := r.metaStore.DB.Where("source = ?", source).First(&cursor).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return cursor.Cursor, nil
+}
+
+func (r *AtpRepository) SaveIngestCursor(source string, cursor int64) error {
+	return r.metaStore.DB.Save(&IngestCursor{
+		Source:    source,
+		Cursor:    cursor,
+		UpdatedAt: time.Now().UnixMilli(),
+	}).Error
+}
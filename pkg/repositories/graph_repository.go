@@ -0,0 +1,197 @@
+package repositories
+
+import "gorm.io/gorm"
+
+type GraphRepository struct {
+	metaStore *MetaStore
+}
+
+func NewGraphRepository(metaStore *MetaStore) *GraphRepository {
+	return &GraphRepository{metaStore: metaStore}
+}
+
+// RelationshipCount 按 DID 聚合的关系条数
+type RelationshipCount struct {
+	Did   string `gorm:"column:did"`
+	Count int64  `gorm:"column:count"`
+}
+
+func (r *GraphRepository) CreateRelationship(relationship *Relationship) error {
+	return r.metaStore.DB.Create(relationship).Error
+}
+
+// SaveRelationship 按主键写入或覆盖关系, 用于索引其他 PDS 上的记录
+func (r *GraphRepository) SaveRelationship(relationship *Relationship) error {
+	return r.metaStore.DB.Save(relationship).Error
+}
+
+func (r *GraphRepository) UpdateRelationship(uri string, updates map[string]interface{}) error {
+	return r.metaStore.DB.Model(&Relationship{}).Where("uri = ?", uri).Updates(updates).Error
+}
+
+func (r *GraphRepository) GetRelationshipByURI(uri string) (*Relationship, error) {
+	var relationship Relationship
+	if err := r.metaStore.DB.Where("uri = ?", uri).First(&relationship).Error; err != nil {
+		return nil, err
+	}
+	return &relationship, nil
+}
+
+// GetRelationship 返回 creator 对 object 的某种关系, 存在多条时取最早的一条
+func (r *GraphRepository) GetRelationship(creator string, predicate string, object string) (*Relationship, error) {
+	var relationship Relationship
+	err := r.metaStore.DB.Where("creator = ? AND predicate = ? AND object = ?", creator, predicate, object).
+		Order("created_at ASC").
+		First(&relationship).Error
+	if err != nil {
+		return nil, err
+	}
+	return &relationship, nil
+}
+
+func (r *GraphRepository) GetRelationshipByID(id string) (*Relationship, error) {
+	var relationship Relationship
+	if err := r.metaStore.DB.Where("id = ?", id).First(&relationship).Error; err != nil {
+		return nil, err
+	}
+	return &relationship, nil
+}
+
+func (r *GraphRepository) DeleteRelationship(uri string) error {
+	return r.metaStore.DB.Where("uri = ?", uri).Delete(&Relationship{}).Error
+}
+
+// RelationshipKey 关系列表的分页位置, 列表按 (created_at, id) 倒序排列
+type RelationshipKey struct {
+	CreatedAt int64
+	ID        string
+}
+
+// beforeRelationshipKey 取排在 key 之后的关系, key 为空时从头开始
+func beforeRelationshipKey(query *gorm.DB, key *RelationshipKey) *gorm.DB {
+	query = query.Order("created_at DESC").Order("id DESC")
+	if key == nil {
+		return query
+	}
+	return query.Where("created_at < ? OR (created_at = ? AND id < ?)", key.CreatedAt, key.CreatedAt, key.ID)
+}
+
+// ListRelationshipsByCreator 按时间倒序返回 creator 发起的关系, 即关注列表或屏蔽列表
+func (r *GraphRepository) ListRelationshipsByCreator(creator string, predicate string, limit int, before *RelationshipKey) ([]*Relationship, error) {
+	var relationships []*Relationship
+	query := beforeRelationshipKey(r.metaStore.DB.Where("creator = ? AND predicate = ?", creator, predicate), before)
+
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	if err := query.Find(&relationships).Error; err != nil {
+		return nil, err
+	}
+	return relationships, nil
+}
+
+// ListRelationshipsByObject 按时间倒序返回指向 object 的关系, 即粉丝列表
+func (r *GraphRepository) ListRelationshipsByObject(object string, predicate string, limit int, before *RelationshipKey) ([]*Relationship, error) {
+	var relationships []*Relationship
+	query := beforeRelationshipKey(r.metaStore.DB.Where("object = ? AND predicate = ?", object, predicate), before)
+
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	if err := query.Find(&relationships).Error; err != nil {
+		return nil, err
+	}
+	return relationships, nil
+}
+
+// GetFollowingDIDs 返回 did 关注的全部用户
+func (r *GraphRepository) GetFollowingDIDs(did string) ([]string, error) {
+	var dids []string
+	if err := r.metaStore.DB.Model(&Relationship{}).
+		Where("creator = ? AND predicate = ?", did, RelationshipFollow).
+		Distinct().Pluck("object", &dids).Error; err != nil {
+		return nil, err
+	}
+	return dids, nil
+}
+
+// GetFollowingDIDsAt 返回 did 在 until 时刻 (含) 之前已经关注的用户
+func (r *GraphRepository) GetFollowingDIDsAt(did string, until int64) ([]string, error) {
+	var dids []string
+	if err := r.metaStore.DB.Model(&Relationship{}).
+		Where("creator = ? AND predicate = ? AND created_at <= ?", did, RelationshipFollow, until).
+		Distinct().Pluck("object", &dids).Error; err != nil {
+		return nil, err
+	}
+	return dids, nil
+}
+
+// CountFollowers 返回每个 DID 的粉丝数, 同一用户的重复关注只计一次
+func (r *GraphRepository) CountFollowers(dids []string) (map[string]int64, error) {
+	return r.countDistinct("object", "creator", dids)
+}
+
+// CountFollowing 返回每个 DID 的关注数
+func (r *GraphRepository) CountFollowing(dids []string) (map[string]int64, error) {
+	return r.countDistinct("creator", "object", dids)
+}
+
+func (r *GraphRepository) countDistinct(groupColumn string, countColumn string, dids []string) (map[string]int64, error) {
+	counts := make(map[string]int64, len(dids))
+	if len(dids) == 0 {
+		return counts, nil
+	}
+
+	var rows []*RelationshipCount
+	err := r.metaStore.DB.Model(&Relationship{}).
+		Select(groupColumn+" AS did, COUNT(DISTINCT "+countColumn+") AS count").
+		Where(groupColumn+" IN ? AND predicate = ?", dids, RelationshipFollow).
+		Group(groupColumn).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		counts[row.Did] = row.Count
+	}
+	return counts, nil
+}
+
+// GetMutedDIDs 返回 viewer 静音的用户, 静音是单向的
+func (r *GraphRepository) GetMutedDIDs(viewerDID string) ([]string, error) {
+	var muted []string
+	if viewerDID == "" {
+		return muted, nil
+	}
+	if err := r.metaStore.DB.Model(&Relationship{}).
+		Where("creator = ? AND predicate = ?", viewerDID, RelationshipMute).
+		Distinct().Pluck("object", &muted).Error; err != nil {
+		return nil, err
+	}
+	return muted, nil
+}
+
+// GetBlockedDIDs 返回与 viewer 互相不可见的用户: viewer 屏蔽的和屏蔽了 viewer 的
+func (r *GraphRepository) GetBlockedDIDs(viewerDID string) ([]string, error) {
+	if viewerDID == "" {
+		return []string{}, nil
+	}
+
+	var blocking []string
+	if err := r.metaStore.DB.Model(&Relationship{}).
+		Where("creator = ? AND predicate = ?", viewerDID, RelationshipBlock).
+		Distinct().Pluck("object", &blocking).Error; err != nil {
+		return nil, err
+	}
+
+	var blockedBy []string
+	if err := r.metaStore.DB.Model(&Relationship{}).
+		Where("object = ? AND predicate = ?", viewerDID, RelationshipBlock).
+		Distinct().Pluck("creator", &blockedBy).Error; err != nil {
+		return nil, err
+	}
+
+	return append(blocking, blockedBy...), nil
+}
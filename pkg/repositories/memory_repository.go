@@ -0,0 +1,62 @@
+package repositories
+
+import (
+	"time"
+)
+
+type MemoryRepository struct {
+	metaStore *MetaStore
+}
+
+func NewMemoryRepository(metaStore *MetaStore) *MemoryRepository {
+	return &MemoryRepository{
+		metaStore: metaStore,
+	}
+}
+
+func (r *MemoryRepository) InsertMemoryVector(vector *MemoryVector) error {
+	if vector.CreatedAt == 0 {
+		vector.CreatedAt = time.Now().UnixMilli()
+	}
+	return r.metaStore.DB.Create(vector).Error
+}
+
+// ListMemoryVectorsByUser 按写入顺序返回用户的全部向量, 用于重建索引
+func (r *MemoryRepository) ListMemoryVectorsByUser(userDid string) ([]*MemoryVector, error) {
+	var vectors []*MemoryVector
+	if err := r.metaStore.DB.Where("user_did = ? AND deleted = ?", userDid, false).
+		Order("created_at ASC").
+		Find(&vectors).Error; err != nil {
+		return nil, err
+	}
+	return vectors, nil
+}
+
+func (r *MemoryRepository) DeleteMemoryVectorsByMessageID(userDid string, messageID string) error {
+	return r.metaStore.DB.Model(&MemoryVector{}).
+		Where("user_did = ? AND message_id = ?", userDid, messageID).
+		Update("deleted", true).Error
+}
+
+func (r *MemoryRepository) InsertThreadSummary(summary *ThreadSummary) error {
+	if summary.CreatedAt == 0 {
+		summary.CreatedAt = time.Now().UnixMilli()
+	}
+	return r.metaStore.DB.Create(summary).Error
+}
+
+// GetLatestThreadSummary 话题最新的摘要, 覆盖到同一毫秒的摘要按累计消息数区分, 不存在时返回 nil
+func (r *MemoryRepository) GetLatestThreadSummary(roomID string, threadID string) (*ThreadSummary, error) {
+	var summaries []*ThreadSummary
+	if err := r.metaStore.DB.Where("room_id = ? AND thread_id = ?", roomID, threadID).
+		Order("covered_until DESC").
+		Order("message_count DESC").
+		Limit(1).
+		Find(&summaries).Error; err != nil {
+		return nil, err
+	}
+	if len(summaries) == 0 {
+		return nil, nil
+	}
+	return summaries[0], nil
+}
@@ -3,6 +3,9 @@ package repositories
 import (
 	"encoding/json"
 	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type MessageRepository struct {
@@ -35,6 +38,56 @@ func (r *MessageRepository) ListMessagesHistory(roomID string, threadID string)
 	return messages, nil
 }
 
+// ListRecentMessages 返回话题中 created_at 不晚于 beforeCreatedAt 的最近 limit 条消息, 按 (created_at, id) 正序排列
+// beforeCreatedAt <= 0 表示不限制时间
+func (r *MessageRepository) ListRecentMessages(roomID string, threadID string, beforeCreatedAt int64, limit int) ([]*Message, error) {
+	query := r.metaStore.DB.Where("room_id = ? AND thread_id = ? AND deleted = ?", roomID, threadID, false)
+	if beforeCreatedAt > 0 {
+		query = query.Where("created_at <= ?", beforeCreatedAt)
+	}
+
+	var messages []*Message
+	if err := query.Order("created_at DESC").Order("id DESC").Limit(limit).Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+// MessageKey 消息在话题中的位置, 同一毫秒内的消息按 id 排序
+type MessageKey struct {
+	CreatedAt int64
+	ID        string
+}
+
+// Before 判断 key 是否排在 other 之前或与之相同
+func (k MessageKey) Before(other MessageKey) bool {
+	return k.CreatedAt < other.CreatedAt || (k.CreatedAt == other.CreatedAt && k.ID <= other.ID)
+}
+
+// afterMessageKey 取排在 key 之后的消息, 按 (created_at, id) 正序排列, key 为空时从头开始
+func afterMessageKey(query *gorm.DB, key *MessageKey) *gorm.DB {
+	query = query.Order("created_at ASC").Order("id ASC")
+	if key == nil {
+		return query
+	}
+	return query.Where("created_at > ? OR (created_at = ? AND id > ?)", key.CreatedAt, key.CreatedAt, key.ID)
+}
+
+// ListMessagesAfter 返回话题中排在 after 之后的消息, 按 (created_at, id) 正序排列
+func (r *MessageRepository) ListMessagesAfter(roomID string, threadID string, after *MessageKey, limit int) ([]*Message, error) {
+	var messages []*Message
+	query := r.metaStore.DB.Where("room_id = ? AND thread_id = ? AND deleted = ?", roomID, threadID, false)
+	if err := afterMessageKey(query, after).
+		Limit(limit).
+		Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
 type MessagePaginationResult struct {
 	Messages []*Message `json:"messages"`
 	HasMore  bool       `json:"hasMore"`
@@ -209,6 +262,169 @@ func (r *MessageRepository) UpdateUserRoomStatus(userID, roomID string, updates
 		Updates(updates).Error
 }
 
+// CreateRoomWithMembers 在一个事务中创建房间、默认话题和成员状态
+func (r *MessageRepository) CreateRoomWithMembers(room *Room, thread *Thread, members []*UserRoomStatus) error {
+	return r.metaStore.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(room).Error; err != nil {
+			return err
+		}
+		if thread != nil {
+			if err := tx.Create(thread).Error; err != nil {
+				return err
+			}
+		}
+		if len(members) > 0 {
+			if err := tx.Create(members).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// FindDirectRoom 两个用户之间未删除的单聊房间, 不存在时返回 nil
+func (r *MessageRepository) FindDirectRoom(userID, peerID string) (*Room, error) {
+	var rooms []*Room
+	err := r.metaStore.DB.Model(&Room{}).
+		Joins("JOIN user_room_status a ON a.room_id = rooms.id AND a.user_id = ?", userID).
+		Joins("JOIN user_room_status b ON b.room_id = rooms.id AND b.user_id = ?", peerID).
+		Where("rooms.type = ? AND rooms.deleted = ?", "direct", false).
+		Limit(1).
+		Find(&rooms).Error
+	if err != nil || len(rooms) == 0 {
+		return nil, err
+	}
+	return rooms[0], nil
+}
+
+// ListRoomsByMember 按更新时间倒序列出用户所在的房间, before 为 0 时从最新开始
+func (r *MessageRepository) ListRoomsByMember(userID string, statuses []string, archived bool, before int64, limit int) ([]*Room, error) {
+	var rooms []*Room
+	query := r.metaStore.DB.Model(&Room{}).
+		Joins("JOIN user_room_status s ON s.room_id = rooms.id").
+		Where("s.user_id = ? AND s.status IN ? AND s.deleted = ?", userID, statuses, false).
+		Where("rooms.archived = ? AND rooms.deleted = ?", archived, false)
+	if before > 0 {
+		query = query.Where("rooms.updated_at < ?", before)
+	}
+	err := query.Order("rooms.updated_at DESC").Limit(limit).Find(&rooms).Error
+	return rooms, err
+}
+
+// HasRoomMessages 房间内是否有未删除的消息, did 不为空时只统计用户发送或接收的消息
+func (r *MessageRepository) HasRoomMessages(roomID string, did string) (bool, error) {
+	query := r.metaStore.DB.Model(&Message{}).Where("room_id = ? AND deleted = ?", roomID, false)
+	if did != "" {
+		query = query.Where("sender_id = ? OR receiver_id = ?", did, did)
+	}
+	var count int64
+	err := query.Count(&count).Error
+	return count > 0, err
+}
+
+// ListRoomIDsWithoutStatus 用户发送或接收过消息, 但没有成员状态的房间
+func (r *MessageRepository) ListRoomIDsWithoutStatus(did string) ([]string, error) {
+	var roomIDs []string
+	err := r.metaStore.DB.Model(&Message{}).
+		Where("(sender_id = ? OR receiver_id = ?) AND deleted = ?", did, did, false).
+		Where("room_id NOT IN (?)", r.metaStore.DB.Model(&UserRoomStatus{}).Select("room_id").Where("user_id = ?", did)).
+		Distinct().
+		Pluck("room_id", &roomIDs).Error
+	return roomIDs, err
+}
+
+// ListRoomMembers 房间内未退出的成员, 按加入时间升序
+func (r *MessageRepository) ListRoomMembers(roomIDs []string) ([]*UserRoomStatus, error) {
+	var members []*UserRoomStatus
+	if len(roomIDs) == 0 {
+		return members, nil
+	}
+	err := r.metaStore.DB.Where("room_id IN ? AND status <> ? AND deleted = ?", roomIDs, "left", false).
+		Order("created_at ASC").
+		Find(&members).Error
+	return members, err
+}
+
+// RecordRoomMessage 更新房间的最后一条消息, 并为发送者以外的正式成员增加未读数
+func (r *MessageRepository) RecordRoomMessage(roomID string, messageID string, senderID string) error {
+	now := time.Now().UnixMilli()
+	return r.metaStore.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Room{}).Where("id = ?", roomID).
+			Updates(map[string]interface{}{"last_mid": messageID, "updated_at": now}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&UserRoomStatus{}).
+			Where("room_id = ? AND user_id <> ? AND status = ? AND deleted = ?", roomID, senderID, "accepted", false).
+			Updates(map[string]interface{}{"unread_count": gorm.Expr("unread_count + 1"), "updated_at": now}).Error
+	})
+}
+
+func (r *MessageRepository) ListThreadsByRoom(roomID string) ([]*Thread, error) {
+	var threads []*Thread
+	err := r.metaStore.DB.Where("room_id = ? AND deleted = ?", roomID, false).
+		Order("created_at ASC").
+		Find(&threads).Error
+	return threads, err
+}
+
+// ListMirrorMembers 房间内开启了 PDS 同步的正式成员
+func (r *MessageRepository) ListMirrorMembers(roomID string) ([]string, error) {
+	var userIDs []string
+	err := r.metaStore.DB.Model(&UserRoomStatus{}).
+		Where("room_id = ? AND status = ? AND pds_mirror = ? AND deleted = ?", roomID, "accepted", true, false).
+		Pluck("user_id", &userIDs).Error
+	return userIDs, err
+}
+
+// ListMirrorMessages 房间内属于用户的消息: 用户发送的消息和回复给用户的 agentMsgType 消息, 按创建时间升序
+func (r *MessageRepository) ListMirrorMessages(roomID string, userID string, agentMsgType int) ([]*Message, error) {
+	var messages []*Message
+	err := r.metaStore.DB.Where("room_id = ? AND deleted = ?", roomID, false).
+		Where("sender_id = ? OR (msg_type = ? AND receiver_id = ?)", userID, agentMsgType, userID).
+		Order("created_at ASC").
+		Find(&messages).Error
+	return messages, err
+}
+
+// ChatHistoryImport 从 PDS 记录重建的聊天数据, 本地已存在的记录保持不变
+type ChatHistoryImport struct {
+	Rooms         []*Room
+	Members       []*UserRoomStatus
+	Threads       []*Thread
+	AgentMessages []*AgentMessage
+	AgentItems    []*AgentMessageItem
+	Messages      []*Message
+}
+
+// ImportChatHistory 在一个事务中写入导入的聊天数据, 主键冲突的记录跳过
+func (r *MessageRepository) ImportChatHistory(data *ChatHistoryImport) error {
+	return r.metaStore.DB.Transaction(func(tx *gorm.DB) error {
+		tx = tx.Clauses(clause.OnConflict{DoNothing: true})
+		create := func(count int, value interface{}) error {
+			if count == 0 {
+				return nil
+			}
+			return tx.CreateInBatches(value, 100).Error
+		}
+		if err := create(len(data.Rooms), data.Rooms); err != nil {
+			return err
+		}
+		if err := create(len(data.Members), data.Members); err != nil {
+			return err
+		}
+		if err := create(len(data.Threads), data.Threads); err != nil {
+			return err
+		}
+		if err := create(len(data.AgentMessages), data.AgentMessages); err != nil {
+			return err
+		}
+		if err := create(len(data.AgentItems), data.AgentItems); err != nil {
+			return err
+		}
+		return create(len(data.Messages), data.Messages)
+	})
+}
+
 func (r *MessageRepository) InsertAgentMessage(message *AgentMessage) error {
 	return r.metaStore.DB.Create(message).Error
 }
@@ -221,6 +437,22 @@ func (r *MessageRepository) GetAgentMessageByID(agentMessageID string) (*AgentMe
 	return &agentMessage, nil
 }
 
+// GetAgentMessagesByMessageIDs 按所属的消息 ID 批量读取 AI 回复
+func (r *MessageRepository) GetAgentMessagesByMessageIDs(messageIDs []string) (map[string]*AgentMessage, error) {
+	result := make(map[string]*AgentMessage, len(messageIDs))
+	if len(messageIDs) == 0 {
+		return result, nil
+	}
+	var agentMessages []*AgentMessage
+	if err := r.metaStore.DB.Where("message_id IN ? AND deleted = ?", messageIDs, false).Find(&agentMessages).Error; err != nil {
+		return nil, err
+	}
+	for _, agentMessage := range agentMessages {
+		result[agentMessage.MessageID] = agentMessage
+	}
+	return result, nil
+}
+
 func (r *MessageRepository) UpdateAgentMessage(agentMessageID string, updates map[string]interface{}) error {
 	updates["updated_at"] = time.Now().UnixMilli()
 	return r.metaStore.DB.Model(&AgentMessage{}).Where("id = ?", agentMessageID).Updates(updates).Error
@@ -271,6 +503,13 @@ func (r *MessageRepository) UpdateAgentMessageIncomplete(agentMessageID string,
 	return r.UpdateAgentMessage(agentMessageID, updates)
 }
 
+func (r *MessageRepository) UpdateAgentMessageMetadata(agentMessageID string, metadata interface{}) error {
+	metadataStr, _ := json.Marshal(metadata)
+	return r.UpdateAgentMessage(agentMessageID, map[string]interface{}{
+		"metadata": string(metadataStr),
+	})
+}
+
 func (r *MessageRepository) InsertAgentMessageItem(item *AgentMessageItem) error {
 	return r.metaStore.DB.Create(item).Error
 }
@@ -374,6 +613,51 @@ func (r *MessageRepository) SearchMessages(roomID string, threadID string, keywo
 	return messages, err
 }
 
+// ListRoomIDsByMember 用户是正式成员, 或发送、接收过消息且没有退出的房间
+func (r *MessageRepository) ListRoomIDsByMember(did string) ([]string, error) {
+	var messageRoomIDs, memberRoomIDs, leftRoomIDs []string
+	if err := r.metaStore.DB.Model(&Message{}).
+		Where("(sender_id = ? OR receiver_id = ?) AND deleted = ?", did, did, false).
+		Distinct().
+		Pluck("room_id", &messageRoomIDs).Error; err != nil {
+		return nil, err
+	}
+	if err := r.metaStore.DB.Model(&UserRoomStatus{}).
+		Where("user_id = ? AND status = ? AND deleted = ?", did, "accepted", false).
+		Pluck("room_id", &memberRoomIDs).Error; err != nil {
+		return nil, err
+	}
+	if err := r.metaStore.DB.Model(&UserRoomStatus{}).
+		Where("user_id = ? AND status = ? AND deleted = ?", did, "left", false).
+		Pluck("room_id", &leftRoomIDs).Error; err != nil {
+		return nil, err
+	}
+
+	left := make(map[string]bool, len(leftRoomIDs))
+	for _, roomID := range leftRoomIDs {
+		left[roomID] = true
+	}
+	seen := make(map[string]bool)
+	roomIDs := make([]string, 0, len(messageRoomIDs)+len(memberRoomIDs))
+	for _, roomID := range append(memberRoomIDs, messageRoomIDs...) {
+		if seen[roomID] || left[roomID] {
+			continue
+		}
+		seen[roomID] = true
+		roomIDs = append(roomIDs, roomID)
+	}
+	return roomIDs, nil
+}
+
+// ListMessagesForIndex 按 (created_at, id) 分批读取排在 after 之后的消息, 用于重建全文检索索引
+func (r *MessageRepository) ListMessagesForIndex(after *MessageKey, limit int) ([]*Message, error) {
+	var messages []*Message
+	err := afterMessageKey(r.metaStore.DB.Where("deleted = ?", false), after).
+		Limit(limit).
+		Find(&messages).Error
+	return messages, err
+}
+
 func (r *MessageRepository) GetMessageStatsByRoom(roomID string, threadID string) (map[string]interface{}, error) {
 	stats := make(map[string]interface{})
 
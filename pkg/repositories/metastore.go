@@ -2,7 +2,10 @@ package repositories
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
+	"github.com/zhongshangwu/avatarai-social/pkg/search"
 	"gorm.io/gorm"
 )
 
@@ -18,6 +21,17 @@ type MetaStore struct {
 	FileRepo     *FileRepository
 	ActivityRepo *ActivityRepository
 	MCPRepo      *MCPRepository
+	MemoryRepo   *MemoryRepository
+	OutboxRepo   *OutboxRepository
+	GraphRepo    *GraphRepository
+	NotifyRepo   *NotificationRepository
+	ModRepo      *ModerationRepository
+
+	// 全文检索索引, Init 之前以及数据库不支持时为 search.DisabledIndex
+	Search search.Index
+
+	// 事务中注册的提交后回调, 只有 WithTransaction 创建的 MetaStore 不为空
+	afterCommit *[]func()
 }
 
 func NewMetaStore(db *gorm.DB) *MetaStore {
@@ -32,12 +46,18 @@ func NewMetaStore(db *gorm.DB) *MetaStore {
 	metaStore.FileRepo = NewFileRepository(metaStore)
 	metaStore.ActivityRepo = NewActivityRepository(metaStore)
 	metaStore.MCPRepo = NewMCPRepository(metaStore)
+	metaStore.MemoryRepo = NewMemoryRepository(metaStore)
+	metaStore.OutboxRepo = NewOutboxRepository(metaStore)
+	metaStore.GraphRepo = NewGraphRepository(metaStore)
+	metaStore.NotifyRepo = NewNotificationRepository(metaStore)
+	metaStore.ModRepo = NewModerationRepository(metaStore)
+	metaStore.Search = search.DisabledIndex{}
 	return metaStore
 }
 
 func (ms *MetaStore) Init() error {
 	ms.DB.Set("gorm:table_options", "WITHOUT ROWID")
-	return ms.DB.AutoMigrate(
+	err := ms.DB.AutoMigrate(
 		&OAuthAuthRequest{},
 		&OAuthSession{},
 		&OAuthCode{},
@@ -60,8 +80,19 @@ func (ms *MetaStore) Init() error {
 		&Topic{},
 		&ActivityTopic{},
 
+		// social graph
+		&Relationship{},
+		&Notification{},
+
+		// moderation
+		&ModerationDecision{},
+		&ContentLabel{},
+		&LabelEvent{},
+
 		// atp
 		&AtpRecord{},
+		&PDSOutboxOp{},
+		&IngestCursor{},
 
 		// messages
 		&Room{},
@@ -79,9 +110,59 @@ func (ms *MetaStore) Init() error {
 		&MCPServerEndpoint{},
 		&MCPServerOAuthCode{},
 		&MCPServerAuth{},
+
+		// memory
+		&MemoryVector{},
+		&ThreadSummary{},
 	)
+	if err != nil {
+		return err
+	}
+
+	index, err := search.NewIndex(ms.DB)
+	if errors.Is(err, search.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("初始化全文检索索引失败: %w", err)
+	}
+	ms.Search = index
+	return nil
 }
 
 func (ms *MetaStore) Transaction(ctx context.Context, fn func(tx *gorm.DB) error) error {
 	return ms.DB.WithContext(ctx).Transaction(fn)
 }
+
+// WithTransaction 在事务中执行 fn, fn 通过 txStore 读写的数据一起提交或回滚.
+// 已经在事务中时使用 savepoint 嵌套, 提交后回调等到最外层事务提交后执行
+func (ms *MetaStore) WithTransaction(ctx context.Context, fn func(txStore *MetaStore) error) error {
+	var callbacks []func()
+	afterCommit := ms.afterCommit
+	if afterCommit == nil {
+		afterCommit = &callbacks
+	}
+	err := ms.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		txStore := NewMetaStore(tx)
+		txStore.Search = ms.Search
+		txStore.afterCommit = afterCommit
+		return fn(txStore)
+	})
+	if err != nil {
+		return err
+	}
+	for _, callback := range callbacks {
+		callback()
+	}
+	return nil
+}
+
+// AfterCommit 事务提交后执行 fn, 不在事务中时立即执行. 用于检索索引等不参与事务的副作用,
+// 避免事务回滚后留下脏数据, 也避免 SQLite 在事务持有写锁时从其他连接写入
+func (ms *MetaStore) AfterCommit(fn func()) {
+	if ms.afterCommit == nil {
+		fn()
+		return
+	}
+	*ms.afterCommit = append(*ms.afterCommit, fn)
+}
@@ -0,0 +1,162 @@
+package repositories
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// likeEscaper 转义 LIKE 中的通配符, 使用 ! 作为转义字符以兼容各数据库对反斜杠的处理
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+type ModerationRepository struct {
+	metaStore *MetaStore
+}
+
+func NewModerationRepository(metaStore *MetaStore) *ModerationRepository {
+	return &ModerationRepository{metaStore: metaStore}
+}
+
+func (r *ModerationRepository) CreateDecision(decision *ModerationDecision) error {
+	return r.metaStore.DB.Create(decision).Error
+}
+
+func (r *ModerationRepository) GetDecision(id uint) (*ModerationDecision, error) {
+	var decision ModerationDecision
+	if err := r.metaStore.DB.Where("id = ?", id).First(&decision).Error; err != nil {
+		return nil, err
+	}
+	return &decision, nil
+}
+
+// ListDecisions 按 ID 升序返回审核记录, 先进入队列的先审核. status 为空时返回全部
+func (r *ModerationRepository) ListDecisions(status string, afterID uint, limit int) ([]*ModerationDecision, error) {
+	var decisions []*ModerationDecision
+	query := r.metaStore.DB.Order("id ASC")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if afterID > 0 {
+		query = query.Where("id > ?", afterID)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	err := query.Find(&decisions).Error
+	return decisions, err
+}
+
+func (r *ModerationRepository) CountDecisions(status string) (int64, error) {
+	var count int64
+	err := r.metaStore.DB.Model(&ModerationDecision{}).Where("status = ?", status).Count(&count).Error
+	return count, err
+}
+
+// ResolveDecision 只更新仍在等待审核的记录, 返回是否更新成功, 避免重复审核
+func (r *ModerationRepository) ResolveDecision(id uint, status string, reviewer string, note string) (bool, error) {
+	result := r.metaStore.DB.Model(&ModerationDecision{}).
+		Where("id = ? AND status = ?", id, ModerationStatusPending).
+		Updates(map[string]interface{}{
+			"status":      status,
+			"reviewer":    reviewer,
+			"review_note": note,
+			"reviewed_at": time.Now().Unix(),
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// AddLabels 写入标签, 已存在的同名标签忽略
+func (r *ModerationRepository) AddLabels(src string, uri string, vals []string) error {
+	if len(vals) == 0 {
+		return nil
+	}
+	now := time.Now().Unix()
+	labels := make([]*ContentLabel, len(vals))
+	for i, val := range vals {
+		labels[i] = &ContentLabel{Src: src, URI: uri, Val: val, CreatedAt: now}
+	}
+	return r.metaStore.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&labels).Error
+}
+
+func (r *ModerationRepository) RemoveLabels(src string, uri string, vals []string) error {
+	if len(vals) == 0 {
+		return nil
+	}
+	return r.metaStore.DB.Where("src = ? AND uri = ? AND val IN ?", src, uri, vals).Delete(&ContentLabel{}).Error
+}
+
+// GetLabelsByURIs 按内容返回全部来源的标签
+func (r *ModerationRepository) GetLabelsByURIs(uris []string) (map[string][]*ContentLabel, error) {
+	result := make(map[string][]*ContentLabel)
+	if len(uris) == 0 {
+		return result, nil
+	}
+	var labels []*ContentLabel
+	if err := r.metaStore.DB.Where("uri IN ?", uris).Order("id ASC").Find(&labels).Error; err != nil {
+		return nil, err
+	}
+	for _, label := range labels {
+		result[label.URI] = append(result[label.URI], label)
+	}
+	return result, nil
+}
+
+// AppendLabelEvents 写入签发的标签事件, 同一标签之前的事件标记为已被取代. 事件的 Seq 在写入后回填
+func (r *ModerationRepository) AppendLabelEvents(events []*LabelEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	return r.metaStore.DB.Transaction(func(tx *gorm.DB) error {
+		for _, event := range events {
+			if err := tx.Model(&LabelEvent{}).
+				Where("src = ? AND uri = ? AND val = ? AND superseded = ?", event.Src, event.URI, event.Val, false).
+				Update("superseded", true).Error; err != nil {
+				return err
+			}
+			if err := tx.Create(event).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListLabelEvents 按序号升序返回 afterSeq 之后的事件, 用于 subscribeLabels 回放
+func (r *ModerationRepository) ListLabelEvents(afterSeq int64, limit int) ([]*LabelEvent, error) {
+	var events []*LabelEvent
+	err := r.metaStore.DB.Where("seq > ?", afterSeq).Order("seq ASC").Limit(limit).Find(&events).Error
+	return events, err
+}
+
+func (r *ModerationRepository) LatestLabelSeq() (int64, error) {
+	var seq int64
+	err := r.metaStore.DB.Model(&LabelEvent{}).Select("COALESCE(MAX(seq), 0)").Scan(&seq).Error
+	return seq, err
+}
+
+// QueryLabelEvents 返回当前生效的标签. uriPatterns 以 * 结尾时按前缀匹配, sources 为空时不限来源
+func (r *ModerationRepository) QueryLabelEvents(uriPatterns []string, sources []string, afterSeq int64, limit int) ([]*LabelEvent, error) {
+	query := r.metaStore.DB.Where("superseded = ? AND neg = ? AND seq > ?", false, false, afterSeq)
+	if len(sources) > 0 {
+		query = query.Where("src IN ?", sources)
+	}
+	if len(uriPatterns) > 0 {
+		conditions := make([]string, 0, len(uriPatterns))
+		args := make([]interface{}, 0, len(uriPatterns))
+		for _, pattern := range uriPatterns {
+			if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+				conditions = append(conditions, "uri LIKE ? ESCAPE '!'")
+				args = append(args, likeEscaper.Replace(prefix)+"%")
+			} else {
+				conditions = append(conditions, "uri = ?")
+				args = append(args, pattern)
+			}
+		}
+		query = query.Where(strings.Join(conditions, " OR "), args...)
+	}
+	var events []*LabelEvent
+	err := query.Order("seq ASC").Limit(limit).Find(&events).Error
+	return events, err
+}
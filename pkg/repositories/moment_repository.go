@@ -1,6 +1,9 @@
 package repositories
 
-import "github.com/zhongshangwu/avatarai-social/pkg/atproto/helper"
+import (
+	"github.com/zhongshangwu/avatarai-social/pkg/atproto/helper"
+	"gorm.io/gorm"
+)
 
 type MomentRepository struct {
 	metaStore *MetaStore
@@ -32,13 +35,24 @@ func (r *MomentRepository) GetMomentByID(id string) (*Moment, error) {
 	return &moment, nil
 }
 
-func (r *MomentRepository) GetLatestMomentURIs(limit int, cursor string) ([]string, error) {
-	var moments []*Moment
-	query := r.metaStore.DB.Order("created_at DESC")
+// FeedKey moment 列表的分页位置, 列表按 (indexed_at, id) 倒序排列
+type FeedKey struct {
+	IndexedAt int64
+	ID        string
+}
 
-	if cursor != "" {
-		query = query.Where("created_at < ?", cursor)
+// beforeFeedKey 取排在 key 之后的 moment, key 为空时从头开始
+func beforeFeedKey(query *gorm.DB, key *FeedKey) *gorm.DB {
+	query = query.Order("moments.indexed_at DESC").Order("moments.id DESC")
+	if key == nil {
+		return query
 	}
+	return query.Where("moments.indexed_at < ? OR (moments.indexed_at = ? AND moments.id < ?)", key.IndexedAt, key.IndexedAt, key.ID)
+}
+
+func (r *MomentRepository) GetLatestMoments(limit int, before *FeedKey) ([]*Moment, error) {
+	var moments []*Moment
+	query := beforeFeedKey(r.metaStore.DB.Model(&Moment{}), before)
 
 	if limit > 0 {
 		query = query.Limit(limit)
@@ -47,21 +61,21 @@ func (r *MomentRepository) GetLatestMomentURIs(limit int, cursor string) ([]stri
 	if err := query.Find(&moments).Error; err != nil {
 		return nil, err
 	}
-
-	uris := make([]string, 0, len(moments))
-	for _, moment := range moments {
-		uris = append(uris, moment.URI)
-	}
-	return uris, nil
+	return moments, nil
 }
 
-func (r *MomentRepository) GetMomentsByCreator(creator string, limit int, cursor string) ([]*Moment, error) {
+// GetMomentsByCreators 返回一组作者的 moment, withReplies 为 false 时不包含回复
+func (r *MomentRepository) GetMomentsByCreators(creators []string, withReplies bool, limit int, before *FeedKey) ([]*Moment, error) {
 	var moments []*Moment
-	query := r.metaStore.DB.Where("creator = ?", creator).Order("created_at DESC")
+	if len(creators) == 0 {
+		return moments, nil
+	}
 
-	if cursor != "" {
-		query = query.Where("created_at < ?", cursor)
+	query := r.metaStore.DB.Model(&Moment{}).Where("moments.creator IN ?", creators)
+	if !withReplies {
+		query = query.Where("moments.reply_parent_id = ? OR moments.reply_parent_id IS NULL", "")
 	}
+	query = beforeFeedKey(query, before)
 
 	if limit > 0 {
 		query = query.Limit(limit)
@@ -77,6 +91,11 @@ func (r *MomentRepository) UpdateMoment(uri string, updates map[string]interface
 	return r.metaStore.DB.Model(&Moment{}).Where("uri = ?", uri).Updates(updates).Error
 }
 
+// SaveMoment 按主键写入或覆盖 moment, 用于索引其他 PDS 上的记录
+func (r *MomentRepository) SaveMoment(moment *Moment) error {
+	return r.metaStore.DB.Save(moment).Error
+}
+
 func (r *MomentRepository) DeleteMoment(uri string) error {
 	return r.metaStore.DB.Where("uri = ?", uri).Delete(&Moment{}).Error
 }
@@ -109,6 +128,16 @@ func (r *MomentRepository) DeleteMomentImages(momentURI string) error {
 	return r.metaStore.DB.Where("moment_uri = ?", momentURI).Delete(&MomentImage{}).Error
 }
 
+// DeleteMomentEmbeds 删除 moment 的图片、视频和外部链接
+func (r *MomentRepository) DeleteMomentEmbeds(momentID string) error {
+	for _, model := range []interface{}{&MomentImage{}, &MomentVideo{}, &MomentExternal{}} {
+		if err := r.metaStore.DB.Where("moment_id = ?", momentID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (r *MomentRepository) CreateMomentVideo(video *MomentVideo) error {
 	return r.metaStore.DB.Create(video).Error
 }
@@ -174,7 +203,7 @@ func (r *MomentRepository) GetMomentsByURIs(uris []string) ([]*Moment, error) {
 }
 
 func (r *MomentRepository) GetBlockedDIDs(viewerDID string) ([]string, error) {
-	return []string{}, nil
+	return r.metaStore.GraphRepo.GetBlockedDIDs(viewerDID)
 }
 
 func (r *MomentRepository) GenerateMomentID() string {
@@ -310,6 +339,30 @@ func (r *MomentRepository) CreateLike(like *Like) error {
 	return r.metaStore.DB.Create(like).Error
 }
 
+func (r *MomentRepository) GetLikeByURI(likeURI string) (*Like, error) {
+	var like Like
+	if err := r.metaStore.DB.Where("uri = ?", likeURI).First(&like).Error; err != nil {
+		return nil, err
+	}
+	return &like, nil
+}
+
+func (r *MomentRepository) GetLikeByID(id string) (*Like, error) {
+	var like Like
+	if err := r.metaStore.DB.Where("id = ?", id).First(&like).Error; err != nil {
+		return nil, err
+	}
+	return &like, nil
+}
+
+func (r *MomentRepository) UpdateLike(likeURI string, updates map[string]interface{}) error {
+	return r.metaStore.DB.Model(&Like{}).Where("uri = ?", likeURI).Updates(updates).Error
+}
+
+func (r *MomentRepository) SaveLike(like *Like) error {
+	return r.metaStore.DB.Save(like).Error
+}
+
 func (r *MomentRepository) DeleteLike(likeURI string) error {
 	return r.metaStore.DB.Where("uri = ?", likeURI).Delete(&Like{}).Error
 }
@@ -325,6 +378,26 @@ func (r *MomentRepository) GetTagByTag(tag string) (*Tag, error) {
 	return &result, nil
 }
 
+func (r *MomentRepository) GetTagByID(id string) (*Tag, error) {
+	var result Tag
+	if err := r.metaStore.DB.Where("id = ?", id).First(&result).Error; err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (r *MomentRepository) GetTagByURI(uri string) (*Tag, error) {
+	var result Tag
+	if err := r.metaStore.DB.Where("uri = ?", uri).First(&result).Error; err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (r *MomentRepository) UpdateTag(id string, updates map[string]interface{}) error {
+	return r.metaStore.DB.Model(&Tag{}).Where("id = ?", id).Updates(updates).Error
+}
+
 func (r *MomentRepository) DeleteTag(id string) error {
 	return r.metaStore.DB.Model(&Tag{}).Where("id = ?", id).Update("deleted", true).Error
 }
@@ -353,6 +426,10 @@ func (r *MomentRepository) DeleteActivityTag(tag string, subjectURI string) erro
 	return r.metaStore.DB.Model(&ActivityTag{}).Where("tag = ? AND subject_uri = ?", tag, subjectURI).Update("deleted", true).Error
 }
 
+func (r *MomentRepository) DeleteActivityTagsBySubjectURI(subjectURI string) error {
+	return r.metaStore.DB.Model(&ActivityTag{}).Where("subject_uri = ?", subjectURI).Update("deleted", true).Error
+}
+
 func (r *MomentRepository) GetTagActivityCounts(tags []string) (map[string]int, error) {
 	counts := make(map[string]int)
 	query := r.metaStore.DB.Model(&ActivityTag{}).Where("tag IN ? AND deleted = ?", tags, false).
@@ -384,6 +461,18 @@ func (r *MomentRepository) GetTopicByTopic(topic string) (*Topic, error) {
 	return &result, nil
 }
 
+func (r *MomentRepository) GetTopicByURI(uri string) (*Topic, error) {
+	var result Topic
+	if err := r.metaStore.DB.Where("uri = ?", uri).First(&result).Error; err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (r *MomentRepository) UpdateTopic(id string, updates map[string]interface{}) error {
+	return r.metaStore.DB.Model(&Topic{}).Where("id = ?", id).Updates(updates).Error
+}
+
 func (r *MomentRepository) DeleteTopic(id string) error {
 	return r.metaStore.DB.Model(&Topic{}).Where("id = ?", id).Update("deleted", true).Error
 }
@@ -412,6 +501,10 @@ func (r *MomentRepository) DeleteActivityTopic(topic string, subjectURI string)
 	return r.metaStore.DB.Model(&ActivityTopic{}).Where("topic = ? AND subject_uri = ?", topic, subjectURI).Update("deleted", true).Error
 }
 
+func (r *MomentRepository) DeleteActivityTopicsBySubjectURI(subjectURI string) error {
+	return r.metaStore.DB.Model(&ActivityTopic{}).Where("subject_uri = ?", subjectURI).Update("deleted", true).Error
+}
+
 func (r *MomentRepository) GetTopicActivityCounts(topics []string) (map[string]int, error) {
 	counts := make(map[string]int)
 	query := r.metaStore.DB.Model(&ActivityTopic{}).Where("topic IN ? AND deleted = ?", topics, false).
@@ -423,16 +516,31 @@ func (r *MomentRepository) GetTopicActivityCounts(topics []string) (map[string]i
 	return counts, nil
 }
 
-func (r *MomentRepository) GetMomentsByTag(tag string, limit int, cursor string) ([]*Moment, error) {
+func (r *MomentRepository) GetMomentsByTag(tag string, limit int, before *FeedKey) ([]*Moment, error) {
 	var moments []*Moment
-	query := r.metaStore.DB.
-		Joins("JOIN activity_tags ON moments.uri = activity_tags.uri").
-		Where("activity_tags.tag = ? AND activity_tags.deleted = ?", tag, false).
-		Order("moments.created_at DESC")
+	query := r.metaStore.DB.Model(&Moment{}).
+		Select("DISTINCT moments.*").
+		Joins("JOIN activity_tags ON moments.uri = activity_tags.subject_uri").
+		Where("activity_tags.tag = ? AND activity_tags.deleted = ?", tag, false)
+	query = beforeFeedKey(query, before)
+
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
 
-	if cursor != "" {
-		query = query.Where("moments.created_at < ?", cursor)
+	if err := query.Find(&moments).Error; err != nil {
+		return nil, err
 	}
+	return moments, nil
+}
+
+func (r *MomentRepository) GetMomentsByTopic(topic string, limit int, before *FeedKey) ([]*Moment, error) {
+	var moments []*Moment
+	query := r.metaStore.DB.Model(&Moment{}).
+		Select("DISTINCT moments.*").
+		Joins("JOIN activity_topics ON moments.uri = activity_topics.subject_uri").
+		Where("activity_topics.topic = ? AND activity_topics.deleted = ?", topic, false)
+	query = beforeFeedKey(query, before)
 
 	if limit > 0 {
 		query = query.Limit(limit)
@@ -444,16 +552,172 @@ func (r *MomentRepository) GetMomentsByTag(tag string, limit int, cursor string)
 	return moments, nil
 }
 
-func (r *MomentRepository) GetMomentsByTopic(topic string, limit int, cursor string) ([]*Moment, error) {
-	var moments []*Moment
-	query := r.metaStore.DB.
-		Joins("JOIN activity_topics ON moments.uri = activity_topics.uri").
-		Where("activity_topics.topic = ? AND activity_topics.deleted = ?", topic, false).
-		Order("moments.created_at DESC")
+// RefreshMomentAgg 重新统计 moment 的点赞数和回复数
+func (r *MomentRepository) RefreshMomentAgg(uri string) error {
+	moment, err := r.GetMomentByURI(uri)
+	if err != nil {
+		return err
+	}
+
+	var likeCount, replyCount int64
+	if err := r.metaStore.DB.Model(&Like{}).Where("subject_uri = ?", uri).Count(&likeCount).Error; err != nil {
+		return err
+	}
+	if err := r.metaStore.DB.Model(&Moment{}).Where("reply_parent_id = ?", moment.ID).Count(&replyCount).Error; err != nil {
+		return err
+	}
+	return r.metaStore.DB.Save(&MomentAgg{URI: uri, LikeCount: int(likeCount), ReplyCount: int(replyCount)}).Error
+}
 
-	if cursor != "" {
-		query = query.Where("moments.created_at < ?", cursor)
+func (r *MomentRepository) GetMomentAggs(uris []string) (map[string]*MomentAgg, error) {
+	ret := make(map[string]*MomentAgg, len(uris))
+	if len(uris) == 0 {
+		return ret, nil
+	}
+	var aggs []*MomentAgg
+	if err := r.metaStore.DB.Where("uri IN ?", uris).Find(&aggs).Error; err != nil {
+		return nil, err
+	}
+	for _, agg := range aggs {
+		ret[agg.URI] = agg
+	}
+	return ret, nil
+}
+
+// CountMomentEngagement 按 until 时刻 (含) 之前创建的点赞和回复统计 moment 的互动数,
+// 与 moment_agg 不同, 结果不受之后的互动影响
+func (r *MomentRepository) CountMomentEngagement(uris []string, until int64) (map[string]*MomentAgg, error) {
+	ret := make(map[string]*MomentAgg, len(uris))
+	if len(uris) == 0 {
+		return ret, nil
+	}
+
+	var likes []*LabelCount
+	if err := r.metaStore.DB.Model(&Like{}).
+		Select("subject_uri AS label, COUNT(*) AS count").
+		Where("subject_uri IN ? AND created_at <= ?", uris, until).
+		Group("subject_uri").
+		Scan(&likes).Error; err != nil {
+		return nil, err
+	}
+	var replies []*LabelCount
+	if err := r.metaStore.DB.Table("moments AS replies").
+		Select("parents.uri AS label, COUNT(*) AS count").
+		Joins("JOIN moments AS parents ON parents.id = replies.reply_parent_id").
+		Where("parents.uri IN ? AND replies.created_at <= ?", uris, until).
+		Group("parents.uri").
+		Scan(&replies).Error; err != nil {
+		return nil, err
+	}
+
+	agg := func(uri string) *MomentAgg {
+		if ret[uri] == nil {
+			ret[uri] = &MomentAgg{URI: uri}
+		}
+		return ret[uri]
+	}
+	for _, row := range likes {
+		agg(row.Label).LikeCount = int(row.Count)
 	}
+	for _, row := range replies {
+		agg(row.Label).ReplyCount = int(row.Count)
+	}
+	return ret, nil
+}
+
+// AuthorInteraction viewer 与某个作者之间的互动次数
+type AuthorInteraction struct {
+	Did   string `gorm:"column:did"`
+	Count int64  `gorm:"column:count"`
+}
+
+// GetLikedAuthors 统计 viewer 在 until 时刻 (含) 之前点赞过的各作者的 moment 数
+func (r *MomentRepository) GetLikedAuthors(viewer string, until int64) ([]*AuthorInteraction, error) {
+	var rows []*AuthorInteraction
+	err := r.metaStore.DB.Model(&Like{}).
+		Select("moments.creator AS did, COUNT(*) AS count").
+		Joins("JOIN moments ON moments.uri = likes.subject_uri").
+		Where("likes.creator = ? AND moments.creator <> ? AND likes.created_at <= ?", viewer, viewer, until).
+		Group("moments.creator").
+		Scan(&rows).Error
+	return rows, err
+}
+
+// GetRepliedAuthors 统计 viewer 在 until 时刻 (含) 之前回复过的各作者的 moment 数
+func (r *MomentRepository) GetRepliedAuthors(viewer string, until int64) ([]*AuthorInteraction, error) {
+	var rows []*AuthorInteraction
+	err := r.metaStore.DB.Table("moments AS replies").
+		Select("parents.creator AS did, COUNT(*) AS count").
+		Joins("JOIN moments AS parents ON parents.id = replies.reply_parent_id").
+		Where("replies.creator = ? AND parents.creator <> ? AND replies.created_at <= ?", viewer, viewer, until).
+		Group("parents.creator").
+		Scan(&rows).Error
+	return rows, err
+}
+
+// GetEngagedMomentURIs 返回 viewer 在 [since, until] 时间窗内点赞或回复过的 moment
+func (r *MomentRepository) GetEngagedMomentURIs(viewer string, since int64, until int64) ([]string, error) {
+	var liked []string
+	if err := r.metaStore.DB.Model(&Like{}).
+		Where("creator = ? AND created_at >= ? AND created_at <= ?", viewer, since, until).
+		Pluck("subject_uri", &liked).Error; err != nil {
+		return nil, err
+	}
+
+	var replied []string
+	if err := r.metaStore.DB.Table("moments AS replies").
+		Joins("JOIN moments AS parents ON parents.id = replies.reply_parent_id").
+		Where("replies.creator = ? AND replies.created_at >= ? AND replies.created_at <= ?", viewer, since, until).
+		Pluck("parents.uri", &replied).Error; err != nil {
+		return nil, err
+	}
+	return append(liked, replied...), nil
+}
+
+// LabelCount 标签或主题出现的次数
+type LabelCount struct {
+	Label string `gorm:"column:label"`
+	Count int64  `gorm:"column:count"`
+}
+
+// GetViewerInterests 统计 viewer 在 until 时刻 (含) 之前发布和点赞过的 moment 上的标签与主题
+func (r *MomentRepository) GetViewerInterests(viewer string, until int64) (tags []*LabelCount, topics []*LabelCount, err error) {
+	subjects := r.metaStore.DB.Raw(
+		"SELECT uri FROM moments WHERE creator = ? AND created_at <= ? UNION SELECT subject_uri FROM likes WHERE creator = ? AND created_at <= ?",
+		viewer, until, viewer, until,
+	)
+
+	err = r.metaStore.DB.Model(&ActivityTag{}).
+		Select("tag AS label, COUNT(*) AS count").
+		Where("deleted = ? AND subject_uri IN (?) AND created_at <= ?", false, subjects, until).
+		Group("tag").
+		Scan(&tags).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = r.metaStore.DB.Model(&ActivityTopic{}).
+		Select("topic AS label, COUNT(*) AS count").
+		Where("deleted = ? AND subject_uri IN (?) AND created_at <= ?", false, subjects, until).
+		Group("topic").
+		Scan(&topics).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	return tags, topics, nil
+}
+
+// GetRankingCandidates 返回 [since, until] 时间窗内索引的 moment, 按索引时间倒序
+func (r *MomentRepository) GetRankingCandidates(creators []string, since int64, until int64, limit int) ([]*Moment, error) {
+	var moments []*Moment
+	query := r.metaStore.DB.Where("indexed_at >= ? AND indexed_at <= ?", since, until)
+	if creators != nil {
+		if len(creators) == 0 {
+			return moments, nil
+		}
+		query = query.Where("creator IN ?", creators)
+	}
+	query = query.Order("indexed_at DESC").Order("id DESC")
 
 	if limit > 0 {
 		query = query.Limit(limit)
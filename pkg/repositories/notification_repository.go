@@ -0,0 +1,67 @@
+package repositories
+
+import (
+	"gorm.io/gorm/clause"
+)
+
+type NotificationRepository struct {
+	metaStore *MetaStore
+}
+
+func NewNotificationRepository(metaStore *MetaStore) *NotificationRepository {
+	return &NotificationRepository{metaStore: metaStore}
+}
+
+// CreateNotification 写入通知, 同一记录对同一用户的同类通知已存在时忽略, 返回是否新写入
+func (r *NotificationRepository) CreateNotification(notification *Notification) (bool, error) {
+	result := r.metaStore.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(notification)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// ListNotifications 按 ID 倒序返回通知, beforeID 为 0 时从最新一条开始
+func (r *NotificationRepository) ListNotifications(recipient string, unreadOnly bool, limit int, beforeID uint) ([]*Notification, error) {
+	var notifications []*Notification
+	query := r.metaStore.DB.Where("recipient = ?", recipient).Order("id DESC")
+
+	if unreadOnly {
+		query = query.Where("is_read = ?", false)
+	}
+
+	if beforeID > 0 {
+		query = query.Where("id < ?", beforeID)
+	}
+
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	if err := query.Find(&notifications).Error; err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+func (r *NotificationRepository) CountUnread(recipient string) (int64, error) {
+	var count int64
+	err := r.metaStore.DB.Model(&Notification{}).
+		Where("recipient = ? AND is_read = ?", recipient, false).
+		Count(&count).Error
+	return count, err
+}
+
+// MarkRead 将 recipient 的通知标记为已读, ids 为空时标记全部
+func (r *NotificationRepository) MarkRead(recipient string, ids []uint) error {
+	query := r.metaStore.DB.Model(&Notification{}).Where("recipient = ? AND is_read = ?", recipient, false)
+	if len(ids) > 0 {
+		query = query.Where("id IN ?", ids)
+	}
+	return query.Update("is_read", true).Error
+}
+
+// DeleteNotificationsByURI 记录被删除后撤回由它产生的通知; moment 被删除时同时撤回对它的点赞和回复通知
+func (r *NotificationRepository) DeleteNotificationsByURI(uri string) error {
+	return r.metaStore.DB.Where("record_uri = ? OR reason_subject = ?", uri, uri).Delete(&Notification{}).Error
+}
@@ -0,0 +1,116 @@
+package repositories
+
+import (
+	"time"
+)
+
+type OutboxRepository struct {
+	metaStore *MetaStore
+}
+
+func NewOutboxRepository(metaStore *MetaStore) *OutboxRepository {
+	return &OutboxRepository{
+		metaStore: metaStore,
+	}
+}
+
+// OutboxCollectionStat 按集合和状态聚合的队列条数
+type OutboxCollectionStat struct {
+	Collection string `gorm:"column:collection" json:"collection"`
+	Status     string `gorm:"column:status" json:"status"`
+	Count      int64  `gorm:"column:count" json:"count"`
+}
+
+// EnqueueRecordOp 写入一条待同步的记录操作
+func (r *OutboxRepository) EnqueueRecordOp(did string, collection string, rkey string, action string) error {
+	now := time.Now().UnixMilli()
+	return r.metaStore.DB.Create(&PDSOutboxOp{
+		Did:           did,
+		Collection:    collection,
+		Rkey:          rkey,
+		Action:        action,
+		Status:        OutboxStatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}).Error
+}
+
+// ListDueOutboxOps 按 ID 顺序返回已到投递时间的待同步操作
+func (r *OutboxRepository) ListDueOutboxOps(now int64, limit int) ([]*PDSOutboxOp, error) {
+	var ops []*PDSOutboxOp
+	if err := r.metaStore.DB.Where("status = ? AND next_attempt_at <= ?", OutboxStatusPending, now).
+		Order("id ASC").
+		Limit(limit).
+		Find(&ops).Error; err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+// GetWaitingOutboxHeads 每个用户最早一条仍在等待重试的操作 ID, 该用户更晚的操作需要排在它之后
+func (r *OutboxRepository) GetWaitingOutboxHeads(now int64) (map[string]uint, error) {
+	var rows []struct {
+		Did string `gorm:"column:did"`
+		ID  uint   `gorm:"column:id"`
+	}
+	if err := r.metaStore.DB.Model(&PDSOutboxOp{}).
+		Select("did, MIN(id) AS id").
+		Where("status = ? AND next_attempt_at > ?", OutboxStatusPending, now).
+		Group("did").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	heads := make(map[string]uint, len(rows))
+	for _, row := range rows {
+		heads[row.Did] = row.ID
+	}
+	return heads, nil
+}
+
+func (r *OutboxRepository) MarkOutboxOpDone(id uint, uri string, cid string) error {
+	return r.metaStore.DB.Model(&PDSOutboxOp{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":     OutboxStatusDone,
+		"uri":        uri,
+		"cid":        cid,
+		"last_error": "",
+		"updated_at": time.Now().UnixMilli(),
+	}).Error
+}
+
+// MarkOutboxOpRetry 记录失败并安排下一次投递时间
+func (r *OutboxRepository) MarkOutboxOpRetry(id uint, attempts int, nextAttemptAt int64, lastError string) error {
+	return r.metaStore.DB.Model(&PDSOutboxOp{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"attempts":        attempts,
+		"next_attempt_at": nextAttemptAt,
+		"last_error":      lastError,
+		"updated_at":      time.Now().UnixMilli(),
+	}).Error
+}
+
+func (r *OutboxRepository) MarkOutboxOpFailed(id uint, attempts int, lastError string) error {
+	return r.metaStore.DB.Model(&PDSOutboxOp{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":     OutboxStatusFailed,
+		"attempts":   attempts,
+		"last_error": lastError,
+		"updated_at": time.Now().UnixMilli(),
+	}).Error
+}
+
+// CountOutboxOpsByCollection 按集合和状态统计队列
+func (r *OutboxRepository) CountOutboxOpsByCollection() ([]*OutboxCollectionStat, error) {
+	var stats []*OutboxCollectionStat
+	if err := r.metaStore.DB.Model(&PDSOutboxOp{}).
+		Select("collection, status, COUNT(*) AS count").
+		Group("collection, status").
+		Scan(&stats).Error; err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// PurgeDoneOutboxOps 清理早于 before 完成的操作
+func (r *OutboxRepository) PurgeDoneOutboxOps(before int64) (int64, error) {
+	result := r.metaStore.DB.Where("status = ? AND updated_at < ?", OutboxStatusDone, before).Delete(&PDSOutboxOp{})
+	return result.RowsAffected, result.Error
+}
@@ -27,6 +27,20 @@ const (
 	TokenTypeAPIKey = "api_key" // API Key
 )
 
+// PDS 同步队列的操作类型
+const (
+	OutboxActionCreate = "create"
+	OutboxActionUpdate = "update"
+	OutboxActionDelete = "delete"
+)
+
+// PDS 同步队列的状态
+const (
+	OutboxStatusPending = "pending" // 等待投递或等待重试
+	OutboxStatusDone    = "done"    // 已写入 PDS
+	OutboxStatusFailed  = "failed"  // 超过最大重试次数或不可重试的错误
+)
+
 // PKCE Challenge方法常量
 const (
 	PKCEMethodPlain = "plain" // plain方法
@@ -195,8 +209,9 @@ func (Like) TableName() string {
 	return "likes"
 }
 
+// MomentAgg moment 的互动计数, 点赞和回复变化时重新统计
 type MomentAgg struct {
-	URI        string `gorm:"column:uri"`
+	URI        string `gorm:"column:uri;primaryKey"`
 	LikeCount  int    `gorm:"column:like_count"`
 	ReplyCount int    `gorm:"column:reply_count"`
 }
@@ -259,6 +274,112 @@ func (ActivityTopic) TableName() string {
 	return "activity_topics"
 }
 
+const (
+	RelationshipFollow = "follow"
+	RelationshipBlock  = "block"
+	RelationshipMute   = "mute" // 静音只保存在本地, 不写入 PDS
+)
+
+// Relationship 用户之间的关注/屏蔽关系, 对应 app.vtri.activity.relationship 记录
+type Relationship struct {
+	ID        string `gorm:"primaryKey"` // 记录 rkey
+	URI       string `gorm:"column:uri;index"`
+	CID       string `gorm:"column:cid"`
+	Creator   string `gorm:"column:creator;index:idx_relationships_creator"`                                  // 发起方 DID, 即记录的 subject
+	Predicate string `gorm:"column:predicate;index:idx_relationships_creator;index:idx_relationships_object"` // follow, block
+	Object    string `gorm:"column:object;index:idx_relationships_object"`                                    // 目标 DID
+	CreatedAt int64  `gorm:"column:created_at"`
+	IndexedAt int64  `gorm:"column:indexed_at"`
+}
+
+func (Relationship) TableName() string {
+	return "relationships"
+}
+
+const (
+	NotificationReasonLike    = "like"
+	NotificationReasonReply   = "reply"
+	NotificationReasonMention = "mention"
+	NotificationReasonFollow  = "follow"
+)
+
+// Notification 发给 recipient 的互动通知. 同一条记录对同一用户的同类通知只保留一条,
+// 本地写入的记录经同步后再从 firehose 索引回来时不会重复通知
+type Notification struct {
+	ID            uint   `gorm:"primaryKey;autoIncrement:true"`
+	Recipient     string `gorm:"column:recipient;index:idx_notifications_recipient;uniqueIndex:idx_notifications_record"`
+	Author        string `gorm:"column:author"`
+	Reason        string `gorm:"column:reason;uniqueIndex:idx_notifications_record"` // like, reply, mention, follow
+	ReasonSubject string `gorm:"column:reason_subject"`                              // 被点赞/回复的 moment URI, follow 为空
+	RecordURI     string `gorm:"column:record_uri;uniqueIndex:idx_notifications_record"`
+	IsRead        bool   `gorm:"column:is_read;index:idx_notifications_recipient"`
+	CreatedAt     int64  `gorm:"column:created_at"`
+}
+
+func (Notification) TableName() string {
+	return "notifications"
+}
+
+const (
+	ModerationStatusAuto     = "auto"     // 自动执行的结论 (label, reject), 不需要人工审核
+	ModerationStatusPending  = "pending"  // 等待人工审核
+	ModerationStatusApproved = "approved" // 人工审核通过
+	ModerationStatusRejected = "rejected" // 人工审核拒绝
+)
+
+// ModerationDecision 一次内容审核的结论, 只记录非 allow 的结论
+type ModerationDecision struct {
+	ID          uint        `gorm:"primaryKey;autoIncrement:true"`
+	SubjectType string      `gorm:"column:subject_type"`  // moment, message
+	Subject     string      `gorm:"column:subject;index"` // moment URI 或消息 ID
+	Author      string      `gorm:"column:author;index"`
+	Text        string      `gorm:"column:text"` // 审核时的正文, 便于审核员查看被拒绝或已删除的内容
+	Verdict     string      `gorm:"column:verdict"`
+	Labels      StringArray `gorm:"type:jsonb;column:labels"`
+	Findings    string      `gorm:"column:findings"` // 各检查器的命中明细, JSON
+	Status      string      `gorm:"column:status;index"`
+	Reviewer    string      `gorm:"column:reviewer"`
+	ReviewNote  string      `gorm:"column:review_note"`
+	ReviewedAt  int64       `gorm:"column:reviewed_at"`
+	CreatedAt   int64       `gorm:"column:created_at"`
+}
+
+func (ModerationDecision) TableName() string {
+	return "moderation_decisions"
+}
+
+// ContentLabel 作用在内容上的标签, 同一来源对同一内容的同名标签只保留一条. Src 为空表示本服务的审核结论
+type ContentLabel struct {
+	ID        uint   `gorm:"primaryKey;autoIncrement:true"`
+	Src       string `gorm:"column:src;uniqueIndex:idx_content_labels_label"`
+	URI       string `gorm:"column:uri;uniqueIndex:idx_content_labels_label;index"`
+	Val       string `gorm:"column:val;uniqueIndex:idx_content_labels_label"`
+	CreatedAt int64  `gorm:"column:created_at"`
+}
+
+func (ContentLabel) TableName() string {
+	return "content_labels"
+}
+
+// LabelEvent 本服务作为 labeler 签发的标签, Seq 即 subscribeLabels 的序号.
+// 同一 (src, uri, val) 的新事件写入后, 之前的事件标记为 Superseded, queryLabels 只返回最新且未撤销的标签
+type LabelEvent struct {
+	Seq        int64  `gorm:"primaryKey;autoIncrement:true;column:seq"`
+	Src        string `gorm:"column:src;index:idx_label_events_label"`
+	URI        string `gorm:"column:uri;index:idx_label_events_label"`
+	Val        string `gorm:"column:val;index:idx_label_events_label"`
+	CID        string `gorm:"column:cid"`
+	Neg        bool   `gorm:"column:neg"`
+	Cts        string `gorm:"column:cts"` // 签名内容中的创建时间, RFC3339
+	Sig        []byte `gorm:"column:sig"`
+	Superseded bool   `gorm:"column:superseded"`
+	CreatedAt  int64  `gorm:"column:created_at"`
+}
+
+func (LabelEvent) TableName() string {
+	return "label_events"
+}
+
 type Message struct {
 	ID         string `gorm:"primaryKey"`
 	ExternalID string `gorm:"column:external_id"`
@@ -282,10 +403,12 @@ func (Message) TableName() string {
 type Room struct {
 	ID        string `gorm:"primaryKey"`
 	Title     string `gorm:"column:title"`
-	Type      string `gorm:"column:type"`
+	Type      string `gorm:"column:type"` // direct, group, ai_chat
 	LastMID   string `gorm:"column:last_mid"`
+	CreatorID string `gorm:"column:creator_id"`
+	Archived  bool   `gorm:"column:archived"`
 	CreatedAt int64  `gorm:"column:created_at"`
-	UpdatedAt int64  `gorm:"column:updated_at"`
+	UpdatedAt int64  `gorm:"column:updated_at;index:idx_rooms_updated_at"`
 	Deleted   bool   `gorm:"column:deleted"`
 }
 
@@ -295,11 +418,14 @@ func (Room) TableName() string {
 
 type UserRoomStatus struct {
 	ID          string `gorm:"primaryKey"`
-	RoomID      string `gorm:"column:room_id"`
+	RoomID      string `gorm:"column:room_id;uniqueIndex:idx_user_room_status_member"`
 	UnreadCount int32  `gorm:"column:unread_count"`
 	Muted       bool   `gorm:"column:muted"`
-	UserID      string `gorm:"column:user_id"`
-	Status      string `gorm:"column:status"` // request, accepted
+	UserID      string `gorm:"column:user_id;uniqueIndex:idx_user_room_status_member"`
+	Status      string `gorm:"column:status"` // request, accepted, left
+	Role        string `gorm:"column:role"`   // owner, member
+	InviterID   string `gorm:"column:inviter_id"`
+	PDSMirror   bool   `gorm:"column:pds_mirror"` // 将房间和自己的消息写入 PDS 的 app.vtri.chat.* 记录
 	CreatedAt   int64  `gorm:"column:created_at"`
 	UpdatedAt   int64  `gorm:"column:updated_at"`
 	Deleted     bool   `gorm:"column:deleted"`
@@ -311,8 +437,9 @@ func (UserRoomStatus) TableName() string {
 
 type Thread struct {
 	ID             string `gorm:"primaryKey"`
-	RoomID         string `gorm:"column:room_id"`
+	RoomID         string `gorm:"column:room_id;index:idx_threads_room"`
 	Title          string `gorm:"column:title"`
+	CreatorID      string `gorm:"column:creator_id"`
 	ContextMode    string `gorm:"column:context_mode"` // continuous, isolated
 	RootMID        string `gorm:"column:root_mid"`
 	ParentThreadID string `gorm:"column:parent_thread_id"`
@@ -361,6 +488,44 @@ func (AgentMessageItem) TableName() string {
 	return "agent_message_items"
 }
 
+// MemoryVector 语义记忆的向量, Vector 为小端序 float32 数组
+type MemoryVector struct {
+	ID         string `gorm:"primaryKey"`
+	UserDid    string `gorm:"column:user_did;index"`
+	NodeID     int    `gorm:"column:node_id"` // 在用户 HNSW 索引中的节点 ID
+	RoomID     string `gorm:"column:room_id"`
+	ThreadID   string `gorm:"column:thread_id"`
+	MessageID  string `gorm:"column:message_id;index"`
+	ChunkType  string `gorm:"column:chunk_type"`
+	Text       string `gorm:"column:text"`
+	Model      string `gorm:"column:model"`
+	Dimensions int    `gorm:"column:dimensions"`
+	Vector     []byte `gorm:"column:vector"`
+	CreatedAt  int64  `gorm:"column:created_at"`
+	Deleted    bool   `gorm:"column:deleted"`
+}
+
+func (MemoryVector) TableName() string {
+	return "memory_vectors"
+}
+
+// ThreadSummary 话题的滚动摘要, 覆盖 (created_at, id) 不晚于 (CoveredUntil, LastMessageID) 的消息, Content 为 JSON
+type ThreadSummary struct {
+	ID            string `gorm:"primaryKey"`
+	RoomID        string `gorm:"column:room_id;index:idx_thread_summaries_thread"`
+	ThreadID      string `gorm:"column:thread_id;index:idx_thread_summaries_thread"`
+	Content       string `gorm:"column:content"`
+	LastMessageID string `gorm:"column:last_message_id"` // 最后一条被摘要的消息
+	CoveredUntil  int64  `gorm:"column:covered_until"`   // 最后一条被摘要消息的 created_at
+	MessageCount  int    `gorm:"column:message_count"`   // 累计被摘要的消息数
+	Model         string `gorm:"column:model"`
+	CreatedAt     int64  `gorm:"column:created_at"`
+}
+
+func (ThreadSummary) TableName() string {
+	return "thread_summaries"
+}
+
 type UploadFile struct {
 	ID        string `gorm:"primaryKey"`
 	CID       string `gorm:"column:cid"`
@@ -413,6 +578,27 @@ type AvatarIntegrate struct { // 这个主要是真实用户关联的第三方
 	UpdatedAt  time.Time `gorm:"column:updated_at"`
 }
 
+// PDSOutboxOp 待写入用户 PDS 的记录操作, 由 pds/syncers 按 ID 顺序异步投递
+type PDSOutboxOp struct {
+	ID            uint   `gorm:"primaryKey;autoIncrement:true"`
+	Did           string `gorm:"column:did;index"`
+	Collection    string `gorm:"column:collection;index"`
+	Rkey          string `gorm:"column:rkey"`
+	Action        string `gorm:"column:action"`                          // create, update, delete
+	Status        string `gorm:"column:status;index:idx_pds_outbox_due"` // pending, done, failed
+	Attempts      int    `gorm:"column:attempts"`
+	NextAttemptAt int64  `gorm:"column:next_attempt_at;index:idx_pds_outbox_due"`
+	LastError     string `gorm:"column:last_error"`
+	URI           string `gorm:"column:uri"` // 写入成功后 PDS 返回的 uri
+	CID           string `gorm:"column:cid"` // 写入成功后 PDS 返回的 cid
+	CreatedAt     int64  `gorm:"column:created_at"`
+	UpdatedAt     int64  `gorm:"column:updated_at"`
+}
+
+func (PDSOutboxOp) TableName() string {
+	return "pds_outbox"
+}
+
 type AtpRecord struct {
 	URI       string `gorm:"column:uri"`
 	CID       string `gorm:"column:cid"`
@@ -425,6 +611,17 @@ func (AtpRecord) TableName() string {
 	return "atp_records"
 }
 
+// IngestCursor 订阅源的消费进度, 重连时从这里继续
+type IngestCursor struct {
+	Source    string `gorm:"primaryKey;column:source"` // 订阅模式 + 地址
+	Cursor    int64  `gorm:"column:cursor"`            // subscribeRepos 为 seq, Jetstream 为 time_us
+	UpdatedAt int64  `gorm:"column:updated_at"`
+}
+
+func (IngestCursor) TableName() string {
+	return "ingest_cursors"
+}
+
 type MCPServer struct {
 	ID                  uint   `gorm:"primaryKey;autoIncrement:true"`
 	McpID               string `gorm:"column:mcp_id;uniqueIndex:idx_user_mcp"`   // MCP服务器唯一标识
@@ -99,6 +99,13 @@ func (r *UserRepository) UpdateAvatar(did string, updates map[string]interface{}
 	return r.metaStore.DB.Model(&Avatar{}).Where("did = ?", did).Updates(updates).Error
 }
 
+// ListAvatars 按 ID 分批读取用户, 用于重建全文检索索引
+func (r *UserRepository) ListAvatars(afterID uint, limit int) ([]*Avatar, error) {
+	var avatars []*Avatar
+	err := r.metaStore.DB.Where("id > ?", afterID).Order("id ASC").Limit(limit).Find(&avatars).Error
+	return avatars, err
+}
+
 // Session 相关操作
 func (r *UserRepository) SaveSession(session *Session) error {
 	return r.metaStore.DB.Create(session).Error
@@ -0,0 +1,99 @@
+package search
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+const (
+	KindMoment  = "moment"
+	KindUser    = "user"
+	KindTag     = "tag"
+	KindTopic   = "topic"
+	KindMessage = "message"
+)
+
+var (
+	ErrUnsupported = errors.New("当前数据库不支持全文检索")
+	ErrUnavailable = errors.New("全文检索不可用")
+)
+
+// Document 一条可检索的内容, 以 (Kind, Key) 唯一标识
+type Document struct {
+	Kind      string
+	Key       string // moment URI, 用户 DID, 标签, 主题, 消息 ID
+	Scope     string // 可见范围, 消息为所在房间 ID, 其余为空
+	Owner     string // 作者 DID, 检索时用于过滤屏蔽的用户
+	Text      string // 原文, 分词后写入索引, 同时保存用于生成高亮摘要
+	CreatedAt int64
+}
+
+type Query struct {
+	Kind          string
+	Text          string
+	Scopes        []string // 非空时只检索这些范围内的文档
+	ExcludeOwners []string
+	Since         int64 // 非零时只检索 CreatedAt >= Since 的文档
+	Until         int64 // 非零时只检索 CreatedAt < Until 的文档
+	Limit         int
+	Offset        int
+}
+
+type Hit struct {
+	Kind      string
+	Key       string
+	Scope     string
+	Owner     string
+	Text      string
+	Score     float64 // 越大越相关, 不同后端之间不可比较
+	CreatedAt int64
+}
+
+// Index 全文索引后端. 写入是幂等的, 同一 (Kind, Key) 重复写入会覆盖旧文档
+type Index interface {
+	Name() string
+	Upsert(ctx context.Context, docs ...*Document) error
+	Delete(ctx context.Context, kind string, key string) error
+	Search(ctx context.Context, query *Query) ([]*Hit, error)
+	Count(ctx context.Context) (int64, error)
+}
+
+// NewIndex 按数据库类型选择索引后端: SQLite 使用 FTS5 (未编译 FTS5 时退回 FTS4), Postgres 使用 tsvector
+func NewIndex(db *gorm.DB) (Index, error) {
+	switch db.Dialector.Name() {
+	case "sqlite":
+		return newSQLiteIndex(db)
+	case "postgres":
+		return newPostgresIndex(db)
+	default:
+		return nil, ErrUnsupported
+	}
+}
+
+// DisabledIndex 不支持全文检索时使用, 写入被忽略, 检索返回 ErrUnavailable
+type DisabledIndex struct{}
+
+func (DisabledIndex) Name() string { return "disabled" }
+
+func (DisabledIndex) Upsert(ctx context.Context, docs ...*Document) error { return nil }
+
+func (DisabledIndex) Delete(ctx context.Context, kind string, key string) error { return nil }
+
+func (DisabledIndex) Search(ctx context.Context, query *Query) ([]*Hit, error) {
+	return nil, ErrUnavailable
+}
+
+func (DisabledIndex) Count(ctx context.Context) (int64, error) { return 0, nil }
+
+// matchTokens 查询分词, 最后一个拉丁词按前缀匹配, 便于输入过程中检索用户名
+func matchTokens(text string) ([]string, bool) {
+	tokens := QueryTokens(text)
+	if len(tokens) == 0 {
+		return nil, false
+	}
+	last := []rune(tokens[len(tokens)-1])
+	prefix := len(last) >= 2 && !isCJK(last[0])
+	return tokens, prefix
+}
@@ -0,0 +1,112 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// PostgresIndex 基于 tsvector 和 GIN 索引. 分词在写入前完成, 因此使用 simple 配置, 不做词干化
+type PostgresIndex struct {
+	db *gorm.DB
+}
+
+func newPostgresIndex(db *gorm.DB) (*PostgresIndex, error) {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS search_documents (
+			kind TEXT NOT NULL,
+			key TEXT NOT NULL,
+			scope TEXT NOT NULL DEFAULT '',
+			owner TEXT NOT NULL DEFAULT '',
+			created_at BIGINT NOT NULL DEFAULT 0,
+			text TEXT NOT NULL DEFAULT '',
+			tokens TSVECTOR NOT NULL,
+			PRIMARY KEY (kind, key)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_search_documents_tokens ON search_documents USING GIN (tokens)`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return nil, fmt.Errorf("创建 tsvector 索引失败: %w", err)
+		}
+	}
+	return &PostgresIndex{db: db}, nil
+}
+
+func (p *PostgresIndex) Name() string {
+	return "postgres-tsvector"
+}
+
+func (p *PostgresIndex) Upsert(ctx context.Context, docs ...*Document) error {
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, doc := range docs {
+			err := tx.Exec(`INSERT INTO search_documents (kind, key, scope, owner, created_at, text, tokens)
+				VALUES (?, ?, ?, ?, ?, ?, to_tsvector('simple', ?))
+				ON CONFLICT (kind, key) DO UPDATE SET
+					scope = EXCLUDED.scope, owner = EXCLUDED.owner, created_at = EXCLUDED.created_at,
+					text = EXCLUDED.text, tokens = EXCLUDED.tokens`,
+				doc.Kind, doc.Key, doc.Scope, doc.Owner, doc.CreatedAt, doc.Text, joinTokens(IndexTokens(doc.Text))).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *PostgresIndex) Delete(ctx context.Context, kind string, key string) error {
+	return p.db.WithContext(ctx).Exec("DELETE FROM search_documents WHERE kind = ? AND key = ?", kind, key).Error
+}
+
+func (p *PostgresIndex) Search(ctx context.Context, query *Query) ([]*Hit, error) {
+	tokens, prefix := matchTokens(query.Text)
+	if len(tokens) == 0 {
+		return []*Hit{}, nil
+	}
+	// 分词结果只含字母、数字和中日韩文字, 不会与 tsquery 的运算符冲突
+	match := strings.Join(tokens, " & ")
+	if prefix {
+		match += ":*"
+	}
+
+	sql := `SELECT kind, key, scope, owner, created_at, text, ts_rank(tokens, q) AS score
+		FROM search_documents, to_tsquery('simple', ?) AS q
+		WHERE tokens @@ q`
+	args := []interface{}{match}
+	if query.Kind != "" {
+		sql += " AND kind = ?"
+		args = append(args, query.Kind)
+	}
+	if len(query.Scopes) > 0 {
+		sql += " AND scope IN ?"
+		args = append(args, query.Scopes)
+	}
+	if len(query.ExcludeOwners) > 0 {
+		sql += " AND owner NOT IN ?"
+		args = append(args, query.ExcludeOwners)
+	}
+	if query.Since > 0 {
+		sql += " AND created_at >= ?"
+		args = append(args, query.Since)
+	}
+	if query.Until > 0 {
+		sql += " AND created_at < ?"
+		args = append(args, query.Until)
+	}
+	sql += " ORDER BY score DESC, created_at DESC LIMIT ? OFFSET ?"
+	args = append(args, query.Limit, query.Offset)
+
+	var hits []*Hit
+	if err := p.db.WithContext(ctx).Raw(sql, args...).Scan(&hits).Error; err != nil {
+		return nil, err
+	}
+	return hits, nil
+}
+
+func (p *PostgresIndex) Count(ctx context.Context) (int64, error) {
+	var count int64
+	err := p.db.WithContext(ctx).Raw("SELECT COUNT(*) FROM search_documents").Scan(&count).Error
+	return count, err
+}
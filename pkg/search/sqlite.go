@@ -0,0 +1,119 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// SQLiteIndex 基于 SQLite 全文检索虚拟表. mattn/go-sqlite3 需要 sqlite_fts5 编译标签才包含 FTS5,
+// 否则使用默认编译的 FTS4, 此时没有 bm25, 结果按时间倒序排列
+type SQLiteIndex struct {
+	db     *gorm.DB
+	module string // fts5, fts4
+}
+
+func newSQLiteIndex(db *gorm.DB) (*SQLiteIndex, error) {
+	fts5 := `CREATE VIRTUAL TABLE IF NOT EXISTS search_fts USING fts5(
+		kind UNINDEXED, key UNINDEXED, scope UNINDEXED, owner UNINDEXED, created_at UNINDEXED, text UNINDEXED,
+		tokens, tokenize = 'unicode61 remove_diacritics 2')`
+	// 探测 FTS5 是否可用, 不可用是预期内的, 不输出错误日志
+	probe := db.Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
+	if err := probe.Exec(fts5).Error; err == nil {
+		return &SQLiteIndex{db: db, module: "fts5"}, nil
+	} else if !strings.Contains(err.Error(), "no such module") {
+		return nil, fmt.Errorf("创建 FTS5 索引失败: %w", err)
+	}
+
+	fts4 := `CREATE VIRTUAL TABLE IF NOT EXISTS search_fts USING fts4(
+		kind, key, scope, owner, created_at, text, tokens,
+		notindexed=kind, notindexed=key, notindexed=scope, notindexed=owner, notindexed=created_at, notindexed=text,
+		tokenize=unicode61)`
+	if err := db.Exec(fts4).Error; err != nil {
+		return nil, fmt.Errorf("创建 FTS4 索引失败: %w", err)
+	}
+	return &SQLiteIndex{db: db, module: "fts4"}, nil
+}
+
+func (s *SQLiteIndex) Name() string {
+	return "sqlite-" + s.module
+}
+
+func (s *SQLiteIndex) Upsert(ctx context.Context, docs ...*Document) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, doc := range docs {
+			if err := tx.Exec("DELETE FROM search_fts WHERE kind = ? AND key = ?", doc.Kind, doc.Key).Error; err != nil {
+				return err
+			}
+			err := tx.Exec("INSERT INTO search_fts (kind, key, scope, owner, created_at, text, tokens) VALUES (?, ?, ?, ?, ?, ?, ?)",
+				doc.Kind, doc.Key, doc.Scope, doc.Owner, doc.CreatedAt, doc.Text, joinTokens(IndexTokens(doc.Text))).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SQLiteIndex) Delete(ctx context.Context, kind string, key string) error {
+	return s.db.WithContext(ctx).Exec("DELETE FROM search_fts WHERE kind = ? AND key = ?", kind, key).Error
+}
+
+func (s *SQLiteIndex) Search(ctx context.Context, query *Query) ([]*Hit, error) {
+	tokens, prefix := matchTokens(query.Text)
+	if len(tokens) == 0 {
+		return []*Hit{}, nil
+	}
+	// 分词结果只含字母、数字和中日韩文字, 可以直接作为 MATCH 的词项
+	match := strings.Join(tokens, " ")
+	if prefix {
+		match += "*"
+	}
+
+	score := "0"
+	order := "CAST(created_at AS INTEGER) DESC"
+	if s.module == "fts5" {
+		score = "-bm25(search_fts)"
+		order = "bm25(search_fts), " + order
+	}
+
+	sql := "SELECT kind, key, scope, owner, CAST(created_at AS INTEGER) AS created_at, text, " + score + " AS score FROM search_fts WHERE search_fts MATCH ?"
+	args := []interface{}{match}
+	if query.Kind != "" {
+		sql += " AND kind = ?"
+		args = append(args, query.Kind)
+	}
+	if len(query.Scopes) > 0 {
+		sql += " AND scope IN ?"
+		args = append(args, query.Scopes)
+	}
+	if len(query.ExcludeOwners) > 0 {
+		sql += " AND owner NOT IN ?"
+		args = append(args, query.ExcludeOwners)
+	}
+	if query.Since > 0 {
+		sql += " AND CAST(created_at AS INTEGER) >= ?"
+		args = append(args, query.Since)
+	}
+	if query.Until > 0 {
+		sql += " AND CAST(created_at AS INTEGER) < ?"
+		args = append(args, query.Until)
+	}
+	sql += " ORDER BY " + order + " LIMIT ? OFFSET ?"
+	args = append(args, query.Limit, query.Offset)
+
+	var hits []*Hit
+	if err := s.db.WithContext(ctx).Raw(sql, args...).Scan(&hits).Error; err != nil {
+		return nil, err
+	}
+	return hits, nil
+}
+
+func (s *SQLiteIndex) Count(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.db.WithContext(ctx).Raw("SELECT COUNT(*) FROM search_fts").Scan(&count).Error
+	return count, err
+}
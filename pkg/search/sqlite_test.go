@@ -0,0 +1,179 @@
+package search
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestIndex 默认编译只有 FTS4, 使用 -tags sqlite_fts5 运行时测试 FTS5
+func newTestIndex(t *testing.T) Index {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "search.sqlite")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	index, err := NewIndex(db)
+	if err != nil {
+		t.Fatalf("new index: %v", err)
+	}
+	t.Logf("search backend: %s", index.Name())
+	return index
+}
+
+func hitKeys(hits []*Hit) string {
+	keys := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		keys = append(keys, hit.Key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+func TestSQLiteIndexSearch(t *testing.T) {
+	ctx := context.Background()
+	index := newTestIndex(t)
+	docs := []*Document{
+		{Kind: KindMoment, Key: "m1", Owner: "did:plc:alice", Text: "今天去北京看升旗", CreatedAt: 100},
+		{Kind: KindMoment, Key: "m2", Owner: "did:plc:bob", Text: "北京烤鸭 is great", CreatedAt: 200},
+		{Kind: KindMoment, Key: "m3", Owner: "did:plc:carol", Text: "Trip to Beijing", CreatedAt: 300},
+		{Kind: KindUser, Key: "did:plc:alice", Owner: "did:plc:alice", Text: "alice.test Alice 北京人", CreatedAt: 100},
+		{Kind: KindMessage, Key: "msg1", Scope: "room-1", Owner: "did:plc:alice", Text: "北京见", CreatedAt: 150},
+		{Kind: KindMessage, Key: "msg2", Scope: "room-2", Owner: "did:plc:bob", Text: "北京见", CreatedAt: 250},
+	}
+	if err := index.Upsert(ctx, docs...); err != nil {
+		t.Fatalf("upsert: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  string
+	}{
+		{"cjk substring", Query{Kind: KindMoment, Text: "北京"}, "m1,m2"},
+		{"cjk single character", Query{Kind: KindMoment, Text: "旗"}, "m1"},
+		{"cjk bigrams must be adjacent", Query{Kind: KindMoment, Text: "北旗"}, ""},
+		{"terms are ANDed", Query{Kind: KindMoment, Text: "北京 great"}, "m2"},
+		{"case insensitive", Query{Kind: KindMoment, Text: "BEIJING"}, "m3"},
+		{"prefix on the last latin word", Query{Kind: KindUser, Text: "ali"}, "did:plc:alice"},
+		{"no prefix on earlier words", Query{Kind: KindMoment, Text: "tri beijing"}, ""},
+		{"all kinds", Query{Text: "北京"}, "did:plc:alice,m1,m2,msg1,msg2"},
+		{"scopes", Query{Kind: KindMessage, Text: "北京", Scopes: []string{"room-1"}}, "msg1"},
+		{"excluded owners", Query{Kind: KindMoment, Text: "北京", ExcludeOwners: []string{"did:plc:bob"}}, "m1"},
+		{"since and until", Query{Text: "北京", Since: 150, Until: 250}, "m2,msg1"},
+		{"punctuation only", Query{Text: "?!"}, ""},
+	}
+	for _, tt := range tests {
+		query := tt.query
+		query.Limit = 10
+		hits, err := index.Search(ctx, &query)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := hitKeys(hits); got != tt.want {
+			t.Errorf("%s: hits = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	hits, err := index.Search(ctx, &Query{Kind: KindMessage, Text: "北京见", Limit: 10})
+	if err != nil || len(hits) != 2 {
+		t.Fatalf("message hits = %v, %v", hits, err)
+	}
+	if hit := hits[0]; hit.Scope == "" || hit.Owner == "" || hit.Text != "北京见" || hit.CreatedAt == 0 {
+		t.Errorf("hit fields not returned: %+v", hit)
+	}
+
+	// 分页结果不重复不遗漏
+	var paged []*Hit
+	for offset := 0; offset < 6; offset += 2 {
+		hits, err := index.Search(ctx, &Query{Text: "北京", Limit: 2, Offset: offset})
+		if err != nil {
+			t.Fatalf("page at %d: %v", offset, err)
+		}
+		if offset < 4 && len(hits) != 2 {
+			t.Fatalf("page at %d has %d hits, want 2", offset, len(hits))
+		}
+		paged = append(paged, hits...)
+	}
+	if got := hitKeys(paged); got != "did:plc:alice,m1,m2,msg1,msg2" {
+		t.Errorf("paged hits = %q, want every match once", got)
+	}
+}
+
+func TestSQLiteIndexUpsertAndDelete(t *testing.T) {
+	ctx := context.Background()
+	index := newTestIndex(t)
+
+	if err := index.Upsert(ctx, &Document{Kind: KindMoment, Key: "m1", Text: "old text"}); err != nil {
+		t.Fatalf("upsert: %v", err)
+	}
+	// 同一 (kind, key) 重复写入覆盖旧文档, 不同 kind 的相同 key 互不影响
+	if err := index.Upsert(ctx,
+		&Document{Kind: KindMoment, Key: "m1", Text: "new text"},
+		&Document{Kind: KindTag, Key: "m1", Text: "old tag"},
+	); err != nil {
+		t.Fatalf("upsert: %v", err)
+	}
+	search := func(kind string, text string) string {
+		hits, err := index.Search(ctx, &Query{Kind: kind, Text: text, Limit: 10})
+		if err != nil {
+			t.Fatalf("search %q: %v", text, err)
+		}
+		return hitKeys(hits)
+	}
+	if got := search(KindMoment, "old"); got != "" {
+		t.Errorf("old moment text still matches: %q", got)
+	}
+	if got := search(KindMoment, "new"); got != "m1" {
+		t.Errorf("new moment text = %q, want m1", got)
+	}
+	if count, err := index.Count(ctx); err != nil || count != 2 {
+		t.Errorf("count = %d, %v, want 2", count, err)
+	}
+
+	if err := index.Delete(ctx, KindMoment, "m1"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if got := search("", "text"); got != "" {
+		t.Errorf("deleted moment still matches: %q", got)
+	}
+	if got := search(KindTag, "old"); got != "m1" {
+		t.Errorf("tag m1 = %q, want it kept", got)
+	}
+}
+
+func TestSQLiteIndexRanking(t *testing.T) {
+	ctx := context.Background()
+	index := newTestIndex(t)
+	if err := index.Upsert(ctx,
+		&Document{Kind: KindMoment, Key: "once", Text: "coffee and tea and more words here", CreatedAt: 300},
+		&Document{Kind: KindMoment, Key: "often", Text: "coffee coffee coffee", CreatedAt: 100},
+		&Document{Kind: KindMoment, Key: "newest", Text: "coffee with milk and sugar and more", CreatedAt: 400},
+	); err != nil {
+		t.Fatalf("upsert: %v", err)
+	}
+	hits, err := index.Search(ctx, &Query{Text: "coffee", Limit: 10})
+	if err != nil || len(hits) != 3 {
+		t.Fatalf("hits = %v, %v", hits, err)
+	}
+
+	if index.Name() == "sqlite-fts5" {
+		// FTS5 按 bm25 排序, 词频高的文档在前
+		if hits[0].Key != "often" || hits[0].Score <= hits[2].Score {
+			t.Errorf("fts5 ranking = %s, first score %v, want often first", hitKeys(hits), hits[0].Score)
+		}
+		return
+	}
+	// FTS4 没有 bm25, 按时间倒序
+	if hits[0].Key != "newest" || hits[1].Key != "once" || hits[2].Key != "often" {
+		t.Errorf("fts4 order = %s,%s,%s, want newest,once,often", hits[0].Key, hits[1].Key, hits[2].Key)
+	}
+}
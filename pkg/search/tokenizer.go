@@ -0,0 +1,115 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// 单个拉丁词的最大长度, 超出部分截断, 避免超长 URL 之类的内容撑大索引
+const maxTokenLength = 64
+
+// 分词在写入索引前完成, 索引后端只按空格切分:
+//
+//   - 字母和数字组成的连续片段作为一个词, 统一为小写
+//   - 中日韩文字没有分隔符, 连续片段按相邻两字切成二元组, 同时保留单字, 以便单字查询也能命中
+//   - 其余字符 (标点、空白、emoji) 作为分隔符丢弃
+//
+// 查询使用相同的切分规则, 但长度大于 1 的中日韩片段只使用二元组, 以保证精度
+
+// IndexTokens 返回写入索引的词
+func IndexTokens(text string) []string {
+	return tokenize(text, true)
+}
+
+// QueryTokens 返回查询使用的词, 各词之间为 AND 关系
+func QueryTokens(text string) []string {
+	return tokenize(text, false)
+}
+
+// Terms 返回查询中需要高亮的原始片段: 每个拉丁词和每段连续的中日韩文字
+func Terms(text string) []string {
+	var terms []string
+	for _, run := range splitRuns(text) {
+		terms = append(terms, string(run.runes))
+	}
+	return terms
+}
+
+func tokenize(text string, withUnigrams bool) []string {
+	var tokens []string
+	for _, run := range splitRuns(text) {
+		if !run.cjk {
+			tokens = append(tokens, string(run.runes))
+			continue
+		}
+		if len(run.runes) == 1 {
+			tokens = append(tokens, string(run.runes))
+			continue
+		}
+		for i := 0; i+1 < len(run.runes); i++ {
+			if withUnigrams {
+				tokens = append(tokens, string(run.runes[i]))
+			}
+			tokens = append(tokens, string(run.runes[i:i+2]))
+		}
+		if withUnigrams {
+			tokens = append(tokens, string(run.runes[len(run.runes)-1]))
+		}
+	}
+	return tokens
+}
+
+type textRun struct {
+	runes []rune
+	cjk   bool
+}
+
+// splitRuns 按字符类别切分文本, 返回小写的拉丁片段和中日韩片段
+func splitRuns(text string) []textRun {
+	var runs []textRun
+	var current []rune
+	currentCJK := false
+
+	flush := func() {
+		if len(current) > 0 {
+			if !currentCJK && len(current) > maxTokenLength {
+				current = current[:maxTokenLength]
+			}
+			runs = append(runs, textRun{runes: current, cjk: currentCJK})
+		}
+		current = nil
+	}
+
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			if !currentCJK {
+				flush()
+			}
+			currentCJK = true
+			current = append(current, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if currentCJK {
+				flush()
+			}
+			currentCJK = false
+			current = append(current, unicode.ToLower(r))
+		default:
+			flush()
+		}
+	}
+	flush()
+	return runs
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) ||
+		unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) ||
+		unicode.Is(unicode.Hangul, r)
+}
+
+// joinTokens 索引中保存以空格分隔的词
+func joinTokens(tokens []string) string {
+	return strings.Join(tokens, " ")
+}
@@ -0,0 +1,65 @@
+package search
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTokenizeCJK(t *testing.T) {
+	tests := []struct {
+		text  string
+		index string
+		query string
+	}{
+		{"世界和平", "世 世界 界 界和 和 和平 平", "世界 界和 和平"},
+		// 单字片段在查询中保留单字
+		{"世", "世", "世"},
+		{"Hello, 世界!", "hello 世 世界 界", "hello 世界"},
+		// 拉丁和中日韩字符相邻时切成不同的片段
+		{"go语言2024", "go 语 语言 言 2024", "go 语言 2024"},
+		{"カタカナ 한국어", "カ カタ タ タカ カ カナ ナ 한 한국 국 국어 어", "カタ タカ カナ 한국 국어"},
+		{"C'est déjà vu 🎉", "c est déjà vu", "c est déjà vu"},
+		{"  ...  ", "", ""},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(IndexTokens(tt.text), " "); got != tt.index {
+			t.Errorf("IndexTokens(%q) = %q, want %q", tt.text, got, tt.index)
+		}
+		if got := strings.Join(QueryTokens(tt.text), " "); got != tt.query {
+			t.Errorf("QueryTokens(%q) = %q, want %q", tt.text, got, tt.query)
+		}
+	}
+
+	if got := strings.Join(Terms("Hello, 世界和平 go"), "|"); got != "hello|世界和平|go" {
+		t.Errorf("Terms = %q", got)
+	}
+	long := strings.Repeat("a", maxTokenLength+10)
+	if tokens := IndexTokens(long); len(tokens) != 1 || len(tokens[0]) != maxTokenLength {
+		t.Errorf("long token = %v, want truncated to %d", tokens, maxTokenLength)
+	}
+}
+
+func TestMatchTokensPrefix(t *testing.T) {
+	tests := []struct {
+		text   string
+		tokens string
+		prefix bool
+	}{
+		{"", "", false},
+		{"!!", "", false},
+		// 单个字母的前缀匹配范围太大, 不启用
+		{"a", "a", false},
+		{"al", "al", true},
+		{"hello wor", "hello wor", true},
+		// 最后一个词是中日韩文字时不按前缀匹配, 二元组已经覆盖子串
+		{"hello 世界", "hello 世界", false},
+		{"世界 hello", "世界 hello", true},
+		{"世", "世", false},
+	}
+	for _, tt := range tests {
+		tokens, prefix := matchTokens(tt.text)
+		if got := strings.Join(tokens, " "); got != tt.tokens || prefix != tt.prefix {
+			t.Errorf("matchTokens(%q) = %q, %v, want %q, %v", tt.text, got, prefix, tt.tokens, tt.prefix)
+		}
+	}
+}
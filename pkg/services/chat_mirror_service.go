@@ -0,0 +1,453 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/atproto"
+	"github.com/zhongshangwu/avatarai-social/pkg/atproto/vtri"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/moderation"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/utils"
+	"github.com/zhongshangwu/avatarai-social/types"
+)
+
+var ErrNoPDSSession = errors.New("缺少 PDS 会话")
+
+const listRecordsPageSize = 100
+
+// ChatImportResult 从 PDS 导入的记录数, 本地已存在的记录也计入
+type ChatImportResult struct {
+	Rooms    int `json:"rooms"`
+	Threads  int `json:"threads"`
+	Messages int `json:"messages"`
+	Skipped  int `json:"skipped"` // 无法解析、不属于用户或未通过内容审核的记录
+}
+
+// chatRecordLister 逐条读取一个集合中的记录
+type chatRecordLister func(ctx context.Context, collection string, handle func(value json.RawMessage)) error
+
+// ChatMirrorService 按房间把聊天记录同步为用户仓库中的 app.vtri.chat.* 记录.
+// PDS 中的记录是公开的, 所以同步需要成员在房间上单独开启, 并且只写入用户自己发送的消息
+// 和回复给用户的 AI 消息, 房间内其他成员的消息不会出现在用户的仓库中
+type ChatMirrorService struct {
+	metaStore         *repositories.MetaStore
+	converter         *MessageConverter
+	roomService       *RoomService
+	moderationService *ModerationService
+}
+
+func NewChatMirrorService(config *config.SocialConfig, metaStore *repositories.MetaStore) *ChatMirrorService {
+	return &ChatMirrorService{
+		metaStore:         metaStore,
+		converter:         NewMessageConverter(metaStore.MessageRepo),
+		roomService:       NewRoomService(metaStore),
+		moderationService: NewModerationService(config, metaStore),
+	}
+}
+
+// SetMirror 开启或关闭房间的 PDS 同步. 开启时补齐房间、话题和已有的消息;
+// 关闭后不再写入新的记录, 已经写入 PDS 的记录保留
+func (s *ChatMirrorService) SetMirror(ctx context.Context, did string, roomID string, enabled bool) error {
+	if _, err := s.roomService.CheckMember(did, roomID); err != nil {
+		return err
+	}
+	status, err := s.roomService.getUserRoomStatus(did, roomID)
+	if err != nil {
+		return err
+	}
+	if status.PDSMirror == enabled {
+		return nil
+	}
+	if err := s.metaStore.MessageRepo.UpdateUserRoomStatus(did, roomID, map[string]interface{}{"pds_mirror": enabled}); err != nil {
+		return fmt.Errorf("更新同步设置失败: %w", err)
+	}
+	if enabled {
+		s.backfill(did, roomID)
+	}
+	return nil
+}
+
+// backfill 按房间、话题、消息的顺序入队, 同一用户的操作按入队顺序写入
+func (s *ChatMirrorService) backfill(did string, roomID string) {
+	enqueueChatRecord(s.metaStore, did, ChatRoomCollection, roomID, repositories.OutboxActionCreate)
+
+	threads, err := s.metaStore.MessageRepo.ListThreadsByRoom(roomID)
+	if err != nil {
+		log.Printf("获取房间 %s 的话题失败, 无法同步到 PDS: %v", roomID, err)
+	}
+	for _, thread := range threads {
+		enqueueChatRecord(s.metaStore, did, ChatThreadCollection, thread.ID, repositories.OutboxActionCreate)
+	}
+
+	dbMessages, err := s.metaStore.MessageRepo.ListMirrorMessages(roomID, did, int(messages.MessageTypeAgent))
+	if err != nil {
+		log.Printf("获取房间 %s 的消息失败, 无法同步到 PDS: %v", roomID, err)
+		return
+	}
+	for _, dbMessage := range dbMessages {
+		if messages.MessageType(dbMessage.MsgType) == messages.MessageTypeAgent {
+			var content map[string]interface{}
+			if err := json.Unmarshal([]byte(dbMessage.Content), &content); err != nil {
+				continue
+			}
+			agentMessageID := utils.GetStringFromMap(content, "agentMessageId")
+			if agentMessageID == "" {
+				continue
+			}
+			enqueueChatRecord(s.metaStore, did, ChatAiChatCollection, agentMessageID, repositories.OutboxActionCreate)
+		}
+		enqueueChatRecord(s.metaStore, did, ChatMessageCollection, dbMessage.ID, repositories.OutboxActionCreate)
+	}
+}
+
+// listRecordsOutput com.atproto.repo.listRecords 的输出, value 按集合解析为对应的记录类型
+type listRecordsOutput struct {
+	Cursor  *string `json:"cursor,omitempty"`
+	Records []struct {
+		Uri   string          `json:"uri"`
+		Cid   string          `json:"cid"`
+		Value json.RawMessage `json:"value"`
+	} `json:"records"`
+}
+
+// ImportFromPDS 读取用户仓库中的 app.vtri.chat.* 记录重建本地聊天历史. 本地不存在的房间以用户为唯一成员创建,
+// 本地已存在的房间只有用户是创建者且仍是正式成员时才导入, 已存在的话题和消息保持不变.
+// 只导入用户自己发送的消息和回复给用户的 AI 消息, AI 消息的发送者固定为用户自己的 Aster, 消息写入前经过内容审核
+func (s *ChatMirrorService) ImportFromPDS(ctx context.Context, did string, session *types.OAuthSession) (*ChatImportResult, error) {
+	if session == nil {
+		return nil, ErrNoPDSSession
+	}
+	client, err := atproto.NewXrpcClient(session, atproto.WithNonceUpdateCallback(func(did, newNonce string) error {
+		return s.metaStore.OAuthRepo.UpdateOAuthSessionDpopPdsNonce(did, newNonce)
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("创建 XRPC 客户端失败: %w", err)
+	}
+	return s.importChatRecords(ctx, did, func(ctx context.Context, collection string, handle func(value json.RawMessage)) error {
+		return listChatRecords(ctx, client, did, collection, handle)
+	})
+}
+
+func (s *ChatMirrorService) importChatRecords(ctx context.Context, did string, list chatRecordLister) (*ChatImportResult, error) {
+	// 记录中的 AI 消息发送者不可信, 没有 Aster 的用户不导入 AI 消息
+	asterDID := ""
+	aster, err := s.metaStore.UserRepo.GetAsterByCreatorDid(did)
+	if err == nil {
+		asterDID = aster.Did
+	} else if !errors.Is(err, repositories.ErrAsterNotFound) {
+		return nil, fmt.Errorf("获取用户的 Aster 失败: %w", err)
+	}
+
+	result := &ChatImportResult{}
+	data := &repositories.ChatHistoryImport{}
+	now := time.Now().UnixMilli()
+
+	// 房间: 新房间直接创建, 已有的房间需要用户是创建者且仍是正式成员
+	rooms := make(map[string]*repositories.Room)
+	created := make(map[string]bool)
+	err = list(ctx, ChatRoomCollection, func(value json.RawMessage) {
+		var record vtri.ChatRoom
+		if err := json.Unmarshal(value, &record); err != nil || record.Id == "" {
+			result.Skipped++
+			return
+		}
+		room, err := s.converter.RecordToRoom(&record)
+		if err != nil {
+			result.Skipped++
+			return
+		}
+		existing, err := s.ownedRoom(did, room.ID)
+		if err != nil {
+			result.Skipped++
+			return
+		}
+		if existing == nil {
+			room.CreatorID = did
+			data.Rooms = append(data.Rooms, room)
+			member := newRoomMember(room.ID, did, messages.RoomMemberStatusAccepted, messages.RoomMemberRoleOwner, "", now)
+			member.PDSMirror = true
+			data.Members = append(data.Members, member)
+			created[room.ID] = true
+		} else {
+			room = existing
+		}
+		rooms[room.ID] = room
+		result.Rooms++
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	threadRooms := make(map[string]string)
+	err = list(ctx, ChatThreadCollection, func(value json.RawMessage) {
+		var record vtri.ChatThread
+		if err := json.Unmarshal(value, &record); err != nil || record.Id == "" {
+			result.Skipped++
+			return
+		}
+		thread, err := s.converter.RecordToThread(&record)
+		if err != nil || rooms[thread.RoomID] == nil {
+			result.Skipped++
+			return
+		}
+		// 话题 ID 已被其他房间使用
+		if existing, err := s.metaStore.MessageRepo.GetThreadByID(thread.ID); !errors.Is(err, gorm.ErrRecordNotFound) && (err != nil || existing.RoomID != thread.RoomID) {
+			result.Skipped++
+			return
+		}
+		thread.CreatorID = did
+		data.Threads = append(data.Threads, thread)
+		threadRooms[thread.ID] = thread.RoomID
+		result.Threads++
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// AI 回复先按所属的消息 ID 收集, 与消息记录配对后再写入
+	agentMessages := make(map[string]*messages.AgentMessage)
+	err = list(ctx, ChatAiChatCollection, func(value json.RawMessage) {
+		var record vtri.ChatAiChat_Message
+		if err := json.Unmarshal(value, &record); err != nil || record.Id == "" || record.UserId != did {
+			result.Skipped++
+			return
+		}
+		agentMessages[record.MessageId] = s.converter.RecordToAgentMessage(&record)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var imported []*messages.Message
+	err = list(ctx, ChatMessageCollection, func(value json.RawMessage) {
+		var record vtri.ChatMessage
+		if err := json.Unmarshal(value, &record); err != nil || record.Id == "" {
+			result.Skipped++
+			return
+		}
+		message, err := s.converter.RecordToMessage(&record)
+		if err != nil || rooms[message.RoomID] == nil {
+			result.Skipped++
+			return
+		}
+		if message.ThreadID != "" && threadRooms[message.ThreadID] != message.RoomID {
+			if _, err := s.roomService.getThread(message.RoomID, message.ThreadID); err != nil {
+				result.Skipped++
+				return
+			}
+		}
+
+		// 本地已存在的消息保持不变, 也不重新审核和索引
+		if _, err := s.metaStore.MessageRepo.GetMessageByID(message.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+			if err != nil {
+				result.Skipped++
+			} else {
+				result.Messages++
+			}
+			return
+		}
+
+		var agentMessage *messages.AgentMessage
+		if message.MsgType == messages.MessageTypeAgent {
+			agentMessage = agentMessages[message.ID]
+			content := message.Content.(*messages.AgentMessageContent)
+			if asterDID == "" || agentMessage == nil || agentMessage.ID != content.AgentMessage.ID {
+				result.Skipped++
+				return
+			}
+			if _, err := s.metaStore.MessageRepo.GetAgentMessageByID(agentMessage.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+				result.Skipped++
+				return
+			}
+			message.SenderID = asterDID
+			message.ReceiverID = did
+			agentMessage.Creator = asterDID
+			content.AgentMessage = *agentMessage
+		} else if message.SenderID != did {
+			result.Skipped++
+			return
+		}
+
+		decision, err := s.moderationService.Moderate(ctx, MessageSubject(message))
+		if err != nil {
+			log.Printf("审核导入的消息 %s 失败: %v", message.ID, err)
+			result.Skipped++
+			return
+		}
+		if decision.Verdict == moderation.VerdictReject {
+			result.Skipped++
+			return
+		}
+		if agentMessage != nil {
+			data.AgentMessages = append(data.AgentMessages, s.converter.AgentMessageToDB(agentMessage))
+			data.AgentItems = append(data.AgentItems, agentMessageItemsToDB(agentMessage)...)
+		}
+		data.Messages = append(data.Messages, s.converter.MessageToDB(message))
+		imported = append(imported, message)
+		result.Messages++
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 新建房间的最后一条消息取导入消息中最晚的一条
+	sort.Slice(imported, func(i, j int) bool { return imported[i].CreatedAt < imported[j].CreatedAt })
+	for _, message := range imported {
+		if !created[message.RoomID] {
+			continue
+		}
+		room := rooms[message.RoomID]
+		room.LastMID = message.ID
+		if message.CreatedAt > room.UpdatedAt {
+			room.UpdatedAt = message.CreatedAt
+		}
+	}
+
+	if err := s.metaStore.MessageRepo.ImportChatHistory(data); err != nil {
+		return nil, fmt.Errorf("写入导入的聊天记录失败: %w", err)
+	}
+	for _, message := range imported {
+		if content, ok := message.Content.(*messages.AgentMessageContent); ok {
+			IndexAgentReply(s.metaStore, &content.AgentMessage)
+			continue
+		}
+		IndexMessage(s.metaStore, message)
+	}
+	return result, nil
+}
+
+// ownedRoom 返回用户创建且仍是正式成员的房间, 房间不存在时返回 nil.
+// 没有房间记录但已有消息的早期聊天按已有房间处理, 由 CheckMember 根据消息补建
+func (s *ChatMirrorService) ownedRoom(did string, roomID string) (*repositories.Room, error) {
+	existing, err := s.roomService.getRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		used, err := s.metaStore.MessageRepo.HasRoomMessages(roomID, "")
+		if err != nil {
+			return nil, fmt.Errorf("获取房间消息失败: %w", err)
+		}
+		if !used {
+			return nil, nil
+		}
+	}
+	room, err := s.roomService.CheckMember(did, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if room.CreatorID != did {
+		return nil, ErrRoomPermission
+	}
+	return room, nil
+}
+
+// listChatRecords 分页读取用户仓库中一个集合的全部记录
+func listChatRecords(ctx context.Context, client *atproto.XrpcClient, did string, collection string, handle func(value json.RawMessage)) error {
+	cursor := ""
+	for {
+		params := map[string]interface{}{
+			"repo":       did,
+			"collection": collection,
+			"limit":      listRecordsPageSize,
+		}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+		var out listRecordsOutput
+		if err := client.Query(ctx, "com.atproto.repo.listRecords", params, &out); err != nil {
+			return fmt.Errorf("读取 PDS 中的 %s 记录失败: %w", collection, err)
+		}
+		for _, record := range out.Records {
+			handle(record.Value)
+		}
+		if out.Cursor == nil || *out.Cursor == "" || *out.Cursor == cursor || len(out.Records) == 0 {
+			return nil
+		}
+		cursor = *out.Cursor
+	}
+}
+
+func agentMessageItemsToDB(agentMessage *messages.AgentMessage) []*repositories.AgentMessageItem {
+	items := make([]*repositories.AgentMessageItem, 0, len(agentMessage.MessageItems))
+	for position, item := range agentMessage.MessageItems {
+		itemBytes, err := json.Marshal(item)
+		if err != nil {
+			continue
+		}
+		items = append(items, &repositories.AgentMessageItem{
+			ID:             uuid.New().String(),
+			AgentMessageID: agentMessage.ID,
+			ItemType:       item.GetType(),
+			Item:           string(itemBytes),
+			Position:       position,
+			CreatedAt:      agentMessage.CreatedAt,
+			UpdatedAt:      agentMessage.UpdatedAt,
+		})
+	}
+	return items
+}
+
+// 以下函数在各写入路径上把房间内的变化加入 PDS 同步队列, 只有开启了同步的成员会写入
+
+// MirrorMessage 同步用户发送的消息, AI 回复在完成后由 MirrorAgentReply 同步
+func MirrorMessage(metaStore *repositories.MetaStore, message *messages.Message) {
+	if message.MsgType == messages.MessageTypeAgent || !chatMirrorEnabled(metaStore, message.SenderID, message.RoomID) {
+		return
+	}
+	enqueueChatRecord(metaStore, message.SenderID, ChatMessageCollection, message.ID, repositories.OutboxActionCreate)
+}
+
+// MirrorAgentReply 同步已完成的 AI 回复, 写入被回复用户的仓库
+func MirrorAgentReply(metaStore *repositories.MetaStore, agentMessage *messages.AgentMessage) {
+	if agentMessage.Status != messages.AgentMessageStatusCompleted {
+		return
+	}
+	message, err := metaStore.MessageRepo.GetMessageByID(agentMessage.MessageID)
+	if err != nil {
+		log.Printf("获取消息 %s 失败, 无法同步到 PDS: %v", agentMessage.MessageID, err)
+		return
+	}
+	if message.ReceiverID == "" || !chatMirrorEnabled(metaStore, message.ReceiverID, message.RoomID) {
+		return
+	}
+	enqueueChatRecord(metaStore, message.ReceiverID, ChatAiChatCollection, agentMessage.ID, repositories.OutboxActionCreate)
+	enqueueChatRecord(metaStore, message.ReceiverID, ChatMessageCollection, message.ID, repositories.OutboxActionCreate)
+}
+
+// mirrorRoomRecord 房间或话题变化后写入所有开启了同步的成员的仓库
+func mirrorRoomRecord(metaStore *repositories.MetaStore, roomID string, collection string, rkey string, action string) {
+	members, err := metaStore.MessageRepo.ListMirrorMembers(roomID)
+	if err != nil {
+		log.Printf("获取房间 %s 开启同步的成员失败: %v", roomID, err)
+		return
+	}
+	for _, member := range members {
+		enqueueChatRecord(metaStore, member, collection, rkey, action)
+	}
+}
+
+func chatMirrorEnabled(metaStore *repositories.MetaStore, did string, roomID string) bool {
+	status, err := metaStore.MessageRepo.GetUserRoomStatus(did, roomID)
+	if err != nil {
+		return false
+	}
+	return status.PDSMirror && !status.Deleted && status.Status == string(messages.RoomMemberStatusAccepted)
+}
+
+func enqueueChatRecord(metaStore *repositories.MetaStore, did string, collection string, rkey string, action string) {
+	if err := metaStore.OutboxRepo.EnqueueRecordOp(did, collection, rkey, action); err != nil {
+		log.Printf("记录 at://%s/%s/%s 加入同步队列失败: %v", did, collection, rkey, err)
+	}
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/moderation"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+)
+
+const importAster = "did:plc:alice-aster"
+
+// recordLister 按集合返回预先准备好的记录, 代替读取 PDS
+func recordLister(t *testing.T, records map[string][]interface{}) chatRecordLister {
+	return func(ctx context.Context, collection string, handle func(value json.RawMessage)) error {
+		for _, record := range records[collection] {
+			value, err := json.Marshal(record)
+			if err != nil {
+				t.Fatalf("marshal %s record: %v", collection, err)
+			}
+			handle(value)
+		}
+		return nil
+	}
+}
+
+func roomRecord(s *ChatMirrorService, roomID string) interface{} {
+	return s.converter.RoomToRecord(&repositories.Room{ID: roomID, Type: string(messages.RoomTypeAIChat), CreatedAt: 1000, UpdatedAt: 1000})
+}
+
+func textRecord(t *testing.T, s *ChatMirrorService, id string, roomID string, sender string, text string) interface{} {
+	t.Helper()
+	record, err := s.converter.MessageToRecord(&messages.Message{
+		ID:        id,
+		RoomID:    roomID,
+		MsgType:   messages.MessageTypeText,
+		Content:   &messages.TextMessageContent{Text: text},
+		SenderID:  sender,
+		CreatedAt: 2000,
+	})
+	if err != nil {
+		t.Fatalf("message record %s: %v", id, err)
+	}
+	return record
+}
+
+// agentRecords AI 回复的消息记录和 aiChat 记录, sender 为记录中声称的发送者
+func agentRecords(t *testing.T, s *ChatMirrorService, id string, roomID string, sender string, user string, text string) (interface{}, interface{}) {
+	t.Helper()
+	agentMessage := &messages.AgentMessage{ID: "am-" + id, MessageID: id, AltText: text}
+	record, err := s.converter.MessageToRecord(&messages.Message{
+		ID:        id,
+		RoomID:    roomID,
+		MsgType:   messages.MessageTypeAgent,
+		Content:   &messages.AgentMessageContent{AgentMessage: *agentMessage},
+		SenderID:  sender,
+		CreatedAt: 3000,
+	})
+	if err != nil {
+		t.Fatalf("agent message record %s: %v", id, err)
+	}
+	return record, s.converter.AgentMessageToRecord(agentMessage, user)
+}
+
+func TestImportFromPDSOnlyWritesOwnRooms(t *testing.T) {
+	ctx := context.Background()
+	store := newTestMetaStore(t)
+	if err := store.UserRepo.CreateAster(&repositories.Avatar{Did: importAster, IsAster: true, Creator: roomAlice}); err != nil {
+		t.Fatalf("create aster: %v", err)
+	}
+	roomService := NewRoomService(store)
+	s := &ChatMirrorService{
+		metaStore:   store,
+		converter:   NewMessageConverter(store.MessageRepo),
+		roomService: roomService,
+		moderationService: newTestModerationService(store,
+			&verdictChecker{trigger: "spam", verdict: moderation.VerdictReject},
+			&verdictChecker{trigger: "maybe", verdict: moderation.VerdictHold},
+		),
+	}
+
+	// alice 是 bob 群聊的正式成员, 但不是创建者
+	bobRoom := createRoom(t, roomService, roomBob, messages.RoomTypeGroup, roomAlice).Room.RoomID
+	if err := roomService.Accept(ctx, roomAlice, bobRoom); err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	bobMessage := &messages.Message{ID: "bob-msg", RoomID: bobRoom, MsgType: messages.MessageTypeText, Content: &messages.TextMessageContent{Text: "bob secret"}, SenderID: roomBob}
+	if err := store.MessageRepo.InsertMessage(s.converter.MessageToDB(bobMessage)); err != nil {
+		t.Fatalf("insert bob message: %v", err)
+	}
+	IndexMessage(store, bobMessage)
+	ownRoom := createRoom(t, roomService, roomAlice, messages.RoomTypeAIChat).Room.RoomID
+
+	reply, replyAgent := agentRecords(t, s, "reply", "new-room", "did:plc:mallory", roomAlice, "imported answer")
+	rejectedReply, rejectedAgent := agentRecords(t, s, "rejected-reply", "new-room", importAster, roomAlice, "spam answer")
+	result, err := s.importChatRecords(ctx, roomAlice, recordLister(t, map[string][]interface{}{
+		ChatRoomCollection: {roomRecord(s, "new-room"), roomRecord(s, ownRoom), roomRecord(s, bobRoom)},
+		ChatMessageCollection: {
+			textRecord(t, s, "mine", "new-room", roomAlice, "imported hello"),
+			textRecord(t, s, "own-room", ownRoom, roomAlice, "imported again"),
+			textRecord(t, s, "held", "new-room", roomAlice, "maybe imported"),
+			textRecord(t, s, "rejected", "new-room", roomAlice, "imported spam"),
+			textRecord(t, s, "forged-sender", "new-room", roomBob, "imported from bob"),
+			textRecord(t, s, "into-bob-room", bobRoom, roomAlice, "imported intruder"),
+			// 与本地其他房间的消息 ID 相同
+			textRecord(t, s, "bob-msg", "new-room", roomAlice, "imported overwrite"),
+			reply,
+			rejectedReply,
+		},
+		ChatAiChatCollection: {replyAgent, rejectedAgent},
+	}))
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if result.Rooms != 2 || result.Messages != 5 || result.Skipped != 5 {
+		t.Fatalf("result = %+v, want 2 rooms, 5 messages and 5 skipped", result)
+	}
+
+	room, err := store.MessageRepo.GetRoomByID("new-room")
+	if err != nil || room.CreatorID != roomAlice {
+		t.Fatalf("new room = %+v, %v, want created by alice", room, err)
+	}
+	for _, id := range []string{"rejected", "forged-sender", "into-bob-room", "rejected-reply"} {
+		if _, err := store.MessageRepo.GetMessageByID(id); err == nil {
+			t.Errorf("message %s was imported", id)
+		}
+	}
+	if message, err := store.MessageRepo.GetMessageByID("bob-msg"); err != nil || message.RoomID != bobRoom || message.SenderID != roomBob {
+		t.Errorf("bob message after import = %+v, %v, want unchanged", message, err)
+	}
+	hits := searchMessages(t, store, "imported")
+	if hits["bob-msg"] != nil || hits["mine"] == nil || hits["own-room"] == nil {
+		t.Errorf("indexed messages = %v, want the imported messages without bob-msg", hits)
+	}
+
+	// AI 回复的发送者固定为 alice 的 Aster
+	replyMessage, err := store.MessageRepo.GetMessageByID("reply")
+	if err != nil || replyMessage.SenderID != importAster || replyMessage.ReceiverID != roomAlice {
+		t.Fatalf("reply = %+v, %v, want sent by the aster to alice", replyMessage, err)
+	}
+	if agentMessage, err := store.MessageRepo.GetAgentMessageByID("am-reply"); err != nil || agentMessage.Creator != importAster {
+		t.Fatalf("agent message = %+v, %v, want created by the aster", agentMessage, err)
+	}
+	if hit := hits["reply"]; hit == nil || hit.Owner != importAster {
+		t.Errorf("reply document = %+v, want owned by the aster", hit)
+	}
+
+	// 等待审核的消息照常导入, 只对发送者可见
+	if got := labelVals(t, store, "held"); got != "!hide" {
+		t.Errorf("held labels = %s, want !hide", got)
+	}
+}
+
+func TestImportFromPDSWithoutAsterSkipsReplies(t *testing.T) {
+	store := newTestMetaStore(t)
+	s := &ChatMirrorService{
+		metaStore:         store,
+		converter:         NewMessageConverter(store.MessageRepo),
+		roomService:       NewRoomService(store),
+		moderationService: newTestModerationService(store),
+	}
+	reply, replyAgent := agentRecords(t, s, "reply", "new-room", importAster, roomCarol, "answer")
+	result, err := s.importChatRecords(context.Background(), roomCarol, recordLister(t, map[string][]interface{}{
+		ChatRoomCollection:    {roomRecord(s, "new-room")},
+		ChatMessageCollection: {textRecord(t, s, "mine", "new-room", roomCarol, "question"), reply},
+		ChatAiChatCollection:  {replyAgent},
+	}))
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if result.Messages != 1 || result.Skipped != 1 {
+		t.Fatalf("result = %+v, want 1 message and 1 skipped", result)
+	}
+	if _, err := store.MessageRepo.GetMessageByID("reply"); err == nil {
+		t.Errorf("reply was imported without an aster")
+	}
+}
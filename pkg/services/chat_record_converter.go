@@ -0,0 +1,445 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/atproto/vtri"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/utils"
+)
+
+const (
+	ChatRoomCollection    = "app.vtri.chat.room"
+	ChatThreadCollection  = "app.vtri.chat.thread"
+	ChatMessageCollection = "app.vtri.chat.message"
+	ChatAiChatCollection  = "app.vtri.chat.aiChat"
+
+	// 记录中话题的独立上下文, 对应本地的 isolated
+	recordThreadIndependent = "independent"
+)
+
+// RoomToRecord 房间转为 app.vtri.chat.room 记录, rkey 为房间 ID
+func (c *MessageConverter) RoomToRecord(room *repositories.Room) *vtri.ChatRoom {
+	record := &vtri.ChatRoom{
+		LexiconTypeID: ChatRoomCollection,
+		Id:            room.ID,
+		Title:         room.Title,
+		Type:          optionalString(room.Type),
+		CreatedAt:     utils.FormatMilliTime(room.CreatedAt),
+		UpdatedAt:     utils.FormatMilliTime(room.UpdatedAt),
+	}
+	if room.Deleted {
+		record.Deleted = &room.Deleted
+	}
+	return record
+}
+
+// RecordToRoom 记录中没有房间类型时按 AI 对话处理
+func (c *MessageConverter) RecordToRoom(record *vtri.ChatRoom) (*repositories.Room, error) {
+	createdAt, err := utils.ParseMilliTime(record.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("解析房间创建时间失败: %w", err)
+	}
+	updatedAt, err := utils.ParseMilliTime(record.UpdatedAt)
+	if err != nil {
+		updatedAt = createdAt
+	}
+	room := &repositories.Room{
+		ID:        record.Id,
+		Title:     record.Title,
+		Type:      string(messages.RoomTypeAIChat),
+		CreatedAt: createdAt,
+		UpdatedAt: updatedAt,
+	}
+	if record.Type != nil && *record.Type != "" {
+		room.Type = *record.Type
+	}
+	if record.Deleted != nil {
+		room.Deleted = *record.Deleted
+	}
+	return room, nil
+}
+
+// ThreadToRecord 话题转为 app.vtri.chat.thread 记录, rkey 为话题 ID
+func (c *MessageConverter) ThreadToRecord(thread *repositories.Thread) *vtri.ChatThread {
+	threadType := string(messages.ThreadContextModeContinuous)
+	if messages.ThreadContextMode(thread.ContextMode) == messages.ThreadContextModeIsolated {
+		threadType = recordThreadIndependent
+	}
+	record := &vtri.ChatThread{
+		LexiconTypeID:  ChatThreadCollection,
+		Id:             thread.ID,
+		Title:          thread.Title,
+		Type:           threadType,
+		RoomId:         optionalString(thread.RoomID),
+		ParentThreadId: optionalString(thread.ParentThreadID),
+		RootMid:        optionalString(thread.RootMID),
+		CreatedAt:      utils.FormatMilliTime(thread.CreatedAt),
+		UpdatedAt:      utils.FormatMilliTime(thread.UpdatedAt),
+	}
+	if thread.Deleted {
+		record.Deleted = &thread.Deleted
+	}
+	return record
+}
+
+func (c *MessageConverter) RecordToThread(record *vtri.ChatThread) (*repositories.Thread, error) {
+	if record.RoomId == nil || *record.RoomId == "" {
+		return nil, fmt.Errorf("话题 %s 缺少房间ID", record.Id)
+	}
+	createdAt, err := utils.ParseMilliTime(record.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("解析话题创建时间失败: %w", err)
+	}
+	updatedAt, err := utils.ParseMilliTime(record.UpdatedAt)
+	if err != nil {
+		updatedAt = createdAt
+	}
+	thread := &repositories.Thread{
+		ID:             record.Id,
+		RoomID:         *record.RoomId,
+		Title:          record.Title,
+		ContextMode:    string(messages.ThreadContextModeContinuous),
+		ParentThreadID: stringValue(record.ParentThreadId),
+		RootMID:        stringValue(record.RootMid),
+		CreatedAt:      createdAt,
+		UpdatedAt:      updatedAt,
+	}
+	if record.Type == recordThreadIndependent {
+		thread.ContextMode = string(messages.ThreadContextModeIsolated)
+	}
+	if record.Deleted != nil {
+		thread.Deleted = *record.Deleted
+	}
+	return thread, nil
+}
+
+// MessageToRecord 消息转为 app.vtri.chat.message 记录, content 与本地存储的 JSON 结构一致,
+// AI 回复的 content 只引用 agentMessageId, 回复内容在 app.vtri.chat.aiChat 记录中
+func (c *MessageConverter) MessageToRecord(message *messages.Message) (*vtri.ChatMessage, error) {
+	content := "{}"
+	if message.Content != nil {
+		contentBytes, err := json.Marshal(c.serializeMessageContent(message.Content))
+		if err != nil {
+			return nil, fmt.Errorf("序列化消息内容失败: %w", err)
+		}
+		content = string(contentBytes)
+	}
+	record := &vtri.ChatMessage{
+		LexiconTypeID: ChatMessageCollection,
+		Id:            message.ID,
+		RoomId:        message.RoomID,
+		ThreadId:      optionalString(message.ThreadID),
+		MsgType:       int64(message.MsgType),
+		Content:       content,
+		SenderId:      message.SenderID,
+		ReceiverId:    optionalString(message.ReceiverID),
+		QuoteId:       optionalString(message.QuoteMID),
+		SenderAt:      message.SenderAt,
+		CreatedAt:     message.CreatedAt,
+		UpdatedAt:     message.UpdatedAt,
+	}
+	if message.Deleted {
+		record.Deleted = &message.Deleted
+	}
+	return record, nil
+}
+
+// RecordToMessage 记录转为消息. AI 回复的内容只包含 AgentMessage 的 ID, 不查询数据库
+func (c *MessageConverter) RecordToMessage(record *vtri.ChatMessage) (*messages.Message, error) {
+	message := &messages.Message{
+		ID:         record.Id,
+		RoomID:     record.RoomId,
+		ThreadID:   stringValue(record.ThreadId),
+		MsgType:    messages.MessageType(record.MsgType),
+		SenderID:   record.SenderId,
+		ReceiverID: stringValue(record.ReceiverId),
+		QuoteMID:   stringValue(record.QuoteId),
+		SenderAt:   record.SenderAt,
+		CreatedAt:  record.CreatedAt,
+		UpdatedAt:  record.UpdatedAt,
+	}
+	if record.Deleted != nil {
+		message.Deleted = *record.Deleted
+	}
+
+	if message.MsgType == messages.MessageTypeAgent {
+		var contentMap map[string]interface{}
+		if err := json.Unmarshal([]byte(record.Content), &contentMap); err != nil {
+			return nil, fmt.Errorf("解析JSON内容失败: %w", err)
+		}
+		agentMessageID := utils.GetStringFromMap(contentMap, "agentMessageId")
+		if agentMessageID == "" {
+			return nil, fmt.Errorf("Agent消息缺少agentMessageId字段")
+		}
+		message.Content = &messages.AgentMessageContent{
+			AgentMessage: messages.AgentMessage{ID: agentMessageID, MessageID: record.Id},
+		}
+		return message, nil
+	}
+
+	content, err := c.parseMessageContent(message.MsgType, record.Content)
+	if err != nil {
+		return nil, err
+	}
+	message.Content = content
+	return message, nil
+}
+
+// AgentMessageToRecord AI 回复转为 app.vtri.chat.aiChat 记录, userID 为回复的用户.
+// 只保留记录中定义了的输出项 (消息、函数调用和推理), 其余输出项不写入
+func (c *MessageConverter) AgentMessageToRecord(agentMessage *messages.AgentMessage, userID string) *vtri.ChatAiChat_Message {
+	record := &vtri.ChatAiChat_Message{
+		LexiconTypeID: ChatAiChatCollection,
+		Id:            agentMessage.ID,
+		MessageId:     agentMessage.MessageID,
+		Role:          string(agentMessage.Role),
+		Text:          agentMessage.AltText,
+		MessageItems:  make([]*vtri.ChatAiChat_OutputItem, 0, len(agentMessage.MessageItems)),
+		InterruptType: int64(agentMessage.InterruptType),
+		Status:        string(agentMessage.Status),
+		UserId:        userID,
+		CreatedAt:     agentMessage.CreatedAt,
+		UpdatedAt:     agentMessage.UpdatedAt,
+	}
+	for _, item := range agentMessage.MessageItems {
+		if outputItem := outputItemToRecord(item); outputItem != nil {
+			record.MessageItems = append(record.MessageItems, outputItem)
+		}
+	}
+	if agentMessage.Error != nil {
+		code := string(agentMessage.Error.Code)
+		record.Error = &vtri.ChatAiChat_ResponseError{Code: &code, Message: agentMessage.Error.Message}
+	}
+	if agentMessage.IncompleteDetails != nil {
+		reason := string(agentMessage.IncompleteDetails.Reason)
+		record.IncompleteDetails = &vtri.ChatAiChat_Message_IncompleteDetails{Reason: &reason}
+	}
+	if usage := agentMessage.Usage; usage != nil {
+		record.Usage = &vtri.ChatAiChat_ResponseUsage{
+			InputTokens:         usage.InputTokens,
+			InputTokensDetails:  &vtri.ChatAiChat_ResponseUsage_InputTokensDetails{CachedTokens: int64(usage.InputTokensDetails.CachedTokens)},
+			OutputTokens:        usage.OutputTokens,
+			OutputTokensDetails: &vtri.ChatAiChat_ResponseUsage_OutputTokensDetails{ReasoningTokens: int64(usage.OutputTokensDetails.ReasoningTokens)},
+			TotalTokens:         usage.TotalTokens,
+		}
+	}
+	return record
+}
+
+// RecordToAgentMessage 记录转为 AI 回复, 记录中没有创建者, 由调用方补充
+func (c *MessageConverter) RecordToAgentMessage(record *vtri.ChatAiChat_Message) *messages.AgentMessage {
+	agentMessage := &messages.AgentMessage{
+		ID:            record.Id,
+		MessageID:     record.MessageId,
+		Role:          messages.RoleType(record.Role),
+		AltText:       record.Text,
+		MessageItems:  make([]messages.MessageItem, 0, len(record.MessageItems)),
+		InterruptType: int32(record.InterruptType),
+		Status:        messages.AgentMessageStatus(record.Status),
+		CreatedAt:     record.CreatedAt,
+		UpdatedAt:     record.UpdatedAt,
+		Metadata:      make(map[string]interface{}),
+	}
+	for _, outputItem := range record.MessageItems {
+		if item := recordToOutputItem(outputItem); item != nil {
+			agentMessage.MessageItems = append(agentMessage.MessageItems, item)
+		}
+	}
+	if record.Error != nil {
+		agentMessage.Error = &messages.ResponseError{
+			Code:    messages.ResponseErrorCode(stringValue(record.Error.Code)),
+			Message: record.Error.Message,
+		}
+	}
+	if record.IncompleteDetails != nil {
+		agentMessage.IncompleteDetails = &messages.IncompleteDetails{
+			Reason: messages.IncompleteReason(stringValue(record.IncompleteDetails.Reason)),
+		}
+	}
+	if usage := record.Usage; usage != nil {
+		agentMessage.Usage = &messages.ResponseUsage{
+			InputTokens:  usage.InputTokens,
+			OutputTokens: usage.OutputTokens,
+			TotalTokens:  usage.TotalTokens,
+		}
+		if usage.InputTokensDetails != nil {
+			agentMessage.Usage.InputTokensDetails.CachedTokens = int(usage.InputTokensDetails.CachedTokens)
+		}
+		if usage.OutputTokensDetails != nil {
+			agentMessage.Usage.OutputTokensDetails.ReasoningTokens = int(usage.OutputTokensDetails.ReasoningTokens)
+		}
+	}
+	return agentMessage
+}
+
+func outputItemToRecord(item messages.MessageItem) *vtri.ChatAiChat_OutputItem {
+	switch item := item.(type) {
+	case *messages.OutputMessage:
+		content := make([]*vtri.ChatAiChat_OutputContent, 0, len(item.Content))
+		for _, part := range item.Content {
+			switch part := part.(type) {
+			case *messages.OutputTextContent:
+				content = append(content, &vtri.ChatAiChat_OutputContent{
+					ChatAiChat_OutputTextContent: &vtri.ChatAiChat_OutputTextContent{
+						Type:        part.Type,
+						Text:        part.Text,
+						Annotations: annotationsToRecord(part.Annotations),
+					},
+				})
+			case *messages.RefusalContent:
+				content = append(content, &vtri.ChatAiChat_OutputContent{
+					ChatAiChat_RefusalContent: &vtri.ChatAiChat_RefusalContent{Type: part.Type, Refusal: part.Refusal},
+				})
+			}
+		}
+		return &vtri.ChatAiChat_OutputItem{ChatAiChat_OutputMessage: &vtri.ChatAiChat_OutputMessage{
+			Id:      item.ID,
+			Type:    item.Type,
+			Role:    item.Role,
+			Status:  item.Status,
+			Content: content,
+		}}
+	case *messages.FunctionToolCall:
+		return &vtri.ChatAiChat_OutputItem{ChatAiChat_FunctionToolCall: &vtri.ChatAiChat_FunctionToolCall{
+			Id:        item.ID,
+			Type:      item.Type,
+			Name:      item.Name,
+			Status:    item.Status,
+			Arguments: optionalString(item.Arguments),
+		}}
+	case *messages.ReasoningItem:
+		summary := make([]*vtri.ChatAiChat_ReasoningItem_Summary_Elem, 0, len(item.Summary))
+		for _, text := range item.Summary {
+			summary = append(summary, &vtri.ChatAiChat_ReasoningItem_Summary_Elem{Type: text.Type, Text: text.Text})
+		}
+		return &vtri.ChatAiChat_OutputItem{ChatAiChat_ReasoningItem: &vtri.ChatAiChat_ReasoningItem{
+			Id:      item.ID,
+			Type:    item.Type,
+			Status:  optionalString(item.Status),
+			Summary: summary,
+		}}
+	default:
+		return nil
+	}
+}
+
+func recordToOutputItem(outputItem *vtri.ChatAiChat_OutputItem) messages.MessageItem {
+	switch {
+	case outputItem.ChatAiChat_OutputMessage != nil:
+		record := outputItem.ChatAiChat_OutputMessage
+		content := make([]messages.OutputContent, 0, len(record.Content))
+		for _, part := range record.Content {
+			switch {
+			case part.ChatAiChat_OutputTextContent != nil:
+				content = append(content, &messages.OutputTextContent{
+					Type:        part.ChatAiChat_OutputTextContent.Type,
+					Text:        part.ChatAiChat_OutputTextContent.Text,
+					Annotations: recordToAnnotations(part.ChatAiChat_OutputTextContent.Annotations),
+				})
+			case part.ChatAiChat_RefusalContent != nil:
+				content = append(content, &messages.RefusalContent{
+					Type:    part.ChatAiChat_RefusalContent.Type,
+					Refusal: part.ChatAiChat_RefusalContent.Refusal,
+				})
+			}
+		}
+		return &messages.OutputMessage{
+			ID:      record.Id,
+			Type:    record.Type,
+			Role:    record.Role,
+			Status:  record.Status,
+			Content: content,
+		}
+	case outputItem.ChatAiChat_FunctionToolCall != nil:
+		record := outputItem.ChatAiChat_FunctionToolCall
+		return &messages.FunctionToolCall{
+			ID:        record.Id,
+			Type:      record.Type,
+			Name:      record.Name,
+			Status:    record.Status,
+			Arguments: stringValue(record.Arguments),
+		}
+	case outputItem.ChatAiChat_ReasoningItem != nil:
+		record := outputItem.ChatAiChat_ReasoningItem
+		summary := make([]messages.SummaryText, 0, len(record.Summary))
+		for _, text := range record.Summary {
+			summary = append(summary, messages.SummaryText{Type: text.Type, Text: text.Text})
+		}
+		return &messages.ReasoningItem{
+			ID:      record.Id,
+			Type:    record.Type,
+			Status:  stringValue(record.Status),
+			Summary: summary,
+		}
+	default:
+		return nil
+	}
+}
+
+// annotationsToRecord 记录中只定义了文件和 URL 引用
+func annotationsToRecord(annotations []messages.Annotation) []*vtri.ChatAiChat_Annotation {
+	if len(annotations) == 0 {
+		return nil
+	}
+	result := make([]*vtri.ChatAiChat_Annotation, 0, len(annotations))
+	for _, annotation := range annotations {
+		switch annotation := annotation.(type) {
+		case *messages.FileCitationBody:
+			result = append(result, &vtri.ChatAiChat_Annotation{ChatAiChat_FileCitationBody: &vtri.ChatAiChat_FileCitationBody{
+				Type:   annotation.Type,
+				FileId: annotation.FileID,
+				Index:  int64(annotation.Index),
+			}})
+		case *messages.UrlCitationBody:
+			result = append(result, &vtri.ChatAiChat_Annotation{ChatAiChat_UrlCitationBody: &vtri.ChatAiChat_UrlCitationBody{
+				Type:       annotation.Type,
+				Url:        annotation.URL,
+				Title:      annotation.Title,
+				StartIndex: int64(annotation.StartIndex),
+				EndIndex:   int64(annotation.EndIndex),
+			}})
+		}
+	}
+	return result
+}
+
+func recordToAnnotations(annotations []*vtri.ChatAiChat_Annotation) []messages.Annotation {
+	if len(annotations) == 0 {
+		return nil
+	}
+	result := make([]messages.Annotation, 0, len(annotations))
+	for _, annotation := range annotations {
+		switch {
+		case annotation.ChatAiChat_FileCitationBody != nil:
+			body := annotation.ChatAiChat_FileCitationBody
+			result = append(result, &messages.FileCitationBody{Type: body.Type, FileID: body.FileId, Index: int(body.Index)})
+		case annotation.ChatAiChat_UrlCitationBody != nil:
+			body := annotation.ChatAiChat_UrlCitationBody
+			result = append(result, &messages.UrlCitationBody{
+				Type:       body.Type,
+				URL:        body.Url,
+				Title:      body.Title,
+				StartIndex: int(body.StartIndex),
+				EndIndex:   int(body.EndIndex),
+			})
+		}
+	}
+	return result
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -2,50 +2,210 @@ package services
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
 
 	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/types"
+)
+
+var (
+	ErrUnknownFeed   = errors.New("未知的 feed")
+	ErrInvalidCursor = errors.New("无效的游标")
+	ErrViewerNeeded  = errors.New("该 feed 需要登录")
 )
 
-type FeedGenerator struct {
+// FeedQuery 一次 feed 请求的参数
+type FeedQuery struct {
+	Viewer      string // 当前用户 DID, 未登录时为空
+	Limit       int
+	Cursor      string
+	WithReplies bool // 只对 author feed 生效
+	Debug       bool // 排序 feed 返回得分明细
+}
+
+// FeedGenerator 按名称选出的 feed 实现, 返回 moment URI 列表和下一页游标
+type FeedGenerator interface {
+	GenerateFeed(ctx context.Context, query *FeedQuery) ([]string, string, error)
+}
+
+// FeedExplainer 可以解释排序结果的 feed, 额外返回每条的得分明细
+type FeedExplainer interface {
+	ExplainFeed(ctx context.Context, query *FeedQuery) ([]string, string, map[string]*types.ScoreExplanation, error)
+}
+
+// NewFeedGenerator 按名称创建 feed:
+//
+//	default          全站最新
+//	following        当前用户及其关注的人
+//	for_you          按互动、时间、亲密度和兴趣排序的推荐
+//	author:<did>     某个用户的 moment
+//	tag:<tag>        带某个标签的 moment
+//	topic:<topic>    属于某个主题的 moment
+func NewFeedGenerator(metaStore *repositories.MetaStore, name string) (FeedGenerator, error) {
+	kind, param, _ := strings.Cut(name, ":")
+	switch kind {
+	case "default":
+		return &LatestFeedGenerator{metaStore: metaStore}, nil
+	case "following":
+		return &FollowingFeedGenerator{metaStore: metaStore}, nil
+	case "for_you":
+		return NewRankedFeedGenerator(metaStore), nil
+	case "author":
+		if _, err := syntax.ParseDID(param); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownFeed, name)
+		}
+		return &AuthorFeedGenerator{metaStore: metaStore, did: param}, nil
+	case "tag":
+		if param == "" {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownFeed, name)
+		}
+		return &TagFeedGenerator{metaStore: metaStore, tag: param}, nil
+	case "topic":
+		if param == "" {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownFeed, name)
+		}
+		return &TopicFeedGenerator{metaStore: metaStore, topic: param}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFeed, name)
+	}
+}
+
+type LatestFeedGenerator struct {
 	metaStore *repositories.MetaStore
 }
 
-func NewFeedGenerator(metaStore *repositories.MetaStore) *FeedGenerator {
-	return &FeedGenerator{metaStore: metaStore}
+func (f *LatestFeedGenerator) GenerateFeed(ctx context.Context, query *FeedQuery) ([]string, string, error) {
+	before, err := decodeFeedCursor(query.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	moments, err := f.metaStore.MomentRepo.GetLatestMoments(query.Limit, before)
+	if err != nil {
+		return nil, "", err
+	}
+	return feedPage(moments, query.Limit)
 }
 
-func (f *FeedGenerator) GenerateFeed(ctx context.Context, limit int, cursor string) ([]string, string, error) {
-	var uris []string
-	var nextCursor string
+// FollowingFeedGenerator 当前用户和其关注的人发布的 moment, 包含回复
+type FollowingFeedGenerator struct {
+	metaStore *repositories.MetaStore
+}
 
-	uris, err := f.metaStore.MomentRepo.GetLatestMomentURIs(limit, cursor)
+func (f *FollowingFeedGenerator) GenerateFeed(ctx context.Context, query *FeedQuery) ([]string, string, error) {
+	if query.Viewer == "" {
+		return nil, "", ErrViewerNeeded
+	}
+	before, err := decodeFeedCursor(query.Cursor)
 	if err != nil {
 		return nil, "", err
 	}
 
-	if len(uris) >= limit {
-		nextCursor = uris[len(uris)-1]
-	} else {
-		nextCursor = ""
+	following, err := f.metaStore.GraphRepo.GetFollowingDIDs(query.Viewer)
+	if err != nil {
+		return nil, "", fmt.Errorf("获取关注列表失败: %w", err)
 	}
+	creators := append(following, query.Viewer)
 
-	return uris, nextCursor, nil
+	moments, err := f.metaStore.MomentRepo.GetMomentsByCreators(creators, true, query.Limit, before)
+	if err != nil {
+		return nil, "", err
+	}
+	return feedPage(moments, query.Limit)
+}
+
+type AuthorFeedGenerator struct {
+	metaStore *repositories.MetaStore
+	did       string
+}
+
+func (f *AuthorFeedGenerator) GenerateFeed(ctx context.Context, query *FeedQuery) ([]string, string, error) {
+	before, err := decodeFeedCursor(query.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	moments, err := f.metaStore.MomentRepo.GetMomentsByCreators([]string{f.did}, query.WithReplies, query.Limit, before)
+	if err != nil {
+		return nil, "", err
+	}
+	return feedPage(moments, query.Limit)
 }
 
-type MockFeedGenerator struct {
+type TagFeedGenerator struct {
 	metaStore *repositories.MetaStore
+	tag       string
 }
 
-func NewMockFeedGenerator(metaStore *repositories.MetaStore) *MockFeedGenerator {
-	return &MockFeedGenerator{metaStore: metaStore}
+func (f *TagFeedGenerator) GenerateFeed(ctx context.Context, query *FeedQuery) ([]string, string, error) {
+	before, err := decodeFeedCursor(query.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	moments, err := f.metaStore.MomentRepo.GetMomentsByTag(f.tag, query.Limit, before)
+	if err != nil {
+		return nil, "", err
+	}
+	return feedPage(moments, query.Limit)
+}
+
+type TopicFeedGenerator struct {
+	metaStore *repositories.MetaStore
+	topic     string
+}
+
+func (f *TopicFeedGenerator) GenerateFeed(ctx context.Context, query *FeedQuery) ([]string, string, error) {
+	before, err := decodeFeedCursor(query.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	moments, err := f.metaStore.MomentRepo.GetMomentsByTopic(f.topic, query.Limit, before)
+	if err != nil {
+		return nil, "", err
+	}
+	return feedPage(moments, query.Limit)
 }
 
-func (f *MockFeedGenerator) GenerateFeed(ctx context.Context, limit int, cursor string) ([]string, string, error) {
-	uris := []string{
-		"at://did:plc:example1/app.vtri.activity.moment/1",
-		"at://did:plc:example2/app.vtri.activity.moment/2",
-		"at://did:plc:example3/app.vtri.activity.moment/3",
+// feedPage 取出 URI, 满页时以最后一条的 (indexed_at, id) 作为下一页游标
+func feedPage(moments []*repositories.Moment, limit int) ([]string, string, error) {
+	uris := make([]string, 0, len(moments))
+	for _, moment := range moments {
+		uris = append(uris, moment.URI)
+	}
+
+	var nextCursor string
+	if limit > 0 && len(moments) >= limit {
+		last := moments[len(moments)-1]
+		nextCursor = encodeFeedCursor(&repositories.FeedKey{IndexedAt: last.IndexedAt, ID: last.ID})
 	}
-	nextCursor := "mock_cursor_value"
 	return uris, nextCursor, nil
 }
+
+// 游标对客户端不透明, 内容为 "<indexed_at>::<id>" 的 base64url 编码
+func encodeFeedCursor(key *repositories.FeedKey) string {
+	raw := strconv.FormatInt(key.IndexedAt, 10) + "::" + key.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeFeedCursor(cursor string) (*repositories.FeedKey, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	indexedAt, id, ok := strings.Cut(string(raw), "::")
+	if !ok || id == "" {
+		return nil, ErrInvalidCursor
+	}
+	value, err := strconv.ParseInt(indexedAt, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &repositories.FeedKey{IndexedAt: value, ID: id}, nil
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+)
+
+const rankViewer = "did:plc:viewer"
+
+// rankSeed 向测试库写入 moment 及互动, 时间都相对 now
+type rankSeed struct {
+	t     *testing.T
+	store *repositories.MetaStore
+	now   time.Time
+	fans  int
+}
+
+func momentURI(id string) string {
+	return "at://did:plc:author/app.vtri.activity.moment/" + id
+}
+
+func (s *rankSeed) moment(id string, creator string, age time.Duration) *repositories.Moment {
+	s.t.Helper()
+	at := s.now.Add(-age).Unix()
+	moment := &repositories.Moment{ID: id, URI: momentURI(id), Text: id, Creator: creator, CreatedAt: at, IndexedAt: at}
+	if err := s.store.MomentRepo.CreateMoment(moment); err != nil {
+		s.t.Fatalf("create moment %s: %v", id, err)
+	}
+	return moment
+}
+
+func (s *rankSeed) reply(id string, creator string, parentID string, age time.Duration) {
+	s.t.Helper()
+	s.moment(id, creator, age)
+	if err := s.store.MomentRepo.UpdateMoment(momentURI(id), map[string]interface{}{"reply_parent_id": parentID, "reply_root_id": parentID}); err != nil {
+		s.t.Fatalf("update reply %s: %v", id, err)
+	}
+	s.refresh(parentID)
+}
+
+func (s *rankSeed) tag(id string, tag string) {
+	s.t.Helper()
+	err := s.store.MomentRepo.CreateActivityTag(&repositories.ActivityTag{ID: id + "-" + tag, SubjectURI: momentURI(id), Tag: tag, CreatedAt: s.now.Add(-time.Hour).Unix()})
+	if err != nil {
+		s.t.Fatalf("tag %s: %v", id, err)
+	}
+}
+
+func (s *rankSeed) relate(creator string, predicate string, object string) {
+	s.t.Helper()
+	id := fmt.Sprintf("%s-%s-%s", creator, predicate, object)
+	err := s.store.GraphRepo.CreateRelationship(&repositories.Relationship{
+		ID:        id,
+		URI:       fmt.Sprintf("at://%s/app.vtri.activity.relationship/%s", creator, id),
+		Creator:   creator,
+		Predicate: predicate,
+		Object:    object,
+		CreatedAt: s.now.Add(-time.Hour).Unix(),
+	})
+	if err != nil {
+		s.t.Fatalf("relationship %s: %v", id, err)
+	}
+}
+
+func feedIDs(uris []string) string {
+	ids := make([]string, 0, len(uris))
+	for _, uri := range uris {
+		ids = append(ids, uri[strings.LastIndex(uri, "/")+1:])
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestFeedCursorRoundTrip(t *testing.T) {
+	key := &repositories.FeedKey{IndexedAt: 1730000000, ID: "3l::odd"}
+	decoded, err := decodeFeedCursor(encodeFeedCursor(key))
+	if err != nil || *decoded != *key {
+		t.Fatalf("decode(encode(%+v)) = %+v, %v", key, decoded, err)
+	}
+	if decoded, err := decodeFeedCursor(""); decoded != nil || err != nil {
+		t.Fatalf("empty cursor = %+v, %v, want nil", decoded, err)
+	}
+
+	encode := func(raw string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(raw))
+	}
+	for _, cursor := range []string{"not base64!", encode("1730000000"), encode("1730000000::"), encode("soon::3l")} {
+		if _, err := decodeFeedCursor(cursor); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("decode %q = %v, want ErrInvalidCursor", cursor, err)
+		}
+	}
+}
+
+func TestFeedGenerators(t *testing.T) {
+	store := newTestMetaStore(t)
+	s := &rankSeed{t: t, store: store, now: time.Now()}
+	s.moment("v1", rankViewer, 30*time.Minute)
+	s.moment("a1", "did:plc:alice", time.Hour)
+	s.moment("b1", "did:plc:bob", 3*time.Hour)
+	s.reply("a2", "did:plc:alice", "b1", 2*time.Hour)
+	s.moment("c1", "did:plc:carol", 4*time.Hour)
+	s.tag("a1", "travel")
+	s.tag("c1", "travel")
+	s.tag("b1", "food")
+	if err := store.MomentRepo.CreateActivityTopic(&repositories.ActivityTopic{ID: "b1-cities", SubjectURI: momentURI("b1"), Topic: "cities"}); err != nil {
+		t.Fatalf("topic: %v", err)
+	}
+	s.relate(rankViewer, repositories.RelationshipFollow, "did:plc:alice")
+
+	tests := []struct {
+		feed  string
+		query FeedQuery
+		want  string
+	}{
+		{"default", FeedQuery{}, "v1,a1,a2,b1,c1"},
+		// 关注的人的回复也出现在 following 中
+		{"following", FeedQuery{Viewer: rankViewer}, "v1,a1,a2"},
+		{"author:did:plc:alice", FeedQuery{}, "a1"},
+		{"author:did:plc:alice", FeedQuery{WithReplies: true}, "a1,a2"},
+		{"tag:travel", FeedQuery{}, "a1,c1"},
+		{"tag:none", FeedQuery{}, ""},
+		{"topic:cities", FeedQuery{}, "b1"},
+	}
+	for _, tt := range tests {
+		generator, err := NewFeedGenerator(store, tt.feed)
+		if err != nil {
+			t.Fatalf("new feed %s: %v", tt.feed, err)
+		}
+		query := tt.query
+		query.Limit = 10
+		uris, cursor, err := generator.GenerateFeed(context.Background(), &query)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.feed, err)
+		}
+		if got := feedIDs(uris); got != tt.want || cursor != "" {
+			t.Errorf("%s %+v = %q (cursor %q), want %q without cursor", tt.feed, tt.query, got, cursor, tt.want)
+		}
+	}
+
+	following, _ := NewFeedGenerator(store, "following")
+	if _, _, err := following.GenerateFeed(context.Background(), &FeedQuery{Limit: 10}); !errors.Is(err, ErrViewerNeeded) {
+		t.Errorf("following without viewer = %v, want ErrViewerNeeded", err)
+	}
+	for _, name := range []string{"unknown", "author:alice", "tag:", "topic:"} {
+		if _, err := NewFeedGenerator(store, name); !errors.Is(err, ErrUnknownFeed) {
+			t.Errorf("feed %q = %v, want ErrUnknownFeed", name, err)
+		}
+	}
+}
+
+func TestFeedGeneratorPagesThroughTies(t *testing.T) {
+	store := newTestMetaStore(t)
+	s := &rankSeed{t: t, store: store, now: time.Now()}
+	// 同一秒索引的 moment 按 id 倒序, 翻页时不能重复或遗漏
+	for _, id := range []string{"m3", "m1", "m5", "m2", "m4"} {
+		s.moment(id, "did:plc:alice", time.Hour)
+		s.tag(id, "travel")
+	}
+	s.moment("m0", "did:plc:alice", 2*time.Hour)
+	s.tag("m0", "travel")
+
+	for _, name := range []string{"default", "author:did:plc:alice", "tag:travel"} {
+		generator, err := NewFeedGenerator(store, name)
+		if err != nil {
+			t.Fatalf("new feed %s: %v", name, err)
+		}
+		var pages []string
+		cursor := ""
+		for i := 0; i < 10; i++ {
+			uris, next, err := generator.GenerateFeed(context.Background(), &FeedQuery{Limit: 2, Cursor: cursor})
+			if err != nil {
+				t.Fatalf("%s page %d: %v", name, i, err)
+			}
+			pages = append(pages, feedIDs(uris))
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		// 最后一页满页时还会返回游标, 再取一次得到空页
+		if got := strings.Join(pages, "|"); got != "m5,m4|m3,m2|m1,m0|" {
+			t.Errorf("%s pages = %q, want m5,m4|m3,m2|m1,m0|", name, got)
+		}
+	}
+
+	latest, _ := NewFeedGenerator(store, "default")
+	if _, _, err := latest.GenerateFeed(context.Background(), &FeedQuery{Limit: 2, Cursor: "%%%"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("invalid cursor = %v, want ErrInvalidCursor", err)
+	}
+}
@@ -13,55 +13,86 @@ import (
 )
 
 type FeedService struct {
-	metaStore         *repositories.MetaStore
-	feedGenerator     *FeedGenerator
-	mockFeedGenerator *MockFeedGenerator
-	momentService     *MomentService
-	imageBuilder      *blobs.ImageUriBuilder
+	metaStore     *repositories.MetaStore
+	momentService *MomentService
+	imageBuilder  *blobs.ImageUriBuilder
 }
 
 func NewFeedService(config *config.SocialConfig, metaStore *repositories.MetaStore) *FeedService {
 	return &FeedService{
-		metaStore:         metaStore,
-		feedGenerator:     NewFeedGenerator(metaStore),
-		mockFeedGenerator: NewMockFeedGenerator(metaStore),
-		momentService:     NewMomentService(metaStore),
-		imageBuilder:      blobs.NewImageUriBuilder(config.Server.Domain),
+		metaStore:     metaStore,
+		momentService: NewMomentService(config, metaStore),
+		imageBuilder:  blobs.NewImageUriBuilder(config.Server.Domain),
 	}
 }
 
-func (s *FeedService) Feeds(ctx context.Context, feedName string, limit int, cursor string) (*types.Feeds, error) {
-	var uris []string
-	var nextCursor string
-	var err error
+func (s *FeedService) Feeds(ctx context.Context, feedName string, query *FeedQuery) (*types.Feeds, error) {
+	feeds := &types.Feeds{}
 
-	feeds := &types.Feeds{
-		Feed:   nil,
-		Cursor: nextCursor,
+	generator, err := NewFeedGenerator(s.metaStore, feedName)
+	if err != nil {
+		return feeds, err
 	}
 
-	switch feedName {
-	case "default":
-		uris, nextCursor, err = s.feedGenerator.GenerateFeed(ctx, limit, cursor)
-	default:
-		uris, nextCursor, err = s.mockFeedGenerator.GenerateFeed(ctx, limit, cursor)
+	var uris []string
+	var nextCursor string
+	if explainer, ok := generator.(FeedExplainer); ok && query.Debug {
+		uris, nextCursor, feeds.Debug, err = explainer.ExplainFeed(ctx, query)
+	} else {
+		uris, nextCursor, err = generator.GenerateFeed(ctx, query)
 	}
-
 	if err != nil {
 		return feeds, err
 	}
 
-	hydrationState, err := s.hydrate(ctx, uris)
+	hydrationState, err := s.hydrate(ctx, query.Viewer, uris)
 	if err != nil {
 		return feeds, err
 	}
 
-	cards := s.presentCards(uris, hydrationState)
-	feeds.Feed = cards
+	feeds.Feed = s.presentCards(uris, hydrationState)
+	feeds.Cursor = nextCursor
 	return feeds, nil
 }
 
-func (s *FeedService) MomentThread(ctx context.Context, uri string, depth int) (*types.MomentThread, error) {
+// FeedSkeleton 只返回 moment URI 和游标, 供 app.bsky.feed.getFeedSkeleton 使用, 由客户端的 AppView 负责补全内容.
+// 屏蔽的作者和因审核不可见的 moment 与完整 feed 一样不返回
+func (s *FeedService) FeedSkeleton(ctx context.Context, feedName string, query *FeedQuery) ([]string, string, error) {
+	generator, err := NewFeedGenerator(s.metaStore, feedName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	uris, nextCursor, err := generator.GenerateFeed(ctx, query)
+	if err != nil {
+		return nil, "", err
+	}
+
+	blocked, err := s.blockedSet(query.Viewer)
+	if err != nil {
+		return nil, "", err
+	}
+	labels, err := s.metaStore.ModRepo.GetLabelsByURIs(uris)
+	if err != nil {
+		return nil, "", err
+	}
+	skeleton := make([]string, 0, len(uris))
+	for _, uri := range uris {
+		aturi, err := helper.BuildAtURI(uri)
+		if err != nil {
+			log.Printf("无法解析记录 URI: %s", err)
+			continue
+		}
+		author := aturi.Authority().String()
+		if blocked[author] || hiddenByLabels(labels[uri], query.Viewer, author) {
+			continue
+		}
+		skeleton = append(skeleton, uri)
+	}
+	return skeleton, nextCursor, nil
+}
+
+func (s *FeedService) MomentThread(ctx context.Context, viewer string, uri string, depth int) (*types.MomentThread, error) {
 	aturi, err := helper.BuildAtURI(uri)
 	if err != nil {
 		return nil, err
@@ -71,17 +102,34 @@ func (s *FeedService) MomentThread(ctx context.Context, uri string, depth int) (
 		return nil, fmt.Errorf("只能获取 moment 记录的帖子")
 	}
 
-	momentID := string(aturi.RecordKey())
+	// 不同仓库的 moment 可能使用相同的 rkey, 按 URI 找到本地的 moment ID
+	root, err := s.metaStore.MomentRepo.GetMomentByURI(uri)
+	if err != nil {
+		return nil, fmt.Errorf("获取 moment 失败: %w", err)
+	}
 
 	if depth <= 0 {
 		depth = 10 // 默认最大深度
 	}
 
-	allMoments, err := s.metaStore.MomentRepo.GetMomentThread(momentID, depth, depth)
+	allMoments, err := s.metaStore.MomentRepo.GetMomentThread(root.ID, depth, depth)
 	if err != nil {
 		return nil, fmt.Errorf("获取 thread moments 失败: %w", err)
 	}
 
+	// 与 viewer 存在屏蔽关系的作者的 moment 及其下的回复不展示
+	blocked, err := s.blockedSet(viewer)
+	if err != nil {
+		return nil, err
+	}
+	visibleMoments := make([]*repositories.Moment, 0, len(allMoments))
+	for _, moment := range allMoments {
+		if !blocked[moment.Creator] {
+			visibleMoments = append(visibleMoments, moment)
+		}
+	}
+	allMoments = visibleMoments
+
 	if len(allMoments) == 0 {
 		return nil, fmt.Errorf("未找到指定的 moment")
 	}
@@ -91,12 +139,20 @@ func (s *FeedService) MomentThread(ctx context.Context, uri string, depth int) (
 		momentURIs[i] = moment.URI
 	}
 
-	hydrationState, err := s.hydrate(ctx, momentURIs)
+	hydrationState, err := s.hydrate(ctx, viewer, momentURIs)
 	if err != nil {
 		return nil, fmt.Errorf("水合数据失败: %w", err)
 	}
 
-	thread, err := s.buildMomentThread(momentID, allMoments, hydrationState)
+	// 因审核不可见的 moment 不在水合结果中, 其下的回复一并不展示
+	hydratedMoments := make([]*repositories.Moment, 0, len(allMoments))
+	for _, moment := range allMoments {
+		if _, ok := hydrationState[moment.URI]; ok {
+			hydratedMoments = append(hydratedMoments, moment)
+		}
+	}
+
+	thread, err := s.buildMomentThread(root.ID, hydratedMoments, hydrationState)
 	if err != nil {
 		return nil, fmt.Errorf("构建 thread 结构失败: %w", err)
 	}
@@ -104,7 +160,7 @@ func (s *FeedService) MomentThread(ctx context.Context, uri string, depth int) (
 	return thread, nil
 }
 
-func (s *FeedService) hydrate(ctx context.Context, uris []string) (map[string]interface{}, error) {
+func (s *FeedService) hydrate(ctx context.Context, viewer string, uris []string) (map[string]interface{}, error) {
 	dids := make([]string, 0, len(uris))
 	hydrationState := make(map[string]interface{})
 
@@ -125,7 +181,12 @@ func (s *FeedService) hydrate(ctx context.Context, uris []string) (map[string]in
 		}
 	}
 
-	moments, momentDids, err := s.hydrateMoments(ctx, momentURIs)
+	blocked, err := s.blockedSet(viewer)
+	if err != nil {
+		return nil, err
+	}
+
+	moments, momentDids, err := s.hydrateMoments(ctx, viewer, momentURIs, blocked)
 	if err != nil {
 		return nil, err
 	}
@@ -145,7 +206,8 @@ func (s *FeedService) hydrate(ctx context.Context, uris []string) (map[string]in
 	return hydrationState, nil
 }
 
-func (s *FeedService) hydrateMoments(ctx context.Context, uris []string) (map[string]interface{}, []string, error) {
+// hydrateMoments 屏蔽的作者和因审核不可见的 moment 不返回, 审核标签以 labels:<uri> 为键
+func (s *FeedService) hydrateMoments(ctx context.Context, viewer string, uris []string, blocked map[string]bool) (map[string]interface{}, []string, error) {
 	hydrationState := make(map[string]interface{})
 	dids := make([]string, 0, len(uris))
 
@@ -157,11 +219,27 @@ func (s *FeedService) hydrateMoments(ctx context.Context, uris []string) (map[st
 		}
 		batchURIs := uris[i:end]
 
-		moments, err := s.metaStore.MomentRepo.GetMomentsByURIs(batchURIs)
+		records, err := s.metaStore.MomentRepo.GetMomentsByURIs(batchURIs)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		labels, err := s.metaStore.ModRepo.GetLabelsByURIs(batchURIs)
 		if err != nil {
 			return nil, nil, err
 		}
 
+		moments := make([]*repositories.Moment, 0, len(records))
+		for _, record := range records {
+			if blocked[record.Creator] || hiddenByLabels(labels[record.URI], viewer, record.Creator) {
+				continue
+			}
+			moments = append(moments, record)
+			if len(labels[record.URI]) > 0 {
+				hydrationState["labels:"+record.URI] = labels[record.URI]
+			}
+		}
+
 		momentIDs := make([]string, 0, len(moments))
 		momentURIs := make([]string, 0, len(moments))
 		for _, record := range moments {
@@ -194,6 +272,11 @@ func (s *FeedService) hydrateMoments(ctx context.Context, uris []string) (map[st
 			return nil, nil, err
 		}
 
+		aggs, err := s.metaStore.MomentRepo.GetMomentAggs(momentURIs)
+		if err != nil {
+			return nil, nil, err
+		}
+
 		for _, record := range moments {
 			images := images[record.ID]
 			video := videos[record.ID]
@@ -201,6 +284,10 @@ func (s *FeedService) hydrateMoments(ctx context.Context, uris []string) (map[st
 			activityTags := activityTags[record.URI]
 			activityTopics := activityTopics[record.URI]
 			moment := s.momentService.ConvertDBToMoment(record, images, video, external, activityTags, activityTopics)
+			if agg, ok := aggs[record.URI]; ok {
+				moment.LikeCount = int64(agg.LikeCount)
+				moment.ReplyCount = int64(agg.ReplyCount)
+			}
 			hydrationState[record.URI] = moment
 
 			dids = append(dids, record.Creator)
@@ -241,10 +328,43 @@ func (s *FeedService) hydrateProfiles(ctx context.Context, dids []string) (map[s
 		for _, avatar := range avatars {
 			hydrationState["profile:"+avatar.Did] = avatar
 		}
+
+		followers, err := s.metaStore.GraphRepo.CountFollowers(batchDIDs)
+		if err != nil {
+			return nil, err
+		}
+		following, err := s.metaStore.GraphRepo.CountFollowing(batchDIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, did := range batchDIDs {
+			hydrationState["graph:"+did] = &graphCounts{
+				Followers: followers[did],
+				Following: following[did],
+			}
+		}
 	}
 	return hydrationState, nil
 }
 
+type graphCounts struct {
+	Followers int64
+	Following int64
+}
+
+// blockedSet 与 viewer 存在屏蔽关系的用户, 未登录时为空
+func (s *FeedService) blockedSet(viewer string) (map[string]bool, error) {
+	dids, err := s.metaStore.MomentRepo.GetBlockedDIDs(viewer)
+	if err != nil {
+		return nil, fmt.Errorf("获取屏蔽关系失败: %w", err)
+	}
+	blocked := make(map[string]bool, len(dids))
+	for _, did := range dids {
+		blocked[did] = true
+	}
+	return blocked, nil
+}
+
 func (s *FeedService) presentCards(uris []string, hydrationState map[string]interface{}) []*types.FeedCard {
 	var cards []*types.FeedCard
 
@@ -275,6 +395,10 @@ func (s *FeedService) presentCards(uris []string, hydrationState map[string]inte
 				authorView.Avatar = avatarURL
 				authorView.CreatedAt = authorProfile.CreatedAt
 			}
+			if counts, ok := hydrationState["graph:"+authorDID].(*graphCounts); ok {
+				authorView.FollowersCount = counts.Followers
+				authorView.FollowingCount = counts.Following
+			}
 
 			var embed *types.EmbedView
 			if moment.Embed != nil {
@@ -340,19 +464,24 @@ func (s *FeedService) presentCards(uris []string, hydrationState map[string]inte
 			}
 
 			momentCard := &types.MomentCard{
-				ID:        moment.ID,
-				URI:       moment.URI,
-				CID:       moment.CID,
-				Text:      moment.Text,
-				Facets:    moment.Facets,
-				Reply:     moment.Reply,
-				Embed:     embed,
-				Langs:     moment.Langs,
-				Tags:      tagViews,
-				Topics:    topicViews,
-				CreatedAt: moment.CreatedAt,
-				UpdatedAt: moment.UpdatedAt,
-				Author:    authorView,
+				ID:         moment.ID,
+				URI:        moment.URI,
+				CID:        moment.CID,
+				Text:       moment.Text,
+				Facets:     moment.Facets,
+				Reply:      moment.Reply,
+				Embed:      embed,
+				Langs:      moment.Langs,
+				Tags:       tagViews,
+				Topics:     topicViews,
+				ReplyCount: int(moment.ReplyCount),
+				LikeCount:  int(moment.LikeCount),
+				CreatedAt:  moment.CreatedAt,
+				UpdatedAt:  moment.UpdatedAt,
+				Author:     authorView,
+			}
+			if labels, ok := hydrationState["labels:"+uri].([]*repositories.ContentLabel); ok {
+				momentCard.Labels = labelViews(labels)
 			}
 
 			cards = append(cards, &types.FeedCard{
@@ -438,6 +567,10 @@ func (s *FeedService) buildMomentCard(moment *repositories.Moment, hydrationStat
 		authorView.Avatar = avatarURL
 		authorView.CreatedAt = authorProfile.CreatedAt
 	}
+	if counts, ok := hydrationState["graph:"+moment.Creator].(*graphCounts); ok {
+		authorView.FollowersCount = counts.Followers
+		authorView.FollowingCount = counts.Following
+	}
 
 	// 构建嵌入内容视图
 	var embed *types.EmbedView
@@ -504,20 +637,28 @@ func (s *FeedService) buildMomentCard(moment *repositories.Moment, hydrationStat
 		})
 	}
 
+	var labels []*types.LabelView
+	if contentLabels, ok := hydrationState["labels:"+moment.URI].([]*repositories.ContentLabel); ok {
+		labels = labelViews(contentLabels)
+	}
+
 	return &types.MomentCard{
-		ID:        momentData.ID,
-		URI:       moment.URI,
-		CID:       moment.CID,
-		Text:      momentData.Text,
-		Facets:    momentData.Facets,
-		Reply:     momentData.Reply,
-		Embed:     embed,
-		Langs:     momentData.Langs,
-		Tags:      tagViews,
-		Topics:    topicViews,
-		CreatedAt: momentData.CreatedAt,
-		UpdatedAt: momentData.UpdatedAt,
-		Author:    authorView,
+		ID:         momentData.ID,
+		URI:        moment.URI,
+		CID:        moment.CID,
+		Text:       momentData.Text,
+		Facets:     momentData.Facets,
+		Reply:      momentData.Reply,
+		Embed:      embed,
+		Langs:      momentData.Langs,
+		Tags:       tagViews,
+		Topics:     topicViews,
+		ReplyCount: int(momentData.ReplyCount),
+		LikeCount:  int(momentData.LikeCount),
+		CreatedAt:  momentData.CreatedAt,
+		UpdatedAt:  momentData.UpdatedAt,
+		Author:     authorView,
+		Labels:     labels,
 	}
 }
 
@@ -48,6 +48,32 @@ func (s *MCPService) ListMCPServers(userDid string) ([]*mcp.MCPServerInfo, error
 	return allServers, nil
 }
 
+// ListEnabledMCPServers 获取用户已启用的 MCP Server, 包括内置和用户自行添加的
+func (s *MCPService) ListEnabledMCPServers(userDid string) ([]*mcp.MCPServerInfo, error) {
+	dbServers, err := s.metaStore.MCPRepo.GetMCPServersByUser(userDid)
+	if err != nil {
+		return nil, err
+	}
+
+	servers := make([]*mcp.MCPServerInfo, 0, len(dbServers))
+	for _, dbServer := range dbServers {
+		if !dbServer.Enabled {
+			continue
+		}
+		serverInfo, err := s.convertDBServerToAPIServer(dbServer)
+		if err != nil {
+			logrus.WithError(err).Error("convertDBServerToAPIServer failed")
+			return nil, err
+		}
+		// 用户自行添加且没有授权记录的 server 视为无需授权
+		if s.GetBuiltinServerConfig(serverInfo.McpId) == nil && serverInfo.Authorization.Config == nil {
+			serverInfo.Authorization.Method = mcp.MCPServerAuthorizationMethodNone
+		}
+		servers = append(servers, serverInfo)
+	}
+	return servers, nil
+}
+
 func (s *MCPService) OverrideInstalled(builtinServers []*mcp.MCPServerInfo, dbServers []*mcp.MCPServerInfo) []*mcp.MCPServerInfo {
 	allServers := make([]*mcp.MCPServerInfo, 0)
 	intalled := make(map[string]*mcp.MCPServerInfo)
@@ -0,0 +1,372 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/labeler"
+	"github.com/zhongshangwu/avatarai-social/pkg/moderation"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/search"
+	"github.com/zhongshangwu/avatarai-social/types"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrContentRejected    = errors.New("内容未通过审核")
+	ErrReviewNotPending   = errors.New("审核记录不存在或已处理")
+	ErrUnknownReviewState = errors.New("未知的审核状态")
+)
+
+var (
+	moderationChainOnce sync.Once
+	moderationChain     *moderation.Chain
+)
+
+// sharedModerationChain 进程内共享的审核链, 配置有误时记录日志并关闭审核
+func sharedModerationChain(config *config.SocialConfig) *moderation.Chain {
+	moderationChainOnce.Do(func() {
+		chain, err := moderation.NewChainFromConfig(config)
+		if err != nil {
+			log.Printf("内容审核配置有误, 审核已关闭: %v", err)
+			chain = moderation.NewChain()
+		}
+		moderationChain = chain
+	})
+	return moderationChain
+}
+
+// ModerationService 发布前的内容审核和人工审核队列.
+// label 结论写入标签后正常发布; hold 结论写入 !hide 标签, 内容只对作者可见, 通过审核后才发布;
+// reject 结论只保存审核记录, 内容不写入. 启用 labeler 时, 已发布 moment 的标签同时对外签发
+type ModerationService struct {
+	metaStore *repositories.MetaStore
+	chain     *moderation.Chain
+	labeler   *labeler.Labeler
+}
+
+func NewModerationService(config *config.SocialConfig, metaStore *repositories.MetaStore) *ModerationService {
+	return &ModerationService{
+		metaStore: metaStore,
+		chain:     sharedModerationChain(config),
+		labeler:   labeler.Shared(config, metaStore),
+	}
+}
+
+// Moderate 执行审核链并保存非 allow 的结论, 需要在内容写入之前调用
+func (s *ModerationService) Moderate(ctx context.Context, subject *moderation.Subject) (*moderation.Decision, error) {
+	decision := s.chain.Moderate(ctx, subject)
+	if decision.Verdict == moderation.VerdictAllow {
+		return decision, nil
+	}
+
+	findings, _ := json.Marshal(decision.Findings)
+	record := &repositories.ModerationDecision{
+		SubjectType: subject.Type,
+		Subject:     subject.URI,
+		Author:      subject.Author,
+		Text:        subject.Text,
+		Verdict:     string(decision.Verdict),
+		Labels:      decision.Labels,
+		Findings:    string(findings),
+		Status:      repositories.ModerationStatusAuto,
+		CreatedAt:   time.Now().Unix(),
+	}
+	labels := decision.Labels
+	if decision.Verdict == moderation.VerdictHold {
+		record.Status = repositories.ModerationStatusPending
+		labels = append(labels, moderation.LabelHide)
+	}
+	if err := s.metaStore.ModRepo.CreateDecision(record); err != nil {
+		return nil, fmt.Errorf("保存审核结论失败: %w", err)
+	}
+	if decision.Verdict != moderation.VerdictReject {
+		if err := s.metaStore.ModRepo.AddLabels("", subject.URI, labels); err != nil {
+			return nil, fmt.Errorf("写入审核标签失败: %w", err)
+		}
+	}
+	if decision.Verdict == moderation.VerdictLabel {
+		s.emitLabels(ctx, subject.Type, subject.URI, decision.Labels)
+	}
+	return decision, nil
+}
+
+// Queue 审核记录列表, status 默认为 pending, 游标为最后一条记录的 ID
+func (s *ModerationService) Queue(ctx context.Context, status string, limit int, cursor string) (*types.ModerationQueue, error) {
+	if status == "" {
+		status = repositories.ModerationStatusPending
+	}
+	switch status {
+	case repositories.ModerationStatusAuto, repositories.ModerationStatusPending,
+		repositories.ModerationStatusApproved, repositories.ModerationStatusRejected:
+	default:
+		return nil, ErrUnknownReviewState
+	}
+	var afterID uint
+	if cursor != "" {
+		value, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		afterID = uint(value)
+	}
+
+	decisions, err := s.metaStore.ModRepo.ListDecisions(status, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("获取审核记录失败: %w", err)
+	}
+	pending, err := s.metaStore.ModRepo.CountDecisions(repositories.ModerationStatusPending)
+	if err != nil {
+		return nil, fmt.Errorf("统计待审核记录失败: %w", err)
+	}
+
+	queue := &types.ModerationQueue{
+		Pending: pending,
+		Items:   make([]*types.ModerationItem, 0, len(decisions)),
+	}
+	for _, decision := range decisions {
+		queue.Items = append(queue.Items, moderationItem(decision))
+	}
+	if limit > 0 && len(decisions) >= limit {
+		queue.Cursor = strconv.FormatUint(uint64(decisions[len(decisions)-1].ID), 10)
+	}
+	return queue, nil
+}
+
+// Review 处理一条等待审核的记录. 通过时移除 !hide 标签并发布内容, 拒绝时改为 !takedown,
+// 被拒绝的聊天消息同时标记为删除
+func (s *ModerationService) Review(ctx context.Context, id uint, approve bool, reviewer string, note string) (*types.ModerationItem, error) {
+	status := repositories.ModerationStatusRejected
+	if approve {
+		status = repositories.ModerationStatusApproved
+	}
+
+	// 审核结果、标签和 PDS 同步操作在同一事务中写入
+	var (
+		decision  *repositories.ModerationDecision
+		published *repositories.Moment
+	)
+	err := s.metaStore.WithTransaction(ctx, func(txStore *repositories.MetaStore) error {
+		resolved, err := txStore.ModRepo.ResolveDecision(id, status, reviewer, note)
+		if err != nil {
+			return fmt.Errorf("更新审核记录失败: %w", err)
+		}
+		if !resolved {
+			return ErrReviewNotPending
+		}
+		decision, err = txStore.ModRepo.GetDecision(id)
+		if err != nil {
+			return fmt.Errorf("获取审核记录失败: %w", err)
+		}
+
+		if !approve {
+			if err := txStore.ModRepo.AddLabels("", decision.Subject, []string{moderation.LabelTakedown}); err != nil {
+				return fmt.Errorf("写入审核标签失败: %w", err)
+			}
+		}
+		if err := txStore.ModRepo.RemoveLabels("", decision.Subject, []string{moderation.LabelHide}); err != nil {
+			return fmt.Errorf("移除审核标签失败: %w", err)
+		}
+
+		switch decision.SubjectType {
+		case moderation.SubjectMoment:
+			if !approve {
+				return nil
+			}
+			moment, err := txStore.MomentRepo.GetMomentByURI(decision.Subject)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				log.Printf("审核通过的 moment %s 已不存在", decision.Subject)
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("获取 moment 失败: %w", err)
+			}
+			if err := enqueueRecordOp(txStore, moment.URI, repositories.OutboxActionCreate); err != nil {
+				return err
+			}
+			published = moment
+		case moderation.SubjectMessage:
+			if !approve {
+				if err := txStore.MessageRepo.DeleteMessage(decision.Subject); err != nil {
+					return fmt.Errorf("删除消息失败: %w", err)
+				}
+				RemoveFromSearch(txStore, search.KindMessage, decision.Subject)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if published != nil {
+		announceMoment(s.metaStore, published)
+		s.emitLabels(ctx, decision.SubjectType, decision.Subject, decision.Labels)
+	}
+	return moderationItem(decision), nil
+}
+
+// emitLabels 以 labeler 的身份对外签发 moment 的标签, 失败只记录日志.
+// 等待审核和审核拒绝的 moment 没有发布, 它们的 !hide 和 !takedown 不对外签发
+func (s *ModerationService) emitLabels(ctx context.Context, subjectType string, uri string, vals []string) {
+	if s.labeler == nil || subjectType != moderation.SubjectMoment || len(vals) == 0 {
+		return
+	}
+	if _, err := s.labeler.Emit(ctx, uri, "", vals, false); err != nil {
+		log.Printf("签发 %s 的标签失败: %v", uri, err)
+	}
+}
+
+// momentSubject 收集 moment 正文、链接和图片用于审核
+func momentSubject(moment *repositories.Moment, req *CreateMomentRequest) *moderation.Subject {
+	subject := &moderation.Subject{
+		Type:   moderation.SubjectMoment,
+		URI:    moment.URI,
+		Author: moment.Creator,
+		Text:   moment.Text,
+	}
+	for _, facet := range req.Facets {
+		for _, feature := range facet.Features {
+			if feature != nil && feature.RichtextFacet_Link != nil {
+				subject.Links = append(subject.Links, feature.RichtextFacet_Link.Uri)
+			}
+		}
+	}
+	for _, image := range req.Images {
+		subject.Blobs = append(subject.Blobs, image.CID)
+	}
+	if req.External != nil {
+		subject.Links = append(subject.Links, req.External.URI)
+		if req.External.ThumbCID != "" {
+			subject.Blobs = append(subject.Blobs, req.External.ThumbCID)
+		}
+	}
+	return subject
+}
+
+// MessageSubject 收集聊天消息的文本、链接和图片用于审核
+func MessageSubject(message *messages.Message) *moderation.Subject {
+	subject := &moderation.Subject{
+		Type:   moderation.SubjectMessage,
+		URI:    message.ID,
+		Author: message.SenderID,
+		Text:   MessageText(message),
+	}
+	switch content := message.Content.(type) {
+	case *messages.ImageMessageContent:
+		subject.Blobs = append(subject.Blobs, content.ImageCID)
+	case *messages.StickerMessageContent:
+		subject.Blobs = append(subject.Blobs, content.StickerCID)
+	case *messages.PostMessageContent:
+		for _, row := range content.Content {
+			for _, node := range row {
+				switch v := node.(type) {
+				case *messages.RichTextNodeLink:
+					subject.Links = append(subject.Links, v.Href)
+				case *messages.RichTextNodeImage:
+					subject.Blobs = append(subject.Blobs, v.ImageKey)
+				}
+			}
+		}
+	}
+	return subject
+}
+
+// hiddenByLabels 返回 moment 或消息是否因审核对 viewer 不可见: !takedown 对所有人隐藏, !hide 只对作者可见
+func hiddenByLabels(labels []*repositories.ContentLabel, viewer string, author string) bool {
+	for _, label := range labels {
+		if label.Src != "" {
+			continue
+		}
+		switch label.Val {
+		case moderation.LabelTakedown:
+			return true
+		case moderation.LabelHide:
+			if viewer != author {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// VisibleMessages 过滤因审核对 viewer 不可见的消息, 等待审核的消息只对发送者可见
+func VisibleMessages(metaStore *repositories.MetaStore, dbMessages []*repositories.Message, viewer string) ([]*repositories.Message, error) {
+	ids := make([]string, len(dbMessages))
+	for i, message := range dbMessages {
+		ids[i] = message.ID
+	}
+	labels, err := metaStore.ModRepo.GetLabelsByURIs(ids)
+	if err != nil {
+		return nil, fmt.Errorf("获取消息的审核标签失败: %w", err)
+	}
+	visible := make([]*repositories.Message, 0, len(dbMessages))
+	for _, message := range dbMessages {
+		if !hiddenByLabels(labels[message.ID], viewer, message.SenderID) {
+			visible = append(visible, message)
+		}
+	}
+	return visible, nil
+}
+
+// unpublished 等待审核或审核被拒绝的 moment 没有同步到 PDS
+func unpublished(metaStore *repositories.MetaStore, uri string) bool {
+	labels, err := metaStore.ModRepo.GetLabelsByURIs([]string{uri})
+	if err != nil {
+		log.Printf("获取 %s 的审核标签失败: %v", uri, err)
+		return false
+	}
+	for _, label := range labels[uri] {
+		if label.Src == "" && (label.Val == moderation.LabelHide || label.Val == moderation.LabelTakedown) {
+			return true
+		}
+	}
+	return false
+}
+
+func labelViews(labels []*repositories.ContentLabel) []*types.LabelView {
+	if len(labels) == 0 {
+		return nil
+	}
+	views := make([]*types.LabelView, len(labels))
+	for i, label := range labels {
+		views[i] = &types.LabelView{Src: label.Src, Val: label.Val, CreatedAt: label.CreatedAt}
+	}
+	return views
+}
+
+func moderationItem(decision *repositories.ModerationDecision) *types.ModerationItem {
+	var findings []*types.ModerationFinding
+	if decision.Findings != "" {
+		if err := json.Unmarshal([]byte(decision.Findings), &findings); err != nil {
+			log.Printf("解析审核记录 %d 的命中明细失败: %v", decision.ID, err)
+		}
+	}
+	labels := []string(decision.Labels)
+	if labels == nil {
+		labels = []string{}
+	}
+	return &types.ModerationItem{
+		ID:          decision.ID,
+		SubjectType: decision.SubjectType,
+		Subject:     decision.Subject,
+		Author:      decision.Author,
+		Text:        decision.Text,
+		Verdict:     decision.Verdict,
+		Labels:      labels,
+		Findings:    findings,
+		Status:      decision.Status,
+		Reviewer:    decision.Reviewer,
+		ReviewNote:  decision.ReviewNote,
+		ReviewedAt:  decision.ReviewedAt,
+		CreatedAt:   decision.CreatedAt,
+	}
+}
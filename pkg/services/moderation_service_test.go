@@ -0,0 +1,235 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/moderation"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/search"
+)
+
+// verdictChecker 对包含 trigger 的内容给出固定结论
+type verdictChecker struct {
+	trigger string
+	verdict moderation.Verdict
+	label   string
+}
+
+func (c *verdictChecker) Name() string {
+	return "test"
+}
+
+func (c *verdictChecker) Check(ctx context.Context, subject *moderation.Subject) ([]*moderation.Finding, error) {
+	if !strings.Contains(subject.Text, c.trigger) {
+		return nil, nil
+	}
+	return []*moderation.Finding{{Checker: c.Name(), Verdict: c.verdict, Label: c.label, Reason: "命中 " + c.trigger}}, nil
+}
+
+func newTestModerationService(store *repositories.MetaStore, checkers ...moderation.Checker) *ModerationService {
+	return &ModerationService{metaStore: store, chain: moderation.NewChain(checkers...)}
+}
+
+func labelVals(t *testing.T, store *repositories.MetaStore, uri string) string {
+	t.Helper()
+	labels, err := store.ModRepo.GetLabelsByURIs([]string{uri})
+	if err != nil {
+		t.Fatalf("labels of %s: %v", uri, err)
+	}
+	vals := make([]string, 0, len(labels[uri]))
+	for _, label := range labels[uri] {
+		vals = append(vals, label.Val)
+	}
+	sort.Strings(vals)
+	return strings.Join(vals, ",")
+}
+
+func skeletonIDs(t *testing.T, store *repositories.MetaStore, viewer string) string {
+	t.Helper()
+	uris, _, err := NewFeedService(&config.SocialConfig{}, store).FeedSkeleton(context.Background(), "default", &FeedQuery{Viewer: viewer, Limit: 10})
+	if err != nil {
+		t.Fatalf("feed skeleton: %v", err)
+	}
+	return feedIDs(uris)
+}
+
+func TestModerationHoldAndApproveMoment(t *testing.T) {
+	ctx := context.Background()
+	store := newTestMetaStore(t)
+	service := newTestModerationService(store,
+		&verdictChecker{trigger: "spoiler", verdict: moderation.VerdictLabel, label: "spoiler"},
+		&verdictChecker{trigger: "maybe", verdict: moderation.VerdictHold, label: "spam"},
+	)
+	s := &rankSeed{t: t, store: store, now: time.Now()}
+	s.moment("public", notifyAuthor, 2*time.Hour)
+	held := s.moment("held", notifyAuthor, time.Hour)
+
+	decision, err := service.Moderate(ctx, &moderation.Subject{Type: moderation.SubjectMoment, URI: held.URI, Author: notifyAuthor, Text: "maybe a spoiler"})
+	if err != nil || decision.Verdict != moderation.VerdictHold {
+		t.Fatalf("moderate = %+v, %v, want hold", decision, err)
+	}
+	if got := labelVals(t, store, held.URI); got != "!hide,spam,spoiler" {
+		t.Fatalf("labels = %s, want !hide,spam,spoiler", got)
+	}
+
+	// 等待审核的 moment 只对作者可见, 包括 feed skeleton
+	if got := skeletonIDs(t, store, "did:plc:viewer"); got != "public" {
+		t.Fatalf("viewer skeleton = %s, want public only", got)
+	}
+	if got := skeletonIDs(t, store, ""); got != "public" {
+		t.Fatalf("anonymous skeleton = %s, want public only", got)
+	}
+	if got := skeletonIDs(t, store, notifyAuthor); got != "held,public" {
+		t.Fatalf("author skeleton = %s, want held,public", got)
+	}
+
+	queue, err := service.Queue(ctx, "", 10, "")
+	if err != nil || queue.Pending != 1 || len(queue.Items) != 1 || queue.Items[0].Subject != held.URI {
+		t.Fatalf("queue = %+v, %v, want the held moment", queue, err)
+	}
+	item, err := service.Review(ctx, queue.Items[0].ID, true, "did:plc:mod", "ok")
+	if err != nil || item.Status != repositories.ModerationStatusApproved || item.Reviewer != "did:plc:mod" {
+		t.Fatalf("approve = %+v, %v", item, err)
+	}
+	// 通过后移除 !hide, 保留分类标签, 同步到 PDS
+	if got := labelVals(t, store, held.URI); got != "spam,spoiler" {
+		t.Fatalf("labels after approval = %s, want spam,spoiler", got)
+	}
+	if n := countRows(t, store, &repositories.PDSOutboxOp{}); n != 1 {
+		t.Fatalf("%d outbox ops after approval, want 1", n)
+	}
+	if got := skeletonIDs(t, store, "did:plc:viewer"); got != "held,public" {
+		t.Fatalf("viewer skeleton after approval = %s", got)
+	}
+	if _, err := service.Review(ctx, queue.Items[0].ID, false, "did:plc:mod", ""); !errors.Is(err, ErrReviewNotPending) {
+		t.Fatalf("second review = %v, want ErrReviewNotPending", err)
+	}
+}
+
+func TestModerationRejectsAndTakesDown(t *testing.T) {
+	ctx := context.Background()
+	store := newTestMetaStore(t)
+	service := newTestModerationService(store,
+		&verdictChecker{trigger: "maybe", verdict: moderation.VerdictHold},
+		&verdictChecker{trigger: "spam", verdict: moderation.VerdictReject, label: "spam"},
+	)
+	s := &rankSeed{t: t, store: store, now: time.Now()}
+	held := s.moment("held", notifyAuthor, time.Hour)
+
+	// reject 只保存审核记录, 不写入标签
+	decision, err := service.Moderate(ctx, &moderation.Subject{Type: moderation.SubjectMoment, URI: momentURI("rejected"), Author: notifyAuthor, Text: "maybe spam"})
+	if err != nil || decision.Verdict != moderation.VerdictReject {
+		t.Fatalf("moderate = %+v, %v, want reject", decision, err)
+	}
+	if got := labelVals(t, store, momentURI("rejected")); got != "" {
+		t.Fatalf("rejected labels = %s, want none", got)
+	}
+	if queue, _ := service.Queue(ctx, repositories.ModerationStatusAuto, 10, ""); len(queue.Items) != 1 || queue.Items[0].Verdict != string(moderation.VerdictReject) {
+		t.Fatalf("auto queue = %+v, want the rejected moment", queue)
+	}
+
+	if _, err := service.Moderate(ctx, &moderation.Subject{Type: moderation.SubjectMoment, URI: held.URI, Author: notifyAuthor, Text: "maybe"}); err != nil {
+		t.Fatalf("moderate: %v", err)
+	}
+	queue, _ := service.Queue(ctx, "", 10, "")
+	if _, err := service.Review(ctx, queue.Items[0].ID, false, "did:plc:mod", "no"); err != nil {
+		t.Fatalf("reject: %v", err)
+	}
+	// 拒绝后所有人不可见, 不同步到 PDS
+	if got := labelVals(t, store, held.URI); got != "!takedown" {
+		t.Fatalf("labels after rejection = %s, want !takedown", got)
+	}
+	if got := skeletonIDs(t, store, notifyAuthor); got != "" {
+		t.Fatalf("author skeleton after rejection = %s, want empty", got)
+	}
+	if n := countRows(t, store, &repositories.PDSOutboxOp{}); n != 0 {
+		t.Fatalf("%d outbox ops after rejection, want 0", n)
+	}
+}
+
+func TestHeldMessagesAreOnlyVisibleToSender(t *testing.T) {
+	ctx := context.Background()
+	store := newTestMetaStore(t)
+	service := newTestModerationService(store, &verdictChecker{trigger: "maybe", verdict: moderation.VerdictHold})
+	converter := NewMessageConverter(store.MessageRepo)
+	write := func(id string, sender string, text string) *messages.Message {
+		message := &messages.Message{ID: id, RoomID: "room-1", ThreadID: "thread-1", MsgType: messages.MessageTypeText, SenderID: sender, Content: &messages.TextMessageContent{Text: text}}
+		if _, err := service.Moderate(ctx, MessageSubject(message)); err != nil {
+			t.Fatalf("moderate %s: %v", id, err)
+		}
+		if err := store.MessageRepo.InsertMessage(converter.MessageToDB(message)); err != nil {
+			t.Fatalf("insert %s: %v", id, err)
+		}
+		IndexMessage(store, message)
+		return message
+	}
+	write("hello", "did:plc:alice", "hello picnic")
+	write("held", "did:plc:bob", "maybe picnic")
+
+	visible := func(viewer string) string {
+		dbMessages, err := store.MessageRepo.GetMessagesByIDs([]string{"hello", "held"})
+		if err != nil {
+			t.Fatalf("get messages: %v", err)
+		}
+		dbMessages, err = VisibleMessages(store, dbMessages, viewer)
+		if err != nil {
+			t.Fatalf("visible messages: %v", err)
+		}
+		ids := make([]string, 0, len(dbMessages))
+		for _, message := range dbMessages {
+			ids = append(ids, message.ID)
+		}
+		sort.Strings(ids)
+		return strings.Join(ids, ",")
+	}
+	if got := visible("did:plc:alice"); got != "hello" {
+		t.Errorf("alice sees %s, want hello", got)
+	}
+	if got := visible("did:plc:bob"); got != "held,hello" {
+		t.Errorf("bob sees %s, want held,hello", got)
+	}
+
+	searchService := NewSearchService(&config.SocialConfig{}, store)
+	searchIDs := func(viewer string) string {
+		results, err := searchService.Search(ctx, &SearchQuery{Viewer: viewer, Text: "picnic", Kind: search.KindMessage, Limit: 10})
+		if err != nil {
+			t.Fatalf("search as %s: %v", viewer, err)
+		}
+		ids := make([]string, 0)
+		if results.Messages != nil {
+			for _, hit := range results.Messages.Hits {
+				ids = append(ids, hit.Key)
+			}
+		}
+		sort.Strings(ids)
+		return strings.Join(ids, ",")
+	}
+	if got := searchIDs("did:plc:alice"); got != "hello" {
+		t.Errorf("alice search = %s, want hello", got)
+	}
+	if got := searchIDs("did:plc:bob"); got != "held,hello" {
+		t.Errorf("bob search = %s, want held,hello", got)
+	}
+
+	// 拒绝后消息删除并从索引中移除
+	queue, _ := service.Queue(ctx, "", 10, "")
+	if len(queue.Items) != 1 || queue.Items[0].Subject != "held" {
+		t.Fatalf("queue = %+v, want the held message", queue.Items)
+	}
+	if _, err := service.Review(ctx, queue.Items[0].ID, false, "did:plc:mod", ""); err != nil {
+		t.Fatalf("reject held: %v", err)
+	}
+	if hits := searchMessages(t, store, "picnic"); hits["held"] != nil || hits["hello"] == nil {
+		t.Errorf("indexed messages after rejection = %v, want hello only", hits)
+	}
+	if message, err := store.MessageRepo.GetMessageByID("held"); err == nil && !message.Deleted {
+		t.Errorf("rejected message is not deleted")
+	}
+}
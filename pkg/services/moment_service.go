@@ -10,7 +10,10 @@ import (
 
 	appbskytypes "github.com/bluesky-social/indigo/api/bsky"
 	"github.com/zhongshangwu/avatarai-social/pkg/atproto/helper"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/moderation"
 	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/search"
 	"github.com/zhongshangwu/avatarai-social/types"
 )
 
@@ -38,28 +41,20 @@ type ExternalData struct {
 }
 
 type MomentService struct {
-	metaStore  *repositories.MetaStore
-	tagService *TagService
+	metaStore         *repositories.MetaStore
+	tagService        *TagService
+	moderationService *ModerationService
 }
 
-func NewMomentService(metaStore *repositories.MetaStore) *MomentService {
+func NewMomentService(config *config.SocialConfig, metaStore *repositories.MetaStore) *MomentService {
 	return &MomentService{
-		metaStore:  metaStore,
-		tagService: NewTagService(metaStore),
+		metaStore:         metaStore,
+		tagService:        NewTagService(metaStore),
+		moderationService: NewModerationService(config, metaStore),
 	}
 }
 
 func (s *MomentService) CreateMoment(ctx context.Context, creatorDid string, req *CreateMomentRequest) (*types.Moment, error) {
-	tx := s.metaStore.DB.Begin()
-	if tx.Error != nil {
-		return nil, fmt.Errorf("开始数据库事务失败: %w", tx.Error)
-	}
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-		}
-	}()
-
 	now := time.Now().Unix()
 	momentId := s.GenerateMomentID()
 
@@ -78,49 +73,58 @@ func (s *MomentService) CreateMoment(ctx context.Context, creatorDid string, req
 		Langs:     req.Langs,
 		Tags:      req.Tags,
 		CreatedAt: now,
-		IndexedAt: 0,
+		IndexedAt: now,
 	}
 
-	// 处理回复逻辑
-	if req.ParentID != "" {
-		dbMoment.ReplyParentID = req.ParentID
-
-		// 如果没有指定 RootID，需要自动查找或设置
-		if req.RootID != "" {
-			dbMoment.ReplyRootID = req.RootID
-		} else {
-			// 获取父 moment，确定根 moment
-			parentMoment, err := s.metaStore.MomentRepo.GetMomentByID(req.ParentID)
-			if err != nil {
-				tx.Rollback()
-				return nil, fmt.Errorf("获取父 moment 失败: %w", err)
-			}
-
-			if parentMoment.ReplyRootID != "" {
-				// 父 moment 是回复，使用相同的根
-				dbMoment.ReplyRootID = parentMoment.ReplyRootID
-			} else {
-				// 父 moment 是顶级帖子，它就是根
-				dbMoment.ReplyRootID = parentMoment.ID
-			}
-		}
-	} else if req.RootID != "" {
-		// 如果只指定了 RootID 而没有 ParentID，则是直接回复根帖子
-		dbMoment.ReplyRootID = req.RootID
-		dbMoment.ReplyParentID = req.RootID
+	// 审核在写入之前进行, 拒绝的内容不落库; 等待审核的内容只对作者可见, 审核通过后才发布
+	decision, err := s.moderationService.Moderate(ctx, momentSubject(dbMoment, req))
+	if err != nil {
+		return nil, err
 	}
-
-	if err := s.metaStore.MomentRepo.CreateMoment(dbMoment); err != nil {
-		return nil, fmt.Errorf("保存moment记录失败: %w", err)
+	if decision.Verdict == moderation.VerdictReject {
+		return nil, ErrContentRejected
 	}
 
 	var (
-		images   []*repositories.MomentImage
-		video    *repositories.MomentVideo
-		external *repositories.MomentExternal
+		images       []*repositories.MomentImage
+		video        *repositories.MomentVideo
+		external     *repositories.MomentExternal
+		activityTags []*repositories.ActivityTag
 	)
+	// moment、嵌入内容、标签和 PDS 同步操作在同一事务中写入
+	err = s.metaStore.WithTransaction(ctx, func(txStore *repositories.MetaStore) error {
+		// 处理回复逻辑
+		if req.ParentID != "" {
+			dbMoment.ReplyParentID = req.ParentID
+
+			// 如果没有指定 RootID，需要自动查找或设置
+			if req.RootID != "" {
+				dbMoment.ReplyRootID = req.RootID
+			} else {
+				// 获取父 moment，确定根 moment
+				parentMoment, err := txStore.MomentRepo.GetMomentByID(req.ParentID)
+				if err != nil {
+					return fmt.Errorf("获取父 moment 失败: %w", err)
+				}
+
+				if parentMoment.ReplyRootID != "" {
+					// 父 moment 是回复，使用相同的根
+					dbMoment.ReplyRootID = parentMoment.ReplyRootID
+				} else {
+					// 父 moment 是顶级帖子，它就是根
+					dbMoment.ReplyRootID = parentMoment.ID
+				}
+			}
+		} else if req.RootID != "" {
+			// 如果只指定了 RootID 而没有 ParentID，则是直接回复根帖子
+			dbMoment.ReplyRootID = req.RootID
+			dbMoment.ReplyParentID = req.RootID
+		}
+
+		if err := txStore.MomentRepo.CreateMoment(dbMoment); err != nil {
+			return fmt.Errorf("保存moment记录失败: %w", err)
+		}
 
-	if len(req.Images) > 0 {
 		for i, img := range req.Images {
 			dbImage := &repositories.MomentImage{
 				MomentID: momentId,
@@ -129,53 +133,81 @@ func (s *MomentService) CreateMoment(ctx context.Context, creatorDid string, req
 				Alt:      "",
 			}
 			images = append(images, dbImage)
-			if err := s.metaStore.MomentRepo.CreateMomentImage(dbImage); err != nil {
-				tx.Rollback()
-				return nil, fmt.Errorf("保存图片记录失败: %w", err)
+			if err := txStore.MomentRepo.CreateMomentImage(dbImage); err != nil {
+				return fmt.Errorf("保存图片记录失败: %w", err)
 			}
 		}
-	}
 
-	if req.Video != nil {
-		video = &repositories.MomentVideo{
-			MomentID: momentId,
-			VideoCID: req.Video.CID,
-			Alt:      "",
+		if req.Video != nil {
+			video = &repositories.MomentVideo{
+				MomentID: momentId,
+				VideoCID: req.Video.CID,
+				Alt:      "",
+			}
+			if err := txStore.MomentRepo.CreateMomentVideo(video); err != nil {
+				return fmt.Errorf("保存视频记录失败: %w", err)
+			}
 		}
-		if err := s.metaStore.MomentRepo.CreateMomentVideo(video); err != nil {
-			tx.Rollback()
-			return nil, fmt.Errorf("保存视频记录失败: %w", err)
+
+		if req.External != nil {
+			external = &repositories.MomentExternal{
+				MomentID:    momentId,
+				URI:         req.External.URI,
+				Title:       req.External.Title,
+				Description: req.External.Description,
+				ThumbCID:    req.External.ThumbCID,
+			}
+			if err := txStore.MomentRepo.CreateMomentExternal(external); err != nil {
+				return fmt.Errorf("保存外部链接记录失败: %w", err)
+			}
 		}
-	}
 
-	if req.External != nil {
-		external = &repositories.MomentExternal{
-			MomentID:    momentId,
-			URI:         req.External.URI,
-			Title:       req.External.Title,
-			Description: req.External.Description,
-			ThumbCID:    req.External.ThumbCID,
+		if len(req.Tags) > 0 {
+			var err error
+			activityTags, err = NewTagService(txStore).SyncActivityTags(ctx, dbMoment.URI, req.Tags, creatorDid)
+			if err != nil {
+				return fmt.Errorf("处理标签失败: %w", err)
+			}
 		}
-		if err := s.metaStore.MomentRepo.CreateMomentExternal(external); err != nil {
-			tx.Rollback()
-			return nil, fmt.Errorf("保存外部链接记录失败: %w", err)
+
+		if decision.Verdict == moderation.VerdictHold {
+			return nil
 		}
+		return enqueueRecordOp(txStore, dbMoment.URI, repositories.OutboxActionCreate)
+	})
+	if err != nil {
+		return nil, err
 	}
-	// 处理标签逻辑 - 在事务提交前处理
-	var activityTags []*repositories.ActivityTag
-	if len(req.Tags) > 0 {
-		activityTags, err = s.tagService.SyncActivityTags(ctx, dbMoment.URI, req.Tags, creatorDid)
-		if err != nil {
-			tx.Rollback()
-			return nil, fmt.Errorf("处理标签失败: %w", err)
-		}
+	if decision.Verdict != moderation.VerdictHold {
+		announceMoment(s.metaStore, dbMoment)
 	}
 
-	if err := tx.Commit().Error; err != nil {
-		return nil, fmt.Errorf("提交事务失败: %w", err)
+	moment := s.ConvertDBToMoment(dbMoment, images, video, external, activityTags, nil)
+	moment.Labels = decision.Labels
+	if decision.Verdict == moderation.VerdictHold {
+		moment.Labels = append(moment.Labels, moderation.LabelHide)
+	}
+	return moment, nil
+}
+
+// VisibleTo 等待审核的 moment 只对作者可见, 审核拒绝的 moment 对所有人不可见
+func (s *MomentService) VisibleTo(moment *types.Moment, viewer string) bool {
+	labels, err := s.metaStore.ModRepo.GetLabelsByURIs([]string{moment.URI})
+	if err != nil {
+		log.Printf("获取 %s 的审核标签失败: %v", moment.URI, err)
+		return true
 	}
+	return !hiddenByLabels(labels[moment.URI], viewer, moment.CreatedBy)
+}
 
-	return s.ConvertDBToMoment(dbMoment, images, video, external, activityTags, nil), nil
+// announceMoment 更新被回复 moment 的计数并通知、索引, 在 moment 发布 (写入并加入同步队列) 的事务提交后调用.
+// 等待审核的 moment 在审核通过后调用
+func announceMoment(metaStore *repositories.MetaStore, moment *repositories.Moment) {
+	if moment.ReplyParentID != "" {
+		refreshReplyParentAgg(metaStore, moment.ReplyParentID)
+	}
+	NotifyMoment(metaStore, moment)
+	IndexMoment(metaStore, moment)
 }
 
 func (s *MomentService) GetMomentByID(ctx context.Context, uri string) (*types.Moment, error) {
@@ -212,9 +244,7 @@ func (s *MomentService) LikeMoment(ctx context.Context, uri string, did string)
 		return nil, fmt.Errorf("只能点赞 moment 记录的帖子")
 	}
 
-	momentID := string(aturi.RecordKey())
-
-	moment, err := s.metaStore.MomentRepo.GetMomentByID(momentID)
+	moment, err := s.metaStore.MomentRepo.GetMomentByURI(uri)
 	if err != nil {
 		return nil, fmt.Errorf("获取 moment 失败: %w", err)
 	}
@@ -230,9 +260,17 @@ func (s *MomentService) LikeMoment(ctx context.Context, uri string, did string)
 		CreatedAt:  time.Now().Unix(),
 		IndexedAt:  0,
 	}
-	if err := s.metaStore.MomentRepo.CreateLike(like); err != nil {
-		return nil, fmt.Errorf("点赞失败: %w", err)
+	err = s.metaStore.WithTransaction(ctx, func(txStore *repositories.MetaStore) error {
+		if err := txStore.MomentRepo.CreateLike(like); err != nil {
+			return fmt.Errorf("点赞失败: %w", err)
+		}
+		return enqueueRecordOp(txStore, like.URI, repositories.OutboxActionCreate)
+	})
+	if err != nil {
+		return nil, err
 	}
+	refreshMomentAgg(s.metaStore, moment.URI)
+	NotifyLike(s.metaStore, like)
 	return like, nil
 }
 
@@ -254,9 +292,17 @@ func (s *MomentService) RemoveLikeMoment(ctx context.Context, uri string, did st
 		return fmt.Errorf("只能取消点赞 moment 记录的帖子")
 	}
 
-	if err := s.metaStore.MomentRepo.DeleteLike(likeURI); err != nil {
-		return fmt.Errorf("取消点赞失败: %w", err)
+	err = s.metaStore.WithTransaction(ctx, func(txStore *repositories.MetaStore) error {
+		if err := txStore.MomentRepo.DeleteLike(likeURI); err != nil {
+			return fmt.Errorf("取消点赞失败: %w", err)
+		}
+		return enqueueRecordOp(txStore, likeURI, repositories.OutboxActionDelete)
+	})
+	if err != nil {
+		return err
 	}
+	refreshMomentAgg(s.metaStore, uri)
+	RetractNotifications(s.metaStore, likeURI)
 	return nil
 }
 
@@ -379,72 +425,102 @@ func (s *MomentService) BuildLikeURI(did string, rkey string) string {
 }
 
 func (s *MomentService) DeleteMoment(ctx context.Context, momentURI string) error {
-	tx := s.metaStore.DB.Begin()
-	if tx.Error != nil {
-		return fmt.Errorf("开始数据库事务失败: %w", tx.Error)
-	}
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
+	moment, _ := s.metaStore.MomentRepo.GetMomentByURI(momentURI)
+
+	err := s.metaStore.WithTransaction(ctx, func(txStore *repositories.MetaStore) error {
+		// 删除标签关联
+		if err := NewTagService(txStore).UnbindActivityTags(ctx, momentURI); err != nil {
+			return fmt.Errorf("删除标签关联失败: %w", err)
 		}
-	}()
 
-	// 删除标签关联
-	if err := s.tagService.UnbindActivityTags(ctx, momentURI); err != nil {
-		tx.Rollback()
-		return fmt.Errorf("删除标签关联失败: %w", err)
-	}
+		// 删除 moment 本身
+		if err := txStore.MomentRepo.DeleteMoment(momentURI); err != nil {
+			return fmt.Errorf("删除moment失败: %w", err)
+		}
 
-	// 删除 moment 本身
-	if err := s.metaStore.MomentRepo.DeleteMoment(momentURI); err != nil {
-		tx.Rollback()
-		return fmt.Errorf("删除moment失败: %w", err)
+		// 等待审核或被拒绝的 moment 没有写入 PDS
+		if unpublished(txStore, momentURI) {
+			return nil
+		}
+		return enqueueRecordOp(txStore, momentURI, repositories.OutboxActionDelete)
+	})
+	if err != nil {
+		return err
 	}
-
-	if err := tx.Commit().Error; err != nil {
-		return fmt.Errorf("提交事务失败: %w", err)
+	if moment != nil && moment.ReplyParentID != "" {
+		refreshReplyParentAgg(s.metaStore, moment.ReplyParentID)
 	}
+	RetractNotifications(s.metaStore, momentURI)
+	RemoveFromSearch(s.metaStore, search.KindMoment, momentURI)
 
 	return nil
 }
 
 func (s *MomentService) UpdateMomentTags(ctx context.Context, momentURI string, newTags []string, creatorDid string) error {
-	tx := s.metaStore.DB.Begin()
-	if tx.Error != nil {
-		return fmt.Errorf("开始数据库事务失败: %w", tx.Error)
-	}
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
+	return s.metaStore.WithTransaction(ctx, func(txStore *repositories.MetaStore) error {
+		tagService := NewTagService(txStore)
+
+		// 删除现有的标签关联
+		if err := tagService.UnbindActivityTags(ctx, momentURI); err != nil {
+			return fmt.Errorf("删除现有标签关联失败: %w", err)
 		}
-	}()
 
-	// 删除现有的标签关联
-	if err := s.tagService.UnbindActivityTags(ctx, momentURI); err != nil {
-		tx.Rollback()
-		return fmt.Errorf("删除现有标签关联失败: %w", err)
-	}
+		// 添加新的标签关联
+		if len(newTags) > 0 {
+			if _, err := tagService.SyncActivityTags(ctx, momentURI, newTags, creatorDid); err != nil {
+				return fmt.Errorf("处理新标签失败: %w", err)
+			}
+		}
 
-	// 添加新的标签关联
-	if len(newTags) > 0 {
-		if _, err := s.tagService.SyncActivityTags(ctx, momentURI, newTags, creatorDid); err != nil {
-			tx.Rollback()
-			return fmt.Errorf("处理新标签失败: %w", err)
+		// 同时更新 moment 表中的 tags 字段
+		updates := map[string]interface{}{
+			"tags": newTags,
 		}
-	}
+		if err := txStore.MomentRepo.UpdateMoment(momentURI, updates); err != nil {
+			return fmt.Errorf("更新moment标签字段失败: %w", err)
+		}
+
+		// 等待审核的 moment 还没有发布, 审核通过时按最新的标签同步和索引
+		if unpublished(txStore, momentURI) {
+			return nil
+		}
+		if err := enqueueRecordOp(txStore, momentURI, repositories.OutboxActionUpdate); err != nil {
+			return err
+		}
+		if moment, err := txStore.MomentRepo.GetMomentByURI(momentURI); err == nil {
+			IndexMoment(txStore, moment)
+		}
+		return nil
+	})
+}
 
-	// 同时更新 moment 表中的 tags 字段
-	updates := map[string]interface{}{
-		"tags": newTags,
+// enqueueRecordOp 将本地记录的变更加入 PDS 同步队列, 由 SyncerManager 异步写入用户的仓库.
+// 调用方传入写入记录的事务, 入队失败时记录的变更一起回滚, 不会出现本地有而 PDS 永远不同步的记录
+func enqueueRecordOp(txStore *repositories.MetaStore, uri string, action string) error {
+	aturi, err := helper.BuildAtURI(uri)
+	if err != nil {
+		return fmt.Errorf("无效的记录URI %s: %w", uri, err)
 	}
-	if err := s.metaStore.MomentRepo.UpdateMoment(momentURI, updates); err != nil {
-		tx.Rollback()
-		return fmt.Errorf("更新moment标签字段失败: %w", err)
+	err = txStore.OutboxRepo.EnqueueRecordOp(aturi.Authority().String(), aturi.Collection().String(), aturi.RecordKey().String(), action)
+	if err != nil {
+		return fmt.Errorf("记录 %s 加入同步队列失败: %w", uri, err)
 	}
+	return nil
+}
 
-	if err := tx.Commit().Error; err != nil {
-		return fmt.Errorf("提交事务失败: %w", err)
+// refreshMomentAgg 重新统计 moment 的互动计数, 失败不影响主流程
+func refreshMomentAgg(metaStore *repositories.MetaStore, uri string) {
+	if err := metaStore.MomentRepo.RefreshMomentAgg(uri); err != nil {
+		log.Printf("更新 moment %s 的互动计数失败: %v", uri, err)
 	}
+}
 
-	return nil
+// refreshReplyParentAgg 回复变化后更新被回复 moment 的回复数
+func refreshReplyParentAgg(metaStore *repositories.MetaStore, parentID string) {
+	parent, err := metaStore.MomentRepo.GetMomentByID(parentID)
+	if err != nil {
+		log.Printf("获取被回复的 moment %s 失败: %v", parentID, err)
+		return
+	}
+	refreshMomentAgg(metaStore, parent.URI)
 }
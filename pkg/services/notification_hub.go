@@ -0,0 +1,89 @@
+package services
+
+import (
+	"log"
+	"sync"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+)
+
+const notificationBufferSize = 32
+
+// NotificationHub 进程内按用户分发实时通知. 每个在线连接持有一个订阅,
+// 订阅的缓冲区满时丢弃推送, 客户端仍可以通过通知列表接口补齐
+type NotificationHub struct {
+	mu          sync.RWMutex
+	subscribers map[string]map[*NotificationSubscription]struct{}
+}
+
+type NotificationSubscription struct {
+	hub    *NotificationHub
+	did    string
+	events chan *messages.NotificationCreatedEvent
+	once   sync.Once
+}
+
+var defaultNotificationHub = NewNotificationHub()
+
+// DefaultNotificationHub 服务写入通知时推送到的全局 hub
+func DefaultNotificationHub() *NotificationHub {
+	return defaultNotificationHub
+}
+
+func NewNotificationHub() *NotificationHub {
+	return &NotificationHub{
+		subscribers: make(map[string]map[*NotificationSubscription]struct{}),
+	}
+}
+
+// Subscribe 订阅 did 的新通知, 连接断开时需要调用 Close
+func (h *NotificationHub) Subscribe(did string) *NotificationSubscription {
+	sub := &NotificationSubscription{
+		hub:    h,
+		did:    did,
+		events: make(chan *messages.NotificationCreatedEvent, notificationBufferSize),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subscribers[did] == nil {
+		h.subscribers[did] = make(map[*NotificationSubscription]struct{})
+	}
+	h.subscribers[did][sub] = struct{}{}
+	return sub
+}
+
+// Online 用户是否有在线的订阅
+func (h *NotificationHub) Online(did string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subscribers[did]) > 0
+}
+
+func (h *NotificationHub) Publish(did string, event *messages.NotificationCreatedEvent) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for sub := range h.subscribers[did] {
+		select {
+		case sub.events <- event:
+		default:
+			log.Printf("用户 %s 的通知推送缓冲区已满, 丢弃一条通知", did)
+		}
+	}
+}
+
+func (s *NotificationSubscription) Events() <-chan *messages.NotificationCreatedEvent {
+	return s.events
+}
+
+func (s *NotificationSubscription) Close() {
+	s.once.Do(func() {
+		s.hub.mu.Lock()
+		defer s.hub.mu.Unlock()
+		delete(s.hub.subscribers[s.did], s)
+		if len(s.hub.subscribers[s.did]) == 0 {
+			delete(s.hub.subscribers, s.did)
+		}
+		close(s.events)
+	})
+}
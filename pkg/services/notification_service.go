@@ -0,0 +1,276 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	appbskytypes "github.com/bluesky-social/indigo/api/bsky"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/atproto/helper"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/types"
+)
+
+// 合并的点赞通知最多展示的点赞者数量
+const maxGroupedAuthors = 5
+
+// NotificationService 通知列表和已读状态. 通知由 moment、点赞和关注的写入路径通过
+// NotifyMoment、NotifyLike、NotifyFollow 产生, 写入后推送给在线的用户
+type NotificationService struct {
+	metaStore           *repositories.MetaStore
+	relationshipService *RelationshipService
+}
+
+func NewNotificationService(config *config.SocialConfig, metaStore *repositories.MetaStore) *NotificationService {
+	return &NotificationService{
+		metaStore:           metaStore,
+		relationshipService: NewRelationshipService(config, metaStore),
+	}
+}
+
+// List 按时间倒序返回通知, 同一页内对同一 moment 的点赞合并为一项. 游标为本页最后一条通知的 ID
+func (s *NotificationService) List(ctx context.Context, did string, unreadOnly bool, limit int, cursor string) (*types.NotificationList, error) {
+	var beforeID uint64
+	if cursor != "" {
+		value, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		beforeID = value
+	}
+
+	notifications, err := s.metaStore.NotifyRepo.ListNotifications(did, unreadOnly, limit, uint(beforeID))
+	if err != nil {
+		return nil, fmt.Errorf("获取通知失败: %w", err)
+	}
+
+	blocked, err := s.metaStore.GraphRepo.GetBlockedDIDs(did)
+	if err != nil {
+		return nil, fmt.Errorf("获取屏蔽关系失败: %w", err)
+	}
+	hidden := make(map[string]bool, len(blocked))
+	for _, blockedDID := range blocked {
+		hidden[blockedDID] = true
+	}
+
+	authors := make([]string, 0, len(notifications))
+	for _, notification := range notifications {
+		authors = append(authors, notification.Author)
+	}
+	views, err := s.relationshipService.UserViews(authors)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &types.NotificationList{Notifications: make([]*types.Notification, 0, len(notifications))}
+	likeGroups := make(map[string]*types.Notification)
+	for _, notification := range notifications {
+		if hidden[notification.Author] {
+			continue
+		}
+
+		if notification.Reason == repositories.NotificationReasonLike {
+			if group, ok := likeGroups[notification.ReasonSubject]; ok {
+				group.IDs = append(group.IDs, notification.ID)
+				group.Count++
+				group.IsRead = group.IsRead && notification.IsRead
+				if len(group.Authors) < maxGroupedAuthors {
+					group.Authors = append(group.Authors, views[notification.Author])
+				}
+				continue
+			}
+		}
+
+		item := &types.Notification{
+			IDs:           []uint{notification.ID},
+			Reason:        notification.Reason,
+			ReasonSubject: notification.ReasonSubject,
+			URI:           notification.RecordURI,
+			Author:        views[notification.Author],
+			Count:         1,
+			IsRead:        notification.IsRead,
+			CreatedAt:     notification.CreatedAt,
+		}
+		if notification.Reason == repositories.NotificationReasonLike {
+			item.Authors = []*types.SimpleUserView{item.Author}
+			likeGroups[notification.ReasonSubject] = item
+		}
+		list.Notifications = append(list.Notifications, item)
+	}
+
+	if limit > 0 && len(notifications) >= limit {
+		list.Cursor = strconv.FormatUint(uint64(notifications[len(notifications)-1].ID), 10)
+	}
+	return list, nil
+}
+
+// MarkRead 标记通知为已读, ids 为空时标记全部
+func (s *NotificationService) MarkRead(ctx context.Context, did string, ids []uint) error {
+	if err := s.metaStore.NotifyRepo.MarkRead(did, ids); err != nil {
+		return fmt.Errorf("标记已读失败: %w", err)
+	}
+	return nil
+}
+
+func (s *NotificationService) UnreadCount(ctx context.Context, did string) (int64, error) {
+	count, err := s.metaStore.NotifyRepo.CountUnread(did)
+	if err != nil {
+		return 0, fmt.Errorf("获取未读数失败: %w", err)
+	}
+	return count, nil
+}
+
+// NotifyMoment 回复通知被回复 moment 的作者, 富文本中 @ 到的用户收到提及通知.
+// 被回复的作者同时被提及时只发送回复通知
+func NotifyMoment(metaStore *repositories.MetaStore, moment *repositories.Moment) {
+	notified := map[string]bool{moment.Creator: true}
+
+	if moment.ReplyParentID != "" {
+		parent, err := metaStore.MomentRepo.GetMomentByID(moment.ReplyParentID)
+		if err == nil {
+			notified[parent.Creator] = true
+			notify(metaStore, &repositories.Notification{
+				Recipient:     parent.Creator,
+				Author:        moment.Creator,
+				Reason:        repositories.NotificationReasonReply,
+				ReasonSubject: parent.URI,
+				RecordURI:     moment.URI,
+				CreatedAt:     moment.CreatedAt,
+			})
+		}
+	}
+
+	for _, did := range mentionedDIDs(moment.Facets) {
+		if notified[did] {
+			continue
+		}
+		notified[did] = true
+		notify(metaStore, &repositories.Notification{
+			Recipient: did,
+			Author:    moment.Creator,
+			Reason:    repositories.NotificationReasonMention,
+			RecordURI: moment.URI,
+			CreatedAt: moment.CreatedAt,
+		})
+	}
+}
+
+// NotifyLike 通知被点赞 moment 的作者
+func NotifyLike(metaStore *repositories.MetaStore, like *repositories.Like) {
+	subject, err := helper.BuildAtURI(like.SubjectURI)
+	if err != nil {
+		return
+	}
+	notify(metaStore, &repositories.Notification{
+		Recipient:     subject.Authority().String(),
+		Author:        like.Creator,
+		Reason:        repositories.NotificationReasonLike,
+		ReasonSubject: like.SubjectURI,
+		RecordURI:     like.URI,
+		CreatedAt:     like.CreatedAt,
+	})
+}
+
+// NotifyFollow 通知被关注的用户, 屏蔽关系不产生通知
+func NotifyFollow(metaStore *repositories.MetaStore, relationship *repositories.Relationship) {
+	if relationship.Predicate != repositories.RelationshipFollow {
+		return
+	}
+	notify(metaStore, &repositories.Notification{
+		Recipient: relationship.Object,
+		Author:    relationship.Creator,
+		Reason:    repositories.NotificationReasonFollow,
+		RecordURI: relationship.URI,
+		CreatedAt: relationship.CreatedAt,
+	})
+}
+
+// RetractNotifications 记录被删除后撤回相关通知, 失败不影响主流程
+func RetractNotifications(metaStore *repositories.MetaStore, uri string) {
+	if err := metaStore.NotifyRepo.DeleteNotificationsByURI(uri); err != nil {
+		log.Printf("撤回记录 %s 的通知失败: %v", uri, err)
+	}
+}
+
+// notify 写入通知并推送给在线的接收者. 不通知自己, 双方存在屏蔽关系时不通知
+func notify(metaStore *repositories.MetaStore, notification *repositories.Notification) {
+	if notification.Recipient == "" || notification.Recipient == notification.Author {
+		return
+	}
+
+	blocked, err := metaStore.GraphRepo.GetBlockedDIDs(notification.Recipient)
+	if err != nil {
+		log.Printf("获取用户 %s 的屏蔽关系失败: %v", notification.Recipient, err)
+		return
+	}
+	for _, did := range blocked {
+		if did == notification.Author {
+			return
+		}
+	}
+
+	if notification.CreatedAt == 0 {
+		notification.CreatedAt = time.Now().Unix()
+	}
+	created, err := metaStore.NotifyRepo.CreateNotification(notification)
+	if err != nil {
+		log.Printf("写入通知失败: %v", err)
+		return
+	}
+	if !created || !defaultNotificationHub.Online(notification.Recipient) {
+		return
+	}
+
+	unread, err := metaStore.NotifyRepo.CountUnread(notification.Recipient)
+	if err != nil {
+		log.Printf("获取用户 %s 的未读数失败: %v", notification.Recipient, err)
+	}
+	author := &types.SimpleUserView{Did: notification.Author}
+	if avatar, err := metaStore.UserRepo.GetAvatarByDID(notification.Author); err == nil {
+		author.Handle = avatar.Handle
+		author.DisplayName = avatar.DisplayName
+	}
+	defaultNotificationHub.Publish(notification.Recipient, &messages.NotificationCreatedEvent{
+		Notification: &types.Notification{
+			IDs:           []uint{notification.ID},
+			Reason:        notification.Reason,
+			ReasonSubject: notification.ReasonSubject,
+			URI:           notification.RecordURI,
+			Author:        author,
+			Count:         1,
+			CreatedAt:     notification.CreatedAt,
+		},
+		UnreadCount: unread,
+	})
+}
+
+// mentionedDIDs 解析 moment 保存的富文本注解, 返回提及的用户
+func mentionedDIDs(facetsJSON string) []string {
+	if facetsJSON == "" || facetsJSON == "null" {
+		return nil
+	}
+	var facets []*appbskytypes.RichtextFacet
+	if err := json.Unmarshal([]byte(facetsJSON), &facets); err != nil {
+		log.Printf("解析富文本注解失败: %v", err)
+		return nil
+	}
+
+	var dids []string
+	for _, facet := range facets {
+		if facet == nil {
+			continue
+		}
+		for _, feature := range facet.Features {
+			if feature != nil && feature.RichtextFacet_Mention != nil {
+				dids = append(dids, feature.RichtextFacet_Mention.Did)
+			}
+		}
+	}
+	return dids
+}
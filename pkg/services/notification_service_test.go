@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/types"
+)
+
+// moment 的作者, rankSeed 写入的 moment URI 都属于这个 DID
+const notifyAuthor = "did:plc:author"
+
+func likeMoment(t *testing.T, store *repositories.MetaStore, creator string, id string, likeID string) *repositories.Like {
+	t.Helper()
+	like := &repositories.Like{
+		ID:         likeID,
+		URI:        fmt.Sprintf("at://%s/app.vtri.activity.like/%s", creator, likeID),
+		Creator:    creator,
+		SubjectURI: momentURI(id),
+		CreatedAt:  time.Now().Unix(),
+	}
+	if err := store.MomentRepo.CreateLike(like); err != nil {
+		t.Fatalf("like %s: %v", id, err)
+	}
+	NotifyLike(store, like)
+	return like
+}
+
+func listNotifications(t *testing.T, service *NotificationService, did string, unreadOnly bool) []*types.Notification {
+	t.Helper()
+	list, err := service.List(context.Background(), did, unreadOnly, 50, "")
+	if err != nil {
+		t.Fatalf("list notifications: %v", err)
+	}
+	return list.Notifications
+}
+
+func describeNotifications(notifications []*types.Notification) string {
+	items := make([]string, 0, len(notifications))
+	for _, notification := range notifications {
+		items = append(items, fmt.Sprintf("%s:%s:%d", notification.Reason, notification.Author.Did, notification.Count))
+	}
+	return strings.Join(items, ",")
+}
+
+func unreadCount(t *testing.T, service *NotificationService, did string) int64 {
+	t.Helper()
+	count, err := service.UnreadCount(context.Background(), did)
+	if err != nil {
+		t.Fatalf("unread count: %v", err)
+	}
+	return count
+}
+
+func TestNotificationLikesAreGroupedPerMoment(t *testing.T) {
+	store := newTestMetaStore(t)
+	service := NewNotificationService(&config.SocialConfig{}, store)
+	s := &rankSeed{t: t, store: store, now: time.Now()}
+	s.moment("popular", notifyAuthor, time.Hour)
+	s.moment("quiet", notifyAuthor, time.Hour)
+
+	for i := 0; i < 7; i++ {
+		likeMoment(t, store, fmt.Sprintf("did:plc:fan%d", i), "popular", fmt.Sprintf("like%d", i))
+	}
+	likeMoment(t, store, "did:plc:fan0", "quiet", "like-quiet")
+	// 自己点赞不产生通知, 重复写入同一条点赞只保留一条通知
+	likeMoment(t, store, notifyAuthor, "popular", "self-like")
+	NotifyLike(store, &repositories.Like{URI: "at://did:plc:fan0/app.vtri.activity.like/like0", Creator: "did:plc:fan0", SubjectURI: momentURI("popular")})
+
+	notifications := listNotifications(t, service, notifyAuthor, false)
+	if got := describeNotifications(notifications); got != "like:did:plc:fan0:1,like:did:plc:fan6:7" {
+		t.Fatalf("notifications = %s, want the quiet like and one group of 7", got)
+	}
+	group := notifications[1]
+	if len(group.IDs) != 7 || len(group.Authors) != maxGroupedAuthors || group.ReasonSubject != momentURI("popular") {
+		t.Fatalf("group = %d ids, %d authors, subject %s", len(group.IDs), len(group.Authors), group.ReasonSubject)
+	}
+	if group.IsRead || unreadCount(t, service, notifyAuthor) != 8 {
+		t.Fatalf("group read = %v, unread = %d, want 8 unread", group.IsRead, unreadCount(t, service, notifyAuthor))
+	}
+
+	// 组内只要有一条未读, 整组就是未读
+	if err := service.MarkRead(context.Background(), notifyAuthor, group.IDs[1:]); err != nil {
+		t.Fatalf("mark read: %v", err)
+	}
+	if group := listNotifications(t, service, notifyAuthor, false)[1]; group.IsRead {
+		t.Fatalf("group with one unread like is read")
+	}
+	if unread := listNotifications(t, service, notifyAuthor, true); describeNotifications(unread) != "like:did:plc:fan0:1,like:did:plc:fan6:1" {
+		t.Fatalf("unread notifications = %s", describeNotifications(unread))
+	}
+}
+
+func TestNotificationRepliesAndMentions(t *testing.T) {
+	store := newTestMetaStore(t)
+	service := NewNotificationService(&config.SocialConfig{}, store)
+	s := &rankSeed{t: t, store: store, now: time.Now()}
+	parent := s.moment("parent", notifyAuthor, time.Hour)
+
+	mention := func(did string) string {
+		return fmt.Sprintf(`{"index":{"byteStart":0,"byteEnd":4},"features":[{"$type":"app.bsky.richtext.facet#mention","did":%q}]}`, did)
+	}
+	reply := &repositories.Moment{
+		ID:            "reply",
+		URI:           "at://did:plc:bob/app.vtri.activity.moment/reply",
+		Creator:       "did:plc:bob",
+		ReplyParentID: parent.ID,
+		ReplyRootID:   parent.ID,
+		// 被回复的作者同时被提及, 只收到回复通知; 提及自己不通知
+		Facets:    "[" + mention(notifyAuthor) + "," + mention("did:plc:carol") + "," + mention("did:plc:bob") + "]",
+		CreatedAt: time.Now().Unix(),
+	}
+	NotifyMoment(store, reply)
+
+	if got := describeNotifications(listNotifications(t, service, notifyAuthor, false)); got != "reply:did:plc:bob:1" {
+		t.Errorf("author notifications = %s, want one reply", got)
+	}
+	if got := describeNotifications(listNotifications(t, service, "did:plc:carol", false)); got != "mention:did:plc:bob:1" {
+		t.Errorf("carol notifications = %s, want one mention", got)
+	}
+	if got := listNotifications(t, service, "did:plc:bob", false); len(got) != 0 {
+		t.Errorf("bob notifications = %s, want none", describeNotifications(got))
+	}
+}
+
+func TestNotificationBlocksSuppressAndHide(t *testing.T) {
+	store := newTestMetaStore(t)
+	service := NewNotificationService(&config.SocialConfig{}, store)
+	s := &rankSeed{t: t, store: store, now: time.Now()}
+	s.moment("m1", notifyAuthor, time.Hour)
+
+	likeMoment(t, store, "did:plc:early", "m1", "like-early")
+	s.relate(notifyAuthor, repositories.RelationshipBlock, "did:plc:early")
+	s.relate(notifyAuthor, repositories.RelationshipBlock, "did:plc:blocked")
+	s.relate("did:plc:blocker", repositories.RelationshipBlock, notifyAuthor)
+
+	// 屏蔽关系存在时不写入通知, 无论哪一方发起屏蔽
+	likeMoment(t, store, "did:plc:blocked", "m1", "like-blocked")
+	likeMoment(t, store, "did:plc:blocker", "m1", "like-blocker")
+	NotifyFollow(store, &repositories.Relationship{URI: "at://did:plc:blocked/app.vtri.activity.relationship/f1", Creator: "did:plc:blocked", Predicate: repositories.RelationshipFollow, Object: notifyAuthor})
+	likeMoment(t, store, "did:plc:friend", "m1", "like-friend")
+
+	if n := countRows(t, store, &repositories.Notification{}); n != 2 {
+		t.Fatalf("%d notifications stored, want the early and friend likes only", n)
+	}
+	// 屏蔽之前产生的通知在列表中隐藏
+	if got := describeNotifications(listNotifications(t, service, notifyAuthor, false)); got != "like:did:plc:friend:1" {
+		t.Fatalf("notifications = %s, want only the friend's like", got)
+	}
+}
+
+func TestNotificationRetraction(t *testing.T) {
+	store := newTestMetaStore(t)
+	service := NewNotificationService(&config.SocialConfig{}, store)
+	s := &rankSeed{t: t, store: store, now: time.Now()}
+	s.moment("m1", notifyAuthor, time.Hour)
+	s.moment("m2", notifyAuthor, time.Hour)
+
+	like := likeMoment(t, store, "did:plc:fan", "m1", "like1")
+	likeMoment(t, store, "did:plc:fan", "m2", "like2")
+	NotifyMoment(store, &repositories.Moment{ID: "reply", URI: "at://did:plc:fan/app.vtri.activity.moment/reply", Creator: "did:plc:fan", ReplyParentID: "m2"})
+	NotifyFollow(store, &repositories.Relationship{URI: "at://did:plc:fan/app.vtri.activity.relationship/f1", Creator: "did:plc:fan", Predicate: repositories.RelationshipFollow, Object: notifyAuthor})
+	if unreadCount(t, service, notifyAuthor) != 4 {
+		t.Fatalf("unread = %d, want 4", unreadCount(t, service, notifyAuthor))
+	}
+
+	// 取消点赞撤回点赞通知, 删除 moment 撤回对它的点赞和回复通知
+	RetractNotifications(store, like.URI)
+	if got := describeNotifications(listNotifications(t, service, notifyAuthor, false)); got != "follow:did:plc:fan:1,reply:did:plc:fan:1,like:did:plc:fan:1" {
+		t.Fatalf("after unlike = %s", got)
+	}
+	RetractNotifications(store, momentURI("m2"))
+	if got := describeNotifications(listNotifications(t, service, notifyAuthor, false)); got != "follow:did:plc:fan:1" {
+		t.Fatalf("after deleting m2 = %s, want only the follow", got)
+	}
+	if unreadCount(t, service, notifyAuthor) != 1 {
+		t.Fatalf("unread = %d, want 1", unreadCount(t, service, notifyAuthor))
+	}
+}
+
+func TestNotificationIsPushedToOnlineRecipient(t *testing.T) {
+	store := newTestMetaStore(t)
+	s := &rankSeed{t: t, store: store, now: time.Now()}
+	s.moment("m1", notifyAuthor, time.Hour)
+
+	sub := DefaultNotificationHub().Subscribe(notifyAuthor)
+	defer sub.Close()
+	likeMoment(t, store, "did:plc:fan0", "m1", "like0")
+	likeMoment(t, store, "did:plc:fan1", "m1", "like1")
+
+	for want := int64(1); want <= 2; want++ {
+		select {
+		case event := <-sub.Events():
+			if event.UnreadCount != want || event.Notification.Reason != repositories.NotificationReasonLike {
+				t.Fatalf("event = %+v, want a like with %d unread", event, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no event %d pushed", want)
+		}
+	}
+}
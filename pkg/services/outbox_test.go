@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestMetaStore(t *testing.T) *repositories.MetaStore {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.sqlite")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	store := repositories.NewMetaStore(db)
+	if err := store.Init(); err != nil {
+		t.Fatalf("init metastore: %v", err)
+	}
+	return store
+}
+
+// breakOutbox 删除同步队列表, 之后的入队操作都会失败
+func breakOutbox(t *testing.T, store *repositories.MetaStore) {
+	t.Helper()
+	if err := store.DB.Migrator().DropTable(&repositories.PDSOutboxOp{}); err != nil {
+		t.Fatalf("drop outbox: %v", err)
+	}
+}
+
+func countRows(t *testing.T, store *repositories.MetaStore, model interface{}) int64 {
+	t.Helper()
+	var count int64
+	if err := store.DB.Model(model).Count(&count).Error; err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	return count
+}
+
+func TestCreateMomentEnqueuesRecordOps(t *testing.T) {
+	store := newTestMetaStore(t)
+	service := NewMomentService(&config.SocialConfig{}, store)
+
+	moment, err := service.CreateMoment(context.Background(), "did:plc:alice", &CreateMomentRequest{Text: "hello", Tags: []string{"go"}})
+	if err != nil {
+		t.Fatalf("create moment: %v", err)
+	}
+
+	var ops []*repositories.PDSOutboxOp
+	if err := store.DB.Order("id").Find(&ops).Error; err != nil {
+		t.Fatalf("list outbox: %v", err)
+	}
+	got := make(map[string]string)
+	for _, op := range ops {
+		if op.Did != "did:plc:alice" || op.Action != repositories.OutboxActionCreate || op.Status != repositories.OutboxStatusPending {
+			t.Errorf("op = %+v, want a pending create for alice", op)
+		}
+		got[op.Collection] = op.Rkey
+	}
+	if got["app.vtri.activity.moment"] != moment.ID {
+		t.Errorf("moment op rkey = %q, want %q", got["app.vtri.activity.moment"], moment.ID)
+	}
+	if _, ok := got["app.vtri.activity.tag"]; !ok {
+		t.Errorf("ops = %v, want the new tag enqueued", got)
+	}
+}
+
+func TestCreateMomentRollsBackWhenEnqueueFails(t *testing.T) {
+	store := newTestMetaStore(t)
+	service := NewMomentService(&config.SocialConfig{}, store)
+	breakOutbox(t, store)
+
+	if _, err := service.CreateMoment(context.Background(), "did:plc:alice", &CreateMomentRequest{Text: "hello", Tags: []string{"go"}}); err == nil {
+		t.Fatal("create moment succeeded without a sync op")
+	}
+	if n := countRows(t, store, &repositories.Moment{}); n != 0 {
+		t.Errorf("%d moments left behind, want the write rolled back", n)
+	}
+	if n := countRows(t, store, &repositories.Tag{}); n != 0 {
+		t.Errorf("%d tags left behind, want the write rolled back", n)
+	}
+	if n := countRows(t, store, &repositories.ActivityTag{}); n != 0 {
+		t.Errorf("%d activity tags left behind, want the write rolled back", n)
+	}
+}
+
+func TestLikeMomentRollsBackWhenEnqueueFails(t *testing.T) {
+	store := newTestMetaStore(t)
+	service := NewMomentService(&config.SocialConfig{}, store)
+	moment, err := service.CreateMoment(context.Background(), "did:plc:alice", &CreateMomentRequest{Text: "hello"})
+	if err != nil {
+		t.Fatalf("create moment: %v", err)
+	}
+	breakOutbox(t, store)
+
+	if _, err := service.LikeMoment(context.Background(), moment.URI, "did:plc:bob"); err == nil {
+		t.Fatal("like succeeded without a sync op")
+	}
+	if n := countRows(t, store, &repositories.Like{}); n != 0 {
+		t.Errorf("%d likes left behind, want the write rolled back", n)
+	}
+}
+
+func TestFollowRollsBackWhenEnqueueFails(t *testing.T) {
+	store := newTestMetaStore(t)
+	service := NewRelationshipService(&config.SocialConfig{}, store)
+	breakOutbox(t, store)
+
+	if _, err := service.Follow(context.Background(), "did:plc:alice", "did:plc:bob"); err == nil {
+		t.Fatal("follow succeeded without a sync op")
+	}
+	if n := countRows(t, store, &repositories.Relationship{}); n != 0 {
+		t.Errorf("%d relationships left behind, want the write rolled back", n)
+	}
+}
@@ -0,0 +1,582 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/types"
+)
+
+const (
+	rankedWindow         = 72 * time.Hour // 候选 moment 的时间窗
+	rankedCandidateLimit = 500            // 每个候选源最多取出的条数
+	rankedMaxItems       = 300            // 排序结果最多可翻页的条数
+)
+
+// RankContext 一次排序请求共享的数据, 候选源和打分器只读
+type RankContext struct {
+	Viewer    string
+	Now       time.Time
+	Following map[string]bool
+	Blocked   map[string]bool
+	Muted     map[string]bool
+	Seen      map[string]bool    // viewer 在时间窗内点赞或回复过的 moment URI
+	Affinity  map[string]float64 // 作者 DID -> viewer 与作者的互动次数
+	Interests map[string]float64 // "tag:x" / "topic:x" -> viewer 历史中出现的次数
+}
+
+// RankCandidate 待排序的 moment 及其打分过程
+type RankCandidate struct {
+	Moment     *repositories.Moment
+	Agg        *repositories.MomentAgg
+	Labels     []string // "tag:x" / "topic:x"
+	Sources    []string
+	Components map[string]float64
+	Score      float64
+}
+
+// CandidateSource 产生候选 moment
+type CandidateSource interface {
+	Name() string
+	Candidates(ctx context.Context, rc *RankContext) ([]*repositories.Moment, error)
+}
+
+// Scorer 为候选打一个分量, 各分量相加得到总分.
+// 分量都在对数尺度上, 相加等价于线性尺度上的相乘, 时间衰减因此可以作为一个负分量
+type Scorer interface {
+	Name() string
+	Score(rc *RankContext, candidate *RankCandidate) float64
+}
+
+// CandidateFilter 在打分前或排序后处理候选列表, 输入按得分倒序
+type CandidateFilter interface {
+	Name() string
+	Filter(rc *RankContext, candidates []*RankCandidate) []*RankCandidate
+}
+
+// RankedFeedGenerator "为你推荐" feed: 候选源 -> 打分器 -> 过滤器.
+// 游标记录排序时刻和偏移量, 翻页时按同一时刻重新排序. 候选、互动计数、关注、已看过和兴趣都只统计
+// 该时刻之前创建的记录, 之后的内容和互动不会让已经翻过的条目重复或被跳过; 屏蔽和静音总是立即生效
+type RankedFeedGenerator struct {
+	metaStore  *repositories.MetaStore
+	sources    []CandidateSource
+	preFilters []CandidateFilter
+	scorers    []Scorer
+	filters    []CandidateFilter
+}
+
+func NewRankedFeedGenerator(metaStore *repositories.MetaStore) *RankedFeedGenerator {
+	return &RankedFeedGenerator{
+		metaStore: metaStore,
+		sources: []CandidateSource{
+			&RecentCandidateSource{metaStore: metaStore},
+			&FollowingCandidateSource{metaStore: metaStore},
+		},
+		preFilters: []CandidateFilter{
+			&BlockedAuthorFilter{},
+			&MutedAuthorFilter{},
+			&SeenFilter{},
+		},
+		scorers: []Scorer{
+			&EngagementScorer{LikeWeight: 1, ReplyWeight: 2},
+			&TimeDecayScorer{HalfLife: 12 * time.Hour},
+			&AffinityScorer{Weight: 0.5, FollowBonus: 0.5},
+			&InterestScorer{Weight: 0.8},
+		},
+		filters: []CandidateFilter{
+			&ThreadDedupeFilter{},
+			&AuthorDiversityFilter{Penalty: 0.7},
+		},
+	}
+}
+
+func (g *RankedFeedGenerator) AddSource(source CandidateSource) {
+	g.sources = append(g.sources, source)
+}
+
+func (g *RankedFeedGenerator) AddScorer(scorer Scorer) {
+	g.scorers = append(g.scorers, scorer)
+}
+
+func (g *RankedFeedGenerator) AddFilter(filter CandidateFilter) {
+	g.filters = append(g.filters, filter)
+}
+
+func (g *RankedFeedGenerator) GenerateFeed(ctx context.Context, query *FeedQuery) ([]string, string, error) {
+	uris, nextCursor, _, err := g.ExplainFeed(ctx, query)
+	return uris, nextCursor, err
+}
+
+// ExplainFeed 返回当前页以及每条的得分明细
+func (g *RankedFeedGenerator) ExplainFeed(ctx context.Context, query *FeedQuery) ([]string, string, map[string]*types.ScoreExplanation, error) {
+	now, offset, err := decodeRankedCursor(query.Cursor)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	ranked, err := g.Rank(ctx, query.Viewer, now)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	end := len(ranked)
+	if query.Limit > 0 && offset+query.Limit < end {
+		end = offset + query.Limit
+	}
+	var page []*RankCandidate
+	if offset < end {
+		page = ranked[offset:end]
+	}
+
+	uris := make([]string, 0, len(page))
+	explanations := make(map[string]*types.ScoreExplanation, len(page))
+	for _, candidate := range page {
+		uris = append(uris, candidate.Moment.URI)
+		explanations[candidate.Moment.URI] = &types.ScoreExplanation{
+			Score:      candidate.Score,
+			Components: candidate.Components,
+			Sources:    candidate.Sources,
+		}
+	}
+
+	var nextCursor string
+	if end < len(ranked) {
+		nextCursor = encodeRankedCursor(now, end)
+	}
+	return uris, nextCursor, explanations, nil
+}
+
+// Rank 执行完整的排序流程, 返回按得分倒序的候选
+func (g *RankedFeedGenerator) Rank(ctx context.Context, viewer string, now time.Time) ([]*RankCandidate, error) {
+	rc, err := g.rankContext(viewer, now)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates, err := g.collect(ctx, rc)
+	if err != nil {
+		return nil, err
+	}
+	for _, filter := range g.preFilters {
+		candidates = filter.Filter(rc, candidates)
+	}
+	if err := g.hydrateCandidates(rc, candidates); err != nil {
+		return nil, err
+	}
+
+	for _, candidate := range candidates {
+		candidate.Components = make(map[string]float64, len(g.scorers))
+		for _, scorer := range g.scorers {
+			value := scorer.Score(rc, candidate)
+			candidate.Components[scorer.Name()] = value
+			candidate.Score += value
+		}
+	}
+	sortCandidates(candidates)
+
+	for _, filter := range g.filters {
+		candidates = filter.Filter(rc, candidates)
+	}
+	if len(candidates) > rankedMaxItems {
+		candidates = candidates[:rankedMaxItems]
+	}
+	return candidates, nil
+}
+
+func (g *RankedFeedGenerator) rankContext(viewer string, now time.Time) (*RankContext, error) {
+	rc := &RankContext{
+		Viewer:    viewer,
+		Now:       now,
+		Following: make(map[string]bool),
+		Blocked:   make(map[string]bool),
+		Muted:     make(map[string]bool),
+		Seen:      make(map[string]bool),
+		Affinity:  make(map[string]float64),
+		Interests: make(map[string]float64),
+	}
+	if viewer == "" {
+		return rc, nil
+	}
+
+	until := now.Unix()
+	following, err := g.metaStore.GraphRepo.GetFollowingDIDsAt(viewer, until)
+	if err != nil {
+		return nil, fmt.Errorf("获取关注列表失败: %w", err)
+	}
+	for _, did := range following {
+		rc.Following[did] = true
+	}
+
+	blocked, err := g.metaStore.GraphRepo.GetBlockedDIDs(viewer)
+	if err != nil {
+		return nil, fmt.Errorf("获取屏蔽关系失败: %w", err)
+	}
+	for _, did := range blocked {
+		rc.Blocked[did] = true
+	}
+
+	muted, err := g.metaStore.GraphRepo.GetMutedDIDs(viewer)
+	if err != nil {
+		return nil, fmt.Errorf("获取静音列表失败: %w", err)
+	}
+	for _, did := range muted {
+		rc.Muted[did] = true
+	}
+
+	seen, err := g.metaStore.MomentRepo.GetEngagedMomentURIs(viewer, now.Add(-rankedWindow).Unix(), until)
+	if err != nil {
+		return nil, fmt.Errorf("获取互动过的 moment 失败: %w", err)
+	}
+	for _, uri := range seen {
+		rc.Seen[uri] = true
+	}
+
+	liked, err := g.metaStore.MomentRepo.GetLikedAuthors(viewer, until)
+	if err != nil {
+		return nil, fmt.Errorf("统计点赞过的作者失败: %w", err)
+	}
+	replied, err := g.metaStore.MomentRepo.GetRepliedAuthors(viewer, until)
+	if err != nil {
+		return nil, fmt.Errorf("统计回复过的作者失败: %w", err)
+	}
+	for _, row := range liked {
+		rc.Affinity[row.Did] += float64(row.Count)
+	}
+	for _, row := range replied {
+		rc.Affinity[row.Did] += float64(row.Count)
+	}
+
+	tags, topics, err := g.metaStore.MomentRepo.GetViewerInterests(viewer, until)
+	if err != nil {
+		return nil, fmt.Errorf("统计兴趣标签失败: %w", err)
+	}
+	for _, row := range tags {
+		rc.Interests["tag:"+row.Label] += float64(row.Count)
+	}
+	for _, row := range topics {
+		rc.Interests["topic:"+row.Label] += float64(row.Count)
+	}
+	return rc, nil
+}
+
+// collect 合并各候选源的结果, 同一 moment 只保留一份并记录来源
+func (g *RankedFeedGenerator) collect(ctx context.Context, rc *RankContext) ([]*RankCandidate, error) {
+	var candidates []*RankCandidate
+	byURI := make(map[string]*RankCandidate)
+	for _, source := range g.sources {
+		moments, err := source.Candidates(ctx, rc)
+		if err != nil {
+			return nil, fmt.Errorf("候选源 %s 失败: %w", source.Name(), err)
+		}
+		for _, moment := range moments {
+			if candidate, ok := byURI[moment.URI]; ok {
+				candidate.Sources = append(candidate.Sources, source.Name())
+				continue
+			}
+			candidate := &RankCandidate{Moment: moment, Sources: []string{source.Name()}}
+			byURI[moment.URI] = candidate
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates, nil
+}
+
+func (g *RankedFeedGenerator) hydrateCandidates(rc *RankContext, candidates []*RankCandidate) error {
+	uris := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		uris = append(uris, candidate.Moment.URI)
+	}
+
+	until := rc.Now.Unix()
+	aggs, err := g.metaStore.MomentRepo.CountMomentEngagement(uris, until)
+	if err != nil {
+		return fmt.Errorf("获取互动计数失败: %w", err)
+	}
+	tags, err := g.metaStore.ActivityRepo.GetActivityTagsBySubjectURIs(uris)
+	if err != nil {
+		return fmt.Errorf("获取标签失败: %w", err)
+	}
+	topics, err := g.metaStore.ActivityRepo.GetActivityTopicsBySubjectURIs(uris)
+	if err != nil {
+		return fmt.Errorf("获取主题失败: %w", err)
+	}
+
+	for _, candidate := range candidates {
+		uri := candidate.Moment.URI
+		candidate.Agg = aggs[uri]
+		for _, tag := range tags[uri] {
+			if tag.CreatedAt > until {
+				continue
+			}
+			candidate.Labels = append(candidate.Labels, "tag:"+tag.Tag)
+		}
+		for _, topic := range topics[uri] {
+			if topic.CreatedAt > until {
+				continue
+			}
+			candidate.Labels = append(candidate.Labels, "topic:"+topic.Topic)
+		}
+	}
+	return nil
+}
+
+// RecentCandidateSource 时间窗内的全站 moment
+type RecentCandidateSource struct {
+	metaStore *repositories.MetaStore
+}
+
+func (s *RecentCandidateSource) Name() string {
+	return "recent"
+}
+
+func (s *RecentCandidateSource) Candidates(ctx context.Context, rc *RankContext) ([]*repositories.Moment, error) {
+	since := rc.Now.Add(-rankedWindow).Unix()
+	return s.metaStore.MomentRepo.GetRankingCandidates(nil, since, rc.Now.Unix(), rankedCandidateLimit)
+}
+
+// FollowingCandidateSource 时间窗内关注的人的 moment, 避免被全站的新内容挤出候选
+type FollowingCandidateSource struct {
+	metaStore *repositories.MetaStore
+}
+
+func (s *FollowingCandidateSource) Name() string {
+	return "following"
+}
+
+func (s *FollowingCandidateSource) Candidates(ctx context.Context, rc *RankContext) ([]*repositories.Moment, error) {
+	creators := make([]string, 0, len(rc.Following))
+	for did := range rc.Following {
+		creators = append(creators, did)
+	}
+	since := rc.Now.Add(-rankedWindow).Unix()
+	return s.metaStore.MomentRepo.GetRankingCandidates(creators, since, rc.Now.Unix(), rankedCandidateLimit)
+}
+
+// EngagementScorer 点赞和回复数, 取 log1p 避免热门内容垄断
+type EngagementScorer struct {
+	LikeWeight  float64
+	ReplyWeight float64
+}
+
+func (s *EngagementScorer) Name() string {
+	return "engagement"
+}
+
+func (s *EngagementScorer) Score(rc *RankContext, candidate *RankCandidate) float64 {
+	if candidate.Agg == nil {
+		return 0
+	}
+	return s.LikeWeight*math.Log1p(float64(candidate.Agg.LikeCount)) +
+		s.ReplyWeight*math.Log1p(float64(candidate.Agg.ReplyCount))
+}
+
+// TimeDecayScorer 按半衰期衰减, 每过一个半衰期得分减少 ln2
+type TimeDecayScorer struct {
+	HalfLife time.Duration
+}
+
+func (s *TimeDecayScorer) Name() string {
+	return "time_decay"
+}
+
+func (s *TimeDecayScorer) Score(rc *RankContext, candidate *RankCandidate) float64 {
+	age := rc.Now.Sub(time.Unix(candidate.Moment.IndexedAt, 0))
+	if age < 0 {
+		age = 0
+	}
+	return -math.Ln2 * age.Hours() / s.HalfLife.Hours()
+}
+
+// AffinityScorer viewer 与作者的互动次数, 关注的作者额外加分
+type AffinityScorer struct {
+	Weight      float64
+	FollowBonus float64
+}
+
+func (s *AffinityScorer) Name() string {
+	return "affinity"
+}
+
+func (s *AffinityScorer) Score(rc *RankContext, candidate *RankCandidate) float64 {
+	author := candidate.Moment.Creator
+	score := s.Weight * math.Log1p(rc.Affinity[author])
+	if rc.Following[author] {
+		score += s.FollowBonus
+	}
+	return score
+}
+
+// InterestScorer 候选的标签/主题与 viewer 历史的重合程度
+type InterestScorer struct {
+	Weight float64
+}
+
+func (s *InterestScorer) Name() string {
+	return "interest"
+}
+
+func (s *InterestScorer) Score(rc *RankContext, candidate *RankCandidate) float64 {
+	var overlap float64
+	seen := make(map[string]bool, len(candidate.Labels))
+	for _, label := range candidate.Labels {
+		if seen[label] {
+			continue
+		}
+		seen[label] = true
+		overlap += rc.Interests[label]
+	}
+	return s.Weight * math.Log1p(overlap)
+}
+
+// BlockedAuthorFilter 去掉与 viewer 存在屏蔽关系的作者和 viewer 自己的 moment
+type BlockedAuthorFilter struct{}
+
+func (f *BlockedAuthorFilter) Name() string {
+	return "blocked_author"
+}
+
+func (f *BlockedAuthorFilter) Filter(rc *RankContext, candidates []*RankCandidate) []*RankCandidate {
+	ret := candidates[:0]
+	for _, candidate := range candidates {
+		author := candidate.Moment.Creator
+		if rc.Blocked[author] || author == rc.Viewer {
+			continue
+		}
+		ret = append(ret, candidate)
+	}
+	return ret
+}
+
+// MutedAuthorFilter 去掉 viewer 静音的作者的 moment
+type MutedAuthorFilter struct{}
+
+func (f *MutedAuthorFilter) Name() string {
+	return "muted_author"
+}
+
+func (f *MutedAuthorFilter) Filter(rc *RankContext, candidates []*RankCandidate) []*RankCandidate {
+	ret := candidates[:0]
+	for _, candidate := range candidates {
+		if !rc.Muted[candidate.Moment.Creator] {
+			ret = append(ret, candidate)
+		}
+	}
+	return ret
+}
+
+// SeenFilter 去掉 viewer 已经点赞或回复过的 moment
+type SeenFilter struct{}
+
+func (f *SeenFilter) Name() string {
+	return "seen"
+}
+
+func (f *SeenFilter) Filter(rc *RankContext, candidates []*RankCandidate) []*RankCandidate {
+	ret := candidates[:0]
+	for _, candidate := range candidates {
+		if !rc.Seen[candidate.Moment.URI] {
+			ret = append(ret, candidate)
+		}
+	}
+	return ret
+}
+
+// ThreadDedupeFilter 同一个帖子树只保留得分最高的一条
+type ThreadDedupeFilter struct{}
+
+func (f *ThreadDedupeFilter) Name() string {
+	return "thread_dedupe"
+}
+
+func (f *ThreadDedupeFilter) Filter(rc *RankContext, candidates []*RankCandidate) []*RankCandidate {
+	ret := make([]*RankCandidate, 0, len(candidates))
+	seen := make(map[string]bool, len(candidates))
+	for _, candidate := range candidates {
+		root := candidate.Moment.ReplyRootID
+		if root == "" {
+			root = candidate.Moment.ID
+		}
+		if seen[root] {
+			continue
+		}
+		seen[root] = true
+		ret = append(ret, candidate)
+	}
+	return ret
+}
+
+// AuthorDiversityFilter 同一作者的第 n 条 (从 0 计) 额外扣除 n*Penalty 后重新排序, 打散同一作者的连续内容
+type AuthorDiversityFilter struct {
+	Penalty float64
+}
+
+func (f *AuthorDiversityFilter) Name() string {
+	return "author_diversity"
+}
+
+func (f *AuthorDiversityFilter) Filter(rc *RankContext, candidates []*RankCandidate) []*RankCandidate {
+	counts := make(map[string]int)
+	for _, candidate := range candidates {
+		author := candidate.Moment.Creator
+		if n := counts[author]; n > 0 {
+			penalty := -f.Penalty * float64(n)
+			candidate.Components[f.Name()] = penalty
+			candidate.Score += penalty
+		}
+		counts[author]++
+	}
+	sortCandidates(candidates)
+	return candidates
+}
+
+// sortCandidates 按得分倒序, 同分时新的在前
+func sortCandidates(candidates []*RankCandidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		if candidates[i].Moment.IndexedAt != candidates[j].Moment.IndexedAt {
+			return candidates[i].Moment.IndexedAt > candidates[j].Moment.IndexedAt
+		}
+		return candidates[i].Moment.ID > candidates[j].Moment.ID
+	})
+}
+
+// 排序 feed 的游标为 "r:<排序时刻 unix 毫秒>:<偏移量>" 的 base64url 编码
+func encodeRankedCursor(now time.Time, offset int) string {
+	raw := "r:" + strconv.FormatInt(now.UnixMilli(), 10) + ":" + strconv.Itoa(offset)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeRankedCursor(cursor string) (time.Time, int, error) {
+	if cursor == "" {
+		return time.Time{}, 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 3 || parts[0] != "r" {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	millis, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	offset, err := strconv.Atoi(parts[2])
+	if err != nil || offset < 0 {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	return time.UnixMilli(millis), offset, nil
+}
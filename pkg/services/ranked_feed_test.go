@@ -0,0 +1,356 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+)
+
+func (s *rankSeed) like(creator string, id string) {
+	s.t.Helper()
+	likeID := fmt.Sprintf("like%d", s.fans)
+	s.fans++
+	err := s.store.MomentRepo.CreateLike(&repositories.Like{
+		ID:         likeID,
+		URI:        fmt.Sprintf("at://%s/app.vtri.activity.like/%s", creator, likeID),
+		Creator:    creator,
+		SubjectURI: momentURI(id),
+		CreatedAt:  s.now.Add(-time.Hour).Unix(),
+	})
+	if err != nil {
+		s.t.Fatalf("like %s: %v", id, err)
+	}
+	s.refresh(id)
+}
+
+// likes 由不同的用户点赞 n 次
+func (s *rankSeed) likes(id string, n int) {
+	for i := 0; i < n; i++ {
+		s.like(fmt.Sprintf("did:plc:fan%d", s.fans), id)
+	}
+}
+
+func (s *rankSeed) refresh(id string) {
+	s.t.Helper()
+	if err := s.store.MomentRepo.RefreshMomentAgg(momentURI(id)); err != nil {
+		s.t.Fatalf("refresh agg %s: %v", id, err)
+	}
+}
+
+func rankedIDs(candidates []*RankCandidate) string {
+	ids := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		ids = append(ids, candidate.Moment.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestRankedFeedGenerator(t *testing.T) {
+	tests := []struct {
+		name string
+		seed func(s *rankSeed)
+		// scorers 为空时使用默认的排序流程, 否则只用这些打分器并去掉排序后的过滤器
+		scorers []Scorer
+		want    string
+		check   func(t *testing.T, ranked []*RankCandidate)
+	}{
+		{
+			name: "replies weigh more than likes",
+			seed: func(s *rankSeed) {
+				s.moment("liked", "did:plc:a", time.Hour)
+				s.likes("liked", 2)
+				s.moment("replied", "did:plc:b", time.Hour)
+				s.reply("old-reply", "did:plc:c", "replied", 100*time.Hour)
+			},
+			scorers: []Scorer{&EngagementScorer{LikeWeight: 1, ReplyWeight: 2}},
+			want:    "replied,liked",
+		},
+		{
+			name: "likes win with equal weights",
+			seed: func(s *rankSeed) {
+				s.moment("liked", "did:plc:a", time.Hour)
+				s.likes("liked", 2)
+				s.moment("replied", "did:plc:b", time.Hour)
+				s.reply("old-reply", "did:plc:c", "replied", 100*time.Hour)
+			},
+			scorers: []Scorer{&EngagementScorer{LikeWeight: 1, ReplyWeight: 1}},
+			want:    "liked,replied",
+		},
+		{
+			name: "time decay halves per half-life",
+			seed: func(s *rankSeed) {
+				s.moment("old", "did:plc:a", 24*time.Hour)
+				s.moment("new", "did:plc:b", 12*time.Hour)
+				s.moment("expired", "did:plc:c", 80*time.Hour)
+			},
+			scorers: []Scorer{&TimeDecayScorer{HalfLife: 12 * time.Hour}},
+			want:    "new,old",
+			check: func(t *testing.T, ranked []*RankCandidate) {
+				if got := ranked[0].Components["time_decay"]; math.Abs(got+math.Ln2) > 1e-9 {
+					t.Errorf("time_decay after one half-life = %v, want -ln2", got)
+				}
+				if got := ranked[1].Components["time_decay"]; math.Abs(got+2*math.Ln2) > 1e-9 {
+					t.Errorf("time_decay after two half-lives = %v, want -2ln2", got)
+				}
+			},
+		},
+		{
+			name: "affinity and follow bonus",
+			seed: func(s *rankSeed) {
+				s.moment("liked-before", "did:plc:friend", 100*time.Hour)
+				s.like(rankViewer, "liked-before")
+				s.moment("stranger", "did:plc:stranger", time.Hour)
+				s.moment("friend", "did:plc:friend", time.Hour)
+				s.moment("followed", "did:plc:followed", time.Hour)
+				s.relate(rankViewer, repositories.RelationshipFollow, "did:plc:followed")
+			},
+			scorers: []Scorer{&AffinityScorer{Weight: 1, FollowBonus: 2}},
+			want:    "followed,friend,stranger",
+			check: func(t *testing.T, ranked []*RankCandidate) {
+				if got := ranked[1].Components["affinity"]; math.Abs(got-math.Ln2) > 1e-9 {
+					t.Errorf("affinity after one like = %v, want ln2", got)
+				}
+			},
+		},
+		{
+			name: "interest overlap with the viewer's tags",
+			seed: func(s *rankSeed) {
+				s.moment("own", rankViewer, 100*time.Hour)
+				s.tag("own", "go")
+				s.moment("untagged", "did:plc:a", time.Hour)
+				s.moment("tagged", "did:plc:b", time.Hour)
+				s.tag("tagged", "go")
+				s.tag("tagged", "rust")
+			},
+			scorers: []Scorer{&InterestScorer{Weight: 1}},
+			want:    "tagged,untagged",
+		},
+		{
+			name: "sources are merged without duplicates",
+			seed: func(s *rankSeed) {
+				s.moment("followed", "did:plc:followed", time.Hour)
+				s.moment("other", "did:plc:other", 2*time.Hour)
+				s.relate(rankViewer, repositories.RelationshipFollow, "did:plc:followed")
+			},
+			want: "followed,other",
+			check: func(t *testing.T, ranked []*RankCandidate) {
+				if got := strings.Join(ranked[0].Sources, ","); got != "recent,following" {
+					t.Errorf("followed sources = %s, want recent,following", got)
+				}
+				if got := strings.Join(ranked[1].Sources, ","); got != "recent" {
+					t.Errorf("other sources = %s, want recent", got)
+				}
+			},
+		},
+		{
+			name: "blocked authors in both directions and own moments are removed",
+			seed: func(s *rankSeed) {
+				s.moment("own", rankViewer, time.Hour)
+				s.moment("blocked", "did:plc:blocked", time.Hour)
+				s.moment("blocker", "did:plc:blocker", time.Hour)
+				s.moment("visible", "did:plc:a", 2*time.Hour)
+				s.relate(rankViewer, repositories.RelationshipBlock, "did:plc:blocked")
+				s.relate("did:plc:blocker", repositories.RelationshipBlock, rankViewer)
+			},
+			want: "visible",
+		},
+		{
+			name: "muted authors are removed",
+			seed: func(s *rankSeed) {
+				s.moment("muted", "did:plc:muted", time.Hour)
+				s.moment("visible", "did:plc:a", 2*time.Hour)
+				s.relate(rankViewer, repositories.RelationshipMute, "did:plc:muted")
+				// 静音是单向的, 被对方静音不影响自己的 feed
+				s.moment("muter", "did:plc:muter", 3*time.Hour)
+				s.relate("did:plc:muter", repositories.RelationshipMute, rankViewer)
+			},
+			want: "visible,muter",
+		},
+		{
+			name: "liked and replied moments are seen",
+			seed: func(s *rankSeed) {
+				s.moment("liked", "did:plc:a", time.Hour)
+				s.like(rankViewer, "liked")
+				s.moment("replied", "did:plc:b", time.Hour)
+				s.reply("viewer-reply", rankViewer, "replied", 30*time.Minute)
+				s.moment("fresh", "did:plc:c", 2*time.Hour)
+			},
+			want: "fresh",
+		},
+		{
+			name: "threads are deduped and authors diversified",
+			seed: func(s *rankSeed) {
+				s.moment("root", "did:plc:a", 2*time.Hour)
+				s.reply("reply", "did:plc:b", "root", time.Hour)
+				s.moment("a2", "did:plc:a", 3*time.Hour)
+				s.moment("a3", "did:plc:a", 3*time.Hour+time.Minute)
+				s.moment("c1", "did:plc:c", 5*time.Hour)
+			},
+			// root 有回复, 得分高于 reply, 代表整个帖子树; a 的第二、三条 moment 被扣分后排到 c1 之后
+			want: "root,c1,a2,a3",
+			check: func(t *testing.T, ranked []*RankCandidate) {
+				for _, candidate := range ranked {
+					if candidate.Moment.ID == "a3" && candidate.Components["author_diversity"] >= 0 {
+						t.Errorf("a3 components = %v, want a diversity penalty", candidate.Components)
+					}
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(1700000000, 0)
+			store := newTestMetaStore(t)
+			tt.seed(&rankSeed{t: t, store: store, now: now})
+
+			generator := NewRankedFeedGenerator(store)
+			if tt.scorers != nil {
+				generator.scorers = tt.scorers
+				generator.filters = nil
+			}
+			ranked, err := generator.Rank(context.Background(), rankViewer, now)
+			if err != nil {
+				t.Fatalf("rank: %v", err)
+			}
+			if got := rankedIDs(ranked); got != tt.want {
+				t.Fatalf("ranked = %s, want %s", got, tt.want)
+			}
+			if tt.check != nil {
+				tt.check(t, ranked)
+			}
+		})
+	}
+}
+
+func TestRankedFeedExplainsScores(t *testing.T) {
+	store := newTestMetaStore(t)
+	// ExplainFeed 以当前时间排序, 数据以当前时间为基准写入
+	seed := &rankSeed{t: t, store: store, now: time.Now()}
+	for i := 0; i < 5; i++ {
+		id := fmt.Sprintf("m%d", i)
+		seed.moment(id, fmt.Sprintf("did:plc:author%d", i%2), time.Duration(i+1)*time.Hour)
+		seed.likes(id, i)
+	}
+	seed.relate(rankViewer, repositories.RelationshipFollow, "did:plc:author1")
+
+	generator := NewRankedFeedGenerator(store)
+	var all []string
+	cursor := ""
+	for page := 0; ; page++ {
+		if page > 5 {
+			t.Fatal("pagination did not terminate")
+		}
+		uris, next, explanations, err := generator.ExplainFeed(context.Background(), &FeedQuery{Viewer: rankViewer, Limit: 2, Cursor: cursor, Debug: true})
+		if err != nil {
+			t.Fatalf("explain: %v", err)
+		}
+		if len(explanations) != len(uris) {
+			t.Fatalf("%d explanations for %d uris", len(explanations), len(uris))
+		}
+		for _, uri := range uris {
+			explanation := explanations[uri]
+			var sum float64
+			for _, name := range []string{"engagement", "time_decay", "affinity", "interest"} {
+				if _, ok := explanation.Components[name]; !ok {
+					t.Errorf("%s components = %v, missing %s", uri, explanation.Components, name)
+				}
+			}
+			for _, value := range explanation.Components {
+				sum += value
+			}
+			if math.Abs(sum-explanation.Score) > 1e-9 {
+				t.Errorf("%s components sum to %v, score %v", uri, sum, explanation.Score)
+			}
+			if len(explanation.Sources) == 0 {
+				t.Errorf("%s has no sources", uri)
+			}
+		}
+		all = append(all, uris...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if len(all) != 5 {
+		t.Fatalf("paged through %d moments, want 5", len(all))
+	}
+
+	if _, _, _, err := generator.ExplainFeed(context.Background(), &FeedQuery{Cursor: "bad cursor"}); err != ErrInvalidCursor {
+		t.Errorf("bad cursor err = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestRankedFeedCursorIgnoresLaterActivity(t *testing.T) {
+	store := newTestMetaStore(t)
+	seed := &rankSeed{t: t, store: store, now: time.Now()}
+	for i := 0; i < 6; i++ {
+		id := fmt.Sprintf("m%d", i)
+		seed.moment(id, fmt.Sprintf("did:plc:author%d", i), time.Duration(i+1)*time.Hour)
+		seed.likes(id, 6-i)
+	}
+
+	generator := NewRankedFeedGenerator(store)
+	query := &FeedQuery{Viewer: rankViewer, Limit: 100}
+	uris, _, err := generator.GenerateFeed(context.Background(), query)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	want := feedIDs(uris)
+
+	query.Limit = 2
+	all, cursor, err := generator.GenerateFeed(context.Background(), query)
+	if err != nil {
+		t.Fatalf("first page: %v", err)
+	}
+
+	// 翻页之间发生的互动、关注和回复都晚于游标记录的排序时刻, 不能改变后续页的排序
+	later := &rankSeed{t: t, store: store, now: time.Now().Add(2 * time.Hour), fans: seed.fans}
+	later.likes("m5", 20)
+	later.like(rankViewer, "m3")
+	later.relate(rankViewer, repositories.RelationshipFollow, "did:plc:author4")
+	later.reply("late-reply", rankViewer, "m2", 0)
+
+	for page := 1; cursor != ""; page++ {
+		if page > 5 {
+			t.Fatal("pagination did not terminate")
+		}
+		query.Cursor = cursor
+		var uris []string
+		uris, cursor, err = generator.GenerateFeed(context.Background(), query)
+		if err != nil {
+			t.Fatalf("page %d: %v", page, err)
+		}
+		all = append(all, uris...)
+	}
+	if got := feedIDs(all); got != want {
+		t.Fatalf("paged feed = %s, want %s", got, want)
+	}
+}
+
+func TestMuteIsNotSyncedToPDS(t *testing.T) {
+	store := newTestMetaStore(t)
+	service := NewRelationshipService(&config.SocialConfig{}, store)
+	if _, err := service.Mute(context.Background(), rankViewer, "did:plc:muted"); err != nil {
+		t.Fatalf("mute: %v", err)
+	}
+	list, err := service.Mutes(context.Background(), rankViewer, 10, "")
+	if err != nil {
+		t.Fatalf("mutes: %v", err)
+	}
+	if len(list.Users) != 1 || list.Users[0].Did != "did:plc:muted" {
+		t.Errorf("mutes = %+v", list.Users)
+	}
+	if err := service.Unmute(context.Background(), rankViewer, "did:plc:muted"); err != nil {
+		t.Fatalf("unmute: %v", err)
+	}
+	if n := countRows(t, store, &repositories.PDSOutboxOp{}); n != 0 {
+		t.Errorf("%d outbox ops, want mutes kept local", n)
+	}
+}
@@ -0,0 +1,303 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"gorm.io/gorm"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/atproto/blobs"
+	"github.com/zhongshangwu/avatarai-social/pkg/atproto/helper"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/types"
+)
+
+var (
+	ErrInvalidDID       = errors.New("无效的用户 DID")
+	ErrSelfRelationship = errors.New("不能关注或屏蔽自己")
+	ErrBlocked          = errors.New("存在屏蔽关系, 无法关注")
+)
+
+// RelationshipService 关注/屏蔽关系. 关系先写入本地, 再通过同步队列写入发起方的 PDS
+type RelationshipService struct {
+	metaStore    *repositories.MetaStore
+	imageBuilder *blobs.ImageUriBuilder
+}
+
+func NewRelationshipService(config *config.SocialConfig, metaStore *repositories.MetaStore) *RelationshipService {
+	return &RelationshipService{
+		metaStore:    metaStore,
+		imageBuilder: blobs.NewImageUriBuilder(config.Server.Domain),
+	}
+}
+
+func (s *RelationshipService) Follow(ctx context.Context, did string, target string) (*repositories.Relationship, error) {
+	if err := validateTarget(did, target); err != nil {
+		return nil, err
+	}
+
+	blocked, err := s.metaStore.GraphRepo.GetBlockedDIDs(did)
+	if err != nil {
+		return nil, fmt.Errorf("获取屏蔽关系失败: %w", err)
+	}
+	for _, blockedDID := range blocked {
+		if blockedDID == target {
+			return nil, ErrBlocked
+		}
+	}
+
+	return s.createRelationship(did, repositories.RelationshipFollow, target)
+}
+
+func (s *RelationshipService) Unfollow(ctx context.Context, did string, target string) error {
+	return s.deleteRelationships(did, repositories.RelationshipFollow, target)
+}
+
+// Block 屏蔽用户, 同时取消对该用户的关注. 对方对自己的关注记录在对方仓库中, 只在查询时过滤
+func (s *RelationshipService) Block(ctx context.Context, did string, target string) (*repositories.Relationship, error) {
+	if err := validateTarget(did, target); err != nil {
+		return nil, err
+	}
+
+	relationship, err := s.createRelationship(did, repositories.RelationshipBlock, target)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.deleteRelationships(did, repositories.RelationshipFollow, target); err != nil {
+		return nil, err
+	}
+	return relationship, nil
+}
+
+func (s *RelationshipService) Unblock(ctx context.Context, did string, target string) error {
+	return s.deleteRelationships(did, repositories.RelationshipBlock, target)
+}
+
+// Mute 静音用户, 被静音用户的 moment 不再出现在推荐 feed 中. 静音只对自己生效, 不写入 PDS
+func (s *RelationshipService) Mute(ctx context.Context, did string, target string) (*repositories.Relationship, error) {
+	if err := validateTarget(did, target); err != nil {
+		return nil, err
+	}
+	return s.createRelationship(did, repositories.RelationshipMute, target)
+}
+
+func (s *RelationshipService) Unmute(ctx context.Context, did string, target string) error {
+	return s.deleteRelationships(did, repositories.RelationshipMute, target)
+}
+
+// Followers 关注 did 的用户列表, 过滤与 viewer 存在屏蔽关系的用户
+func (s *RelationshipService) Followers(ctx context.Context, did string, viewer string, limit int, cursor string) (*types.UserList, error) {
+	before, err := decodeRelationshipCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	relationships, err := s.metaStore.GraphRepo.ListRelationshipsByObject(did, repositories.RelationshipFollow, limit, before)
+	if err != nil {
+		return nil, fmt.Errorf("获取粉丝列表失败: %w", err)
+	}
+	return s.presentUserList(relationships, viewer, limit, func(r *repositories.Relationship) string { return r.Creator })
+}
+
+// Following did 关注的用户列表, 过滤与 viewer 存在屏蔽关系的用户
+func (s *RelationshipService) Following(ctx context.Context, did string, viewer string, limit int, cursor string) (*types.UserList, error) {
+	before, err := decodeRelationshipCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	relationships, err := s.metaStore.GraphRepo.ListRelationshipsByCreator(did, repositories.RelationshipFollow, limit, before)
+	if err != nil {
+		return nil, fmt.Errorf("获取关注列表失败: %w", err)
+	}
+	return s.presentUserList(relationships, viewer, limit, func(r *repositories.Relationship) string { return r.Object })
+}
+
+// Blocks 当前用户屏蔽的用户列表
+func (s *RelationshipService) Blocks(ctx context.Context, did string, limit int, cursor string) (*types.UserList, error) {
+	before, err := decodeRelationshipCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	relationships, err := s.metaStore.GraphRepo.ListRelationshipsByCreator(did, repositories.RelationshipBlock, limit, before)
+	if err != nil {
+		return nil, fmt.Errorf("获取屏蔽列表失败: %w", err)
+	}
+	return s.presentUserList(relationships, "", limit, func(r *repositories.Relationship) string { return r.Object })
+}
+
+// Mutes 当前用户静音的用户列表
+func (s *RelationshipService) Mutes(ctx context.Context, did string, limit int, cursor string) (*types.UserList, error) {
+	before, err := decodeRelationshipCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	relationships, err := s.metaStore.GraphRepo.ListRelationshipsByCreator(did, repositories.RelationshipMute, limit, before)
+	if err != nil {
+		return nil, fmt.Errorf("获取静音列表失败: %w", err)
+	}
+	return s.presentUserList(relationships, "", limit, func(r *repositories.Relationship) string { return r.Object })
+}
+
+// UserViews 构建用户视图, 包含粉丝数和关注数; 没有资料的用户只返回 DID
+func (s *RelationshipService) UserViews(dids []string) (map[string]*types.SimpleUserView, error) {
+	views := make(map[string]*types.SimpleUserView, len(dids))
+	if len(dids) == 0 {
+		return views, nil
+	}
+	dids = deduplicate(dids)
+
+	avatars, err := s.metaStore.UserRepo.GetUsersByDIDs(dids)
+	if err != nil {
+		return nil, fmt.Errorf("获取用户资料失败: %w", err)
+	}
+	followers, err := s.metaStore.GraphRepo.CountFollowers(dids)
+	if err != nil {
+		return nil, fmt.Errorf("获取粉丝数失败: %w", err)
+	}
+	following, err := s.metaStore.GraphRepo.CountFollowing(dids)
+	if err != nil {
+		return nil, fmt.Errorf("获取关注数失败: %w", err)
+	}
+
+	for _, did := range dids {
+		views[did] = &types.SimpleUserView{
+			Did:            did,
+			FollowersCount: followers[did],
+			FollowingCount: following[did],
+		}
+	}
+	for _, avatar := range avatars {
+		view, ok := views[avatar.Did]
+		if !ok {
+			continue
+		}
+		view.Handle = avatar.Handle
+		view.DisplayName = avatar.DisplayName
+		view.Avatar, _ = s.imageBuilder.GetPresetUri(blobs.PresetAvatar, avatar.Did, avatar.AvatarCID)
+		view.CreatedAt = avatar.CreatedAt
+	}
+	return views, nil
+}
+
+func (s *RelationshipService) createRelationship(did string, predicate string, target string) (*repositories.Relationship, error) {
+	existing, err := s.metaStore.GraphRepo.GetRelationship(did, predicate, target)
+	if err == nil {
+		return existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("获取关系失败: %w", err)
+	}
+
+	id := helper.GenerateTID()
+	relationship := &repositories.Relationship{
+		ID:        id,
+		URI:       fmt.Sprintf("at://%s/app.vtri.activity.relationship/%s", did, id),
+		Creator:   did,
+		Predicate: predicate,
+		Object:    target,
+		CreatedAt: time.Now().Unix(),
+	}
+	err = s.metaStore.WithTransaction(context.Background(), func(txStore *repositories.MetaStore) error {
+		if err := txStore.GraphRepo.CreateRelationship(relationship); err != nil {
+			return fmt.Errorf("创建关系失败: %w", err)
+		}
+		if predicate == repositories.RelationshipMute {
+			return nil
+		}
+		return enqueueRecordOp(txStore, relationship.URI, repositories.OutboxActionCreate)
+	})
+	if err != nil {
+		return nil, err
+	}
+	NotifyFollow(s.metaStore, relationship)
+	return relationship, nil
+}
+
+// deleteRelationships 删除 did 对 target 的某种关系, 包括从 PDS 索引到的重复记录
+func (s *RelationshipService) deleteRelationships(did string, predicate string, target string) error {
+	for {
+		relationship, err := s.metaStore.GraphRepo.GetRelationship(did, predicate, target)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("获取关系失败: %w", err)
+		}
+		err = s.metaStore.WithTransaction(context.Background(), func(txStore *repositories.MetaStore) error {
+			if err := txStore.GraphRepo.DeleteRelationship(relationship.URI); err != nil {
+				return fmt.Errorf("删除关系失败: %w", err)
+			}
+			if predicate == repositories.RelationshipMute {
+				return nil
+			}
+			return enqueueRecordOp(txStore, relationship.URI, repositories.OutboxActionDelete)
+		})
+		if err != nil {
+			return err
+		}
+		RetractNotifications(s.metaStore, relationship.URI)
+	}
+}
+
+func (s *RelationshipService) presentUserList(
+	relationships []*repositories.Relationship,
+	viewer string,
+	limit int,
+	userOf func(*repositories.Relationship) string,
+) (*types.UserList, error) {
+	blocked, err := s.metaStore.GraphRepo.GetBlockedDIDs(viewer)
+	if err != nil {
+		return nil, fmt.Errorf("获取屏蔽关系失败: %w", err)
+	}
+	hidden := make(map[string]bool, len(blocked))
+	for _, did := range blocked {
+		hidden[did] = true
+	}
+
+	dids := make([]string, 0, len(relationships))
+	for _, relationship := range relationships {
+		dids = append(dids, userOf(relationship))
+	}
+	views, err := s.UserViews(dids)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &types.UserList{Users: make([]*types.SimpleUserView, 0, len(relationships))}
+	seen := make(map[string]bool, len(relationships))
+	for _, relationship := range relationships {
+		did := userOf(relationship)
+		if hidden[did] || seen[did] {
+			continue
+		}
+		seen[did] = true
+		list.Users = append(list.Users, views[did])
+	}
+	if limit > 0 && len(relationships) >= limit {
+		last := relationships[len(relationships)-1]
+		list.Cursor = encodeFeedCursor(&repositories.FeedKey{IndexedAt: last.CreatedAt, ID: last.ID})
+	}
+	return list, nil
+}
+
+// decodeRelationshipCursor 关系列表的游标与 feed 游标格式相同, 内容为 "<created_at>::<id>"
+func decodeRelationshipCursor(cursor string) (*repositories.RelationshipKey, error) {
+	key, err := decodeFeedCursor(cursor)
+	if err != nil || key == nil {
+		return nil, err
+	}
+	return &repositories.RelationshipKey{CreatedAt: key.IndexedAt, ID: key.ID}, nil
+}
+
+func validateTarget(did string, target string) error {
+	if _, err := syntax.ParseDID(target); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidDID, target)
+	}
+	if did == target {
+		return ErrSelfRelationship
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+)
+
+func addFollow(t *testing.T, store *repositories.MetaStore, id string, creator string, object string, createdAt int64) {
+	t.Helper()
+	err := store.GraphRepo.CreateRelationship(&repositories.Relationship{
+		ID:        id,
+		URI:       fmt.Sprintf("at://%s/app.vtri.activity.relationship/%s", creator, id),
+		Creator:   creator,
+		Predicate: repositories.RelationshipFollow,
+		Object:    object,
+		CreatedAt: createdAt,
+	})
+	if err != nil {
+		t.Fatalf("create relationship: %v", err)
+	}
+}
+
+func TestFollowersPaginatesThroughEqualTimestamps(t *testing.T) {
+	store := newTestMetaStore(t)
+	service := NewRelationshipService(&config.SocialConfig{}, store)
+
+	// 7 个粉丝, 其中 5 个在同一秒关注
+	want := map[string]bool{}
+	for i := 0; i < 7; i++ {
+		createdAt := int64(1000)
+		if i >= 5 {
+			createdAt = int64(900 + i)
+		}
+		follower := fmt.Sprintf("did:plc:follower%d", i)
+		addFollow(t, store, fmt.Sprintf("3krel%d", i), follower, "did:plc:bob", createdAt)
+		want[follower] = true
+	}
+
+	seen := map[string]bool{}
+	var order []string
+	cursor := ""
+	for page := 0; ; page++ {
+		if page > 10 {
+			t.Fatal("pagination did not terminate")
+		}
+		list, err := service.Followers(context.Background(), "did:plc:bob", "", 2, cursor)
+		if err != nil {
+			t.Fatalf("followers: %v", err)
+		}
+		for _, user := range list.Users {
+			if seen[user.Did] {
+				t.Fatalf("follower %s returned twice, order %v", user.Did, order)
+			}
+			seen[user.Did] = true
+			order = append(order, user.Did)
+		}
+		if list.Cursor == "" {
+			break
+		}
+		cursor = list.Cursor
+	}
+	if len(seen) != len(want) {
+		t.Fatalf("paged through %v, want all %d followers", order, len(want))
+	}
+	// 同一时间的关系按 id 倒序, 之后是更早的关系
+	if order[0] != "did:plc:follower4" || order[4] != "did:plc:follower0" || order[5] != "did:plc:follower6" {
+		t.Errorf("order = %v", order)
+	}
+}
+
+func TestRelationshipListsRejectInvalidCursor(t *testing.T) {
+	store := newTestMetaStore(t)
+	service := NewRelationshipService(&config.SocialConfig{}, store)
+
+	for _, cursor := range []string{"1000", "not base64!", "MTAwMA"} {
+		if _, err := service.Following(context.Background(), "did:plc:alice", "", 10, cursor); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("cursor %q: err = %v, want ErrInvalidCursor", cursor, err)
+		}
+	}
+}
@@ -0,0 +1,818 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/fanout"
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+)
+
+var (
+	ErrInvalidRoom     = errors.New("无效的房间参数")
+	ErrRoomNotFound    = errors.New("房间不存在")
+	ErrThreadNotFound  = errors.New("话题不存在")
+	ErrNotRoomMember   = errors.New("不是房间成员")
+	ErrRoomArchived    = errors.New("房间已归档")
+	ErrRoomPermission  = errors.New("没有操作该房间的权限")
+	ErrAlreadyMember   = errors.New("用户已经是房间成员或已被邀请")
+	ErrNoPendingInvite = errors.New("没有待接受的邀请")
+	ErrSenderMismatch  = errors.New("发送者与当前用户不一致")
+)
+
+const (
+	maxRoomTitleLength = 100
+	maxGroupMembers    = 200
+	defaultThreadTitle = "默认话题"
+)
+
+// RoomList 当前用户视角的房间列表, 游标为本页最后一个房间的更新时间
+type RoomList struct {
+	Cursor string                     `json:"cursor"`
+	Rooms  []*messages.UserRoomStatus `json:"rooms"`
+}
+
+// RoomDetail 房间和房间内的话题
+type RoomDetail struct {
+	Room    *messages.UserRoomStatus `json:"room"`
+	Threads []*messages.Thread       `json:"threads"`
+}
+
+// RoomService 房间和话题的生命周期. 成员关系记录在 user_room_status 中: 被邀请的成员为 request,
+// 接受后为 accepted, 退出后为 left. 只有 accepted 的成员可以发送消息和查看历史.
+// 群聊的重命名、归档和邀请只允许群主操作, 单聊和 AI 对话的成员都可以操作.
+// 成员关系上线之前的聊天没有成员状态, 也可能没有房间和话题记录, 用户访问时根据消息补建
+type RoomService struct {
+	metaStore *repositories.MetaStore
+}
+
+func NewRoomService(metaStore *repositories.MetaStore) *RoomService {
+	return &RoomService{metaStore: metaStore}
+}
+
+// CreateRoom 创建房间和一个默认话题. 单聊的 members 为对方一人, 两人之间已有单聊时直接返回;
+// 群聊的成员以邀请状态加入; AI 对话只有创建者一个成员
+func (s *RoomService) CreateRoom(ctx context.Context, did string, roomType string, title string, members []string) (*RoomDetail, error) {
+	title = strings.TrimSpace(title)
+	if len([]rune(title)) > maxRoomTitleLength {
+		return nil, fmt.Errorf("%w: 标题不能超过 %d 个字符", ErrInvalidRoom, maxRoomTitleLength)
+	}
+	members = dedupeMembers(did, members)
+	for _, member := range members {
+		if err := validateTarget(did, member); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRoom, err)
+		}
+	}
+
+	switch messages.RoomType(roomType) {
+	case messages.RoomTypeDirect:
+		if len(members) != 1 {
+			return nil, fmt.Errorf("%w: 单聊需要且只能有一个对方成员", ErrInvalidRoom)
+		}
+		existing, err := s.metaStore.MessageRepo.FindDirectRoom(did, members[0])
+		if err != nil {
+			return nil, fmt.Errorf("查询单聊房间失败: %w", err)
+		}
+		if existing != nil {
+			return s.reopenDirectRoom(ctx, did, existing.ID)
+		}
+	case messages.RoomTypeGroup:
+		if len(members)+1 > maxGroupMembers {
+			return nil, fmt.Errorf("%w: 群聊成员不能超过 %d 人", ErrInvalidRoom, maxGroupMembers)
+		}
+	case messages.RoomTypeAIChat:
+		if len(members) > 0 {
+			return nil, fmt.Errorf("%w: AI 对话不能邀请其他成员", ErrInvalidRoom)
+		}
+	default:
+		return nil, fmt.Errorf("%w: 未知的房间类型 %s", ErrInvalidRoom, roomType)
+	}
+	for _, member := range members {
+		if err := s.checkNotBlocked(member, did); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	room := &repositories.Room{
+		ID:        uuid.New().String(),
+		Title:     title,
+		Type:      roomType,
+		CreatorID: did,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	thread := &repositories.Thread{
+		ID:          uuid.New().String(),
+		RoomID:      room.ID,
+		Title:       defaultThreadTitle,
+		CreatorID:   did,
+		ContextMode: string(messages.ThreadContextModeContinuous),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	statuses := []*repositories.UserRoomStatus{
+		newRoomMember(room.ID, did, messages.RoomMemberStatusAccepted, messages.RoomMemberRoleOwner, "", now),
+	}
+	for _, member := range members {
+		statuses = append(statuses, newRoomMember(room.ID, member, messages.RoomMemberStatusRequest, messages.RoomMemberRoleMember, did, now))
+	}
+	if err := s.metaStore.MessageRepo.CreateRoomWithMembers(room, thread, statuses); err != nil {
+		return nil, fmt.Errorf("创建房间失败: %w", err)
+	}
+
+	// 邀请的成员接受之后才加入房间的事件分发
+	fanout.Default().JoinRoom(ctx, did, room.ID)
+
+	return &RoomDetail{
+		Room:    roomView(room, statuses[0], statuses),
+		Threads: []*messages.Thread{threadView(thread)},
+	}, nil
+}
+
+// ListRooms 按最近活跃倒序列出用户的房间, pending 为 true 时只列出待接受的邀请
+func (s *RoomService) ListRooms(ctx context.Context, did string, pending bool, archived bool, limit int, cursor string) (*RoomList, error) {
+	var before int64
+	if cursor != "" {
+		value, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		before = value
+	}
+	status := messages.RoomMemberStatusAccepted
+	if pending {
+		status = messages.RoomMemberStatusRequest
+	} else if !archived && before == 0 {
+		s.backfillRooms(did)
+	}
+
+	rooms, err := s.metaStore.MessageRepo.ListRoomsByMember(did, []string{string(status)}, archived, before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("获取房间列表失败: %w", err)
+	}
+	roomIDs := make([]string, 0, len(rooms))
+	for _, room := range rooms {
+		roomIDs = append(roomIDs, room.ID)
+	}
+	members, err := s.metaStore.MessageRepo.ListRoomMembers(roomIDs)
+	if err != nil {
+		return nil, fmt.Errorf("获取房间成员失败: %w", err)
+	}
+	membersByRoom := make(map[string][]*repositories.UserRoomStatus, len(rooms))
+	for _, member := range members {
+		membersByRoom[member.RoomID] = append(membersByRoom[member.RoomID], member)
+	}
+
+	list := &RoomList{Rooms: make([]*messages.UserRoomStatus, 0, len(rooms))}
+	for _, room := range rooms {
+		var own *repositories.UserRoomStatus
+		for _, member := range membersByRoom[room.ID] {
+			if member.UserID == did {
+				own = member
+			}
+		}
+		if own == nil {
+			continue
+		}
+		list.Rooms = append(list.Rooms, roomView(room, own, membersByRoom[room.ID]))
+	}
+	if len(rooms) >= limit && len(rooms) > 0 {
+		list.Cursor = strconv.FormatInt(rooms[len(rooms)-1].UpdatedAt, 10)
+	}
+	return list, nil
+}
+
+// GetRoom 返回房间和话题, 被邀请的用户也可以查看以决定是否接受
+func (s *RoomService) GetRoom(ctx context.Context, did string, roomID string) (*RoomDetail, error) {
+	room, status, err := s.loadMembership(did, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if status.Status == string(messages.RoomMemberStatusLeft) {
+		return nil, ErrNotRoomMember
+	}
+	members, err := s.metaStore.MessageRepo.ListRoomMembers([]string{roomID})
+	if err != nil {
+		return nil, fmt.Errorf("获取房间成员失败: %w", err)
+	}
+	threads, err := s.metaStore.MessageRepo.ListThreadsByRoom(roomID)
+	if err != nil {
+		return nil, fmt.Errorf("获取话题列表失败: %w", err)
+	}
+	detail := &RoomDetail{
+		Room:    roomView(room, status, members),
+		Threads: make([]*messages.Thread, 0, len(threads)),
+	}
+	for _, thread := range threads {
+		detail.Threads = append(detail.Threads, threadView(thread))
+	}
+	return detail, nil
+}
+
+func (s *RoomService) RenameRoom(ctx context.Context, did string, roomID string, title string) error {
+	title = strings.TrimSpace(title)
+	if len([]rune(title)) > maxRoomTitleLength {
+		return fmt.Errorf("%w: 标题不能超过 %d 个字符", ErrInvalidRoom, maxRoomTitleLength)
+	}
+	if _, err := s.checkManage(did, roomID); err != nil {
+		return err
+	}
+	if err := s.metaStore.MessageRepo.UpdateRoom(roomID, map[string]interface{}{"title": title}); err != nil {
+		return fmt.Errorf("重命名房间失败: %w", err)
+	}
+	mirrorRoomRecord(s.metaStore, roomID, ChatRoomCollection, roomID, repositories.OutboxActionUpdate)
+	return nil
+}
+
+// ArchiveRoom 归档或取消归档房间, 归档的房间不能发送消息, 默认不出现在房间列表中
+func (s *RoomService) ArchiveRoom(ctx context.Context, did string, roomID string, archived bool) error {
+	if _, err := s.checkManage(did, roomID); err != nil {
+		return err
+	}
+	if err := s.metaStore.MessageRepo.UpdateRoom(roomID, map[string]interface{}{"archived": archived}); err != nil {
+		return fmt.Errorf("归档房间失败: %w", err)
+	}
+	return nil
+}
+
+// Invite 邀请用户加入群聊, 已退出的用户重新进入邀请状态
+func (s *RoomService) Invite(ctx context.Context, did string, roomID string, invitee string) error {
+	if err := validateTarget(did, invitee); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRoom, err)
+	}
+	room, err := s.checkManage(did, roomID)
+	if err != nil {
+		return err
+	}
+	if messages.RoomType(room.Type) != messages.RoomTypeGroup {
+		return fmt.Errorf("%w: 只有群聊可以邀请成员", ErrInvalidRoom)
+	}
+	if room.Archived {
+		return ErrRoomArchived
+	}
+	if err := s.checkNotBlocked(invitee, did); err != nil {
+		return err
+	}
+
+	existing, err := s.getUserRoomStatus(invitee, roomID)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		members, err := s.metaStore.MessageRepo.ListRoomMembers([]string{roomID})
+		if err != nil {
+			return fmt.Errorf("获取房间成员失败: %w", err)
+		}
+		if len(members) >= maxGroupMembers {
+			return fmt.Errorf("%w: 群聊成员不能超过 %d 人", ErrInvalidRoom, maxGroupMembers)
+		}
+		member := newRoomMember(roomID, invitee, messages.RoomMemberStatusRequest, messages.RoomMemberRoleMember, did, time.Now().UnixMilli())
+		if err := s.metaStore.MessageRepo.CreateUserRoomStatus(member); err != nil {
+			return fmt.Errorf("邀请成员失败: %w", err)
+		}
+		return nil
+	}
+	if existing.Status != string(messages.RoomMemberStatusLeft) {
+		return ErrAlreadyMember
+	}
+	if err := s.metaStore.MessageRepo.UpdateUserRoomStatus(invitee, roomID, map[string]interface{}{
+		"status":       string(messages.RoomMemberStatusRequest),
+		"role":         string(messages.RoomMemberRoleMember),
+		"inviter_id":   did,
+		"unread_count": 0,
+	}); err != nil {
+		return fmt.Errorf("邀请成员失败: %w", err)
+	}
+	return nil
+}
+
+// Accept 接受邀请, 用户的所有连接加入房间的事件分发
+func (s *RoomService) Accept(ctx context.Context, did string, roomID string) error {
+	_, status, err := s.loadMembership(did, roomID)
+	if err != nil {
+		return err
+	}
+	if status.Status != string(messages.RoomMemberStatusRequest) {
+		return ErrNoPendingInvite
+	}
+	if err := s.metaStore.MessageRepo.UpdateUserRoomStatus(did, roomID, map[string]interface{}{
+		"status": string(messages.RoomMemberStatusAccepted),
+	}); err != nil {
+		return fmt.Errorf("接受邀请失败: %w", err)
+	}
+	fanout.Default().JoinRoom(ctx, did, roomID)
+	return nil
+}
+
+// Leave 退出房间或拒绝邀请, 同时关闭 PDS 同步. 群主退出时群主身份转给最早加入的成员
+func (s *RoomService) Leave(ctx context.Context, did string, roomID string) error {
+	room, status, err := s.loadMembership(did, roomID)
+	if err != nil {
+		return err
+	}
+	if status.Status == string(messages.RoomMemberStatusLeft) {
+		return ErrNotRoomMember
+	}
+	if err := s.metaStore.MessageRepo.UpdateUserRoomStatus(did, roomID, map[string]interface{}{
+		"status":       string(messages.RoomMemberStatusLeft),
+		"role":         string(messages.RoomMemberRoleMember),
+		"unread_count": 0,
+		"pds_mirror":   false,
+	}); err != nil {
+		return fmt.Errorf("退出房间失败: %w", err)
+	}
+	fanout.Default().LeaveRoom(ctx, did, roomID)
+
+	if messages.RoomType(room.Type) == messages.RoomTypeGroup && status.Role == string(messages.RoomMemberRoleOwner) {
+		s.transferOwnership(roomID)
+	}
+	return nil
+}
+
+// MarkRead 清空用户在房间中的未读数
+func (s *RoomService) MarkRead(ctx context.Context, did string, roomID string) error {
+	if _, err := s.CheckMember(did, roomID); err != nil {
+		return err
+	}
+	if err := s.metaStore.MessageRepo.UpdateUserRoomStatus(did, roomID, map[string]interface{}{"unread_count": 0}); err != nil {
+		return fmt.Errorf("更新未读数失败: %w", err)
+	}
+	return nil
+}
+
+// CreateThread 在房间中创建话题. 指定 parentThreadID 时从父话题分叉, rootMID 为分叉点的消息,
+// 连续上下文的话题在生成回复时会继承父话题分叉点之前的消息
+func (s *RoomService) CreateThread(ctx context.Context, did string, roomID string, title string, contextMode string, parentThreadID string, rootMID string) (*messages.Thread, error) {
+	room, err := s.CheckMember(did, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if room.Archived {
+		return nil, ErrRoomArchived
+	}
+	title = strings.TrimSpace(title)
+	if len([]rune(title)) > maxRoomTitleLength {
+		return nil, fmt.Errorf("%w: 标题不能超过 %d 个字符", ErrInvalidRoom, maxRoomTitleLength)
+	}
+	switch messages.ThreadContextMode(contextMode) {
+	case "":
+		contextMode = string(messages.ThreadContextModeContinuous)
+	case messages.ThreadContextModeContinuous, messages.ThreadContextModeIsolated:
+	default:
+		return nil, fmt.Errorf("%w: 未知的上下文模式 %s", ErrInvalidRoom, contextMode)
+	}
+
+	if parentThreadID != "" {
+		if _, err := s.getThread(roomID, parentThreadID); err != nil {
+			return nil, err
+		}
+	}
+	if rootMID != "" {
+		root, err := s.metaStore.MessageRepo.GetMessageByID(rootMID)
+		if err != nil || root.Deleted || root.RoomID != roomID || root.ThreadID != parentThreadID {
+			return nil, fmt.Errorf("%w: 分叉的消息不在父话题中", ErrInvalidRoom)
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	thread := &repositories.Thread{
+		ID:             uuid.New().String(),
+		RoomID:         roomID,
+		Title:          title,
+		CreatorID:      did,
+		ContextMode:    contextMode,
+		RootMID:        rootMID,
+		ParentThreadID: parentThreadID,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if err := s.metaStore.MessageRepo.CreateThread(thread); err != nil {
+		return nil, fmt.Errorf("创建话题失败: %w", err)
+	}
+	mirrorRoomRecord(s.metaStore, roomID, ChatThreadCollection, thread.ID, repositories.OutboxActionCreate)
+	return threadView(thread), nil
+}
+
+// ForkThread 从 threadID 的 messageID 处分叉出新话题
+func (s *RoomService) ForkThread(ctx context.Context, did string, threadID string, messageID string, title string, contextMode string) (*messages.Thread, error) {
+	parent, err := s.metaStore.MessageRepo.GetThreadByID(threadID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && parent.Deleted) {
+		return nil, ErrThreadNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("获取话题失败: %w", err)
+	}
+	if title == "" {
+		title = parent.Title
+	}
+	return s.CreateThread(ctx, did, parent.RoomID, title, contextMode, threadID, messageID)
+}
+
+func (s *RoomService) ListThreads(ctx context.Context, did string, roomID string) ([]*messages.Thread, error) {
+	if _, err := s.CheckMember(did, roomID); err != nil {
+		return nil, err
+	}
+	threads, err := s.metaStore.MessageRepo.ListThreadsByRoom(roomID)
+	if err != nil {
+		return nil, fmt.Errorf("获取话题列表失败: %w", err)
+	}
+	views := make([]*messages.Thread, 0, len(threads))
+	for _, thread := range threads {
+		views = append(views, threadView(thread))
+	}
+	return views, nil
+}
+
+// CheckMember 确认用户是房间的正式成员
+func (s *RoomService) CheckMember(did string, roomID string) (*repositories.Room, error) {
+	room, status, err := s.loadMembership(did, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if status.Status != string(messages.RoomMemberStatusAccepted) {
+		return nil, ErrNotRoomMember
+	}
+	return room, nil
+}
+
+// CheckThread 确认用户是房间的正式成员且话题属于该房间, threadID 为空表示房间主线
+func (s *RoomService) CheckThread(did string, roomID string, threadID string) (*repositories.Room, error) {
+	room, err := s.CheckMember(did, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if threadID != "" {
+		_, err := s.getThread(roomID, threadID)
+		if errors.Is(err, ErrThreadNotFound) {
+			err = s.backfillThread(did, room, threadID)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return room, nil
+}
+
+// CheckSend 发送消息前的检查, 在 CheckThread 的基础上要求房间未归档.
+// 旧版客户端使用自己生成的房间 ID 发起 AI 对话, 房间不存在且没有任何消息时按 AI 对话创建
+func (s *RoomService) CheckSend(did string, roomID string, threadID string) error {
+	room, err := s.CheckThread(did, roomID, threadID)
+	if errors.Is(err, ErrRoomNotFound) {
+		room, err = s.createAIRoom(did, roomID, threadID)
+	}
+	if err != nil {
+		return err
+	}
+	if room.Archived {
+		return ErrRoomArchived
+	}
+	return nil
+}
+
+// RecordMessage 消息写入后更新房间的最后一条消息和其他成员的未读数
+func (s *RoomService) RecordMessage(message *messages.Message) {
+	if err := s.metaStore.MessageRepo.RecordRoomMessage(message.RoomID, message.ID, message.SenderID); err != nil {
+		log.Printf("更新房间 %s 的最后消息失败: %v", message.RoomID, err)
+	}
+}
+
+// reopenDirectRoom 再次发起已有的单聊, 自己已经退出或仍是邀请状态时重新成为正式成员
+func (s *RoomService) reopenDirectRoom(ctx context.Context, did string, roomID string) (*RoomDetail, error) {
+	status, err := s.getUserRoomStatus(did, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if status != nil && status.Status != string(messages.RoomMemberStatusAccepted) {
+		if err := s.metaStore.MessageRepo.UpdateUserRoomStatus(did, roomID, map[string]interface{}{
+			"status": string(messages.RoomMemberStatusAccepted),
+		}); err != nil {
+			return nil, fmt.Errorf("重新加入单聊失败: %w", err)
+		}
+		fanout.Default().JoinRoom(ctx, did, roomID)
+	}
+	return s.GetRoom(ctx, did, roomID)
+}
+
+func (s *RoomService) loadMembership(did string, roomID string) (*repositories.Room, *repositories.UserRoomStatus, error) {
+	if roomID == "" {
+		return nil, nil, fmt.Errorf("%w: 缺少房间 ID", ErrInvalidRoom)
+	}
+	room, err := s.getRoom(roomID)
+	if err != nil {
+		return nil, nil, err
+	}
+	status, err := s.getUserRoomStatus(did, roomID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if status == nil {
+		return s.backfillMembership(did, roomID, room)
+	}
+	if room == nil {
+		return nil, nil, ErrRoomNotFound
+	}
+	return room, status, nil
+}
+
+// getRoom 房间记录不存在时返回 nil, 已删除时返回 ErrRoomNotFound
+func (s *RoomService) getRoom(roomID string) (*repositories.Room, error) {
+	room, err := s.metaStore.MessageRepo.GetRoomByID(roomID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("获取房间失败: %w", err)
+	}
+	if room.Deleted {
+		return nil, ErrRoomNotFound
+	}
+	return room, nil
+}
+
+// backfillMembership 用户没有成员状态时, 在房间中发送或接收过消息的用户补建为正式成员
+func (s *RoomService) backfillMembership(did string, roomID string, room *repositories.Room) (*repositories.Room, *repositories.UserRoomStatus, error) {
+	participated, err := s.metaStore.MessageRepo.HasRoomMessages(roomID, did)
+	if err != nil {
+		return nil, nil, fmt.Errorf("获取房间消息失败: %w", err)
+	}
+	if participated {
+		return s.backfillMember(did, roomID, room)
+	}
+	if room != nil {
+		return nil, nil, ErrNotRoomMember
+	}
+	// 没有房间记录但有其他人的消息, 房间存在但用户不是成员
+	used, err := s.metaStore.MessageRepo.HasRoomMessages(roomID, "")
+	if err != nil {
+		return nil, nil, fmt.Errorf("获取房间消息失败: %w", err)
+	}
+	if used {
+		return nil, nil, ErrNotRoomMember
+	}
+	return nil, nil, ErrRoomNotFound
+}
+
+// backfillMember 写入用户的正式成员状态. 房间记录不存在时按 AI 对话补建, 用户为创建者
+func (s *RoomService) backfillMember(did string, roomID string, room *repositories.Room) (*repositories.Room, *repositories.UserRoomStatus, error) {
+	now := time.Now().UnixMilli()
+	var err error
+	var status *repositories.UserRoomStatus
+	if room == nil {
+		room = &repositories.Room{
+			ID:        roomID,
+			Type:      string(messages.RoomTypeAIChat),
+			CreatorID: did,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		status = newRoomMember(roomID, did, messages.RoomMemberStatusAccepted, messages.RoomMemberRoleOwner, "", now)
+		err = s.metaStore.MessageRepo.CreateRoomWithMembers(room, nil, []*repositories.UserRoomStatus{status})
+	} else {
+		role := messages.RoomMemberRoleMember
+		if room.CreatorID == did {
+			role = messages.RoomMemberRoleOwner
+		}
+		status = newRoomMember(roomID, did, messages.RoomMemberStatusAccepted, role, "", now)
+		err = s.metaStore.MessageRepo.CreateUserRoomStatus(status)
+	}
+	if err != nil {
+		// 并发补建时以已经写入的记录为准
+		if existing, _ := s.getRoom(roomID); existing != nil {
+			if status, _ := s.getUserRoomStatus(did, roomID); status != nil {
+				return existing, status, nil
+			}
+		}
+		return nil, nil, fmt.Errorf("补建房间成员失败: %w", err)
+	}
+	log.Printf("补建用户 %s 在房间 %s 中的成员状态", did, roomID)
+	return room, status, nil
+}
+
+// createAIRoom 房间记录不存在且没有任何消息时, 按 AI 对话创建房间, 用户为创建者
+func (s *RoomService) createAIRoom(did string, roomID string, threadID string) (*repositories.Room, error) {
+	existing, err := s.getRoom(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, ErrRoomNotFound
+	}
+	if _, _, err := s.backfillMember(did, roomID, nil); err != nil {
+		return nil, err
+	}
+	return s.CheckThread(did, roomID, threadID)
+}
+
+// backfillRooms 为用户参与过但没有成员状态的房间补建成员关系, 使其出现在房间列表中
+func (s *RoomService) backfillRooms(did string) {
+	roomIDs, err := s.metaStore.MessageRepo.ListRoomIDsWithoutStatus(did)
+	if err != nil {
+		log.Printf("获取用户 %s 需要补建的房间失败: %v", did, err)
+		return
+	}
+	for _, roomID := range roomIDs {
+		if roomID == "" {
+			continue
+		}
+		room, err := s.getRoom(roomID)
+		if err == nil {
+			_, _, err = s.backfillMember(did, roomID, room)
+		}
+		if err != nil && !errors.Is(err, ErrRoomNotFound) {
+			log.Printf("补建用户 %s 在房间 %s 中的成员状态失败: %v", did, roomID, err)
+		}
+	}
+}
+
+// backfillThread 旧版客户端自己生成话题 ID. 话题记录不存在时, AI 对话直接补建, 其他房间只在话题中已有消息时补建
+func (s *RoomService) backfillThread(did string, room *repositories.Room, threadID string) error {
+	if _, err := s.metaStore.MessageRepo.GetThreadByID(threadID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		if err != nil {
+			return fmt.Errorf("获取话题失败: %w", err)
+		}
+		// 话题属于其他房间或已删除
+		return ErrThreadNotFound
+	}
+	if messages.RoomType(room.Type) != messages.RoomTypeAIChat {
+		count, err := s.metaStore.MessageRepo.GetMessageCountByRoom(room.ID, threadID)
+		if err != nil {
+			return fmt.Errorf("获取话题消息失败: %w", err)
+		}
+		if count == 0 {
+			return ErrThreadNotFound
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	thread := &repositories.Thread{
+		ID:          threadID,
+		RoomID:      room.ID,
+		Title:       defaultThreadTitle,
+		CreatorID:   did,
+		ContextMode: string(messages.ThreadContextModeContinuous),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := s.metaStore.MessageRepo.CreateThread(thread); err != nil {
+		if _, err := s.getThread(room.ID, threadID); err == nil {
+			return nil
+		}
+		return fmt.Errorf("补建话题失败: %w", err)
+	}
+	mirrorRoomRecord(s.metaStore, room.ID, ChatThreadCollection, thread.ID, repositories.OutboxActionCreate)
+	return nil
+}
+
+// checkManage 群聊只有群主可以管理, 单聊和 AI 对话的正式成员都可以
+func (s *RoomService) checkManage(did string, roomID string) (*repositories.Room, error) {
+	room, status, err := s.loadMembership(did, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if status.Status != string(messages.RoomMemberStatusAccepted) {
+		return nil, ErrNotRoomMember
+	}
+	if messages.RoomType(room.Type) == messages.RoomTypeGroup && status.Role != string(messages.RoomMemberRoleOwner) {
+		return nil, ErrRoomPermission
+	}
+	return room, nil
+}
+
+func (s *RoomService) getUserRoomStatus(did string, roomID string) (*repositories.UserRoomStatus, error) {
+	status, err := s.metaStore.MessageRepo.GetUserRoomStatus(did, roomID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("获取成员状态失败: %w", err)
+	}
+	if status.Deleted {
+		return nil, nil
+	}
+	return status, nil
+}
+
+func (s *RoomService) getThread(roomID string, threadID string) (*repositories.Thread, error) {
+	thread, err := s.metaStore.MessageRepo.GetThreadByID(threadID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrThreadNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("获取话题失败: %w", err)
+	}
+	if thread.Deleted || thread.RoomID != roomID {
+		return nil, ErrThreadNotFound
+	}
+	return thread, nil
+}
+
+// checkNotBlocked 对方屏蔽了自己时不能发起单聊或邀请
+func (s *RoomService) checkNotBlocked(target string, did string) error {
+	blocked, err := s.metaStore.GraphRepo.GetBlockedDIDs(target)
+	if err != nil {
+		return fmt.Errorf("获取屏蔽关系失败: %w", err)
+	}
+	for _, blockedDID := range blocked {
+		if blockedDID == did {
+			return ErrBlocked
+		}
+	}
+	return nil
+}
+
+func (s *RoomService) transferOwnership(roomID string) {
+	members, err := s.metaStore.MessageRepo.ListRoomMembers([]string{roomID})
+	if err != nil {
+		log.Printf("获取房间 %s 的成员失败, 无法转让群主: %v", roomID, err)
+		return
+	}
+	for _, member := range members {
+		if member.Status != string(messages.RoomMemberStatusAccepted) {
+			continue
+		}
+		if err := s.metaStore.MessageRepo.UpdateUserRoomStatus(member.UserID, roomID, map[string]interface{}{
+			"role": string(messages.RoomMemberRoleOwner),
+		}); err != nil {
+			log.Printf("转让房间 %s 的群主失败: %v", roomID, err)
+		}
+		return
+	}
+}
+
+func newRoomMember(roomID string, did string, status messages.RoomMemberStatus, role messages.RoomMemberRole, inviter string, now int64) *repositories.UserRoomStatus {
+	return &repositories.UserRoomStatus{
+		ID:        uuid.New().String(),
+		RoomID:    roomID,
+		UserID:    did,
+		Status:    string(status),
+		Role:      string(role),
+		InviterID: inviter,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+func dedupeMembers(did string, members []string) []string {
+	seen := map[string]bool{did: true}
+	result := make([]string, 0, len(members))
+	for _, member := range members {
+		member = strings.TrimSpace(member)
+		if member == "" || seen[member] {
+			continue
+		}
+		seen[member] = true
+		result = append(result, member)
+	}
+	return result
+}
+
+func roomView(room *repositories.Room, status *repositories.UserRoomStatus, members []*repositories.UserRoomStatus) *messages.UserRoomStatus {
+	participants := make([]string, 0, len(members))
+	for _, member := range members {
+		if member.Status == string(messages.RoomMemberStatusAccepted) {
+			participants = append(participants, member.UserID)
+		}
+	}
+	return &messages.UserRoomStatus{
+		ID:           status.ID,
+		RoomID:       room.ID,
+		Title:        room.Title,
+		Type:         room.Type,
+		LastMID:      room.LastMID,
+		Participants: participants,
+		UnreadCount:  status.UnreadCount,
+		Muted:        status.Muted,
+		UserID:       status.UserID,
+		Status:       status.Status,
+		Role:         status.Role,
+		Archived:     room.Archived,
+		PDSMirror:    status.PDSMirror,
+		CreatedAt:    room.CreatedAt,
+		UpdatedAt:    room.UpdatedAt,
+		Deleted:      room.Deleted,
+	}
+}
+
+func threadView(thread *repositories.Thread) *messages.Thread {
+	return &messages.Thread{
+		ID:             thread.ID,
+		RoomID:         thread.RoomID,
+		Title:          thread.Title,
+		ContextMode:    messages.ThreadContextMode(thread.ContextMode),
+		RootMID:        thread.RootMID,
+		ParentThreadID: thread.ParentThreadID,
+		CreatedAt:      thread.CreatedAt,
+		UpdatedAt:      thread.UpdatedAt,
+		Deleted:        thread.Deleted,
+	}
+}
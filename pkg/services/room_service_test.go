@@ -0,0 +1,270 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+)
+
+const (
+	roomAlice = "did:plc:alice"
+	roomBob   = "did:plc:bob"
+	roomCarol = "did:plc:carol"
+	roomAster = "did:plc:aster"
+)
+
+func createRoom(t *testing.T, service *RoomService, did string, roomType messages.RoomType, members ...string) *RoomDetail {
+	t.Helper()
+	detail, err := service.CreateRoom(context.Background(), did, string(roomType), "", members)
+	if err != nil {
+		t.Fatalf("create %s room: %v", roomType, err)
+	}
+	return detail
+}
+
+func memberStatus(t *testing.T, store *repositories.MetaStore, did string, roomID string) *repositories.UserRoomStatus {
+	t.Helper()
+	status, err := store.MessageRepo.GetUserRoomStatus(did, roomID)
+	if err != nil {
+		t.Fatalf("status of %s in %s: %v", did, roomID, err)
+	}
+	return status
+}
+
+// insertLegacyMessage 写入成员关系上线之前的消息, 没有房间、话题和成员状态记录
+func insertLegacyMessage(t *testing.T, store *repositories.MetaStore, id string, roomID string, threadID string, sender string, receiver string) {
+	t.Helper()
+	if err := store.MessageRepo.InsertMessage(&repositories.Message{
+		ID:         id,
+		RoomID:     roomID,
+		ThreadID:   threadID,
+		MsgType:    int(messages.MessageTypeText),
+		SenderID:   sender,
+		ReceiverID: receiver,
+		CreatedAt:  1000,
+	}); err != nil {
+		t.Fatalf("insert message %s: %v", id, err)
+	}
+}
+
+func TestRoomMembershipChecks(t *testing.T) {
+	store := newTestMetaStore(t)
+	service := NewRoomService(store)
+	group := createRoom(t, service, roomAlice, messages.RoomTypeGroup, roomBob)
+	other := createRoom(t, service, roomCarol, messages.RoomTypeAIChat)
+	roomID, threadID := group.Room.RoomID, group.Threads[0].ID
+
+	tests := []struct {
+		name     string
+		did      string
+		roomID   string
+		threadID string
+		want     error
+	}{
+		{"owner in the default thread", roomAlice, roomID, threadID, nil},
+		{"owner in the main line", roomAlice, roomID, "", nil},
+		{"pending invitee", roomBob, roomID, threadID, ErrNotRoomMember},
+		{"stranger", roomCarol, roomID, threadID, ErrNotRoomMember},
+		{"thread of another room", roomAlice, roomID, other.Threads[0].ID, ErrThreadNotFound},
+		{"unknown room", roomAlice, "missing", "", ErrRoomNotFound},
+		{"missing room id", roomAlice, "", "", ErrInvalidRoom},
+	}
+	for _, tt := range tests {
+		if _, err := service.CheckThread(tt.did, tt.roomID, tt.threadID); !errors.Is(err, tt.want) {
+			t.Errorf("%s: check thread = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// 群聊中不存在的话题不补建
+	if _, err := service.CheckThread(roomAlice, roomID, "unknown-thread"); !errors.Is(err, ErrThreadNotFound) {
+		t.Errorf("unknown thread in group = %v, want ErrThreadNotFound", err)
+	}
+
+	if err := service.ArchiveRoom(context.Background(), roomAlice, roomID, true); err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+	if _, err := service.CheckThread(roomAlice, roomID, threadID); err != nil {
+		t.Errorf("check thread in archived room = %v, want nil", err)
+	}
+	if err := service.CheckSend(roomAlice, roomID, threadID); !errors.Is(err, ErrRoomArchived) {
+		t.Errorf("send to archived room = %v, want ErrRoomArchived", err)
+	}
+}
+
+func TestRoomInviteAcceptLeave(t *testing.T) {
+	ctx := context.Background()
+	store := newTestMetaStore(t)
+	service := NewRoomService(store)
+	roomID := createRoom(t, service, roomAlice, messages.RoomTypeGroup, roomBob).Room.RoomID
+
+	if err := service.Invite(ctx, roomAlice, roomID, roomBob); !errors.Is(err, ErrAlreadyMember) {
+		t.Fatalf("invite pending member = %v, want ErrAlreadyMember", err)
+	}
+	if err := service.Accept(ctx, roomCarol, roomID); !errors.Is(err, ErrNotRoomMember) {
+		t.Fatalf("accept without invite = %v, want ErrNotRoomMember", err)
+	}
+	if err := service.Accept(ctx, roomBob, roomID); err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	if err := service.Accept(ctx, roomBob, roomID); !errors.Is(err, ErrNoPendingInvite) {
+		t.Fatalf("accept twice = %v, want ErrNoPendingInvite", err)
+	}
+	if err := service.CheckSend(roomBob, roomID, ""); err != nil {
+		t.Fatalf("send after accepting = %v", err)
+	}
+	// 只有群主可以邀请
+	if err := service.Invite(ctx, roomBob, roomID, roomCarol); !errors.Is(err, ErrRoomPermission) {
+		t.Fatalf("member invites = %v, want ErrRoomPermission", err)
+	}
+
+	// 群主退出后群主身份转给最早加入的成员
+	if err := service.Leave(ctx, roomAlice, roomID); err != nil {
+		t.Fatalf("leave: %v", err)
+	}
+	if err := service.Leave(ctx, roomAlice, roomID); !errors.Is(err, ErrNotRoomMember) {
+		t.Fatalf("leave twice = %v, want ErrNotRoomMember", err)
+	}
+	if err := service.CheckSend(roomAlice, roomID, ""); !errors.Is(err, ErrNotRoomMember) {
+		t.Fatalf("send after leaving = %v, want ErrNotRoomMember", err)
+	}
+	if status := memberStatus(t, store, roomBob, roomID); status.Role != string(messages.RoomMemberRoleOwner) {
+		t.Fatalf("bob role after alice left = %s, want owner", status.Role)
+	}
+
+	// 已退出的用户可以被重新邀请
+	if err := service.Invite(ctx, roomBob, roomID, roomAlice); err != nil {
+		t.Fatalf("invite again: %v", err)
+	}
+	status := memberStatus(t, store, roomAlice, roomID)
+	if status.Status != string(messages.RoomMemberStatusRequest) || status.Role != string(messages.RoomMemberRoleMember) || status.InviterID != roomBob {
+		t.Fatalf("alice status after invitation = %+v", status)
+	}
+	if err := service.Accept(ctx, roomAlice, roomID); err != nil {
+		t.Fatalf("accept again: %v", err)
+	}
+	if err := service.CheckSend(roomAlice, roomID, ""); err != nil {
+		t.Fatalf("send after rejoining = %v", err)
+	}
+
+	// AI 对话不能邀请成员
+	aiRoom := createRoom(t, service, roomAlice, messages.RoomTypeAIChat)
+	if err := service.Invite(ctx, roomAlice, aiRoom.Room.RoomID, roomBob); !errors.Is(err, ErrInvalidRoom) {
+		t.Fatalf("invite to ai chat = %v, want ErrInvalidRoom", err)
+	}
+}
+
+func TestRoomUnreadCounts(t *testing.T) {
+	ctx := context.Background()
+	store := newTestMetaStore(t)
+	service := NewRoomService(store)
+	roomID := createRoom(t, service, roomAlice, messages.RoomTypeGroup, roomBob, roomCarol).Room.RoomID
+	if err := service.Accept(ctx, roomBob, roomID); err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+
+	service.RecordMessage(&messages.Message{ID: "m1", RoomID: roomID, SenderID: roomAlice})
+	service.RecordMessage(&messages.Message{ID: "m2", RoomID: roomID, SenderID: roomAlice})
+	service.RecordMessage(&messages.Message{ID: "m3", RoomID: roomID, SenderID: roomBob})
+
+	// 发送者自己和仍在邀请状态的成员不计未读
+	for did, want := range map[string]int32{roomAlice: 1, roomBob: 2, roomCarol: 0} {
+		if got := memberStatus(t, store, did, roomID).UnreadCount; got != want {
+			t.Errorf("unread count of %s = %d, want %d", did, got, want)
+		}
+	}
+	list, err := service.ListRooms(ctx, roomBob, false, false, 10, "")
+	if err != nil || len(list.Rooms) != 1 || list.Rooms[0].UnreadCount != 2 || list.Rooms[0].LastMID != "m3" {
+		t.Fatalf("bob rooms = %+v, %v, want one room with 2 unread and last message m3", list, err)
+	}
+
+	if err := service.MarkRead(ctx, roomBob, roomID); err != nil {
+		t.Fatalf("mark read: %v", err)
+	}
+	if got := memberStatus(t, store, roomBob, roomID).UnreadCount; got != 0 {
+		t.Errorf("unread count after mark read = %d, want 0", got)
+	}
+	if got := memberStatus(t, store, roomAlice, roomID).UnreadCount; got != 1 {
+		t.Errorf("alice unread count after bob marked read = %d, want 1", got)
+	}
+	if err := service.MarkRead(ctx, roomCarol, roomID); !errors.Is(err, ErrNotRoomMember) {
+		t.Errorf("pending member mark read = %v, want ErrNotRoomMember", err)
+	}
+}
+
+func TestLegacyChatsBackfillMembership(t *testing.T) {
+	ctx := context.Background()
+	store := newTestMetaStore(t)
+	service := NewRoomService(store)
+	insertLegacyMessage(t, store, "m1", "legacy-1", "legacy-thread", roomAlice, roomAster)
+	insertLegacyMessage(t, store, "m2", "legacy-2", "", roomAlice, roomAster)
+	insertLegacyMessage(t, store, "m3", "legacy-bob", "", roomBob, roomAster)
+
+	// 参与过的房间补建为 AI 对话, 用户为创建者, 旧的话题 ID 一并补建
+	if err := service.CheckSend(roomAlice, "legacy-1", "legacy-thread"); err != nil {
+		t.Fatalf("send to legacy chat = %v", err)
+	}
+	room, err := store.MessageRepo.GetRoomByID("legacy-1")
+	if err != nil || room.Type != string(messages.RoomTypeAIChat) || room.CreatorID != roomAlice {
+		t.Fatalf("backfilled room = %+v, %v, want an ai chat created by alice", room, err)
+	}
+	if status := memberStatus(t, store, roomAlice, "legacy-1"); status.Status != string(messages.RoomMemberStatusAccepted) || status.Role != string(messages.RoomMemberRoleOwner) {
+		t.Fatalf("backfilled status = %+v, want accepted owner", status)
+	}
+	if thread, err := store.MessageRepo.GetThreadByID("legacy-thread"); err != nil || thread.RoomID != "legacy-1" {
+		t.Fatalf("backfilled thread = %+v, %v", thread, err)
+	}
+
+	// 没有参与过的用户不能借此加入
+	if err := service.CheckSend(roomCarol, "legacy-bob", ""); !errors.Is(err, ErrNotRoomMember) {
+		t.Fatalf("stranger sends to legacy chat = %v, want ErrNotRoomMember", err)
+	}
+	if _, err := service.CheckThread(roomCarol, "legacy-1", ""); !errors.Is(err, ErrNotRoomMember) {
+		t.Fatalf("stranger reads backfilled room = %v, want ErrNotRoomMember", err)
+	}
+	// 其他房间的话题不能补建到当前房间
+	if err := service.CheckSend(roomBob, "legacy-bob", "legacy-thread"); !errors.Is(err, ErrThreadNotFound) {
+		t.Fatalf("thread of another room = %v, want ErrThreadNotFound", err)
+	}
+
+	// 旧版客户端自己生成的新房间和话题按 AI 对话创建
+	if err := service.CheckSend(roomCarol, "fresh", "fresh-thread"); err != nil {
+		t.Fatalf("send to new room = %v", err)
+	}
+	if status := memberStatus(t, store, roomCarol, "fresh"); status.Role != string(messages.RoomMemberRoleOwner) {
+		t.Fatalf("new room status = %+v, want owner", status)
+	}
+	// 只读的检查不创建房间
+	if _, err := service.CheckThread(roomCarol, "never-used", ""); !errors.Is(err, ErrRoomNotFound) {
+		t.Fatalf("read unknown room = %v, want ErrRoomNotFound", err)
+	}
+	if _, err := store.MessageRepo.GetRoomByID("never-used"); err == nil {
+		t.Fatalf("read check created the room")
+	}
+
+	// 房间列表补建用户参与过的其他早期聊天
+	list, err := service.ListRooms(ctx, roomAlice, false, false, 10, "")
+	if err != nil {
+		t.Fatalf("list rooms: %v", err)
+	}
+	roomIDs := make([]string, 0, len(list.Rooms))
+	for _, room := range list.Rooms {
+		roomIDs = append(roomIDs, room.RoomID)
+	}
+	sort.Strings(roomIDs)
+	if got := strings.Join(roomIDs, ","); got != "legacy-1,legacy-2" {
+		t.Fatalf("alice rooms = %s, want legacy-1,legacy-2", got)
+	}
+
+	// 退出之后不再补建
+	if err := service.Leave(ctx, roomAlice, "legacy-2"); err != nil {
+		t.Fatalf("leave: %v", err)
+	}
+	if err := service.CheckSend(roomAlice, "legacy-2", ""); !errors.Is(err, ErrNotRoomMember) {
+		t.Fatalf("send after leaving legacy chat = %v, want ErrNotRoomMember", err)
+	}
+}
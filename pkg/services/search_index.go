@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/search"
+)
+
+// 以下函数在各写入路径上维护全文检索索引, 写入已经成功, 索引失败只记录日志
+
+// IndexMoment 索引 moment 的正文和标签
+func IndexMoment(metaStore *repositories.MetaStore, moment *repositories.Moment) {
+	upsertDocument(metaStore, momentDocument(moment))
+}
+
+// IndexUser 索引用户的 handle、昵称和简介
+func IndexUser(metaStore *repositories.MetaStore, did string) {
+	avatar, err := metaStore.UserRepo.GetAvatarByDID(did)
+	if err != nil {
+		log.Printf("获取用户 %s 失败, 无法写入检索索引: %v", did, err)
+		return
+	}
+	upsertDocument(metaStore, userDocument(avatar))
+}
+
+// IndexTag 索引标签池中的标签
+func IndexTag(metaStore *repositories.MetaStore, tag *repositories.Tag) {
+	upsertDocument(metaStore, tagDocument(tag))
+}
+
+// IndexTopic 索引主题池中的主题
+func IndexTopic(metaStore *repositories.MetaStore, topic *repositories.Topic) {
+	upsertDocument(metaStore, topicDocument(topic))
+}
+
+// IndexMessage 索引聊天消息中可检索的文本, 没有文本的消息不写入
+func IndexMessage(metaStore *repositories.MetaStore, message *messages.Message) {
+	if doc := messageDocument(message, MessageText(message)); doc != nil {
+		upsertDocument(metaStore, doc)
+	}
+}
+
+// IndexAgentReply AI 回复完成后才有完整的文本, 以回复自身的消息写入索引, 发送者为 AI
+func IndexAgentReply(metaStore *repositories.MetaStore, agentMessage *messages.AgentMessage) {
+	message, err := metaStore.MessageRepo.GetMessageByID(agentMessage.MessageID)
+	if err != nil {
+		log.Printf("获取消息 %s 失败, 无法写入检索索引: %v", agentMessage.MessageID, err)
+		return
+	}
+	if message.MsgType != int(messages.MessageTypeAgent) {
+		log.Printf("AI 回复 %s 所属的消息 %s 不是 AI 消息, 不写入检索索引", agentMessage.ID, message.ID)
+		return
+	}
+	if doc := agentReplyDocument(message, agentMessage.Creator, agentMessage.AltText); doc != nil {
+		upsertDocument(metaStore, doc)
+	}
+}
+
+// MessageText 提取消息中可用于检索的文本
+func MessageText(message *messages.Message) string {
+	if message == nil {
+		return ""
+	}
+	switch content := message.Content.(type) {
+	case *messages.TextMessageContent:
+		return content.Text
+	case *messages.AgentMessageContent:
+		return content.AgentMessage.AltText
+	case *messages.PostMessageContent:
+		parts := []string{content.Title}
+		for _, row := range content.Content {
+			for _, node := range row {
+				switch v := node.(type) {
+				case *messages.RichTextNodeText:
+					parts = append(parts, v.Text)
+				case *messages.RichTextNodeLink:
+					parts = append(parts, v.Text)
+				}
+			}
+		}
+		return strings.TrimSpace(strings.Join(parts, " "))
+	case *messages.ImageMessageContent:
+		return content.Alt
+	case *messages.StickerMessageContent:
+		return content.Alt
+	default:
+		return ""
+	}
+}
+
+// RemoveFromSearch 记录删除后从索引中移除
+func RemoveFromSearch(metaStore *repositories.MetaStore, kind string, key string) {
+	metaStore.AfterCommit(func() {
+		if err := metaStore.Search.Delete(context.Background(), kind, key); err != nil {
+			log.Printf("从检索索引中移除 %s %s 失败: %v", kind, key, err)
+		}
+	})
+}
+
+// upsertDocument 在事务中调用时等到提交后再写入索引
+func upsertDocument(metaStore *repositories.MetaStore, doc *search.Document) {
+	metaStore.AfterCommit(func() {
+		if err := metaStore.Search.Upsert(context.Background(), doc); err != nil {
+			log.Printf("写入检索索引 %s %s 失败: %v", doc.Kind, doc.Key, err)
+		}
+	})
+}
+
+func userDocument(avatar *repositories.Avatar) *search.Document {
+	return &search.Document{
+		Kind:      search.KindUser,
+		Key:       avatar.Did,
+		Owner:     avatar.Did,
+		Text:      strings.TrimSpace(strings.Join([]string{avatar.Handle, avatar.DisplayName, avatar.Description}, " ")),
+		CreatedAt: avatar.CreatedAt,
+	}
+}
+
+func momentDocument(moment *repositories.Moment) *search.Document {
+	parts := []string{moment.Text}
+	for _, tag := range moment.Tags {
+		parts = append(parts, "#"+tag)
+	}
+	return &search.Document{
+		Kind:      search.KindMoment,
+		Key:       moment.URI,
+		Owner:     moment.Creator,
+		Text:      strings.Join(parts, " "),
+		CreatedAt: moment.CreatedAt,
+	}
+}
+
+// 标签和主题是共享的, 不记录创建者, 不受屏蔽关系影响
+func tagDocument(tag *repositories.Tag) *search.Document {
+	return &search.Document{
+		Kind:      search.KindTag,
+		Key:       tag.Tag,
+		Text:      tag.Tag,
+		CreatedAt: tag.CreatedAt,
+	}
+}
+
+func topicDocument(topic *repositories.Topic) *search.Document {
+	return &search.Document{
+		Kind:      search.KindTopic,
+		Key:       topic.Topic,
+		Text:      topic.Topic,
+		CreatedAt: topic.CreatedAt,
+	}
+}
+
+// messageDocument 消息的可见范围为所在房间, 没有可检索文本时返回 nil
+func messageDocument(message *messages.Message, text string) *search.Document {
+	if message == nil || strings.TrimSpace(text) == "" {
+		return nil
+	}
+	return &search.Document{
+		Kind:      search.KindMessage,
+		Key:       message.ID,
+		Scope:     message.RoomID,
+		Owner:     message.SenderID,
+		Text:      text,
+		CreatedAt: message.CreatedAt,
+	}
+}
+
+// agentReplyDocument AI 回复以所在的消息为键, 发送者取回复的创建者
+func agentReplyDocument(message *repositories.Message, creator string, text string) *search.Document {
+	owner := creator
+	if owner == "" {
+		owner = message.SenderID
+	}
+	return messageDocument(&messages.Message{
+		ID:        message.ID,
+		RoomID:    message.RoomID,
+		SenderID:  owner,
+		CreatedAt: message.CreatedAt,
+	}, text)
+}
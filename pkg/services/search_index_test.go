@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/search"
+)
+
+const (
+	searchUser = "did:plc:alice"
+	searchAI   = "did:plc:assistant"
+)
+
+// seedAgentChat 写入 extra 以及一条用户消息和对它的 AI 回复, 都在 room-1 中
+func seedAgentChat(t *testing.T, store *repositories.MetaStore, extra []*repositories.Message) {
+	t.Helper()
+	chat := append(extra,
+		NewMessageConverter(store.MessageRepo).MessageToDB(&messages.Message{
+			ID:       "u1",
+			RoomID:   "room-1",
+			MsgType:  messages.MessageTypeText,
+			Content:  &messages.TextMessageContent{Text: "我想去北京旅行"},
+			SenderID: searchUser,
+		}),
+		&repositories.Message{ID: "r1", RoomID: "room-1", MsgType: int(messages.MessageTypeAgent), SenderID: searchAI, ReceiverID: searchUser},
+	)
+	for _, message := range chat {
+		message.CreatedAt = 1000
+		if err := store.MessageRepo.InsertMessage(message); err != nil {
+			t.Fatalf("seed message %s: %v", message.ID, err)
+		}
+	}
+	if err := store.MessageRepo.InsertAgentMessage(&repositories.AgentMessage{ID: "am1", MessageID: "r1", AltText: "北京有很多景点", Creator: searchAI}); err != nil {
+		t.Fatalf("seed agent message: %v", err)
+	}
+}
+
+func searchMessages(t *testing.T, store *repositories.MetaStore, text string) map[string]*search.Hit {
+	t.Helper()
+	hits, err := store.Search.Search(context.Background(), &search.Query{Kind: search.KindMessage, Text: text, Limit: 500})
+	if err != nil {
+		t.Fatalf("search %q: %v", text, err)
+	}
+	result := make(map[string]*search.Hit, len(hits))
+	for _, hit := range hits {
+		result[hit.Key] = hit
+	}
+	return result
+}
+
+func TestIndexAgentReplyUsesReplyMessage(t *testing.T) {
+	store := newTestMetaStore(t)
+	seedAgentChat(t, store, nil)
+	user, err := store.MessageRepo.GetMessageByID("u1")
+	if err != nil {
+		t.Fatalf("get message: %v", err)
+	}
+	IndexMessage(store, NewMessageConverter(store.MessageRepo).DBToMessage(user))
+	IndexAgentReply(store, &messages.AgentMessage{ID: "am1", MessageID: "r1", AltText: "北京有很多景点", Creator: searchAI})
+
+	hits := searchMessages(t, store, "北京")
+	if len(hits) != 2 || hits["u1"] == nil || hits["r1"] == nil {
+		t.Fatalf("hits = %v, want u1 and r1", hits)
+	}
+	if hits["r1"].Owner != searchAI || hits["r1"].Scope != "room-1" || hits["r1"].Text != "北京有很多景点" {
+		t.Errorf("reply document = %+v, want owned by the assistant in room-1", hits["r1"])
+	}
+	if hits["u1"].Owner != searchUser || hits["u1"].Text != "我想去北京旅行" {
+		t.Errorf("user document = %+v, want the user's own text", hits["u1"])
+	}
+
+	// 指向用户消息的 AI 回复不覆盖用户消息的索引
+	IndexAgentReply(store, &messages.AgentMessage{ID: "am2", MessageID: "u1", AltText: "北京烤鸭", Creator: searchAI})
+	if hit := searchMessages(t, store, "北京")["u1"]; hit == nil || hit.Owner != searchUser || hit.Text != "我想去北京旅行" {
+		t.Errorf("user document after mismatched reply = %+v", hit)
+	}
+}
+
+func TestReindexIndexesAgentRepliesAndTies(t *testing.T) {
+	store := newTestMetaStore(t)
+	converter := NewMessageConverter(store.MessageRepo)
+	// 超过一批的消息在同一时间创建, 分批时不能遗漏排在后面的消息
+	var chat []*repositories.Message
+	for i := 0; i <= reindexBatchSize; i++ {
+		chat = append(chat, converter.MessageToDB(&messages.Message{
+			ID:       fmt.Sprintf("m%03d", i),
+			RoomID:   "room-2",
+			MsgType:  messages.MessageTypeText,
+			Content:  &messages.TextMessageContent{Text: fmt.Sprintf("消息 %d", i)},
+			SenderID: searchUser,
+		}))
+	}
+	seedAgentChat(t, store, chat)
+
+	total, err := NewSearchService(&config.SocialConfig{}, store).Reindex(context.Background())
+	if err != nil {
+		t.Fatalf("reindex: %v", err)
+	}
+	if want := reindexBatchSize + 3; total != want {
+		t.Fatalf("reindexed %d documents, want %d", total, want)
+	}
+	if count, err := store.Search.Count(context.Background()); err != nil || count != int64(reindexBatchSize+3) {
+		t.Fatalf("index count = %d, %v", count, err)
+	}
+	hits := searchMessages(t, store, "北京")
+	if reply := hits["r1"]; reply == nil || reply.Owner != searchAI || reply.Text != "北京有很多景点" {
+		t.Fatalf("reindexed reply = %+v, want owned by the assistant", reply)
+	}
+}
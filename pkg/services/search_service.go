@@ -0,0 +1,409 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/zhongshangwu/avatarai-social/pkg/communication/messages"
+	"github.com/zhongshangwu/avatarai-social/pkg/config"
+	"github.com/zhongshangwu/avatarai-social/pkg/repositories"
+	"github.com/zhongshangwu/avatarai-social/pkg/search"
+	"github.com/zhongshangwu/avatarai-social/types"
+)
+
+var (
+	ErrEmptySearchQuery  = errors.New("搜索内容不能为空")
+	ErrUnknownSearchType = errors.New("未知的搜索类型")
+)
+
+const (
+	// 高亮摘要最多保留的字符数
+	searchSnippetLength = 120
+	// 重建索引时每批写入的文档数
+	reindexBatchSize = 200
+)
+
+// SearchKinds 综合搜索依次返回的结果类型
+var SearchKinds = []string{search.KindMoment, search.KindUser, search.KindTag, search.KindTopic, search.KindMessage}
+
+type SearchQuery struct {
+	Viewer string
+	Text   string
+	Kind   string // 为空时检索全部类型, 每种类型返回第一页, 此时忽略游标
+	Limit  int
+	Cursor string
+}
+
+// SearchService 全文检索. 索引由各写入路径通过 IndexMoment、IndexUser、IndexMessage 等函数维护,
+// 检索时排除存在屏蔽关系的用户, 聊天消息只在 viewer 参与过的房间内检索
+type SearchService struct {
+	metaStore           *repositories.MetaStore
+	feedService         *FeedService
+	relationshipService *RelationshipService
+	converter           *MessageConverter
+}
+
+func NewSearchService(config *config.SocialConfig, metaStore *repositories.MetaStore) *SearchService {
+	return &SearchService{
+		metaStore:           metaStore,
+		feedService:         NewFeedService(config, metaStore),
+		relationshipService: NewRelationshipService(config, metaStore),
+		converter:           NewMessageConverter(metaStore.MessageRepo),
+	}
+}
+
+func (s *SearchService) Search(ctx context.Context, query *SearchQuery) (*types.SearchResults, error) {
+	if strings.TrimSpace(query.Text) == "" || len(search.QueryTokens(query.Text)) == 0 {
+		return nil, ErrEmptySearchQuery
+	}
+
+	kinds := SearchKinds
+	offset := 0
+	if query.Kind != "" {
+		if !isSearchKind(query.Kind) {
+			return nil, ErrUnknownSearchType
+		}
+		kinds = []string{query.Kind}
+		var err error
+		if offset, err = decodeSearchCursor(query.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	blocked, err := s.metaStore.GraphRepo.GetBlockedDIDs(query.Viewer)
+	if err != nil {
+		return nil, fmt.Errorf("获取屏蔽关系失败: %w", err)
+	}
+
+	results := &types.SearchResults{}
+	for _, kind := range kinds {
+		section, err := s.searchSection(ctx, kind, query, offset, blocked)
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case search.KindMoment:
+			results.Moments = section
+		case search.KindUser:
+			results.Users = section
+		case search.KindTag:
+			results.Tags = section
+		case search.KindTopic:
+			results.Topics = section
+		case search.KindMessage:
+			results.Messages = section
+		}
+	}
+	return results, nil
+}
+
+func (s *SearchService) searchSection(ctx context.Context, kind string, query *SearchQuery, offset int, blocked []string) (*types.SearchSection, error) {
+	section := &types.SearchSection{Hits: []*types.SearchHit{}}
+
+	indexQuery := &search.Query{
+		Kind:          kind,
+		Text:          query.Text,
+		ExcludeOwners: blocked,
+		Limit:         query.Limit,
+		Offset:        offset,
+	}
+	if kind == search.KindMessage {
+		if query.Viewer == "" {
+			return section, nil
+		}
+		roomIDs, err := s.metaStore.MessageRepo.ListRoomIDsByMember(query.Viewer)
+		if err != nil {
+			return nil, fmt.Errorf("获取用户的房间失败: %w", err)
+		}
+		if len(roomIDs) == 0 {
+			return section, nil
+		}
+		indexQuery.Scopes = roomIDs
+	}
+
+	hits, err := s.metaStore.Search.Search(ctx, indexQuery)
+	if err != nil {
+		return nil, fmt.Errorf("检索%s失败: %w", kind, err)
+	}
+	if query.Limit > 0 && len(hits) >= query.Limit {
+		section.Cursor = encodeSearchCursor(offset + len(hits))
+	}
+
+	switch kind {
+	case search.KindMoment:
+		err = s.presentMoments(ctx, query, hits, section)
+	case search.KindUser:
+		err = s.presentUsers(query, hits, section)
+	case search.KindMessage:
+		err = s.presentMessages(query, hits, section)
+	default:
+		for _, hit := range hits {
+			section.Hits = append(section.Hits, newSearchHit(hit, query.Text))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return section, nil
+}
+
+// presentMoments 补全 moment 卡片, 已删除或不可见的 moment 不返回
+func (s *SearchService) presentMoments(ctx context.Context, query *SearchQuery, hits []*search.Hit, section *types.SearchSection) error {
+	uris := make([]string, len(hits))
+	for i, hit := range hits {
+		uris[i] = hit.Key
+	}
+	hydrationState, err := s.feedService.hydrate(ctx, query.Viewer, uris)
+	if err != nil {
+		return err
+	}
+	cards := make(map[string]*types.FeedCard, len(uris))
+	for _, card := range s.feedService.presentCards(uris, hydrationState) {
+		if moment, ok := card.Card.(*types.MomentCard); ok {
+			cards[moment.URI] = card
+		}
+	}
+	for _, hit := range hits {
+		card, ok := cards[hit.Key]
+		if !ok {
+			continue
+		}
+		searchHit := newSearchHit(hit, query.Text)
+		searchHit.Moment = card
+		section.Hits = append(section.Hits, searchHit)
+	}
+	return nil
+}
+
+func (s *SearchService) presentUsers(query *SearchQuery, hits []*search.Hit, section *types.SearchSection) error {
+	dids := make([]string, len(hits))
+	for i, hit := range hits {
+		dids[i] = hit.Key
+	}
+	views, err := s.relationshipService.UserViews(dids)
+	if err != nil {
+		return err
+	}
+	for _, hit := range hits {
+		searchHit := newSearchHit(hit, query.Text)
+		searchHit.User = views[hit.Key]
+		section.Hits = append(section.Hits, searchHit)
+	}
+	return nil
+}
+
+// presentMessages 补全消息所在的话题, 已撤回和等待审核的消息不返回
+func (s *SearchService) presentMessages(query *SearchQuery, hits []*search.Hit, section *types.SearchSection) error {
+	ids := make([]string, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.Key
+	}
+	dbMessages, err := s.metaStore.MessageRepo.GetMessagesByIDs(ids)
+	if err != nil {
+		return fmt.Errorf("获取消息失败: %w", err)
+	}
+	dbMessages, err = VisibleMessages(s.metaStore, dbMessages, query.Viewer)
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]*repositories.Message, len(dbMessages))
+	for _, message := range dbMessages {
+		byID[message.ID] = message
+	}
+	for _, hit := range hits {
+		message, ok := byID[hit.Key]
+		if !ok {
+			continue
+		}
+		searchHit := newSearchHit(hit, query.Text)
+		searchHit.Message = &types.MessageRef{
+			ID:        message.ID,
+			RoomID:    message.RoomID,
+			ThreadID:  message.ThreadID,
+			SenderID:  message.SenderID,
+			CreatedAt: message.CreatedAt,
+		}
+		section.Hits = append(section.Hits, searchHit)
+	}
+	return nil
+}
+
+// Reindex 从数据库重建全部索引, 返回写入的文档数. 用于首次启用检索或更换索引后端后的回填
+func (s *SearchService) Reindex(ctx context.Context) (int, error) {
+	total := 0
+	var batch []*search.Document
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := s.metaStore.Search.Upsert(ctx, batch...); err != nil {
+			return fmt.Errorf("写入检索索引失败: %w", err)
+		}
+		total += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	add := func(doc *search.Document) error {
+		batch = append(batch, doc)
+		if len(batch) >= reindexBatchSize {
+			return flush()
+		}
+		return nil
+	}
+
+	var afterID uint
+	for {
+		avatars, err := s.metaStore.UserRepo.ListAvatars(afterID, reindexBatchSize)
+		if err != nil {
+			return total, fmt.Errorf("读取用户失败: %w", err)
+		}
+		for _, avatar := range avatars {
+			if err := add(userDocument(avatar)); err != nil {
+				return total, err
+			}
+			afterID = avatar.ID
+		}
+		if len(avatars) < reindexBatchSize {
+			break
+		}
+	}
+
+	var before *repositories.FeedKey
+	for {
+		moments, err := s.metaStore.MomentRepo.GetLatestMoments(reindexBatchSize, before)
+		if err != nil {
+			return total, fmt.Errorf("读取 moment 失败: %w", err)
+		}
+		for _, moment := range moments {
+			if err := add(momentDocument(moment)); err != nil {
+				return total, err
+			}
+			before = &repositories.FeedKey{IndexedAt: moment.IndexedAt, ID: moment.ID}
+		}
+		if len(moments) < reindexBatchSize {
+			break
+		}
+	}
+
+	for page := 1; ; page++ {
+		tags, err := s.metaStore.ActivityRepo.ListTags(page, reindexBatchSize)
+		if err != nil {
+			return total, fmt.Errorf("读取标签失败: %w", err)
+		}
+		for _, tag := range tags {
+			if err := add(tagDocument(tag)); err != nil {
+				return total, err
+			}
+		}
+		if len(tags) < reindexBatchSize {
+			break
+		}
+	}
+
+	for page := 1; ; page++ {
+		topics, err := s.metaStore.ActivityRepo.ListTopics(page, reindexBatchSize)
+		if err != nil {
+			return total, fmt.Errorf("读取主题失败: %w", err)
+		}
+		for _, topic := range topics {
+			if err := add(topicDocument(topic)); err != nil {
+				return total, err
+			}
+		}
+		if len(topics) < reindexBatchSize {
+			break
+		}
+	}
+
+	var after *repositories.MessageKey
+	for {
+		dbMessages, err := s.metaStore.MessageRepo.ListMessagesForIndex(after, reindexBatchSize)
+		if err != nil {
+			return total, fmt.Errorf("读取消息失败: %w", err)
+		}
+		// AI 回复的文本保存在 agent_messages 中, 按批读取
+		var agentMessageIDs []string
+		for _, dbMessage := range dbMessages {
+			if dbMessage.MsgType == int(messages.MessageTypeAgent) {
+				agentMessageIDs = append(agentMessageIDs, dbMessage.ID)
+			}
+		}
+		agentMessages, err := s.metaStore.MessageRepo.GetAgentMessagesByMessageIDs(agentMessageIDs)
+		if err != nil {
+			return total, fmt.Errorf("读取 AI 回复失败: %w", err)
+		}
+		for _, dbMessage := range dbMessages {
+			after = &repositories.MessageKey{CreatedAt: dbMessage.CreatedAt, ID: dbMessage.ID}
+			var doc *search.Document
+			if dbMessage.MsgType == int(messages.MessageTypeAgent) {
+				if agentMessage := agentMessages[dbMessage.ID]; agentMessage != nil {
+					doc = agentReplyDocument(dbMessage, agentMessage.Creator, agentMessage.AltText)
+				}
+			} else {
+				message := s.converter.DBToMessage(dbMessage)
+				doc = messageDocument(message, MessageText(message))
+			}
+			if doc != nil {
+				if err := add(doc); err != nil {
+					return total, err
+				}
+			}
+		}
+		if len(dbMessages) < reindexBatchSize {
+			break
+		}
+	}
+
+	if err := flush(); err != nil {
+		return total, err
+	}
+	log.Printf("检索索引重建完成, 共 %d 条文档", total)
+	return total, nil
+}
+
+func newSearchHit(hit *search.Hit, query string) *types.SearchHit {
+	return &types.SearchHit{
+		Key:       hit.Key,
+		Highlight: search.Highlight(hit.Text, query, searchSnippetLength),
+		Score:     hit.Score,
+		CreatedAt: hit.CreatedAt,
+	}
+}
+
+func isSearchKind(kind string) bool {
+	for _, k := range SearchKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// 搜索游标对客户端不透明, 内容为 "s:<偏移量>" 的 base64url 编码
+func encodeSearchCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("s:" + strconv.Itoa(offset)))
+}
+
+func decodeSearchCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	value, ok := strings.CutPrefix(string(raw), "s:")
+	if !ok {
+		return 0, ErrInvalidCursor
+	}
+	offset, err := strconv.Atoi(value)
+	if err != nil || offset < 0 {
+		return 0, ErrInvalidCursor
+	}
+	return offset, nil
+}
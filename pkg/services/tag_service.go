@@ -26,16 +26,28 @@ func (s *TagService) CreateOrGetTag(ctx context.Context, tag string, creator str
 	}
 
 	// 如果标签不存在，创建新标签
+	id := helper.GenerateTID()
 	newTag := &repositories.Tag{
-		ID:        helper.GenerateTID(),
+		ID:        id,
+		URI:       fmt.Sprintf("at://%s/app.vtri.activity.tag/%s", creator, id),
 		Tag:       tag,
 		CreatedAt: time.Now().Unix(),
 		Creator:   creator,
 		Deleted:   false,
 	}
 
-	if err := s.metaStore.MomentRepo.CreateTag(newTag); err != nil {
-		return nil, fmt.Errorf("创建标签失败: %w", err)
+	err = s.metaStore.WithTransaction(ctx, func(txStore *repositories.MetaStore) error {
+		if err := txStore.MomentRepo.CreateTag(newTag); err != nil {
+			return fmt.Errorf("创建标签失败: %w", err)
+		}
+		if err := enqueueRecordOp(txStore, newTag.URI, repositories.OutboxActionCreate); err != nil {
+			return err
+		}
+		IndexTag(txStore, newTag)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return newTag, nil
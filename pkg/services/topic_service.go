@@ -26,16 +26,28 @@ func (s *TopicService) CreateOrGetTopic(ctx context.Context, topic string, creat
 	}
 
 	// 如果主题不存在，创建新主题
+	id := helper.GenerateTID()
 	newTopic := &repositories.Topic{
-		ID:        helper.GenerateTID(),
+		ID:        id,
+		URI:       fmt.Sprintf("at://%s/app.vtri.activity.topic/%s", creator, id),
 		Topic:     topic,
 		CreatedAt: time.Now().Unix(),
 		Creator:   creator,
 		Deleted:   false,
 	}
 
-	if err := s.metaStore.MomentRepo.CreateTopic(newTopic); err != nil {
-		return nil, fmt.Errorf("创建主题失败: %w", err)
+	err = s.metaStore.WithTransaction(ctx, func(txStore *repositories.MetaStore) error {
+		if err := txStore.MomentRepo.CreateTopic(newTopic); err != nil {
+			return fmt.Errorf("创建主题失败: %w", err)
+		}
+		if err := enqueueRecordOp(txStore, newTopic.URI, repositories.OutboxActionCreate); err != nil {
+			return err
+		}
+		IndexTopic(txStore, newTopic)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return newTopic, nil
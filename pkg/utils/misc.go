@@ -36,3 +36,17 @@ func FormatTime(t int64) string {
 func Timestamp() int64 {
 	return time.Now().Unix()
 }
+
+// FormatMilliTime 毫秒时间戳转为记录中使用的 datetime 字符串
+func FormatMilliTime(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format(time.RFC3339Nano)
+}
+
+// ParseMilliTime 解析记录中的 datetime 字符串为毫秒时间戳
+func ParseMilliTime(s string) (int64, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, err
+	}
+	return t.UnixMilli(), nil
+}
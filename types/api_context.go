@@ -32,6 +32,14 @@ func (c *APIContext) IsAster() bool {
 	return c.User.IsAster
 }
 
+// ViewerDid 当前登录用户的 DID, 未登录时为空
+func (c *APIContext) ViewerDid() string {
+	if c.User == nil {
+		return ""
+	}
+	return c.User.Did
+}
+
 func (c *APIContext) IsUser() bool {
 	if c.User == nil {
 		return false
@@ -11,8 +11,15 @@ const (
 )
 
 type Feeds struct {
-	Cursor string      `json:"cursor"`
-	Feed   []*FeedCard `json:"feed"`
+	Cursor string                       `json:"cursor"`
+	Feed   []*FeedCard                  `json:"feed"`
+	Debug  map[string]*ScoreExplanation `json:"debug,omitempty"` // 排序 feed 在 debug 模式下按 URI 返回得分明细
+}
+
+type ScoreExplanation struct {
+	Score      float64            `json:"score"`
+	Components map[string]float64 `json:"components"`
+	Sources    []string           `json:"sources"`
 }
 
 type MomentThread struct {
@@ -45,6 +52,7 @@ type MomentCard struct {
 	CreatedAt  int64                         `json:"createdAt"`
 	UpdatedAt  int64                         `json:"updatedAt"`
 	Author     *SimpleUserView               `json:"author"`
+	Labels     []*LabelView                  `json:"labels,omitempty"`
 }
 
 func (c *MomentCard) CardType() ActivityCardType {
@@ -52,11 +60,18 @@ func (c *MomentCard) CardType() ActivityCardType {
 }
 
 type SimpleUserView struct {
-	Did         string `json:"did"`
-	Handle      string `json:"handle"`
-	DisplayName string `json:"displayName"`
-	Avatar      string `json:"avatar,omitempty"`
-	CreatedAt   int64  `json:"createdAt"`
+	Did            string `json:"did"`
+	Handle         string `json:"handle"`
+	DisplayName    string `json:"displayName"`
+	Avatar         string `json:"avatar,omitempty"`
+	FollowersCount int64  `json:"followersCount"`
+	FollowingCount int64  `json:"followingCount"`
+	CreatedAt      int64  `json:"createdAt"`
+}
+
+type UserList struct {
+	Cursor string            `json:"cursor"`
+	Users  []*SimpleUserView `json:"users"`
 }
 
 type EmbedView struct {
@@ -0,0 +1,38 @@
+package types
+
+// LabelView 内容上的标签, Src 为空表示本服务的审核结论, 以 ! 开头的为系统标签
+type LabelView struct {
+	Src       string `json:"src,omitempty"`
+	Val       string `json:"val"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+type ModerationFinding struct {
+	Checker string `json:"checker"`
+	Verdict string `json:"verdict"`
+	Label   string `json:"label,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+// ModerationItem 审核队列中的一条记录
+type ModerationItem struct {
+	ID          uint                 `json:"id"`
+	SubjectType string               `json:"subjectType"`
+	Subject     string               `json:"subject"`
+	Author      string               `json:"author"`
+	Text        string               `json:"text"`
+	Verdict     string               `json:"verdict"`
+	Labels      []string             `json:"labels"`
+	Findings    []*ModerationFinding `json:"findings"`
+	Status      string               `json:"status"`
+	Reviewer    string               `json:"reviewer,omitempty"`
+	ReviewNote  string               `json:"reviewNote,omitempty"`
+	ReviewedAt  int64                `json:"reviewedAt,omitempty"`
+	CreatedAt   int64                `json:"createdAt"`
+}
+
+type ModerationQueue struct {
+	Cursor  string            `json:"cursor"`
+	Pending int64             `json:"pending"` // 等待审核的总数
+	Items   []*ModerationItem `json:"items"`
+}
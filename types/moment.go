@@ -22,6 +22,7 @@ type Moment struct {
 	IndexedAt  int64                         `json:"indexedAt"`
 	CreatedBy  string                        `json:"createdBy"`
 	Deleted    bool                          `json:"deleted"`
+	Labels     []string                      `json:"labels,omitempty"` // 审核结论的标签, 只在创建时返回
 }
 
 type MomentRelyRef struct {
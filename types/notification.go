@@ -0,0 +1,20 @@
+package types
+
+// Notification 通知列表中的一项. 同一页内对同一 moment 的多次点赞合并为一项,
+// IDs 为合并的全部通知 ID, Authors 为最近的几位点赞者
+type Notification struct {
+	IDs           []uint            `json:"ids"`
+	Reason        string            `json:"reason"` // like, reply, mention, follow
+	ReasonSubject string            `json:"reasonSubject,omitempty"`
+	URI           string            `json:"uri"` // 产生通知的记录
+	Author        *SimpleUserView   `json:"author"`
+	Authors       []*SimpleUserView `json:"authors,omitempty"`
+	Count         int               `json:"count"`
+	IsRead        bool              `json:"isRead"`
+	CreatedAt     int64             `json:"createdAt"`
+}
+
+type NotificationList struct {
+	Cursor        string          `json:"cursor"`
+	Notifications []*Notification `json:"notifications"`
+}
@@ -0,0 +1,60 @@
+package types
+
+// SearchResults 综合搜索结果, 按类型分区返回, 未请求或没有命中的分区为空
+type SearchResults struct {
+	Moments  *SearchSection `json:"moments,omitempty"`
+	Users    *SearchSection `json:"users,omitempty"`
+	Tags     *SearchSection `json:"tags,omitempty"`
+	Topics   *SearchSection `json:"topics,omitempty"`
+	Messages *SearchSection `json:"messages,omitempty"`
+}
+
+// SearchSection 一种类型的命中结果, Cursor 为空表示没有更多结果
+type SearchSection struct {
+	Cursor string       `json:"cursor"`
+	Hits   []*SearchHit `json:"hits"`
+}
+
+// SearchHit 一条命中. Highlight 为经过 HTML 转义的摘要, 命中片段以 <mark> 标记;
+// 按类型填充 Moment、User、Message 之一, 标签和主题只有 Key
+type SearchHit struct {
+	Key       string          `json:"key"`
+	Highlight string          `json:"highlight"`
+	Score     float64         `json:"score"`
+	CreatedAt int64           `json:"createdAt"`
+	Moment    *FeedCard       `json:"moment,omitempty"`
+	User      *SimpleUserView `json:"user,omitempty"`
+	Message   *MessageRef     `json:"message,omitempty"`
+}
+
+type MessageRef struct {
+	ID        string `json:"id"`
+	RoomID    string `json:"roomId"`
+	ThreadID  string `json:"threadId"`
+	SenderID  string `json:"senderId"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+// HistorySearchResults 用户聊天记录的混合检索结果, 按融合得分排序
+type HistorySearchResults struct {
+	Hits []*HistorySearchHit `json:"hits"`
+}
+
+// HistoryItemType 命中的是用户发送的消息还是 AI 的回复
+type HistoryItemType string
+
+const (
+	HistoryItemUserMessage HistoryItemType = "userMessage"
+	HistoryItemAgentReply  HistoryItemType = "agentReply"
+)
+
+// HistorySearchHit KeywordRank、VectorRank 为消息在关键词检索和语义检索中的名次, 0 表示该路未命中
+type HistorySearchHit struct {
+	Message     *MessageRef     `json:"message"`
+	ItemType    HistoryItemType `json:"itemType"`
+	Text        string          `json:"text"`
+	Highlight   string          `json:"highlight"`
+	Score       float64         `json:"score"`
+	KeywordRank int             `json:"keywordRank,omitempty"`
+	VectorRank  int             `json:"vectorRank,omitempty"`
+}